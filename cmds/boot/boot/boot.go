@@ -8,7 +8,7 @@
 
 //
 // Synopsis:
-//	boot [-v][-no-load][-no-exec]
+//	boot [-v][-no-load][-no-exec][-uki-db FILE][-fb DEVICE]
 //
 // Description:
 //	If returns to u-root shell, the code didn't found a local bootable option
@@ -16,11 +16,17 @@
 //      -v prints messages
 //      -no-load prints the boot image paths it was going to load, but doesn't load + exec them
 //      -no-exec loads the boot image, but doesn't exec it
+//      -uki-db only boots unified kernel images signed with the PEM or DER
+//              certificates in FILE
+//      -fb shows the boot menu on the framebuffer DEVICE, e.g. /dev/fb0,
+//          with input from the keyboards, rather than on the terminal
 //
 // Notes:
 //	The code is looking for boot/grub/grub.cfg file as to identify the
 //	boot option.
 //	The first bootable device found in the block device tree is the one used
+//	File systems the kernel cannot mount are read directly if they are
+//	ext2/3/4 or FAT, for BootLoaderSpec entries only
 //	Windows is not supported (that is a work in progress)
 //
 // Example:
@@ -29,11 +35,16 @@
 package main
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"flag"
+	"fmt"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/bls"
 	"github.com/u-root/u-root/pkg/boot/bootcmd"
 	"github.com/u-root/u-root/pkg/boot/localboot"
 	"github.com/u-root/u-root/pkg/boot/menu"
@@ -52,8 +63,41 @@ var (
 	reuseCmdlineItem  = flag.String("reuse", "console", "comma separated list of kernel params value to reuse from current kernel (default to console)")
 	appendCmdline     = flag.String("append", "", "Additional kernel params")
 	blockList         = flag.String("block", "", "comma separated list of pci vendor and device ids to ignore (format vendor:device). E.g. 0x8086:0x1234,0x8086:0xabcd")
+	ukiDB             = flag.String("uki-db", "", "PEM or DER file with the certificates unified kernel images have to be Authenticode signed with")
+	framebuffer       = flag.String("fb", "", "framebuffer device, e.g. /dev/fb0, to show the boot menu on, with input from the keyboards")
 )
 
+// loadCertificates reads PEM or DER certificates from a file.
+func loadCertificates(name string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(string(b), "-----BEGIN") {
+		return x509.ParseCertificates(b)
+	}
+	var certs []*x509.Certificate
+	for {
+		var p *pem.Block
+		p, b = pem.Decode(b)
+		if p == nil {
+			break
+		}
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates in %s", name)
+	}
+	return certs, nil
+}
+
 // updateBootCmdline get the kernel command line parameters and filter it:
 // it removes parameters listed in 'remove' and append extra parameters from
 // the 'append' and 'reuse' flags
@@ -84,6 +128,14 @@ func main() {
 		}
 	}
 
+	var blsOpts bls.Options
+	if *ukiDB != "" {
+		blsOpts.DB, err = loadCertificates(*ukiDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	log.Printf("Booting from the following block devices: %v", blockDevs)
 
 	var l ulog.Logger = ulog.Null
@@ -91,7 +143,7 @@ func main() {
 		l = ulog.Log
 	}
 	mountPool := &mount.Pool{}
-	images, err := localboot.Localboot(l, blockDevs, mountPool)
+	images, err := localboot.Localboot(l, blockDevs, mountPool, &blsOpts)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -107,5 +159,5 @@ func main() {
 	menuEntries = append(menuEntries, menu.StartShell{})
 
 	// Boot does not return.
-	bootcmd.ShowMenuAndBoot(menuEntries, mountPool, *noLoad, *noExec)
+	bootcmd.ShowMenuAndBoot(menuEntries, mountPool, *noLoad, *noExec, *framebuffer)
 }
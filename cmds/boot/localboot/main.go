@@ -15,6 +15,8 @@ import (
 	"github.com/u-root/u-root/pkg/boot/jsonboot"
 	"github.com/u-root/u-root/pkg/mount"
 	"github.com/u-root/u-root/pkg/mount/block"
+	"github.com/u-root/u-root/pkg/mount/lvm"
+	"github.com/u-root/u-root/pkg/mount/md"
 )
 
 // TODO backward compatibility for BIOS mode with partition type 0xee
@@ -30,6 +32,8 @@ var (
 	flagInitramfsPath  = flag.String("initramfs", "", "Specify the path of the initramfs to load. If using -grub, this argument is ignored")
 	flagKernelCmdline  = flag.String("cmdline", "", "Specify the kernel command line. If using -grub, this argument is ignored")
 	flagDeviceGUID     = flag.String("guid", "", "GUID of the device where the kernel (and optionally initramfs) are located. Ignored if -grub is set or if -kernel is not specified")
+	flagMD             = flag.Bool("md", false, "Assemble md RAID arrays before looking for boot configurations")
+	flagLVM            = flag.Bool("lvm", false, "Activate LVM2 logical volumes before looking for boot configurations")
 )
 
 var debug = func(string, ...interface{}) {}
@@ -191,6 +195,24 @@ func BootPathMode(devices block.BlockDevices, baseMountpoint string, guid string
 	return nil
 }
 
+// activateLVM activates all logical volumes, so they are found as block
+// devices. Failures are logged: the boot configuration may not be on LVM.
+func activateLVM() {
+	lvm.Debug = debug
+	vgs, err := lvm.Scan()
+	if err != nil {
+		log.Printf("LVM scan failed: %v", err)
+		return
+	}
+	for _, vg := range vgs {
+		paths, err := vg.ActivateAll()
+		if err != nil {
+			log.Printf("Activating volume group %s: %v", vg.Name, err)
+		}
+		debug("Activated logical volumes of %s: %v", vg.Name, paths)
+	}
+}
+
 func main() {
 	flag.Parse()
 	if *flagGrubMode && *flagKernelPath != "" {
@@ -200,6 +222,19 @@ func main() {
 		debug = log.Printf
 	}
 
+	// Arrays may hold LVM physical volumes.
+	if *flagMD {
+		md.Debug = debug
+		paths, err := md.AssembleAll()
+		if err != nil {
+			log.Printf("Assembling md arrays: %v", err)
+		}
+		debug("Assembled md arrays: %v", paths)
+	}
+	if *flagLVM {
+		activateLVM()
+	}
+
 	// Get all the available block devices
 	devices, err := block.GetBlockDevices()
 	if err != nil {
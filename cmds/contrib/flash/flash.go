@@ -5,7 +5,8 @@
 // flash reads and writes to a flash chip.
 //
 // Synopsis:
-//     flash -p PROGRAMMER[:parameter[,parameter[...]]] [-r FILE|-w FILE]
+//     flash -p PROGRAMMER[:parameter[,parameter[...]]] [--region NAME|--fmap-area NAME] [-r FILE|-w FILE|-v FILE|-E]
+//     flash -p PROGRAMMER[:parameter[,parameter[...]]] [--wp-disable] [--wp-range START,LEN] [--wp-enable] [--wp-status] [--otp-lock] [--otp-status]
 //
 // Options:
 //     -p PROGRAMMER: Specify the programmer with zero or more parameters (see
@@ -14,6 +15,29 @@
 //     -w FILE: Write the file to the flash chip. First, the flash chip is read
 //              and then diffed against the file. The differing blocks are
 //              erased and written. Finally, the contents are verified.
+//     -v FILE: Verify the flash chip contains the file.
+//     -E: Erase the flash chip.
+//     --region NAME: Only read or write the region of the Intel flash
+//                    descriptor, such as bios or me. The file has the size
+//                    of the region.
+//     --fmap-area NAME: Only read or write the area of the FMAP, such as
+//                       RW_VPD. The file has the size of the area.
+//
+// Write protection:
+//     These options use the block protect bits of the status register of the
+//     flash chip, so they need a programmer which sends opcodes. They are
+//     applied in the order below.
+//
+//     --wp-disable: Clear the block protect bits and the status register
+//                   write disable bit.
+//     --wp-range START,LEN: Set the block protect bits to protect LEN bytes
+//                           at START. Only ranges at the top of the chip are
+//                           supported.
+//     --wp-enable: Set the status register write disable bit, so the status
+//                  register cannot be written while the WP# pin is asserted.
+//     --otp-lock: Lock down the secured OTP area. This cannot be undone.
+//     --wp-status: Print the block protect bits and the protected range.
+//     --otp-status: Print the lock bits of the secured OTP area.
 //
 // Programmers:
 //     dummy
@@ -22,6 +46,16 @@
 //       dummy:image=image.rom
 //         File to memmap for the memory buffer.
 //
+//     internal
+//       Use the SPI controller of the chipset: Intel ICH9 and later, and AMD
+//       FCH with the SPI100 controller. This is only supported on Linux and
+//       needs /dev/mem. Ranges protected by the chipset cannot be written.
+//
+//       internal:ich_spi_mode=hwseq
+//         On Intel, use hardware sequencing, which needs a flash descriptor.
+//         This is the default. With swseq, use software sequencing, which
+//         sends the opcodes of the opcode menu to the flash chip.
+//
 //     linux_spi:dev=/dev/spidev0.0
 //       Use Linux's spidev driver. This is only supported on Linux. The dev
 //       parameter is required.
@@ -41,18 +75,134 @@ import (
 	"log"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 
 	flag "github.com/spf13/pflag"
+	"github.com/u-root/u-root/pkg/flash"
+	"github.com/u-root/u-root/pkg/flash/fmap"
+	"github.com/u-root/u-root/pkg/flash/ifd"
 )
 
 type programmer interface {
-	io.ReaderAt
-	io.WriterAt
+	flash.Eraser
 	Size() int64
 	Close() error
 }
 
+// protector is a programmer with access to the status and security
+// registers of the flash chip, such as flash.Flash.
+type protector interface {
+	BlockProtect() (int, bool, error)
+	SetBlockProtect(level int, srwd bool) error
+	ProtectedRange(level int) (int64, int64)
+	ProtectLevel(off int64, n int64) (int, error)
+	ReadSecurity() (byte, error)
+	LockOTP() error
+}
+
+// wrapper is a programmer which wraps another one.
+type wrapper interface {
+	Unwrap() programmer
+}
+
+// wpOptions are the write protect options.
+type wpOptions struct {
+	status, enable, disable bool
+	rng                     string
+	otpStatus, otpLock      bool
+}
+
+func (o *wpOptions) set() bool {
+	return o.status || o.enable || o.disable || o.rng != "" || o.otpStatus || o.otpLock
+}
+
+// parseRange parses START,LEN.
+func parseRange(s string) (int64, int64, error) {
+	i := strings.IndexByte(s, ',')
+	if i == -1 {
+		return 0, 0, fmt.Errorf("invalid range %q, want START,LEN", s)
+	}
+	start, err := strconv.ParseInt(s[:i], 0, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseInt(s[i+1:], 0, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, n, nil
+}
+
+// writeProtect runs the write protect options.
+func writeProtect(w io.Writer, p programmer, o *wpOptions) error {
+	if u, ok := p.(wrapper); ok {
+		p = u.Unwrap()
+	}
+	chip, ok := p.(protector)
+	if !ok {
+		return errors.New("the programmer cannot access the status register of the flash chip")
+	}
+	if o.disable {
+		if err := chip.SetBlockProtect(0, false); err != nil {
+			return err
+		}
+	}
+	if o.rng != "" {
+		start, n, err := parseRange(o.rng)
+		if err != nil {
+			return err
+		}
+		level, err := chip.ProtectLevel(start, n)
+		if err != nil {
+			return err
+		}
+		_, srwd, err := chip.BlockProtect()
+		if err != nil {
+			return err
+		}
+		if err := chip.SetBlockProtect(level, srwd); err != nil {
+			return err
+		}
+	}
+	if o.enable {
+		level, _, err := chip.BlockProtect()
+		if err != nil {
+			return err
+		}
+		if err := chip.SetBlockProtect(level, true); err != nil {
+			return err
+		}
+	}
+	if o.otpLock {
+		if err := chip.LockOTP(); err != nil {
+			return err
+		}
+	}
+	if o.status {
+		level, srwd, err := chip.BlockProtect()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Block protect level: %d\n", level)
+		if off, n := chip.ProtectedRange(level); n == 0 {
+			fmt.Fprintf(w, "Protected range: none\n")
+		} else {
+			fmt.Fprintf(w, "Protected range: %#x-%#x (%#x bytes)\n", off, off+n-1, n)
+		}
+		fmt.Fprintf(w, "Status register write disable: %v\n", srwd)
+	}
+	if o.otpStatus {
+		s, err := chip.ReadSecurity()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "OTP factory locked: %v\n", s&flash.SecurityFactoryOTP != 0)
+		fmt.Fprintf(w, "OTP locked down: %v\n", s&flash.SecurityLockedOTP != 0)
+	}
+	return nil
+}
+
 type (
 	programmerParams map[string]string
 	programmerInit   func(programmerParams) (programmer, error)
@@ -79,7 +229,7 @@ func parseProgrammerParams(arg string) (string, map[string]string) {
 	return arg[:colon], params
 }
 
-func run(args []string, supportedProgrammers map[string]programmerInit) (reterr error) {
+func run(stdout io.Writer, args []string, supportedProgrammers map[string]programmerInit) (reterr error) {
 	// Make a human readable list of supported programmers.
 	programmerList := []string{}
 	for k := range supportedProgrammers {
@@ -93,7 +243,20 @@ func run(args []string, supportedProgrammers map[string]programmerInit) (reterr
 		p = fs.StringP("programmer", "p", "", fmt.Sprintf("programmer (%s)", strings.Join(programmerList, ",")))
 		r = fs.StringP("read", "r", "", "read flash data into the file")
 		w = fs.StringP("write", "w", "", "write the file to flash")
+		v = fs.StringP("verify", "v", "", "verify flash against the file")
+		e = fs.BoolP("erase", "E", false, "erase flash")
+
+		region   = fs.String("region", "", "only read or write this region of the Intel flash descriptor")
+		fmapArea = fs.String("fmap-area", "", "only read or write this area of the FMAP")
+
+		wp wpOptions
 	)
+	fs.BoolVar(&wp.status, "wp-status", false, "print the write protect status")
+	fs.BoolVar(&wp.enable, "wp-enable", false, "set the status register write disable bit")
+	fs.BoolVar(&wp.disable, "wp-disable", false, "clear the block protect bits and the status register write disable bit")
+	fs.StringVar(&wp.rng, "wp-range", "", "protect the range START,LEN with the block protect bits")
+	fs.BoolVar(&wp.otpStatus, "otp-status", false, "print the lock bits of the secured OTP area")
+	fs.BoolVar(&wp.otpLock, "otp-lock", false, "lock down the secured OTP area")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -106,11 +269,23 @@ func run(args []string, supportedProgrammers map[string]programmerInit) (reterr
 		return errors.New("-p needs to be set")
 	}
 
-	if *r == "" && *w == "" {
-		return errors.New("either -r or -w need to be set")
+	ops := 0
+	for _, set := range []bool{*r != "", *w != "", *v != "", *e, wp.set()} {
+		if set {
+			ops++
+		}
+	}
+	if ops == 0 {
+		return errors.New("one of -r, -w, -v, -E or the write protect options needs to be set")
+	}
+	if ops > 1 {
+		return errors.New("only one of -r, -w, -v, -E or the write protect options can be set")
+	}
+	if wp.enable && wp.disable {
+		return errors.New("both --wp-enable and --wp-disable cannot be set")
 	}
-	if *r != "" && *w != "" {
-		return errors.New("both -r and -w cannot be set")
+	if *region != "" && *fmapArea != "" {
+		return errors.New("both --region and --fmap-area cannot be set")
 	}
 
 	programmerName, params := parseProgrammerParams(*p)
@@ -130,8 +305,48 @@ func run(args []string, supportedProgrammers map[string]programmerInit) (reterr
 		}
 	}()
 
+	if wp.set() {
+		return writeProtect(stdout, programmer, &wp)
+	}
+
+	// off, size and what describe the part of the flash to read or write.
+	off, size, what := int64(0), programmer.Size(), "flash"
+	switch {
+	case *region != "":
+		d, err := ifd.Read(programmer)
+		if err != nil {
+			return err
+		}
+		reg, err := d.Region(*region)
+		if err != nil {
+			return err
+		}
+		off, size, what = reg.Base, reg.Size(), fmt.Sprintf("region %q", reg.Name)
+	case *fmapArea != "":
+		f, _, err := fmap.Read(programmer, programmer.Size())
+		if err != nil {
+			return err
+		}
+		a, err := f.Area(*fmapArea)
+		if err != nil {
+			return err
+		}
+		off, size, what = int64(a.Offset), int64(a.Size), fmt.Sprintf("area %q", a.Name)
+	}
+	if off+size > programmer.Size() {
+		return fmt.Errorf("%s at %#x of %#x bytes is beyond the flash size (%#x)", what, off, size, programmer.Size())
+	}
+
+	if *e {
+		if _, err := programmer.EraseAt(size, off); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Erased %s\n", what)
+		return nil
+	}
+
 	// Create a buffer to hold the contents of the image.
-	buf := make([]byte, programmer.Size())
+	buf := make([]byte, size)
 
 	if *r != "" {
 		f, err := os.Create(*r)
@@ -144,35 +359,52 @@ func run(args []string, supportedProgrammers map[string]programmerInit) (reterr
 				reterr = err
 			}
 		}()
-		if _, err := programmer.ReadAt(buf, 0); err != nil {
+		if _, err := programmer.ReadAt(buf, off); err != nil {
 			return err
 		}
 		if _, err := f.Write(buf); err != nil {
 			return err
 		}
-	} else if *w != "" {
-		f, err := os.Open(*w)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		if _, err := io.ReadFull(f, buf); err != nil {
-			return err
-		}
-		if leftover, err := io.Copy(io.Discard, f); err != nil {
-			return err
-		} else if leftover != 0 {
-			return fmt.Errorf("flash size (%#x) unequal to file size (%#x)", len(buf), int64(len(buf))+leftover)
-		}
+		return nil
+	}
 
-		return errors.New("write not yet supported")
+	file := *w
+	if *v != "" {
+		file = *v
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if n, err := io.ReadFull(f, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%s size (%#x) unequal to file size (%#x)", what, len(buf), n)
+	} else if err != nil {
+		return err
+	}
+	if leftover, err := io.Copy(io.Discard, f); err != nil {
+		return err
+	} else if leftover != 0 {
+		return fmt.Errorf("%s size (%#x) unequal to file size (%#x)", what, len(buf), int64(len(buf))+leftover)
 	}
 
+	if *v != "" {
+		if err := flash.Verify(programmer, buf, off); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Verified %s\n", what)
+		return nil
+	}
+	st, err := flash.Program(programmer, buf, off)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Erased %#x bytes, wrote %#x bytes, skipped %#x bytes of %s\n", st.Erased, st.Written, st.Skipped, what)
 	return nil
 }
 
 func main() {
-	if err := run(os.Args[1:], supportedProgrammers); err != nil {
+	if err := run(os.Stdout, os.Args[1:], supportedProgrammers); err != nil {
 		log.Fatalf("Error: %v", err)
 	}
 }
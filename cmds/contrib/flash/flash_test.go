@@ -0,0 +1,239 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/flash/fmap"
+	"github.com/u-root/u-root/pkg/flash/ifd"
+	"github.com/u-root/u-root/pkg/flash/spimock"
+)
+
+// testImage returns an image for the dummy programmer with a flash
+// descriptor and an FMAP.
+func testImage(t *testing.T) string {
+	t.Helper()
+	img := filepath.Join(t.TempDir(), "image.rom")
+	f, err := os.Create(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(spimock.FakeSize); err != nil {
+		t.Fatal(err)
+	}
+
+	// The descriptor has the BIOS region in the upper 32MiB and the ME
+	// below it.
+	fd := make([]byte, 0x60)
+	binary.LittleEndian.PutUint32(fd[0x10:], ifd.Signature)
+	binary.LittleEndian.PutUint32(fd[0x14:], 0x40>>4<<16|2<<24)
+	binary.LittleEndian.PutUint32(fd[0x18:], 0x60>>4)
+	binary.LittleEndian.PutUint32(fd[0x40:], 0x00000000)
+	binary.LittleEndian.PutUint32(fd[0x44:], 0x3fff2000)
+	binary.LittleEndian.PutUint32(fd[0x48:], 0x1fff0001)
+	for off := 0x4c; off < 0x60; off += 4 {
+		binary.LittleEndian.PutUint32(fd[off:], 0x00007fff)
+	}
+	if _, err := f.WriteAt(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &fmap.FMap{
+		VerMajor: 1,
+		Size:     spimock.FakeSize,
+		Name:     "FLASH",
+		Areas: []fmap.Area{
+			{Offset: 0, Size: 0x10000, Name: "FMAP"},
+			{Offset: 0x10000, Size: 0x4000, Name: "RW_VPD"},
+		},
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The FMAP is at the start of the BIOS region.
+	if _, err := f.WriteAt(b, 0x2000000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("vpd"), 0x10000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("me"), 0x1000); err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestRun(t *testing.T) {
+	img := testImage(t)
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		prefix string
+		size   int
+		err    string
+	}{
+		{
+			name:   "fmap area",
+			args:   []string{"--fmap-area", "RW_VPD"},
+			prefix: "vpd",
+			size:   0x4000,
+		},
+		{
+			name:   "region",
+			args:   []string{"--region", "me"},
+			prefix: "me",
+			size:   0x1fff000,
+		},
+		{
+			name: "no area",
+			args: []string{"--fmap-area", "RO_VPD"},
+			err:  `area "RO_VPD" not found`,
+		},
+		{
+			name: "unused region",
+			args: []string{"--region", "gbe"},
+			err:  `region "gbe" is not used`,
+		},
+		{
+			name: "both",
+			args: []string{"--region", "bios", "--fmap-area", "RW_VPD"},
+			err:  "both --region and --fmap-area cannot be set",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			args := append([]string{"-p", "dummy:image=" + img, "-r", out}, tt.args...)
+			err := run(io.Discard, args, supportedProgrammers)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("run = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) != tt.size || !bytes.HasPrefix(b, []byte(tt.prefix)) {
+				t.Errorf("read %.10q of %#x bytes, want %#x bytes starting with %q", b, len(b), tt.size, tt.prefix)
+			}
+		})
+	}
+
+	// Writes check the size of the region.
+	in := filepath.Join(t.TempDir(), "in")
+	if err := os.WriteFile(in, make([]byte, 0x1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := run(io.Discard, []string{"-p", "dummy:image=" + img, "--fmap-area", "RW_VPD", "-w", in}, supportedProgrammers)
+	if want := `area "RW_VPD" size (0x4000) unequal to file size (0x1000)`; err == nil || err.Error() != want {
+		t.Errorf("run -w = %v, want %q", err, want)
+	}
+
+	for _, args := range [][]string{
+		{"-r", in, "-w", in},
+		{"-E", "--wp-status"},
+		{},
+	} {
+		if err := run(io.Discard, append([]string{"-p", "dummy:image=" + img}, args...), supportedProgrammers); err == nil {
+			t.Errorf("run %q succeeded", args)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	img := testImage(t)
+	in := filepath.Join(t.TempDir(), "in")
+	data := append([]byte("new vpd"), bytes.Repeat([]byte{0xff}, 0x4000-7)...)
+	if err := os.WriteFile(in, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		args []string
+		out  string
+		err  string
+	}{
+		{
+			args: []string{"-v", in},
+			err:  "verify failed",
+		},
+		{
+			args: []string{"-w", in},
+			out:  `Erased 0x4000 bytes, wrote 0x7 bytes, skipped 0x0 bytes of area "RW_VPD"`,
+		},
+		{
+			args: []string{"-v", in},
+			out:  `Verified area "RW_VPD"`,
+		},
+		{
+			args: []string{"-E"},
+			out:  `Erased area "RW_VPD"`,
+		},
+		{
+			args: []string{"-v", in},
+			err:  "verify failed",
+		},
+	} {
+		var out bytes.Buffer
+		args := append([]string{"-p", "dummy:image=" + img, "--fmap-area", "RW_VPD"}, tt.args...)
+		err := run(&out, args, supportedProgrammers)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("run %q = %v, want an error containing %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("run %q = %v", tt.args, err)
+		}
+		if got := strings.TrimSpace(out.String()); got != tt.out {
+			t.Errorf("run %q printed %q, want %q", tt.args, got, tt.out)
+		}
+	}
+}
+
+func TestWriteProtect(t *testing.T) {
+	img := testImage(t)
+	var out bytes.Buffer
+	args := []string{"-p", "dummy:image=" + img, "--wp-range", "0x3ff0000,0x10000", "--wp-enable", "--wp-status", "--otp-status"}
+	if err := run(&out, args, supportedProgrammers); err != nil {
+		t.Fatal(err)
+	}
+	want := `Block protect level: 1
+Protected range: 0x3ff0000-0x3ffffff (0x10000 bytes)
+Status register write disable: true
+OTP factory locked: false
+OTP locked down: false
+`
+	if out.String() != want {
+		t.Errorf("run %q printed\n%s\nwant\n%s", args, out.String(), want)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--wp-range", "0,0x10000"}, "cannot be protected"},
+		{[]string{"--wp-range", "0x10000"}, "want START,LEN"},
+		{[]string{"--wp-enable", "--wp-disable"}, "cannot be set"},
+	} {
+		err := run(io.Discard, append([]string{"-p", "dummy:image=" + img}, tt.args...), supportedProgrammers)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("run %q = %v, want an error containing %q", tt.args, err, tt.err)
+		}
+	}
+}
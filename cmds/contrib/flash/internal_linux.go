@@ -0,0 +1,180 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/u-root/u-root/pkg/flash"
+	"github.com/u-root/u-root/pkg/flash/chipset"
+	"github.com/u-root/u-root/pkg/pci"
+)
+
+// PCI devices with a SPI controller.
+const (
+	intelVendor = 0x8086
+	amdVendor   = 0x1022
+
+	// intelSPIAddr is the SPI device of the 100 series PCH and later.
+	intelSPIAddr = "0000:00:1f.5"
+	// intelLPCAddr is the LPC bridge, which has the RCBA on older
+	// chipsets.
+	intelLPCAddr = "0000:00:1f.0"
+	// amdLPCAddr is the LPC bridge, which has the SPI base address.
+	amdLPCAddr = "0000:00:14.3"
+)
+
+// internalProgrammer is a hardware sequencing ICH, or a flash.Flash driven
+// by a chipset controller.
+type internalProgrammer struct {
+	programmer
+	regs *chipset.MMIO
+}
+
+func (p *internalProgrammer) Close() error {
+	return p.regs.Close()
+}
+
+// Unwrap returns the programmer of the controller.
+func (p *internalProgrammer) Unwrap() programmer {
+	return p.programmer
+}
+
+// flashProgrammer adds a no-op Close to flash.Flash.
+type flashProgrammer struct {
+	*flash.Flash
+}
+
+func (flashProgrammer) Close() error {
+	return nil
+}
+
+// ichProgrammer adds a no-op Close to chipset.ICH.
+type ichProgrammer struct {
+	*chipset.ICH
+}
+
+func (ichProgrammer) Close() error {
+	return nil
+}
+
+// logRanges logs the protected ranges, which writes must avoid.
+func logRanges(ranges []chipset.ProtectedRange) {
+	for _, r := range ranges {
+		log.Printf("Protected range %v", r)
+	}
+}
+
+// openInternal finds the SPI controller of the chipset and returns it as a
+// programmer.
+func openInternal(mode string) (*internalProgrammer, error) {
+	br, err := pci.NewBusReader(intelSPIAddr, intelLPCAddr, amdLPCAddr)
+	if err != nil {
+		return nil, err
+	}
+	devs, err := br.Read()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devs {
+		var (
+			base       int64
+			gen        chipset.Generation
+			romProtect []uint32
+		)
+		switch {
+		case d.Vendor == intelVendor && d.Addr == intelSPIAddr:
+			if len(d.BARS) == 0 {
+				return nil, fmt.Errorf("SPI device %s has no BAR", d.Addr)
+			}
+			base, gen = int64(d.BARS[0].Base), chipset.PCH100
+		case d.Vendor == intelVendor && d.Addr == intelLPCAddr:
+			rcba, err := d.ReadConfigRegister(0xf0, 32)
+			if err != nil {
+				return nil, err
+			}
+			if rcba&1 == 0 {
+				continue
+			}
+			base, gen = int64(rcba&^0x3fff)+0x3800, chipset.ICH9
+		case d.Vendor == amdVendor && d.Addr == amdLPCAddr:
+			spiBase, err := d.ReadConfigRegister(0xa0, 32)
+			if err != nil {
+				return nil, err
+			}
+			for off := int64(0x50); off <= 0x5c; off += 4 {
+				v, err := d.ReadConfigRegister(off, 32)
+				if err != nil {
+					return nil, err
+				}
+				romProtect = append(romProtect, uint32(v))
+			}
+			base = int64(spiBase &^ 0xff)
+		default:
+			continue
+		}
+
+		regs, err := chipset.Map(base)
+		if err != nil {
+			return nil, err
+		}
+		p, err := newInternal(regs, d.Vendor, gen, romProtect, mode)
+		if err != nil {
+			regs.Close()
+			return nil, err
+		}
+		return &internalProgrammer{programmer: p, regs: regs}, nil
+	}
+	return nil, errors.New("no supported chipset SPI controller found")
+}
+
+// newInternal returns the programmer for the controller with the registers
+// regs.
+func newInternal(regs chipset.Registers, vendor uint16, gen chipset.Generation, romProtect []uint32, mode string) (programmer, error) {
+	if vendor == amdVendor {
+		c := chipset.NewFCH(regs, romProtect)
+		logRanges(c.ProtectedRanges())
+		f, err := flash.New(c)
+		if err != nil {
+			return nil, err
+		}
+		return flashProgrammer{f}, nil
+	}
+	c, err := chipset.NewICH(regs, gen)
+	if err != nil {
+		return nil, err
+	}
+	logRanges(c.ProtectedRanges())
+	if mode == "swseq" {
+		f, err := flash.New(c)
+		if err != nil {
+			return nil, err
+		}
+		return flashProgrammer{f}, nil
+	}
+	if c.Size() == 0 {
+		return nil, chipset.ErrNoDescriptor
+	}
+	return ichProgrammer{c}, nil
+}
+
+func init() {
+	supportedProgrammers["internal"] = func(params programmerParams) (programmer, error) {
+		mode := "hwseq"
+		if m, ok := params["ich_spi_mode"]; ok {
+			mode = m
+			delete(params, "ich_spi_mode")
+		}
+		if mode != "hwseq" && mode != "swseq" {
+			return nil, fmt.Errorf("unknown ich_spi_mode %q, want hwseq or swseq", mode)
+		}
+		if len(params) != 0 {
+			return nil, fmt.Errorf("unrecognized parameters: %v", params)
+		}
+		return openInternal(mode)
+	}
+}
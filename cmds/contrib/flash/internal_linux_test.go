@@ -0,0 +1,26 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInternalParams(t *testing.T) {
+	for _, tt := range []struct {
+		arg string
+		err string
+	}{
+		{"internal:ich_spi_mode=fast", `unknown ich_spi_mode "fast"`},
+		{"internal:laptop", "unrecognized parameters"},
+	} {
+		err := run(io.Discard, []string{"-p", tt.arg, "-r", "/dev/null"}, supportedProgrammers)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("run(-p %s) = %v, want an error containing %q", tt.arg, err, tt.err)
+		}
+	}
+}
@@ -3,29 +3,215 @@
 // license that can be found in the LICENSE file.
 
 // Blkid prints information about blocks.
+//
+// Synopsis:
+//     blkid [-o full|value|device|export] [-s TAG]... [device...]
+//     blkid -L LABEL | -U UUID
+//
+// Description:
+//     Print the TYPE, UUID, LABEL, PARTUUID and PARTLABEL of block devices.
+//     Devices may also be image files, which are probed directly. The exit
+//     status is 2 if nothing was found.
+//
+// Options:
+//     -o: output format; full prints tags on one line per device, value
+//         prints one value per line, device prints device names only, and
+//         export prints KEY=value lines suitable for a shell
+//     -s: only print the given tag; may be repeated
+//     -L: print the device with the given file system label
+//     -U: print the device with the given file system UUID
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/u-root/u-root/pkg/mount/block"
 )
 
+type tags []string
+
+func (t *tags) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tags) Set(s string) error {
+	*t = append(*t, s)
+	return nil
+}
+
+var (
+	format = flag.String("o", "full", "Output format: full, value, device or export")
+	label  = flag.String("L", "", "Print the device with this file system label")
+	uuid   = flag.String("U", "", "Print the device with this file system UUID")
+	only   tags
+)
+
+func init() {
+	flag.Var(&only, "s", "Only print this tag (may be repeated)")
+}
+
+// errNotFound makes blkid exit with 2, like the util-linux blkid.
+var errNotFound = errors.New("no matching devices")
+
+type tag struct {
+	name, value string
+}
+
+// deviceTags returns the tags of device in the order blkid prints them.
+func deviceTags(d *block.BlockDev, only []string) []tag {
+	all := []tag{
+		{"LABEL", d.FsLabel},
+		{"UUID", d.FsUUID},
+		{"TYPE", d.FSType},
+		{"PARTLABEL", d.PartLabel},
+		{"PARTUUID", d.PartUUID},
+	}
+	var t []tag
+	for _, a := range all {
+		if a.value == "" {
+			continue
+		}
+		if len(only) > 0 && !contains(only, a.name) {
+			continue
+		}
+		t = append(t, a)
+	}
+	return t
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// escape backslash-escapes the characters in special.
+func escape(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// devices returns the block devices named in args, probing files that are
+// not block devices directly.
+func devices(all block.BlockDevices, args []string) block.BlockDevices {
+	if len(args) == 0 {
+		return all
+	}
+	var ds block.BlockDevices
+	for _, a := range args {
+		if d := all.FilterNames(a); len(d) > 0 {
+			ds = append(ds, d...)
+			continue
+		}
+		if d, err := probeFile(a); err == nil {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+func probeFile(path string) (*block.BlockDev, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	info, err := block.Probe(f, size)
+	if err != nil {
+		return nil, err
+	}
+	return &block.BlockDev{Name: path, FSType: info.Type, FsUUID: info.UUID, FsLabel: info.Label}, nil
+}
+
+// devicePath returns the path of a device, or the path of a probed file.
+func devicePath(d *block.BlockDev) string {
+	if strings.ContainsRune(d.Name, filepath.Separator) {
+		return d.Name
+	}
+	return d.DevicePath()
+}
+
+func blkid(w io.Writer, all block.BlockDevices, format, label, uuid string, only, args []string) error {
+	if label != "" || uuid != "" {
+		var d block.BlockDevices
+		if label != "" {
+			d = all.FilterFSLabel(label)
+		} else {
+			d = all.FilterFSUUID(uuid)
+		}
+		if len(d) == 0 {
+			return errNotFound
+		}
+		fmt.Fprintln(w, devicePath(d[0]))
+		return nil
+	}
+
+	found := false
+	for _, d := range devices(all, args) {
+		t := deviceTags(d, only)
+		if len(t) == 0 {
+			continue
+		}
+		found = true
+		switch format {
+		case "full":
+			fmt.Fprintf(w, "%s:", devicePath(d))
+			for _, e := range t {
+				fmt.Fprintf(w, ` %s="%s"`, e.name, escape(e.value, `"\`))
+			}
+			fmt.Fprintln(w)
+		case "value":
+			for _, e := range t {
+				fmt.Fprintln(w, e.value)
+			}
+		case "device":
+			fmt.Fprintln(w, devicePath(d))
+		case "export":
+			fmt.Fprintf(w, "DEVNAME=%s\n", devicePath(d))
+			for _, e := range t {
+				fmt.Fprintf(w, "%s=%s\n", e.name, escape(e.value, " \\\"'$`<>"))
+			}
+			fmt.Fprintln(w)
+		default:
+			return fmt.Errorf("unknown output format %q", format)
+		}
+	}
+	if !found {
+		return errNotFound
+	}
+	return nil
+}
+
 func main() {
+	flag.Parse()
 	devices, err := block.GetBlockDevices()
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	for _, device := range devices {
-		fmt.Print(device.DevicePath())
-		if device.FsUUID != "" {
-			fmt.Printf(` UUID="%s"`, device.FsUUID)
-		}
-		if device.FSType != "" {
-			fmt.Printf(` TYPE="%s"`, device.FSType)
+	if err := blkid(os.Stdout, devices, *format, *label, *uuid, only, flag.Args()); err != nil {
+		if err == errNotFound {
+			os.Exit(2)
 		}
-		fmt.Println()
+		log.Fatal(err)
 	}
 }
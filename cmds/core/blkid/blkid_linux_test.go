@@ -0,0 +1,114 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/mount/block"
+)
+
+var testDevices = block.BlockDevices{
+	{Name: "sda"},
+	{Name: "sda1", FSType: "vfat", FsUUID: "1122-3344", FsLabel: "EFI", PartLabel: "EFI System", PartUUID: "0fc63daf-8483-4772-8e79-3d69d8477de4"},
+	{Name: "sda2", FSType: "ext4", FsUUID: "2e83ab4d-1f0e-4b47-9a1c-3495f05d0b6e", FsLabel: "my root"},
+}
+
+func TestBlkid(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		format string
+		label  string
+		uuid   string
+		only   []string
+		args   []string
+		want   string
+		err    error
+	}{
+		{
+			name:   "full",
+			format: "full",
+			want: `/dev/sda1: LABEL="EFI" UUID="1122-3344" TYPE="vfat" PARTLABEL="EFI System" PARTUUID="0fc63daf-8483-4772-8e79-3d69d8477de4"
+/dev/sda2: LABEL="my root" UUID="2e83ab4d-1f0e-4b47-9a1c-3495f05d0b6e" TYPE="ext4"
+`,
+		},
+		{
+			name:   "export",
+			format: "export",
+			args:   []string{"/dev/sda2"},
+			want: `DEVNAME=/dev/sda2
+LABEL=my\ root
+UUID=2e83ab4d-1f0e-4b47-9a1c-3495f05d0b6e
+TYPE=ext4
+
+`,
+		},
+		{
+			name:   "value of tag",
+			format: "value",
+			only:   []string{"TYPE"},
+			want:   "vfat\next4\n",
+		},
+		{
+			name:   "device",
+			format: "device",
+			only:   []string{"PARTUUID"},
+			want:   "/dev/sda1\n",
+		},
+		{
+			name:  "by label",
+			label: "my root",
+			want:  "/dev/sda2\n",
+		},
+		{
+			name: "by uuid",
+			uuid: "1122-3344",
+			want: "/dev/sda1\n",
+		},
+		{
+			name:  "label not found",
+			label: "nope",
+			err:   errNotFound,
+		},
+		{
+			name:   "no tags",
+			format: "full",
+			args:   []string{"sda"},
+			err:    errNotFound,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := blkid(&b, testDevices, tt.format, tt.label, tt.uuid, tt.only, tt.args)
+			if err != tt.err {
+				t.Fatalf("blkid = %v, want %v", err, tt.err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("blkid output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlkidImage(t *testing.T) {
+	img := filepath.Join(t.TempDir(), "disk.img")
+	b := make([]byte, 64<<10)
+	copy(b, "XFSB")
+	copy(b[108:], "data")
+	if err := os.WriteFile(img, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := blkid(&out, testDevices, "full", "", "", nil, []string{img}); err != nil {
+		t.Fatal(err)
+	}
+	if want := img + `: LABEL="data" TYPE="xfs"` + "\n"; out.String() != want {
+		t.Errorf("blkid output = %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// curl transfers a URL.
+//
+// Synopsis:
+//     curl [OPTIONS...] URL
+//
+// Description:
+//     curl fetches URL over http, https, tftp or file and writes it to
+//     stdout or a file. Failed requests can be retried, and interrupted
+//     transfers resume where they stopped using Range requests.
+//
+//     Returns a non-zero code on failure, including HTTP error codes.
+//
+// Options:
+//     -o:        write to this file instead of stdout
+//     -O:        write to a file named like the remote file
+//     -C:        resume the transfer at this byte offset, appending to the
+//                output file, or at the size of the output file for -C -
+//     -H:        add a request header, e.g. "Accept: text/plain"; may be repeated
+//     -d:        POST this data, or the contents of FILE for @FILE
+//     -X:        request method
+//     -cert:     PEM client certificate
+//     -key:      PEM private key for -cert, if not in the -cert file
+//     -cacert:   PEM CA bundle to verify the server with
+//     -k:        do not verify the server's certificate
+//     -retry:    retry failed requests and transfers this many times
+//     -m:        maximum time for the whole transfer
+//     -progress: print transfer progress to stderr
+//     -checksum: verify the result against ALGO:HEX, ALGO one of md5, sha1, sha256, sha512
+//
+// Example:
+//     curl -O -C - -retry 5 -checksum sha256:9f86d0... http://10.0.0.1/vmlinuz
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/u-root/u-root/pkg/curl"
+	"github.com/u-root/u-root/pkg/progress"
+)
+
+type headers []string
+
+func (h *headers) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headers) Set(s string) error {
+	if !strings.Contains(s, ":") {
+		return fmt.Errorf("header %q is not NAME: VALUE", s)
+	}
+	*h = append(*h, s)
+	return nil
+}
+
+var (
+	outPath    = flag.String("o", "", "Write to this file instead of stdout")
+	remoteName = flag.Bool("O", false, "Write to a file named like the remote file")
+	resumeAt   = flag.String("C", "", "Resume the transfer at this byte offset, or - for the size of the output file")
+	data       = flag.String("d", "", "POST this data, or the contents of FILE for @FILE")
+	method     = flag.String("X", "", "Request method")
+	certFile   = flag.String("cert", "", "PEM client certificate")
+	keyFile    = flag.String("key", "", "PEM private key for -cert")
+	caFile     = flag.String("cacert", "", "PEM CA bundle to verify the server with")
+	insecure   = flag.Bool("k", false, "Do not verify the server's certificate")
+	retries    = flag.Uint("retry", 0, "Retry failed requests and transfers this many times")
+	maxTime    = flag.Duration("m", 0, "Maximum time for the whole transfer")
+	showProg   = flag.Bool("progress", false, "Print transfer progress to stderr")
+	checksum   = flag.String("checksum", "", "Verify the result against ALGO:HEX")
+
+	reqHeaders headers
+)
+
+func init() {
+	flag.Var(&reqHeaders, "H", "Add a request header; may be repeated")
+}
+
+func usage() {
+	log.Printf("Usage: %s [ARGS] URL\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func tlsConfig() (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: *insecure}
+	if *caFile != "" {
+		b, err := os.ReadFile(*caFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %s", *caFile)
+		}
+	}
+	if *certFile != "" {
+		key := *keyFile
+		if key == "" {
+			key = *certFile
+		}
+		cert, err := tls.LoadX509KeyPair(*certFile, key)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// schemes returns the FileSchemes to fetch with, with retries if asked
+// for.
+func schemes() (curl.Schemes, error) {
+	tc, err := tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	h := curl.NewHTTPClient(&http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tc,
+		},
+	})
+	h.Method = *method
+	if len(reqHeaders) > 0 {
+		h.Header = http.Header{}
+		for _, hdr := range reqHeaders {
+			i := strings.Index(hdr, ":")
+			h.Header.Add(strings.TrimSpace(hdr[:i]), strings.TrimSpace(hdr[i+1:]))
+		}
+	}
+	if *data != "" {
+		body := []byte(*data)
+		if strings.HasPrefix(*data, "@") {
+			if body, err = os.ReadFile((*data)[1:]); err != nil {
+				return nil, err
+			}
+		}
+		h.Body = body
+		if h.Method == "" {
+			h.Method = "POST"
+		}
+		if h.Header == nil {
+			h.Header = http.Header{}
+		}
+		if h.Header.Get("Content-Type") == "" {
+			h.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+
+	s := curl.Schemes{
+		"tftp":  curl.DefaultTFTPClient,
+		"http":  h,
+		"https": h,
+		"file":  &curl.LocalFileClient{},
+	}
+	if *retries == 0 {
+		return s, nil
+	}
+	for name, fs := range s {
+		doRetry := curl.RetryOr(curl.RetryConnectErrors, curl.RetryTemporaryNetworkErrors, curl.RetryHTTP)
+		if name == "tftp" {
+			doRetry = curl.RetryTFTP
+		}
+		s[name] = &curl.SchemeWithRetries{
+			Scheme:  fs,
+			DoRetry: doRetry,
+			BackOff: backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(*retries)),
+		}
+	}
+	return s, nil
+}
+
+// parseChecksum parses ALGO:HEX.
+func parseChecksum(s string) (hash.Hash, []byte, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return nil, nil, fmt.Errorf("checksum %q is not ALGO:HEX", s)
+	}
+	want, err := hex.DecodeString(s[i+1:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("checksum %q: %v", s, err)
+	}
+	var h hash.Hash
+	switch strings.ToLower(s[:i]) {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", s[:i])
+	}
+	if len(want) != h.Size() {
+		return nil, nil, fmt.Errorf("checksum %q has %d bytes, want %d", s, len(want), h.Size())
+	}
+	return h, want, nil
+}
+
+// counter counts bytes written for pkg/progress.
+type counter struct {
+	n int64
+}
+
+func (c *counter) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(b)))
+	return len(b), nil
+}
+
+func run() (reterr error) {
+	log.SetPrefix("curl: ")
+
+	if flag.Parse(); flag.NArg() != 1 {
+		usage()
+	}
+	u, err := url.Parse(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" {
+		return fmt.Errorf("%q: missing scheme, e.g. http://", flag.Arg(0))
+	}
+
+	var (
+		h    hash.Hash
+		want []byte
+	)
+	if *checksum != "" {
+		if h, want, err = parseChecksum(*checksum); err != nil {
+			return err
+		}
+	}
+
+	// resume is whether to resume at the size of the output file.
+	var (
+		resume bool
+		offset int64
+	)
+	switch *resumeAt {
+	case "":
+	case "-":
+		resume = true
+	default:
+		if offset, err = strconv.ParseInt(*resumeAt, 10, 64); err != nil || offset < 0 {
+			return fmt.Errorf("-C %q: want a byte offset or -", *resumeAt)
+		}
+		// The checksum could not cover the skipped bytes.
+		if h != nil {
+			return errors.New("-checksum needs -C - rather than an offset")
+		}
+	}
+	s, err := schemes()
+	if err != nil {
+		return err
+	}
+
+	if *remoteName && *outPath == "" {
+		*outPath = "index.html"
+		if u.Path != "" && !strings.HasSuffix(u.Path, "/") {
+			*outPath = path.Base(u.Path)
+		}
+	}
+	var w io.Writer = os.Stdout
+	if *outPath != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if *resumeAt != "" {
+			flags = os.O_RDWR | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(*outPath, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); reterr == nil {
+				reterr = err
+			}
+		}()
+		if resume {
+			// The checksum covers what we already have, too.
+			if h != nil {
+				if offset, err = io.Copy(h, f); err != nil {
+					return err
+				}
+			} else if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+				return err
+			}
+		}
+		w = f
+	} else if resume {
+		return errors.New("-C - needs an output file")
+	}
+
+	ctx := context.Background()
+	if *maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxTime)
+		defer cancel()
+	}
+
+	var c counter
+	out := io.MultiWriter(w, &c)
+	if h != nil {
+		out = io.MultiWriter(out, h)
+	}
+	if *showProg {
+		p := progress.Begin("progress", &c.n)
+		defer p.End()
+	}
+
+	for attempt := uint(0); ; attempt++ {
+		r, err := s.FetchRange(ctx, u, offset)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(out, r)
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		offset += n
+		if err == nil {
+			break
+		}
+		if attempt == *retries || ctx.Err() != nil {
+			return fmt.Errorf("transfer interrupted after %d bytes: %v", offset, err)
+		}
+		log.Printf("Transfer interrupted after %d bytes: %v; resuming", offset, err)
+	}
+
+	if h != nil {
+		if got := h.Sum(nil); !bytes.Equal(got, want) {
+			return fmt.Errorf("checksum mismatch: got %x, want %x", got, want)
+		}
+	}
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
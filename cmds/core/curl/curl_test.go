@@ -0,0 +1,120 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+const content = "The quick brown fox jumps over the lazy dog"
+
+func newServer(t *testing.T) *httptest.Server {
+	var flaky int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, strings.NewReader(content))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("X-Test"), b)
+	})
+	// The first transfer of /flaky breaks off halfway.
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&flaky, 1) == 1 {
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.Write([]byte(content[:10]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "flaky", time.Time{}, strings.NewReader(content))
+	})
+	mux.HandleFunc("/404", http.NotFound)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestCurl(t *testing.T) {
+	ts := newServer(t)
+	sum := sha256.Sum256([]byte(content))
+
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		partial string // existing output file content
+		want    string
+		retCode int
+	}{
+		{name: "basic", args: []string{"/file"}, want: content},
+		{name: "post", args: []string{"-d", "x=1", "-H", "X-Test: yes", "/echo"}, want: "POST yes x=1"},
+		{name: "method", args: []string{"-X", "PUT", "/echo"}, want: "PUT  "},
+		{name: "resume", args: []string{"-C", "-", "/file"}, partial: content[:15], want: content},
+		{name: "resume complete", args: []string{"-C", "-", "/file"}, partial: content, want: content},
+		{name: "resume offset", args: []string{"-C", "10", "/file"}, partial: content[:15], want: content[:15] + content[10:]},
+		{name: "bad offset", args: []string{"-C", "x", "/file"}, retCode: 1},
+		{name: "checksum", args: []string{"-C", "-", "-checksum", fmt.Sprintf("sha256:%x", sum), "/file"}, partial: content[:5], want: content},
+		{name: "bad checksum", args: []string{"-checksum", "sha256:" + strings.Repeat("00", 32), "/file"}, want: content, retCode: 1},
+		{name: "interrupted", args: []string{"-retry", "1", "/flaky"}, want: content},
+		{name: "not found", args: []string{"/404"}, retCode: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			if tt.partial != "" {
+				if err := os.WriteFile(out, []byte(tt.partial), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			args := append([]string{"-o", out}, tt.args...)
+			args[len(args)-1] = ts.URL + args[len(args)-1]
+			output, err := testutil.Command(t, args...).CombinedOutput()
+			if err := testutil.IsExitCode(err, tt.retCode); err != nil {
+				t.Fatalf("exit code: %v, output: %s", err, output)
+			}
+			if tt.want == "" {
+				return
+			}
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurlStdout(t *testing.T) {
+	ts := newServer(t)
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range []string{ts.URL + "/file", "file://" + path} {
+		out, err := testutil.Command(t, arg).Output()
+		if err != nil {
+			t.Fatalf("curl %s: %v", arg, err)
+		}
+		if string(out) != content {
+			t.Errorf("curl %s = %q, want %q", arg, out, content)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
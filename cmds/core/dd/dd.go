@@ -16,16 +16,32 @@
 //     -bs n:    input and output block size (default=0)
 //     -skip n:  skip n ibs-sized input blocks before reading (default=0)
 //     -seek n:  seek n obs-sized output blocks before writing (default=0)
-//     -conv s:  comma separated list of conversions (none|notrunc)
+//     -conv s:  comma separated list of conversions, can be:
+//         notrunc:  do not truncate the output file
+//         noerror:  continue after read errors
+//         sync:     pad every input block with zeros to ibs
+//         sparse:   seek over zero output blocks instead of writing them
 //     -count n: copy only n ibs-sized input blocks
 //     -if:      defaults to stdin
 //     -of:      defaults to stdout
-//     -oflag:   comma separated list of out flags (none|sync|dsync)
+//     -iflag:   comma separated list of in flags, can be:
+//         direct:      use direct I/O
+//         fullblock:   accumulate full input blocks
+//         count_bytes: count is in bytes
+//         skip_bytes:  skip is in bytes
+//     -oflag:   comma separated list of out flags, can be:
+//         sync, dsync: use synchronized I/O
+//         direct:      use direct I/O
+//         seek_bytes:  seek is in bytes
+//     -mapfile: log the input ranges read and the bad ones to this file in
+//               the format of ddrescue(1); implies conv=noerror,sync
 //     -status:  print transfer stats to stderr, can be one of:
 //         none:     do not display
 //         xfer:     print on completion (default)
 //         progress: print throughout transfer (GNU)
 //
+//     Stats are also printed when dd gets SIGUSR1.
+//
 // Notes:
 //     Because UTF-8 clashes with block-oriented copying, `conv=lcase` and
 //     `conv=ucase` will not be supported. Additionally, research showed these
@@ -33,15 +49,18 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"unsafe"
 
 	"github.com/rck/unit"
 	"github.com/u-root/u-root/pkg/progress"
@@ -51,11 +70,13 @@ var (
 	ibs, obs, bs *unit.Value
 	skip         = flag.Int64("skip", 0, "skip N ibs-sized blocks before reading")
 	seek         = flag.Int64("seek", 0, "seek N obs-sized blocks before writing")
-	conv         = flag.String("conv", "none", "comma separated list of conversions (none|notrunc)")
+	conv         = flag.String("conv", "none", "comma separated list of conversions (none|notrunc|noerror|sync|sparse)")
 	count        = flag.Int64("count", math.MaxInt64, "copy only N input blocks")
 	inName       = flag.String("if", "", "Input file")
 	outName      = flag.String("of", "", "Output file")
-	oFlag        = flag.String("oflag", "none", "comma separated list of out flags (none|sync|dsync)")
+	iFlag        = flag.String("iflag", "none", "comma separated list of in flags (none|direct|fullblock|count_bytes|skip_bytes)")
+	oFlag        = flag.String("oflag", "none", "comma separated list of out flags (none|sync|dsync|direct|seek_bytes)")
+	mapName      = flag.String("mapfile", "", "ddrescue map file of the input ranges read")
 	status       = flag.String("status", "xfer", "display status of transfer (none|xfer|progress)")
 
 	bytesWritten int64 // access atomically, must be global for correct alignedness
@@ -69,16 +90,31 @@ type bitClearAndSet struct {
 // N.B. The flags in os derive from syscall.
 // They are, hence, mutually exclusive, on target
 // kernels.
+//
+// Entries without bits are handled while copying.
 var convMap = map[string]bitClearAndSet{
 	"notrunc": {clear: os.O_TRUNC},
+	"noerror": {},
+	"sync":    {},
+	"sparse":  {},
+}
+
+var iflagMap = map[string]bitClearAndSet{
+	"fullblock":   {},
+	"count_bytes": {},
+	"skip_bytes":  {},
 }
 
 var flagMap = map[string]bitClearAndSet{
-	"sync": {set: os.O_SYNC},
+	"sync":       {set: os.O_SYNC},
+	"seek_bytes": {},
 }
 
 var allowedFlags = os.O_TRUNC | os.O_SYNC
 
+// statusSignals make dd print its stats.
+var statusSignals []os.Signal
+
 // intermediateBuffer is a buffer that one can write to and read from.
 type intermediateBuffer interface {
 	io.ReaderFrom
@@ -325,24 +361,239 @@ func (s *sectionReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-// inFile opens the input file and seeks to the right position.
-func inFile(name string, inputBytes int64, skip int64, count int64) (io.Reader, error) {
-	maxRead := int64(math.MaxInt64)
-	if count != math.MaxInt64 {
-		maxRead = count * inputBytes
-	}
-
+// inFile opens the input file and seeks to the right position. skip and
+// count are in bytes, and a count of math.MaxInt64 reads to the end.
+func inFile(name string, skip int64, count int64, flags int) (io.Reader, error) {
 	if name == "" {
 		// os.Stdin is an io.ReaderAt, but you can't actually call
 		// pread(2) on it, so use the copying section reader.
-		return newStreamSectionReader(os.Stdin, inputBytes*skip, maxRead), nil
+		return newStreamSectionReader(os.Stdin, skip, count), nil
 	}
 
-	in, err := os.Open(name)
+	in, err := os.OpenFile(name, os.O_RDONLY|(flags&allowedFlags), 0)
 	if err != nil {
 		return nil, fmt.Errorf("error opening input file %q: %v", name, err)
 	}
-	return io.NewSectionReader(in, inputBytes*skip, maxRead), nil
+	return io.NewSectionReader(in, skip, count), nil
+}
+
+// directAlign is the alignment of buffers for direct I/O.
+const directAlign = 4096
+
+// alignedBuffer returns n bytes aligned for direct I/O.
+func alignedBuffer(n int) []byte {
+	b := make([]byte, n+directAlign)
+	off := directAlign - int(uintptr(unsafe.Pointer(&b[0]))&(directAlign-1))
+	return b[off : off+n : off+n]
+}
+
+// badRange is a range of input that could not be read.
+type badRange struct {
+	off  int64
+	size int64
+}
+
+// blockReader reads at most one input block per Read, doing the
+// conversions that work on whole input blocks.
+type blockReader struct {
+	r io.Reader
+
+	// pos is the input offset of the next read.
+	pos int64
+
+	// buf is an aligned buffer for direct I/O, or nil.
+	buf []byte
+
+	fullblock bool
+	noerror   bool
+	sync      bool
+
+	// bad are the ranges skipped after read errors.
+	bad []badRange
+}
+
+// Read implements io.Reader.
+func (b *blockReader) Read(p []byte) (int, error) {
+	for {
+		buf := p
+		if b.buf != nil {
+			if len(p) > len(b.buf) {
+				buf = b.buf
+			} else {
+				buf = b.buf[:len(p)]
+			}
+		}
+
+		var n int
+		var err error
+		if b.fullblock {
+			n, err = io.ReadFull(b.r, buf)
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+		} else {
+			n, err = b.r.Read(buf)
+		}
+		b.pos += int64(n)
+		if err != nil && err != io.EOF && b.noerror {
+			log.Printf("error reading at offset %d: %v", b.pos, err)
+			// Skip the rest of the block, like GNU dd. Input
+			// that cannot seek just goes on.
+			rest := int64(len(buf) - n)
+			if s, ok := b.r.(io.Seeker); ok {
+				if _, err := s.Seek(rest, io.SeekCurrent); err != nil {
+					return n, err
+				}
+			}
+			b.addBad(b.pos, rest)
+			b.pos += rest
+			if b.sync {
+				n = b.pad(buf, n)
+			}
+			err = nil
+		} else if n > 0 && b.sync {
+			n = b.pad(buf, n)
+		}
+		if b.buf != nil {
+			copy(p, buf[:n])
+		}
+		// Without sync, a block that was all errors has nothing to
+		// give, and an empty read would be taken for the end.
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// pad fills buf with zeros after the n bytes read.
+func (b *blockReader) pad(buf []byte, n int) int {
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	return len(buf)
+}
+
+func (b *blockReader) addBad(off, size int64) {
+	if size == 0 {
+		return
+	}
+	if l := len(b.bad) - 1; l >= 0 && b.bad[l].off+b.bad[l].size == off {
+		b.bad[l].size += size
+		return
+	}
+	b.bad = append(b.bad, badRange{off: off, size: size})
+}
+
+// writeMap writes the ranges from start up to the current position to w
+// as a ddrescue(1) map file: the bad ones are marked "-", the rest "+",
+// and anything before start was never tried.
+func (b *blockReader) writeMap(w io.Writer, start int64, finished bool) error {
+	status := "+"
+	if !finished {
+		status = "?"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Mapfile. Created by u-root dd\n")
+	fmt.Fprintf(&buf, "# current_pos  current_status\n")
+	fmt.Fprintf(&buf, "0x%08X     %s\n", b.pos, status)
+	fmt.Fprintf(&buf, "#      pos        size  status\n")
+	line := func(off, size int64, status string) {
+		if size > 0 {
+			fmt.Fprintf(&buf, "0x%08X  0x%08X  %s\n", off, size, status)
+		}
+	}
+	line(0, start, "?")
+	off := start
+	for _, r := range b.bad {
+		line(off, r.off-off, "+")
+		line(r.off, r.size, "-")
+		off = r.off + r.size
+	}
+	line(off, b.pos-off, "+")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// sparseWriter seeks over output blocks of zeros instead of writing them.
+type sparseWriter struct {
+	io.Writer
+
+	// hole is whether the last block was skipped.
+	hole bool
+}
+
+// Write implements io.Writer.
+func (s *sparseWriter) Write(p []byte) (int, error) {
+	if isZero(p) {
+		if sk, ok := s.Writer.(io.Seeker); ok {
+			if _, err := sk.Seek(int64(len(p)), io.SeekCurrent); err == nil {
+				s.hole = true
+				return len(p), nil
+			}
+		}
+	}
+	s.hole = false
+	return s.Writer.Write(p)
+}
+
+// finish extends the output file over a hole at its end.
+func (s *sparseWriter) finish() error {
+	f, ok := s.Writer.(interface {
+		io.Seeker
+		Stat() (os.FileInfo, error)
+		Truncate(int64) error
+	})
+	if !s.hole || !ok {
+		return nil
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() || fi.Size() >= off {
+		return nil
+	}
+	return f.Truncate(off)
+}
+
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// clearDirect turns direct I/O off for f.
+var clearDirect = func(f *os.File) error { return nil }
+
+// directWriter writes to a file opened for direct I/O from an aligned
+// buffer.
+type directWriter struct {
+	*os.File
+	buf []byte
+}
+
+// Write implements io.Writer.
+func (d *directWriter) Write(p []byte) (int, error) {
+	// The last block may be too short for direct I/O. GNU dd writes it
+	// with direct I/O turned off, too.
+	if len(p)%512 != 0 {
+		if err := clearDirect(d.File); err != nil {
+			return 0, err
+		}
+		return d.File.Write(p)
+	}
+	if len(d.buf) < len(p) {
+		d.buf = alignedBuffer(len(p))
+	}
+	copy(d.buf, p)
+	return d.File.Write(d.buf[:len(p)])
 }
 
 // outFile opens the output file and seeks to the right position.
@@ -366,12 +617,33 @@ func outFile(name string, outputBytes int64, seek int64, flags int) (io.Writer,
 }
 
 func usage() {
-	log.Fatal(`Usage: dd [if=file] [of=file] [conv=none|notrunc] [seek=#] [skip=#]
-			     [count=#] [bs=#] [ibs=#] [obs=#] [status=none|xfer|progress] [oflag=none|sync|dsync]
+	log.Fatal(`Usage: dd [if=file] [of=file] [conv=none|notrunc|noerror|sync|sparse] [seek=#] [skip=#]
+			     [count=#] [bs=#] [ibs=#] [obs=#] [status=none|xfer|progress]
+			     [iflag=none|direct|fullblock|count_bytes|skip_bytes]
+			     [oflag=none|sync|dsync|direct|seek_bytes] [mapfile=file]
 		options may also be invoked Go-style as -opt value or -opt=value
 		bs, if specified, overrides ibs and obs`)
 }
 
+// parseFlags applies the comma separated list of names in m to flags. It
+// returns the new flags and the names given.
+func parseFlags(list string, m map[string]bitClearAndSet, flags int) (int, map[string]bool, error) {
+	names := map[string]bool{}
+	if list == "none" {
+		return flags, names, nil
+	}
+	for _, f := range strings.Split(list, ",") {
+		v, ok := m[f]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown argument %s", f)
+		}
+		flags &= ^v.clear
+		flags |= v.set
+		names[f] = true
+	}
+	return flags, names, nil
+}
+
 func convertArgs(osArgs []string) []string {
 	// EVERYTHING in dd follows x=y. So blindly split and convert.
 	var args []string
@@ -400,37 +672,40 @@ func main() {
 		usage()
 	}
 
-	// Convert conv argument to bit set.
-	flags := os.O_TRUNC
-	if *conv != "none" {
-		for _, c := range strings.Split(*conv, ",") {
-			if v, ok := convMap[c]; ok {
-				flags &= ^v.clear
-				flags |= v.set
-			} else {
-				log.Printf("unknown argument conv=%s", c)
-				usage()
-			}
-		}
+	// Convert conv and oflag arguments to bit set.
+	flags, convs, err := parseFlags(*conv, convMap, os.O_TRUNC)
+	if err != nil {
+		log.Printf("conv=%s: %v", *conv, err)
+		usage()
 	}
-
-	// Convert oflag argument to bit set.
-	if *oFlag != "none" {
-		for _, f := range strings.Split(*oFlag, ",") {
-			if v, ok := flagMap[f]; ok {
-				flags &= ^v.clear
-				flags |= v.set
-			} else {
-				log.Printf("unknown argument oflag=%s", f)
-				usage()
-			}
-		}
+	flags, oflags, err := parseFlags(*oFlag, flagMap, flags)
+	if err != nil {
+		log.Printf("oflag=%s: %v", *oFlag, err)
+		usage()
+	}
+	iflagBits, iflags, err := parseFlags(*iFlag, iflagMap, 0)
+	if err != nil {
+		log.Printf("iflag=%s: %v", *iFlag, err)
+		usage()
+	}
+	if *mapName != "" {
+		convs["noerror"], convs["sync"] = true, true
 	}
 
 	if *status != "none" && *status != "xfer" && *status != "progress" {
 		usage()
 	}
 	progress := progress.Begin(*status, &bytesWritten)
+	if *status != "none" && len(statusSignals) > 0 {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, statusSignals...)
+		defer signal.Stop(sigs)
+		go func() {
+			for range sigs {
+				progress.Print()
+			}
+		}()
+	}
 
 	// bs = both 'ibs' and 'obs' (IEEE Std 1003.1 - 2013)
 	if bs.IsSet {
@@ -438,16 +713,73 @@ func main() {
 		obs = bs
 	}
 
-	in, err := inFile(*inName, ibs.Value, *skip, *count)
+	skipBytes, seekBytes, maxRead := *skip*ibs.Value, *seek*obs.Value, int64(math.MaxInt64)
+	if iflags["skip_bytes"] {
+		skipBytes = *skip
+	}
+	if oflags["seek_bytes"] {
+		seekBytes = *seek
+	}
+	if iflags["count_bytes"] {
+		maxRead = *count
+	} else if *count != math.MaxInt64 {
+		maxRead = *count * ibs.Value
+	}
+
+	in, err := inFile(*inName, skipBytes, maxRead, iflagBits)
 	if err != nil {
 		log.Fatal(err)
 	}
-	out, err := outFile(*outName, obs.Value, *seek, flags)
+	var br *blockReader
+	if convs["noerror"] || convs["sync"] || iflags["fullblock"] || iflagBits != 0 {
+		br = &blockReader{
+			r:         in,
+			pos:       skipBytes,
+			fullblock: iflags["fullblock"],
+			noerror:   convs["noerror"],
+			sync:      convs["sync"],
+		}
+		if iflagBits != 0 {
+			br.buf = alignedBuffer(int(ibs.Value))
+		}
+		in = br
+	}
+	out, err := outFile(*outName, 1, seekBytes, flags)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := parallelChunkedCopy(in, out, ibs.Value, obs.Value, flags); err != nil {
-		log.Fatal(err)
+	if f, ok := out.(*os.File); ok && oflags["direct"] && *outName != "" {
+		out = &directWriter{File: f}
+	}
+	var sparse *sparseWriter
+	if convs["sparse"] {
+		sparse = &sparseWriter{Writer: out}
+		out = sparse
+	}
+
+	copyErr := parallelChunkedCopy(in, out, ibs.Value, obs.Value, flags)
+	if *mapName != "" {
+		f, err := os.Create(*mapName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := br.writeMap(f, skipBytes, copyErr == nil); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if copyErr != nil {
+		log.Fatal(copyErr)
+	}
+	if sparse != nil {
+		if err := sparse.finish(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if br != nil && len(br.bad) > 0 {
+		log.Printf("%d bad input ranges", len(br.bad))
 	}
 
 	progress.End()
@@ -4,9 +4,29 @@
 
 package main
 
-import "syscall"
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
 
 func init() {
 	flagMap["dsync"] = bitClearAndSet{set: syscall.O_DSYNC}
-	allowedFlags |= syscall.O_DSYNC
+	flagMap["direct"] = bitClearAndSet{set: syscall.O_DIRECT}
+	iflagMap["direct"] = bitClearAndSet{set: syscall.O_DIRECT}
+	allowedFlags |= syscall.O_DSYNC | syscall.O_DIRECT
+
+	statusSignals = append(statusSignals, syscall.SIGUSR1)
+
+	clearDirect = func(f *os.File) error {
+		fl, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+		if err != nil {
+			return &os.PathError{Op: "fcntl", Path: f.Name(), Err: err}
+		}
+		if _, err := unix.FcntlInt(f.Fd(), unix.F_SETFL, fl&^unix.O_DIRECT); err != nil {
+			return &os.PathError{Op: "fcntl", Path: f.Name(), Err: err}
+		}
+		return nil
+	}
 }
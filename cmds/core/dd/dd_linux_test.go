@@ -0,0 +1,43 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestDirect(t *testing.T) {
+	tmpDir := t.TempDir()
+	inFile := filepath.Join(tmpDir, "inFile")
+	outFile := filepath.Join(tmpDir, "outFile")
+	// Not a multiple of the block size, so the last block cannot use
+	// direct I/O.
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := os.WriteFile(inFile, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(inFile, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if err != nil {
+		t.Skipf("no direct I/O in %s: %v", tmpDir, err)
+	}
+	f.Close()
+
+	if err := testutil.Command(t, "if="+inFile, "of="+outFile, "bs=4096", "iflag=direct", "oflag=direct").Run(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("copied %d bytes, want the %d written", len(got), len(data))
+	}
+}
@@ -7,6 +7,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -313,7 +314,7 @@ func TestInFile(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := inFile(p, tt.outputBytes, tt.seek, tt.count)
+			_, err := inFile(p, tt.outputBytes*tt.seek, tt.outputBytes*tt.count, 0)
 			if err != nil && !tt.wantErr {
 				t.Errorf("outFile failed with %v", err)
 			}
@@ -501,6 +502,20 @@ func TestDd(t *testing.T) {
 			stdout:  []byte("world....."),
 			compare: stdoutEqual,
 		},
+		{
+			name:    "Pad short blocks",
+			flags:   []string{"bs=4", "conv=sync"},
+			stdin:   "hello world",
+			stdout:  []byte("hello world\x00"),
+			compare: stdoutEqual,
+		},
+		{
+			name:    "Skip and count in bytes",
+			flags:   []string{"bs=4", "skip=2", "count=3", "iflag=skip_bytes,count_bytes"},
+			stdin:   "hello world",
+			stdout:  []byte("llo"),
+			compare: stdoutEqual,
+		},
 		{
 			name:    "512 MiB zeroed file in 1024 1KiB blocks",
 			flags:   []string{"bs=524288", "count=1024", "if=/dev/zero"},
@@ -648,6 +663,32 @@ func TestFiles(t *testing.T) {
 			inFile:   []byte("y: defaults"),
 			expected: []byte("y: defaults"),
 		},
+		{
+			name:     "seek in bytes",
+			flags:    []string{"bs=4", "seek=2", "oflag=seek_bytes", "conv=notrunc"},
+			inFile:   []byte("12"),
+			outFile:  []byte("abcde"),
+			expected: []byte("ab12e"),
+		},
+		{
+			name:     "sparse",
+			flags:    []string{"bs=4", "conv=sparse"},
+			inFile:   []byte("\x00\x00\x00\x00abcd\x00\x00\x00\x00\x00\x00"),
+			expected: []byte("\x00\x00\x00\x00abcd\x00\x00\x00\x00\x00\x00"),
+		},
+		{
+			name:     "sparse keeps data",
+			flags:    []string{"bs=4", "conv=sparse,notrunc"},
+			inFile:   []byte("\x00\x00\x00\x00abcd"),
+			outFile:  []byte("12345678"),
+			expected: []byte("1234abcd"),
+		},
+		{
+			name:     "full blocks",
+			flags:    []string{"bs=4", "iflag=fullblock", "conv=sync"},
+			inFile:   []byte("abcdef"),
+			expected: []byte("abcdef\x00\x00"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -678,6 +719,158 @@ func TestFiles(t *testing.T) {
 	}
 }
 
+// badReader reads data, but fails to read the bad ranges.
+type badReader struct {
+	data []byte
+	bad  []badRange
+	off  int64
+}
+
+var errBad = errors.New("bad sector")
+
+func (b *badReader) Read(p []byte) (int, error) {
+	if b.off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	for _, r := range b.bad {
+		if b.off >= r.off && b.off < r.off+r.size {
+			return 0, errBad
+		}
+		if r.off > b.off && r.off < b.off+int64(len(p)) {
+			p = p[:r.off-b.off]
+		}
+	}
+	n := copy(p, b.data[b.off:])
+	b.off += int64(n)
+	return n, nil
+}
+
+func (b *badReader) Seek(off int64, whence int) (int64, error) {
+	if whence != io.SeekCurrent {
+		return 0, fmt.Errorf("whence %d not supported", whence)
+	}
+	b.off += off
+	return b.off, nil
+}
+
+func TestBlockReader(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		br    blockReader
+		clean bool
+		want  string
+		bad   []badRange
+		err   error
+	}{
+		{
+			name:  "no errors",
+			br:    blockReader{noerror: true},
+			clean: true,
+			want:  "0123456789abcdef",
+		},
+		{
+			name: "error",
+			br:   blockReader{},
+			want: "01234",
+			err:  errBad,
+		},
+		{
+			name: "noerror",
+			br:   blockReader{noerror: true},
+			want: "012349abcdef",
+			bad:  []badRange{{5, 4}},
+		},
+		{
+			name: "noerror,sync",
+			br:   blockReader{noerror: true, sync: true},
+			want: "01234\x00\x00\x00\x00\x00\x00\x009abcdef\x00",
+			bad:  []badRange{{5, 4}},
+		},
+		{
+			name: "noerror,sync fullblock",
+			br:   blockReader{noerror: true, sync: true, fullblock: true},
+			want: "01234\x00\x00\x0089abcdef",
+			bad:  []badRange{{5, 3}},
+		},
+		{
+			name: "direct",
+			br:   blockReader{noerror: true, sync: true, fullblock: true, buf: alignedBuffer(4)},
+			want: "01234\x00\x00\x0089abcdef",
+			bad:  []badRange{{5, 3}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			br := tt.br
+			r := &badReader{data: []byte("0123456789abcdef"), bad: []badRange{{5, 2}}}
+			if tt.clean {
+				r.bad = nil
+			}
+			br.r = r
+			var got []byte
+			var err error
+			for {
+				p := make([]byte, 4)
+				var n int
+				n, err = br.Read(p)
+				got = append(got, p[:n]...)
+				if err != nil {
+					break
+				}
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			if string(got) != tt.want || err != tt.err {
+				t.Errorf("read %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+			if !reflect.DeepEqual(br.bad, tt.bad) {
+				t.Errorf("bad ranges %v, want %v", br.bad, tt.bad)
+			}
+		})
+	}
+}
+
+func TestWriteMap(t *testing.T) {
+	br := &blockReader{pos: 0x1000, bad: []badRange{{0x400, 0x200}, {0xe00, 0x200}}}
+	var b bytes.Buffer
+	if err := br.writeMap(&b, 0x200, true); err != nil {
+		t.Fatal(err)
+	}
+	want := `# Mapfile. Created by u-root dd
+# current_pos  current_status
+0x00001000     +
+#      pos        size  status
+0x00000000  0x00000200  ?
+0x00000200  0x00000200  +
+0x00000400  0x00000200  -
+0x00000600  0x00000800  +
+0x00000E00  0x00000200  -
+`
+	if b.String() != want {
+		t.Errorf("map file:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestMapFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	inFile := filepath.Join(tmpDir, "inFile")
+	mapFile := filepath.Join(tmpDir, "map")
+	if err := os.WriteFile(inFile, []byte("0123456789"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.Command(t, "if="+inFile, "of=/dev/null", "bs=4", "skip=1", "mapfile="+mapFile).Run(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(mapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "0x00000000  0x00000004  ?\n0x00000004  0x00000006  +\n"
+	if !strings.HasSuffix(string(got), want) {
+		t.Errorf("map file:\n%s\nwant it to end with:\n%s", got, want)
+	}
+}
+
 // BenchmarkDd benchmarks the dd command. Each "op" unit is a 1MiB block.
 func BenchmarkDd(b *testing.B) {
 	const bytesPerOp = 1024 * 1024
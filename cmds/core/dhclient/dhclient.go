@@ -14,6 +14,7 @@
 //     -timeout:  lease timeout in seconds
 //     -renewals: number of DHCP renewals before exiting
 //     -verbose:  verbose output
+//     -dns-stub: point resolv.conf at the stub resolver at this address
 package main
 
 import (
@@ -26,6 +27,7 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/u-root/u-root/pkg/dns"
 	"github.com/vishvananda/netlink"
 )
 
@@ -43,6 +45,8 @@ var (
 	v6Server = flag.String("v6-server", "ff02::1:2", "DHCPv6 server address to send to (multicast or unicast)")
 
 	v4Port = flag.Int("v4-port", dhcpv4.ServerPort, "DHCPv4 server port to send to")
+
+	dnsStub = flag.String("dns-stub", "", "Address of a local stub resolver (e.g. dnsd) to point resolv.conf at; DHCP nameservers are written to "+dns.UpstreamConf)
 )
 
 func main() {
@@ -54,16 +58,22 @@ func main() {
 	if len(flag.Args()) > 0 {
 		ifName = flag.Args()[0]
 	}
+	var dnsOpts dhclient.DNSOptions
+	if *dnsStub != "" {
+		if dnsOpts.Stub = net.ParseIP(*dnsStub); dnsOpts.Stub == nil {
+			log.Fatalf("invalid -dns-stub address %q", *dnsStub)
+		}
+	}
 
 	filteredIfs, err := dhclient.Interfaces(ifName)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	configureAll(filteredIfs)
+	configureAll(filteredIfs, dnsOpts)
 }
 
-func configureAll(ifs []netlink.Link) {
+func configureAll(ifs []netlink.Link, dnsOpts dhclient.DNSOptions) {
 	packetTimeout := time.Duration(*timeout) * time.Second
 
 	c := dhclient.Config{
@@ -91,7 +101,7 @@ func configureAll(ifs []netlink.Link) {
 			log.Printf("Could not configure %s for %s: %v", result.Interface.Attrs().Name, result.Protocol, result.Err)
 		} else if *dryRun {
 			log.Printf("Dry run: would have configured %s with %s", result.Interface.Attrs().Name, result.Lease)
-		} else if err := result.Lease.Configure(); err != nil {
+		} else if err := result.Lease.ConfigureWithOptions(dnsOpts); err != nil {
 			log.Printf("Could not configure %s for %s: %v", result.Interface.Attrs().Name, result.Protocol, err)
 		} else {
 			log.Printf("Configured %s with %s", result.Interface.Attrs().Name, result.Lease)
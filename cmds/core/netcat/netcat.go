@@ -3,72 +3,593 @@
 // license that can be found in the LICENSE file.
 
 // netcat creates arbitrary TCP and UDP connections and listens and sends arbitrary data.
+//
+// Synopsis:
+//     netcat [OPTIONS...] HOST:PORT
+//     netcat [OPTIONS...] HOST PORT[-PORT]
+//     netcat -l [OPTIONS...] [[HOST:]PORT]
+//     netcat -U [OPTIONS...] PATH
+//
+// Description:
+//     Without -l, netcat connects to the address and copies stdin to the
+//     connection and the connection to stdout. With -l, it does the same
+//     for the first connection it accepts.
+//
+// Options:
+//     -net:            network type, e.g. tcp, udp, unix (default tcp)
+//     -u:              use UDP
+//     -U:              use unix domain sockets
+//     -4, -6:          only use IPv4 or IPv6
+//     -l:              listen for connections
+//     -k:              with -l, keep listening after a connection ends
+//     -z:              zero-I/O mode: report which ports accept connections
+//     -w:              connect and idle timeout
+//     -e:              execute PROG with its stdin and stdout connected to the peer
+//     -c:              like -e, but run CMD with /bin/sh -c
+//     -s:              source address to connect from
+//     -p:              source port to connect from, or with -l the port to listen on
+//     -v:              verbose output
+//     -ssl:            use TLS
+//     -ssl-cert:       PEM certificate to present; a listener without one uses a self-signed certificate
+//     -ssl-key:        PEM private key for -ssl-cert
+//     -ssl-verify:     verify the peer's certificate
+//     -ssl-trustfile:  PEM CA certificates to verify the peer with, implies -ssl-verify
+//     -ssl-servername: server name to verify, default the host connected to
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"net"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/u-root/u-root/pkg/uroot/util"
 )
 
-const usage = "netcat [go-style network address]"
+const usage = "netcat [go-style network address | host port[-port]]"
 
-var (
-	netType = flag.String("net", "tcp", "What net type to use, e.g. tcp, unix, etc.")
-	listen  = flag.Bool("l", false, "Listen for connections.")
-	verbose = flag.Bool("v", false, "Verbose output.")
-)
+type params struct {
+	net     string
+	udp     bool
+	unix    bool
+	ipv4    bool
+	ipv6    bool
+	listen  bool
+	keep    bool
+	zero    bool
+	timeout time.Duration
+	exec    string
+	shell   string
+	source  string
+	port    string
+	verbose bool
+
+	ssl           bool
+	sslCert       string
+	sslKey        string
+	sslVerify     bool
+	sslTrustFile  string
+	sslServerName string
+}
+
+var p params
 
 func init() {
+	flag.StringVar(&p.net, "net", "tcp", "What net type to use, e.g. tcp, unix, etc.")
+	flag.BoolVar(&p.udp, "u", false, "Use UDP.")
+	flag.BoolVar(&p.unix, "U", false, "Use unix domain sockets.")
+	flag.BoolVar(&p.ipv4, "4", false, "Only use IPv4.")
+	flag.BoolVar(&p.ipv6, "6", false, "Only use IPv6.")
+	flag.BoolVar(&p.listen, "l", false, "Listen for connections.")
+	flag.BoolVar(&p.keep, "k", false, "Keep listening after a connection ends.")
+	flag.BoolVar(&p.zero, "z", false, "Zero-I/O mode: only report open ports.")
+	flag.DurationVar(&p.timeout, "w", 0, "Connect and idle timeout.")
+	flag.StringVar(&p.exec, "e", "", "Execute this program on connect.")
+	flag.StringVar(&p.shell, "c", "", "Execute this command with /bin/sh -c on connect.")
+	flag.StringVar(&p.source, "s", "", "Source address.")
+	flag.StringVar(&p.port, "p", "", "Source port, or the port to listen on.")
+	flag.BoolVar(&p.verbose, "v", false, "Verbose output.")
+	flag.BoolVar(&p.ssl, "ssl", false, "Use TLS.")
+	flag.StringVar(&p.sslCert, "ssl-cert", "", "PEM certificate file.")
+	flag.StringVar(&p.sslKey, "ssl-key", "", "PEM private key file.")
+	flag.BoolVar(&p.sslVerify, "ssl-verify", false, "Verify the peer's certificate.")
+	flag.StringVar(&p.sslTrustFile, "ssl-trustfile", "", "PEM CA certificates to verify the peer with.")
+	flag.StringVar(&p.sslServerName, "ssl-servername", "", "Server name to verify.")
 	util.Usage(usage)
 }
 
-func main() {
-	var c net.Conn
-	var err error
-	if flag.Parse(); len(flag.Args()) != 1 {
-		flag.Usage()
-		os.Exit(1)
+type cmd struct {
+	params
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// network returns the Go network name.
+func (c *cmd) network() string {
+	n := c.net
+	switch {
+	case c.unix && c.udp:
+		n = "unixgram"
+	case c.unix:
+		n = "unix"
+	case c.udp:
+		n = "udp"
+	}
+	if n == "tcp" || n == "udp" {
+		if c.ipv4 {
+			n += "4"
+		} else if c.ipv6 {
+			n += "6"
+		}
+	}
+	return n
+}
+
+func isUnix(network string) bool {
+	return strings.HasPrefix(network, "unix")
+}
+
+func isPacket(network string) bool {
+	return strings.HasPrefix(network, "udp") || network == "unixgram"
+}
+
+// ports expands a port or a port range such as 20-25.
+func ports(s string) ([]string, error) {
+	lo, hi := s, s
+	if i := strings.Index(s, "-"); i > 0 {
+		lo, hi = s[:i], s[i+1:]
 	}
+	l, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", s)
+	}
+	h, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil || h < l {
+		return nil, fmt.Errorf("invalid port range %q", s)
+	}
+	var ps []string
+	for i := l; i <= h; i++ {
+		ps = append(ps, strconv.FormatUint(i, 10))
+	}
+	return ps, nil
+}
 
-	addr := flag.Args()[0]
+// addresses returns the addresses named by args. Only -z accepts port
+// ranges.
+func (c *cmd) addresses(network string, args []string) ([]string, error) {
+	if isUnix(network) {
+		if len(args) != 1 {
+			return nil, errors.New(usage)
+		}
+		return args, nil
+	}
+
+	var host, port string
+	switch {
+	case len(args) == 2:
+		host, port = args[0], args[1]
+	case len(args) == 1:
+		if _, err := strconv.ParseUint(args[0], 10, 16); err == nil && c.listen {
+			port = args[0]
+		} else if i := strings.LastIndex(args[0], ":"); i >= 0 {
+			host, port = strings.Trim(args[0][:i], "[]"), args[0][i+1:]
+		} else if c.listen && c.port != "" {
+			host, port = args[0], c.port
+		} else {
+			return nil, fmt.Errorf("%q: missing port", args[0])
+		}
+	case len(args) == 0 && c.listen && c.port != "":
+		port = c.port
+	default:
+		return nil, errors.New(usage)
+	}
 
-	if *listen {
-		ln, err := net.Listen(*netType, addr)
+	ps := []string{port}
+	if c.zero {
+		var err error
+		if ps, err = ports(port); err != nil {
+			return nil, err
+		}
+	}
+	addrs := make([]string, len(ps))
+	for i, p := range ps {
+		addrs[i] = net.JoinHostPort(host, p)
+	}
+	return addrs, nil
+}
+
+// localAddr returns the -s and -p source address to dial from, if any.
+func (c *cmd) localAddr(network string) (net.Addr, error) {
+	if c.source == "" && c.port == "" {
+		return nil, nil
+	}
+	switch {
+	case isUnix(network):
+		return net.ResolveUnixAddr(network, c.source)
+	case isPacket(network):
+		return net.ResolveUDPAddr(network, net.JoinHostPort(c.source, c.port))
+	default:
+		return net.ResolveTCPAddr(network, net.JoinHostPort(c.source, c.port))
+	}
+}
+
+func (c *cmd) tlsConfig(server bool, addr string) (*tls.Config, error) {
+	conf := &tls.Config{}
+	switch {
+	case c.sslCert != "":
+		key := c.sslKey
+		if key == "" {
+			key = c.sslCert
+		}
+		cert, err := tls.LoadX509KeyPair(c.sslCert, key)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, err
 		}
-		if *verbose {
-			fmt.Fprintln(os.Stderr, "Listening on", ln.Addr())
+		conf.Certificates = []tls.Certificate{cert}
+	case server:
+		cert, err := selfSigned()
+		if err != nil {
+			return nil, err
 		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
 
-		c, err = ln.Accept()
+	var pool *x509.CertPool
+	if c.sslTrustFile != "" {
+		b, err := os.ReadFile(c.sslTrustFile)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, err
 		}
-	} else {
-		if c, err = net.Dial(*netType, addr); err != nil {
-			log.Fatalln(err)
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %s", c.sslTrustFile)
 		}
 	}
-	if *verbose {
-		fmt.Fprintln(os.Stderr, "Connected to", c.RemoteAddr())
+	verify := c.sslVerify || pool != nil
+	if server {
+		if verify {
+			conf.ClientCAs = pool
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return conf, nil
+	}
+	conf.RootCAs = pool
+	conf.InsecureSkipVerify = !verify
+	conf.ServerName = c.sslServerName
+	if conf.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			conf.ServerName = host
+		}
 	}
+	return conf, nil
+}
 
-	go func() {
-		if _, err := io.Copy(c, os.Stdin); err != nil {
-			fmt.Fprintln(os.Stderr, err)
+// selfSigned returns an ephemeral certificate for listeners without
+// -ssl-cert.
+func selfSigned() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "netcat"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func (c *cmd) dial(network, addr string) (net.Conn, error) {
+	local, err := c.localAddr(network)
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Timeout: c.timeout, LocalAddr: local}
+	conn, err := d.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if !c.ssl {
+		return conn, nil
+	}
+	conf, err := c.tlsConfig(false, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tc := tls.Client(conn, conf)
+	if c.timeout > 0 {
+		tc.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if err := tc.Handshake(); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// scan reports which of addrs accept connections. UDP ports are open
+// unless they answer with ICMP port unreachable.
+func (c *cmd) scan(network string, addrs []string) error {
+	timeout := c.timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	open := 0
+	for _, addr := range addrs {
+		d := net.Dialer{Timeout: timeout}
+		conn, err := d.Dial(network, addr)
+		if err == nil && isPacket(network) {
+			conn.SetDeadline(time.Now().Add(timeout))
+			if _, err = conn.Write(nil); err == nil {
+				_, err = conn.Read(make([]byte, 1))
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					err = nil
+				}
+			}
+			conn.Close()
+		} else if err == nil {
+			conn.Close()
 		}
+		if err != nil {
+			if c.verbose {
+				fmt.Fprintf(c.stderr, "Connection to %s failed: %v\n", addr, err)
+			}
+			continue
+		}
+		open++
+		if c.verbose {
+			fmt.Fprintf(c.stderr, "Connection to %s [%s] succeeded\n", addr, network)
+		}
+	}
+	if open == 0 {
+		return errors.New("no open ports")
+	}
+	return nil
+}
+
+// idleReader ends reads from conn after the -w timeout without data.
+type idleReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r idleReader) Read(b []byte) (int, error) {
+	if r.timeout > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	}
+	n, err := r.conn.Read(b)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// run runs -e or -c with its stdin and stdout connected to conn.
+func (c *cmd) run(conn net.Conn) error {
+	var e *exec.Cmd
+	if c.exec != "" {
+		f := strings.Fields(c.exec)
+		e = exec.Command(f[0], f[1:]...)
+	} else {
+		e = exec.Command("/bin/sh", "-c", c.shell)
+	}
+	e.Stdout, e.Stderr = conn, c.stderr
+	// exec.Cmd would wait for the peer to send something before returning
+	// if conn was Stdin, so copy it ourselves.
+	stdin, err := e.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := e.Start(); err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(stdin, idleReader{conn, c.timeout})
+		stdin.Close()
 	}()
-	if _, err = io.Copy(os.Stdout, c); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	return e.Wait()
+}
+
+// handle copies between conn and stdin/stdout or runs the -e/-c program,
+// then closes conn.
+func (c *cmd) handle(conn net.Conn) error {
+	defer conn.Close()
+	if c.verbose {
+		fmt.Fprintln(c.stderr, "Connected to", conn.RemoteAddr())
+	}
+	var err error
+	if c.exec != "" || c.shell != "" {
+		err = c.run(conn)
+	} else {
+		go func() {
+			if _, err := io.Copy(conn, c.stdin); err != nil {
+				fmt.Fprintln(c.stderr, err)
+				return
+			}
+			// Tell the peer we are done sending.
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+		}()
+		_, err = io.Copy(c.stdout, idleReader{conn, c.timeout})
+	}
+	if c.verbose {
+		fmt.Fprintln(c.stderr, "Disconnected")
+	}
+	return err
+}
+
+// packetConn is a net.Conn talking to the first peer that sent a datagram
+// to a listening packet socket.
+type packetConn struct {
+	net.PacketConn
+	peer    net.Addr
+	pending []byte
+}
+
+func (p *packetConn) Read(b []byte) (int, error) {
+	if p.pending != nil {
+		n := copy(b, p.pending)
+		p.pending = nil
+		return n, nil
+	}
+	for {
+		n, addr, err := p.ReadFrom(b)
+		if err != nil || addr.String() == p.peer.String() {
+			return n, err
+		}
+	}
+}
+
+func (p *packetConn) Write(b []byte) (int, error) {
+	return p.WriteTo(b, p.peer)
+}
+
+func (p *packetConn) RemoteAddr() net.Addr {
+	return p.peer
+}
+
+// servePacket talks to peers sending datagrams to pc, one at a time.
+func (c *cmd) servePacket(pc net.PacketConn) error {
+	for {
+		buf := make([]byte, 65535)
+		n, peer, err := pc.ReadFrom(buf)
+		if err != nil {
+			pc.Close()
+			return err
+		}
+		conn := &packetConn{PacketConn: pc, peer: peer, pending: buf[:n]}
+		if !c.keep {
+			return c.handle(conn)
+		}
+		// Keep the socket open for the next peer.
+		if err := c.handle(nopCloser{conn}); err != nil {
+			fmt.Fprintln(c.stderr, err)
+		}
 	}
-	if *verbose {
-		fmt.Fprintln(os.Stderr, "Disconnected")
+}
+
+// nopCloser keeps handle from closing a shared packet socket.
+type nopCloser struct {
+	net.Conn
+}
+
+func (nopCloser) Close() error { return nil }
+
+func (c *cmd) serve(network, addr string) error {
+	if isPacket(network) {
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		if c.verbose {
+			fmt.Fprintln(c.stderr, "Listening on", pc.LocalAddr())
+		}
+		return c.servePacket(pc)
+	}
+
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	if c.ssl {
+		conf, err := c.tlsConfig(true, addr)
+		if err != nil {
+			return err
+		}
+		ln = tls.NewListener(ln, conf)
+	}
+	if c.verbose {
+		fmt.Fprintln(c.stderr, "Listening on", ln.Addr())
+	}
+	return c.accept(ln)
+}
+
+// accept handles connections accepted from ln.
+func (c *cmd) accept(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		if !c.keep {
+			return c.handle(conn)
+		}
+		// Programs can serve several peers at once; stdin and stdout
+		// only one.
+		if c.exec != "" || c.shell != "" {
+			go func() {
+				if err := c.handle(conn); err != nil {
+					fmt.Fprintln(c.stderr, err)
+				}
+			}()
+		} else if err := c.handle(conn); err != nil {
+			fmt.Fprintln(c.stderr, err)
+		}
+	}
+}
+
+func (c *cmd) main(args []string) error {
+	if c.exec != "" && c.shell != "" {
+		return errors.New("-e and -c are mutually exclusive")
+	}
+	if c.ssl && isPacket(c.network()) {
+		return errors.New("TLS is not supported over datagrams")
+	}
+	network := c.network()
+	addrs, err := c.addresses(network, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.zero:
+		return c.scan(network, addrs)
+	case c.listen:
+		return c.serve(network, addrs[0])
+	}
+	conn, err := c.dial(network, addrs[0])
+	if err != nil {
+		return err
+	}
+	return c.handle(conn)
+}
+
+func main() {
+	flag.Parse()
+	c := &cmd{params: p, stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	if err := c.main(flag.Args()); err != nil {
+		if err.Error() == usage {
+			flag.Usage()
+			os.Exit(1)
+		}
+		log.Fatalln(err)
 	}
 }
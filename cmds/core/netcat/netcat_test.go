@@ -0,0 +1,210 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCmd(p params, stdin string) (*cmd, *bytes.Buffer) {
+	var out bytes.Buffer
+	if p.net == "" {
+		p.net = "tcp"
+	}
+	return &cmd{params: p, stdin: strings.NewReader(stdin), stdout: &out, stderr: io.Discard}, &out
+}
+
+func TestAddresses(t *testing.T) {
+	for _, tt := range []struct {
+		p    params
+		args []string
+		want string
+	}{
+		{params{net: "tcp"}, []string{"localhost:80"}, "localhost:80"},
+		{params{net: "tcp"}, []string{"[::1]:80"}, "[::1]:80"},
+		{params{net: "tcp"}, []string{"localhost", "80"}, "localhost:80"},
+		{params{net: "tcp", listen: true}, []string{"8080"}, ":8080"},
+		{params{net: "tcp", listen: true, port: "8080"}, nil, ":8080"},
+		{params{net: "tcp", listen: true, port: "8080"}, []string{"127.0.0.1"}, "127.0.0.1:8080"},
+		{params{net: "tcp", zero: true}, []string{"localhost", "20-22"}, "localhost:20 localhost:21 localhost:22"},
+		{params{unix: true}, []string{"/tmp/sock"}, "/tmp/sock"},
+	} {
+		c, _ := newCmd(tt.p, "")
+		got, err := c.addresses(c.network(), tt.args)
+		if err != nil {
+			t.Errorf("addresses(%q) = %v", tt.args, err)
+			continue
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("addresses(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{nil, {"localhost"}, {"localhost", "20-10"}, {"a", "b", "c"}} {
+		c, _ := newCmd(params{zero: true}, "")
+		if _, err := c.addresses("tcp", args); err == nil {
+			t.Errorf("addresses(%q) succeeded", args)
+		}
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	for _, tt := range []struct {
+		p    params
+		want string
+	}{
+		{params{net: "tcp"}, "tcp"},
+		{params{net: "tcp", udp: true, ipv6: true}, "udp6"},
+		{params{net: "tcp", ipv4: true}, "tcp4"},
+		{params{net: "tcp", unix: true}, "unix"},
+		{params{net: "tcp", unix: true, udp: true}, "unixgram"},
+	} {
+		c, _ := newCmd(tt.p, "")
+		if got := c.network(); got != tt.want {
+			t.Errorf("network(%+v) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+// echo starts a netcat listener on ln running cat and returns a channel
+// with its result.
+func echo(t *testing.T, p params, ln net.Listener) chan error {
+	t.Helper()
+	p.shell = "cat"
+	s, _ := newCmd(p, "")
+	done := make(chan error, 1)
+	go func() { done <- s.accept(ln) }()
+	return done
+}
+
+func TestTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := echo(t, params{}, ln)
+
+	c, out := newCmd(params{timeout: 5 * time.Second}, "hello\n")
+	if err := c.main([]string{ln.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello\n" {
+		t.Errorf("echo = %q, want %q", out.String(), "hello\n")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("listener: %v", err)
+	}
+}
+
+func TestUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := echo(t, params{}, ln)
+
+	c, out := newCmd(params{unix: true}, "over unix\n")
+	if err := c.main([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "over unix\n" {
+		t.Errorf("echo = %q", out.String())
+	}
+	<-done
+}
+
+func TestTLS(t *testing.T) {
+	s, _ := newCmd(params{ssl: true}, "")
+	conf, err := s.tlsConfig(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := echo(t, params{ssl: true}, tls.NewListener(ln, conf))
+
+	// The self-signed certificate does not verify.
+	c, _ := newCmd(params{ssl: true, sslVerify: true}, "")
+	if err := c.main([]string{ln.Addr().String()}); err == nil {
+		t.Errorf("verified a self-signed certificate")
+	}
+	<-done
+
+	done = echo(t, params{ssl: true}, tls.NewListener(ln, conf))
+	c, out := newCmd(params{ssl: true}, "secret\n")
+	if err := c.main([]string{ln.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "secret\n" {
+		t.Errorf("echo = %q", out.String())
+	}
+	<-done
+}
+
+func TestUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, got := newCmd(params{timeout: 200 * time.Millisecond}, "pong\n")
+	done := make(chan error, 1)
+	go func() { done <- s.servePacket(pc) }()
+
+	// UDP has no end of stream, so both sides stop after -w.
+	c, out := newCmd(params{udp: true, timeout: 500 * time.Millisecond}, "ping\n")
+	if err := c.main([]string{pc.LocalAddr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "ping\n" || out.String() != "pong\n" {
+		t.Errorf("listener got %q, client got %q", got.String(), out.String())
+	}
+}
+
+func TestScan(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// A port that was just open is most likely closed now.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+
+	var log bytes.Buffer
+	c, _ := newCmd(params{zero: true, verbose: true}, "")
+	c.stderr = &log
+	if err := c.main([]string{host, port}); err != nil {
+		t.Errorf("scan of open port: %v", err)
+	}
+	if err := c.main([]string{host, closedPort}); err == nil {
+		t.Errorf("scan of closed port succeeded")
+	}
+	if !strings.Contains(log.String(), "succeeded") || !strings.Contains(log.String(), "failed") {
+		t.Errorf("scan log = %q", log.String())
+	}
+}
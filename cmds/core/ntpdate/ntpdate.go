@@ -8,7 +8,8 @@
 // ntpdate uses NTP to adjust the system clock.
 //
 // Synopsis:
-//     ntpdate [--config=/etc/ntp.conf] [--rtc] [--verbose] [server ...]
+//     ntpdate [--config=/etc/ntp.conf] [--rtc] [--verbose] [--samples=N] [--slew]
+//             [--keys=/etc/ntp.keys --keyid=N] [server ...]
 //
 // Description:
 //     ntpdate queries NTP server(s) for time and update susyem time.
@@ -19,6 +20,14 @@
 //     If servers are specified on the command line, they are tried first.
 //     time.google.com is used as the last resort.
 //
+//     Each server is queried once, or --samples times 2s apart with the
+//     answer with the lowest round trip delay used. Servers that disagree
+//     with the majority are ignored and the offsets of the rest are combined.
+//
+//     With --slew, offsets of up to 0.5s are slewed away gradually instead
+//     of stepping the clock. With --keyid, requests and responses are
+//     authenticated with a symmetric key from --keys.
+//
 // Options:
 //     -w: set hwclock to system clock in UTC
 package main
@@ -34,6 +43,10 @@ var (
 	config  = flag.String("config", ntpdate.DefaultNTPConfig, "NTP config file.")
 	setRTC  = flag.Bool("rtc", false, "Set RTC time as well")
 	verbose = flag.Bool("verbose", false, "Verbose output")
+	samples = flag.Int("samples", 1, "Number of queries per server, 2s apart")
+	slew    = flag.Bool("slew", false, "Slew the clock instead of stepping it if the offset is small")
+	keys    = flag.String("keys", ntpdate.DefaultNTPKeys, "NTP symmetric keys file")
+	keyID   = flag.Uint("keyid", 0, "Authenticate with this key from the keys file. 0 disables authentication")
 )
 
 const (
@@ -45,7 +58,18 @@ func main() {
 	if *verbose {
 		ntpdate.Debug = log.Printf
 	}
-	server, offset, err := ntpdate.SetTime(flag.Args(), *config, fallback, *setRTC)
+	opts := ntpdate.Options{
+		Samples: *samples,
+		Slew:    *slew,
+	}
+	if *keyID != 0 {
+		k, err := ntpdate.ReadKey(*keys, uint32(*keyID))
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		opts.Key = k
+	}
+	server, offset, err := ntpdate.SetTimeWithOptions(flag.Args(), *config, fallback, *setRTC, opts)
 	if err != nil {
 		log.Fatalf("Error: %v", err)
 	}
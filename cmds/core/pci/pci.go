@@ -10,10 +10,27 @@
 // Description:
 //     List the PCI bus, with names if possible.
 //
+//     With -vv, the capabilities are decoded, which needs root to read
+//     the full config space.
+//
+//     The action options act on the devices chosen with -s, instead of
+//     listing them. --rescan without -s rescans all buses.
+//
 // Options:
 //     -n: just show numbers
 //     -c: dump config space
 //     -s: specify glob for choosing devices.
+//     -v: verbosity, -vv decodes capabilities
+//
+// Actions:
+//     --rescan: rescan the buses, or the buses below bridges
+//     --remove: remove the devices
+//     --reset METHOD: reset the devices with flr, bus or pm, or default
+//     --bus-reset: reset the secondary buses of bridges
+//     --unbind: unbind the devices from their drivers
+//     --override DRIVER: only bind the devices to DRIVER, none to clear
+//     --bind DRIVER: bind the devices to DRIVER
+//     --sriov-numvfs N: enable N SR-IOV virtual functions
 package main
 
 import (
@@ -106,6 +123,87 @@ func registers(d pci.Devices, cmds ...string) {
 	}
 }
 
+// actions are what the action options do to the devices.
+type actions struct {
+	rescan   bool
+	remove   bool
+	reset    string
+	busReset bool
+	unbind   bool
+	override string
+	bind     string
+	numVFs   int
+}
+
+func (a *actions) any() bool {
+	return a.rescan || a.remove || a.reset != "" || a.busReset || a.unbind || a.override != "" || a.bind != "" || a.numVFs >= 0
+}
+
+// do does the actions to each device, in the order that makes sense when
+// several are given: e.g. drivers are swapped before a reset, and devices
+// are removed before a rescan.
+func (a *actions) do(d pci.Devices) error {
+	for _, p := range d {
+		if a.unbind {
+			if err := p.Unbind(); err != nil {
+				return err
+			}
+		}
+		if a.override != "" {
+			drv := a.override
+			if drv == "none" {
+				drv = ""
+			}
+			if err := p.SetDriverOverride(drv); err != nil {
+				return err
+			}
+			if err := p.Probe(); err != nil {
+				return err
+			}
+		}
+		if a.bind != "" {
+			if err := p.Bind(a.bind); err != nil {
+				return err
+			}
+		}
+		if a.reset != "" {
+			m := a.reset
+			if m == "default" {
+				m = ""
+			}
+			if err := p.Reset(m); err != nil {
+				return err
+			}
+		}
+		if a.busReset {
+			if err := p.SecondaryBusReset(); err != nil {
+				return err
+			}
+		}
+		if a.numVFs >= 0 {
+			if err := p.SetNumVFs(a.numVFs); err != nil {
+				return err
+			}
+		}
+		if a.remove {
+			if err := p.Remove(); err != nil {
+				return err
+			}
+		}
+	}
+	if a.rescan {
+		if a.remove {
+			return pci.Rescan()
+		}
+		for _, p := range d {
+			if err := p.Rescan(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func main() {
 	var dumpSize int
 	numbers := flag.Bool('n', "Show numeric IDs")
@@ -114,6 +212,16 @@ func main() {
 	v := flag.Counter('v', "verbosity")
 	x := flag.Counter('x', "hexdump the config space")
 	readJSON := flag.StringLong("JSON", 'J', "", "Read JSON in instead of /sys")
+	var a actions
+	flag.FlagLong(&a.rescan, "rescan", 0, "Rescan the buses, or the buses below the selected bridges")
+	flag.FlagLong(&a.remove, "remove", 0, "Remove the selected devices")
+	flag.FlagLong(&a.reset, "reset", 0, "Reset the selected devices with flr, bus, pm or default", "METHOD")
+	flag.FlagLong(&a.busReset, "bus-reset", 0, "Reset the secondary buses of the selected bridges")
+	flag.FlagLong(&a.unbind, "unbind", 0, "Unbind the selected devices from their drivers")
+	flag.FlagLong(&a.override, "override", 0, "Only bind the selected devices to DRIVER, none to clear", "DRIVER")
+	flag.FlagLong(&a.bind, "bind", 0, "Bind the selected devices to DRIVER", "DRIVER")
+	a.numVFs = -1
+	flag.FlagLong(&a.numVFs, "sriov-numvfs", 0, "Enable N SR-IOV virtual functions of the selected devices", "N")
 
 	flag.Parse()
 
@@ -132,6 +240,28 @@ func main() {
 		log.Fatalf("%v", err)
 	}
 
+	if a.any() {
+		// Acting on all devices is never what anyone wants, except to
+		// rescan.
+		if !flag.IsSet("select") {
+			if a != (actions{rescan: true, numVFs: -1}) {
+				log.Fatal("Choose the devices to act on with -s")
+			}
+			if err := pci.Rescan(); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		d, err := r.Read()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := a.do(d); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var d pci.Devices
 	if len(*readJSON) != 0 {
 		b, err := os.ReadFile(*readJSON)
@@ -0,0 +1,220 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// cryptsetup opens and closes LUKS encrypted volumes with dm-crypt.
+//
+// Synopsis:
+//     cryptsetup open [-readonly] [-key-file FILE] [-key-slot N] DEVICE NAME
+//         Unlock DEVICE and map it to /dev/mapper/NAME.
+//     cryptsetup close NAME
+//         Remove the mapping NAME.
+//     cryptsetup status NAME
+//         Show the mapping NAME.
+//     cryptsetup luksDump DEVICE
+//         Show the LUKS header of DEVICE.
+//     cryptsetup isLuks DEVICE
+//         Exit with status 0 if DEVICE is a LUKS volume.
+//
+// Description:
+//     The passphrase is read from the key file, prompted for on a
+//     terminal, or else read from the first line of stdin. A key file of
+//     "-" is stdin. DEVICE must be a block device; use losetup for files.
+//
+// Options:
+//     -readonly: map the volume read-only
+//     -key-file: read the passphrase from FILE
+//     -key-slot: only try keyslot N
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/luks"
+	"github.com/u-root/u-root/pkg/mount/dm"
+	"golang.org/x/term"
+)
+
+const usage = `usage:
+  cryptsetup open [-readonly] [-key-file FILE] [-key-slot N] DEVICE NAME
+  cryptsetup close NAME
+  cryptsetup status NAME
+  cryptsetup luksDump DEVICE
+  cryptsetup isLuks DEVICE`
+
+var errUsage = errors.New(usage)
+
+// errNotLUKS makes isLuks exit with status 1 without printing anything.
+var errNotLUKS = errors.New("not a LUKS device")
+
+type cmd struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// passphrase reads the passphrase from keyFile, a terminal or stdin.
+func (c *cmd) passphrase(keyFile, device string) ([]byte, error) {
+	if keyFile != "" && keyFile != "-" {
+		// Key files are used whole, like cryptsetup does.
+		return os.ReadFile(keyFile)
+	}
+	if f, ok := c.stdin.(*os.File); ok && keyFile == "" && term.IsTerminal(int(f.Fd())) {
+		fmt.Fprintf(c.stderr, "Enter passphrase for %s: ", device)
+		p, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(c.stderr)
+		return p, err
+	}
+	p, err := bufio.NewReader(c.stdin).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return bytes.TrimSuffix(p, []byte("\n")), nil
+}
+
+func (c *cmd) open(args []string) error {
+	fs := flag.NewFlagSet("cryptsetup open", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	var (
+		readOnly = fs.Bool("readonly", false, "map the volume read-only")
+		keyFile  = fs.String("key-file", "", "read the passphrase from `FILE`")
+		keySlot  = fs.Int("key-slot", -1, "only try keyslot `N`")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	device, name := fs.Arg(0), fs.Arg(1)
+
+	pass, err := c.passphrase(*keyFile, device)
+	if err != nil {
+		return err
+	}
+	var path string
+	if *keySlot < 0 {
+		path, err = luks.Open(device, name, pass, *readOnly)
+	} else {
+		path, err = luks.OpenKeyslot(device, name, *keySlot, pass, *readOnly)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, path)
+	return nil
+}
+
+func (c *cmd) status(name string) error {
+	s, err := luks.GetStatus(name)
+	if err != nil {
+		return err
+	}
+	mode := "read/write"
+	if s.ReadOnly {
+		mode = "readonly"
+	}
+	fmt.Fprintf(c.stdout, "%s is active.\n", dm.DevPath(name))
+	fmt.Fprintf(c.stdout, "  cipher:  %s\n", s.Cipher)
+	fmt.Fprintf(c.stdout, "  keysize: %d bits\n", s.KeySize*8)
+	fmt.Fprintf(c.stdout, "  device:  %s\n", s.Device)
+	fmt.Fprintf(c.stdout, "  offset:  %d sectors\n", s.Offset)
+	fmt.Fprintf(c.stdout, "  size:    %d sectors\n", s.Size)
+	fmt.Fprintf(c.stdout, "  mode:    %s\n", mode)
+	return nil
+}
+
+func readHeader(device string) (*luks.Header, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return luks.ReadHeader(f)
+}
+
+func (c *cmd) dump(device string) error {
+	h, err := readHeader(device)
+	if err != nil {
+		return fmt.Errorf("%s: %w", device, err)
+	}
+	fmt.Fprintf(c.stdout, "LUKS header information for %s\n\n", device)
+	fmt.Fprintf(c.stdout, "Version:       \t%d\n", h.Version)
+	fmt.Fprintf(c.stdout, "UUID:          \t%s\n", h.UUID)
+	if h.Label != "" {
+		fmt.Fprintf(c.stdout, "Label:         \t%s\n", h.Label)
+	}
+	fmt.Fprintf(c.stdout, "Cipher:        \t%s\n", h.Cipher)
+	fmt.Fprintf(c.stdout, "Key size:      \t%d bits\n", h.KeySize*8)
+	fmt.Fprintf(c.stdout, "Sector size:   \t%d\n", h.SectorSize)
+	fmt.Fprintf(c.stdout, "Payload offset:\t%d\n", h.PayloadOffset)
+	if h.PayloadSize == 0 {
+		fmt.Fprintf(c.stdout, "Payload size:  \tdynamic\n")
+	} else {
+		fmt.Fprintf(c.stdout, "Payload size:  \t%d\n", h.PayloadSize)
+	}
+	fmt.Fprintf(c.stdout, "\nKeyslots:\n")
+	for _, k := range h.Keyslots {
+		fmt.Fprintf(c.stdout, "  %d: priority %d, %s, AF stripes %d, %s\n", k.ID, k.Priority, k.Cipher, k.Stripes, k.AFHash)
+		switch k.KDF.Type {
+		case "pbkdf2":
+			fmt.Fprintf(c.stdout, "\tKDF:        pbkdf2-%s, %d iterations\n", k.KDF.Hash, k.KDF.Iterations)
+		default:
+			fmt.Fprintf(c.stdout, "\tKDF:        %s, time %d, memory %d KiB, %d threads\n", k.KDF.Type, k.KDF.Time, k.KDF.Memory, k.KDF.CPUs)
+		}
+		fmt.Fprintf(c.stdout, "\tArea:       %d bytes at %d\n", k.Size, k.Offset)
+	}
+	return nil
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch op, args := args[0], args[1:]; op {
+	case "open", "luksOpen":
+		return c.open(args)
+	case "close", "luksClose":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return luks.Close(args[0])
+	case "status":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return c.status(args[0])
+	case "luksDump":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return c.dump(args[0])
+	case "isLuks":
+		if len(args) != 1 {
+			return errUsage
+		}
+		if _, err := readHeader(args[0]); err != nil {
+			return errNotLUKS
+		}
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+func main() {
+	c := &cmd{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	if err := c.run(os.Args[1:]); err != nil {
+		if err == errNotLUKS {
+			os.Exit(1)
+		}
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		want []string
+		err  error
+	}{
+		{
+			name: "dump LUKS1",
+			args: []string{"luksDump", "../../../pkg/luks/testdata/luks1.img"},
+			want: []string{"Version:       \t1", "Cipher:        \taes-xts-plain64", "Key size:      \t256 bits", "  3: priority 1", "pbkdf2-sha256, 1000 iterations"},
+		},
+		{
+			name: "dump LUKS2",
+			args: []string{"luksDump", "../../../pkg/luks/testdata/luks2.img"},
+			want: []string{"Version:       \t2", "Label:         \ttest", "Payload size:  \tdynamic", "argon2id, time 4, memory 32 KiB, 1 threads"},
+		},
+		{
+			name: "isLuks",
+			args: []string{"isLuks", "../../../pkg/luks/testdata/luks2.img"},
+		},
+		{
+			name: "not LUKS",
+			args: []string{"isLuks", "cryptsetup_linux.go"},
+			err:  errNotLUKS,
+		},
+		{
+			name: "no args",
+			err:  errUsage,
+		},
+		{
+			name: "open without name",
+			args: []string{"open", "/dev/sda2"},
+			err:  errUsage,
+		},
+		{
+			name: "unknown",
+			args: []string{"format", "/dev/sda2"},
+			err:  errUsage,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			c := &cmd{stdin: strings.NewReader(""), stdout: &stdout, stderr: &stderr}
+			if err := c.run(tt.args); err != tt.err {
+				t.Fatalf("run(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout.String(), w) {
+					t.Errorf("output does not contain %q:\n%s", w, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	c := &cmd{stdin: strings.NewReader("secret\nignored\n")}
+	p, err := c.passphrase("", "/dev/sda2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "secret" {
+		t.Errorf("passphrase = %q, want secret", p)
+	}
+}
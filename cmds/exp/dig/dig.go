@@ -0,0 +1,225 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// dig queries DNS servers.
+//
+// Synopsis:
+//     dig [@SERVER] [-p PORT] [-t TYPE] [-x ADDR] [+tcp] [+trace] [+short] [+norecurse] [NAME] [TYPE]
+//
+// Description:
+//     dig sends a query for NAME to SERVER, or to the first nameserver in
+//     /etc/resolv.conf, and prints the response. UDP responses that are
+//     truncated are retried over TCP.
+//
+// Options:
+//     @SERVER:    server to query
+//     -p:         server port (default 53)
+//     -t:         query type: A, AAAA, CNAME, MX, NS, PTR, SOA, SRV, TXT or ANY (default A)
+//     -x:         reverse lookup of ADDR, a PTR query
+//     +tcp:       only use TCP
+//     +trace:     resolve iteratively from the root servers, printing each step
+//     +short:     only print the answer data
+//     +norecurse: do not ask the server to recurse
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dns"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const usage = "dig [@server] [-p port] [-t type] [-x addr] [+tcp] [+trace] [+short] [+norecurse] [name] [type]"
+
+var errUsage = errors.New(usage)
+
+type cmd struct {
+	server string
+	name   string
+	t      dnsmessage.Type
+	trace  bool
+	short  bool
+	client dns.Client
+}
+
+// parse parses dig's arguments, which mix @server, -flags, +options, a
+// name and a type in any order.
+func parse(args []string) (*cmd, error) {
+	c := &cmd{
+		t:      dnsmessage.TypeA,
+		client: dns.Client{Timeout: dns.DefaultTimeout},
+	}
+	typeSet := false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case strings.HasPrefix(a, "@"):
+			c.server = a[1:]
+		case strings.HasPrefix(a, "+"):
+			switch a[1:] {
+			case "tcp", "vc":
+				c.client.TCP = true
+			case "trace":
+				c.trace = true
+			case "short":
+				c.short = true
+			case "norecurse", "norec":
+				c.client.NoRecursion = true
+			default:
+				return nil, fmt.Errorf("unknown option %q: %w", a, errUsage)
+			}
+		case a == "-p" || a == "-t" || a == "-x":
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("%s needs an argument: %w", a, errUsage)
+			}
+			i++
+			switch a {
+			case "-p":
+				c.client.Port = args[i]
+			case "-t":
+				t, err := dns.ParseType(args[i])
+				if err != nil {
+					return nil, err
+				}
+				c.t, typeSet = t, true
+			case "-x":
+				ip := net.ParseIP(args[i])
+				if ip == nil {
+					return nil, fmt.Errorf("invalid address %q", args[i])
+				}
+				n, err := dns.ReverseName(ip)
+				if err != nil {
+					return nil, err
+				}
+				c.name = n
+				if !typeSet {
+					c.t = dnsmessage.TypePTR
+				}
+			}
+		case strings.HasPrefix(a, "-"):
+			return nil, fmt.Errorf("unknown flag %q: %w", a, errUsage)
+		default:
+			// The type may follow the name, as in "dig example.com MX".
+			if t, err := dns.ParseType(a); err == nil && c.name != "" && !typeSet {
+				c.t, typeSet = t, true
+				continue
+			}
+			if c.name != "" {
+				return nil, fmt.Errorf("extra argument %q: %w", a, errUsage)
+			}
+			c.name = a
+		}
+	}
+	if c.name == "" {
+		// Like dig, ask for the root servers.
+		c.name = "."
+		if !typeSet {
+			c.t = dnsmessage.TypeNS
+		}
+	}
+	return c, nil
+}
+
+func (c *cmd) run(w io.Writer) error {
+	ctx := context.Background()
+	if c.trace {
+		roots := dns.RootServers
+		if c.server != "" {
+			roots = []string{c.server}
+		}
+		steps, err := c.client.Trace(ctx, c.name, c.t, roots)
+		for _, s := range steps {
+			c.print(w, s.Server, s.Response, 0)
+		}
+		return err
+	}
+
+	server := c.server
+	if server == "" {
+		conf, err := dns.ReadResolvConf(dns.ResolvConf)
+		if err != nil {
+			return err
+		}
+		if len(conf.Nameservers) == 0 {
+			return fmt.Errorf("no nameservers in %s", dns.ResolvConf)
+		}
+		server = conf.Nameservers[0]
+	}
+	start := time.Now()
+	r, err := c.client.Query(ctx, c.name, c.t, server)
+	if err != nil {
+		return err
+	}
+	c.print(w, server, r, time.Since(start))
+	return nil
+}
+
+func (c *cmd) print(w io.Writer, server string, r *dnsmessage.Message, rtt time.Duration) {
+	if c.short {
+		for _, a := range r.Answers {
+			fmt.Fprintln(w, dns.FormatBody(a.Body))
+		}
+		return
+	}
+	fmt.Fprintf(w, ";; ->>HEADER<<- status: %s, id: %d\n", dns.RCodeString(r.RCode), r.ID)
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{r.Response, "qr"},
+		{r.Authoritative, "aa"},
+		{r.Truncated, "tc"},
+		{r.RecursionDesired, "rd"},
+		{r.RecursionAvailable, "ra"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	fmt.Fprintf(w, ";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		strings.Join(flags, " "), len(r.Questions), len(r.Answers), len(r.Authorities), len(r.Additionals))
+	for _, s := range []struct {
+		name string
+		rs   []dnsmessage.Resource
+	}{
+		{"ANSWER", r.Answers},
+		{"AUTHORITY", r.Authorities},
+		{"ADDITIONAL", r.Additionals},
+	} {
+		var lines []string
+		for _, rr := range s.rs {
+			if rr.Header.Type != dnsmessage.TypeOPT {
+				lines = append(lines, dns.FormatResource(rr))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n;; %s SECTION:\n%s\n", s.name, strings.Join(lines, "\n"))
+	}
+	fmt.Fprintln(w)
+	if rtt > 0 {
+		fmt.Fprintf(w, ";; Query time: %v\n", rtt.Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, ";; SERVER: %s\n\n", server)
+}
+
+func main() {
+	c, err := parse(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := c.run(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dns"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		server string
+		name   string
+		t      dnsmessage.Type
+		tcp    bool
+		trace  bool
+	}{
+		{[]string{"example.com"}, "", "example.com", dnsmessage.TypeA, false, false},
+		{[]string{"@192.0.2.53", "example.com", "AAAA"}, "192.0.2.53", "example.com", dnsmessage.TypeAAAA, false, false},
+		{[]string{"-t", "srv", "_http._tcp.example.com", "+tcp"}, "", "_http._tcp.example.com", dnsmessage.TypeSRV, true, false},
+		{[]string{"+trace", "example.com", "txt"}, "", "example.com", dnsmessage.TypeTXT, false, true},
+		{[]string{"-x", "192.0.2.1"}, "", "1.2.0.192.in-addr.arpa.", dnsmessage.TypePTR, false, false},
+		{nil, "", ".", dnsmessage.TypeNS, false, false},
+	} {
+		c, err := parse(tt.args)
+		if err != nil {
+			t.Errorf("parse(%q) = %v", tt.args, err)
+			continue
+		}
+		if c.server != tt.server || c.name != tt.name || c.t != tt.t || c.client.TCP != tt.tcp || c.trace != tt.trace {
+			t.Errorf("parse(%q) = %+v", tt.args, c)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"+bogus", "example.com"},
+		{"-q"},
+		{"-t"},
+		{"a.example", "b.example"},
+	} {
+		if _, err := parse(args); !errors.Is(err, errUsage) {
+			t.Errorf("parse(%q) = %v, want usage error", args, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	name := dnsmessage.MustNewName("www.example.com.")
+	q := dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+
+	// A stub resolver without upstreams, answering from its cache.
+	s := dns.NewServer(nil)
+	s.Cache.Put(q, &dnsmessage.Message{
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		}},
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go s.ServeUDP(pc)
+
+	c, err := parse([]string{"@" + pc.LocalAddr().String(), "www.example.com", "+short"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := c.run(&out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "192.0.2.1\n"; got != want {
+		t.Errorf("dig +short = %q, want %q", got, want)
+	}
+
+	c.short = false
+	out.Reset()
+	if err := c.run(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("status: NOERROR")) || !bytes.Contains(out.Bytes(), []byte("www.example.com.\t")) {
+		t.Errorf("dig output missing status or answer:\n%s", out.String())
+	}
+}
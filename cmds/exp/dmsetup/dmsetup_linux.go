@@ -0,0 +1,339 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// dmsetup manages device-mapper devices.
+//
+// Synopsis:
+//     dmsetup create [-r] [-u UUID] [-table TABLE] NAME [TABLE_FILE]
+//     dmsetup load [-r] [-table TABLE] NAME [TABLE_FILE]
+//     dmsetup remove NAME...
+//     dmsetup remove_all
+//     dmsetup suspend NAME...
+//     dmsetup resume NAME...
+//     dmsetup rename NAME NEW_NAME
+//     dmsetup clear NAME
+//     dmsetup message NAME SECTOR MESSAGE...
+//     dmsetup info [NAME...]
+//     dmsetup ls
+//     dmsetup table [NAME...]
+//     dmsetup status [NAME...]
+//     dmsetup deps NAME...
+//     dmsetup targets
+//     dmsetup version
+//     dmsetup mknodes
+//
+// Description:
+//     Tables have one target per line: START LENGTH TYPE PARAMS. They are
+//     read from -table, TABLE_FILE or stdin. create loads the table and
+//     makes it live.
+//
+// Options:
+//     -r: read-only device
+//     -u: device UUID
+//     -table: the table, with targets separated by ; or newlines
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/mount/dm"
+)
+
+const usage = `usage:
+  dmsetup create [-r] [-u UUID] [-table TABLE] NAME [TABLE_FILE]
+  dmsetup load [-r] [-table TABLE] NAME [TABLE_FILE]
+  dmsetup remove NAME...
+  dmsetup remove_all
+  dmsetup suspend NAME...
+  dmsetup resume NAME...
+  dmsetup rename NAME NEW_NAME
+  dmsetup clear NAME
+  dmsetup message NAME SECTOR MESSAGE...
+  dmsetup info [NAME...]
+  dmsetup ls
+  dmsetup table [NAME...]
+  dmsetup status [NAME...]
+  dmsetup deps NAME...
+  dmsetup targets
+  dmsetup version
+  dmsetup mknodes`
+
+var errUsage = errors.New(usage)
+
+type cmd struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// tableArgs are the arguments of create and load.
+type tableArgs struct {
+	name     string
+	uuid     string
+	readOnly bool
+	targets  []dm.Target
+}
+
+func (c *cmd) parseTable(op string, args []string) (*tableArgs, error) {
+	fs := flag.NewFlagSet("dmsetup "+op, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	var (
+		readOnly = fs.Bool("r", false, "read-only device")
+		uuid     = fs.String("u", "", "device `UUID`")
+		table    = fs.String("table", "", "the `TABLE`, with targets separated by ; or newlines")
+	)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || (*table != "" && fs.NArg() == 2) || (op == "load" && *uuid != "") {
+		return nil, errUsage
+	}
+
+	r := c.stdin
+	switch {
+	case *table != "":
+		r = strings.NewReader(strings.ReplaceAll(*table, ";", "\n"))
+	case fs.NArg() == 2:
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	ts, err := dm.ParseTable(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(ts) == 0 {
+		return nil, errors.New("empty table")
+	}
+	return &tableArgs{name: fs.Arg(0), uuid: *uuid, readOnly: *readOnly, targets: ts}, nil
+}
+
+func (c *cmd) info(i *dm.Info) {
+	state := "ACTIVE"
+	if i.Suspended {
+		state = "SUSPENDED"
+	}
+	mode := "read-write"
+	if i.ReadOnly {
+		mode = "readonly"
+	}
+	var tables []string
+	if i.LiveTable {
+		tables = append(tables, "LIVE")
+	}
+	if i.InactiveTable {
+		tables = append(tables, "INACTIVE")
+	}
+	if len(tables) == 0 {
+		tables = append(tables, "None")
+	}
+	fmt.Fprintf(c.stdout, "Name:              %s\n", i.Name)
+	fmt.Fprintf(c.stdout, "State:             %s\n", state)
+	fmt.Fprintf(c.stdout, "Read Ahead:        %s\n", mode)
+	fmt.Fprintf(c.stdout, "Tables present:    %s\n", strings.Join(tables, " & "))
+	fmt.Fprintf(c.stdout, "Open count:        %d\n", i.OpenCount)
+	fmt.Fprintf(c.stdout, "Event number:      %d\n", i.EventNr)
+	fmt.Fprintf(c.stdout, "Major, minor:      %d, %d\n", i.Major, i.Minor)
+	fmt.Fprintf(c.stdout, "Number of targets: %d\n", i.TargetCount)
+	if i.UUID != "" {
+		fmt.Fprintf(c.stdout, "UUID: %s\n", i.UUID)
+	}
+}
+
+// names returns args, or all devices if there are none.
+func names(ctl *dm.Control, args []string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	ds, err := ctl.List()
+	if err != nil {
+		return nil, err
+	}
+	var n []string
+	for _, d := range ds {
+		n = append(n, d.Name)
+	}
+	return n, nil
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	op, args := args[0], args[1:]
+
+	// Check usage before touching the control device.
+	nargs := map[string][2]int{
+		"remove":     {1, -1},
+		"remove_all": {0, 0},
+		"suspend":    {1, -1},
+		"resume":     {1, -1},
+		"rename":     {2, 2},
+		"clear":      {1, 1},
+		"message":    {3, -1},
+		"info":       {0, -1},
+		"ls":         {0, 0},
+		"table":      {0, -1},
+		"status":     {0, -1},
+		"deps":       {1, -1},
+		"targets":    {0, 0},
+		"version":    {0, 0},
+		"mknodes":    {0, 0},
+	}
+	var ta *tableArgs
+	switch op {
+	case "create", "load":
+		var err error
+		if ta, err = c.parseTable(op, args); err != nil {
+			return err
+		}
+	default:
+		n, ok := nargs[op]
+		if !ok || len(args) < n[0] || (n[1] >= 0 && len(args) > n[1]) {
+			return errUsage
+		}
+	}
+
+	switch op {
+	case "create":
+		_, err := dm.CreateDevice(ta.name, ta.uuid, ta.targets, ta.readOnly)
+		return err
+	case "remove":
+		for _, n := range args {
+			if err := dm.RemoveDevice(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "mknodes":
+		return dm.MkNodes()
+	}
+
+	ctl, err := dm.Open()
+	if err != nil {
+		return err
+	}
+	defer ctl.Close()
+
+	switch op {
+	case "load":
+		return ctl.Load(ta.name, ta.targets, ta.readOnly)
+	case "remove_all":
+		return ctl.RemoveAll()
+	case "suspend", "resume":
+		for _, n := range args {
+			f := ctl.Resume
+			if op == "suspend" {
+				f = ctl.Suspend
+			}
+			if _, err := f(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "rename":
+		return ctl.Rename(args[0], args[1])
+	case "clear":
+		return ctl.Clear(args[0])
+	case "message":
+		sector, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid sector %q", args[1])
+		}
+		return ctl.Message(args[0], sector, strings.Join(args[2:], " "))
+	case "ls":
+		ds, err := ctl.List()
+		if err != nil {
+			return err
+		}
+		if len(ds) == 0 {
+			fmt.Fprintln(c.stdout, "No devices found")
+		}
+		for _, d := range ds {
+			fmt.Fprintf(c.stdout, "%s\t(%d:%d)\n", d.Name, d.Major, d.Minor)
+		}
+		return nil
+	case "targets":
+		vs, err := ctl.Targets()
+		if err != nil {
+			return err
+		}
+		for _, v := range vs {
+			fmt.Fprintf(c.stdout, "%-16s v%d.%d.%d\n", v.Name, v.Version[0], v.Version[1], v.Version[2])
+		}
+		return nil
+	case "version":
+		v, err := ctl.Version()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "Driver version:    %d.%d.%d\n", v[0], v[1], v[2])
+		return nil
+	case "deps":
+		for _, n := range args {
+			ds, err := ctl.Deps(n)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(c.stdout, "%s: %d dependencies\t:", n, len(ds))
+			for _, d := range ds {
+				fmt.Fprintf(c.stdout, " (%d, %d)", d.Major, d.Minor)
+			}
+			fmt.Fprintln(c.stdout)
+		}
+		return nil
+	}
+
+	// info, table and status default to all devices.
+	ns, err := names(ctl, args)
+	if err != nil {
+		return err
+	}
+	for i, n := range ns {
+		switch op {
+		case "info":
+			in, err := ctl.Info(n)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				fmt.Fprintln(c.stdout)
+			}
+			c.info(in)
+		case "table", "status":
+			f := ctl.Table
+			if op == "status" {
+				f = ctl.Status
+			}
+			_, ts, err := f(n)
+			if err != nil {
+				return err
+			}
+			for _, t := range ts {
+				if len(args) == 0 {
+					fmt.Fprintf(c.stdout, "%s: ", n)
+				}
+				fmt.Fprintln(c.stdout, t)
+			}
+		}
+	}
+	return nil
+}
+
+func main() {
+	c := &cmd{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
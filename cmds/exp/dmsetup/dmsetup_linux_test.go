@@ -0,0 +1,81 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/mount/dm"
+)
+
+func TestParseTable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "table")
+	if err := os.WriteFile(file, []byte("0 100 linear /dev/sda1 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	linear := []dm.Target{dm.Linear(0, 100, "/dev/sda1", 0)}
+	for _, tt := range []struct {
+		op    string
+		args  []string
+		stdin string
+		want  *tableArgs
+	}{
+		{
+			op:   "create",
+			args: []string{"-r", "-u", "LVM-1234", "-table", "0 100 linear /dev/sda1 0;100 50 zero", "root"},
+			want: &tableArgs{name: "root", uuid: "LVM-1234", readOnly: true, targets: append(linear, dm.Zero(100, 50))},
+		},
+		{
+			op:   "create",
+			args: []string{"root", file},
+			want: &tableArgs{name: "root", targets: linear},
+		},
+		{
+			op:    "load",
+			args:  []string{"root"},
+			stdin: "0 100 linear /dev/sda1 0\n",
+			want:  &tableArgs{name: "root", targets: linear},
+		},
+	} {
+		c := &cmd{stdin: strings.NewReader(tt.stdin), stderr: &bytes.Buffer{}}
+		got, err := c.parseTable(tt.op, tt.args)
+		if err != nil {
+			t.Errorf("parseTable(%q, %q) = %v", tt.op, tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTable(%q, %q) = %+v, want %+v", tt.op, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"frobnicate"},
+		{"rename", "root"},
+		{"remove"},
+		{"ls", "root"},
+		{"message", "root", "0"},
+		{"create"},
+		{"create", "-table", "0 1 zero", "root", "file"},
+		{"load", "-u", "uuid", "-table", "0 1 zero", "root"},
+	} {
+		c := &cmd{stdin: strings.NewReader(""), stderr: &bytes.Buffer{}}
+		if err := c.run(args); err != errUsage {
+			t.Errorf("run(%q) = %v, want usage", args, err)
+		}
+	}
+
+	c := &cmd{stdin: strings.NewReader("\n"), stderr: &bytes.Buffer{}}
+	if err := c.run([]string{"create", "root"}); err == nil || err == errUsage {
+		t.Errorf("run(create with empty table) = %v, want empty table error", err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// dnsd is a caching stub DNS resolver.
+//
+// Synopsis:
+//     dnsd [-addr ADDR] [-upstreams FILE] [-cache SIZE] [-v] [SERVER...]
+//
+// Description:
+//     dnsd answers recursive queries on ADDR from its cache, forwarding
+//     misses to the upstream SERVERs in order. Without SERVERs, the
+//     upstreams are the nameservers in FILE, which is re-read when it
+//     changes. `dhclient -dns-stub 127.0.0.53` writes DHCP nameservers to
+//     FILE and points /etc/resolv.conf at dnsd.
+//
+// Options:
+//     -addr:      address to listen on (default 127.0.0.53:53)
+//     -upstreams: resolv.conf listing upstream servers (default /etc/resolv.conf.upstream)
+//     -cache:     number of cached responses (default 1024)
+//     -v:         log failed queries
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dns"
+)
+
+var (
+	addr      = flag.String("addr", net.JoinHostPort(dns.StubAddr, dns.DefaultPort), "Address to listen on")
+	upstreams = flag.String("upstreams", dns.UpstreamConf, "resolv.conf listing upstream servers, used when none are given as arguments")
+	cacheSize = flag.Int("cache", dns.DefaultCacheSize, "Number of cached responses")
+	verbose   = flag.Bool("v", false, "Log failed queries")
+)
+
+// pollInterval is how often the upstreams file is checked for changes.
+const pollInterval = 2 * time.Second
+
+// watch keeps s's upstreams in sync with the resolv.conf at path.
+func watch(s *dns.Server, path string) {
+	var last time.Time
+	for ; ; time.Sleep(pollInterval) {
+		fi, err := os.Stat(path)
+		if err != nil || fi.ModTime().Equal(last) {
+			continue
+		}
+		last = fi.ModTime()
+		c, err := dns.ReadResolvConf(path)
+		if err != nil {
+			log.Printf("Reading upstreams: %v", err)
+			continue
+		}
+		log.Printf("Upstream servers: %v", c.Nameservers)
+		s.SetUpstreams(c.Nameservers)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	s := dns.NewServer(flag.Args())
+	s.Cache = dns.NewCache(*cacheSize)
+	if *verbose {
+		s.Logf = log.Printf
+	}
+	if flag.NArg() == 0 {
+		go watch(s, *upstreams)
+	}
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(s.ListenAndServe(*addr))
+}
@@ -0,0 +1,485 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// gpio lists, reads, sets and monitors GPIO lines through the GPIO
+// character devices.
+//
+// Synopsis:
+//     gpio detect
+//         List GPIO chips.
+//     gpio info [CHIP...]
+//         List the lines of the chips, or of all chips.
+//     gpio get [-c CHIP] [-active-low] [-bias BIAS] LINE...
+//         Read the values of input lines.
+//     gpio set [-c CHIP] [-active-low] [-bias BIAS] [-drive DRIVE] [-hold DURATION] LINE=VALUE...
+//         Set the values of output lines.
+//     gpio mon [-c CHIP] [-active-low] [-bias BIAS] [-edge EDGE] [-debounce DURATION] [-n COUNT] LINE...
+//         Print the edge events of input lines.
+//
+// Description:
+//     CHIP is a path such as /dev/gpiochip0, a name such as gpiochip0, a
+//     number or a label. LINE is an offset on the chip given with -c, or
+//     the name of a line. Without -c, the lines are looked up by name on
+//     all chips, and they have to be on the same one. VALUE is 0, 1, low
+//     or high.
+//
+//     The values of set are held until gpio exits, after -hold. The kernel
+//     may reset the lines after they are released.
+//
+// Options:
+//     -c: the chip of the lines
+//     -active-low: invert the values
+//     -bias: as-is, pull-up, pull-down or disabled
+//     -drive: push-pull, open-drain or open-source
+//     -hold: how long to hold the values of set
+//     -edge: both, rising or falling
+//     -debounce: debounce period of the inputs
+//     -n: exit after COUNT events
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/gpio"
+)
+
+const usage = `usage:
+  gpio detect
+  gpio info [CHIP...]
+  gpio get [-c CHIP] [-active-low] [-bias BIAS] LINE...
+  gpio set [-c CHIP] [-active-low] [-bias BIAS] [-drive DRIVE] [-hold DURATION] LINE=VALUE...
+  gpio mon [-c CHIP] [-active-low] [-bias BIAS] [-edge EDGE] [-debounce DURATION] [-n COUNT] LINE...`
+
+var errUsage = errors.New(usage)
+
+// chip is the part of *gpio.Chip used here.
+type chip interface {
+	Close() error
+	Name() string
+	Label() string
+	NumLines() int
+	LineInfo(offset int) (*gpio.LineInfo, error)
+	FindLine(name string) (int, error)
+	Request(offsets []int, cfg gpio.LineConfig) (lines, error)
+}
+
+// lines is the part of *gpio.Lines used here.
+type lines interface {
+	Close() error
+	Values() ([]gpio.Value, error)
+	SetValues(vals []gpio.Value) error
+	ReadEvent() (*gpio.Event, error)
+}
+
+// chardev adapts *gpio.Chip to chip.
+type chardev struct {
+	*gpio.Chip
+}
+
+func (c chardev) Request(offsets []int, cfg gpio.LineConfig) (lines, error) {
+	l, err := c.Chip.Request(offsets, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func openChardev(path string) (chip, error) {
+	c, err := gpio.OpenChip(path)
+	if err != nil {
+		return nil, err
+	}
+	return chardev{c}, nil
+}
+
+type cmd struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	chips func() ([]string, error)
+	open  func(path string) (chip, error)
+	sleep func(time.Duration)
+}
+
+// openChip opens the chip given by a path, name, number or label.
+func (c *cmd) openChip(arg string) (chip, error) {
+	switch {
+	case strings.HasPrefix(arg, "/"):
+		return c.open(arg)
+	case strings.HasPrefix(arg, "gpiochip"):
+		return c.open(filepath.Join("/dev", arg))
+	}
+	if _, err := strconv.Atoi(arg); err == nil {
+		return c.open("/dev/gpiochip" + arg)
+	}
+	paths, err := c.chips()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		ch, err := c.open(p)
+		if err != nil {
+			return nil, err
+		}
+		if ch.Label() == arg {
+			return ch, nil
+		}
+		ch.Close()
+	}
+	return nil, fmt.Errorf("no GPIO chip %q", arg)
+}
+
+// findLines returns the offsets of the lines on ch.
+func findLines(ch chip, names []string) ([]int, error) {
+	var offsets []int
+	for _, n := range names {
+		off, err := strconv.Atoi(n)
+		if err != nil {
+			if off, err = ch.FindLine(n); err != nil {
+				return nil, fmt.Errorf("no line %q on %s", n, ch.Name())
+			}
+		} else if off < 0 || off >= ch.NumLines() {
+			return nil, fmt.Errorf("line %d is beyond the %d lines of %s", off, ch.NumLines(), ch.Name())
+		}
+		offsets = append(offsets, off)
+	}
+	return offsets, nil
+}
+
+// lookup opens the chip of the lines and returns their offsets.
+func (c *cmd) lookup(chipArg string, names []string) (chip, []int, error) {
+	if len(names) == 0 {
+		return nil, nil, errUsage
+	}
+	if chipArg != "" {
+		ch, err := c.openChip(chipArg)
+		if err != nil {
+			return nil, nil, err
+		}
+		offsets, err := findLines(ch, names)
+		if err != nil {
+			ch.Close()
+			return nil, nil, err
+		}
+		return ch, offsets, nil
+	}
+	paths, err := c.chips()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range paths {
+		ch, err := c.open(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := ch.FindLine(names[0]); err != nil {
+			ch.Close()
+			continue
+		}
+		var offsets []int
+		for _, n := range names {
+			off, err := ch.FindLine(n)
+			if err != nil {
+				ch.Close()
+				return nil, nil, fmt.Errorf("line %q is not on %s with line %q", n, ch.Name(), names[0])
+			}
+			offsets = append(offsets, off)
+		}
+		return ch, offsets, nil
+	}
+	return nil, nil, fmt.Errorf("no line %q", names[0])
+}
+
+// lineFlags are the flags shared by get, set and mon.
+type lineFlags struct {
+	chip      *string
+	activeLow *bool
+	bias      *string
+}
+
+func (c *cmd) flags(op string) (*flag.FlagSet, *lineFlags) {
+	fs := flag.NewFlagSet("gpio "+op, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs, &lineFlags{
+		chip:      fs.String("c", "", "the `CHIP` of the lines"),
+		activeLow: fs.Bool("active-low", false, "invert the values"),
+		bias:      fs.String("bias", "as-is", "`BIAS`: as-is, pull-up, pull-down or disabled"),
+	}
+}
+
+// choice returns the flag of the value of an option.
+func choice(option, v string, choices map[string]gpio.LineFlag) (gpio.LineFlag, error) {
+	f, ok := choices[v]
+	if !ok {
+		return 0, fmt.Errorf("unknown %s %q", option, v)
+	}
+	return f, nil
+}
+
+func (l *lineFlags) config(dir gpio.LineFlag) (gpio.LineConfig, error) {
+	cfg := gpio.LineConfig{Consumer: "gpio", Flags: dir}
+	if *l.activeLow {
+		cfg.Flags |= gpio.FlagActiveLow
+	}
+	bias, err := choice("bias", *l.bias, map[string]gpio.LineFlag{
+		"as-is":     0,
+		"pull-up":   gpio.FlagBiasPullUp,
+		"pull-down": gpio.FlagBiasPullDown,
+		"disabled":  gpio.FlagBiasDisabled,
+	})
+	cfg.Flags |= bias
+	return cfg, err
+}
+
+func (c *cmd) detect() error {
+	paths, err := c.chips()
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		ch, err := c.open(p)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: %v\n", p, err)
+			continue
+		}
+		fmt.Fprintf(c.stdout, "%s [%s] (%d lines)\n", ch.Name(), ch.Label(), ch.NumLines())
+		ch.Close()
+	}
+	return nil
+}
+
+// quote quotes s, or returns none if it is empty.
+func quote(s, none string) string {
+	if s == "" {
+		return none
+	}
+	return strconv.Quote(s)
+}
+
+func (c *cmd) info(args []string) error {
+	var chips []chip
+	if len(args) == 0 {
+		paths, err := c.chips()
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			ch, err := c.open(p)
+			if err != nil {
+				return err
+			}
+			chips = append(chips, ch)
+		}
+	}
+	for _, a := range args {
+		ch, err := c.openChip(a)
+		if err != nil {
+			return err
+		}
+		chips = append(chips, ch)
+	}
+	for _, ch := range chips {
+		defer ch.Close()
+	}
+
+	for _, ch := range chips {
+		fmt.Fprintf(c.stdout, "%s - %d lines:\n", ch.Name(), ch.NumLines())
+		for i := 0; i < ch.NumLines(); i++ {
+			l, err := ch.LineInfo(i)
+			if err != nil {
+				return err
+			}
+			flags := l.Flags.String()
+			if l.Debounce != 0 {
+				flags += fmt.Sprintf(" debounce=%v", l.Debounce)
+			}
+			fmt.Fprintf(c.stdout, "\tline %3d: %12s %12s %s\n", i, quote(l.Name, "unnamed"), quote(l.Consumer, "unused"), flags)
+		}
+	}
+	return nil
+}
+
+func (c *cmd) get(args []string) error {
+	fs, lf := c.flags("get")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := lf.config(gpio.FlagInput)
+	if err != nil {
+		return err
+	}
+	ch, offsets, err := c.lookup(*lf.chip, fs.Args())
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	l, err := ch.Request(offsets, cfg)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	vals, err := l.Values()
+	if err != nil {
+		return err
+	}
+	s := make([]string, len(vals))
+	for i, v := range vals {
+		s[i] = v.String()
+	}
+	fmt.Fprintln(c.stdout, strings.Join(s, " "))
+	return nil
+}
+
+// parseValue parses the VALUE of LINE=VALUE.
+func parseValue(s string) (gpio.Value, error) {
+	switch strings.ToLower(s) {
+	case "0", "low", "inactive":
+		return gpio.Low, nil
+	case "1", "high", "active":
+		return gpio.High, nil
+	}
+	return gpio.Low, fmt.Errorf("invalid value %q, want 0, 1, low or high", s)
+}
+
+func (c *cmd) set(args []string) error {
+	fs, lf := c.flags("set")
+	var (
+		drive = fs.String("drive", "push-pull", "`DRIVE`: push-pull, open-drain or open-source")
+		hold  = fs.Duration("hold", 0, "how long to hold the values")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := lf.config(gpio.FlagOutput)
+	if err != nil {
+		return err
+	}
+	d, err := choice("drive", *drive, map[string]gpio.LineFlag{
+		"push-pull":   0,
+		"open-drain":  gpio.FlagOpenDrain,
+		"open-source": gpio.FlagOpenSource,
+	})
+	if err != nil {
+		return err
+	}
+	cfg.Flags |= d
+
+	var names []string
+	for _, a := range fs.Args() {
+		i := strings.IndexByte(a, '=')
+		if i == -1 {
+			return fmt.Errorf("%q is not LINE=VALUE", a)
+		}
+		v, err := parseValue(a[i+1:])
+		if err != nil {
+			return err
+		}
+		names = append(names, a[:i])
+		cfg.Values = append(cfg.Values, v)
+	}
+	ch, offsets, err := c.lookup(*lf.chip, names)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	// The values are set by the request.
+	l, err := ch.Request(offsets, cfg)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	c.sleep(*hold)
+	return nil
+}
+
+func (c *cmd) mon(args []string) error {
+	fs, lf := c.flags("mon")
+	var (
+		edge     = fs.String("edge", "both", "`EDGE`: both, rising or falling")
+		debounce = fs.Duration("debounce", 0, "debounce period of the inputs")
+		count    = fs.Int("n", 0, "exit after `COUNT` events")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := lf.config(gpio.FlagInput)
+	if err != nil {
+		return err
+	}
+	e, err := choice("edge", *edge, map[string]gpio.LineFlag{
+		"both":    gpio.FlagEdgeRising | gpio.FlagEdgeFalling,
+		"rising":  gpio.FlagEdgeRising,
+		"falling": gpio.FlagEdgeFalling,
+	})
+	if err != nil {
+		return err
+	}
+	cfg.Flags |= e
+	cfg.Debounce = *debounce
+
+	ch, offsets, err := c.lookup(*lf.chip, fs.Args())
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	l, err := ch.Request(offsets, cfg)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for n := 0; *count == 0 || n < *count; n++ {
+		ev, err := l.ReadEvent()
+		if err != nil {
+			return err
+		}
+		ts := ev.Timestamp
+		fmt.Fprintf(c.stdout, "event: %7s edge offset: %d timestamp: [%8d.%09d]\n", ev.Type, ev.Offset, ts/time.Second, ts%time.Second)
+	}
+	return nil
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch op, args := args[0], args[1:]; op {
+	case "detect":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return c.detect()
+	case "info":
+		return c.info(args)
+	case "get":
+		return c.get(args)
+	case "set":
+		return c.set(args)
+	case "mon":
+		return c.mon(args)
+	default:
+		return errUsage
+	}
+}
+
+func main() {
+	c := &cmd{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		chips:  gpio.Chips,
+		open:   openChardev,
+		sleep:  time.Sleep,
+	}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/gpio"
+)
+
+type fakeChip struct {
+	name, label string
+	lines       []string
+	values      []gpio.Value
+	events      []gpio.Event
+
+	// offsets and cfg are of the last request.
+	offsets []int
+	cfg     *gpio.LineConfig
+}
+
+func (c *fakeChip) Close() error  { return nil }
+func (c *fakeChip) Name() string  { return c.name }
+func (c *fakeChip) Label() string { return c.label }
+func (c *fakeChip) NumLines() int { return len(c.lines) }
+
+func (c *fakeChip) LineInfo(offset int) (*gpio.LineInfo, error) {
+	info := &gpio.LineInfo{Name: c.lines[offset], Offset: offset, Flags: gpio.FlagInput}
+	if offset == 0 {
+		info.Consumer, info.Flags = "reset", gpio.FlagUsed|gpio.FlagOutput|gpio.FlagActiveLow
+	}
+	return info, nil
+}
+
+func (c *fakeChip) FindLine(name string) (int, error) {
+	for i, n := range c.lines {
+		if n == name {
+			return i, nil
+		}
+	}
+	return 0, os.ErrNotExist
+}
+
+func (c *fakeChip) Request(offsets []int, cfg gpio.LineConfig) (lines, error) {
+	if err := cfg.Flags.Validate(); err != nil {
+		return nil, err
+	}
+	c.offsets, c.cfg = offsets, &cfg
+	return &fakeLines{c}, nil
+}
+
+type fakeLines struct {
+	c *fakeChip
+}
+
+func (l *fakeLines) Close() error { return nil }
+
+func (l *fakeLines) Values() ([]gpio.Value, error) {
+	var v []gpio.Value
+	for _, o := range l.c.offsets {
+		v = append(v, l.c.values[o])
+	}
+	return v, nil
+}
+
+func (l *fakeLines) SetValues(vals []gpio.Value) error {
+	return errors.New("not implemented")
+}
+
+func (l *fakeLines) ReadEvent() (*gpio.Event, error) {
+	if len(l.c.events) == 0 {
+		return nil, io.EOF
+	}
+	ev := l.c.events[0]
+	l.c.events = l.c.events[1:]
+	return &ev, nil
+}
+
+func newChips() map[string]*fakeChip {
+	return map[string]*fakeChip{
+		"/dev/gpiochip0": {
+			name:   "gpiochip0",
+			label:  "INT34C5:00",
+			lines:  []string{"RESET_N", "", "LED0", "LED1"},
+			values: []gpio.Value{gpio.High, gpio.Low, gpio.High, gpio.Low},
+			events: []gpio.Event{
+				{Timestamp: 12*time.Second + 5, Type: gpio.RisingEdge, Offset: 2},
+				{Timestamp: 13 * time.Second, Type: gpio.FallingEdge, Offset: 3},
+			},
+		},
+		"/dev/gpiochip1": {
+			name:   "gpiochip1",
+			label:  "gpio-mockup-A",
+			lines:  []string{"", "BUTTON"},
+			values: []gpio.Value{gpio.Low, gpio.High},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		want    string
+		err     string
+		chip    string
+		offsets []int
+		cfg     *gpio.LineConfig
+		hold    time.Duration
+	}{
+		{
+			name: "detect",
+			args: []string{"detect"},
+			want: "gpiochip0 [INT34C5:00] (4 lines)\ngpiochip1 [gpio-mockup-A] (2 lines)\n",
+		},
+		{
+			name: "info",
+			args: []string{"info", "gpio-mockup-A"},
+			want: "gpiochip1 - 2 lines:\n\tline   0:      unnamed      \"reset\" used,active-low,output\n\tline   1:     \"BUTTON\"       unused input\n",
+		},
+		{
+			name:    "get by name",
+			args:    []string{"get", "LED1", "LED0"},
+			want:    "0 1\n",
+			chip:    "/dev/gpiochip0",
+			offsets: []int{3, 2},
+			cfg:     &gpio.LineConfig{Consumer: "gpio", Flags: gpio.FlagInput},
+		},
+		{
+			name:    "get by offset",
+			args:    []string{"get", "-c", "1", "-bias", "pull-up", "-active-low", "1"},
+			want:    "1\n",
+			chip:    "/dev/gpiochip1",
+			offsets: []int{1},
+			cfg:     &gpio.LineConfig{Consumer: "gpio", Flags: gpio.FlagInput | gpio.FlagBiasPullUp | gpio.FlagActiveLow},
+		},
+		{
+			name:    "set",
+			args:    []string{"set", "-c", "gpiochip0", "-drive", "open-drain", "-hold", "1s", "2=high", "LED1=0"},
+			chip:    "/dev/gpiochip0",
+			offsets: []int{2, 3},
+			cfg:     &gpio.LineConfig{Consumer: "gpio", Flags: gpio.FlagOutput | gpio.FlagOpenDrain, Values: []gpio.Value{gpio.High, gpio.Low}},
+			hold:    time.Second,
+		},
+		{
+			name:    "mon",
+			args:    []string{"mon", "-n", "2", "-debounce", "10ms", "LED0", "LED1"},
+			want:    "event:  rising edge offset: 2 timestamp: [      12.000000005]\nevent: falling edge offset: 3 timestamp: [      13.000000000]\n",
+			chip:    "/dev/gpiochip0",
+			offsets: []int{2, 3},
+			cfg:     &gpio.LineConfig{Consumer: "gpio", Flags: gpio.FlagInput | gpio.FlagEdgeRising | gpio.FlagEdgeFalling, Debounce: 10 * time.Millisecond},
+		},
+		{
+			name: "lines on different chips",
+			args: []string{"get", "LED0", "BUTTON"},
+			err:  `line "BUTTON" is not on gpiochip0 with line "LED0"`,
+		},
+		{
+			name: "no line",
+			args: []string{"get", "-c", "0", "4"},
+			err:  "line 4 is beyond the 4 lines of gpiochip0",
+		},
+		{
+			name: "bad value",
+			args: []string{"set", "LED0=on"},
+			err:  `invalid value "on", want 0, 1, low or high`,
+		},
+		{
+			name: "bad bias",
+			args: []string{"mon", "-bias", "strong", "LED0"},
+			err:  `unknown bias "strong"`,
+		},
+		{
+			name: "no lines",
+			args: []string{"get"},
+			err:  usage,
+		},
+		{
+			name: "unknown",
+			args: []string{"toggle"},
+			err:  usage,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			chips := newChips()
+			var hold time.Duration
+			c := &cmd{
+				stdout: &stdout,
+				stderr: &stderr,
+				chips:  func() ([]string, error) { return []string{"/dev/gpiochip0", "/dev/gpiochip1"}, nil },
+				open: func(p string) (chip, error) {
+					if c, ok := chips[p]; ok {
+						return c, nil
+					}
+					return nil, os.ErrNotExist
+				},
+				sleep: func(d time.Duration) { hold = d },
+			}
+			err := c.run(tt.args)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("run(%q) = %v, want %q", tt.args, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("run(%q) = %v", tt.args, err)
+			}
+			if stdout.String() != tt.want {
+				t.Errorf("run(%q) printed\n%q\nwant\n%q", tt.args, stdout.String(), tt.want)
+			}
+			if tt.chip != "" {
+				ch := chips[tt.chip]
+				if !reflect.DeepEqual(ch.offsets, tt.offsets) || !reflect.DeepEqual(ch.cfg, tt.cfg) {
+					t.Errorf("requested %v with %+v, want %v with %+v", ch.offsets, ch.cfg, tt.offsets, tt.cfg)
+				}
+			}
+			if hold != tt.hold {
+				t.Errorf("held for %v, want %v", hold, tt.hold)
+			}
+			if strings.Contains(stderr.String(), "flag provided but not defined") {
+				t.Errorf("stderr: %s", stderr.String())
+			}
+		})
+	}
+}
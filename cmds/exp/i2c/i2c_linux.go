@@ -0,0 +1,819 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// i2c detects, reads and writes I2C and SMBus devices, and decodes the SPD
+// EEPROMs of memory modules.
+//
+// Synopsis:
+//     i2c detect -l
+//         List I2C adapters.
+//     i2c detect -F BUS
+//         Print the functionality of an adapter.
+//     i2c detect [-q|-r] [-a] BUS
+//         Scan an adapter for devices.
+//     i2c get [-f] [-pec] BUS ADDR [REG [MODE]]
+//         Read a register.
+//     i2c set [-f] [-pec] BUS ADDR REG [VALUE...] [MODE]
+//         Write a register.
+//     i2c dump [-f] [-pec] [-r FIRST-LAST] BUS ADDR [MODE]
+//         Dump the registers of a device.
+//     i2c transfer [-f] BUS DESC [DATA...]...
+//         Send a combined transaction of I2C messages.
+//     i2c spd [BUS [ADDR...]]
+//         Decode the SPD EEPROMs of DDR4 and DDR5 modules.
+//
+// Description:
+//     BUS is a path such as /dev/i2c-0, a name such as i2c-0, a number or
+//     the name of an adapter. ADDR is a 7-bit address and REG a register,
+//     VALUE and DATA bytes, or words in mode w, all in decimal, octal with
+//     a leading 0 or hex with a leading 0x.
+//
+//     MODE is the SMBus transaction: b for byte data, the default, w for
+//     word data, c for a byte without data, s for an SMBus block and i
+//     for an I2C block. get without REG receives a byte.
+//
+//     detect prints the address of each device that responds, -- for no
+//     device and UU for devices used by a kernel driver. It probes with a
+//     read for 0x30-0x37 and 0x50-0x5f, where writes may corrupt EEPROMs,
+//     and with a quick write elsewhere.
+//
+//     DESC of transfer is {r|w}LENGTH[@ADDR]; a write is followed by its
+//     LENGTH bytes. ADDR defaults to that of the previous message. The
+//     bytes read are printed a message per line.
+//
+//     spd without ADDR scans 0x50-0x57, and without BUS all adapters.
+//
+// Options:
+//     -l: list adapters
+//     -F: print the functionality of the adapter
+//     -q: probe with quick writes
+//     -r: probe with reads, or the range of registers to dump
+//     -a: scan all addresses, including the reserved ones
+//     -f: access devices even if a kernel driver uses them
+//     -pec: enable Packet Error Checking
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/i2c"
+	"github.com/u-root/u-root/pkg/i2c/spd"
+	"golang.org/x/sys/unix"
+)
+
+const usage = `usage:
+  i2c detect -l
+  i2c detect -F BUS
+  i2c detect [-q|-r] [-a] BUS
+  i2c get [-f] [-pec] BUS ADDR [REG [MODE]]
+  i2c set [-f] [-pec] BUS ADDR REG [VALUE...] [MODE]
+  i2c dump [-f] [-pec] [-r FIRST-LAST] BUS ADDR [MODE]
+  i2c transfer [-f] BUS DESC [DATA...]...
+  i2c spd [BUS [ADDR...]]`
+
+var errUsage = errors.New(usage)
+
+// bus is the part of *i2c.Bus used here.
+type bus interface {
+	Close() error
+	Functionality() i2c.Functionality
+	SetPEC(on bool) error
+	Quick(addr uint16, read bool) error
+	ReceiveByte(addr uint16) (byte, error)
+	SendByte(addr uint16, v byte) error
+	ReadByteData(addr uint16, cmd byte) (byte, error)
+	WriteByteData(addr uint16, cmd byte, v byte) error
+	ReadWordData(addr uint16, cmd byte) (uint16, error)
+	WriteWordData(addr uint16, cmd byte, v uint16) error
+	ReadBlockData(addr uint16, cmd byte) ([]byte, error)
+	WriteBlockData(addr uint16, cmd byte, p []byte) error
+	ReadI2CBlockData(addr uint16, cmd byte, n int) ([]byte, error)
+	WriteI2CBlockData(addr uint16, cmd byte, p []byte) error
+	Transfer(msgs []i2c.Msg) error
+}
+
+func openDev(path string, force bool) (bus, error) {
+	b, err := i2c.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	b.Force = force
+	return b, nil
+}
+
+type cmd struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	adapters func() ([]i2c.Adapter, error)
+	open     func(path string, force bool) (bus, error)
+}
+
+// busPath returns the device of the adapter given by a path, name, number
+// or adapter name.
+func (c *cmd) busPath(arg string) (string, error) {
+	switch {
+	case strings.HasPrefix(arg, "/"):
+		return arg, nil
+	case strings.HasPrefix(arg, "i2c-"):
+		return filepath.Join("/dev", arg), nil
+	}
+	if n, err := strconv.Atoi(arg); err == nil {
+		return i2c.Adapter{Number: n}.Path(), nil
+	}
+	as, err := c.adapters()
+	if err != nil {
+		return "", err
+	}
+	for _, a := range as {
+		if a.Name == arg {
+			return a.Path(), nil
+		}
+	}
+	return "", fmt.Errorf("no I2C adapter %q", arg)
+}
+
+func (c *cmd) openBus(arg string, force, pec bool) (bus, error) {
+	p, err := c.busPath(arg)
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.open(p, force)
+	if err != nil {
+		return nil, err
+	}
+	if pec {
+		if !b.Functionality().Has(i2c.FuncSMBusPEC) {
+			b.Close()
+			return nil, fmt.Errorf("%s does not support PEC", p)
+		}
+		if err := b.SetPEC(true); err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func parseUint(name, s string, bits int) (uint64, error) {
+	v, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return v, nil
+}
+
+func parseAddr(s string) (uint16, error) {
+	a, err := parseUint("address", s, 7)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(a), i2c.CheckAddr(uint16(a), false)
+}
+
+// mode splits a trailing MODE off args.
+func mode(args []string, def string) ([]string, string) {
+	if n := len(args); n > 0 {
+		switch args[n-1] {
+		case "b", "w", "c", "s", "i":
+			return args[:n-1], args[n-1]
+		}
+	}
+	return args, def
+}
+
+// need checks that b supports the transactions f of mode.
+func need(b bus, f i2c.Functionality, what string) error {
+	if !b.Functionality().Has(f) {
+		return fmt.Errorf("adapter does not support %s", what)
+	}
+	return nil
+}
+
+func (c *cmd) flags(op string) (*flag.FlagSet, *bool, *bool) {
+	fs := flag.NewFlagSet("i2c "+op, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs, fs.Bool("f", false, "access devices even if a kernel driver uses them"), fs.Bool("pec", false, "enable Packet Error Checking")
+}
+
+func (c *cmd) list() error {
+	as, err := c.adapters()
+	if err != nil {
+		return err
+	}
+	for _, a := range as {
+		fmt.Fprintf(c.stdout, "i2c-%d\t%s\n", a.Number, a.Name)
+	}
+	return nil
+}
+
+func (c *cmd) funcs(b bus) {
+	f := b.Functionality()
+	for _, n := range []struct {
+		f    i2c.Functionality
+		name string
+	}{
+		{i2c.FuncI2C, "I2C"},
+		{i2c.FuncSMBusQuick, "SMBus Quick Command"},
+		{i2c.FuncSMBusWriteByte, "SMBus Send Byte"},
+		{i2c.FuncSMBusReadByte, "SMBus Receive Byte"},
+		{i2c.FuncSMBusWriteByteData, "SMBus Write Byte"},
+		{i2c.FuncSMBusReadByteData, "SMBus Read Byte"},
+		{i2c.FuncSMBusWriteWordData, "SMBus Write Word"},
+		{i2c.FuncSMBusReadWordData, "SMBus Read Word"},
+		{i2c.FuncSMBusProcCall, "SMBus Process Call"},
+		{i2c.FuncSMBusWriteBlockData, "SMBus Block Write"},
+		{i2c.FuncSMBusReadBlockData, "SMBus Block Read"},
+		{i2c.FuncSMBusBlockProcCall, "SMBus Block Process Call"},
+		{i2c.FuncSMBusPEC, "SMBus PEC"},
+		{i2c.FuncSMBusWriteI2CBlock, "I2C Block Write"},
+		{i2c.FuncSMBusReadI2CBlock, "I2C Block Read"},
+	} {
+		yes := "no"
+		if f.Has(n.f) {
+			yes = "yes"
+		}
+		fmt.Fprintf(c.stdout, "%-32s%s\n", n.name, yes)
+	}
+}
+
+// probe returns whether a device responds at addr.
+func probe(b bus, addr uint16, read bool) (bool, error) {
+	var err error
+	if read {
+		_, err = b.ReceiveByte(addr)
+	} else {
+		err = b.Quick(addr, false)
+	}
+	if errors.Is(err, unix.EBUSY) {
+		return false, err
+	}
+	return err == nil, nil
+}
+
+func (c *cmd) detect(args []string) error {
+	fs := flag.NewFlagSet("i2c detect", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	list := fs.Bool("l", false, "list adapters")
+	funcs := fs.Bool("F", false, "print the functionality of the adapter")
+	quick := fs.Bool("q", false, "probe with quick writes")
+	read := fs.Bool("r", false, "probe with reads")
+	all := fs.Bool("a", false, "scan all addresses, including the reserved ones")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *list {
+		if fs.NArg() != 0 {
+			return errUsage
+		}
+		return c.list()
+	}
+	if fs.NArg() != 1 || *quick && *read {
+		return errUsage
+	}
+	b, err := c.openBus(fs.Arg(0), false, false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	if *funcs {
+		c.funcs(b)
+		return nil
+	}
+	f := b.Functionality()
+	switch {
+	case *quick:
+		if err := need(b, i2c.FuncSMBusQuick, "quick writes"); err != nil {
+			return err
+		}
+	case *read:
+		if err := need(b, i2c.FuncSMBusReadByte, "receive byte"); err != nil {
+			return err
+		}
+	case !f.Has(i2c.FuncSMBusQuick | i2c.FuncSMBusReadByte):
+		return errors.New("adapter does not support quick writes and receive byte; use -q or -r")
+	}
+
+	first, last := uint16(0x03), uint16(0x77)
+	if *all {
+		first, last = 0x00, 0x7f
+	}
+	fmt.Fprint(c.stdout, "   ")
+	for i := 0; i < 16; i++ {
+		fmt.Fprintf(c.stdout, "  %x", i)
+	}
+	for a := uint16(0); a <= last; a++ {
+		if a%16 == 0 {
+			fmt.Fprintf(c.stdout, "\n%02x:", a)
+		}
+		if a < first {
+			fmt.Fprint(c.stdout, "   ")
+			continue
+		}
+		byRead := *read || !*quick && (a >= 0x30 && a <= 0x37 || a >= 0x50 && a <= 0x5f)
+		ok, err := probe(b, a, byRead)
+		switch {
+		case err != nil:
+			fmt.Fprint(c.stdout, " UU")
+		case ok:
+			fmt.Fprintf(c.stdout, " %02x", a)
+		default:
+			fmt.Fprint(c.stdout, " --")
+		}
+	}
+	fmt.Fprintln(c.stdout)
+	return nil
+}
+
+func printBytes(w io.Writer, p []byte) {
+	s := make([]string, len(p))
+	for i, v := range p {
+		s[i] = fmt.Sprintf("0x%02x", v)
+	}
+	fmt.Fprintln(w, strings.Join(s, " "))
+}
+
+func (c *cmd) get(args []string) error {
+	fs, force, pec := c.flags("get")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args, m := mode(fs.Args(), "b")
+	if len(args) < 2 || len(args) > 3 || len(args) == 2 && m != "b" {
+		return errUsage
+	}
+	addr, err := parseAddr(args[1])
+	if err != nil {
+		return err
+	}
+	var reg byte
+	if len(args) == 3 {
+		r, err := parseUint("register", args[2], 8)
+		if err != nil {
+			return err
+		}
+		reg = byte(r)
+	}
+	b, err := c.openBus(args[0], *force, *pec)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	switch {
+	case len(args) == 2:
+		if err := need(b, i2c.FuncSMBusReadByte, "receive byte"); err != nil {
+			return err
+		}
+		v, err := b.ReceiveByte(addr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "0x%02x\n", v)
+	case m == "b":
+		if err := need(b, i2c.FuncSMBusReadByteData, "read byte"); err != nil {
+			return err
+		}
+		v, err := b.ReadByteData(addr, reg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "0x%02x\n", v)
+	case m == "w":
+		if err := need(b, i2c.FuncSMBusReadWordData, "read word"); err != nil {
+			return err
+		}
+		v, err := b.ReadWordData(addr, reg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "0x%04x\n", v)
+	case m == "c":
+		if err := need(b, i2c.FuncSMBusWriteByte|i2c.FuncSMBusReadByte, "send and receive byte"); err != nil {
+			return err
+		}
+		if err := b.SendByte(addr, reg); err != nil {
+			return err
+		}
+		v, err := b.ReceiveByte(addr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "0x%02x\n", v)
+	case m == "s":
+		if err := need(b, i2c.FuncSMBusReadBlockData, "block read"); err != nil {
+			return err
+		}
+		p, err := b.ReadBlockData(addr, reg)
+		if err != nil {
+			return err
+		}
+		printBytes(c.stdout, p)
+	case m == "i":
+		if err := need(b, i2c.FuncSMBusReadI2CBlock, "I2C block read"); err != nil {
+			return err
+		}
+		p, err := b.ReadI2CBlockData(addr, reg, i2c.BlockMax)
+		if err != nil {
+			return err
+		}
+		printBytes(c.stdout, p)
+	}
+	return nil
+}
+
+func (c *cmd) set(args []string) error {
+	fs, force, pec := c.flags("set")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args, m := mode(fs.Args(), "")
+	if len(args) < 3 {
+		return errUsage
+	}
+	addr, err := parseAddr(args[1])
+	if err != nil {
+		return err
+	}
+	r, err := parseUint("register", args[2], 8)
+	if err != nil {
+		return err
+	}
+	reg, vals := byte(r), args[3:]
+	if m == "" {
+		m = "b"
+		if len(vals) == 0 {
+			m = "c"
+		}
+	}
+	switch {
+	case m == "c" && len(vals) != 0,
+		(m == "b" || m == "w") && len(vals) != 1,
+		(m == "s" || m == "i") && (len(vals) == 0 || len(vals) > i2c.BlockMax):
+		return errUsage
+	}
+	bits := 8
+	if m == "w" {
+		bits = 16
+	}
+	var p []byte
+	var word uint16
+	for _, s := range vals {
+		v, err := parseUint("value", s, bits)
+		if err != nil {
+			return err
+		}
+		p, word = append(p, byte(v)), uint16(v)
+	}
+	b, err := c.openBus(args[0], *force, *pec)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	switch m {
+	case "c":
+		if err := need(b, i2c.FuncSMBusWriteByte, "send byte"); err != nil {
+			return err
+		}
+		return b.SendByte(addr, reg)
+	case "b":
+		if err := need(b, i2c.FuncSMBusWriteByteData, "write byte"); err != nil {
+			return err
+		}
+		return b.WriteByteData(addr, reg, p[0])
+	case "w":
+		if err := need(b, i2c.FuncSMBusWriteWordData, "write word"); err != nil {
+			return err
+		}
+		return b.WriteWordData(addr, reg, word)
+	case "s":
+		if err := need(b, i2c.FuncSMBusWriteBlockData, "block write"); err != nil {
+			return err
+		}
+		return b.WriteBlockData(addr, reg, p)
+	default:
+		if err := need(b, i2c.FuncSMBusWriteI2CBlock, "I2C block write"); err != nil {
+			return err
+		}
+		return b.WriteI2CBlockData(addr, reg, p)
+	}
+}
+
+func parseRange(s string) (int, int, error) {
+	fl := strings.SplitN(s, "-", 2)
+	if len(fl) != 2 {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	first, err := parseUint("range", fl[0], 8)
+	if err != nil {
+		return 0, 0, err
+	}
+	last, err := parseUint("range", fl[1], 8)
+	if err != nil {
+		return 0, 0, err
+	}
+	if first > last {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	return int(first), int(last), nil
+}
+
+// readRegs reads registers first to last in mode b or i. Registers that
+// fail to read are -1.
+func readRegs(b bus, addr uint16, m string, first, last int) []int {
+	regs := make([]int, 256)
+	for i := range regs {
+		regs[i] = -1
+	}
+	for r := first; r <= last; {
+		if m == "i" {
+			n := last - r + 1
+			if n > i2c.BlockMax {
+				n = i2c.BlockMax
+			}
+			p, err := b.ReadI2CBlockData(addr, byte(r), n)
+			if err != nil || len(p) == 0 {
+				r += n
+				continue
+			}
+			for _, v := range p {
+				regs[r] = int(v)
+				r++
+			}
+			continue
+		}
+		if v, err := b.ReadByteData(addr, byte(r)); err == nil {
+			regs[r] = int(v)
+		}
+		r++
+	}
+	return regs
+}
+
+func (c *cmd) dump(args []string) error {
+	fs, force, pec := c.flags("dump")
+	rng := fs.String("r", "0x00-0xff", "`FIRST-LAST` registers to dump")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args, m := mode(fs.Args(), "b")
+	if len(args) != 2 || m == "c" || m == "s" {
+		return errUsage
+	}
+	first, last, err := parseRange(*rng)
+	if err != nil {
+		return err
+	}
+	addr, err := parseAddr(args[1])
+	if err != nil {
+		return err
+	}
+	b, err := c.openBus(args[0], *force, *pec)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if m == "w" {
+		if err := need(b, i2c.FuncSMBusReadWordData, "read word"); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout, "     0,8  1,9  2,a  3,b  4,c  5,d  6,e  7,f")
+		for row := first &^ 7; row <= last; row += 8 {
+			fmt.Fprintf(c.stdout, "%02x:", row)
+			for r := row; r < row+8; r++ {
+				if r < first || r > last {
+					fmt.Fprint(c.stdout, "     ")
+					continue
+				}
+				v, err := b.ReadWordData(addr, byte(r))
+				if err != nil {
+					fmt.Fprint(c.stdout, " XXXX")
+					continue
+				}
+				fmt.Fprintf(c.stdout, " %04x", v)
+			}
+			fmt.Fprintln(c.stdout)
+		}
+		return nil
+	}
+
+	f, what := i2c.FuncSMBusReadByteData, "read byte"
+	if m == "i" {
+		f, what = i2c.FuncSMBusReadI2CBlock, "I2C block read"
+	}
+	if err := need(b, f, what); err != nil {
+		return err
+	}
+	regs := readRegs(b, addr, m, first, last)
+	fmt.Fprintln(c.stdout, "     0  1  2  3  4  5  6  7  8  9  a  b  c  d  e  f    0123456789abcdef")
+	for row := first &^ 0xf; row <= last; row += 16 {
+		var ascii strings.Builder
+		fmt.Fprintf(c.stdout, "%02x:", row)
+		for r := row; r < row+16; r++ {
+			switch v := regs[r]; {
+			case r < first || r > last:
+				fmt.Fprint(c.stdout, "   ")
+				ascii.WriteByte(' ')
+			case v < 0:
+				fmt.Fprint(c.stdout, " XX")
+				ascii.WriteByte('X')
+			default:
+				fmt.Fprintf(c.stdout, " %02x", v)
+				if v < 0x20 || v > 0x7e {
+					v = '.'
+				}
+				ascii.WriteByte(byte(v))
+			}
+		}
+		fmt.Fprintf(c.stdout, "    %s\n", strings.TrimRight(ascii.String(), " "))
+	}
+	return nil
+}
+
+// parseMsgs parses the message descriptions and data of transfer.
+func parseMsgs(args []string) ([]i2c.Msg, error) {
+	var msgs []i2c.Msg
+	addr := -1
+	for len(args) > 0 {
+		desc := args[0]
+		args = args[1:]
+		if len(desc) < 2 || desc[0] != 'r' && desc[0] != 'w' {
+			return nil, fmt.Errorf("invalid message %q", desc)
+		}
+		la := strings.SplitN(desc[1:], "@", 2)
+		if len(la) == 2 {
+			v, err := parseAddr(la[1])
+			if err != nil {
+				return nil, err
+			}
+			addr = int(v)
+		}
+		if addr < 0 {
+			return nil, fmt.Errorf("message %q has no address", desc)
+		}
+		n, err := parseUint("length", la[0], 16)
+		if err != nil {
+			return nil, err
+		}
+		m := i2c.Msg{Addr: uint16(addr), Buf: make([]byte, n)}
+		if desc[0] == 'r' {
+			m.Flags = i2c.Read
+		} else {
+			if len(args) < int(n) {
+				return nil, fmt.Errorf("message %q has %d of %d bytes", desc, len(args), n)
+			}
+			for i := range m.Buf {
+				v, err := parseUint("data", args[i], 8)
+				if err != nil {
+					return nil, err
+				}
+				m.Buf[i] = byte(v)
+			}
+			args = args[n:]
+		}
+		msgs = append(msgs, m)
+	}
+	if len(msgs) > i2c.MaxMsgs {
+		return nil, fmt.Errorf("%d messages, more than %d", len(msgs), i2c.MaxMsgs)
+	}
+	return msgs, nil
+}
+
+func (c *cmd) transfer(args []string) error {
+	fs := flag.NewFlagSet("i2c transfer", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	force := fs.Bool("f", false, "access devices even if a kernel driver uses them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errUsage
+	}
+	msgs, err := parseMsgs(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	b, err := c.openBus(fs.Arg(0), *force, false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	if err := need(b, i2c.FuncI2C, "I2C transfers"); err != nil {
+		return err
+	}
+	if err := b.Transfer(msgs); err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if m.Flags&i2c.Read != 0 {
+			printBytes(c.stdout, m.Buf)
+		}
+	}
+	return nil
+}
+
+func (c *cmd) spd(args []string) error {
+	var buses []string
+	var addrs []uint16
+	if len(args) == 0 {
+		as, err := c.adapters()
+		if err != nil {
+			return err
+		}
+		for _, a := range as {
+			buses = append(buses, a.Path())
+		}
+	} else {
+		buses = args[:1]
+		for _, s := range args[1:] {
+			a, err := parseAddr(s)
+			if err != nil {
+				return err
+			}
+			addrs = append(addrs, a)
+		}
+	}
+	scan := len(addrs) == 0
+	if scan {
+		for a := uint16(spd.FirstAddr); a <= spd.LastAddr; a++ {
+			addrs = append(addrs, a)
+		}
+	}
+
+	found := false
+	for _, arg := range buses {
+		b, err := c.openBus(arg, false, false)
+		if err != nil {
+			return err
+		}
+		if !b.Functionality().Has(i2c.FuncSMBusReadByteData | i2c.FuncSMBusWriteByteData | i2c.FuncSMBusWriteByte) {
+			b.Close()
+			continue
+		}
+		for _, a := range addrs {
+			d, err := spd.Read(b, a)
+			if err == nil {
+				var s *spd.SPD
+				if s, err = spd.Decode(d); err == nil {
+					fmt.Fprintf(c.stdout, "%s address %#02x:\n%v\n", arg, a, s)
+					found = true
+					continue
+				}
+			}
+			if !scan {
+				b.Close()
+				return err
+			}
+		}
+		b.Close()
+	}
+	if !found {
+		return errors.New("no SPD EEPROMs found")
+	}
+	return nil
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch op, args := args[0], args[1:]; op {
+	case "detect":
+		return c.detect(args)
+	case "get":
+		return c.get(args)
+	case "set":
+		return c.set(args)
+	case "dump":
+		return c.dump(args)
+	case "transfer":
+		return c.transfer(args)
+	case "spd":
+		return c.spd(args)
+	default:
+		return errUsage
+	}
+}
+
+func main() {
+	c := &cmd{
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		adapters: i2c.Adapters,
+		open:     openDev,
+	}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
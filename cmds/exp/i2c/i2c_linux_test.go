@@ -0,0 +1,413 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/i2c"
+	"github.com/u-root/u-root/pkg/i2c/spd"
+	"golang.org/x/sys/unix"
+)
+
+// fakeBus is like i2c-stub: devices have 256 byte registers and a
+// pointer, set by send byte and incremented by receive byte. Devices in
+// page1 have a second page, selected by a send byte to 0x37 like EE1004
+// EEPROMs.
+type fakeBus struct {
+	funcs i2c.Functionality
+	devs  map[uint16]*[256]byte
+	page1 map[uint16]*[256]byte
+	page  int
+	ptr   map[uint16]byte
+	busy  map[uint16]bool
+	pec   bool
+	force bool
+}
+
+func (f *fakeBus) dev(addr uint16) (*[256]byte, error) {
+	if f.busy[addr] && !f.force {
+		return nil, fmt.Errorf("setting address %#02x: %w", addr, unix.EBUSY)
+	}
+	if d, ok := f.page1[addr]; ok && f.page == 1 {
+		return d, nil
+	}
+	d, ok := f.devs[addr]
+	if !ok {
+		return nil, unix.ENXIO
+	}
+	return d, nil
+}
+
+func (f *fakeBus) Close() error                     { return nil }
+func (f *fakeBus) Functionality() i2c.Functionality { return f.funcs }
+func (f *fakeBus) SetPEC(on bool) error             { f.pec = on; return nil }
+
+func (f *fakeBus) Quick(addr uint16, read bool) error {
+	_, err := f.dev(addr)
+	return err
+}
+
+func (f *fakeBus) ReceiveByte(addr uint16) (byte, error) {
+	d, err := f.dev(addr)
+	if err != nil {
+		return 0, err
+	}
+	v := d[f.ptr[addr]]
+	f.ptr[addr]++
+	return v, nil
+}
+
+func (f *fakeBus) SendByte(addr uint16, v byte) error {
+	if len(f.page1) != 0 && (addr == 0x36 || addr == 0x37) {
+		f.page = int(addr - 0x36)
+		return nil
+	}
+	if _, err := f.dev(addr); err != nil {
+		return err
+	}
+	f.ptr[addr] = v
+	return nil
+}
+
+func (f *fakeBus) ReadByteData(addr uint16, cmd byte) (byte, error) {
+	d, err := f.dev(addr)
+	if err != nil {
+		return 0, err
+	}
+	return d[cmd], nil
+}
+
+func (f *fakeBus) WriteByteData(addr uint16, cmd byte, v byte) error {
+	return f.WriteI2CBlockData(addr, cmd, []byte{v})
+}
+
+func (f *fakeBus) ReadWordData(addr uint16, cmd byte) (uint16, error) {
+	p, err := f.ReadI2CBlockData(addr, cmd, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(p), nil
+}
+
+func (f *fakeBus) WriteWordData(addr uint16, cmd byte, v uint16) error {
+	return f.WriteI2CBlockData(addr, cmd, []byte{byte(v), byte(v >> 8)})
+}
+
+func (f *fakeBus) ReadBlockData(addr uint16, cmd byte) ([]byte, error) {
+	d, err := f.dev(addr)
+	if err != nil {
+		return nil, err
+	}
+	return f.ReadI2CBlockData(addr, cmd+1, int(d[cmd]))
+}
+
+func (f *fakeBus) WriteBlockData(addr uint16, cmd byte, p []byte) error {
+	return f.WriteI2CBlockData(addr, cmd, append([]byte{byte(len(p))}, p...))
+}
+
+func (f *fakeBus) ReadI2CBlockData(addr uint16, cmd byte, n int) ([]byte, error) {
+	d, err := f.dev(addr)
+	if err != nil {
+		return nil, err
+	}
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = d[byte(int(cmd)+i)]
+	}
+	return p, nil
+}
+
+func (f *fakeBus) WriteI2CBlockData(addr uint16, cmd byte, p []byte) error {
+	d, err := f.dev(addr)
+	if err != nil {
+		return err
+	}
+	for i, v := range p {
+		d[byte(int(cmd)+i)] = v
+	}
+	return nil
+}
+
+// Transfer treats the first byte of a write as the register of the
+// following reads.
+func (f *fakeBus) Transfer(msgs []i2c.Msg) error {
+	for _, m := range msgs {
+		if m.Flags&i2c.Read != 0 {
+			p, err := f.ReadI2CBlockData(m.Addr, f.ptr[m.Addr], len(m.Buf))
+			if err != nil {
+				return err
+			}
+			copy(m.Buf, p)
+			continue
+		}
+		if err := f.SendByte(m.Addr, m.Buf[0]); err != nil {
+			return err
+		}
+		if err := f.WriteI2CBlockData(m.Addr, m.Buf[0], m.Buf[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const allFuncs = i2c.FuncI2C | i2c.FuncSMBusPEC | i2c.FuncSMBusQuick | i2c.FuncSMBusReadByte |
+	i2c.FuncSMBusWriteByte | i2c.FuncSMBusReadByteData | i2c.FuncSMBusWriteByteData |
+	i2c.FuncSMBusReadWordData | i2c.FuncSMBusWriteWordData | i2c.FuncSMBusReadBlockData |
+	i2c.FuncSMBusWriteBlockData | i2c.FuncSMBusReadI2CBlock | i2c.FuncSMBusWriteI2CBlock
+
+// ddr4 returns the pages of the SPD of a DDR4-2400 4 GiB SO-DIMM.
+func ddr4() (*[256]byte, *[256]byte) {
+	var d [512]byte
+	copy(d[:], []byte{0x23, 0x11, 0x0c, 0x03, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03})
+	d[18] = 0x07
+	d[125] = 0xd6 // 875 - 42 = 833 ps
+	binary.LittleEndian.PutUint16(d[126:], spd.CRC16(d[0:126]))
+	copy(d[320:], []byte{0x80, 0xad, 0x00, 0x19, 0x33, 0x01, 0x02, 0x03, 0x04})
+	copy(d[329:], "HMA851S6CJR6N-VK    ")
+	var p0, p1 [256]byte
+	copy(p0[:], d[:256])
+	copy(p1[:], d[256:])
+	return &p0, &p1
+}
+
+func newBuses() map[string]*fakeBus {
+	regs := &[256]byte{}
+	for i := range regs {
+		regs[i] = byte(i)
+	}
+	copy(regs[0x40:], "u-root\x00")
+	p0, p1 := ddr4()
+	return map[string]*fakeBus{
+		"/dev/i2c-0": {
+			funcs: allFuncs,
+			devs:  map[uint16]*[256]byte{0x1a: regs, 0x2c: {}, 0x50: p0},
+			page1: map[uint16]*[256]byte{0x50: p1},
+			ptr:   map[uint16]byte{},
+			busy:  map[uint16]bool{0x2c: true},
+		},
+		"/dev/i2c-1": {
+			funcs: i2c.FuncSMBusQuick | i2c.FuncSMBusReadByteData,
+			devs:  map[uint16]*[256]byte{0x08: {}},
+			ptr:   map[uint16]byte{},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		want string
+		err  string
+		pec  bool
+		// check checks the registers of device 0x1a on i2c-0.
+		check func(regs *[256]byte) bool
+	}{
+		{
+			name: "list",
+			args: []string{"detect", "-l"},
+			want: "i2c-0\tSMBus I801 adapter at efa0\ni2c-1\tSMBus stub driver\n",
+		},
+		{
+			name: "funcs",
+			args: []string{"detect", "-F", "SMBus stub driver"},
+			want: "I2C                             no\nSMBus Quick Command             yes\nSMBus Send Byte                 no\nSMBus Receive Byte              no\nSMBus Write Byte                no\nSMBus Read Byte                 yes\nSMBus Write Word                no\nSMBus Read Word                 no\nSMBus Process Call              no\nSMBus Block Write               no\nSMBus Block Read                no\nSMBus Block Process Call        no\nSMBus PEC                       no\nI2C Block Write                 no\nI2C Block Read                  no\n",
+		},
+		{
+			name: "detect",
+			args: []string{"detect", "0"},
+			want: `     0  1  2  3  4  5  6  7  8  9  a  b  c  d  e  f
+00:          -- -- -- -- -- -- -- -- -- -- -- -- --
+10: -- -- -- -- -- -- -- -- -- -- 1a -- -- -- -- --
+20: -- -- -- -- -- -- -- -- -- -- -- -- UU -- -- --
+30: -- -- -- -- -- -- -- -- -- -- -- -- -- -- -- --
+40: -- -- -- -- -- -- -- -- -- -- -- -- -- -- -- --
+50: 50 -- -- -- -- -- -- -- -- -- -- -- -- -- -- --
+60: -- -- -- -- -- -- -- -- -- -- -- -- -- -- -- --
+70: -- -- -- -- -- -- -- --
+`,
+		},
+		{
+			name: "detect without receive byte",
+			args: []string{"detect", "i2c-1"},
+			err:  "adapter does not support quick writes and receive byte; use -q or -r",
+		},
+		{
+			name: "get",
+			args: []string{"get", "0", "0x1a", "0x10"},
+			want: "0x10\n",
+		},
+		{
+			name: "get word",
+			args: []string{"get", "-pec", "0", "0x1a", "0x10", "w"},
+			want: "0x1110\n",
+			pec:  true,
+		},
+		{
+			name: "get byte",
+			args: []string{"get", "0", "0x1a", "0x20", "c"},
+			want: "0x20\n",
+		},
+		{
+			name: "get block",
+			args: []string{"get", "0", "0x1a", "0x03", "s"},
+			want: "0x04 0x05 0x06\n",
+		},
+		{
+			name: "get busy",
+			args: []string{"get", "0", "0x2c", "0"},
+			err:  "setting address 0x2c: device or resource busy",
+		},
+		{
+			name: "get forced",
+			args: []string{"get", "-f", "0", "0x2c", "0"},
+			want: "0x00\n",
+		},
+		{
+			name: "get unsupported",
+			args: []string{"get", "1", "0x08", "0", "w"},
+			err:  "adapter does not support read word",
+		},
+		{
+			name:  "set",
+			args:  []string{"set", "0", "0x1a", "0x10", "0xff"},
+			check: func(r *[256]byte) bool { return r[0x10] == 0xff && r[0x11] == 0x11 },
+		},
+		{
+			name:  "set word",
+			args:  []string{"set", "0", "0x1a", "0x10", "0xbeef", "w"},
+			check: func(r *[256]byte) bool { return r[0x10] == 0xef && r[0x11] == 0xbe },
+		},
+		{
+			name:  "set block",
+			args:  []string{"set", "0", "0x1a", "0x10", "1", "2", "s"},
+			check: func(r *[256]byte) bool { return r[0x10] == 2 && r[0x11] == 1 && r[0x12] == 2 },
+		},
+		{
+			name:  "set I2C block",
+			args:  []string{"set", "0", "0x1a", "0x10", "1", "2", "i"},
+			check: func(r *[256]byte) bool { return r[0x10] == 1 && r[0x11] == 2 && r[0x12] == 0x12 },
+		},
+		{
+			name: "set values without block",
+			args: []string{"set", "0", "0x1a", "0x10", "1", "2"},
+			err:  usage,
+		},
+		{
+			name: "set bad value",
+			args: []string{"set", "0", "0x1a", "0x10", "0x100"},
+			err:  `invalid value "0x100"`,
+		},
+		{
+			name: "dump",
+			args: []string{"dump", "-r", "0x3c-0x47", "0", "0x1a"},
+			want: "     0  1  2  3  4  5  6  7  8  9  a  b  c  d  e  f    0123456789abcdef\n30:                                     3c 3d 3e 3f                <=>?\n40: 75 2d 72 6f 6f 74 00 47                            u-root.G\n",
+		},
+		{
+			name: "dump I2C block",
+			args: []string{"dump", "-r", "0x40-0x45", "0", "0x1a", "i"},
+			want: "     0  1  2  3  4  5  6  7  8  9  a  b  c  d  e  f    0123456789abcdef\n40: 75 2d 72 6f 6f 74                                  u-root\n",
+		},
+		{
+			name: "dump words",
+			args: []string{"dump", "-r", "0x06-0x09", "0", "0x1a", "w"},
+			want: "     0,8  1,9  2,a  3,b  4,c  5,d  6,e  7,f\n00:                               0706 0807\n08: 0908 0a09                              \n",
+		},
+		{
+			name: "dump bad range",
+			args: []string{"dump", "-r", "0x10", "0", "0x1a"},
+			err:  `invalid range "0x10"`,
+		},
+		{
+			name: "transfer",
+			args: []string{"transfer", "0", "w1@0x1a", "0x40", "r6", "r2@0x50"},
+			want: "0x75 0x2d 0x72 0x6f 0x6f 0x74\n0x23 0x11\n",
+		},
+		{
+			name: "transfer without address",
+			args: []string{"transfer", "0", "r1"},
+			err:  `message "r1" has no address`,
+		},
+		{
+			name: "transfer short",
+			args: []string{"transfer", "0", "w2@0x1a", "0x40"},
+			err:  `message "w2@0x1a" has 1 of 2 bytes`,
+		},
+		{
+			name: "transfer without I2C",
+			args: []string{"transfer", "1", "r1@0x08"},
+			err:  "adapter does not support I2C transfers",
+		},
+		{
+			name: "spd",
+			args: []string{"spd"},
+			want: "/dev/i2c-0 address 0x50:\nType: DDR4 SO-DIMM\nSize: 4096 MiB, 1 rank(s), x8 devices, 64-bit\nSpeed: DDR4-2400\nManufacturer: SK Hynix\nPart number: HMA851S6CJR6N-VK\nSerial number: 01020304\nManufactured: 2019 week 33\nCRC: ok\n\n",
+		},
+		{
+			name: "spd empty slot",
+			args: []string{"spd", "0", "0x51"},
+			err:  "spd: reading 0x51: no such device or address",
+		},
+		{
+			name: "bad address",
+			args: []string{"get", "0", "0x78"},
+			err:  "i2c: address 0x78 is out of range",
+		},
+		{
+			name: "no adapter",
+			args: []string{"get", "SMBus nForce2", "0x50", "0"},
+			err:  `no I2C adapter "SMBus nForce2"`,
+		},
+		{
+			name: "unknown",
+			args: []string{"read"},
+			err:  usage,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			buses := newBuses()
+			c := &cmd{
+				stdout: &stdout,
+				stderr: &stderr,
+				adapters: func() ([]i2c.Adapter, error) {
+					return []i2c.Adapter{{Number: 0, Name: "SMBus I801 adapter at efa0"}, {Number: 1, Name: "SMBus stub driver"}}, nil
+				},
+				open: func(p string, force bool) (bus, error) {
+					b, ok := buses[p]
+					if !ok {
+						return nil, os.ErrNotExist
+					}
+					b.force = force
+					return b, nil
+				},
+			}
+			err := c.run(tt.args)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("run(%q) = %v, want %q", tt.args, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("run(%q) = %v", tt.args, err)
+			}
+			if stdout.String() != tt.want {
+				t.Errorf("run(%q) printed\n%q\nwant\n%q", tt.args, stdout.String(), tt.want)
+			}
+			if tt.check != nil && !tt.check(buses["/dev/i2c-0"].devs[0x1a]) {
+				t.Errorf("run(%q) registers: % x", tt.args, buses["/dev/i2c-0"].devs[0x1a][0x10:0x14])
+			}
+			if buses["/dev/i2c-0"].pec != tt.pec {
+				t.Errorf("run(%q) set PEC %v, want %v", tt.args, buses["/dev/i2c-0"].pec, tt.pec)
+			}
+		})
+	}
+}
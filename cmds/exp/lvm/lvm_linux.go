@@ -0,0 +1,218 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// lvm lists and activates LVM2 volume groups.
+//
+// Synopsis:
+//     lvm pvs|vgs|lvs [DEVICE...]
+//     lvm vgchange -a y|n [VG...]
+//     lvm lvchange -a y|n VG/LV...
+//
+// Description:
+//     All block devices are scanned for physical volumes unless DEVICEs
+//     are given. Activated logical volumes show up as /dev/mapper/VG-LV
+//     and /dev/VG/LV.
+//
+// Options:
+//     -a: activate (y) or deactivate (n)
+//     -d: scan these comma separated devices instead of all
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/u-root/u-root/pkg/mount/lvm"
+)
+
+const usage = `usage:
+  lvm pvs|vgs|lvs [DEVICE...]
+  lvm vgchange [-d DEVICES] -a y|n [VG...]
+  lvm lvchange [-d DEVICES] -a y|n VG/LV...`
+
+var errUsage = errors.New(usage)
+
+type cmd struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	// scan finds volume groups on devices, or all block devices.
+	scan func(devices []string) ([]*lvm.VG, error)
+}
+
+func scan(devices []string) ([]*lvm.VG, error) {
+	if len(devices) == 0 {
+		return lvm.Scan()
+	}
+	return lvm.ScanDevices(devices), nil
+}
+
+func mib(sectors uint64) string {
+	return fmt.Sprintf("%.2fm", float64(sectors)/2048)
+}
+
+func (c *cmd) list(op string, vgs []*lvm.VG) {
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	switch op {
+	case "pvs":
+		fmt.Fprintln(w, "PV\tVG\tPSize\tUUID")
+		for _, vg := range vgs {
+			for _, pv := range vg.PVs {
+				dev := pv.Device
+				if dev == "" {
+					dev = "[unknown]"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", dev, vg.Name, mib(pv.PECount*vg.ExtentSize), pv.UUID)
+			}
+		}
+	case "vgs":
+		fmt.Fprintln(w, "VG\t#PV\t#LV\tMissing\tSeq\tUUID")
+		for _, vg := range vgs {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", vg.Name, len(vg.PVs), len(vg.LVs), len(vg.Missing()), vg.SeqNo, vg.UUID)
+		}
+	case "lvs":
+		fmt.Fprintln(w, "LV\tVG\tAttr\tLSize\tType")
+		for _, vg := range vgs {
+			for _, lv := range vg.LVs {
+				if !lv.Visible() {
+					continue
+				}
+				attr := "r"
+				if lv.Writable() {
+					attr = "w"
+				}
+				typ := ""
+				if len(lv.Segments) > 0 {
+					typ = lv.Segments[0].Type
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", lv.Name, vg.Name, attr, mib(lv.Size()*vg.ExtentSize), typ)
+			}
+		}
+	}
+}
+
+// change activates or deactivates the logical volumes named by args:
+// volume groups for vgchange, VG/LV for lvchange.
+func (c *cmd) change(op string, args []string) error {
+	fs := flag.NewFlagSet("lvm "+op, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	var (
+		activate = fs.String("a", "", "activate (y) or deactivate (n)")
+		devices  = fs.String("d", "", "scan these comma separated `DEVICES` instead of all")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*activate != "y" && *activate != "n") || (op == "lvchange" && fs.NArg() == 0) {
+		return errUsage
+	}
+	var devs []string
+	if *devices != "" {
+		devs = strings.Split(*devices, ",")
+	}
+	vgs, err := c.scan(devs)
+	if err != nil {
+		return err
+	}
+
+	type volume struct {
+		vg *lvm.VG
+		lv *lvm.LV
+	}
+	var vols []volume
+	if op == "vgchange" {
+		want := map[string]bool{}
+		for _, n := range fs.Args() {
+			want[n] = true
+		}
+		found := 0
+		for _, vg := range vgs {
+			if len(want) > 0 && !want[vg.Name] {
+				continue
+			}
+			found++
+			for _, lv := range vg.LVs {
+				if lv.Visible() {
+					vols = append(vols, volume{vg, lv})
+				}
+			}
+		}
+		if found < len(want) {
+			return fmt.Errorf("volume groups %v not found", fs.Args())
+		}
+	} else {
+		for _, a := range fs.Args() {
+			f := strings.SplitN(a, "/", 2)
+			if len(f) != 2 {
+				return fmt.Errorf("%q: want VG/LV", a)
+			}
+			var v volume
+			for _, vg := range vgs {
+				if vg.Name == f[0] {
+					v.vg = vg
+				}
+			}
+			if v.vg == nil {
+				return fmt.Errorf("volume group %s not found", f[0])
+			}
+			if v.lv, err = v.vg.LV(f[1]); err != nil {
+				return err
+			}
+			vols = append(vols, v)
+		}
+	}
+
+	var firstErr error
+	for _, v := range vols {
+		if *activate == "y" {
+			p, err := v.vg.Activate(v.lv)
+			if err != nil {
+				fmt.Fprintf(c.stderr, "%s/%s: %v\n", v.vg.Name, v.lv.Name, err)
+			} else {
+				fmt.Fprintln(c.stdout, p)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err := v.vg.Deactivate(v.lv); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch op, args := args[0], args[1:]; op {
+	case "pvs", "vgs", "lvs":
+		vgs, err := c.scan(args)
+		if err != nil {
+			return err
+		}
+		c.list(op, vgs)
+		return nil
+	case "vgchange", "lvchange":
+		return c.change(op, args)
+	default:
+		return errUsage
+	}
+}
+
+func main() {
+	c := &cmd{stdout: os.Stdout, stderr: os.Stderr, scan: scan}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
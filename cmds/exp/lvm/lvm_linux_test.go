@@ -0,0 +1,101 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/mount/lvm"
+)
+
+const metadata = `vg0 {
+id = "t4Rz8q-5nWj-LkH3-vX2P-9sTd-Qw1E-mY7uBc"
+seqno = 2
+status = ["RESIZEABLE", "READ", "WRITE"]
+extent_size = 8192
+physical_volumes {
+pv0 {
+id = "jmFb9Z-Hbxn-Gq2L-nB1G-3YbV-8WfP-2XJ0xd"
+pe_start = 2048
+pe_count = 255
+}
+}
+logical_volumes {
+root {
+id = "2Hb7cK-Vq9X-pL3a-Wn0E-dR5j-Ty8M-uZ1sFo"
+status = ["READ", "WRITE", "VISIBLE"]
+segment_count = 1
+segment1 {
+start_extent = 0
+extent_count = 100
+type = "striped"
+stripe_count = 1
+stripes = ["pv0", 0]
+}
+}
+}
+}
+`
+
+func testCmd(t *testing.T) (*cmd, *bytes.Buffer) {
+	vg, err := lvm.ParseMetadata(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vg.PVs[0].Device = "/dev/sda2"
+	var out bytes.Buffer
+	return &cmd{
+		stdout: &out,
+		stderr: &out,
+		scan:   func([]string) ([]*lvm.VG, error) { return []*lvm.VG{vg}, nil },
+	}, &out
+}
+
+func TestList(t *testing.T) {
+	for _, tt := range []struct {
+		op   string
+		want string
+	}{
+		{"pvs", "/dev/sda2  vg0  1020.00m  jmFb9Z-Hbxn-Gq2L-nB1G-3YbV-8WfP-2XJ0xd\n"},
+		{"vgs", "vg0  1    1    0        2    t4Rz8q-5nWj-LkH3-vX2P-9sTd-Qw1E-mY7uBc\n"},
+		{"lvs", "root  vg0  w     400.00m  striped\n"},
+	} {
+		c, out := testCmd(t)
+		if err := c.run([]string{tt.op}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(out.String(), tt.want) {
+			t.Errorf("%s = %q, want suffix %q", tt.op, out.String(), tt.want)
+		}
+	}
+}
+
+func TestChangeErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"lvcreate"},
+		{"vgchange"},
+		{"vgchange", "-a", "x"},
+		{"lvchange", "-a", "y"},
+	} {
+		c, _ := testCmd(t)
+		if err := c.run(args); err != errUsage {
+			t.Errorf("run(%q) = %v, want usage", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"vgchange", "-a", "y", "vg1"},
+		{"lvchange", "-a", "y", "vg0"},
+		{"lvchange", "-a", "y", "vg0/swap"},
+		{"lvchange", "-a", "y", "vg1/root"},
+	} {
+		c, _ := testCmd(t)
+		if err := c.run(args); err == nil || err == errUsage {
+			t.Errorf("run(%q) = %v, want lookup error", args, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// mdadm assembles and inspects Linux software RAID arrays.
+//
+// Synopsis:
+//     mdadm --assemble --scan
+//     mdadm --assemble MD DEVICE...
+//     mdadm --examine [--scan] [DEVICE...]
+//     mdadm --detail MD...
+//     mdadm --stop MD...
+//
+// Description:
+//     --assemble --scan assembles all arrays found on block devices.
+//     --examine --scan prints ARRAY lines for mdadm.conf.
+//
+// Options:
+//     -A, --assemble: assemble arrays
+//     -E, --examine: print member superblocks
+//     -D, --detail: print running arrays
+//     -S, --stop: stop arrays
+//     -s, --scan: use all block devices
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/mount/md"
+)
+
+const usage = `usage:
+  mdadm --assemble --scan
+  mdadm --assemble MD DEVICE...
+  mdadm --examine [--scan] [DEVICE...]
+  mdadm --detail MD...
+  mdadm --stop MD...`
+
+var errUsage = errors.New(usage)
+
+type params struct {
+	assemble, examine, detail, stop, scan bool
+}
+
+type cmd struct {
+	params
+	args   []string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func parse(args []string, stderr io.Writer) (*cmd, error) {
+	c := &cmd{stderr: stderr}
+	fs := flag.NewFlagSet("mdadm", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	for _, f := range []struct {
+		p           *bool
+		long, short string
+		usage       string
+	}{
+		{&c.assemble, "assemble", "A", "assemble arrays"},
+		{&c.examine, "examine", "E", "print member superblocks"},
+		{&c.detail, "detail", "D", "print running arrays"},
+		{&c.stop, "stop", "S", "stop arrays"},
+		{&c.scan, "scan", "s", "use all block devices"},
+	} {
+		fs.BoolVar(f.p, f.long, false, f.usage)
+		fs.BoolVar(f.p, f.short, false, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c.args = fs.Args()
+
+	modes := 0
+	for _, m := range []bool{c.assemble, c.examine, c.detail, c.stop} {
+		if m {
+			modes++
+		}
+	}
+	switch {
+	case modes != 1:
+		return nil, errUsage
+	case c.assemble && c.scan != (len(c.args) == 0):
+		return nil, errUsage
+	case c.assemble && !c.scan && len(c.args) < 2:
+		return nil, errUsage
+	case (c.detail || c.stop) && (c.scan || len(c.args) == 0):
+		return nil, errUsage
+	case c.examine && !c.scan && len(c.args) == 0:
+		return nil, errUsage
+	}
+	return c, nil
+}
+
+func (c *cmd) members() ([]md.Member, error) {
+	if c.scan && len(c.args) == 0 {
+		return md.Scan()
+	}
+	devs := c.args
+	if c.assemble {
+		devs = devs[1:]
+	}
+	return md.ScanDevices(devs), nil
+}
+
+func (c *cmd) examineMembers(ms []md.Member) {
+	if c.scan {
+		for _, a := range md.Group(ms) {
+			fmt.Fprintf(c.stdout, "ARRAY /dev/md/%s metadata=%s UUID=%s", a.ShortName(), a.Version, a.UUIDString())
+			if a.Name != "" {
+				fmt.Fprintf(c.stdout, " name=%s", a.Name)
+			}
+			fmt.Fprintln(c.stdout)
+		}
+		return
+	}
+	for i, m := range ms {
+		if i > 0 {
+			fmt.Fprintln(c.stdout)
+		}
+		fmt.Fprintf(c.stdout, "%s:\n", m.Device)
+		fmt.Fprintf(c.stdout, "          Version : %s\n", m.Version)
+		fmt.Fprintf(c.stdout, "       Array UUID : %s\n", m.UUIDString())
+		if m.Name != "" {
+			fmt.Fprintf(c.stdout, "             Name : %s\n", m.Name)
+		}
+		fmt.Fprintf(c.stdout, "    Creation Time : %s\n", m.CTime.UTC().Format("Mon Jan _2 15:04:05 2006"))
+		fmt.Fprintf(c.stdout, "       Raid Level : %s\n", m.LevelString())
+		fmt.Fprintf(c.stdout, "     Raid Devices : %d\n", m.RaidDisks)
+		fmt.Fprintf(c.stdout, "   Avail Dev Size : %d sectors\n", m.Size)
+		if m.DataOffset != 0 {
+			fmt.Fprintf(c.stdout, "      Data Offset : %d sectors\n", m.DataOffset)
+		}
+		fmt.Fprintf(c.stdout, "    Update Time : %s\n", m.UTime.UTC().Format("Mon Jan _2 15:04:05 2006"))
+		fmt.Fprintf(c.stdout, "           Events : %d\n", m.Events)
+		role := fmt.Sprintf("Active device %d", m.Role)
+		switch m.Role {
+		case md.RoleSpare:
+			role = "spare"
+		case md.RoleFaulty:
+			role = "faulty"
+		case md.RoleJournal:
+			role = "journal"
+		}
+		fmt.Fprintf(c.stdout, "      Device Role : %s\n", role)
+	}
+}
+
+func (c *cmd) assembleArrays(ms []md.Member) error {
+	arrays := md.Group(ms)
+	if len(arrays) == 0 {
+		return errors.New("no arrays found")
+	}
+	if !c.scan && len(arrays) > 1 {
+		return fmt.Errorf("devices belong to %d arrays", len(arrays))
+	}
+	var firstErr error
+	for _, a := range arrays {
+		for _, s := range a.Stale {
+			fmt.Fprintf(c.stderr, "mdadm: %s is stale (%d events, array has %d)\n", s.Device, s.Events, a.Events)
+		}
+		path := ""
+		if !c.scan {
+			path = c.args[0]
+		}
+		p, err := a.Assemble(path)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "mdadm: %v\n", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		state := ""
+		if a.Degraded() {
+			state = " (degraded)"
+		}
+		fmt.Fprintf(c.stdout, "mdadm: %s has been started with %d drives%s.\n", p, len(a.Members), state)
+	}
+	return firstErr
+}
+
+func (c *cmd) detailArray(path string) error {
+	info, disks, err := md.Detail(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "%s:\n", path)
+	fmt.Fprintf(c.stdout, "           Version : %d.%d\n", info.MajorVersion, info.MinorVersion)
+	fmt.Fprintf(c.stdout, "        Raid Level : %s\n", (&md.Superblock{Level: int(info.Level)}).LevelString())
+	fmt.Fprintf(c.stdout, "      Raid Devices : %d\n", info.RaidDisks)
+	fmt.Fprintf(c.stdout, "     Total Devices : %d\n", info.NrDisks)
+	fmt.Fprintf(c.stdout, "    Active Devices : %d\n", info.ActiveDisks)
+	fmt.Fprintf(c.stdout, "   Working Devices : %d\n", info.WorkingDisks)
+	fmt.Fprintf(c.stdout, "    Failed Devices : %d\n", info.FailedDisks)
+	fmt.Fprintf(c.stdout, "     Spare Devices : %d\n", info.SpareDisks)
+	fmt.Fprintf(c.stdout, "\n    Number   Major   Minor   RaidDevice State\n")
+	for _, d := range disks {
+		state := "spare"
+		switch {
+		case d.Faulty():
+			state = "faulty"
+		case d.Active() && d.Sync():
+			state = "active sync"
+		case d.Active():
+			state = "active"
+		}
+		fmt.Fprintf(c.stdout, "    %6d   %5d   %5d   %10d %-12s %s\n", d.Number, d.Major, d.Minor, d.RaidDisk, state, md.DeviceName(d.Major, d.Minor))
+	}
+	return nil
+}
+
+func (c *cmd) run() error {
+	switch {
+	case c.detail:
+		for _, p := range c.args {
+			if err := c.detailArray(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	case c.stop:
+		for _, p := range c.args {
+			if err := md.Stop(p); err != nil {
+				return err
+			}
+			fmt.Fprintf(c.stdout, "mdadm: stopped %s\n", p)
+		}
+		return nil
+	}
+
+	ms, err := c.members()
+	if err != nil {
+		return err
+	}
+	if c.examine {
+		c.examineMembers(ms)
+		return nil
+	}
+	return c.assembleArrays(ms)
+}
+
+func main() {
+	c, err := parse(os.Args[1:], os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.stdout = os.Stdout
+	if err := c.run(); err != nil {
+		log.Fatal(err)
+	}
+}
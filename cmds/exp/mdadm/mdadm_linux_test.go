@@ -0,0 +1,103 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want params
+		ok   bool
+	}{
+		{[]string{"--assemble", "--scan"}, params{assemble: true, scan: true}, true},
+		{[]string{"-A", "/dev/md0", "/dev/sda1", "/dev/sdb1"}, params{assemble: true}, true},
+		{[]string{"-E", "/dev/sda1"}, params{examine: true}, true},
+		{[]string{"--examine", "--scan"}, params{examine: true, scan: true}, true},
+		{[]string{"-D", "/dev/md0"}, params{detail: true}, true},
+		{[]string{"--stop", "/dev/md0"}, params{stop: true}, true},
+		{nil, params{}, false},
+		{[]string{"-A"}, params{}, false},
+		{[]string{"-A", "/dev/md0"}, params{}, false},
+		{[]string{"-A", "-s", "/dev/md0"}, params{}, false},
+		{[]string{"-A", "-D", "/dev/md0"}, params{}, false},
+		{[]string{"-D"}, params{}, false},
+		{[]string{"-E"}, params{}, false},
+	} {
+		c, err := parse(tt.args, &bytes.Buffer{})
+		if (err == nil) != tt.ok {
+			t.Errorf("parse(%q) = %v, want ok %v", tt.args, err, tt.ok)
+			continue
+		}
+		if err == nil && c.params != tt.want {
+			t.Errorf("parse(%q) = %+v, want %+v", tt.args, c.params, tt.want)
+		}
+	}
+}
+
+// member writes a device with a version 1.2 superblock.
+func member(t *testing.T, path string) {
+	b := make([]byte, 1<<20)
+	s := b[4096:]
+	binary.LittleEndian.PutUint32(s, 0xa92b4efc)
+	binary.LittleEndian.PutUint32(s[4:], 1)
+	copy(s[16:], []byte{0xde, 0xad, 0xbe, 0xef})
+	copy(s[32:], "host:root")
+	binary.LittleEndian.PutUint32(s[72:], 1)
+	binary.LittleEndian.PutUint32(s[92:], 2)
+	binary.LittleEndian.PutUint64(s[144:], 8)
+	binary.LittleEndian.PutUint32(s[220:], 1)
+	var sum uint64
+	for i := 0; i < 256; i += 4 {
+		sum += uint64(binary.LittleEndian.Uint32(s[i:]))
+	}
+	binary.LittleEndian.PutUint32(s[216:], uint32(sum)+uint32(sum>>32))
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExamine(t *testing.T) {
+	dev := filepath.Join(t.TempDir(), "sda1")
+	member(t, dev)
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--examine", "--scan", dev}, "ARRAY /dev/md/root metadata=1.2 UUID=deadbeef:00000000:00000000:00000000 name=host:root\n"},
+	} {
+		c, err := parse(tt.args, &bytes.Buffer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		c.stdout = &out
+		if err := c.run(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q = %q, want %q", tt.args, out.String(), tt.want)
+		}
+	}
+
+	c, _ := parse([]string{"-E", dev}, &bytes.Buffer{})
+	var out bytes.Buffer
+	c.stdout = &out
+	if err := c.run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"Version : 1.2\n", "Raid Level : raid1\n", "Device Role : Active device 0\n"} {
+		if !bytes.Contains(out.Bytes(), []byte(w)) {
+			t.Errorf("examine output does not contain %q:\n%s", w, out.String())
+		}
+	}
+}
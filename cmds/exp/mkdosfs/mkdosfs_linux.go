@@ -0,0 +1,229 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// mkdosfs creates FAT file systems, like mkfs.vfat.
+//
+// Synopsis:
+//     mkdosfs [-F 12|16|32] [-n LABEL] [-s SECTORS] [-S SIZE] [-i ID] [-h SECTORS] [-C] [-d DIR] [-v] DEVICE [BLOCKS]
+//
+// Description:
+//     mkdosfs formats DEVICE, a block device or image file, with a FAT
+//     file system. The FAT type and cluster size are chosen by size
+//     unless given. BLOCKS is the size of the file system in KiB, by
+//     default all of DEVICE.
+//
+//     With -d, the files and directories below DIR are copied into the
+//     new file system, so that an EFI system partition can be made in
+//     one step.
+//
+// Options:
+//     -F: FAT type, 12, 16 or 32
+//     -n: volume label
+//     -s: sectors per cluster
+//     -S: logical sector size, by default the device's
+//     -i: volume ID in hex, by default from the time
+//     -h: hidden sectors, by default the partition's start
+//     -C: create the image file DEVICE of BLOCKS KiB
+//     -d: copy the contents of DIR
+//     -v: show the file system created
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/mount/fat"
+	"golang.org/x/sys/unix"
+)
+
+const usage = "usage: mkdosfs [-F 12|16|32] [-n LABEL] [-s SECTORS] [-S SIZE] [-i ID] [-h SECTORS] [-C] [-d DIR] [-v] DEVICE [BLOCKS]"
+
+var errUsage = errors.New(usage)
+
+type cmd struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// deviceGeometry returns the logical sector size of a block device and
+// the start of its partition in 512 byte sectors.
+func deviceGeometry(f *os.File) (int, uint64, error) {
+	ss, err := unix.IoctlGetInt(int(f.Fd()), unix.BLKSSZGET)
+	if err != nil {
+		return 0, 0, &os.PathError{Op: "ioctl BLKSSZGET", Path: f.Name(), Err: err}
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return ss, 0, nil
+	}
+	b, err := os.ReadFile(fmt.Sprintf("/sys/dev/block/%d:%d/start", unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev))))
+	if err != nil {
+		// Not a partition.
+		return ss, 0, nil
+	}
+	start, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return ss, 0, nil
+	}
+	return ss, start, nil
+}
+
+// copyDir copies the files and directories below dir into fsys.
+func copyDir(fsys *fat.FS, dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			return fsys.Mkdir(name)
+		}
+		// Follow symlinks, which FAT cannot have.
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("%s: cannot copy %v", p, fi.Mode().Type())
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return fsys.WriteFile(name, data)
+	})
+}
+
+func (c *cmd) run(args []string) error {
+	f := flag.NewFlagSet("mkdosfs", flag.ContinueOnError)
+	f.SetOutput(c.stderr)
+	bits := f.Int("F", 0, "FAT type, 12, 16 or 32")
+	label := f.String("n", "", "volume label")
+	spc := f.Int("s", 0, "sectors per cluster")
+	sectorSize := f.Int("S", 0, "logical sector size")
+	id := f.String("i", "", "volume ID in hex")
+	hidden := f.Int64("h", -1, "hidden sectors")
+	create := f.Bool("C", false, "create the image file")
+	dir := f.String("d", "", "copy the contents of this directory")
+	verbose := f.Bool("v", false, "show the file system created")
+	f.Usage = func() {
+		fmt.Fprintln(c.stderr, usage)
+		f.PrintDefaults()
+	}
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() < 1 || f.NArg() > 2 {
+		return errUsage
+	}
+	dev := f.Arg(0)
+
+	var blocks int64
+	if f.NArg() == 2 {
+		var err error
+		if blocks, err = strconv.ParseInt(f.Arg(1), 10, 64); err != nil || blocks <= 0 {
+			return fmt.Errorf("invalid block count %q", f.Arg(1))
+		}
+	}
+	opts := &fat.FormatOptions{
+		Bits:       *bits,
+		Label:      *label,
+		SectorSize: *sectorSize,
+	}
+	if *id != "" {
+		serial, err := strconv.ParseUint(*id, 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid volume ID %q", *id)
+		}
+		opts.Serial = uint32(serial)
+	}
+
+	var file *os.File
+	var err error
+	if *create {
+		if blocks == 0 {
+			return fmt.Errorf("-C needs BLOCKS")
+		}
+		if file, err = os.OpenFile(dev, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644); err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := file.Truncate(blocks * 1024); err != nil {
+			return err
+		}
+	} else {
+		if file, err = os.OpenFile(dev, os.O_RDWR, 0); err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if blocks > 0 {
+		if blocks*1024 > size {
+			return fmt.Errorf("%s has %d bytes, fewer than %d KiB", dev, size, blocks)
+		}
+		size = blocks * 1024
+	}
+
+	var start uint64
+	if fi, err := file.Stat(); err == nil && fi.Mode()&fs.ModeDevice != 0 {
+		ss, s, err := deviceGeometry(file)
+		if err != nil {
+			return err
+		}
+		if opts.SectorSize == 0 {
+			opts.SectorSize = ss
+		}
+		start = s
+	}
+	ss := opts.SectorSize
+	if ss == 0 {
+		ss = 512
+	}
+	opts.ClusterSize = *spc * ss
+	if *hidden >= 0 {
+		opts.HiddenSectors = uint32(*hidden)
+	} else {
+		opts.HiddenSectors = uint32(start * 512 / uint64(ss))
+	}
+
+	fsys, err := fat.Format(file, size, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dev, err)
+	}
+	if *dir != "" {
+		if err := copyDir(fsys, *dir); err != nil {
+			return err
+		}
+	}
+	if *verbose {
+		fmt.Fprintf(c.stdout, "%s: FAT%d, %d bytes, label %q, volume ID %s\n", dev, fsys.Bits, size, fsys.Label(), fsys.UUID())
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func main() {
+	c := &cmd{stdout: os.Stdout, stderr: os.Stderr}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
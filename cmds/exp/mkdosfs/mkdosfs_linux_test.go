@@ -0,0 +1,156 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/mount/fat"
+)
+
+func TestRun(t *testing.T) {
+	src := t.TempDir()
+	for name, data := range map[string]string{
+		"EFI/BOOT/BOOTX64.EFI":           "efi binary",
+		"loader/loader.conf":             "default linux\n",
+		"loader/entries/linux-5.10.conf": "title Linux\nlinux /vmlinuz\n",
+	} {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("loader/loader.conf", filepath.Join(src, "link.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		args  []string
+		pre   func(img string)
+		want  string
+		err   string
+		bits  int
+		label string
+		files []string
+	}{
+		{
+			name: "no device",
+			args: []string{},
+			err:  usage,
+		},
+		{
+			name: "bad block count",
+			args: []string{"-C", "IMG", "many"},
+			err:  `invalid block count "many"`,
+		},
+		{
+			name: "create needs size",
+			args: []string{"-C", "IMG"},
+			err:  "-C needs BLOCKS",
+		},
+		{
+			name: "create",
+			args: []string{"-C", "-v", "-n", "esp", "-i", "1234abcd", "IMG", "1024"},
+			want: "IMG: FAT12, 1048576 bytes, label \"ESP\", volume ID 1234-ABCD\n",
+			bits: 12, label: "ESP",
+		},
+		{
+			name: "existing image",
+			args: []string{"-F", "32", "-s", "1", "-d", src, "IMG"},
+			pre: func(img string) {
+				if err := os.WriteFile(img, make([]byte, 40<<20), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			bits:  32,
+			files: []string{"EFI/BOOT/BOOTX64.EFI", "loader/loader.conf", "loader/entries/linux-5.10.conf", "link.conf"},
+		},
+		{
+			name: "part of an image",
+			args: []string{"-F", "16", "IMG", "8192"},
+			pre: func(img string) {
+				if err := os.WriteFile(img, make([]byte, 40<<20), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			bits: 16,
+		},
+		{
+			name: "too large",
+			args: []string{"IMG", "8192"},
+			pre: func(img string) {
+				if err := os.WriteFile(img, make([]byte, 1<<20), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "fewer than 8192 KiB",
+		},
+		{
+			name: "bad FAT type",
+			args: []string{"-C", "-F", "16", "IMG", "1024"},
+			err:  "too few for FAT16",
+		},
+		{
+			name: "missing image",
+			args: []string{"IMG"},
+			err:  "no such file or directory",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			img := filepath.Join(t.TempDir(), "img")
+			if tt.pre != nil {
+				tt.pre(img)
+			}
+			var args []string
+			for _, a := range tt.args {
+				args = append(args, strings.ReplaceAll(a, "IMG", img))
+			}
+			var stdout, stderr bytes.Buffer
+			c := &cmd{stdout: &stdout, stderr: &stderr}
+			err := c.run(args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("run = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.ReplaceAll(tt.want, "IMG", img); stdout.String() != want {
+				t.Errorf("stdout = %q, want %q", stdout.String(), want)
+			}
+
+			f, err := os.Open(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			fsys, err := fat.New(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fsys.Bits != tt.bits || fsys.Label() != tt.label {
+				t.Errorf("FAT%d labeled %q, want FAT%d labeled %q", fsys.Bits, fsys.Label(), tt.bits, tt.label)
+			}
+			for _, name := range tt.files {
+				got, err := fs.ReadFile(fsys, name)
+				want, _ := os.ReadFile(filepath.Join(src, name))
+				if err != nil || !bytes.Equal(got, want) {
+					t.Errorf("ReadFile(%s) = %q, %v, want %q", name, got, err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// nvme sends admin commands to NVMe controllers.
+//
+// Synopsis:
+//     nvme list
+//         List NVMe controllers.
+//     nvme id-ctrl [-o FORMAT] DEVICE
+//         Show the Identify Controller data.
+//     nvme id-ns [-o FORMAT] [-n NSID] DEVICE
+//         Show the Identify Namespace data.
+//     nvme smart-log [-o FORMAT] DEVICE
+//         Show the SMART / health log.
+//     nvme sanitize-log [-o FORMAT] DEVICE
+//         Show the status of the last sanitize operation.
+//     nvme format -force [-n NSID] [-l LBAF] [-s SES] DEVICE
+//         Format a namespace, destroying its data.
+//     nvme sanitize -force -a ACTION [-ause] [-owpass N] [-ovrpat N] [-oipbp] [-nodas] DEVICE
+//         Start sanitizing the whole NVM subsystem, destroying all data.
+//     nvme opal-discovery [-o FORMAT] DEVICE
+//         Show the TCG Level 0 Discovery data.
+//     nvme opal-unlock [-user N] [-range N,...] [-mbr-done] [-sedutil-hash] DEVICE
+//         Unlock Opal locking ranges.
+//
+// Description:
+//     DEVICE is a controller, e.g. /dev/nvme0, or a namespace, e.g.
+//     /dev/nvme0n1. The namespace defaults to that of DEVICE, else 1.
+//
+//     The opal-unlock password is prompted for on a terminal, or else
+//     read from the first line of stdin. It is used as the PIN unless
+//     -sedutil-hash is given, which hashes it with the controller's
+//     serial number like sedutil-cli does.
+//
+// Options:
+//     -o: output format, normal or json
+//     -force: really format or sanitize
+//     -n: namespace ID
+//     -l: LBA format index
+//     -s: secure erase setting: 0 none, 1 user data erase, 2 crypto erase
+//     -a: sanitize action: block-erase, overwrite, crypto-erase or exit-failure
+//     -ause: allow unrestricted sanitize exit
+//     -owpass: overwrite pass count
+//     -ovrpat: overwrite pattern
+//     -oipbp: invert the overwrite pattern between passes
+//     -nodas: do not deallocate after sanitize
+//     -user: unlock as user N rather than Admin1
+//     -range: locking ranges to unlock, 0 being the global range
+//     -mbr-done: hide the shadow MBR
+//     -sedutil-hash: hash the password like sedutil-cli
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/mount/nvme"
+	"github.com/u-root/u-root/pkg/mount/opal"
+	"golang.org/x/term"
+)
+
+const usage = `usage:
+  nvme list
+  nvme id-ctrl [-o FORMAT] DEVICE
+  nvme id-ns [-o FORMAT] [-n NSID] DEVICE
+  nvme smart-log [-o FORMAT] DEVICE
+  nvme sanitize-log [-o FORMAT] DEVICE
+  nvme format -force [-n NSID] [-l LBAF] [-s SES] DEVICE
+  nvme sanitize -force -a ACTION [-ause] [-owpass N] [-ovrpat N] [-oipbp] [-nodas] DEVICE
+  nvme opal-discovery [-o FORMAT] DEVICE
+  nvme opal-unlock [-user N] [-range N,...] [-mbr-done] [-sedutil-hash] DEVICE`
+
+var (
+	errUsage = errors.New(usage)
+	errForce = errors.New("this destroys data; use -force to proceed")
+)
+
+// device is the part of *nvme.Device used here.
+type device interface {
+	Close() error
+	NamespaceID() (uint32, error)
+	IdentifyController() (*nvme.Controller, error)
+	IdentifyNamespace(nsid uint32) (*nvme.Namespace, error)
+	SMARTLog() (*nvme.SMARTLog, error)
+	SanitizeStatus() (*nvme.SanitizeStatus, error)
+	Format(nsid uint32, o nvme.FormatOptions) error
+	Sanitize(o nvme.SanitizeOptions) error
+	opal.Transport
+}
+
+type cmd struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	controllers func() ([]string, error)
+	open        func(path string) (device, error)
+}
+
+func openDevice(path string) (device, error) {
+	return nvme.Open(path)
+}
+
+// flags returns a FlagSet for op with the output format flag.
+func (c *cmd) flags(op string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("nvme "+op, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs, fs.String("o", "normal", "output `FORMAT`, normal or json")
+}
+
+// parse parses args and opens the single device argument.
+func (c *cmd) parse(fs *flag.FlagSet, args []string) (device, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, errUsage
+	}
+	return c.open(fs.Arg(0))
+}
+
+// print writes v as JSON for the json format, or calls normal.
+func (c *cmd) print(format string, v fmt.Stringer, normal func()) error {
+	switch format {
+	case "json":
+		fmt.Fprintln(c.stdout, v)
+	case "normal":
+		normal()
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	return nil
+}
+
+func (c *cmd) list() error {
+	paths, err := c.controllers()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "%-12s %-20s %-40s %s\n", "Node", "SN", "Model", "FW Rev")
+	for _, p := range paths {
+		d, err := c.open(p)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: %v\n", p, err)
+			continue
+		}
+		ctrl, err := d.IdentifyController()
+		d.Close()
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: %v\n", p, err)
+			continue
+		}
+		fmt.Fprintf(c.stdout, "%-12s %-20s %-40s %s\n", p, ctrl.Serial, ctrl.Model, ctrl.FirmwareRevision)
+	}
+	return nil
+}
+
+func (c *cmd) idCtrl(args []string) error {
+	fs, format := c.flags("id-ctrl")
+	d, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	ctrl, err := d.IdentifyController()
+	if err != nil {
+		return err
+	}
+	return c.print(*format, ctrl, func() {
+		fmt.Fprintf(c.stdout, "vid     : %#x\n", ctrl.VendorID)
+		fmt.Fprintf(c.stdout, "ssvid   : %#x\n", ctrl.SubsystemVendorID)
+		fmt.Fprintf(c.stdout, "sn      : %s\n", ctrl.Serial)
+		fmt.Fprintf(c.stdout, "mn      : %s\n", ctrl.Model)
+		fmt.Fprintf(c.stdout, "fr      : %s\n", ctrl.FirmwareRevision)
+		fmt.Fprintf(c.stdout, "cntlid  : %d\n", ctrl.ControllerID)
+		fmt.Fprintf(c.stdout, "ver     : %s\n", ctrl.VersionString())
+		fmt.Fprintf(c.stdout, "oacs    : %#x\n", ctrl.OACS)
+		fmt.Fprintf(c.stdout, "fna     : %#x\n", ctrl.FNA)
+		fmt.Fprintf(c.stdout, "sanicap : %#x\n", ctrl.SANICAP)
+		fmt.Fprintf(c.stdout, "nn      : %d\n", ctrl.NumNamespaces)
+		fmt.Fprintf(c.stdout, "tnvmcap : %d\n", ctrl.TotalCapacity)
+		fmt.Fprintf(c.stdout, "unvmcap : %d\n", ctrl.UnallocatedCapacity)
+		fmt.Fprintf(c.stdout, "subnqn  : %s\n", ctrl.SubsystemNQN)
+	})
+}
+
+// namespaceID returns nsid if set, else the namespace of d, else 1.
+func namespaceID(d device, nsid uint) uint32 {
+	if nsid != 0 {
+		return uint32(nsid)
+	}
+	if n, err := d.NamespaceID(); err == nil {
+		return n
+	}
+	return 1
+}
+
+func (c *cmd) idNS(args []string) error {
+	fs, format := c.flags("id-ns")
+	nsid := fs.Uint("n", 0, "namespace `NSID`")
+	d, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	ns, err := d.IdentifyNamespace(namespaceID(d, *nsid))
+	if err != nil {
+		return err
+	}
+	return c.print(*format, ns, func() {
+		fmt.Fprintf(c.stdout, "nsze  : %d\n", ns.Size)
+		fmt.Fprintf(c.stdout, "ncap  : %d\n", ns.Capacity)
+		fmt.Fprintf(c.stdout, "nuse  : %d\n", ns.Utilization)
+		fmt.Fprintf(c.stdout, "nguid : %x\n", ns.NGUID)
+		fmt.Fprintf(c.stdout, "eui64 : %x\n", ns.EUI64)
+		for i, f := range ns.LBAFormats {
+			inUse := ""
+			if i == ns.FormattedLBA {
+				inUse = " (in use)"
+			}
+			fmt.Fprintf(c.stdout, "lbaf %2d : ms:%-3d lbads:%-5d rp:%d%s\n", i, f.MetadataSize, f.DataSize, f.RelativePerformance, inUse)
+		}
+	})
+}
+
+func (c *cmd) smartLog(args []string) error {
+	fs, format := c.flags("smart-log")
+	d, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	l, err := d.SMARTLog()
+	if err != nil {
+		return err
+	}
+	return c.print(*format, l, func() {
+		fmt.Fprintf(c.stdout, "critical_warning          : %#x\n", l.CriticalWarning)
+		fmt.Fprintf(c.stdout, "temperature               : %d C\n", l.Celsius())
+		fmt.Fprintf(c.stdout, "available_spare           : %d%%\n", l.AvailableSpare)
+		fmt.Fprintf(c.stdout, "available_spare_threshold : %d%%\n", l.AvailableSpareThreshold)
+		fmt.Fprintf(c.stdout, "percentage_used           : %d%%\n", l.PercentageUsed)
+		fmt.Fprintf(c.stdout, "data_units_read           : %d\n", l.DataUnitsRead)
+		fmt.Fprintf(c.stdout, "data_units_written        : %d\n", l.DataUnitsWritten)
+		fmt.Fprintf(c.stdout, "host_read_commands        : %d\n", l.HostReadCommands)
+		fmt.Fprintf(c.stdout, "host_write_commands       : %d\n", l.HostWriteCommands)
+		fmt.Fprintf(c.stdout, "controller_busy_time      : %d\n", l.ControllerBusyTime)
+		fmt.Fprintf(c.stdout, "power_cycles              : %d\n", l.PowerCycles)
+		fmt.Fprintf(c.stdout, "power_on_hours            : %d\n", l.PowerOnHours)
+		fmt.Fprintf(c.stdout, "unsafe_shutdowns          : %d\n", l.UnsafeShutdowns)
+		fmt.Fprintf(c.stdout, "media_errors              : %d\n", l.MediaErrors)
+		fmt.Fprintf(c.stdout, "num_err_log_entries       : %d\n", l.ErrorLogEntries)
+		fmt.Fprintf(c.stdout, "warning_temp_time         : %d\n", l.WarningTempTime)
+		fmt.Fprintf(c.stdout, "critical_comp_time        : %d\n", l.CriticalTempTime)
+		for i, k := range l.TemperatureSensors {
+			if k != 0 {
+				fmt.Fprintf(c.stdout, "temperature_sensor_%d      : %d C\n", i+1, int(k)-273)
+			}
+		}
+	})
+}
+
+func (c *cmd) sanitizeLog(args []string) error {
+	fs, format := c.flags("sanitize-log")
+	d, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	s, err := d.SanitizeStatus()
+	if err != nil {
+		return err
+	}
+	return c.print(*format, s, func() {
+		fmt.Fprintf(c.stdout, "status             : %s\n", s.State)
+		fmt.Fprintf(c.stdout, "progress           : %.1f%%\n", s.Percent())
+		fmt.Fprintf(c.stdout, "overwrite passes   : %d\n", s.OverwritePasses)
+		fmt.Fprintf(c.stdout, "global data erased : %t\n", s.GlobalDataErased)
+		fmt.Fprintf(c.stdout, "cdw10              : %#x\n", s.CDW10)
+		fmt.Fprintf(c.stdout, "overwrite time     : %s\n", estimate(s.OverwriteTime))
+		fmt.Fprintf(c.stdout, "block erase time   : %s\n", estimate(s.BlockEraseTime))
+		fmt.Fprintf(c.stdout, "crypto erase time  : %s\n", estimate(s.CryptoEraseTime))
+	})
+}
+
+// estimate formats a sanitize time estimate in seconds.
+func estimate(s uint32) string {
+	if s == 0xffffffff {
+		return "unknown"
+	}
+	return fmt.Sprintf("%ds", s)
+}
+
+func (c *cmd) format(args []string) error {
+	fs := flag.NewFlagSet("nvme format", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	var (
+		force = fs.Bool("force", false, "really format")
+		nsid  = fs.Uint("n", 0, "namespace `NSID`")
+		lbaf  = fs.Uint("l", 0, "`LBAF` index")
+		ses   = fs.Uint("s", 0, "secure erase setting `SES`")
+	)
+	d, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if !*force {
+		return errForce
+	}
+	if *lbaf > 63 || *ses > 2 {
+		return errUsage
+	}
+	return d.Format(namespaceID(d, *nsid), nvme.FormatOptions{
+		LBAFormat:   uint8(*lbaf),
+		SecureErase: nvme.SecureErase(*ses),
+	})
+}
+
+func (c *cmd) sanitize(args []string) error {
+	fs := flag.NewFlagSet("nvme sanitize", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	var (
+		force     = fs.Bool("force", false, "really sanitize")
+		action    = fs.String("a", "", "sanitize `ACTION`")
+		ause      = fs.Bool("ause", false, "allow unrestricted sanitize exit")
+		owpass    = fs.Uint("owpass", 1, "overwrite pass count `N`")
+		ovrpat    = fs.Uint("ovrpat", 0, "overwrite pattern `N`")
+		oipbp     = fs.Bool("oipbp", false, "invert the overwrite pattern between passes")
+		noDealloc = fs.Bool("nodas", false, "do not deallocate after sanitize")
+	)
+	d, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	a, err := nvme.ParseSanitizeAction(*action)
+	if err != nil {
+		return err
+	}
+	if *owpass > 16 || *ovrpat > 0xffffffff {
+		return errUsage
+	}
+	if !*force {
+		return errForce
+	}
+	return d.Sanitize(nvme.SanitizeOptions{
+		Action:                a,
+		AllowUnrestrictedExit: *ause,
+		// 16 passes are encoded as 0.
+		OverwritePasses:  uint8(*owpass & 0xf),
+		OverwritePattern: uint32(*ovrpat),
+		InvertPattern:    *oipbp,
+		NoDeallocate:     *noDealloc,
+	})
+}
+
+func (c *cmd) opalDiscovery(args []string) error {
+	fs, format := c.flags("opal-discovery")
+	d, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	disc, err := opal.Discover(d)
+	if err != nil {
+		return err
+	}
+	return c.print(*format, disc, func() {
+		fmt.Fprintf(c.stdout, "SSC         : %s\n", disc.SSC)
+		fmt.Fprintf(c.stdout, "Base ComID  : %#x\n", disc.BaseComID)
+		fmt.Fprintf(c.stdout, "ComIDs      : %d\n", disc.NumComIDs)
+		var codes []string
+		for _, f := range disc.Features {
+			codes = append(codes, fmt.Sprintf("%#04x", f.Code))
+		}
+		fmt.Fprintf(c.stdout, "Features    : %s\n", strings.Join(codes, " "))
+		if l := disc.Locking; l != nil {
+			fmt.Fprintf(c.stdout, "Locking     : supported %t, enabled %t, locked %t\n", l.Supported, l.Enabled, l.Locked)
+			fmt.Fprintf(c.stdout, "Shadow MBR  : enabled %t, done %t\n", l.MBREnabled, l.MBRDone)
+		}
+	})
+}
+
+// password reads the password from a terminal or stdin.
+func (c *cmd) password(device string) ([]byte, error) {
+	if f, ok := c.stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		fmt.Fprintf(c.stderr, "Enter password for %s: ", device)
+		p, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(c.stderr)
+		return p, err
+	}
+	p, err := bufio.NewReader(c.stdin).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return bytes.TrimSuffix(p, []byte("\n")), nil
+}
+
+func (c *cmd) opalUnlock(args []string) error {
+	fs := flag.NewFlagSet("nvme opal-unlock", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	var (
+		user    = fs.Uint("user", 0, "unlock as user `N` rather than Admin1")
+		ranges  = fs.String("range", "0", "comma separated locking ranges `N,...`")
+		mbrDone = fs.Bool("mbr-done", false, "hide the shadow MBR")
+		sedutil = fs.Bool("sedutil-hash", false, "hash the password like sedutil-cli")
+	)
+	d, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	o := opal.UnlockOptions{MBRDone: *mbrDone}
+	if *user > 0xff {
+		return errUsage
+	}
+	if *user != 0 {
+		o.Authority = opal.User(uint8(*user))
+	}
+	for _, r := range strings.Split(*ranges, ",") {
+		n, err := strconv.ParseUint(r, 0, 8)
+		if err != nil {
+			return fmt.Errorf("locking range %q: %w", r, err)
+		}
+		o.Ranges = append(o.Ranges, opal.LockingRange(uint8(n)))
+	}
+
+	pass, err := c.password(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	o.Credential = pass
+	if *sedutil {
+		ctrl, err := d.IdentifyController()
+		if err != nil {
+			return err
+		}
+		o.Credential = opal.SedutilHash(string(pass), []byte(ctrl.OrigSerial))
+	}
+	return opal.Unlock(d, o)
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch op, args := args[0], args[1:]; op {
+	case "list":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return c.list()
+	case "id-ctrl":
+		return c.idCtrl(args)
+	case "id-ns":
+		return c.idNS(args)
+	case "smart-log":
+		return c.smartLog(args)
+	case "sanitize-log":
+		return c.sanitizeLog(args)
+	case "format":
+		return c.format(args)
+	case "sanitize":
+		return c.sanitize(args)
+	case "opal-discovery":
+		return c.opalDiscovery(args)
+	case "opal-unlock":
+		return c.opalUnlock(args)
+	default:
+		return errUsage
+	}
+}
+
+func main() {
+	c := &cmd{
+		stdin:       os.Stdin,
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+		controllers: nvme.Controllers,
+		open:        openDevice,
+	}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/mount/nvme"
+)
+
+type fakeDevice struct {
+	format   *nvme.FormatOptions
+	formatNS uint32
+	sanitize *nvme.SanitizeOptions
+}
+
+func (d *fakeDevice) Close() error { return nil }
+
+func (d *fakeDevice) NamespaceID() (uint32, error) {
+	return 0, errors.New("not a namespace")
+}
+
+func (d *fakeDevice) IdentifyController() (*nvme.Controller, error) {
+	return &nvme.Controller{
+		VendorID:         0x144d,
+		Serial:           "S4EWNX0R123456",
+		Model:            "Samsung SSD 970 EVO Plus 1TB",
+		FirmwareRevision: "2B2QEXM7",
+		Version:          0x10300,
+		OACS:             0x17,
+		NumNamespaces:    1,
+	}, nil
+}
+
+func (d *fakeDevice) IdentifyNamespace(nsid uint32) (*nvme.Namespace, error) {
+	if nsid != 1 {
+		return nil, nvme.StatusError(0x400b)
+	}
+	return &nvme.Namespace{
+		Size:         1953525168,
+		FormattedLBA: 0,
+		LBAFormats:   []nvme.LBAFormat{{DataSize: 512}, {DataSize: 4096}},
+	}, nil
+}
+
+func (d *fakeDevice) SMARTLog() (*nvme.SMARTLog, error) {
+	return &nvme.SMARTLog{Temperature: 310, AvailableSpare: 100, PowerOnHours: 1234, TemperatureSensors: [8]uint16{305}}, nil
+}
+
+func (d *fakeDevice) SanitizeStatus() (*nvme.SanitizeStatus, error) {
+	return &nvme.SanitizeStatus{Progress: 0x4000, State: nvme.SanitizeInProgress, OverwriteTime: 0xffffffff, BlockEraseTime: 30}, nil
+}
+
+func (d *fakeDevice) Format(nsid uint32, o nvme.FormatOptions) error {
+	d.formatNS, d.format = nsid, &o
+	return nil
+}
+
+func (d *fakeDevice) Sanitize(o nvme.SanitizeOptions) error {
+	d.sanitize = &o
+	return nil
+}
+
+func (d *fakeDevice) SecuritySend(protocol uint8, spsp uint16, data []byte) error {
+	return errors.New("no TPer")
+}
+
+// SecurityReceive returns Level 0 Discovery data with the Locking and
+// Opal 2 features.
+func (d *fakeDevice) SecurityReceive(protocol uint8, spsp uint16, data []byte) error {
+	if protocol != 1 || spsp != 1 {
+		return errors.New("no TPer")
+	}
+	b := make([]byte, 48)
+	b = append(b, 0x00, 0x02, 0x10, 0x0c, 0x17, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	b = append(b, 0x02, 0x03, 0x20, 0x10, 0x07, 0xfe, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	copy(data, b)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		want     []string
+		err      error
+		format   *nvme.FormatOptions
+		sanitize *nvme.SanitizeOptions
+	}{
+		{
+			name: "list",
+			args: []string{"list"},
+			want: []string{"/dev/nvme0   S4EWNX0R123456       Samsung SSD 970 EVO Plus 1TB             2B2QEXM7\n"},
+		},
+		{
+			name: "id-ctrl",
+			args: []string{"id-ctrl", "/dev/nvme0"},
+			want: []string{"vid     : 0x144d\n", "sn      : S4EWNX0R123456\n", "ver     : 1.3\n", "oacs    : 0x17\n"},
+		},
+		{
+			name: "id-ctrl json",
+			args: []string{"id-ctrl", "-o", "json", "/dev/nvme0"},
+			want: []string{`"Model": "Samsung SSD 970 EVO Plus 1TB"`, `"VendorID": 5197`},
+		},
+		{
+			name: "id-ctrl bad format",
+			args: []string{"id-ctrl", "-o", "xml", "/dev/nvme0"},
+			err:  errors.New(`unknown output format "xml"`),
+		},
+		{
+			name: "id-ns",
+			args: []string{"id-ns", "/dev/nvme0"},
+			want: []string{"nsze  : 1953525168\n", "lbaf  0 : ms:0   lbads:512   rp:0 (in use)\n", "lbaf  1 : ms:0   lbads:4096  rp:0\n"},
+		},
+		{
+			name: "id-ns bad namespace",
+			args: []string{"id-ns", "-n", "2", "/dev/nvme0"},
+			err:  nvme.StatusError(0x400b),
+		},
+		{
+			name: "smart-log",
+			args: []string{"smart-log", "/dev/nvme0"},
+			want: []string{"temperature               : 37 C\n", "available_spare           : 100%\n", "power_on_hours            : 1234\n", "temperature_sensor_1      : 32 C\n"},
+		},
+		{
+			name: "sanitize-log",
+			args: []string{"sanitize-log", "/dev/nvme0"},
+			want: []string{"status             : in progress\n", "progress           : 25.0%\n", "overwrite time     : unknown\n", "block erase time   : 30s\n"},
+		},
+		{
+			name: "format without force",
+			args: []string{"format", "/dev/nvme0"},
+			err:  errForce,
+		},
+		{
+			name:   "format",
+			args:   []string{"format", "-force", "-l", "1", "-s", "2", "/dev/nvme0"},
+			format: &nvme.FormatOptions{LBAFormat: 1, SecureErase: nvme.CryptoErase},
+		},
+		{
+			name: "format bad SES",
+			args: []string{"format", "-force", "-s", "3", "/dev/nvme0"},
+			err:  errUsage,
+		},
+		{
+			name: "sanitize without force",
+			args: []string{"sanitize", "-a", "crypto-erase", "/dev/nvme0"},
+			err:  errForce,
+		},
+		{
+			name: "sanitize bad action",
+			args: []string{"sanitize", "-force", "-a", "shred", "/dev/nvme0"},
+			err:  errors.New(`unknown sanitize action "shred"`),
+		},
+		{
+			name:     "sanitize",
+			args:     []string{"sanitize", "-force", "-a", "overwrite", "-owpass", "16", "-ovrpat", "0xdeadbeef", "-oipbp", "-ause", "/dev/nvme0"},
+			sanitize: &nvme.SanitizeOptions{Action: nvme.SanitizeOverwrite, AllowUnrestrictedExit: true, OverwritePattern: 0xdeadbeef, InvertPattern: true},
+		},
+		{
+			name: "opal-discovery",
+			args: []string{"opal-discovery", "/dev/nvme0"},
+			want: []string{"SSC         : Opal 2\n", "Base ComID  : 0x7fe\n", "Features    : 0x0002 0x0203\n", "Locking     : supported true, enabled true, locked true\n"},
+		},
+		{
+			name: "opal-unlock bad range",
+			args: []string{"opal-unlock", "-range", "1,x", "/dev/nvme0"},
+			err:  errors.New(`locking range "x": strconv.ParseUint: parsing "x": invalid syntax`),
+		},
+		{
+			name: "no args",
+			err:  errUsage,
+		},
+		{
+			name: "no device",
+			args: []string{"smart-log"},
+			err:  errUsage,
+		},
+		{
+			name: "unknown",
+			args: []string{"fw-download", "/dev/nvme0"},
+			err:  errUsage,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			d := &fakeDevice{}
+			c := &cmd{
+				stdin:       strings.NewReader(""),
+				stdout:      &stdout,
+				stderr:      &stderr,
+				controllers: func() ([]string, error) { return []string{"/dev/nvme0"}, nil },
+				open:        func(string) (device, error) { return d, nil },
+			}
+			err := c.run(tt.args)
+			if err != tt.err && (err == nil || tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Fatalf("run(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout.String(), w) {
+					t.Errorf("output does not contain %q:\n%s", w, stdout.String())
+				}
+			}
+			if !reflect.DeepEqual(d.format, tt.format) {
+				t.Errorf("format options = %+v, want %+v", d.format, tt.format)
+			}
+			if d.format != nil && d.formatNS != 1 {
+				t.Errorf("formatted namespace %d, want 1", d.formatNS)
+			}
+			if !reflect.DeepEqual(d.sanitize, tt.sanitize) {
+				t.Errorf("sanitize options = %+v, want %+v", d.sanitize, tt.sanitize)
+			}
+		})
+	}
+}
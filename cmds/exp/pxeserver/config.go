@@ -0,0 +1,336 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// Config is the JSON configuration of the DHCPv4 part of pxeserver.
+//
+// An example:
+//
+//	{
+//	  "server_ip": "192.168.0.1",
+//	  "subnet": "192.168.0.0/24",
+//	  "pool_start": "192.168.0.100",
+//	  "pool_end": "192.168.0.200",
+//	  "lease_time": "1h",
+//	  "bootfile": "pxelinux.0",
+//	  "arch": {
+//	    "efi-x86_64": "syslinux.efi",
+//	    "efi-arm64": "grubaa64.efi"
+//	  },
+//	  "ipxe_bootfile": "http://192.168.0.1/boot.ipxe",
+//	  "clients": {
+//	    "00:11:22:33:44:55": {"ip": "192.168.0.10", "hostname": "node1"}
+//	  },
+//	  "templates": {
+//	    "boot.ipxe": "/etc/pxeserver/boot.ipxe.tmpl"
+//	  }
+//	}
+type Config struct {
+	// ServerIP is the IP of the server, used as server identifier and
+	// next server.
+	ServerIP string `json:"server_ip"`
+
+	// Subnet is the CIDR of the served network.
+	Subnet string `json:"subnet"`
+
+	// PoolStart and PoolEnd are the first and last address (inclusive)
+	// handed out to clients without a static IP.
+	PoolStart string `json:"pool_start"`
+	PoolEnd   string `json:"pool_end"`
+
+	// Router defaults to ServerIP.
+	Router string `json:"router,omitempty"`
+
+	// DNS is the list of name servers handed out to clients.
+	DNS []string `json:"dns,omitempty"`
+
+	// LeaseTime is a time.ParseDuration string. Defaults to 1h.
+	LeaseTime string `json:"lease_time,omitempty"`
+
+	// Boot is the default boot configuration for all clients.
+	Boot
+
+	// Clients maps a MAC address to per-client configuration.
+	Clients map[string]ClientConfig `json:"clients,omitempty"`
+
+	// Templates maps a file name served over TFTP and HTTP to a
+	// text/template file rendered per client. Relative paths are
+	// relative to the directory of the configuration file.
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+// Boot describes what a client should boot.
+type Boot struct {
+	// BootFile is served to clients that do not match anything in Arch.
+	BootFile string `json:"bootfile,omitempty"`
+
+	// Arch maps a client architecture (DHCP option 93) to a boot file.
+	//
+	// Keys are either decimal architecture numbers or one of the names
+	// in archNames, e.g. "bios", "efi-x86_64", "efi-arm64".
+	Arch map[string]string `json:"arch,omitempty"`
+
+	// IPXEBootFile is served instead of any other boot file to clients
+	// that identify as iPXE in their user class, to break the
+	// chainloading loop.
+	IPXEBootFile string `json:"ipxe_bootfile,omitempty"`
+
+	// RootPath is DHCP option 17.
+	RootPath string `json:"rootpath,omitempty"`
+}
+
+// ClientConfig is the configuration of a single client.
+type ClientConfig struct {
+	// IP is a static address for the client. It may be outside of the
+	// pool, but must be in the subnet.
+	IP string `json:"ip,omitempty"`
+
+	// Hostname is DHCP option 12.
+	Hostname string `json:"hostname,omitempty"`
+
+	// Boot overrides the non-empty fields of the default Boot.
+	Boot
+
+	// Vars are passed to templates.
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+var archNames = map[string]iana.Arch{
+	"bios":            iana.INTEL_X86PC,
+	"efi-ia32":        iana.EFI_IA32,
+	"efi-x86_64":      iana.EFI_X86_64,
+	"efi-bc":          iana.EFI_BC,
+	"efi-arm32":       iana.EFI_ARM32,
+	"efi-arm64":       iana.EFI_ARM64,
+	"efi-x86-http":    iana.EFI_X86_HTTP,
+	"efi-x86_64-http": iana.EFI_X86_64_HTTP,
+	"efi-arm64-http":  iana.EFI_ARM64_HTTP,
+	"efi-riscv64":     iana.EFI_RISCV64,
+}
+
+func parseArch(s string) (iana.Arch, error) {
+	if a, ok := archNames[strings.ToLower(s)]; ok {
+		return a, nil
+	}
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown architecture %q", s)
+	}
+	return iana.Arch(n), nil
+}
+
+// server4Config is the parsed form of Config.
+type server4Config struct {
+	self      net.IP
+	router    net.IP
+	dns       []net.IP
+	subnet    *net.IPNet
+	poolStart net.IP
+	poolEnd   net.IP
+	leaseTime time.Duration
+	boot      boot
+	clients   map[string]*client
+	templates map[string]string
+}
+
+type boot struct {
+	bootfile     string
+	arch         map[iana.Arch]string
+	ipxeBootfile string
+	rootpath     string
+}
+
+type client struct {
+	ip       net.IP
+	hostname string
+	boot     boot
+	vars     map[string]string
+}
+
+func parseBoot(b Boot) (boot, error) {
+	r := boot{
+		bootfile:     b.BootFile,
+		ipxeBootfile: b.IPXEBootFile,
+		rootpath:     b.RootPath,
+		arch:         make(map[iana.Arch]string),
+	}
+	for k, v := range b.Arch {
+		a, err := parseArch(k)
+		if err != nil {
+			return boot{}, err
+		}
+		r.arch[a] = v
+	}
+	return r, nil
+}
+
+// override returns b with the non-empty values in o applied on top.
+func (b boot) override(o boot) boot {
+	r := boot{
+		bootfile:     b.bootfile,
+		ipxeBootfile: b.ipxeBootfile,
+		rootpath:     b.rootpath,
+		arch:         make(map[iana.Arch]string),
+	}
+	if o.bootfile != "" {
+		r.bootfile = o.bootfile
+	}
+	if o.ipxeBootfile != "" {
+		r.ipxeBootfile = o.ipxeBootfile
+	}
+	if o.rootpath != "" {
+		r.rootpath = o.rootpath
+	}
+	for k, v := range b.arch {
+		r.arch[k] = v
+	}
+	for k, v := range o.arch {
+		r.arch[k] = v
+	}
+	return r
+}
+
+// bootFile picks the boot file for a client with the given architectures
+// that is or is not running iPXE.
+func (b boot) bootFile(archs []iana.Arch, ipxe bool) string {
+	if ipxe && b.ipxeBootfile != "" {
+		return b.ipxeBootfile
+	}
+	for _, a := range archs {
+		if f, ok := b.arch[a]; ok {
+			return f
+		}
+	}
+	return b.bootfile
+}
+
+func parseIP4(name, s string) (net.IP, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%s: invalid IPv4 address %q", name, s)
+	}
+	return ip, nil
+}
+
+func (c *Config) parse() (*server4Config, error) {
+	var (
+		s   server4Config
+		err error
+	)
+	if s.self, err = parseIP4("server_ip", c.ServerIP); err != nil {
+		return nil, err
+	}
+	if _, s.subnet, err = net.ParseCIDR(c.Subnet); err != nil {
+		return nil, fmt.Errorf("subnet: %v", err)
+	}
+	s.router = s.self
+	if c.Router != "" {
+		if s.router, err = parseIP4("router", c.Router); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range c.DNS {
+		ip, err := parseIP4("dns", d)
+		if err != nil {
+			return nil, err
+		}
+		s.dns = append(s.dns, ip)
+	}
+	if c.PoolStart != "" || c.PoolEnd != "" {
+		if s.poolStart, err = parseIP4("pool_start", c.PoolStart); err != nil {
+			return nil, err
+		}
+		if s.poolEnd, err = parseIP4("pool_end", c.PoolEnd); err != nil {
+			return nil, err
+		}
+		if !s.subnet.Contains(s.poolStart) || !s.subnet.Contains(s.poolEnd) {
+			return nil, fmt.Errorf("pool %s-%s is not in subnet %s", s.poolStart, s.poolEnd, s.subnet)
+		}
+		if ip4ToUint(s.poolStart) > ip4ToUint(s.poolEnd) {
+			return nil, fmt.Errorf("pool_start %s is after pool_end %s", s.poolStart, s.poolEnd)
+		}
+	}
+	s.leaseTime = time.Hour
+	if c.LeaseTime != "" {
+		if s.leaseTime, err = time.ParseDuration(c.LeaseTime); err != nil {
+			return nil, fmt.Errorf("lease_time: %v", err)
+		}
+	}
+	if s.boot, err = parseBoot(c.Boot); err != nil {
+		return nil, err
+	}
+
+	s.clients = make(map[string]*client)
+	for m, cc := range c.Clients {
+		mac, err := net.ParseMAC(m)
+		if err != nil {
+			return nil, fmt.Errorf("clients: %v", err)
+		}
+		cl := &client{
+			hostname: cc.Hostname,
+			vars:     cc.Vars,
+		}
+		if cc.IP != "" {
+			if cl.ip, err = parseIP4("clients["+m+"].ip", cc.IP); err != nil {
+				return nil, err
+			}
+			if !s.subnet.Contains(cl.ip) {
+				return nil, fmt.Errorf("client %s: IP %s is not in subnet %s", m, cl.ip, s.subnet)
+			}
+		}
+		b, err := parseBoot(cc.Boot)
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %v", m, err)
+		}
+		cl.boot = s.boot.override(b)
+		s.clients[mac.String()] = cl
+	}
+	s.templates = c.Templates
+	return &s, nil
+}
+
+// loadConfig reads a JSON Config from path, and resolves the relative
+// template paths in it against the directory of path.
+func loadConfig(path string) (*server4Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	s, err := c.parse()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, file := range s.templates {
+		if !filepath.IsAbs(file) {
+			s.templates[name] = filepath.Join(filepath.Dir(path), file)
+		}
+	}
+	return s, nil
+}
+
+// client returns the configuration for mac, falling back to the defaults.
+func (s *server4Config) client(mac net.HardwareAddr) *client {
+	if c, ok := s.clients[mac.String()]; ok {
+		return c
+	}
+	return &client{boot: s.boot}
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+
+	"pack.ag/tftp"
+)
+
+// templateData is passed to config templates.
+type templateData struct {
+	MAC      string
+	IP       string
+	Hostname string
+	ServerIP string
+	// Arch is the first architecture (DHCP option 93) the client
+	// announced, as a number. -1 if it announced none.
+	Arch     int
+	BootFile string
+	RootPath string
+	Vars     map[string]string
+}
+
+// fileServer serves per-client rendered templates over TFTP and HTTP and
+// falls back to serving a directory.
+type fileServer struct {
+	d         *dserver4
+	templates map[string]*template.Template
+}
+
+func newFileServer(d *dserver4) (*fileServer, error) {
+	fs := &fileServer{
+		d:         d,
+		templates: make(map[string]*template.Template),
+	}
+	for name, file := range d.conf.templates {
+		t, err := template.ParseFiles(file)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %v", name, err)
+		}
+		fs.templates[cleanName(name)] = t
+	}
+	return fs, nil
+}
+
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// render executes the template registered under name for the client with
+// the given MAC, or if mac is nil, the one leasing ip. ok is false if
+// there is no template with that name.
+func (fs *fileServer) render(name string, ip net.IP, mac net.HardwareAddr) (b []byte, ok bool, err error) {
+	t, ok := fs.templates[cleanName(name)]
+	if !ok {
+		return nil, false, nil
+	}
+	if mac == nil {
+		mac, _ = fs.d.leases.Lookup(ip)
+	}
+	data := templateData{
+		IP:       ip.String(),
+		ServerIP: fs.d.conf.self.String(),
+		Arch:     -1,
+	}
+	c := fs.d.conf.client(mac)
+	if mac != nil {
+		data.MAC = mac.String()
+		if archs := fs.d.clientArch(mac); len(archs) > 0 {
+			data.Arch = int(archs[0])
+		}
+		data.BootFile = c.boot.bootFile(fs.d.clientArch(mac), false)
+	} else {
+		data.BootFile = c.boot.bootfile
+	}
+	data.Hostname = c.hostname
+	data.RootPath = c.boot.rootpath
+	data.Vars = c.vars
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, true, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// httpHandler serves templates, and dir if not empty.
+func (fs *fileServer) httpHandler(dir string) http.Handler {
+	var files http.Handler = http.NotFoundHandler()
+	if len(dir) != 0 {
+		files = http.FileServer(http.Dir(dir))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		var mac net.HardwareAddr
+		if m := r.URL.Query().Get("mac"); m != "" {
+			if mac, err = net.ParseMAC(m); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		b, ok, err := fs.render(r.URL.Path, net.ParseIP(host), mac)
+		if !ok {
+			files.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Could not render %s for %s: %v", r.URL.Path, host, err)
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		w.Write(b)
+	})
+}
+
+type tftpHandlerFunc func(tftp.ReadRequest)
+
+func (f tftpHandlerFunc) ServeTFTP(r tftp.ReadRequest) {
+	f(r)
+}
+
+// tftpHandler serves templates, and dir if not empty.
+func (fs *fileServer) tftpHandler(dir string) tftp.ReadHandler {
+	var files tftp.ReadHandler
+	if len(dir) != 0 {
+		files = tftp.FileServer(dir)
+	}
+	return tftpHandlerFunc(func(r tftp.ReadRequest) {
+		b, ok, err := fs.render(r.Name(), r.Addr().IP, nil)
+		if !ok {
+			if files == nil {
+				r.WriteError(tftp.ErrCodeFileNotFound, "file not found")
+				return
+			}
+			files.ServeTFTP(r)
+			return
+		}
+		if err != nil {
+			log.Printf("Could not render %s for %s: %v", r.Name(), r.Addr(), err)
+			r.WriteError(tftp.ErrCodeNotDefined, "template error")
+			return
+		}
+		r.WriteSize(int64(len(b)))
+		if _, err := r.Write(b); err != nil {
+			log.Printf("Could not send %s to %s: %v", r.Name(), r.Addr(), err)
+		}
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// offerTime is how long an address offered in a DHCPOFFER stays reserved
+// for the client before it must be requested.
+const offerTime = time.Minute
+
+var errPoolExhausted = errors.New("address pool exhausted")
+
+type lease struct {
+	mac     string
+	ip      net.IP
+	expires time.Time
+}
+
+// leases tracks addresses handed out from a pool.
+type leases struct {
+	mu sync.Mutex
+
+	start, end uint32
+
+	// static maps a MAC to its reserved IP.
+	static map[string]net.IP
+
+	byMAC map[string]*lease
+	byIP  map[uint32]*lease
+
+	// declined are addresses reported in use by another host.
+	declined map[uint32]time.Time
+
+	now func() time.Time
+}
+
+func ip4ToUint(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uintToIP4(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// newLeases returns a lease table for the pool [start, end]. start and end
+// may be nil, in which case only static addresses are handed out.
+func newLeases(start, end net.IP, static map[string]net.IP) *leases {
+	l := &leases{
+		static:   static,
+		byMAC:    make(map[string]*lease),
+		byIP:     make(map[uint32]*lease),
+		declined: make(map[uint32]time.Time),
+		now:      time.Now,
+	}
+	if start != nil && end != nil {
+		l.start, l.end = ip4ToUint(start), ip4ToUint(end)
+	}
+	return l
+}
+
+func (l *leases) expire(now time.Time) {
+	for ip, le := range l.byIP {
+		if now.After(le.expires) {
+			delete(l.byIP, ip)
+			delete(l.byMAC, le.mac)
+		}
+	}
+	for ip, t := range l.declined {
+		if now.After(t) {
+			delete(l.declined, ip)
+		}
+	}
+}
+
+func (l *leases) isStatic(n uint32) bool {
+	for _, ip := range l.static {
+		if ip4ToUint(ip) == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *leases) set(mac string, ip net.IP, d time.Duration) net.IP {
+	if old, ok := l.byMAC[mac]; ok {
+		delete(l.byIP, ip4ToUint(old.ip))
+	}
+	le := &lease{mac: mac, ip: ip, expires: l.now().Add(d)}
+	l.byMAC[mac] = le
+	l.byIP[ip4ToUint(ip)] = le
+	return ip
+}
+
+// Offer picks an address for mac and reserves it for offerTime.
+//
+// A client keeps its static address or its current lease; otherwise the
+// requested address is used if free, and the first free pool address
+// if not.
+func (l *leases) Offer(mac net.HardwareAddr, requested net.IP) (net.IP, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.expire(now)
+	m := mac.String()
+
+	if ip, ok := l.static[m]; ok {
+		return l.set(m, ip, offerTime), nil
+	}
+	if le, ok := l.byMAC[m]; ok {
+		if le.expires.Before(now.Add(offerTime)) {
+			le.expires = now.Add(offerTime)
+		}
+		return le.ip, nil
+	}
+	if l.start == 0 && l.end == 0 {
+		return nil, errPoolExhausted
+	}
+	if requested != nil && requested.To4() != nil && l.free(ip4ToUint(requested)) {
+		return l.set(m, requested.To4(), offerTime), nil
+	}
+	for n := l.start; n <= l.end && n >= l.start; n++ {
+		if l.free(n) {
+			return l.set(m, uintToIP4(n), offerTime), nil
+		}
+	}
+	return nil, errPoolExhausted
+}
+
+func (l *leases) free(n uint32) bool {
+	if n < l.start || n > l.end || l.isStatic(n) {
+		return false
+	}
+	if _, ok := l.declined[n]; ok {
+		return false
+	}
+	_, ok := l.byIP[n]
+	return !ok
+}
+
+// Request confirms that mac may use ip for d. It returns false if the
+// address belongs to someone else or is not ours to give.
+func (l *leases) Request(mac net.HardwareAddr, ip net.IP, d time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire(l.now())
+	m := mac.String()
+	ip = ip.To4()
+	if ip == nil {
+		return false
+	}
+	if sip, ok := l.static[m]; ok {
+		if !sip.Equal(ip) {
+			return false
+		}
+		l.set(m, ip, d)
+		return true
+	}
+	if le, ok := l.byIP[ip4ToUint(ip)]; ok {
+		if le.mac != m {
+			return false
+		}
+		l.set(m, ip, d)
+		return true
+	}
+	if !l.free(ip4ToUint(ip)) {
+		return false
+	}
+	l.set(m, ip, d)
+	return true
+}
+
+// Release frees the lease of mac on ip.
+func (l *leases) Release(mac net.HardwareAddr, ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m := mac.String()
+	if le, ok := l.byMAC[m]; ok && (ip == nil || le.ip.Equal(ip)) {
+		delete(l.byMAC, m)
+		delete(l.byIP, ip4ToUint(le.ip))
+	}
+}
+
+// Decline marks ip as used by an unknown host for a lease period.
+func (l *leases) Decline(mac net.HardwareAddr, ip net.IP, d time.Duration) {
+	l.Release(mac, ip)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ip.To4() != nil {
+		l.declined[ip4ToUint(ip)] = l.now().Add(d)
+	}
+}
+
+// Lookup returns the MAC holding a lease on ip.
+func (l *leases) Lookup(ip net.IP) (net.HardwareAddr, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ip.To4() == nil {
+		return nil, false
+	}
+	le, ok := l.byIP[ip4ToUint(ip)]
+	if !ok || l.now().After(le.expires) {
+		return nil, false
+	}
+	mac, err := net.ParseMAC(le.mac)
+	if err != nil {
+		return nil, false
+	}
+	return mac, true
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func mustMAC(t *testing.T, s string) net.HardwareAddr {
+	t.Helper()
+	m, err := net.ParseMAC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLeases(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := mustMAC(t, "00:00:00:00:00:0a")
+	b := mustMAC(t, "00:00:00:00:00:0b")
+	c := mustMAC(t, "00:00:00:00:00:0c")
+	st := mustMAC(t, "00:00:00:00:00:ff")
+
+	l := newLeases(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 11), map[string]net.IP{
+		st.String(): net.IPv4(10, 0, 0, 5).To4(),
+	})
+	l.now = func() time.Time { return now }
+
+	ipA, err := l.Offer(a, nil)
+	if err != nil || !ipA.Equal(net.IPv4(10, 0, 0, 10)) {
+		t.Fatalf("Offer(a) = %v, %v, want 10.0.0.10", ipA, err)
+	}
+	// The same client gets the same offer again.
+	if ip, err := l.Offer(a, nil); err != nil || !ip.Equal(ipA) {
+		t.Errorf("second Offer(a) = %v, %v, want %v", ip, err, ipA)
+	}
+	// Someone else may not take it.
+	if ip, err := l.Offer(b, ipA); err != nil || !ip.Equal(net.IPv4(10, 0, 0, 11)) {
+		t.Errorf("Offer(b) = %v, %v, want 10.0.0.11", ip, err)
+	}
+	if _, err := l.Offer(c, nil); err != errPoolExhausted {
+		t.Errorf("Offer(c) = %v, want %v", err, errPoolExhausted)
+	}
+	if l.Request(b, ipA, time.Hour) {
+		t.Errorf("Request(b, %v) succeeded, want failure", ipA)
+	}
+	if !l.Request(a, ipA, time.Hour) {
+		t.Errorf("Request(a, %v) failed", ipA)
+	}
+	if mac, ok := l.Lookup(ipA); !ok || mac.String() != a.String() {
+		t.Errorf("Lookup(%v) = %v, %v, want %v", ipA, mac, ok, a)
+	}
+
+	// The static client always gets its address, which is not in the pool.
+	if ip, err := l.Offer(st, nil); err != nil || !ip.Equal(net.IPv4(10, 0, 0, 5)) {
+		t.Errorf("Offer(static) = %v, %v, want 10.0.0.5", ip, err)
+	}
+	if l.Request(st, net.IPv4(10, 0, 0, 11), time.Hour) {
+		t.Errorf("Request(static, 10.0.0.11) succeeded, want failure")
+	}
+
+	// b's offer expires, so c can get it.
+	now = now.Add(2 * offerTime)
+	if ip, err := l.Offer(c, nil); err != nil || !ip.Equal(net.IPv4(10, 0, 0, 11)) {
+		t.Errorf("Offer(c) after expiry = %v, %v, want 10.0.0.11", ip, err)
+	}
+
+	// a's lease is still valid.
+	if _, ok := l.Lookup(ipA); !ok {
+		t.Errorf("Lookup(%v) after offer expiry failed", ipA)
+	}
+	l.Release(a, ipA)
+	if _, ok := l.Lookup(ipA); ok {
+		t.Errorf("Lookup(%v) after release succeeded", ipA)
+	}
+
+	// Declined addresses are not handed out.
+	l.Decline(b, ipA, time.Hour)
+	if _, err := l.Offer(b, nil); err != errPoolExhausted {
+		t.Errorf("Offer(b) after decline = %v, want %v", err, errPoolExhausted)
+	}
+}
+
+func TestLeasesNoPool(t *testing.T) {
+	l := newLeases(nil, nil, nil)
+	if _, err := l.Offer(mustMAC(t, "00:00:00:00:00:0a"), net.IPv4(10, 0, 0, 1)); err != errPoolExhausted {
+		t.Errorf("Offer = %v, want %v", err, errPoolExhausted)
+	}
+}
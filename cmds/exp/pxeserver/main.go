@@ -4,8 +4,17 @@
 
 // pxeserver is a test & lab PXE server that supports TFTP, HTTP, and DHCPv4.
 //
-// pxeserver can either respond to *all* DHCP requests, or a DHCP request from
-// a specific MAC. In either case, it will supply the same IP in all answers.
+// Without a config file, pxeserver can either respond to *all* DHCP requests,
+// or a DHCP request from a specific MAC. In either case, it will supply the
+// same IP in all answers.
+//
+// With -config, pxeserver reads a JSON file (see Config) describing an
+// address pool with lease tracking, static per-MAC addresses, boot files
+// per client architecture (DHCP option 93), a boot file for iPXE clients
+// to break chainloading loops, and text/template files that are rendered
+// per client and served over TFTP and HTTP, e.g. iPXE scripts or pxelinux
+// configs. The client of a template is found by its leased IP, or over HTTP
+// by a "mac" query parameter.
 package main
 
 import (
@@ -17,6 +26,7 @@ import (
 	"net"
 	"net/http"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,11 +34,13 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/dhcpv6/server6"
+	"github.com/insomniacslk/dhcp/iana"
 	"pack.ag/tftp"
 )
 
 var (
-	mac = flag.String("mac", "", "MAC address to respond to. Responds to all requests if unspecified.")
+	mac    = flag.String("mac", "", "MAC address to respond to. Responds to all requests if unspecified.")
+	config = flag.String("config", "", "JSON config file for DHCPv4. Overrides -ip, -your-ip, -rootpath and -bootfilename")
 
 	// DHCPv4-specific
 	ipv4         = flag.Bool("4", true, "IPv4 DHCP server")
@@ -51,57 +63,195 @@ var (
 )
 
 type dserver4 struct {
-	mac          net.HardwareAddr
-	yourIP       net.IP
-	submask      net.IPMask
-	self         net.IP
-	bootfilename string
-	rootpath     string
+	// mac, if set, is the only client answered.
+	mac net.HardwareAddr
+
+	conf *server4Config
+
+	// sharedIP, if set, is handed to every client without lease
+	// tracking. This is the behavior without a config file.
+	sharedIP net.IP
+	leases   *leases
+
+	// archs remembers the architectures a client announced, for
+	// templates.
+	mu    sync.Mutex
+	archs map[string][]iana.Arch
 }
 
-func (s *dserver4) dhcpHandler(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
-	log.Printf("Handling request %v for peer %v", m, peer)
+func newDServer4(conf *server4Config, mac net.HardwareAddr, sharedIP net.IP) *dserver4 {
+	static := make(map[string]net.IP)
+	for m, c := range conf.clients {
+		if c.ip != nil {
+			static[m] = c.ip
+		}
+	}
+	return &dserver4{
+		mac:      mac,
+		conf:     conf,
+		sharedIP: sharedIP,
+		leases:   newLeases(conf.poolStart, conf.poolEnd, static),
+		archs:    make(map[string][]iana.Arch),
+	}
+}
+
+// isIPXE returns whether m was sent by iPXE, which identifies itself in
+// the user class.
+func isIPXE(m *dhcpv4.DHCPv4) bool {
+	for _, uc := range m.UserClass() {
+		if uc == "iPXE" {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTTPBoot(archs []iana.Arch) bool {
+	for _, a := range archs {
+		if strings.HasSuffix(a.String(), "from HTTP") {
+			return true
+		}
+	}
+	return false
+}
 
-	var replyType dhcpv4.MessageType
+// clientArch returns the architectures last announced by mac.
+func (s *dserver4) clientArch(mac net.HardwareAddr) []iana.Arch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.archs[mac.String()]
+}
+
+// reply computes the answer to m. A nil reply and nil error mean that
+// m must not be answered.
+func (s *dserver4) reply(m *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	if s.mac != nil && !bytes.Equal(m.ClientHWAddr, s.mac) {
+		log.Printf("Not responding to DHCP request for mac %s, which does not match %s", m.ClientHWAddr, s.mac)
+		return nil, nil
+	}
+	if archs := m.ClientArch(); len(archs) > 0 {
+		s.mu.Lock()
+		s.archs[m.ClientHWAddr.String()] = archs
+		s.mu.Unlock()
+	}
+
+	var (
+		replyType dhcpv4.MessageType
+		yourIP    net.IP
+		err       error
+	)
 	switch mt := m.MessageType(); mt {
 	case dhcpv4.MessageTypeDiscover:
 		replyType = dhcpv4.MessageTypeOffer
+		yourIP = s.sharedIP
+		if yourIP == nil {
+			if yourIP, err = s.leases.Offer(m.ClientHWAddr, m.RequestedIPAddress()); err != nil {
+				return nil, err
+			}
+		}
+
 	case dhcpv4.MessageTypeRequest:
+		// The client chose a different server.
+		if sid := m.ServerIdentifier(); sid != nil && !sid.Equal(s.conf.self) {
+			if s.sharedIP == nil {
+				s.leases.Release(m.ClientHWAddr, nil)
+			}
+			return nil, nil
+		}
 		replyType = dhcpv4.MessageTypeAck
+		yourIP = m.RequestedIPAddress()
+		if yourIP == nil {
+			// Renewing or rebinding.
+			yourIP = m.ClientIPAddr
+		}
+		if s.sharedIP != nil {
+			yourIP = s.sharedIP
+		} else if !s.leases.Request(m.ClientHWAddr, yourIP, s.conf.leaseTime) {
+			replyType = dhcpv4.MessageTypeNak
+		}
+
+	case dhcpv4.MessageTypeInform:
+		replyType = dhcpv4.MessageTypeAck
+
+	case dhcpv4.MessageTypeRelease:
+		if s.sharedIP == nil {
+			s.leases.Release(m.ClientHWAddr, m.ClientIPAddr)
+		}
+		return nil, nil
+
+	case dhcpv4.MessageTypeDecline:
+		if s.sharedIP == nil {
+			s.leases.Decline(m.ClientHWAddr, m.RequestedIPAddress(), s.conf.leaseTime)
+		}
+		return nil, nil
+
 	default:
 		log.Printf("Can't handle type %v", mt)
-		return
+		return nil, nil
 	}
-	if s.mac != nil && !bytes.Equal(m.ClientHWAddr, s.mac) {
-		log.Printf("Not responding to DHCP request for mac %s, which does not match %s", m.ClientHWAddr, s.mac)
-		return
+
+	if replyType == dhcpv4.MessageTypeNak {
+		return dhcpv4.NewReplyFromRequest(m,
+			dhcpv4.WithMessageType(replyType),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(s.conf.self)),
+		)
 	}
 
-	reply, err := dhcpv4.NewReplyFromRequest(m,
+	c := s.conf.client(m.ClientHWAddr)
+	mods := []dhcpv4.Modifier{
 		dhcpv4.WithMessageType(replyType),
-		dhcpv4.WithServerIP(s.self),
-		dhcpv4.WithRouter(s.self),
-		dhcpv4.WithNetmask(s.submask),
-		dhcpv4.WithYourIP(s.yourIP),
+		dhcpv4.WithServerIP(s.conf.self),
+		dhcpv4.WithRouter(s.conf.router),
+		dhcpv4.WithNetmask(s.conf.subnet.Mask),
 		// RFC 2131, Section 4.3.1. Server Identifier: MUST
-		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(s.self)),
-		// RFC 2131, Section 4.3.1. IP lease time: MUST
-		dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(dhcpv4.MaxLeaseTime)),
-	)
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(s.conf.self)),
+	}
+	if m.MessageType() != dhcpv4.MessageTypeInform {
+		mods = append(mods,
+			dhcpv4.WithYourIP(yourIP),
+			// RFC 2131, Section 4.3.1. IP lease time: MUST
+			dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(s.conf.leaseTime)),
+		)
+	}
+	if len(s.conf.dns) > 0 {
+		mods = append(mods, dhcpv4.WithDNS(s.conf.dns...))
+	}
+	if len(c.hostname) > 0 {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptHostName(c.hostname)))
+	}
+	reply, err := dhcpv4.NewReplyFromRequest(m, mods...)
+	if err != nil {
+		return nil, err
+	}
+
 	// RFC 6842, MUST include Client Identifier if client specified one.
 	if val := m.Options.Get(dhcpv4.OptionClientIdentifier); len(val) > 0 {
 		reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientIdentifier, val))
 	}
-	if len(s.bootfilename) > 0 {
-		reply.BootFileName = s.bootfilename
+	if bf := c.boot.bootFile(m.ClientArch(), isIPXE(m)); len(bf) > 0 {
+		reply.BootFileName = bf
+	}
+	// UEFI HTTP boot clients ignore offers without this class.
+	if isHTTPBoot(m.ClientArch()) {
+		reply.UpdateOption(dhcpv4.OptClassIdentifier("HTTPClient"))
 	}
-	if len(s.rootpath) > 0 {
-		reply.UpdateOption(dhcpv4.OptRootPath(s.rootpath))
+	if len(c.boot.rootpath) > 0 {
+		reply.UpdateOption(dhcpv4.OptRootPath(c.boot.rootpath))
 	}
+	return reply, nil
+}
+
+func (s *dserver4) dhcpHandler(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+	log.Printf("Handling request %v for peer %v", m, peer)
+
+	reply, err := s.reply(m)
 	if err != nil {
 		log.Printf("Could not create reply for %v: %v", m, err)
 		return
 	}
+	if reply == nil {
+		return
+	}
 
 	// Experimentally determined. You can't just blindly send a broadcast packet
 	// with the broadcast address. You can, however, send a broadcast packet
@@ -112,7 +262,7 @@ func (s *dserver4) dhcpHandler(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHC
 	// because this is not that expensive and it's just a tiny bit easier to
 	// follow IMHO.
 	if runtime.GOOS == "darwin" {
-		p := &net.UDPAddr{IP: s.yourIP.Mask(s.submask), Port: 68}
+		p := &net.UDPAddr{IP: s.conf.subnet.IP, Port: 68}
 		log.Printf("Changing %v to %v", peer, p)
 		peer = p
 	}
@@ -195,13 +345,39 @@ func main() {
 			log.Fatal(err)
 		}
 	}
-	yourIP, yourNet, err := net.ParseCIDR(*yourIP)
+
+	var (
+		conf     *server4Config
+		sharedIP net.IP
+		err      error
+	)
+	if len(*config) != 0 {
+		if conf, err = loadConfig(*config); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		var subnet *net.IPNet
+		sharedIP, subnet, err = net.ParseCIDR(*yourIP)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b := boot{bootfile: *bootfilename, rootpath: *rootpath}
+		conf = &server4Config{
+			self:      net.ParseIP(*selfIP),
+			router:    net.ParseIP(*selfIP),
+			subnet:    subnet,
+			leaseTime: dhcpv4.MaxLeaseTime,
+			boot:      b,
+		}
+	}
+	s4 := newDServer4(conf, maca, sharedIP)
+	fs, err := newFileServer(s4)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	var wg sync.WaitGroup
-	if len(*tftpDir) != 0 {
+	if len(*tftpDir) != 0 || len(conf.templates) != 0 {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -211,15 +387,15 @@ func main() {
 			}
 
 			log.Println("starting file server")
-			server.ReadHandler(tftp.FileServer(*tftpDir))
+			server.ReadHandler(fs.tftpHandler(*tftpDir))
 			log.Fatal(server.ListenAndServe())
 		}()
 	}
-	if len(*httpDir) != 0 {
+	if len(*httpDir) != 0 || len(conf.templates) != 0 {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			http.Handle("/", http.FileServer(http.Dir(*httpDir)))
+			http.Handle("/", fs.httpHandler(*httpDir))
 			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *httpPort), nil))
 		}()
 	}
@@ -228,17 +404,8 @@ func main() {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			s := &dserver4{
-				mac:          maca,
-				self:         net.ParseIP(*selfIP),
-				yourIP:       yourIP,
-				submask:      yourNet.Mask,
-				bootfilename: *bootfilename,
-				rootpath:     *rootpath,
-			}
-
 			laddr := &net.UDPAddr{Port: dhcpv4.ServerPort}
-			server, err := server4.NewServer(*inf, laddr, s.dhcpHandler)
+			server, err := server4.NewServer(*inf, laddr, s4.dhcpHandler)
 			if err != nil {
 				log.Fatal(err)
 			}
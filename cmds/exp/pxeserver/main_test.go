@@ -0,0 +1,292 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+const testConfig = `{
+	"server_ip": "192.168.0.1",
+	"subnet": "192.168.0.0/24",
+	"pool_start": "192.168.0.100",
+	"pool_end": "192.168.0.101",
+	"dns": ["192.168.0.53"],
+	"bootfile": "pxelinux.0",
+	"arch": {
+		"efi-x86_64": "syslinux.efi",
+		"11": "grubaa64.efi",
+		"efi-x86_64-http": "http://192.168.0.1/shim.efi"
+	},
+	"ipxe_bootfile": "http://192.168.0.1/boot.ipxe",
+	"clients": {
+		"00:11:22:33:44:55": {
+			"ip": "192.168.0.10",
+			"hostname": "node1",
+			"bootfile": "node1.0",
+			"vars": {"kernel": "vmlinuz-node1"}
+		}
+	},
+	"templates": {
+		"boot.ipxe": "boot.ipxe.tmpl"
+	}
+}`
+
+const testTemplate = `#!ipxe
+kernel http://{{.ServerIP}}/{{or .Vars.kernel "vmlinuz"}} ip={{.IP}} arch={{.Arch}}
+boot
+`
+
+func testServer(t *testing.T) *dserver4 {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "boot.ipxe.tmpl"), []byte(testTemplate), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(c, []byte(testConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := loadConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newDServer4(conf, nil, nil)
+}
+
+func request(t *testing.T, mt dhcpv4.MessageType, mac string, mods ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+	m, err := dhcpv4.New(append([]dhcpv4.Modifier{
+		dhcpv4.WithMessageType(mt),
+		dhcpv4.WithHwAddr(mustMAC(t, mac)),
+	}, mods...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestReply(t *testing.T) {
+	s := testServer(t)
+	for _, tt := range []struct {
+		name     string
+		mac      string
+		mods     []dhcpv4.Modifier
+		yourIP   string
+		bootfile string
+		hostname string
+		class    string
+	}{
+		{
+			name:     "bios",
+			mac:      "00:00:00:00:00:01",
+			mods:     []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC))},
+			yourIP:   "192.168.0.100",
+			bootfile: "pxelinux.0",
+		},
+		{
+			name:     "uefi",
+			mac:      "00:00:00:00:00:02",
+			mods:     []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64))},
+			yourIP:   "192.168.0.101",
+			bootfile: "syslinux.efi",
+		},
+		{
+			name: "pool exhausted",
+			mac:  "00:00:00:00:00:03",
+			mods: []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_ARM64))},
+		},
+		{
+			name: "static arm64",
+			mac:  "00:11:22:33:44:55",
+			mods: []dhcpv4.Modifier{
+				dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_ARM64)),
+			},
+			yourIP:   "192.168.0.10",
+			bootfile: "grubaa64.efi",
+			hostname: "node1",
+		},
+		{
+			name:     "static no arch",
+			mac:      "00:11:22:33:44:55",
+			yourIP:   "192.168.0.10",
+			bootfile: "node1.0",
+			hostname: "node1",
+		},
+		{
+			name: "ipxe",
+			mac:  "00:00:00:00:00:01",
+			mods: []dhcpv4.Modifier{
+				dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+				dhcpv4.WithUserClass("iPXE", false),
+			},
+			yourIP:   "192.168.0.100",
+			bootfile: "http://192.168.0.1/boot.ipxe",
+		},
+		{
+			name: "http boot",
+			mac:  "00:00:00:00:00:02",
+			mods: []dhcpv4.Modifier{
+				dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64_HTTP)),
+			},
+			yourIP:   "192.168.0.101",
+			bootfile: "http://192.168.0.1/shim.efi",
+			class:    "HTTPClient",
+		},
+	} {
+		// Subtests run in order on the same server, so leases accumulate.
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := s.reply(request(t, dhcpv4.MessageTypeDiscover, tt.mac, tt.mods...))
+			if tt.yourIP == "" {
+				if err != errPoolExhausted {
+					t.Fatalf("reply = %v, %v, want error %v", reply, err, errPoolExhausted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reply.MessageType(); got != dhcpv4.MessageTypeOffer {
+				t.Errorf("message type = %v, want %v", got, dhcpv4.MessageTypeOffer)
+			}
+			if got := reply.YourIPAddr.String(); got != tt.yourIP {
+				t.Errorf("your IP = %v, want %v", got, tt.yourIP)
+			}
+			if reply.BootFileName != tt.bootfile {
+				t.Errorf("bootfile = %q, want %q", reply.BootFileName, tt.bootfile)
+			}
+			if got := reply.HostName(); got != tt.hostname {
+				t.Errorf("hostname = %q, want %q", got, tt.hostname)
+			}
+			if got := reply.ClassIdentifier(); got != tt.class {
+				t.Errorf("class identifier = %q, want %q", got, tt.class)
+			}
+			if got := reply.DNS(); len(got) != 1 || !got[0].Equal(net.IPv4(192, 168, 0, 53)) {
+				t.Errorf("DNS = %v, want [192.168.0.53]", got)
+			}
+		})
+	}
+}
+
+func TestRequest(t *testing.T) {
+	s := testServer(t)
+	a := "00:00:00:00:00:01"
+	b := "00:00:00:00:00:02"
+
+	offer, err := s.reply(request(t, dhcpv4.MessageTypeDiscover, a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := s.reply(request(t, dhcpv4.MessageTypeRequest, a,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(offer.YourIPAddr)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(s.conf.self))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.MessageType() != dhcpv4.MessageTypeAck || !ack.YourIPAddr.Equal(offer.YourIPAddr) {
+		t.Errorf("request for %v = %v, want ACK", offer.YourIPAddr, ack.Summary())
+	}
+
+	nak, err := s.reply(request(t, dhcpv4.MessageTypeRequest, b,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(offer.YourIPAddr))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nak.MessageType() != dhcpv4.MessageTypeNak {
+		t.Errorf("request of leased IP by other client = %v, want NAK", nak.Summary())
+	}
+
+	// Requests for other servers are ignored and drop our offer.
+	if r, err := s.reply(request(t, dhcpv4.MessageTypeRequest, b,
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 168, 0, 2))))); r != nil || err != nil {
+		t.Errorf("request for other server = %v, %v, want nil, nil", r, err)
+	}
+
+	if r, err := s.reply(request(t, dhcpv4.MessageTypeRelease, a, dhcpv4.WithClientIP(offer.YourIPAddr))); r != nil || err != nil {
+		t.Errorf("release = %v, %v, want nil, nil", r, err)
+	}
+	if _, ok := s.leases.Lookup(offer.YourIPAddr); ok {
+		t.Errorf("%v still leased after release", offer.YourIPAddr)
+	}
+}
+
+func TestSharedIP(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.168.0.2/24")
+	conf := &server4Config{
+		self:   net.IPv4(192, 168, 0, 1),
+		router: net.IPv4(192, 168, 0, 1),
+		subnet: subnet,
+		boot:   boot{bootfile: "pxelinux.0"},
+	}
+	s := newDServer4(conf, mustMAC(t, "00:00:00:00:00:01"), net.IPv4(192, 168, 0, 2))
+	for _, mac := range []string{"00:00:00:00:00:01", "00:00:00:00:00:01"} {
+		r, err := s.reply(request(t, dhcpv4.MessageTypeDiscover, mac))
+		if err != nil || r == nil || !r.YourIPAddr.Equal(net.IPv4(192, 168, 0, 2)) || r.BootFileName != "pxelinux.0" {
+			t.Errorf("reply = %v, %v, want 192.168.0.2 and pxelinux.0", r, err)
+		}
+	}
+	if r, err := s.reply(request(t, dhcpv4.MessageTypeDiscover, "00:00:00:00:00:02")); r != nil || err != nil {
+		t.Errorf("reply to filtered MAC = %v, %v, want nil, nil", r, err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	s := testServer(t)
+	fs, err := newFileServer(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.reply(request(t, dhcpv4.MessageTypeDiscover, "00:11:22:33:44:55",
+		dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_ARM64)))); err != nil {
+		t.Fatal(err)
+	}
+	b, ok, err := fs.render("/boot.ipxe", net.IPv4(192, 168, 0, 10), nil)
+	if !ok || err != nil {
+		t.Fatalf("render = %v, %v", ok, err)
+	}
+	want := "#!ipxe\nkernel http://192.168.0.1/vmlinuz-node1 ip=192.168.0.10 arch=11\nboot\n"
+	if string(b) != want {
+		t.Errorf("render = %q, want %q", b, want)
+	}
+
+	if _, ok, _ := fs.render("nothere", net.IPv4(192, 168, 0, 10), nil); ok {
+		t.Errorf("render of unknown template succeeded")
+	}
+
+	srv := httptest.NewServer(fs.httpHandler(""))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/boot.ipxe?mac=00:00:00:00:00:09")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "#!ipxe\nkernel http://192.168.0.1/vmlinuz ip=127.0.0.1 arch=-1\nboot\n"
+	if string(body) != want {
+		t.Errorf("GET boot.ipxe = %q, want %q", body, want)
+	}
+
+	resp, err = http.Get(srv.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /other = %v, want 404", resp.Status)
+	}
+}
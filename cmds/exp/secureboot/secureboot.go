@@ -0,0 +1,210 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// secureboot reports the UEFI Secure Boot state and signature databases,
+// and verifies PE images against them.
+//
+// Synopsis:
+//     secureboot [-d DIR] [status]
+//         Print SecureBoot, SetupMode and the enrolled certificates.
+//     secureboot [-d DIR] list [DATABASE...]
+//         Print the signatures of the databases, or of all of them.
+//     secureboot [-d DIR] verify [-db FILE] [-dbx FILE] IMAGE...
+//         Verify the Authenticode signatures of images.
+//
+// Description:
+//     The databases are PK, KEK, db, dbx, and the shim MOK lists MokListRT
+//     and MokListXRT.
+//
+//     verify checks images like shim does: they are allowed by db and
+//     MokListRT, and forbidden by dbx and MokListXRT. -db and -dbx replace
+//     them with EFI signature list files.
+//
+// Options:
+//     -d: the sysfs efivars directory, default /sys/firmware/efi/vars
+//     -db: EFI signature list of allowed certificates and digests
+//     -dbx: EFI signature list of forbidden certificates and digests
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/uefivars"
+	"github.com/u-root/u-root/pkg/uefivars/secureboot"
+)
+
+const usage = `usage:
+  secureboot [-d DIR] [status]
+  secureboot [-d DIR] list [DATABASE...]
+  secureboot [-d DIR] verify [-db FILE] [-dbx FILE] IMAGE...`
+
+var errUsage = errors.New(usage)
+
+type cmd struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func enabled(b bool) string {
+	if b {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func (c *cmd) status() error {
+	s, err := secureboot.ReadState()
+	if err != nil {
+		return fmt.Errorf("reading Secure Boot state, is this a UEFI system? %w", err)
+	}
+	fmt.Fprintf(c.stdout, "SecureBoot: %s\n", enabled(s.SecureBoot))
+	fmt.Fprintf(c.stdout, "SetupMode: %s\n", enabled(s.SetupMode))
+	for _, name := range secureboot.Databases {
+		d, err := secureboot.ReadDatabase(name)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(c.stdout, "%s: not present\n", name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var certs, digests int
+		for _, s := range d {
+			switch {
+			case s.Certificate != nil:
+				certs++
+			case s.Type == secureboot.CertSHA256:
+				digests++
+			}
+		}
+		fmt.Fprintf(c.stdout, "%s: %d certificates, %d SHA-256 digests, %d other signatures\n", name, certs, digests, len(d)-certs-digests)
+		for _, cert := range d.Certificates() {
+			fmt.Fprintf(c.stdout, "\t%s\n", cert.Subject)
+		}
+	}
+	return nil
+}
+
+func (c *cmd) list(names []string) error {
+	if len(names) == 0 {
+		names = secureboot.Databases
+	}
+	for _, name := range names {
+		d, err := secureboot.ReadDatabase(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "%s:\n", name)
+		for _, s := range d {
+			fmt.Fprintf(c.stdout, "\t%s\n", s)
+		}
+	}
+	return nil
+}
+
+// readDatabases reads a signature list file, or concatenates the databases
+// that exist.
+func readDatabases(file string, names ...string) (secureboot.Database, error) {
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return secureboot.ParseDatabase(b)
+	}
+	var all secureboot.Database
+	for _, name := range names {
+		d, err := secureboot.ReadDatabase(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, d...)
+	}
+	return all, nil
+}
+
+func (c *cmd) verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	dbFile := fs.String("db", "", "EFI signature list of allowed certificates and digests")
+	dbxFile := fs.String("dbx", "", "EFI signature list of forbidden certificates and digests")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+	db, err := readDatabases(*dbFile, "db", "MokListRT")
+	if err != nil {
+		return err
+	}
+	dbx, err := readDatabases(*dbxFile, "dbx", "MokListXRT")
+	if err != nil {
+		return err
+	}
+
+	var failed bool
+	for _, name := range fs.Args() {
+		img, err := os.ReadFile(name)
+		if err == nil {
+			err = secureboot.Verify(img, db, dbx)
+		}
+		if err != nil {
+			fmt.Fprintf(c.stdout, "%s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(c.stdout, "%s: ok\n", name)
+	}
+	if failed {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+func (c *cmd) run(args []string) error {
+	fs := flag.NewFlagSet("secureboot", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	dir := fs.String("d", uefivars.EfiVarDir, "the sysfs efivars directory")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	uefivars.EfiVarDir = *dir
+
+	args = fs.Args()
+	if len(args) == 0 {
+		return c.status()
+	}
+	switch op, args := args[0], args[1:]; op {
+	case "status":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return c.status()
+	case "list":
+		return c.list(args)
+	case "verify":
+		return c.verify(args)
+	default:
+		return errUsage
+	}
+}
+
+func main() {
+	c := &cmd{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
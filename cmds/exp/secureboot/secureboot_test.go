@@ -0,0 +1,204 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/internal/authenticodetest"
+	"github.com/u-root/u-root/pkg/uefivars"
+	"github.com/u-root/u-root/pkg/uefivars/secureboot"
+)
+
+const owner = "77fa9abd-0359-4d32-bd60-28f4e78f784b"
+
+func testCert(t *testing.T, name string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, key
+}
+
+func database(t *testing.T, certs []*x509.Certificate, digests ...[]byte) []byte {
+	t.Helper()
+	o, err := uefivars.ParseGUID(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d secureboot.Database
+	for _, c := range certs {
+		d = append(d, secureboot.Signature{Type: secureboot.CertX509, Owner: o, Data: c.Raw})
+	}
+	for _, h := range digests {
+		d = append(d, secureboot.Signature{Type: secureboot.CertSHA256, Owner: o, Data: h})
+	}
+	return d.Marshal()
+}
+
+func writeVar(t *testing.T, dir, guid, name string, data []byte) {
+	t.Helper()
+	d := filepath.Join(dir, name+"-"+guid)
+	if err := os.MkdirAll(d, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(d, "data"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	old := uefivars.EfiVarDir
+	defer func() { uefivars.EfiVarDir = old }()
+
+	pk, _ := testCert(t, "Platform Key")
+	kek, _ := testCert(t, "KEK")
+	dbCert, key := testCert(t, "db")
+	other, otherKey := testCert(t, "Other")
+	digest := bytes.Repeat([]byte{0xab}, 32)
+
+	dir := t.TempDir()
+	writeVar(t, dir, secureboot.GlobalVariable, "SecureBoot", []byte{1})
+	writeVar(t, dir, secureboot.GlobalVariable, "SetupMode", []byte{0})
+	writeVar(t, dir, secureboot.GlobalVariable, "PK", database(t, []*x509.Certificate{pk}))
+	writeVar(t, dir, secureboot.GlobalVariable, "KEK", database(t, []*x509.Certificate{kek}))
+	writeVar(t, dir, secureboot.ImageSecurityDatabase, "db", database(t, []*x509.Certificate{dbCert}))
+	writeVar(t, dir, secureboot.ImageSecurityDatabase, "dbx", database(t, nil, digest))
+
+	img, err := os.ReadFile("../../../pkg/boot/bls/testdata/uki/EFI/Linux/fedora-5.17.5.efi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := authenticodetest.Sign(img, key, []*x509.Certificate{dbCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigned, err := authenticodetest.Sign(img, otherKey, []*x509.Certificate{other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := t.TempDir()
+	for name, b := range map[string][]byte{
+		"signed.efi":       signed,
+		"unsigned.efi":     img,
+		"other-signed.efi": otherSigned,
+		"other.esl":        database(t, []*x509.Certificate{other}),
+	} {
+		if err := os.WriteFile(filepath.Join(files, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		want string
+		err  string
+	}{
+		{
+			name: "status",
+			args: []string{"-d", dir},
+			want: `SecureBoot: enabled
+SetupMode: disabled
+PK: 1 certificates, 0 SHA-256 digests, 0 other signatures
+	CN=Platform Key
+KEK: 1 certificates, 0 SHA-256 digests, 0 other signatures
+	CN=KEK
+db: 1 certificates, 0 SHA-256 digests, 0 other signatures
+	CN=db
+dbx: 0 certificates, 1 SHA-256 digests, 0 other signatures
+MokListRT: not present
+MokListXRT: not present
+`,
+		},
+		{
+			name: "list",
+			args: []string{"-d", dir, "list", "db", "dbx"},
+			want: `db:
+	x509 owner=` + owner + ` subject="CN=db" issuer="CN=db"
+dbx:
+	sha256 owner=` + owner + ` abababababababababababababababababababababababababababababababab
+`,
+		},
+		{
+			name: "list missing",
+			args: []string{"-d", dir, "list", "MokListRT"},
+			err:  "no such file or directory",
+		},
+		{
+			name: "verify",
+			args: []string{"-d", dir, "verify", filepath.Join(files, "signed.efi")},
+			want: filepath.Join(files, "signed.efi") + ": ok\n",
+		},
+		{
+			name: "verify fails",
+			args: []string{"-d", dir, "verify", filepath.Join(files, "unsigned.efi"), filepath.Join(files, "other-signed.efi")},
+			want: filepath.Join(files, "unsigned.efi") + ": image is not signed\n" +
+				filepath.Join(files, "other-signed.efi") + ": no signature is trusted by the db\n",
+			err: "verification failed",
+		},
+		{
+			name: "verify db file",
+			args: []string{"-d", dir, "verify", "-db", filepath.Join(files, "other.esl"), filepath.Join(files, "other-signed.efi")},
+			want: filepath.Join(files, "other-signed.efi") + ": ok\n",
+		},
+		{
+			name: "no state",
+			args: []string{"-d", files},
+			err:  "is this a UEFI system?",
+		},
+		{
+			name: "usage",
+			args: []string{"-d", dir, "frob"},
+			err:  "usage:",
+		},
+		{
+			name: "verify usage",
+			args: []string{"-d", dir, "verify"},
+			err:  "usage:",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			c := &cmd{stdout: &stdout, stderr: &stderr}
+			err := c.run(tt.args)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("run(%q) = %v, want error %q", tt.args, err, tt.err)
+			}
+			if stdout.String() != tt.want {
+				t.Errorf("run(%q) printed\n%s\nwant\n%s", tt.args, stdout.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// veritysetup creates and checks dm-verity hash trees and maps verified
+// read-only devices.
+//
+// Synopsis:
+//     veritysetup format [OPTIONS] DATA HASH
+//         Write a superblock and the hash tree of DATA to HASH and print
+//         the root hash.
+//     veritysetup verify [-hash-offset N] DATA HASH ROOT
+//         Check DATA against the hash tree.
+//     veritysetup open [-hash-offset N] DATA NAME HASH ROOT
+//         Map DATA as /dev/mapper/NAME, verifying every read.
+//     veritysetup close NAME
+//         Remove the mapping NAME.
+//     veritysetup dump [-hash-offset N] HASH
+//         Show the superblock of HASH.
+//
+// Description:
+//     DATA and HASH may be the same device if HASH starts past the data.
+//
+// Options:
+//     -hash: hash algorithm (default sha256)
+//     -data-block-size: data block size (default 4096)
+//     -hash-block-size: hash block size (default 4096)
+//     -data-blocks: number of data blocks (default the whole device)
+//     -salt: salt in hex, - for none (default random)
+//     -hash-offset: offset of the superblock in HASH in bytes
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/mount/dm"
+	"github.com/u-root/u-root/pkg/mount/dm/verity"
+)
+
+const usage = `usage:
+  veritysetup format [OPTIONS] DATA HASH
+  veritysetup verify [-hash-offset N] DATA HASH ROOT
+  veritysetup open [-hash-offset N] DATA NAME HASH ROOT
+  veritysetup close NAME
+  veritysetup dump [-hash-offset N] HASH`
+
+var errUsage = errors.New(usage)
+
+type cmd struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func size(f *os.File) (int64, error) {
+	return f.Seek(0, io.SeekEnd)
+}
+
+func (c *cmd) format(args []string) error {
+	fs := flag.NewFlagSet("veritysetup format", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	var (
+		alg        = fs.String("hash", "sha256", "hash algorithm")
+		dataBlock  = fs.Uint("data-block-size", 4096, "data block size")
+		hashBlock  = fs.Uint("hash-block-size", 4096, "hash block size")
+		dataBlocks = fs.Uint64("data-blocks", 0, "number of data blocks (default the whole device)")
+		salt       = fs.String("salt", "", "salt in hex, - for none (default random)")
+		hashOffset = fs.Int64("hash-offset", 0, "offset of the superblock in HASH in bytes")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+
+	data, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	p := &verity.Params{
+		Algorithm:     *alg,
+		DataBlockSize: uint32(*dataBlock),
+		HashBlockSize: uint32(*hashBlock),
+		DataBlocks:    *dataBlocks,
+	}
+	if p.DataBlocks == 0 {
+		n, err := size(data)
+		if err != nil {
+			return err
+		}
+		p.DataBlocks = uint64(n) / uint64(p.DataBlockSize)
+	}
+	switch *salt {
+	case "":
+		p.Salt = make([]byte, 32)
+		if _, err := rand.Read(p.Salt); err != nil {
+			return err
+		}
+	case "-":
+	default:
+		if p.Salt, err = hex.DecodeString(*salt); err != nil {
+			return fmt.Errorf("invalid salt: %v", err)
+		}
+	}
+	if _, err := rand.Read(p.UUID[:]); err != nil {
+		return err
+	}
+	p.UUID[6] = p.UUID[6]&0x0f | 0x40
+	p.UUID[8] = p.UUID[8]&0x3f | 0x80
+
+	hash, err := os.OpenFile(fs.Arg(1), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	start, root, err := p.Format(data, hash, *hashOffset)
+	if err != nil {
+		hash.Close()
+		return err
+	}
+	if err := hash.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Data blocks:     %d\n", p.DataBlocks)
+	fmt.Fprintf(c.stdout, "Hash start:      %d\n", start)
+	fmt.Fprintf(c.stdout, "Salt:            %s\n", saltString(p.Salt))
+	fmt.Fprintf(c.stdout, "Root hash:       %x\n", root)
+	return nil
+}
+
+func saltString(s []byte) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return hex.EncodeToString(s)
+}
+
+// superblock parses the common arguments of verify, open and dump: the
+// -hash-offset flag, and n arguments of which the hash device is at
+// hashArg. It returns the arguments, the parameters and the first hash
+// block of the tree.
+func (c *cmd) superblock(op string, args []string, n, hashArg int) ([]string, *verity.Params, uint64, error) {
+	fs := flag.NewFlagSet("veritysetup "+op, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	hashOffset := fs.Int64("hash-offset", 0, "offset of the superblock in HASH in bytes")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, 0, err
+	}
+	if fs.NArg() != n {
+		return nil, nil, 0, errUsage
+	}
+	f, err := os.Open(fs.Arg(hashArg))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer f.Close()
+	p, err := verity.ReadSuperblock(f, *hashOffset)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	hb := int64(p.HashBlockSize)
+	return fs.Args(), p, uint64((*hashOffset + verity.SuperblockSize + hb - 1) / hb), nil
+}
+
+func (c *cmd) verify(args []string) error {
+	args, p, start, err := c.superblock("verify", args, 3, 1)
+	if err != nil {
+		return err
+	}
+	root, err := hex.DecodeString(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid root hash: %v", err)
+	}
+	data, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	hash, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer hash.Close()
+	return p.Verify(data, hash, start, root)
+}
+
+func (c *cmd) open(args []string) error {
+	args, p, start, err := c.superblock("open", args, 4, 2)
+	if err != nil {
+		return err
+	}
+	root, err := hex.DecodeString(args[3])
+	if err != nil {
+		return fmt.Errorf("invalid root hash: %v", err)
+	}
+	t := p.Target(args[0], args[2], start, root)
+	uuid := fmt.Sprintf("CRYPT-VERITY-%x-%s", p.UUID, args[1])
+	path, err := dm.CreateDevice(args[1], uuid, []dm.Target{t}, true)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, path)
+	return nil
+}
+
+func (c *cmd) dump(args []string) error {
+	_, p, start, err := c.superblock("dump", args, 1, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "UUID:            %x\n", p.UUID)
+	fmt.Fprintf(c.stdout, "Hash type:       1\n")
+	fmt.Fprintf(c.stdout, "Data blocks:     %d\n", p.DataBlocks)
+	fmt.Fprintf(c.stdout, "Data block size: %d\n", p.DataBlockSize)
+	fmt.Fprintf(c.stdout, "Hash block size: %d\n", p.HashBlockSize)
+	fmt.Fprintf(c.stdout, "Hash algorithm:  %s\n", p.Algorithm)
+	fmt.Fprintf(c.stdout, "Salt:            %s\n", saltString(p.Salt))
+	fmt.Fprintf(c.stdout, "Hash start:      %d\n", start)
+	return nil
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch op, args := args[0], args[1:]; op {
+	case "format":
+		return c.format(args)
+	case "verify":
+		return c.verify(args)
+	case "open", "create":
+		return c.open(args)
+	case "close", "remove":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return dm.RemoveDevice(args[0])
+	case "dump":
+		return c.dump(args)
+	default:
+		return errUsage
+	}
+}
+
+func main() {
+	c := &cmd{stdout: os.Stdout, stderr: os.Stderr}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/mount/dm/verity"
+)
+
+func TestFormatVerify(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	hash := filepath.Join(dir, "hash")
+	b := bytes.Repeat([]byte("verity"), 100000)
+	if err := os.WriteFile(data, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	c := &cmd{stdout: &out, stderr: &out}
+	if err := c.run([]string{"format", "-salt", "0102", data, hash}); err != nil {
+		t.Fatalf("format: %v\n%s", err, out.String())
+	}
+	m := regexp.MustCompile(`Root hash: +([0-9a-f]+)`).FindStringSubmatch(out.String())
+	if m == nil {
+		t.Fatalf("no root hash in %q", out.String())
+	}
+	root := m[1]
+	if !strings.Contains(out.String(), "Data blocks:     146\n") {
+		t.Errorf("format output = %q, want 146 data blocks", out.String())
+	}
+
+	out.Reset()
+	if err := c.run([]string{"dump", hash}); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"Salt:            0102\n", "Hash start:      1\n", "Hash algorithm:  sha256\n"} {
+		if !strings.Contains(out.String(), w) {
+			t.Errorf("dump output does not contain %q:\n%s", w, out.String())
+		}
+	}
+
+	if err := c.run([]string{"verify", data, hash, root}); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	b[4096] ^= 1
+	if err := os.WriteFile(data, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.run([]string{"verify", data, hash, root}); !errors.Is(err, verity.ErrCorrupt) {
+		t.Errorf("verify(corrupt) = %v, want %v", err, verity.ErrCorrupt)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"frobnicate"},
+		{"format", "data"},
+		{"close"},
+	} {
+		c := &cmd{stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+		if err := c.run(args); err != errUsage {
+			t.Errorf("run(%q) = %v, want usage", args, err)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/mount/nvme"
+	"github.com/u-root/u-root/pkg/mount/scuzz"
+	"golang.org/x/sys/unix"
+)
+
+// poll calls status every pollInterval until it reports completion or
+// timeout passes.
+func poll(status func() (float64, bool, error), timeout time.Duration, progress func(float64)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pct, done, err := status()
+		if err != nil {
+			return err
+		}
+		progress(pct)
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not done after %v", timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// nvmeDevice is the part of *nvme.Device used here.
+type nvmeDevice interface {
+	Close() error
+	NamespaceID() (uint32, error)
+	IdentifyController() (*nvme.Controller, error)
+	IdentifyNamespace(nsid uint32) (*nvme.Namespace, error)
+	SanitizeStatus() (*nvme.SanitizeStatus, error)
+	Format(nsid uint32, o nvme.FormatOptions) error
+	Sanitize(o nvme.SanitizeOptions) error
+}
+
+type nvmeDrive struct {
+	d    nvmeDevice
+	nsid uint32
+	ctrl *nvme.Controller
+	ns   *nvme.Namespace
+
+	// log holds the sanitize estimates, if the controller has them.
+	log *nvme.SanitizeStatus
+}
+
+func newNVMeDrive(d nvmeDevice) (*nvmeDrive, error) {
+	nsid, err := d.NamespaceID()
+	if err != nil {
+		return nil, err
+	}
+	ctrl, err := d.IdentifyController()
+	if err != nil {
+		return nil, err
+	}
+	ns, err := d.IdentifyNamespace(nsid)
+	if err != nil {
+		return nil, err
+	}
+	n := &nvmeDrive{d: d, nsid: nsid, ctrl: ctrl, ns: ns}
+	if ctrl.SANICAP != 0 {
+		n.log, _ = d.SanitizeStatus()
+	}
+	return n, nil
+}
+
+func (n *nvmeDrive) Close() error {
+	return n.d.Close()
+}
+
+func (n *nvmeDrive) identify(c *certificate) {
+	c.Transport = "nvme"
+	c.Model, c.Serial, c.Firmware = n.ctrl.Model, n.ctrl.Serial, n.ctrl.FirmwareRevision
+}
+
+// estimate converts a sanitize estimate in seconds.
+func (n *nvmeDrive) estimate(field func(*nvme.SanitizeStatus) uint32) time.Duration {
+	if n.log == nil {
+		return 0
+	}
+	s := field(n.log)
+	if s == 0xffffffff {
+		return 0
+	}
+	return time.Duration(s) * time.Second
+}
+
+func (n *nvmeDrive) methods() []method {
+	var m []method
+	if n.ctrl.SupportsSanitize(nvme.SanitizeCryptoErase) {
+		m = append(m, method{name: "sanitize-crypto-erase", estimate: n.estimate(func(s *nvme.SanitizeStatus) uint32 { return s.CryptoEraseTime })})
+	}
+	if n.ctrl.SupportsSanitize(nvme.SanitizeBlockErase) {
+		m = append(m, method{name: "sanitize-block-erase", estimate: n.estimate(func(s *nvme.SanitizeStatus) uint32 { return s.BlockEraseTime })})
+	}
+	if n.ctrl.SupportsSanitize(nvme.SanitizeOverwrite) {
+		m = append(m, method{name: "sanitize-overwrite", zeros: true, estimate: n.estimate(func(s *nvme.SanitizeStatus) uint32 { return s.OverwriteTime })})
+	}
+	if n.ctrl.SupportsCryptoErase() {
+		m = append(m, method{name: "format-crypto-erase"})
+	}
+	if n.ctrl.SupportsFormat() {
+		m = append(m, method{name: "format-user-data-erase"})
+	}
+	return m
+}
+
+func (n *nvmeDrive) sanitize(o nvme.SanitizeOptions, timeout time.Duration, progress func(float64)) error {
+	if err := n.d.Sanitize(o); err != nil {
+		return err
+	}
+	return poll(func() (float64, bool, error) {
+		s, err := n.d.SanitizeStatus()
+		if err != nil {
+			return 0, false, err
+		}
+		switch s.State {
+		case nvme.SanitizeInProgress:
+			return s.Percent(), false, nil
+		case nvme.SanitizeSucceeded, nvme.SanitizeSucceededNoDealloc:
+			return 100, true, nil
+		}
+		return 0, false, fmt.Errorf("sanitize %v", s.State)
+	}, timeout, progress)
+}
+
+func (n *nvmeDrive) format(ses nvme.SecureErase, timeout time.Duration, progress func(float64)) error {
+	progress(0)
+	if err := n.d.Format(n.nsid, nvme.FormatOptions{
+		LBAFormat:   uint8(n.ns.FormattedLBA),
+		SecureErase: ses,
+		Timeout:     timeout,
+	}); err != nil {
+		return err
+	}
+	progress(100)
+	return nil
+}
+
+func (n *nvmeDrive) erase(m method, timeout time.Duration, progress func(float64)) error {
+	switch m.name {
+	case "sanitize-crypto-erase":
+		return n.sanitize(nvme.SanitizeOptions{Action: nvme.SanitizeCryptoErase}, timeout, progress)
+	case "sanitize-block-erase":
+		return n.sanitize(nvme.SanitizeOptions{Action: nvme.SanitizeBlockErase}, timeout, progress)
+	case "sanitize-overwrite":
+		// Deallocated blocks need not read as the pattern.
+		return n.sanitize(nvme.SanitizeOptions{Action: nvme.SanitizeOverwrite, OverwritePasses: 1, NoDeallocate: true}, timeout, progress)
+	case "format-crypto-erase":
+		return n.format(nvme.CryptoErase, timeout, progress)
+	case "format-user-data-erase":
+		return n.format(nvme.UserDataErase, timeout, progress)
+	}
+	return fmt.Errorf("unknown method %q", m.name)
+}
+
+// ataDisk is the part of *scuzz.SGDisk used for ATA drives.
+type ataDisk interface {
+	Close() error
+	SetPassword(password string, admin bool) error
+	SecurityErase(password string, admin, enhanced bool, timeout time.Duration) error
+	Sanitize(o scuzz.SanitizeOptions) error
+	SanitizeStatus() (*scuzz.SanitizeStatus, error)
+}
+
+type ataDrive struct {
+	d        ataDisk
+	info     *scuzz.Info
+	password string
+
+	// sanitizeFrozen is true after SANITIZE FREEZE LOCK.
+	sanitizeFrozen bool
+}
+
+func newATADrive(d ataDisk, info *scuzz.Info, password string) *ataDrive {
+	a := &ataDrive{d: d, info: info, password: password}
+	if info.SanitizeFeatures.Supported() {
+		if s, err := d.SanitizeStatus(); err == nil {
+			a.sanitizeFrozen = s.Frozen
+		}
+	}
+	return a
+}
+
+func (a *ataDrive) Close() error {
+	return a.d.Close()
+}
+
+func (a *ataDrive) identify(c *certificate) {
+	c.Transport = "ata"
+	c.Model, c.Serial, c.Firmware = a.info.Model, a.info.Serial, a.info.FirmwareRevision
+}
+
+func (a *ataDrive) methods() []method {
+	var m []method
+	if f := a.info.SanitizeFeatures; !a.sanitizeFrozen {
+		if f.Supports(scuzz.SanitizeCryptoScramble) {
+			m = append(m, method{name: "sanitize-crypto-scramble"})
+		}
+		if f.Supports(scuzz.SanitizeBlockErase) {
+			m = append(m, method{name: "sanitize-block-erase"})
+		}
+		if f.Supports(scuzz.SanitizeOverwrite) {
+			m = append(m, method{name: "sanitize-overwrite", zeros: true})
+		}
+	}
+	// Security erase needs a password we know.
+	if s := a.info.SecurityStatus; s.SecuritySupported() && !s.SecurityEnabled() && !s.SecurityFrozen() {
+		if s.EnhancedEraseSupported() {
+			m = append(m, method{name: "security-erase-enhanced", estimate: a.info.EnhancedEraseTime})
+		}
+		// A normal erase writes zeros.
+		m = append(m, method{name: "security-erase", zeros: true, estimate: a.info.NormalEraseTime})
+	}
+	return m
+}
+
+func (a *ataDrive) sanitize(o scuzz.SanitizeOptions, timeout time.Duration, progress func(float64)) error {
+	if err := a.d.Sanitize(o); err != nil {
+		return err
+	}
+	return poll(func() (float64, bool, error) {
+		s, err := a.d.SanitizeStatus()
+		if err != nil {
+			return 0, false, err
+		}
+		if s.InProgress {
+			return s.Percent(), false, nil
+		}
+		if !s.Succeeded {
+			return 0, false, fmt.Errorf("sanitize did not succeed")
+		}
+		return 100, true, nil
+	}, timeout, progress)
+}
+
+func (a *ataDrive) securityErase(enhanced bool, timeout time.Duration, progress func(float64)) error {
+	if err := a.d.SetPassword(a.password, false); err != nil {
+		return fmt.Errorf("setting password: %w", err)
+	}
+	progress(0)
+	if err := a.d.SecurityErase(a.password, false, enhanced, timeout); err != nil {
+		return err
+	}
+	progress(100)
+	return nil
+}
+
+func (a *ataDrive) erase(m method, timeout time.Duration, progress func(float64)) error {
+	switch m.name {
+	case "sanitize-crypto-scramble":
+		return a.sanitize(scuzz.SanitizeOptions{Action: scuzz.SanitizeCryptoScramble}, timeout, progress)
+	case "sanitize-block-erase":
+		return a.sanitize(scuzz.SanitizeOptions{Action: scuzz.SanitizeBlockErase}, timeout, progress)
+	case "sanitize-overwrite":
+		return a.sanitize(scuzz.SanitizeOptions{Action: scuzz.SanitizeOverwrite, OverwritePasses: 1}, timeout, progress)
+	case "security-erase-enhanced":
+		return a.securityErase(true, timeout, progress)
+	case "security-erase":
+		return a.securityErase(false, timeout, progress)
+	}
+	return fmt.Errorf("unknown method %q", m.name)
+}
+
+// scsiDisk is the part of *scuzz.SGDisk used for SCSI drives.
+type scsiDisk interface {
+	Close() error
+	ReadCapacity() (*scuzz.Capacity, error)
+	MaxUnmap() (uint32, error)
+	Unmap(lba uint64, count uint32) error
+	FormatUnit(timeout time.Duration) error
+}
+
+// defaultUnmapBlocks is the UNMAP size for drives not reporting a
+// maximum.
+const defaultUnmapBlocks = 1 << 16
+
+type scsiDrive struct {
+	d        scsiDisk
+	capacity *scuzz.Capacity
+
+	// model, serial and firmware are read from sysfs.
+	model    string
+	serial   string
+	firmware string
+}
+
+func newSCSIDrive(d scsiDisk) (*scsiDrive, error) {
+	c, err := d.ReadCapacity()
+	if err != nil {
+		return nil, err
+	}
+	return &scsiDrive{d: d, capacity: c}, nil
+}
+
+func (s *scsiDrive) Close() error {
+	return s.d.Close()
+}
+
+func (s *scsiDrive) identify(c *certificate) {
+	c.Transport = "scsi"
+	c.Model, c.Serial, c.Firmware = s.model, s.serial, s.firmware
+}
+
+func (s *scsiDrive) methods() []method {
+	m := []method{{name: "format-unit"}}
+	if s.capacity.Provisioned {
+		m = append(m, method{name: "unmap", zeros: s.capacity.ReadsZeros})
+	}
+	return m
+}
+
+func (s *scsiDrive) unmap(progress func(float64)) error {
+	max, err := s.d.MaxUnmap()
+	if err != nil {
+		return err
+	}
+	if max == 0 {
+		max = defaultUnmapBlocks
+	}
+	blocks := s.capacity.Blocks
+	for lba := uint64(0); lba < blocks; lba += uint64(max) {
+		progress(float64(lba) * 100 / float64(blocks))
+		n := max
+		if left := blocks - lba; left < uint64(n) {
+			n = uint32(left)
+		}
+		if err := s.d.Unmap(lba, n); err != nil {
+			return fmt.Errorf("unmapping %d blocks at %d: %w", n, lba, err)
+		}
+	}
+	progress(100)
+	return nil
+}
+
+func (s *scsiDrive) erase(m method, timeout time.Duration, progress func(float64)) error {
+	switch m.name {
+	case "format-unit":
+		progress(0)
+		if err := s.d.FormatUnit(timeout); err != nil {
+			return err
+		}
+		progress(100)
+		return nil
+	case "unmap":
+		return s.unmap(progress)
+	}
+	return fmt.Errorf("unknown method %q", m.name)
+}
+
+// sysfsAttr reads a device attribute of the block device at path.
+func sysfsAttr(path, attr string) string {
+	b, err := os.ReadFile(filepath.Join("/sys/class/block", filepath.Base(path), "device", attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// openDrive opens the drive at path with the transport it supports.
+func openDrive(path, password string) (drive, error) {
+	if strings.HasPrefix(filepath.Base(path), "nvme") {
+		d, err := nvme.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		n, err := newNVMeDrive(d)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		return n, nil
+	}
+
+	// Only ATA drives, including those behind SATL, answer IDENTIFY.
+	if d, err := scuzz.NewSGDisk(path); err == nil {
+		info, err := d.Identify()
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		return newATADrive(d, info, password), nil
+	}
+
+	d, err := scuzz.NewSCSIDisk(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newSCSIDrive(d)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	s.model = strings.TrimSpace(sysfsAttr(path, "vendor") + " " + sysfsAttr(path, "model"))
+	// The Unit Serial Number VPD page has a 4 byte header.
+	if vpd := sysfsAttr(path, "vpd_pg80"); len(vpd) > 4 {
+		s.serial = strings.TrimSpace(vpd[4:])
+	}
+	s.firmware = sysfsAttr(path, "rev")
+	return s, nil
+}
+
+type blockFile struct {
+	*os.File
+	size int64
+}
+
+func (b *blockFile) Size() int64 {
+	return b.size
+}
+
+// openBlock opens the block device at path, dropping its cached pages so
+// reads see the media after an erase.
+func openBlock(path string) (blockDevice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.IoctlSetInt(int(f.Fd()), unix.BLKFLSBUF, 0); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "ioctl BLKFLSBUF", Path: path, Err: err}
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &blockFile{File: f, size: size}, nil
+}
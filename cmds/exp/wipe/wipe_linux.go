@@ -0,0 +1,380 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// wipe securely erases drives and certifies the result.
+//
+// Synopsis:
+//     wipe [-n] [-force] [-method NAME] [-samples N] [-timeout D] [-password PW] [-cert DIR] DEVICE...
+//
+// Description:
+//     wipe detects whether each DEVICE is an NVMe namespace, an ATA drive
+//     or another SCSI drive, and runs the strongest erase the drive
+//     supports:
+//
+//     NVMe: sanitize-crypto-erase, sanitize-block-erase, sanitize-overwrite,
+//           format-crypto-erase, format-user-data-erase
+//     ATA:  sanitize-crypto-scramble, sanitize-block-erase, sanitize-overwrite,
+//           security-erase-enhanced, security-erase
+//     SCSI: format-unit, unmap
+//
+//     Sectors sampled across the drive before and after the erase are
+//     compared to verify it. A sample fails if it still holds its old
+//     data, or if the method leaves zeros and it does not read as zeros.
+//
+//     A JSON certificate describing the drive, method, timing and
+//     verification is written for each DEVICE, to stdout or to
+//     DIR/NAME.json.
+//
+//     ATA security erase sets the user password to PW first, which the
+//     erase then clears. It is unavailable on frozen drives and drives
+//     that already have a password.
+//
+// Options:
+//     -n: only show the supported methods and the one that would be used
+//     -force: really erase the drives
+//     -method: use method NAME rather than the strongest
+//     -samples: number of sectors to verify
+//     -timeout: erase timeout, by default twice the drive's estimate or 24h
+//     -password: temporary ATA security password
+//     -cert: directory for the certificates
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const usage = "usage: wipe [-n] [-force] [-method NAME] [-samples N] [-timeout D] [-password PW] [-cert DIR] DEVICE..."
+
+var (
+	errUsage = errors.New(usage)
+	errForce = errors.New("this destroys all data; use -force to proceed")
+)
+
+const (
+	// sampleSize is the size of a verification sample.
+	sampleSize = 4096
+
+	// defaultTimeout is used when the drive has no estimate.
+	defaultTimeout = 24 * time.Hour
+)
+
+// pollInterval is the interval between progress queries.
+var pollInterval = 5 * time.Second
+
+// method is an erase method.
+type method struct {
+	name string
+
+	// zeros is true if erased sectors read as zeros.
+	zeros bool
+
+	// estimate is the drive's estimate of the duration, or 0.
+	estimate time.Duration
+}
+
+// drive is a drive that can be erased.
+type drive interface {
+	Close() error
+
+	// identify fills in the transport and identity of the drive.
+	identify(c *certificate)
+
+	// methods returns the supported erase methods, strongest first.
+	methods() []method
+
+	// erase runs m, reporting progress in percent.
+	erase(m method, timeout time.Duration, progress func(float64)) error
+}
+
+// blockDevice is the block device of a drive, used for verification.
+type blockDevice interface {
+	io.ReaderAt
+	Close() error
+	Size() int64
+}
+
+// verification is the result of comparing samples before and after the
+// erase.
+type verification struct {
+	Samples int `json:"samples"`
+
+	// Unchanged and Mismatched are the byte offsets of failed samples.
+	Unchanged  []int64 `json:"unchanged,omitempty"`
+	Mismatched []int64 `json:"mismatched,omitempty"`
+
+	Passed bool `json:"passed"`
+}
+
+// certificate describes the erase of one drive.
+type certificate struct {
+	Device    string `json:"device"`
+	Transport string `json:"transport"`
+	Model     string `json:"model"`
+	Serial    string `json:"serial"`
+	Firmware  string `json:"firmware"`
+	Size      int64  `json:"size"`
+
+	Method       string        `json:"method"`
+	Start        time.Time     `json:"start"`
+	End          time.Time     `json:"end"`
+	Verification *verification `json:"verification,omitempty"`
+
+	// Result is "passed" or "failed".
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+type cmd struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	open      func(path, password string) (drive, error)
+	openBlock func(path string) (blockDevice, error)
+	now       func() time.Time
+	rand      *rand.Rand
+
+	dryRun   bool
+	force    bool
+	method   string
+	samples  int
+	timeout  time.Duration
+	password string
+	certDir  string
+}
+
+// choose returns the named method, or the strongest method.
+func (c *cmd) choose(methods []method) (method, error) {
+	if len(methods) == 0 {
+		return method{}, errors.New("no supported erase method")
+	}
+	if c.method == "" {
+		return methods[0], nil
+	}
+	for _, m := range methods {
+		if m.name == c.method {
+			return m, nil
+		}
+	}
+	return method{}, fmt.Errorf("erase method %q is not supported", c.method)
+}
+
+// offsets returns n sample offsets spread across a device of size bytes,
+// including the first and last sample.
+func (c *cmd) offsets(size int64, n int) []int64 {
+	last := size/sampleSize - 1
+	if int64(n) > last+1 {
+		n = int(last + 1)
+	}
+	if n < 2 {
+		return []int64{0}
+	}
+	// The middle samples are randomly placed in equal strides.
+	offs := []int64{0}
+	if mid := int64(n - 2); mid > 0 {
+		stride := (last - 1) / mid
+		for i := int64(0); i < mid; i++ {
+			offs = append(offs, (1+i*stride+c.rand.Int63n(stride))*sampleSize)
+		}
+	}
+	return append(offs, last*sampleSize)
+}
+
+// sample reads the samples at offs.
+func (c *cmd) sample(path string, offs []int64) ([][]byte, error) {
+	b, err := c.openBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	var s [][]byte
+	for _, o := range offs {
+		buf := make([]byte, sampleSize)
+		if _, err := b.ReadAt(buf, o); err != nil {
+			return nil, fmt.Errorf("reading sample at %d: %w", o, err)
+		}
+		s = append(s, buf)
+	}
+	return s, nil
+}
+
+// uniform returns true if all bytes of b are the same, e.g. a never
+// written sector, which an erase may leave unchanged.
+func uniform(b []byte) bool {
+	for _, c := range b {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func verify(m method, offs []int64, before, after [][]byte) *verification {
+	v := &verification{Samples: len(offs)}
+	zeros := make([]byte, sampleSize)
+	for i, o := range offs {
+		if bytes.Equal(before[i], after[i]) && !uniform(before[i]) {
+			v.Unchanged = append(v.Unchanged, o)
+		}
+		if m.zeros && !bytes.Equal(after[i], zeros) {
+			v.Mismatched = append(v.Mismatched, o)
+		}
+	}
+	v.Passed = len(v.Unchanged) == 0 && len(v.Mismatched) == 0
+	return v
+}
+
+// wipe erases the drive at path and fills in cert.
+func (c *cmd) wipe(path string, cert *certificate) error {
+	d, err := c.open(path, c.password)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	d.identify(cert)
+
+	methods := d.methods()
+	m, err := c.choose(methods)
+	if c.dryRun {
+		var names []string
+		for _, m := range methods {
+			names = append(names, m.name)
+		}
+		fmt.Fprintf(c.stdout, "%s: %s %s, serial %s\n", path, cert.Transport, cert.Model, cert.Serial)
+		fmt.Fprintf(c.stdout, "  methods: %s\n", strings.Join(names, ", "))
+		if err == nil {
+			fmt.Fprintf(c.stdout, "  selected: %s\n", m.name)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cert.Method = m.name
+
+	b, err := c.openBlock(path)
+	if err != nil {
+		return err
+	}
+	cert.Size = b.Size()
+	b.Close()
+	if cert.Size < sampleSize {
+		return fmt.Errorf("device size %d is less than a sample", cert.Size)
+	}
+	offs := c.offsets(cert.Size, c.samples)
+	before, err := c.sample(path, offs)
+	if err != nil {
+		return err
+	}
+
+	timeout := c.timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+		if m.estimate != 0 {
+			timeout = 2 * m.estimate
+		}
+	}
+	cert.Start = c.now()
+	err = d.erase(m, timeout, func(pct float64) {
+		fmt.Fprintf(c.stderr, "\r%s: %s %5.1f%%", path, m.name, pct)
+	})
+	cert.End = c.now()
+	fmt.Fprintln(c.stderr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.name, err)
+	}
+
+	after, err := c.sample(path, offs)
+	if err != nil {
+		return err
+	}
+	cert.Verification = verify(m, offs, before, after)
+	if !cert.Verification.Passed {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+// certify writes cert to stdout or the certificate directory.
+func (c *cmd) certify(cert *certificate) error {
+	b, err := json.MarshalIndent(cert, "", "\t")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if c.certDir == "" {
+		_, err := c.stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.certDir, filepath.Base(cert.Device)+".json"), b, 0o644)
+}
+
+func (c *cmd) run(args []string) error {
+	fs := flag.NewFlagSet("wipe", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.BoolVar(&c.dryRun, "n", false, "only show the supported methods and the one that would be used")
+	fs.BoolVar(&c.force, "force", false, "really erase the drives")
+	fs.StringVar(&c.method, "method", "", "use method `NAME` rather than the strongest")
+	fs.IntVar(&c.samples, "samples", 64, "number of sectors to verify")
+	fs.DurationVar(&c.timeout, "timeout", 0, "erase timeout, by default twice the drive's estimate or 24h")
+	fs.StringVar(&c.password, "password", "wipe", "temporary ATA security password")
+	fs.StringVar(&c.certDir, "cert", "", "`DIR`ectory for the certificates")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || c.samples < 1 {
+		return errUsage
+	}
+	if !c.force && !c.dryRun {
+		return errForce
+	}
+
+	var failed []string
+	for _, path := range fs.Args() {
+		cert := &certificate{Device: path, Result: "passed"}
+		err := c.wipe(path, cert)
+		if c.dryRun {
+			if err != nil {
+				fmt.Fprintf(c.stderr, "%s: %v\n", path, err)
+				failed = append(failed, path)
+			}
+			continue
+		}
+		if err != nil {
+			cert.Result, cert.Error = "failed", err.Error()
+			failed = append(failed, path)
+		}
+		if err := c.certify(cert); err != nil {
+			return err
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to wipe %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func main() {
+	c := &cmd{
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+		open:      openDrive,
+		openBlock: openBlock,
+		now:       time.Now,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/mount/nvme"
+	"github.com/u-root/u-root/pkg/mount/scuzz"
+)
+
+const imageSize = 1 << 20
+
+func newImage() []byte {
+	img := make([]byte, imageSize)
+	rand.New(rand.NewSource(1)).Read(img)
+	return img
+}
+
+// scramble overwrites img with other random data.
+func scramble(img []byte) {
+	rand.New(rand.NewSource(2)).Read(img)
+}
+
+func zero(img []byte) {
+	for i := range img {
+		img[i] = 0
+	}
+}
+
+type memBlock struct {
+	*bytes.Reader
+}
+
+func (memBlock) Close() error { return nil }
+
+type fakeATA struct {
+	img    []byte
+	effect func([]byte)
+
+	// status is returned by successive SanitizeStatus calls, then
+	// a successful status.
+	status []scuzz.SanitizeStatus
+
+	password string
+	sanitize *scuzz.SanitizeOptions
+	enhanced bool
+}
+
+func (f *fakeATA) Close() error { return nil }
+
+func (f *fakeATA) SetPassword(password string, admin bool) error {
+	f.password = password
+	return nil
+}
+
+func (f *fakeATA) SecurityErase(password string, admin, enhanced bool, timeout time.Duration) error {
+	if f.password == "" || password != f.password {
+		return errors.New("security erase: bad password")
+	}
+	f.enhanced, f.password = enhanced, ""
+	f.effect(f.img)
+	return nil
+}
+
+func (f *fakeATA) Sanitize(o scuzz.SanitizeOptions) error {
+	f.sanitize = &o
+	f.effect(f.img)
+	return nil
+}
+
+func (f *fakeATA) SanitizeStatus() (*scuzz.SanitizeStatus, error) {
+	if len(f.status) == 0 {
+		return &scuzz.SanitizeStatus{Succeeded: true}, nil
+	}
+	s := f.status[0]
+	f.status = f.status[1:]
+	return &s, nil
+}
+
+type fakeNVMe struct {
+	img    []byte
+	effect func([]byte)
+	ctrl   nvme.Controller
+	status []nvme.SanitizeStatus
+
+	format   *nvme.FormatOptions
+	sanitize *nvme.SanitizeOptions
+}
+
+func (f *fakeNVMe) Close() error { return nil }
+
+func (f *fakeNVMe) NamespaceID() (uint32, error) { return 1, nil }
+
+func (f *fakeNVMe) IdentifyController() (*nvme.Controller, error) { return &f.ctrl, nil }
+
+func (f *fakeNVMe) IdentifyNamespace(nsid uint32) (*nvme.Namespace, error) {
+	return &nvme.Namespace{
+		Size:         imageSize / 4096,
+		FormattedLBA: 1,
+		LBAFormats:   []nvme.LBAFormat{{DataSize: 512}, {DataSize: 4096}},
+	}, nil
+}
+
+func (f *fakeNVMe) SanitizeStatus() (*nvme.SanitizeStatus, error) {
+	if len(f.status) == 0 {
+		return &nvme.SanitizeStatus{State: nvme.SanitizeSucceeded, CryptoEraseTime: 30, BlockEraseTime: 0xffffffff}, nil
+	}
+	s := f.status[0]
+	f.status = f.status[1:]
+	return &s, nil
+}
+
+func (f *fakeNVMe) Format(nsid uint32, o nvme.FormatOptions) error {
+	f.format = &o
+	f.effect(f.img)
+	return nil
+}
+
+func (f *fakeNVMe) Sanitize(o nvme.SanitizeOptions) error {
+	f.sanitize = &o
+	f.effect(f.img)
+	return nil
+}
+
+// fakeSCSI zeroes unmapped blocks.
+type fakeSCSI struct {
+	img      []byte
+	capacity scuzz.Capacity
+	maxUnmap uint32
+	unmaps   int
+}
+
+func (f *fakeSCSI) Close() error { return nil }
+
+func (f *fakeSCSI) ReadCapacity() (*scuzz.Capacity, error) { return &f.capacity, nil }
+
+func (f *fakeSCSI) MaxUnmap() (uint32, error) { return f.maxUnmap, nil }
+
+func (f *fakeSCSI) Unmap(lba uint64, count uint32) error {
+	bs := uint64(f.capacity.BlockSize)
+	zero(f.img[lba*bs : (lba+uint64(count))*bs])
+	f.unmaps++
+	return nil
+}
+
+func (f *fakeSCSI) FormatUnit(timeout time.Duration) error {
+	return errors.New("FORMAT UNIT: unsupported")
+}
+
+func TestMethods(t *testing.T) {
+	const (
+		supported = 0x1
+		enabled   = 0x2
+		frozen    = 0x8
+		enhanced  = 0x20
+	)
+	for _, tt := range []struct {
+		name  string
+		drive drive
+		want  []string
+	}{
+		{
+			name:  "ata",
+			drive: newATADrive(&fakeATA{}, &scuzz.Info{SanitizeFeatures: 0xf000, SecurityStatus: supported | enhanced}, "wipe"),
+			want:  []string{"sanitize-crypto-scramble", "sanitize-block-erase", "sanitize-overwrite", "security-erase-enhanced", "security-erase"},
+		},
+		{
+			name:  "ata security frozen",
+			drive: newATADrive(&fakeATA{}, &scuzz.Info{SanitizeFeatures: 0x9000, SecurityStatus: supported | frozen | enhanced}, "wipe"),
+			want:  []string{"sanitize-block-erase"},
+		},
+		{
+			name:  "ata password set",
+			drive: newATADrive(&fakeATA{}, &scuzz.Info{SecurityStatus: supported | enabled}, "wipe"),
+		},
+		{
+			name:  "ata sanitize frozen",
+			drive: newATADrive(&fakeATA{status: []scuzz.SanitizeStatus{{Frozen: true}}}, &scuzz.Info{SanitizeFeatures: 0xf000, SecurityStatus: supported}, "wipe"),
+			want:  []string{"security-erase"},
+		},
+		{
+			name:  "nvme",
+			drive: &nvmeDrive{ctrl: &nvme.Controller{OACS: 0x2, FNA: 0x4, SANICAP: 0x3}},
+			want:  []string{"sanitize-crypto-erase", "sanitize-block-erase", "format-crypto-erase", "format-user-data-erase"},
+		},
+		{
+			name:  "scsi",
+			drive: &scsiDrive{capacity: &scuzz.Capacity{Provisioned: true}},
+			want:  []string{"format-unit", "unmap"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range tt.drive.methods() {
+				got = append(got, m.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("methods = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	c := &cmd{rand: rand.New(rand.NewSource(1))}
+	offs := c.offsets(imageSize, 10)
+	if len(offs) != 10 || offs[0] != 0 || offs[9] != imageSize-sampleSize {
+		t.Fatalf("offsets = %d, want 10 from 0 to %d", offs, imageSize-sampleSize)
+	}
+	for i := 1; i < len(offs); i++ {
+		if offs[i] <= offs[i-1] || offs[i]%sampleSize != 0 {
+			t.Errorf("offsets = %d, want increasing multiples of %d", offs, sampleSize)
+		}
+	}
+	if offs := c.offsets(3*sampleSize, 10); !reflect.DeepEqual(offs, []int64{0, sampleSize, 2 * sampleSize}) {
+		t.Errorf("offsets of a small device = %d, want every sample", offs)
+	}
+}
+
+func TestRun(t *testing.T) {
+	pollInterval = 0
+	ataInfo := &scuzz.Info{
+		Model:            "Samsung SSD 860 EVO 500GB",
+		Serial:           "S3Z1NB0K123456",
+		FirmwareRevision: "RVT02B6Q",
+		SanitizeFeatures: 0xf000,
+		SecurityStatus:   0x21,
+	}
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		drive  func(img []byte) drive
+		want   []string
+		err    error
+		cert   *certificate
+		verify *verification
+	}{
+		{
+			name: "no force",
+			args: []string{"/dev/sda"},
+			err:  errForce,
+		},
+		{
+			name: "no device",
+			args: []string{"-force"},
+			err:  errUsage,
+		},
+		{
+			name: "dry run",
+			args: []string{"-n", "/dev/sda"},
+			drive: func(img []byte) drive {
+				return newATADrive(&fakeATA{img: img}, ataInfo, "wipe")
+			},
+			want: []string{
+				"/dev/sda: ata Samsung SSD 860 EVO 500GB, serial S3Z1NB0K123456\n",
+				"  methods: sanitize-crypto-scramble, sanitize-block-erase, sanitize-overwrite, security-erase-enhanced, security-erase\n",
+				"  selected: sanitize-crypto-scramble\n",
+			},
+		},
+		{
+			name: "ata sanitize",
+			args: []string{"-force", "-samples", "8", "/dev/sda"},
+			drive: func(img []byte) drive {
+				return newATADrive(&fakeATA{
+					img:    img,
+					effect: scramble,
+					status: []scuzz.SanitizeStatus{{}, {InProgress: true, Progress: 0x8000}},
+				}, ataInfo, "wipe")
+			},
+			cert: &certificate{
+				Device:    "/dev/sda",
+				Transport: "ata",
+				Model:     "Samsung SSD 860 EVO 500GB",
+				Serial:    "S3Z1NB0K123456",
+				Firmware:  "RVT02B6Q",
+				Size:      imageSize,
+				Method:    "sanitize-crypto-scramble",
+				Result:    "passed",
+			},
+			verify: &verification{Samples: 8, Passed: true},
+		},
+		{
+			name: "ata security erase",
+			args: []string{"-force", "-method", "security-erase", "/dev/sda"},
+			drive: func(img []byte) drive {
+				return newATADrive(&fakeATA{img: img, effect: zero}, ataInfo, "wipe")
+			},
+			want: []string{`"method": "security-erase"`, `"result": "passed"`},
+		},
+		{
+			name: "ata unsupported method",
+			args: []string{"-force", "-method", "format-unit", "/dev/sda"},
+			drive: func(img []byte) drive {
+				return newATADrive(&fakeATA{img: img}, ataInfo, "wipe")
+			},
+			want: []string{`"error": "erase method \"format-unit\" is not supported"`},
+			err:  errors.New("failed to wipe /dev/sda"),
+		},
+		{
+			name: "ata sanitize failure",
+			args: []string{"-force", "/dev/sda"},
+			drive: func(img []byte) drive {
+				return newATADrive(&fakeATA{img: img, effect: scramble, status: []scuzz.SanitizeStatus{{}, {}}}, ataInfo, "wipe")
+			},
+			want: []string{`"error": "sanitize-crypto-scramble: sanitize did not succeed"`},
+			err:  errors.New("failed to wipe /dev/sda"),
+		},
+		{
+			name: "nvme unchanged",
+			args: []string{"-force", "-samples", "2", "/dev/nvme0n1"},
+			drive: func(img []byte) drive {
+				d, _ := newNVMeDrive(&fakeNVMe{img: img, effect: func([]byte) {}, ctrl: nvme.Controller{SANICAP: 0x1}})
+				return d
+			},
+			cert: &certificate{
+				Device:    "/dev/nvme0n1",
+				Transport: "nvme",
+				Size:      imageSize,
+				Method:    "sanitize-crypto-erase",
+				Result:    "failed",
+				Error:     "verification failed",
+			},
+			verify: &verification{Samples: 2, Unchanged: []int64{0, imageSize - sampleSize}},
+			err:    errors.New("failed to wipe /dev/nvme0n1"),
+		},
+		{
+			name: "nvme overwrite mismatch",
+			args: []string{"-force", "-samples", "2", "-method", "sanitize-overwrite", "/dev/nvme0n1"},
+			drive: func(img []byte) drive {
+				d, _ := newNVMeDrive(&fakeNVMe{img: img, effect: scramble, ctrl: nvme.Controller{SANICAP: 0x4}})
+				return d
+			},
+			verify: &verification{Samples: 2, Mismatched: []int64{0, imageSize - sampleSize}},
+			err:    errors.New("failed to wipe /dev/nvme0n1"),
+		},
+		{
+			name: "nvme format",
+			args: []string{"-force", "-method", "format-crypto-erase", "/dev/nvme0n1"},
+			drive: func(img []byte) drive {
+				d, _ := newNVMeDrive(&fakeNVMe{img: img, effect: scramble, ctrl: nvme.Controller{OACS: 0x2, FNA: 0x4}})
+				return d
+			},
+			want: []string{`"method": "format-crypto-erase"`, `"result": "passed"`},
+		},
+		{
+			name: "scsi unmap",
+			args: []string{"-force", "-method", "unmap", "/dev/sdb"},
+			drive: func(img []byte) drive {
+				return &scsiDrive{
+					d:        &fakeSCSI{img: img, capacity: scuzz.Capacity{Blocks: imageSize / 512, BlockSize: 512, Provisioned: true, ReadsZeros: true}, maxUnmap: 300},
+					capacity: &scuzz.Capacity{Blocks: imageSize / 512, BlockSize: 512, Provisioned: true, ReadsZeros: true},
+				}
+			},
+			want: []string{`"transport": "scsi"`, `"result": "passed"`},
+		},
+		{
+			name: "scsi format unit",
+			args: []string{"-force", "/dev/sdb"},
+			drive: func(img []byte) drive {
+				return &scsiDrive{d: &fakeSCSI{img: img}, capacity: &scuzz.Capacity{}}
+			},
+			want: []string{`"error": "format-unit: FORMAT UNIT: unsupported"`},
+			err:  errors.New("failed to wipe /dev/sdb"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			img := newImage()
+			var stdout, stderr bytes.Buffer
+			start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+			c := &cmd{
+				stdout:    &stdout,
+				stderr:    &stderr,
+				open:      func(string, string) (drive, error) { return tt.drive(img), nil },
+				openBlock: func(string) (blockDevice, error) { return memBlock{bytes.NewReader(img)}, nil },
+				now: func() time.Time {
+					start = start.Add(time.Minute)
+					return start
+				},
+				rand: rand.New(rand.NewSource(1)),
+			}
+			err := c.run(tt.args)
+			if err != tt.err && (err == nil || tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Fatalf("run(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout.String(), w) {
+					t.Errorf("output does not contain %q:\n%s", w, stdout.String())
+				}
+			}
+			if tt.cert == nil && tt.verify == nil {
+				return
+			}
+			var cert certificate
+			if err := json.Unmarshal(stdout.Bytes(), &cert); err != nil {
+				t.Fatalf("certificate %q: %v", stdout.String(), err)
+			}
+			if !reflect.DeepEqual(cert.Verification, tt.verify) {
+				t.Errorf("verification = %+v, want %+v", cert.Verification, tt.verify)
+			}
+			if tt.cert == nil {
+				return
+			}
+			if got := cert.End.Sub(cert.Start); got != time.Minute {
+				t.Errorf("erase took %v, want 1m", got)
+			}
+			cert.Start, cert.End, cert.Verification = time.Time{}, time.Time{}, nil
+			if !reflect.DeepEqual(&cert, tt.cert) {
+				t.Errorf("certificate = %+v, want %+v", cert, *tt.cert)
+			}
+		})
+	}
+}
+
+func TestCertDir(t *testing.T) {
+	dir := t.TempDir()
+	img := newImage()
+	c := &cmd{
+		stdout: &bytes.Buffer{},
+		stderr: &bytes.Buffer{},
+		open: func(string, string) (drive, error) {
+			return newATADrive(&fakeATA{img: img, effect: zero}, &scuzz.Info{SecurityStatus: 0x1}, "wipe"), nil
+		},
+		openBlock: func(string) (blockDevice, error) { return memBlock{bytes.NewReader(img)}, nil },
+		now:       time.Now,
+		rand:      rand.New(rand.NewSource(1)),
+	}
+	if err := c.run([]string{"-force", "-cert", dir, "/dev/sda"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "sda.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"method": "security-erase"`) {
+		t.Errorf("certificate = %s, want a security-erase", b)
+	}
+}
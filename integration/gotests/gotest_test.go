@@ -42,6 +42,7 @@ func testPkgs(t *testing.T) []string {
 	// 2. or it depends on some test files (for example /bin/sleep)
 	blocklist := []string{
 		"github.com/u-root/u-root/cmds/core/cmp",
+		"github.com/u-root/u-root/cmds/core/curl",
 		"github.com/u-root/u-root/cmds/core/dd",
 		"github.com/u-root/u-root/cmds/core/fusermount",
 		"github.com/u-root/u-root/cmds/core/wget",
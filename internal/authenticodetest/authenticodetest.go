@@ -0,0 +1,243 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package authenticodetest signs PE images with Authenticode signatures for
+// tests.
+package authenticodetest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/u-root/u-root/pkg/authenticode"
+)
+
+var (
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSpcIndirectData = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidSpcPEImageData  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}
+	oidRSA             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+const (
+	// certRevision is WIN_CERT_REVISION_2_0.
+	certRevision = 0x0200
+	// certTypePKCS is WIN_CERT_TYPE_PKCS_SIGNED_DATA.
+	certTypePKCS = 0x0002
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          spcAttributeTypeAndOptionalValue
+	MessageDigest digestInfo
+}
+
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+// securityDir returns the offset of the certificate table entry in the
+// data directories of a PE image that authenticode.Hash accepted.
+func securityDir(img []byte) int {
+	le := binary.LittleEndian
+	oh := int(le.Uint32(img[0x3c:])) + 24
+	if le.Uint16(img[oh:]) == 0x10b {
+		return oh + 96 + 4*8
+	}
+	return oh + 112 + 4*8
+}
+
+// spcPEImageData returns an SpcPeImageData with the obsolete file link that
+// signing tools put in it.
+func spcPEImageData() asn1.RawValue {
+	var name []byte
+	for _, c := range utf16.Encode([]rune("<<<Obsolete>>>")) {
+		name = append(name, byte(c>>8), byte(c))
+	}
+	tag := func(t int, compound bool, b []byte) []byte {
+		v, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: t, IsCompound: compound, Bytes: b})
+		return v
+	}
+	flags, _ := asn1.Marshal(asn1.BitString{})
+	// SpcLink [0] { SpcString [2] { unicode [0] IMPLICIT } }.
+	file := tag(0, true, tag(2, true, tag(0, false, name)))
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: append(flags, file...)}
+}
+
+func marshalAttribute(oid asn1.ObjectIdentifier, v interface{}) ([]byte, error) {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(attribute{Type: oid, Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: b}})
+}
+
+// Sign signs a PE image with key, and returns the signed image. certs are
+// added to the signature and the first one has to be the certificate of
+// key. The digest is SHA-256.
+//
+// The image must not be signed yet.
+func Sign(img []byte, key crypto.Signer, certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no signer certificate")
+	}
+
+	// The certificate table is 8 byte aligned, and the padding before
+	// it is hashed.
+	out := append([]byte{}, img...)
+	out = append(out, make([]byte, (8-len(out)%8)%8)...)
+	digest, err := authenticode.Hash(out, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	dir := securityDir(out)
+	if binary.LittleEndian.Uint32(out[dir+4:]) != 0 {
+		return nil, errors.New("image is already signed")
+	}
+
+	sha256 := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	content, err := asn1.Marshal(spcIndirectDataContent{
+		Data:          spcAttributeTypeAndOptionalValue{Type: oidSpcPEImageData, Value: spcPEImageData()},
+		MessageDigest: digestInfo{Algorithm: sha256, Digest: digest},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	d := crypto.SHA256.New()
+	d.Write(raw.Bytes)
+
+	// Authenticated attributes are a DER SET OF, so they are sorted.
+	ct, err := marshalAttribute(oidContentType, oidSpcIndirectData)
+	if err != nil {
+		return nil, err
+	}
+	md, err := marshalAttribute(oidMessageDigest, d.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	attrs := [][]byte{ct, md}
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return nil, err
+	}
+	d = crypto.SHA256.New()
+	d.Write(set)
+	sig, err := key.Sign(rand.Reader, d.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	var alg pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		alg = pkix.AlgorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		alg = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, errors.New("key is neither RSA nor ECDSA")
+	}
+
+	var der []byte
+	for _, c := range certs {
+		der = append(der, c.Raw...)
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256},
+		ContentInfo: contentInfo{
+			ContentType: oidSpcIndirectData,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				Issuer: asn1.RawValue{FullBytes: certs[0].RawIssuer},
+				Serial: certs[0].SerialNumber,
+			},
+			DigestAlgorithm:           sha256,
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(attrs, nil)},
+			DigestEncryptionAlgorithm: alg,
+			EncryptedDigest:           sig,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	p7, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+	entry := make([]byte, 8, 8+len(p7)+7)
+	le.PutUint32(entry, uint32(8+len(p7)))
+	le.PutUint16(entry[4:], certRevision)
+	le.PutUint16(entry[6:], certTypePKCS)
+	entry = append(entry, p7...)
+	entry = append(entry, make([]byte, (8-len(entry)%8)%8)...)
+
+	le.PutUint32(out[dir:], uint32(len(out)))
+	le.PutUint32(out[dir+4:], uint32(len(entry)))
+	return append(out, entry...), nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package authenticode verifies Authenticode signatures of PE images, such as
+// EFI applications.
+//
+// An Authenticode signature is a PKCS#7 SignedData in the certificate table
+// of the image. It signs a digest of the image that leaves out the checksum,
+// the certificate table and its data directory entry. See "Windows
+// Authenticode Portable Executable Signature Format".
+//
+// Like UEFI firmware, Verify accepts an image if a signature chains to a
+// certificate in a db, and ignores the validity periods of certificates.
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrNotSigned is returned by Verify for an image without signatures.
+	ErrNotSigned = errors.New("image is not signed")
+	// ErrUntrusted is returned by Verify if no signature chains to the db.
+	ErrUntrusted = errors.New("no signature is trusted by the db")
+)
+
+const (
+	// certRevision is WIN_CERT_REVISION_2_0.
+	certRevision = 0x0200
+	// certTypePKCS is WIN_CERT_TYPE_PKCS_SIGNED_DATA.
+	certTypePKCS = 0x0002
+	// securityDir is the index of the certificate table in the data
+	// directories.
+	securityDir = 4
+)
+
+// header has the offsets of the PE header fields Authenticode cares about.
+type header struct {
+	checksum    int
+	securityDir int
+	headerSize  int
+	// sections are the file offset and size of each section.
+	sections [][2]int
+	// certs and certsSize are the certificate table.
+	certs, certsSize int
+}
+
+func parseHeader(img []byte) (*header, error) {
+	if len(img) < 0x40 || img[0] != 'M' || img[1] != 'Z' {
+		return nil, errors.New("not a PE image: no MZ header")
+	}
+	le := binary.LittleEndian
+	pe := int(le.Uint32(img[0x3c:]))
+	if pe < 0x40 || pe+24 > len(img) || !bytes.Equal(img[pe:pe+4], []byte("PE\x00\x00")) {
+		return nil, errors.New("not a PE image: no PE signature")
+	}
+	nsect := int(le.Uint16(img[pe+6:]))
+	ohSize := int(le.Uint16(img[pe+20:]))
+	oh := pe + 24
+	if oh+ohSize > len(img) || ohSize < 2 {
+		return nil, errors.New("optional header is truncated")
+	}
+
+	var dirs, ndirs int
+	switch magic := le.Uint16(img[oh:]); magic {
+	case 0x10b:
+		dirs, ndirs = oh+96, oh+92
+	case 0x20b:
+		dirs, ndirs = oh+112, oh+108
+	default:
+		return nil, fmt.Errorf("unknown optional header magic %#x", magic)
+	}
+	if dirs > oh+ohSize || le.Uint32(img[ndirs:]) <= securityDir || dirs+(securityDir+1)*8 > oh+ohSize {
+		return nil, errors.New("image has no certificate table entry")
+	}
+	h := &header{
+		checksum:    oh + 64,
+		securityDir: dirs + securityDir*8,
+		headerSize:  int(le.Uint32(img[oh+60:])),
+		certs:       int(le.Uint32(img[dirs+securityDir*8:])),
+		certsSize:   int(le.Uint32(img[dirs+securityDir*8+4:])),
+	}
+	if h.headerSize < h.securityDir+8 || h.headerSize > len(img) {
+		return nil, fmt.Errorf("bad SizeOfHeaders %#x", h.headerSize)
+	}
+	if h.certsSize == 0 {
+		h.certs = 0
+	} else if h.certs < h.headerSize || h.certs+h.certsSize != len(img) {
+		return nil, errors.New("certificate table is not at the end of the image")
+	}
+
+	st := oh + ohSize
+	if st+nsect*40 > h.headerSize {
+		return nil, errors.New("section table is truncated")
+	}
+	for i := 0; i < nsect; i++ {
+		s := img[st+i*40:]
+		size, off := int(le.Uint32(s[16:])), int(le.Uint32(s[20:]))
+		if size == 0 {
+			continue
+		}
+		if off < h.headerSize || off+size > len(img)-h.certsSize {
+			return nil, fmt.Errorf("section %d is outside the image", i)
+		}
+		h.sections = append(h.sections, [2]int{off, size})
+	}
+	sort.Slice(h.sections, func(i, j int) bool { return h.sections[i][0] < h.sections[j][0] })
+	return h, nil
+}
+
+// Hash returns the Authenticode digest of a PE image.
+func Hash(img []byte, hash crypto.Hash) ([]byte, error) {
+	h, err := parseHeader(img)
+	if err != nil {
+		return nil, err
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("hash %v is not available", hash)
+	}
+	d := hash.New()
+	d.Write(img[:h.checksum])
+	d.Write(img[h.checksum+4 : h.securityDir])
+	d.Write(img[h.securityDir+8 : h.headerSize])
+	n := h.headerSize
+	for _, s := range h.sections {
+		d.Write(img[s[0] : s[0]+s[1]])
+		n += s[1]
+	}
+	// Whatever follows the sections, up to the certificate table, is
+	// hashed too.
+	if end := len(img) - h.certsSize; n < end {
+		d.Write(img[n:end])
+	}
+	return d.Sum(nil), nil
+}
+
+// Signatures returns the Authenticode signatures in the certificate table of
+// a PE image.
+func Signatures(img []byte) ([]*Signature, error) {
+	h, err := parseHeader(img)
+	if err != nil {
+		return nil, err
+	}
+	var sigs []*Signature
+	t := img[h.certs : h.certs+h.certsSize]
+	for len(t) >= 8 {
+		le := binary.LittleEndian
+		n := int(le.Uint32(t))
+		if n < 8 || n > len(t) {
+			return nil, fmt.Errorf("certificate table entry of %d bytes in %d", n, len(t))
+		}
+		if le.Uint16(t[4:]) == certRevision && le.Uint16(t[6:]) == certTypePKCS {
+			s, err := ParseSignature(t[8:n])
+			if err != nil {
+				return nil, err
+			}
+			sigs = append(sigs, s)
+		}
+		// Entries are 8 byte aligned.
+		n = (n + 7) &^ 7
+		if n > len(t) {
+			break
+		}
+		t = t[n:]
+	}
+	return sigs, nil
+}
+
+// Verify verifies the Authenticode signatures of a PE image, and returns nil
+// if one of them is valid and chains to a certificate in db.
+func Verify(img []byte, db []*x509.Certificate) error {
+	sigs, err := Signatures(img)
+	if err != nil {
+		return err
+	}
+	if len(sigs) == 0 {
+		return ErrNotSigned
+	}
+	err = ErrUntrusted
+	for _, s := range sigs {
+		if e := s.Verify(img); e != nil {
+			err = e
+			continue
+		}
+		if s.ChainsTo(db) {
+			return nil
+		}
+	}
+	return err
+}
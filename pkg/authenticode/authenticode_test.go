@@ -0,0 +1,281 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package authenticode_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/internal/authenticodetest"
+	"github.com/u-root/u-root/pkg/authenticode"
+)
+
+// testImage returns a PE32+ image with a .text and a .data section, and 5
+// bytes after them.
+func testImage() []byte {
+	var b bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	b.Write(dos)
+	b.WriteString("PE\x00\x00")
+	binary.Write(&b, binary.LittleEndian, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     2,
+		SizeOfOptionalHeader: 240,
+	})
+	binary.Write(&b, binary.LittleEndian, pe.OptionalHeader64{
+		Magic:               0x20b,
+		FileAlignment:       0x200,
+		SectionAlignment:    0x1000,
+		SizeOfHeaders:       0x200,
+		CheckSum:            0x1234,
+		NumberOfRvaAndSizes: 16,
+	})
+	for i, name := range []string{".text", ".data"} {
+		s := pe.SectionHeader32{
+			VirtualSize:      0x100,
+			VirtualAddress:   uint32(0x1000 * (i + 1)),
+			SizeOfRawData:    0x200,
+			PointerToRawData: uint32(0x200 * (i + 1)),
+		}
+		copy(s.Name[:], name)
+		binary.Write(&b, binary.LittleEndian, s)
+	}
+	img := make([]byte, 0x600, 0x605)
+	copy(img, b.Bytes())
+	for i := 0x200; i < 0x600; i++ {
+		img[i] = byte(i)
+	}
+	return append(img, "extra"...)
+}
+
+func testCert(t *testing.T, name string, parent *x509.Certificate, parentKey crypto.Signer, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHash(t *testing.T) {
+	img := testImage()
+	h, err := authenticode.Hash(img, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The checksum and certificate table entry are not hashed.
+	skipped := append([]byte{}, img...)
+	skipped[0x40+24+64] = 0xff
+	skipped[0x40+24+112+4*8+7] = 0
+	if h2, err := authenticode.Hash(skipped, crypto.SHA256); err != nil || !bytes.Equal(h, h2) {
+		t.Errorf("Hash() with another checksum = %x, %v, want %x", h2, err, h)
+	}
+
+	for _, off := range []int{0x40 + 24 + 60, 0x300, 0x500, 0x602} {
+		changed := append([]byte{}, img...)
+		changed[off] ^= 1
+		if h2, err := authenticode.Hash(changed, crypto.SHA256); err == nil && bytes.Equal(h, h2) {
+			t.Errorf("Hash() with byte %#x changed did not change", off)
+		}
+	}
+
+	if _, err := authenticode.Hash(img[:0x100], crypto.SHA256); err == nil {
+		t.Errorf("Hash() of a truncated image succeeded")
+	}
+	if _, err := authenticode.Hash([]byte("not a PE image, just some text long enough to have a header..."), crypto.SHA256); err == nil {
+		t.Errorf("Hash() of text succeeded")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := testCert(t, "CA", nil, nil, caKey)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := testCert(t, "Signer", ca, caKey, key)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := testCert(t, "Other CA", nil, nil, otherKey)
+
+	img := testImage()
+	if err := authenticode.Verify(img, []*x509.Certificate{ca}); !errors.Is(err, authenticode.ErrNotSigned) {
+		t.Errorf("Verify(unsigned) = %v, want %v", err, authenticode.ErrNotSigned)
+	}
+
+	signed, err := authenticodetest.Sign(img, key, []*x509.Certificate{leaf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticodetest.Sign(signed, key, []*x509.Certificate{leaf}); err == nil {
+		t.Errorf("Sign(signed) succeeded")
+	}
+	d, err := authenticode.Hash(img, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Padding the image to 8 bytes changes the digest.
+	if d2, err := authenticode.Hash(signed, crypto.SHA256); err != nil || bytes.Equal(d, d2) {
+		t.Errorf("Hash(signed) = %x, %v, want a different digest than %x", d2, err, d)
+	}
+
+	sigs, err := authenticode.Signatures(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 1 || sigs[0].Hash != crypto.SHA256 || !sigs[0].Signer.Equal(leaf) {
+		t.Fatalf("Signatures() = %+v, want one SHA-256 signature by the signer", sigs)
+	}
+
+	for _, tt := range []struct {
+		name string
+		db   []*x509.Certificate
+		err  error
+	}{
+		{"CA", []*x509.Certificate{other, ca}, nil},
+		{"signer", []*x509.Certificate{leaf}, nil},
+		{"other", []*x509.Certificate{other}, authenticode.ErrUntrusted},
+		{"empty", nil, authenticode.ErrUntrusted},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := authenticode.Verify(signed, tt.db); !errors.Is(err, tt.err) {
+				t.Errorf("Verify() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// Changing the image breaks the signature, while the checksum can be
+	// changed.
+	bad := append([]byte{}, signed...)
+	bad[0x400] ^= 1
+	if err := authenticode.Verify(bad, []*x509.Certificate{ca}); err == nil || errors.Is(err, authenticode.ErrUntrusted) {
+		t.Errorf("Verify(changed) = %v, want a digest mismatch", err)
+	}
+	bad = append([]byte{}, signed...)
+	bad[0x40+24+64] ^= 1
+	if err := authenticode.Verify(bad, []*x509.Certificate{ca}); err != nil {
+		t.Errorf("Verify(new checksum) = %v, want nil", err)
+	}
+
+	// So does a signature by an ECDSA key with its chain.
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecLeaf := testCert(t, "EC signer", ca, caKey, ecKey)
+	signed, err = authenticodetest.Sign(img, ecKey, []*x509.Certificate{ecLeaf, ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authenticode.Verify(signed, []*x509.Certificate{ca}); err != nil {
+		t.Errorf("Verify(ECDSA) = %v, want nil", err)
+	}
+}
+
+func TestChainsTo(t *testing.T) {
+	// cert returns a certificate valid from notBefore for a year.
+	cert := func(name string, parent *x509.Certificate, parentKey, key crypto.Signer, ca bool, notBefore time.Time) *x509.Certificate {
+		t.Helper()
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             notBefore,
+			NotAfter:              notBefore.AddDate(1, 0, 0),
+			BasicConstraintsValid: true,
+			IsCA:                  ca,
+			KeyUsage:              x509.KeyUsageDigitalSignature,
+		}
+		if ca {
+			tmpl.KeyUsage |= x509.KeyUsageCertSign
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	var keys [4]crypto.Signer
+	for i := range keys {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = k
+	}
+	// The signer is issued after the intermediate expired, which is issued
+	// before the root is valid.
+	root := cert("Root", nil, nil, keys[0], true, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	inter := cert("Intermediate", root, keys[0], keys[1], true, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))
+	leaf := cert("Signer", inter, keys[1], keys[2], false, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	other := cert("Other", nil, nil, keys[3], true, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	// notCA has the intermediate's name and key, but may not issue
+	// certificates.
+	notCA := cert("Intermediate", root, keys[0], keys[1], false, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, tt := range []struct {
+		name  string
+		certs []*x509.Certificate
+		db    []*x509.Certificate
+		want  bool
+	}{
+		{"root", []*x509.Certificate{leaf, inter}, []*x509.Certificate{other, root}, true},
+		{"intermediate", []*x509.Certificate{leaf}, []*x509.Certificate{inter}, true},
+		{"signer", []*x509.Certificate{leaf}, []*x509.Certificate{leaf}, true},
+		{"missing intermediate", []*x509.Certificate{leaf}, []*x509.Certificate{root}, false},
+		{"intermediate not a CA", []*x509.Certificate{leaf, notCA}, []*x509.Certificate{root}, false},
+		{"other", []*x509.Certificate{leaf, inter}, []*x509.Certificate{other}, false},
+		{"empty", []*x509.Certificate{leaf, inter}, nil, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &authenticode.Signature{Signer: leaf, Certificates: tt.certs}
+			if got := s.ChainsTo(tt.db); got != tt.want {
+				t.Errorf("ChainsTo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSpcIndirectData      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidRSA                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSA                = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSHA1                 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSignatureRSAPrefix   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1}
+	oidSignatureECDSAPrefix = asn1.ObjectIdentifier{1, 2, 840, 10045, 4}
+)
+
+var hashes = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+	rsa  x509.SignatureAlgorithm
+	ec   x509.SignatureAlgorithm
+}{
+	{oidSHA1, crypto.SHA1, x509.SHA1WithRSA, x509.ECDSAWithSHA1},
+	{oidSHA256, crypto.SHA256, x509.SHA256WithRSA, x509.ECDSAWithSHA256},
+	{oidSHA384, crypto.SHA384, x509.SHA384WithRSA, x509.ECDSAWithSHA384},
+	{oidSHA512, crypto.SHA512, x509.SHA512WithRSA, x509.ECDSAWithSHA512},
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          spcAttributeTypeAndOptionalValue
+	MessageDigest digestInfo
+}
+
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+// Signature is an Authenticode signature.
+type Signature struct {
+	// Hash is the hash function of Digest.
+	Hash crypto.Hash
+	// Digest is the signed digest of the image.
+	Digest []byte
+	// Certificates are the certificates that came with the signature,
+	// including Signer.
+	Certificates []*x509.Certificate
+	// Signer is the certificate of the key that made the signature.
+	Signer *x509.Certificate
+
+	// signed are the bytes the signature is over.
+	signed    []byte
+	algorithm x509.SignatureAlgorithm
+	signature []byte
+}
+
+func hashOf(oid asn1.ObjectIdentifier) (int, error) {
+	for i, h := range hashes {
+		if h.oid.Equal(oid) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %v", oid)
+}
+
+func unmarshal(b []byte, v interface{}, what string) error {
+	rest, err := asn1.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", what, err)
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("parsing %s: %d trailing bytes", what, len(rest))
+	}
+	return nil
+}
+
+// ParseSignature parses a DER PKCS#7 SignedData of SpcIndirectDataContent,
+// the contents of a WIN_CERTIFICATE.
+func ParseSignature(der []byte) (*Signature, error) {
+	// Signatures may be padded to 8 bytes, so trailing data is allowed.
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("parsing ContentInfo: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("content type %v is not SignedData", ci.ContentType)
+	}
+	var sd signedData
+	if err := unmarshal(ci.Content.Bytes, &sd, "SignedData"); err != nil {
+		return nil, err
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectData) {
+		return nil, fmt.Errorf("signed content type %v is not SpcIndirectDataContent", sd.ContentInfo.ContentType)
+	}
+	var content spcIndirectDataContent
+	if err := unmarshal(sd.ContentInfo.Content.Bytes, &content, "SpcIndirectDataContent"); err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("%d signers, want 1", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+
+	i, err := hashOf(content.MessageDigest.Algorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	s := &Signature{
+		Hash:      hashes[i].hash,
+		Digest:    content.MessageDigest.Digest,
+		signature: si.EncryptedDigest,
+	}
+	if len(sd.Certificates.Bytes) > 0 {
+		if s.Certificates, err = x509.ParseCertificates(sd.Certificates.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range s.Certificates {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) && c.SerialNumber.Cmp(si.IssuerAndSerialNumber.Serial) == 0 {
+			s.Signer = c
+			break
+		}
+	}
+	if s.Signer == nil {
+		return nil, errors.New("signer certificate not found")
+	}
+
+	if i, err = hashOf(si.DigestAlgorithm.Algorithm); err != nil {
+		return nil, err
+	}
+	switch alg := si.DigestEncryptionAlgorithm.Algorithm; {
+	case alg.Equal(oidRSA), len(alg) == len(oidSignatureRSAPrefix)+1 && alg[:len(alg)-1].Equal(oidSignatureRSAPrefix):
+		s.algorithm = hashes[i].rsa
+	case alg.Equal(oidECDSA), len(alg) == len(oidSignatureECDSAPrefix)+2 && alg[:len(alg)-2].Equal(oidSignatureECDSAPrefix):
+		s.algorithm = hashes[i].ec
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %v", alg)
+	}
+
+	// The content digest is over the contents of SpcIndirectDataContent,
+	// without its tag and length.
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &raw); err != nil {
+		return nil, err
+	}
+	if len(si.AuthenticatedAttributes.FullBytes) == 0 {
+		s.signed = raw.Bytes
+		return s, nil
+	}
+
+	// With authenticated attributes, the signature is over them, encoded
+	// as a SET OF, and they have the content digest.
+	var digest []byte
+	for b := si.AuthenticatedAttributes.Bytes; len(b) > 0; {
+		var a attribute
+		if b, err = asn1.Unmarshal(b, &a); err != nil {
+			return nil, fmt.Errorf("parsing authenticated attributes: %w", err)
+		}
+		if a.Type.Equal(oidMessageDigest) {
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &digest); err != nil {
+				return nil, fmt.Errorf("parsing message digest: %w", err)
+			}
+		}
+	}
+	h := hashes[i].hash
+	if !h.Available() {
+		return nil, fmt.Errorf("hash %v is not available", h)
+	}
+	d := h.New()
+	d.Write(raw.Bytes)
+	if !bytes.Equal(d.Sum(nil), digest) {
+		return nil, errors.New("content digest does not match the message digest attribute")
+	}
+	s.signed = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	return s, nil
+}
+
+// Verify checks that the signature is valid for a PE image.
+func (s *Signature) Verify(img []byte) error {
+	d, err := Hash(img, s.Hash)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(d, s.Digest) {
+		return fmt.Errorf("image %v digest %x does not match the signed digest %x", s.Hash, d, s.Digest)
+	}
+	if err := s.Signer.CheckSignature(s.algorithm, s.signed, s.signature); err != nil {
+		return fmt.Errorf("signature by %q: %w", s.Signer.Subject, err)
+	}
+	return nil
+}
+
+// ChainsTo returns whether the signer is one of the certificates in db, or
+// is issued by one through the certificates of the signature.
+//
+// Like firmware, which has no trusted time, ChainsTo ignores the validity
+// periods of the certificates and only checks the signatures of the chain.
+func (s *Signature) ChainsTo(db []*x509.Certificate) bool {
+	return chainsTo(s.Signer, db, s.Certificates, len(s.Certificates))
+}
+
+// chainsTo returns whether c is in db or is issued by a certificate in db,
+// directly or through up to depth of the intermediates.
+func chainsTo(c *x509.Certificate, db, intermediates []*x509.Certificate, depth int) bool {
+	for _, r := range db {
+		if c.Equal(r) || issuedBy(c, r) {
+			return true
+		}
+	}
+	if depth == 0 {
+		return false
+	}
+	for _, i := range intermediates {
+		if !c.Equal(i) && issuedBy(c, i) && chainsTo(i, db, intermediates, depth-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// issuedBy returns whether c is signed by the CA certificate parent.
+func issuedBy(c, parent *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, parent.RawSubject) && c.CheckSignatureFrom(parent) == nil
+}
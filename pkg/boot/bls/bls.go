@@ -4,9 +4,10 @@
 
 // Package bls parses systemd Boot Loader Spec config files.
 //
-// See spec at https://systemd.io/BOOT_LOADER_SPECIFICATION. Only Type #1 BLS
-// entries are supported at the moment, while Type #2 EFI entries are left
-// unimplemented awaiting EFI boot support in u-root/LinuxBoot.
+// See spec at https://systemd.io/BOOT_LOADER_SPECIFICATION. Type #1 BLS
+// entries and Type #2 EFI entries are supported. Type #2 entries, and the efi
+// programs of Type #1 entries, must be unified kernel images, which are
+// booted with kexec. Other EFI programs cannot be booted without UEFI.
 //
 // This package also supports the systemd-boot loader.conf as described in
 // https://www.freedesktop.org/software/systemd/man/loader.conf.html. Only the
@@ -15,38 +16,57 @@ package bls
 
 import (
 	"bufio"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/uki"
 	"github.com/u-root/u-root/pkg/ulog"
 )
 
 const (
 	blsEntriesDir = "loader/entries"
+	// Type #2 entries are unified kernel images in this directory.
+	blsType2Dir = "EFI/Linux"
 	// Set a higher default rank for BLS. It should be booted prior to the
 	// other local images.
 	blsDefaultRank = 1
 )
 
+// Options are optional parameters for ScanBLSEntries and ScanBLSEntriesFS.
+type Options struct {
+	// DB is the Secure Boot db that unified kernel images must be
+	// Authenticode signed with. If it is nil, their signatures are not
+	// checked.
+	DB []*x509.Certificate
+}
+
 func cutConf(s string) string {
-	if strings.HasSuffix(s, ".conf") {
-		return s[:len(s)-6]
-	}
-	return s
+	return strings.TrimSuffix(s, ".conf")
 }
 
 // ScanBLSEntries scans the filesystem root for valid BLS entries.
 // This function skips over invalid or unreadable entries in an effort
-// to return everything that is bootable.
-func ScanBLSEntries(log ulog.Logger, fsRoot string) ([]boot.OSImage, error) {
-	entriesDir := filepath.Join(fsRoot, blsEntriesDir)
+// to return everything that is bootable. opts may be nil.
+func ScanBLSEntries(log ulog.Logger, fsRoot string, opts *Options) ([]boot.OSImage, error) {
+	return ScanBLSEntriesFS(log, os.DirFS(fsRoot), opts)
+}
 
-	files, err := filepath.Glob(filepath.Join(entriesDir, "*.conf"))
+// ScanBLSEntriesFS is like ScanBLSEntries, but scans fsys, such as a file
+// system read in-process by the ext4 or fat packages rather than mounted.
+func ScanBLSEntriesFS(log ulog.Logger, fsys fs.FS, opts *Options) ([]boot.OSImage, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	files, err := fs.Glob(fsys, path.Join(blsEntriesDir, "*.conf"))
 	if err != nil {
 		return nil, fmt.Errorf("no BootLoaderSpec entries found: %w", err)
 	}
@@ -54,7 +74,7 @@ func ScanBLSEntries(log ulog.Logger, fsRoot string) ([]boot.OSImage, error) {
 	// loader.conf is not in the real spec; it's an implementation detail
 	// of systemd-boot. It is specified in
 	// https://www.freedesktop.org/software/systemd/man/loader.conf.html
-	loaderConf, err := parseConf(filepath.Join(fsRoot, "loader", "loader.conf"))
+	loaderConf, err := parseConf(fsys, "loader/loader.conf")
 	if err != nil {
 		// loader.conf is optional.
 		loaderConf = make(map[string]string)
@@ -64,9 +84,9 @@ func ScanBLSEntries(log ulog.Logger, fsRoot string) ([]boot.OSImage, error) {
 	// in the spec (but not mandated, surprisingly).
 	imgs := make(map[string]boot.OSImage)
 	for _, f := range files {
-		identifier := cutConf(filepath.Base(f))
+		identifier := cutConf(path.Base(f))
 
-		img, err := parseBLSEntry(f, fsRoot)
+		img, err := parseBLSEntry(fsys, f, opts.DB)
 		if err != nil {
 			log.Printf("BootLoaderSpec skipping entry %s: %v", f, err)
 			continue
@@ -74,6 +94,21 @@ func ScanBLSEntries(log ulog.Logger, fsRoot string) ([]boot.OSImage, error) {
 		imgs[identifier] = img
 	}
 
+	// Type #2 entries are identified by their file name, including the
+	// .efi suffix.
+	files, err = fs.Glob(fsys, path.Join(blsType2Dir, "*.efi"))
+	if err != nil {
+		return nil, fmt.Errorf("no BootLoaderSpec Type #2 entries found: %w", err)
+	}
+	for _, f := range files {
+		img, err := parseUKI(fsys, f, opts.DB)
+		if err != nil {
+			log.Printf("BootLoaderSpec skipping Type #2 entry %s: %v", f, err)
+			continue
+		}
+		imgs[path.Base(f)] = img
+	}
+
 	return sortImages(loaderConf, imgs), nil
 }
 
@@ -114,8 +149,8 @@ func sortImages(loaderConf map[string]string, imgs map[string]boot.OSImage) []bo
 	return rankedImages
 }
 
-func parseConf(entryPath string) (map[string]string, error) {
-	f, err := os.Open(entryPath)
+func parseConf(fsys fs.FS, entryPath string) (map[string]string, error) {
+	f, err := fsys.Open(entryPath)
 	if err != nil {
 		return nil, err
 	}
@@ -144,21 +179,37 @@ func parseConf(entryPath string) (map[string]string, error) {
 // for Type #1 entries", but that's bullshit. Relative file names are indeed in
 // the $BOOT/loader/ directory, but absolute path names are in $BOOT, as
 // evidenced by the entries that kernel-install installs on Fedora 32.
-func filePath(fsRoot, value string) string {
-	if !filepath.IsAbs(value) {
-		return filepath.Join(fsRoot, "loader", value)
+//
+// The returned name is relative to the root of the file system.
+func filePath(value string) string {
+	if !path.IsAbs(value) {
+		return path.Join("loader", value)
+	}
+	return path.Join(".", value)
+}
+
+// openImage opens a kernel or initrd, which must be readable at offsets.
+func openImage(fsys fs.FS, value string) (io.ReaderAt, error) {
+	f, err := fsys.Open(filePath(value))
+	if err != nil {
+		return nil, err
 	}
-	return filepath.Join(fsRoot, value)
+	r, ok := f.(io.ReaderAt)
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf("%s does not support ReadAt", value)
+	}
+	return r, nil
 }
 
-func parseLinuxImage(vals map[string]string, fsRoot string) (boot.OSImage, error) {
+func parseLinuxImage(vals map[string]string, fsys fs.FS) (boot.OSImage, error) {
 	linux := &boot.LinuxImage{}
 
 	var cmdlines []string
 	for key, val := range vals {
 		switch key {
 		case "linux":
-			f, err := os.Open(filePath(fsRoot, val))
+			f, err := openImage(fsys, val)
 			if err != nil {
 				return nil, err
 			}
@@ -166,7 +217,7 @@ func parseLinuxImage(vals map[string]string, fsRoot string) (boot.OSImage, error
 
 		// TODO: initrd may be specified more than once.
 		case "initrd":
-			f, err := os.Open(filePath(fsRoot, val))
+			f, err := openImage(fsys, val)
 			if err != nil {
 				return nil, err
 			}
@@ -189,6 +240,15 @@ func parseLinuxImage(vals map[string]string, fsRoot string) (boot.OSImage, error
 		return nil, fmt.Errorf("malformed Linux config: linux keyword missing")
 	}
 
+	// If both title and version were empty, so will this.
+	linux.Name = entryName(vals)
+	linux.Cmdline = strings.Join(cmdlines, " ")
+	linux.BootRank = bootRank()
+
+	return linux, nil
+}
+
+func entryName(vals map[string]string) string {
 	var name []string
 	if title, ok := vals["title"]; ok && len(title) > 0 {
 		name = append(name, title)
@@ -196,24 +256,62 @@ func parseLinuxImage(vals map[string]string, fsRoot string) (boot.OSImage, error
 	if version, ok := vals["version"]; ok && len(version) > 0 {
 		name = append(name, version)
 	}
-	// If both title and version were empty, so will this.
-	linux.Name = strings.Join(name, " ")
-	linux.Cmdline = strings.Join(cmdlines, " ")
-	linux.BootRank = blsDefaultRank
+	return strings.Join(name, " ")
+}
+
+func bootRank() int {
 	if val, exist := os.LookupEnv("BLS_BOOT_RANK"); exist {
 		if rank, err := strconv.Atoi(val); err == nil {
-			linux.BootRank = rank
+			return rank
 		}
 	}
+	return blsDefaultRank
+}
 
+// parseUKI parses a unified kernel image, which is verified against db
+// unless it is nil.
+func parseUKI(fsys fs.FS, name string, db []*x509.Certificate) (*boot.LinuxImage, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	u, err := uki.Parse(b, db)
+	if err != nil {
+		return nil, err
+	}
+	linux, err := u.LinuxImage()
+	if err != nil {
+		return nil, err
+	}
+	linux.BootRank = bootRank()
+	return linux, nil
+}
+
+// parseEFIImage parses a Type #1 entry with an efi program, which has to be
+// a unified kernel image.
+func parseEFIImage(vals map[string]string, fsys fs.FS, db []*x509.Certificate) (boot.OSImage, error) {
+	linux, err := parseUKI(fsys, filePath(vals["efi"]), db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like systemd-stub, the options only replace the command line of
+	// the image if signatures are not checked.
+	if options, ok := vals["options"]; ok && db == nil {
+		linux.Cmdline = options
+	}
+	// The image's own name is used if the entry has none.
+	if name := entryName(vals); len(name) > 0 {
+		linux.Name = name
+	}
 	return linux, nil
 }
 
-// parseBLSEntry takes a Type #1 BLS entry and the directory of entries, and
-// returns a LinuxImage.
+// parseBLSEntry takes a Type #1 BLS entry, the file system it is on and the
+// db to verify unified kernel images against, and returns a LinuxImage.
 // An error is returned if the syntax is wrong or required keys are missing.
-func parseBLSEntry(entryPath, fsRoot string) (boot.OSImage, error) {
-	vals, err := parseConf(entryPath)
+func parseBLSEntry(fsys fs.FS, entryPath string, db []*x509.Certificate) (boot.OSImage, error) {
+	vals, err := parseConf(fsys, entryPath)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing config in %s: %w", entryPath, err)
 	}
@@ -221,11 +319,11 @@ func parseBLSEntry(entryPath, fsRoot string) (boot.OSImage, error) {
 	var img boot.OSImage
 	err = fmt.Errorf("neither linux, efi, nor multiboot present in BootLoaderSpec config")
 	if _, ok := vals["linux"]; ok {
-		img, err = parseLinuxImage(vals, fsRoot)
+		img, err = parseLinuxImage(vals, fsys)
 	} else if _, ok := vals["multiboot"]; ok {
 		err = fmt.Errorf("multiboot not yet supported")
 	} else if _, ok := vals["efi"]; ok {
-		err = fmt.Errorf("EFI not yet supported")
+		img, err = parseEFIImage(vals, fsys, db)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("error parsing config in %s: %w", entryPath, err)
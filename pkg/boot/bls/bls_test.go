@@ -5,12 +5,17 @@
 package bls
 
 import (
+	"crypto/x509"
+	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
 
+	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/boot/boottest"
 	"github.com/u-root/u-root/pkg/ulog/ulogtest"
 )
@@ -38,7 +43,7 @@ func DISABLEDTestGenerateConfigs(t *testing.T) {
 	for _, test := range tests {
 		configPath := strings.TrimRight(test, ".json")
 		t.Run(configPath, func(t *testing.T) {
-			imgs, err := ScanBLSEntries(ulogtest.Logger{t}, configPath)
+			imgs, err := ScanBLSEntries(ulogtest.Logger{t}, configPath, nil)
 			if err != nil {
 				t.Fatalf("Failed to parse %s: %v", test, err)
 			}
@@ -51,12 +56,11 @@ func DISABLEDTestGenerateConfigs(t *testing.T) {
 }
 
 func TestParseBLSEntries(t *testing.T) {
-	fsRoot := "./testdata/madeup"
-	dir := filepath.Join(fsRoot, "loader/entries")
+	fsys := os.DirFS("./testdata/madeup")
 
 	for _, tt := range blsEntries {
 		t.Run(tt.entry, func(t *testing.T) {
-			image, err := parseBLSEntry(filepath.Join(dir, tt.entry), fsRoot)
+			image, err := parseBLSEntry(fsys, path.Join(blsEntriesDir, tt.entry), nil)
 			if err != nil {
 				if tt.err == "" {
 					t.Fatalf("Got error %v", err)
@@ -89,7 +93,7 @@ func TestScanBLSEntries(t *testing.T) {
 				t.Errorf("Failed to read test json '%v':%v", test, err)
 			}
 
-			imgs, err := ScanBLSEntries(ulogtest.Logger{t}, configPath)
+			imgs, err := ScanBLSEntries(ulogtest.Logger{t}, configPath, nil)
 			if err != nil {
 				t.Fatalf("Failed to parse %s: %v", test, err)
 			}
@@ -102,15 +106,14 @@ func TestScanBLSEntries(t *testing.T) {
 }
 
 func TestSetBLSRank(t *testing.T) {
-	fsRoot := "./testdata/madeup"
-	dir := filepath.Join(fsRoot, "loader/entries")
+	fsys := os.DirFS("./testdata/madeup")
 	testRank := 2
 	originRank := os.Getenv("BLS_BOOT_RANK")
 	os.Setenv("BLS_BOOT_RANK", strconv.Itoa(testRank))
 
 	for _, tt := range blsEntries {
 		t.Run(tt.entry, func(t *testing.T) {
-			image, err := parseBLSEntry(filepath.Join(dir, tt.entry), fsRoot)
+			image, err := parseBLSEntry(fsys, path.Join(blsEntriesDir, tt.entry), nil)
 			if err != nil {
 				if tt.err == "" {
 					t.Fatalf("Got error %v", err)
@@ -132,3 +135,69 @@ func TestSetBLSRank(t *testing.T) {
 
 	os.Setenv("BLS_BOOT_RANK", originRank)
 }
+
+func TestScanBLSEntriesFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"vmlinuz": {Data: []byte("kernel\n")},
+	}
+	for i := 0; i < 3; i++ {
+		fsys[fmt.Sprintf("loader/entries/linux-5.10.%d.conf", i)] = &fstest.MapFile{
+			Data: []byte(fmt.Sprintf("title Linux 5.10.%d\nlinux /vmlinuz\noptions console=ttyS0\n", i)),
+		}
+	}
+
+	imgs, err := ScanBLSEntriesFS(ulogtest.Logger{TB: t}, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 3 {
+		t.Fatalf("ScanBLSEntriesFS returned %d images, want 3", len(imgs))
+	}
+	li, ok := imgs[0].(*boot.LinuxImage)
+	if !ok {
+		t.Fatalf("image is a %T, want *boot.LinuxImage", imgs[0])
+	}
+	if li.Name != "Linux 5.10.2" || li.Cmdline != "console=ttyS0" {
+		t.Errorf("first image is %q with %q, want %q with %q", li.Name, li.Cmdline, "Linux 5.10.2", "console=ttyS0")
+	}
+	b := make([]byte, 7)
+	if _, err := li.Kernel.ReadAt(b, 0); err != nil || string(b) != "kernel\n" {
+		t.Errorf("kernel starts with %q, %v, want %q", b, err, "kernel\n")
+	}
+}
+
+func TestScanUKIEntries(t *testing.T) {
+	imgs, err := ScanBLSEntries(ulogtest.Logger{TB: t}, "testdata/uki", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Identifiers sort in reverse, so the Type #1 entry is first.
+	want := []struct {
+		name, cmdline string
+	}{
+		{"Fedora Linux rescue", "root=UUID=1d8cd4b2 ro single"},
+		{"Fedora Linux 36 (Thirty Six) 5.17.5-300.fc36.x86_64", "root=UUID=1d8cd4b2 ro quiet"},
+	}
+	if len(imgs) != len(want) {
+		t.Fatalf("ScanBLSEntries returned %d images, want %d", len(imgs), len(want))
+	}
+	for i, w := range want {
+		li, ok := imgs[i].(*boot.LinuxImage)
+		if !ok {
+			t.Fatalf("image %d is a %T, want *boot.LinuxImage", i, imgs[i])
+		}
+		if li.Name != w.name || li.Cmdline != w.cmdline {
+			t.Errorf("image %d is %q with %q, want %q with %q", i, li.Name, li.Cmdline, w.name, w.cmdline)
+		}
+		b := make([]byte, 5)
+		if _, err := li.Kernel.ReadAt(b, 0); err != nil || string(b) != "linux" {
+			t.Errorf("kernel starts with %q, %v, want %q", b, err, "linux")
+		}
+	}
+
+	// The images are not signed, so they are skipped with a db.
+	opts := &Options{DB: []*x509.Certificate{}}
+	if imgs, err = ScanBLSEntries(ulogtest.Logger{TB: t}, "testdata/uki", opts); err != nil || len(imgs) != 0 {
+		t.Errorf("ScanBLSEntries with a db = %v, %v, want no images", imgs, err)
+	}
+}
@@ -19,8 +19,10 @@ import (
 //
 // mountPool is unmounted before kexecing. noLoad prints the list of entries
 // and exits. If noLoad is false, a boot menu is shown to the user. The
-// user-chosen boot entry will be kexec'd unless noExec is true.
-func ShowMenuAndBoot(entries []menu.Entry, mountPool *mount.Pool, noLoad, noExec bool) {
+// user-chosen boot entry will be kexec'd unless noExec is true. If
+// framebuffer is not empty, the menu is shown on that framebuffer device
+// rather than the terminal, for machines without a serial console.
+func ShowMenuAndBoot(entries []menu.Entry, mountPool *mount.Pool, noLoad, noExec bool, framebuffer string) {
 	if noLoad {
 		log.Print("Not loading menu or kernel. Options:")
 		for i, entry := range entries {
@@ -30,7 +32,12 @@ func ShowMenuAndBoot(entries []menu.Entry, mountPool *mount.Pool, noLoad, noExec
 		os.Exit(0)
 	}
 
-	loadedEntry := menu.ShowMenuAndLoad(true, entries...)
+	var loadedEntry menu.Entry
+	if framebuffer != "" {
+		loadedEntry = menu.ShowFBMenuAndLoad(framebuffer, true, entries...)
+	} else {
+		loadedEntry = menu.ShowMenuAndLoad(true, entries...)
+	}
 
 	// Clean up.
 	if mountPool != nil {
@@ -34,12 +34,13 @@ func fakeDevices() (block.BlockDevices, *mount.Pool, error) {
 	devices := block.BlockDevices{}
 	mountPool := &mount.Pool{}
 	for _, dir := range dirs {
-		// TODO: Also add LABEL to BlockDev
 		fsUUID, _ := os.ReadFile(filepath.Join(dir, "UUID"))
+		fsLabel, _ := os.ReadFile(filepath.Join(dir, "LABEL"))
 		devices = append(devices, &block.BlockDev{
-			Name:   dir,
-			FSType: "test",
-			FsUUID: strings.TrimSpace(string(fsUUID)),
+			Name:    dir,
+			FSType:  "test",
+			FsUUID:  strings.TrimSpace(string(fsUUID)),
+			FsLabel: strings.TrimSpace(string(fsLabel)),
 		})
 		mountPool.Add(&mount.MountPoint{
 			Path:   dir,
@@ -379,7 +379,10 @@ func (c *parser) append(ctx context.Context, config string) error {
 				}
 				c.variables[*setVar] = setVal.String()
 			case *searchLabel:
-				d := c.devices.FilterPartLabel(searchName)
+				d := c.devices.FilterFSLabel(searchName)
+				if len(d) == 0 {
+					d = c.devices.FilterPartLabel(searchName)
+				}
 				if len(d) != 1 {
 					log.Printf("Error: Expected 1 device with label %q, found %d", searchName, len(d))
 					continue
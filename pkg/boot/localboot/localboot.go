@@ -7,6 +7,10 @@ package localboot
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"sort"
 
 	"github.com/u-root/u-root/pkg/boot"
@@ -16,6 +20,8 @@ import (
 	"github.com/u-root/u-root/pkg/boot/syslinux"
 	"github.com/u-root/u-root/pkg/mount"
 	"github.com/u-root/u-root/pkg/mount/block"
+	"github.com/u-root/u-root/pkg/mount/ext4"
+	"github.com/u-root/u-root/pkg/mount/fat"
 	"github.com/u-root/u-root/pkg/ulog"
 )
 
@@ -27,8 +33,8 @@ func (a byRank) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byRank) Len() int           { return len(a) }
 
 // parse treats device as a block device with a file system.
-func parse(l ulog.Logger, device *block.BlockDev, devices block.BlockDevices, mountDir string, mountPool *mount.Pool) []boot.OSImage {
-	imgs, err := bls.ScanBLSEntries(l, mountDir)
+func parse(l ulog.Logger, device *block.BlockDev, devices block.BlockDevices, mountDir string, mountPool *mount.Pool, blsOpts *bls.Options) []boot.OSImage {
+	imgs, err := bls.ScanBLSEntries(l, mountDir, blsOpts)
 	if err != nil {
 		l.Printf("No systemd-boot BootLoaderSpec configs found on %s, trying another format...: %v", device, err)
 	}
@@ -51,6 +57,44 @@ func parse(l ulog.Logger, device *block.BlockDev, devices block.BlockDevices, mo
 	return imgs
 }
 
+// readFS reads the ext2/3/4 or FAT file system at path without mounting
+// it. The returned io.Closer closes the underlying file.
+func readFS(path string) (fs.FS, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fsys, err := ext4.New(f); err == nil {
+		return fsys, f, nil
+	}
+	if fsys, err := fat.New(f); err == nil {
+		return fsys, f, nil
+	}
+	f.Close()
+	return nil, nil, fmt.Errorf("%s: no ext2/3/4 or FAT file system", path)
+}
+
+// parseFS treats device as a block device with a file system the kernel
+// cannot mount, e.g. for lack of a driver, and reads it directly. Only
+// BootLoaderSpec entries are found this way. The images read their
+// kernels and initramfs from the device, so it stays open if any are
+// found.
+func parseFS(l ulog.Logger, device *block.BlockDev, blsOpts *bls.Options) []boot.OSImage {
+	fsys, c, err := readFS(device.DevicePath())
+	if err != nil {
+		l.Printf("Cannot read %s: %v", device, err)
+		return nil
+	}
+	imgs, err := bls.ScanBLSEntriesFS(l, fsys, blsOpts)
+	if err != nil {
+		l.Printf("No systemd-boot BootLoaderSpec configs found on %s: %v", device, err)
+	}
+	if len(imgs) == 0 {
+		c.Close()
+	}
+	return imgs
+}
+
 // parseUnmounted treats device as unmounted, with or without partitions.
 func parseUnmounted(l ulog.Logger, device *block.BlockDev, mountPool *mount.Pool) []boot.OSImage {
 	// This will try to mount device partition 5 and 6.
@@ -81,8 +125,9 @@ func parseUnmounted(l ulog.Logger, device *block.BlockDev, mountPool *mount.Pool
 	return images
 }
 
-// Localboot tries to boot from any local filesystem by parsing grub configuration
-func Localboot(l ulog.Logger, blockDevs block.BlockDevices, mp *mount.Pool) ([]boot.OSImage, error) {
+// Localboot tries to boot from any local filesystem by parsing grub configuration.
+// BootLoaderSpec entries are scanned with blsOpts, which may be nil.
+func Localboot(l ulog.Logger, blockDevs block.BlockDevices, mp *mount.Pool, blsOpts *bls.Options) ([]boot.OSImage, error) {
 	var images []boot.OSImage
 	for _, device := range blockDevs {
 		imgs := parseUnmounted(l, device, mp)
@@ -91,9 +136,10 @@ func Localboot(l ulog.Logger, blockDevs block.BlockDevices, mp *mount.Pool) ([]b
 		} else {
 			m, err := mp.Mount(device, mount.ReadOnly)
 			if err != nil {
+				images = append(images, parseFS(l, device, blsOpts)...)
 				continue
 			}
-			imgs = parse(l, device, blockDevs, m.Path, mp)
+			imgs = parse(l, device, blockDevs, m.Path, mp, blsOpts)
 			images = append(images, imgs...)
 		}
 	}
@@ -0,0 +1,49 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localboot
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFS(t *testing.T) {
+	dir := t.TempDir()
+
+	// An empty FAT12 file system of 64 sectors, with one reserved
+	// sector, two FATs of one sector and a root directory of one sector.
+	b := make([]byte, 64*512)
+	b[0] = 0xeb
+	binary.LittleEndian.PutUint16(b[11:], 512)
+	b[13] = 1
+	binary.LittleEndian.PutUint16(b[14:], 1)
+	b[16] = 2
+	binary.LittleEndian.PutUint16(b[17:], 16)
+	binary.LittleEndian.PutUint16(b[19:], 64)
+	binary.LittleEndian.PutUint16(b[22:], 1)
+	img := filepath.Join(dir, "fat.img")
+	if err := os.WriteFile(img, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys, c, err := readFS(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if entries, err := fs.ReadDir(fsys, "."); err != nil || len(entries) != 0 {
+		t.Errorf("root directory is %v, %v, want it empty", entries, err)
+	}
+
+	empty := filepath.Join(dir, "empty.img")
+	if err := os.WriteFile(empty, make([]byte, 64*512), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readFS(empty); err == nil {
+		t.Errorf("readFS(%q) succeeded without a file system", empty)
+	}
+}
@@ -3,7 +3,7 @@
 // license that can be found in the LICENSE file.
 
 // Package menu displays a Terminal UI based text menu to choose boot options
-// from.
+// from, or a graphical one on the framebuffer.
 package menu
 
 import (
@@ -199,7 +199,7 @@ func showMenuAndLoadFromFile(file *os.File, allowEdit bool, entries ...Entry) En
 	fmt.Printf("Welcome to LinuxBoot's Menu\n\n")
 	fmt.Printf("Enter a number to boot a kernel:\n")
 
-	for {
+	return loadChosen(os.Stdout, func() Entry {
 		t := NewTerminal(file)
 		// Allow the user to choose.
 		entry := Choose(t, allowEdit, entries...)
@@ -207,7 +207,15 @@ func showMenuAndLoadFromFile(file *os.File, allowEdit bool, entries ...Entry) En
 			log.Printf("Failed to close terminal made from file %s "+
 				"(desc %d): %v", file.Name(), file.Fd(), err)
 		}
+		return entry
+	}, entries...)
+}
 
+// loadChosen loads the entry returned by choose, until it returns nil, and
+// then the first default entry that loads. Messages are written to w.
+func loadChosen(w io.Writer, choose func() Entry, entries ...Entry) Entry {
+	for {
+		entry := choose()
 		if entry == nil {
 			// This only returns something if the user explicitly
 			// entered something.
@@ -226,7 +234,7 @@ func showMenuAndLoadFromFile(file *os.File, allowEdit bool, entries ...Entry) En
 		return entry
 	}
 
-	fmt.Println("")
+	fmt.Fprintln(w, "")
 
 	// We only get one shot at actually booting, so boot the first kernel
 	// that can be loaded correctly.
@@ -234,7 +242,7 @@ func showMenuAndLoadFromFile(file *os.File, allowEdit bool, entries ...Entry) En
 		// Only perform actions that are default actions. I.e. don't
 		// drop to shell.
 		if e.IsDefault() {
-			fmt.Printf("Attempting to boot %s.\n\n", ExtendedLabel(e))
+			fmt.Fprintf(w, "Attempting to boot %s.\n\n", ExtendedLabel(e))
 
 			if err := e.Load(); err != nil {
 				log.Printf("Failed to load %s: %v", e.Label(), err)
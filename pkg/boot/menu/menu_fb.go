@@ -0,0 +1,225 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package menu
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/u-root/u-root/pkg/evdev"
+	"github.com/u-root/u-root/pkg/fb"
+)
+
+var (
+	fbFg    = color.RGBA{0xd0, 0xd0, 0xd0, 0xff}
+	fbBg    = color.RGBA{0, 0, 0, 0xff}
+	fbTitle = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// fbLayout returns the font for a screen r, and splits it into the menu and
+// the console below it.
+func fbLayout(r image.Rectangle) (font *fb.Font, menu, console image.Rectangle) {
+	// At least 80x50 characters.
+	scale := r.Dx() / (80 * 8)
+	if s := r.Dy() / (50 * 8); s < scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	font = fb.Basic.Scaled(scale)
+
+	// The console takes the bottom quarter.
+	h := font.Size().Y
+	rows := r.Dy() / h
+	menu, console = r, r
+	menu.Max.Y = r.Min.Y + (rows-rows/4)*h
+	console.Min.Y = menu.Max.Y
+	return font, menu, console
+}
+
+// fbMenu is the menu drawn by ChooseFB.
+type fbMenu struct {
+	dst    draw.Image
+	font   *fb.Font
+	rect   image.Rectangle
+	list   *fb.List
+	status image.Point
+	bar    *fb.ProgressBar
+}
+
+func newFBMenu(dst draw.Image, entries []Entry) *fbMenu {
+	font, r, _ := fbLayout(dst.Bounds())
+	g := font.Size()
+	m := &fbMenu{
+		dst:  dst,
+		font: font,
+		rect: r,
+	}
+	// row returns the top left of the text in row i, with a margin of 2
+	// columns.
+	row := func(i int) image.Point {
+		return r.Min.Add(image.Pt(2*g.X, i*g.Y))
+	}
+
+	var items []string
+	for i, e := range entries {
+		items = append(items, fmt.Sprintf("%02d. %s", i+1, e.Label()))
+	}
+	// Title and help, a blank row, the list, a blank row, the status and
+	// the progress bar.
+	rows := r.Dy()/g.Y - 8
+	if rows < 1 {
+		rows = 1
+	}
+	m.list = &fb.List{
+		Rect:  image.Rectangle{row(4), row(4 + rows).Add(image.Pt(r.Dx()-4*g.X, 0))},
+		Font:  font,
+		Items: items,
+		Fg:    fbFg,
+		Bg:    fbBg,
+	}
+	m.status = row(5 + rows)
+	bar := row(6 + rows)
+	m.bar = &fb.ProgressBar{
+		Rect: image.Rectangle{bar, bar.Add(image.Pt(r.Dx()-4*g.X, g.Y/2))},
+		Fg:   fbFg,
+		Bg:   fbBg,
+	}
+
+	fb.Fill(dst, r, fbBg)
+	font.DrawString(dst, row(1), "Welcome to LinuxBoot's Menu", fbTitle, fbBg)
+	font.DrawString(dst, row(2), "Up/Down or number to select, Enter to boot, Esc to boot the default", fbFg, fbBg)
+	return m
+}
+
+// drawStatus draws the time left out of timeout to boot the default entries.
+func (m *fbMenu) drawStatus(left, timeout time.Duration) {
+	g := m.font.Size()
+	fb.Fill(m.dst, image.Rectangle{m.status, image.Pt(m.rect.Max.X, m.status.Y+g.Y)}, fbBg)
+	s := fmt.Sprintf("Booting the default in %ds", (left+time.Second-1)/time.Second)
+	m.font.DrawString(m.dst, m.status, s, fbFg, fbBg)
+	m.bar.Draw(m.dst, int(left/time.Millisecond), int(timeout/time.Millisecond))
+}
+
+// ChooseFB presents the user a graphical menu on dst to choose an entry from
+// with keys, and returns that entry.
+//
+// Like Choose, it returns nil when it times out, and when the user presses Esc
+// or keys is closed. The kernel command line cannot be edited.
+func ChooseFB(dst draw.Image, keys <-chan evdev.Key, entries ...Entry) Entry {
+	m := newFBMenu(dst, entries)
+	m.list.Draw(dst)
+
+	timeout := initialTimeout
+	deadline := time.Now().Add(timeout)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+
+	// num is the number being typed.
+	var num int
+	for {
+		left := time.Until(deadline)
+		if left < 0 {
+			left = 0
+		}
+		m.drawStatus(left, timeout)
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			// Reset the countdown timer when you press a key.
+			timeout = subsequentTimeout
+			deadline = time.Now().Add(timeout)
+
+			if d := k.Digit(); d >= 0 {
+				if num = 10*num + d; num > len(entries) {
+					num = d
+				}
+				if num > 0 {
+					m.list.Selected = num - 1
+				}
+				m.list.Draw(dst)
+				continue
+			}
+			num = 0
+			switch k {
+			case evdev.KeyUp:
+				m.list.Up()
+			case evdev.KeyDown:
+				m.list.Down()
+			case evdev.KeyHome, evdev.KeyPageUp:
+				m.list.Selected = 0
+			case evdev.KeyEnd, evdev.KeyPageDown:
+				m.list.Selected = len(entries) - 1
+			case evdev.KeyEnter, evdev.KeyKPEnter:
+				if len(entries) > 0 {
+					return entries[m.list.Selected]
+				}
+			case evdev.KeyEsc:
+				return nil
+			}
+			m.list.Draw(dst)
+		case <-tick.C:
+			if time.Until(deadline) <= 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// ShowFBMenuAndLoad is like ShowMenuAndLoad, but shows the menu on the
+// framebuffer device, e.g. /dev/fb0, with input from the keyboards of the
+// event devices, for machines without a serial console. The messages of
+// loading the entries are shown below the menu.
+//
+// Without a framebuffer or keyboard, it falls back to ShowMenuAndLoad, which
+// is the only one of the two allowing to edit the kernel command line.
+func ShowFBMenuAndLoad(device string, allowEdit bool, entries ...Entry) Entry {
+	paths, err := evdev.Keyboards()
+	if err != nil {
+		log.Printf("Failed to find keyboards: %v", err)
+	}
+	var devs []*evdev.Device
+	for _, p := range paths {
+		d, err := evdev.Open(p)
+		if err != nil {
+			log.Printf("Failed to open keyboard: %v", err)
+			continue
+		}
+		defer d.Close()
+		devs = append(devs, d)
+	}
+	if len(devs) == 0 {
+		log.Printf("No keyboard, falling back to the terminal menu")
+		return ShowMenuAndLoad(allowEdit, entries...)
+	}
+	screen, err := fb.Open(device)
+	if err != nil {
+		log.Printf("Failed to open framebuffer, falling back to the terminal menu: %v", err)
+		return ShowMenuAndLoad(allowEdit, entries...)
+	}
+	defer screen.Close()
+
+	font, _, r := fbLayout(screen.Bounds())
+	console := fb.NewConsole(screen, r, font)
+	console.Fg, console.Bg = fbFg, fbBg
+	console.Clear()
+	w := log.Writer()
+	log.SetOutput(io.MultiWriter(w, console))
+	defer log.SetOutput(w)
+
+	keys := evdev.Keys(devs...)
+	return loadChosen(io.MultiWriter(os.Stdout, console), func() Entry {
+		return ChooseFB(screen, keys, entries...)
+	}, entries...)
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package menu
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/evdev"
+	"github.com/u-root/u-root/pkg/fb"
+)
+
+func TestFBLayout(t *testing.T) {
+	for _, tt := range []struct {
+		screen  image.Rectangle
+		scale   int
+		menu    image.Rectangle
+		console image.Rectangle
+	}{
+		{image.Rect(0, 0, 640, 400), 1, image.Rect(0, 0, 640, 304), image.Rect(0, 304, 640, 400)},
+		{image.Rect(0, 0, 1920, 1080), 2, image.Rect(0, 0, 1920, 816), image.Rect(0, 816, 1920, 1080)},
+		{image.Rect(0, 0, 100, 20), 1, image.Rect(0, 0, 100, 16), image.Rect(0, 16, 100, 20)},
+	} {
+		font, menu, console := fbLayout(tt.screen)
+		if font.Scale != tt.scale || menu != tt.menu || console != tt.console {
+			t.Errorf("fbLayout(%v) = scale %d, %v, %v, want scale %d, %v, %v", tt.screen, font.Scale, menu, console, tt.scale, tt.menu, tt.console)
+		}
+	}
+}
+
+func TestChooseFB(t *testing.T) {
+	entry1 := &testEntry{label: "1"}
+	entry2 := &testEntry{label: "2"}
+	entry3 := &testEntry{label: "3"}
+	entries := []Entry{entry1, entry2, entry3}
+
+	for _, tt := range []struct {
+		name string
+		keys []evdev.Key
+		// closed is whether keys is closed after the keys.
+		closed bool
+		want   Entry
+	}{
+		{"enter", []evdev.Key{evdev.KeyEnter}, false, entry1},
+		{"down", []evdev.Key{evdev.KeyDown, evdev.KeyDown, evdev.KeyDown, evdev.KeyUp, evdev.KeyKPEnter}, false, entry2},
+		{"end", []evdev.Key{evdev.KeyEnd, evdev.KeyEnter}, false, entry3},
+		{"number", []evdev.Key{evdev.Key0, evdev.Key3, evdev.KeyEnter}, false, entry3},
+		{"number too large", []evdev.Key{evdev.Key3, evdev.Key2, evdev.KeyEnter}, false, entry2},
+		{"escape", []evdev.Key{evdev.KeyDown, evdev.KeyEsc}, false, nil},
+		{"closed", []evdev.Key{evdev.KeyDown}, true, nil},
+		{"timeout", nil, false, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			screen := fb.NewCanvas(640, 400, 4)
+			keys := make(chan evdev.Key)
+			go func() {
+				for _, k := range tt.keys {
+					keys <- k
+				}
+				if tt.closed {
+					close(keys)
+				}
+			}()
+			if got := ChooseFB(screen, keys, entries...); got != tt.want {
+				t.Errorf("ChooseFB() = %v, want %v", got, tt.want)
+			}
+			if bytes.Count(screen.Pix, []byte{0}) == len(screen.Pix) {
+				t.Errorf("ChooseFB() drew nothing")
+			}
+		})
+	}
+}
+
+func TestChooseFBDraw(t *testing.T) {
+	screen := fb.NewCanvas(640, 400, 4)
+	keys := make(chan evdev.Key)
+	close(keys)
+	ChooseFB(screen, keys, &testEntry{label: "Boot Linux"})
+
+	// The title is in row 1 and the entry in row 4.
+	for _, tt := range []struct {
+		row  int
+		text string
+	}{
+		{1, "Welcome to LinuxBoot's Menu"},
+		{4, "01. Boot Linux"},
+	} {
+		r := image.Rect(16, 8*tt.row, 16+8*len(tt.text), 8*tt.row+8)
+		want := fb.NewCanvas(r.Dx(), r.Dy(), 4)
+		fg, bg := fbFg, fbBg
+		switch tt.row {
+		case 1:
+			fg = fbTitle
+		case 4:
+			// The entry is selected.
+			fg, bg = bg, fg
+		}
+		fb.Basic.DrawString(want, image.Point{}, tt.text, fg, bg)
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				if got := screen.At(r.Min.X+x, r.Min.Y+y); got != want.At(x, y) {
+					t.Fatalf("row %d is not %q: pixel (%d, %d) is %v, want %v", tt.row, tt.text, x, y, got, want.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestLoadChosen(t *testing.T) {
+	entry1 := &testEntry{label: "1", isDefault: true, load: errors.New("load failed")}
+	entry2 := &testEntry{label: "2", isDefault: true}
+	var out bytes.Buffer
+	if got := loadChosen(&out, func() Entry { return nil }, entry1, entry2); got != entry2 {
+		t.Errorf("loadChosen() = %v, want %v", got, entry2)
+	}
+	if want := "Attempting to boot 2."; !strings.Contains(out.String(), want) {
+		t.Errorf("loadChosen() printed %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package uki parses unified kernel images, the Type #2 entries of the Boot
+// Loader Spec.
+//
+// A unified kernel image is an EFI stub, such as systemd-stub, with the
+// kernel, initramfs, command line and os-release of the OS in PE sections.
+// Rather than running the stub, which needs UEFI, the sections are booted
+// with kexec as a boot.LinuxImage. Other EFI applications, such as Windows
+// Boot Manager, cannot be booted this way.
+//
+// See https://uapi-group.org/specifications/specs/unified_kernel_image/.
+package uki
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/authenticode"
+	"github.com/u-root/u-root/pkg/boot"
+)
+
+// ErrNotUKI is returned by Parse for a PE image without a .linux section.
+var ErrNotUKI = errors.New("no .linux section, not a unified kernel image")
+
+// Image is a unified kernel image.
+type Image struct {
+	// Linux is the kernel, from the .linux section.
+	Linux []byte
+	// Initrd is the initramfs, from the .initrd section.
+	Initrd []byte
+	// Cmdline is the kernel command line, from the .cmdline section.
+	Cmdline string
+	// OSRelease is the os-release of the OS, from the .osrel section.
+	OSRelease map[string]string
+	// Uname is the kernel release, from the .uname section.
+	Uname string
+	// DTB is the device tree, from the .dtb section.
+	DTB []byte
+}
+
+// sectionData returns the contents of a section, without the padding to the
+// file alignment.
+func sectionData(s *pe.Section) ([]byte, error) {
+	b, err := s.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading section %s: %w", s.Name, err)
+	}
+	if s.VirtualSize != 0 && int(s.VirtualSize) < len(b) {
+		b = b[:s.VirtualSize]
+	}
+	return b, nil
+}
+
+func trim(b []byte) string {
+	return strings.TrimSpace(string(bytes.TrimRight(b, "\x00")))
+}
+
+// parseOSRelease parses an os-release file, which is a list of shell
+// variable assignments.
+func parseOSRelease(b []byte) map[string]string {
+	vals := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := kv[1]
+		if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+			v = v[1 : len(v)-1]
+		} else if u, err := strconv.Unquote(v); err == nil {
+			v = u
+		}
+		vals[kv[0]] = v
+	}
+	return vals
+}
+
+// Parse parses a unified kernel image. If db is not nil, the image must
+// have an Authenticode signature that chains to a certificate in it.
+func Parse(img []byte, db []*x509.Certificate) (*Image, error) {
+	f, err := pe.NewFile(bytes.NewReader(img))
+	if err != nil {
+		return nil, err
+	}
+	if f.Section(".linux") == nil {
+		return nil, ErrNotUKI
+	}
+
+	if db != nil {
+		if err := authenticode.Verify(img, db); err != nil {
+			return nil, err
+		}
+	}
+
+	u := &Image{}
+
+	var cmdline, osrel, uname []byte
+	sections := map[string]*[]byte{
+		".linux":   &u.Linux,
+		".initrd":  &u.Initrd,
+		".dtb":     &u.DTB,
+		".cmdline": &cmdline,
+		".osrel":   &osrel,
+		".uname":   &uname,
+	}
+	for _, s := range f.Sections {
+		if p, ok := sections[s.Name]; ok {
+			if *p, err = sectionData(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	u.Cmdline = trim(cmdline)
+	u.OSRelease = parseOSRelease(osrel)
+	u.Uname = trim(uname)
+	return u, nil
+}
+
+// Name returns the name of the OS from its os-release, and the version of
+// the kernel.
+func (u *Image) Name() string {
+	var name []string
+	for _, k := range []string{"PRETTY_NAME", "NAME", "ID"} {
+		if v := u.OSRelease[k]; v != "" {
+			name = append(name, v)
+			break
+		}
+	}
+	if u.Uname != "" {
+		name = append(name, u.Uname)
+	} else if v := u.OSRelease["VERSION_ID"]; v != "" {
+		name = append(name, v)
+	}
+	return strings.Join(name, " ")
+}
+
+// LinuxImage returns a boot.LinuxImage that boots the kernel and initramfs
+// of the image with its command line.
+func (u *Image) LinuxImage() (*boot.LinuxImage, error) {
+	// As in the bls package, a devicetree the kernel needs cannot be
+	// silently ignored.
+	if len(u.DTB) != 0 {
+		return nil, errors.New("devicetree sections are unsupported")
+	}
+	li := &boot.LinuxImage{
+		Name:    u.Name(),
+		Kernel:  bytes.NewReader(u.Linux),
+		Cmdline: u.Cmdline,
+	}
+	if len(u.Initrd) != 0 {
+		li.Initrd = bytes.NewReader(u.Initrd)
+	}
+	return li, nil
+}
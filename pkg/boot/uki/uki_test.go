@@ -0,0 +1,184 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uki
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/internal/authenticodetest"
+	"github.com/u-root/u-root/pkg/authenticode"
+)
+
+// testUKI returns a PE32+ image with the given sections, each padded to 512
+// bytes.
+func testUKI(sections [][2]string) []byte {
+	const (
+		align   = 0x200
+		headers = 2 * align
+	)
+	var b bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	b.Write(dos)
+	b.WriteString("PE\x00\x00")
+	binary.Write(&b, binary.LittleEndian, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     uint16(len(sections)),
+		SizeOfOptionalHeader: 240,
+	})
+	binary.Write(&b, binary.LittleEndian, pe.OptionalHeader64{
+		Magic:               0x20b,
+		FileAlignment:       align,
+		SectionAlignment:    0x1000,
+		SizeOfHeaders:       headers,
+		NumberOfRvaAndSizes: 16,
+	})
+	off := headers
+	var data []byte
+	for i, s := range sections {
+		size := (len(s[1]) + align - 1) &^ (align - 1)
+		h := pe.SectionHeader32{
+			VirtualSize:      uint32(len(s[1])),
+			VirtualAddress:   uint32(0x1000 * (i + 1)),
+			SizeOfRawData:    uint32(size),
+			PointerToRawData: uint32(off),
+		}
+		copy(h.Name[:], s[0])
+		binary.Write(&b, binary.LittleEndian, h)
+		data = append(data, s[1]...)
+		data = append(data, make([]byte, size-len(s[1]))...)
+		off += size
+	}
+	img := make([]byte, headers, off)
+	copy(img, b.Bytes())
+	return append(img, data...)
+}
+
+var sections = [][2]string{
+	{".osrel", "NAME=Fedora Linux\nVERSION_ID=36\n# a comment\nPRETTY_NAME=\"Fedora Linux 36 (Thirty Six)\"\nID='fedora'\n"},
+	{".cmdline", "root=/dev/sda1 ro\n\x00"},
+	{".uname", "5.17.5-300.fc36.x86_64"},
+	{".linux", "bzImage"},
+	{".initrd", "initramfs"},
+}
+
+func TestParse(t *testing.T) {
+	u, err := Parse(testUKI(sections), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Image{
+		Linux:   []byte("bzImage"),
+		Initrd:  []byte("initramfs"),
+		Cmdline: "root=/dev/sda1 ro",
+		OSRelease: map[string]string{
+			"NAME":        "Fedora Linux",
+			"VERSION_ID":  "36",
+			"PRETTY_NAME": "Fedora Linux 36 (Thirty Six)",
+			"ID":          "fedora",
+		},
+		Uname: "5.17.5-300.fc36.x86_64",
+	}
+	if !reflect.DeepEqual(u, want) {
+		t.Errorf("Parse() = %+v, want %+v", u, want)
+	}
+
+	li, err := u.LinuxImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Fedora Linux 36 (Thirty Six) 5.17.5-300.fc36.x86_64"; li.Name != want {
+		t.Errorf("Name = %q, want %q", li.Name, want)
+	}
+	if li.Cmdline != u.Cmdline {
+		t.Errorf("Cmdline = %q, want %q", li.Cmdline, u.Cmdline)
+	}
+	for _, f := range []struct {
+		r    io.ReaderAt
+		want string
+	}{{li.Kernel, "bzImage"}, {li.Initrd, "initramfs"}} {
+		b := make([]byte, len(f.want)+1)
+		if n, err := f.r.ReadAt(b, 0); n != len(f.want) || err != io.EOF || string(b[:n]) != f.want {
+			t.Errorf("ReadAt() = %q, %v, want %q, EOF", b[:n], err, f.want)
+		}
+	}
+
+	// A devicetree cannot be ignored.
+	u.DTB = []byte("dtb")
+	if _, err := u.LinuxImage(); err == nil {
+		t.Errorf("LinuxImage() with a devicetree succeeded")
+	}
+
+	if _, err := Parse(testUKI(sections[:3]), nil); !errors.Is(err, ErrNotUKI) {
+		t.Errorf("Parse() without .linux = %v, want %v", err, ErrNotUKI)
+	}
+	if _, err := Parse([]byte("not a PE image"), nil); err == nil {
+		t.Errorf("Parse() of text succeeded")
+	}
+}
+
+func TestParseSigned(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "db"},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := []*x509.Certificate{cert}
+
+	img := testUKI(sections)
+	if _, err := Parse(img, db); !errors.Is(err, authenticode.ErrNotSigned) {
+		t.Errorf("Parse(unsigned) = %v, want %v", err, authenticode.ErrNotSigned)
+	}
+	signed, err := authenticodetest.Sign(img, key, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := Parse(signed, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Cmdline != "root=/dev/sda1 ro" {
+		t.Errorf("Parse(signed) = %+v, want the command line of the image", u)
+	}
+	if _, err := Parse(signed, []*x509.Certificate{}); !errors.Is(err, authenticode.ErrUntrusted) {
+		t.Errorf("Parse() with an empty db = %v, want %v", err, authenticode.ErrUntrusted)
+	}
+
+	// The command line is signed too.
+	i := bytes.Index(signed, []byte("root="))
+	signed[i] = 'R'
+	if _, err := Parse(signed, db); err == nil {
+		t.Errorf("Parse() with a changed command line succeeded")
+	}
+}
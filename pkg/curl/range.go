@@ -0,0 +1,121 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package curl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RangeScheme is a FileScheme that can fetch a file starting at an offset,
+// e.g. to resume an interrupted download.
+type RangeScheme interface {
+	FileScheme
+
+	// FetchRange returns a reader for the contents of u starting at
+	// offset. If offset is the size of the file, the reader is empty.
+	FetchRange(ctx context.Context, u *url.URL, offset int64) (io.Reader, error)
+}
+
+// fetchRange fetches u from fs starting at offset. FileSchemes that are not
+// RangeSchemes fetch the whole file and skip to offset.
+func fetchRange(ctx context.Context, fs FileScheme, u *url.URL, offset int64) (io.Reader, error) {
+	if rs, ok := fs.(RangeScheme); ok {
+		return rs.FetchRange(ctx, u, offset)
+	}
+	r, err := fs.FetchWithoutCache(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		return nil, fmt.Errorf("skipping to offset %d: %w", offset, err)
+	}
+	return r, nil
+}
+
+// FetchRange fetches the file with the given `u` starting at offset, like
+// FetchWithoutCache.
+func (s Schemes) FetchRange(ctx context.Context, u *url.URL, offset int64) (FileWithoutCache, error) {
+	fg, ok := s[u.Scheme]
+	if !ok {
+		return nil, &URLError{URL: u, Err: ErrNoSuchScheme}
+	}
+	r, err := fetchRange(ctx, fg, u, offset)
+	if err != nil {
+		return nil, &URLError{URL: u, Err: err}
+	}
+	return &file{Reader: r, url: u}, nil
+}
+
+// FetchRange implements RangeScheme.FetchRange for retry wrapper.
+func (s *SchemeWithRetries) FetchRange(ctx context.Context, u *url.URL, offset int64) (io.Reader, error) {
+	var r io.Reader
+	err := s.retry(ctx, u, func() error {
+		var err error
+		r, err = fetchRange(ctx, s.Scheme, u, offset)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// FetchRange implements RangeScheme.FetchRange for HTTP using a Range
+// request. Servers that ignore Range are handled by skipping to offset.
+func (h HTTPClient) FetchRange(ctx context.Context, u *url.URL, offset int64) (io.Reader, error) {
+	if offset == 0 {
+		return httpFetch(ctx, h, u)
+	}
+	resp, err := h.do(ctx, u, http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}})
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("skipping to offset %d: %w", offset, err)
+		}
+		return resp.Body, nil
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// "bytes */size": offset is the size if we already have it all.
+		cr := resp.Header.Get("Content-Range")
+		if size, err := strconv.ParseInt(strings.TrimPrefix(cr, "bytes */"), 10, 64); err == nil && size == offset {
+			resp.Body.Close()
+			return strings.NewReader(""), nil
+		}
+		fallthrough
+
+	default:
+		resp.Body.Close()
+		return nil, &HTTPClientCodeError{err, resp.StatusCode}
+	}
+}
+
+// FetchRange implements RangeScheme.FetchRange for LocalFile.
+func (lfs LocalFileClient) FetchRange(_ context.Context, u *url.URL, offset int64) (io.Reader, error) {
+	f, err := os.Open(filepath.Clean(u.Path))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
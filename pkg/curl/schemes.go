@@ -8,6 +8,7 @@
 package curl
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -161,6 +162,15 @@ func (f file) String() string {
 	return f.url.String()
 }
 
+// Close closes the underlying reader if it is an io.Closer, such as an HTTP
+// response body.
+func (f file) Close() error {
+	if c, ok := f.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 // Fetch fetchs the file with the given `u`. `u.Scheme` is used to
 // select the FileScheme via `s`.
 //
@@ -299,8 +309,8 @@ type SchemeWithRetries struct {
 	BackOff backoff.BackOff
 }
 
-// Fetch implements FileScheme.Fetch for retry wrapper.
-func (s *SchemeWithRetries) Fetch(ctx context.Context, u *url.URL) (io.ReaderAt, error) {
+// retry calls fetch until it succeeds or s gives up.
+func (s *SchemeWithRetries) retry(ctx context.Context, u *url.URL, fetch func() error) error {
 	var err error
 	s.BackOff.Reset()
 	back := backoff.WithContext(s.BackOff, ctx)
@@ -309,50 +319,48 @@ func (s *SchemeWithRetries) Fetch(ctx context.Context, u *url.URL) (io.ReaderAt,
 			time.Sleep(d)
 		}
 
-		var r io.ReaderAt
 		// Note: err uses the scope outside the for loop.
-		r, err = s.Scheme.Fetch(ctx, u)
-		if err == nil {
-			return r, nil
+		if err = fetch(); err == nil {
+			return nil
 		}
 
 		log.Printf("Error: Getting %v: %v", u, err)
 		if s.DoRetry != nil && !s.DoRetry(u, err) {
-			return r, err
+			return err
 		}
 		log.Printf("Retrying %v", u)
 	}
 
 	log.Printf("Error: Too many retries to get file %v", u)
-	return nil, err
+	return err
+}
+
+// Fetch implements FileScheme.Fetch for retry wrapper.
+func (s *SchemeWithRetries) Fetch(ctx context.Context, u *url.URL) (io.ReaderAt, error) {
+	var r io.ReaderAt
+	err := s.retry(ctx, u, func() error {
+		var err error
+		r, err = s.Scheme.Fetch(ctx, u)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 // FetchWithoutCache implements FileScheme.FetchWithoutCache for retry wrapper.
 func (s *SchemeWithRetries) FetchWithoutCache(ctx context.Context, u *url.URL) (io.Reader, error) {
-	var err error
-	s.BackOff.Reset()
-	back := backoff.WithContext(s.BackOff, ctx)
-	for d := time.Duration(0); d != backoff.Stop; d = back.NextBackOff() {
-		if d > 0 {
-			time.Sleep(d)
-		}
-
-		var r io.Reader
-		// Note: err uses the scope outside the for loop.
+	var r io.Reader
+	err := s.retry(ctx, u, func() error {
+		var err error
 		r, err = s.Scheme.FetchWithoutCache(ctx, u)
-		if err == nil {
-			return r, nil
-		}
-
-		log.Printf("Error: Getting %v: %v", u, err)
-		if s.DoRetry != nil && !s.DoRetry(u, err) {
-			return r, err
-		}
-		log.Printf("Retrying %v", u)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	log.Printf("Error: Too many retries to get file %v", u)
-	return nil, err
+	return r, nil
 }
 
 // HTTPClientCodeError is returned by HTTPClient.Fetch when the server replies
@@ -375,6 +383,15 @@ func (h *HTTPClientCodeError) Unwrap() error {
 // HTTPClient implements FileScheme for HTTP files.
 type HTTPClient struct {
 	c *http.Client
+
+	// Header is added to every request.
+	Header http.Header
+
+	// Method is the request method. The default is GET.
+	Method string
+
+	// Body, if set, is sent with every request, e.g. for POST.
+	Body []byte
 }
 
 // NewHTTPClient returns a new HTTP FileScheme based on the given http.Client.
@@ -384,17 +401,36 @@ func NewHTTPClient(c *http.Client) *HTTPClient {
 	}
 }
 
-func httpFetch(ctx context.Context, c *http.Client, u *url.URL) (io.Reader, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+func (h HTTPClient) do(ctx context.Context, u *url.URL, header http.Header) (*http.Response, error) {
+	method := h.Method
+	if method == "" {
+		method = "GET"
+	}
+	var body io.Reader
+	if h.Body != nil {
+		body = bytes.NewReader(h.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.Do(req)
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return h.c.Do(req)
+}
+
+func httpFetch(ctx context.Context, h HTTPClient, u *url.URL) (io.Reader, error) {
+	resp, err := h.do(ctx, u, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode != 200 {
+		resp.Body.Close()
 		return nil, &HTTPClientCodeError{err, resp.StatusCode}
 	}
 	return resp.Body, nil
@@ -402,7 +438,7 @@ func httpFetch(ctx context.Context, c *http.Client, u *url.URL) (io.Reader, erro
 
 // Fetch implements FileScheme.Fetch for HTTP.
 func (h HTTPClient) Fetch(ctx context.Context, u *url.URL) (io.ReaderAt, error) {
-	r, err := httpFetch(ctx, h.c, u)
+	r, err := httpFetch(ctx, h, u)
 	if err != nil {
 		return nil, err
 	}
@@ -411,7 +447,7 @@ func (h HTTPClient) Fetch(ctx context.Context, u *url.URL) (io.ReaderAt, error)
 
 // FetchWithoutCache implements FileScheme.FetchWithoutCache for HTTP.
 func (h HTTPClient) FetchWithoutCache(ctx context.Context, u *url.URL) (io.Reader, error) {
-	return httpFetch(ctx, h.c, u)
+	return httpFetch(ctx, h, u)
 }
 
 // RetryOr returns a DoRetry function that returns true if any one of fn return
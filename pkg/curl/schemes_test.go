@@ -12,7 +12,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/u-root/u-root/pkg/uio"
@@ -354,3 +358,76 @@ func TestHttpFetches(t *testing.T) {
 		t.Errorf("got %s, want %s", got, c)
 	}
 }
+
+func TestFetchRange(t *testing.T) {
+	const c = "0123456789"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/range", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(c))
+	})
+	mux.HandleFunc("/norange", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, c)
+	})
+	mux.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("X-Test"), b)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte(c), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ms := NewMockScheme("fooftp")
+	ms.Add("192.168.0.1", "/f", c)
+	s := Schemes{
+		"http":   DefaultHTTPClient,
+		"file":   &LocalFileClient{},
+		"fooftp": ms,
+	}
+
+	for _, tt := range []struct {
+		url    string
+		offset int64
+		want   string
+	}{
+		{ts.URL + "/range", 0, c},
+		{ts.URL + "/range", 4, "456789"},
+		{ts.URL + "/range", 10, ""},
+		{ts.URL + "/norange", 4, "456789"},
+		{"file://" + filepath.Join(dir, "f"), 7, "789"},
+		{"fooftp://192.168.0.1/f", 3, "3456789"},
+	} {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := s.FetchRange(context.Background(), u, tt.offset)
+		if err != nil {
+			t.Errorf("FetchRange(%s, %d) = %v", u, tt.offset, err)
+			continue
+		}
+		if got, err := io.ReadAll(r); err != nil || string(got) != tt.want {
+			t.Errorf("FetchRange(%s, %d) = %q, %v, want %q", u, tt.offset, got, err, tt.want)
+		}
+	}
+
+	u, _ := url.Parse(ts.URL + "/range")
+	if _, err := s.FetchRange(context.Background(), u, 20); err == nil {
+		t.Errorf("FetchRange past the end = %v, want error", err)
+	}
+
+	h := NewHTTPClient(http.DefaultClient)
+	h.Method = "POST"
+	h.Header = http.Header{"X-Test": {"yes"}}
+	h.Body = []byte("data")
+	u, _ = url.Parse(ts.URL + "/post")
+	r, err := h.FetchWithoutCache(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "POST yes data" {
+		t.Errorf("POST = %q, want %q", got, "POST yes data")
+	}
+}
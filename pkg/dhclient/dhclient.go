@@ -23,6 +23,7 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/u-root/u-root/pkg/dns"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
@@ -73,8 +74,31 @@ func IfUp(ifname string, linkUpTimeout time.Duration) (netlink.Link, error) {
 	return nil, fmt.Errorf("link %q still down after %v seconds", ifname, linkUpTimeout.Seconds())
 }
 
+// DNSOptions are optional parameters for WriteDNSSettingsWithOptions.
+type DNSOptions struct {
+	// Stub, if set, is the address of a local caching stub resolver such
+	// as dnsd. The nameservers are then written to dns.UpstreamConf for
+	// the stub to forward to, and resolv.conf points at the stub.
+	Stub net.IP
+}
+
 // WriteDNSSettings writes the given nameservers, search list, and domain to resolv.conf.
 func WriteDNSSettings(ns []net.IP, sl []string, domain string) error {
+	return WriteDNSSettingsWithOptions(ns, sl, domain, DNSOptions{})
+}
+
+// WriteDNSSettingsWithOptions is WriteDNSSettings with DNSOptions.
+func WriteDNSSettingsWithOptions(ns []net.IP, sl []string, domain string, opts DNSOptions) error {
+	if opts.Stub == nil {
+		return writeResolvConf(dns.ResolvConf, ns, sl, domain)
+	}
+	if err := writeResolvConf(dns.UpstreamConf, ns, nil, ""); err != nil {
+		return err
+	}
+	return writeResolvConf(dns.ResolvConf, []net.IP{opts.Stub}, sl, domain)
+}
+
+func writeResolvConf(path string, ns []net.IP, sl []string, domain string) error {
 	rc := &bytes.Buffer{}
 	if domain != "" {
 		rc.WriteString(fmt.Sprintf("domain %s\n", domain))
@@ -87,7 +111,7 @@ func WriteDNSSettings(ns []net.IP, sl []string, domain string) error {
 		rc.WriteString(strings.Join(sl, " "))
 		rc.WriteString("\n")
 	}
-	return os.WriteFile("/etc/resolv.conf", rc.Bytes(), 0o644)
+	return os.WriteFile(path, rc.Bytes(), 0o644)
 }
 
 // Lease is a network configuration obtained by DHCP.
@@ -98,6 +122,10 @@ type Lease interface {
 	// configuration.
 	Configure() error
 
+	// ConfigureWithOptions is Configure with DNSOptions for writing the
+	// DNS servers.
+	ConfigureWithOptions(opts DNSOptions) error
+
 	// Boot is a URL to obtain booting information from that was part of
 	// the network config.
 	Boot() (*url.URL, error)
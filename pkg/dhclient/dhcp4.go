@@ -68,6 +68,12 @@ func Configure4(iface netlink.Link, packet *dhcpv4.DHCPv4) error {
 
 // Configure configures interface using this packet.
 func (p *Packet4) Configure() error {
+	return p.ConfigureWithOptions(DNSOptions{})
+}
+
+// ConfigureWithOptions configures interface using this packet, and writes
+// the DNS servers as described by opts.
+func (p *Packet4) ConfigureWithOptions(opts DNSOptions) error {
 	l := p.Lease()
 	if l == nil {
 		return fmt.Errorf("packet has no IP lease")
@@ -111,7 +117,7 @@ func (p *Packet4) Configure() error {
 	}
 
 	nameServers, searchList, domain := p.GatherDNSSettings()
-	if err := WriteDNSSettings(nameServers, searchList, domain); err != nil {
+	if err := WriteDNSSettingsWithOptions(nameServers, searchList, domain, opts); err != nil {
 		return err
 	}
 
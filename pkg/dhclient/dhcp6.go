@@ -48,6 +48,12 @@ func Configure6(iface netlink.Link, packet *dhcpv6.Message) error {
 
 // Configure configures interface using this packet.
 func (p *Packet6) Configure() error {
+	return p.ConfigureWithOptions(DNSOptions{})
+}
+
+// ConfigureWithOptions configures interface using this packet, and writes
+// the DNS servers as described by opts.
+func (p *Packet6) ConfigureWithOptions(opts DNSOptions) error {
 	l := p.Lease()
 	if l == nil {
 		return fmt.Errorf("no lease returned")
@@ -83,7 +89,7 @@ func (p *Packet6) Configure() error {
 	}
 
 	if ips := p.DNS(); ips != nil {
-		if err := WriteDNSSettings(ips, nil, ""); err != nil {
+		if err := WriteDNSSettingsWithOptions(ips, nil, "", opts); err != nil {
 			return err
 		}
 	}
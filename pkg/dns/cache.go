@@ -0,0 +1,189 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultCacheSize is the default number of cached responses.
+	DefaultCacheSize = 1024
+
+	// maxTTL caps how long anything is cached.
+	maxTTL = 24 * time.Hour
+
+	// negativeTTL is used for negative responses without SOA.
+	negativeTTL = 30 * time.Second
+)
+
+type cacheKey struct {
+	name  string
+	t     dnsmessage.Type
+	class dnsmessage.Class
+}
+
+type cacheEntry struct {
+	rcode       dnsmessage.RCode
+	answers     []dnsmessage.Resource
+	authorities []dnsmessage.Resource
+	stored      time.Time
+	expires     time.Time
+}
+
+// Cache caches DNS responses for their TTL. Negative responses are cached
+// for the SOA minimum, RFC 2308.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*cacheEntry
+	now     func() time.Time
+}
+
+// NewCache returns a cache of at most size responses.
+func NewCache(size int) *Cache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	return &Cache{
+		size:    size,
+		entries: make(map[cacheKey]*cacheEntry),
+		now:     time.Now,
+	}
+}
+
+func keyOf(q dnsmessage.Question) cacheKey {
+	return cacheKey{strings.ToLower(q.Name.String()), q.Type, q.Class}
+}
+
+// ttl returns how long r may be cached.
+func ttl(r *dnsmessage.Message) time.Duration {
+	if r.RCode == dnsmessage.RCodeNameError || len(r.Answers) == 0 {
+		for _, a := range r.Authorities {
+			if soa, ok := a.Body.(*dnsmessage.SOAResource); ok {
+				t := soa.MinTTL
+				if a.Header.TTL < t {
+					t = a.Header.TTL
+				}
+				return time.Duration(t) * time.Second
+			}
+		}
+		return negativeTTL
+	}
+	t := maxTTL
+	for _, a := range r.Answers {
+		if d := time.Duration(a.Header.TTL) * time.Second; d < t {
+			t = d
+		}
+	}
+	return t
+}
+
+// Put caches the response r to q. Only successful and NXDOMAIN responses
+// are cached.
+func (c *Cache) Put(q dnsmessage.Question, r *dnsmessage.Message) {
+	if r.RCode != dnsmessage.RCodeSuccess && r.RCode != dnsmessage.RCodeNameError {
+		return
+	}
+	if r.Truncated {
+		return
+	}
+	d := ttl(r)
+	if d <= 0 {
+		return
+	}
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		c.evict(now)
+	}
+	// Copy the records: packing r writes to their headers.
+	c.entries[keyOf(q)] = &cacheEntry{
+		rcode:       r.RCode,
+		answers:     append([]dnsmessage.Resource(nil), r.Answers...),
+		authorities: append([]dnsmessage.Resource(nil), r.Authorities...),
+		stored:      now,
+		expires:     now.Add(d),
+	}
+}
+
+// evict drops expired entries, or the entry closest to expiry if there are
+// none.
+func (c *Cache) evict(now time.Time) {
+	var (
+		oldest    cacheKey
+		oldestExp time.Time
+	)
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+			continue
+		}
+		if oldestExp.IsZero() || e.expires.Before(oldestExp) {
+			oldest, oldestExp = k, e.expires
+		}
+	}
+	if len(c.entries) >= c.size {
+		delete(c.entries, oldest)
+	}
+}
+
+// Get returns a cached response to q with TTLs reduced by the time spent
+// in the cache.
+func (c *Cache) Get(q dnsmessage.Question) (*dnsmessage.Message, bool) {
+	now := c.now()
+	k := keyOf(q)
+
+	c.mu.Lock()
+	e, ok := c.entries[k]
+	if ok && !now.Before(e.expires) {
+		delete(c.entries, k)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	age := uint32(now.Sub(e.stored) / time.Second)
+	return &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			Response:           true,
+			RecursionDesired:   true,
+			RecursionAvailable: true,
+			RCode:              e.rcode,
+		},
+		Questions:   []dnsmessage.Question{q},
+		Answers:     aged(e.answers, age),
+		Authorities: aged(e.authorities, age),
+	}, true
+}
+
+// aged returns a copy of rs with age subtracted from all TTLs.
+func aged(rs []dnsmessage.Resource, age uint32) []dnsmessage.Resource {
+	out := make([]dnsmessage.Resource, len(rs))
+	for i, r := range rs {
+		out[i] = r
+		if r.Header.TTL > age {
+			out[i].Header.TTL = r.Header.TTL - age
+		} else {
+			out[i].Header.TTL = 0
+		}
+	}
+	return out
+}
+
+// Len returns the number of cached responses.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
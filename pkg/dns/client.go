@@ -0,0 +1,216 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultTimeout is the timeout of a single exchange.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultPort is the DNS port.
+	DefaultPort = "53"
+
+	// udpSize is the EDNS0 UDP payload size we advertise.
+	udpSize = 1232
+)
+
+// Client sends DNS queries to a server.
+type Client struct {
+	// Timeout is the timeout of a single exchange. Defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// TCP always uses TCP. Otherwise, UDP is tried first and TCP is used
+	// if the response is truncated.
+	TCP bool
+
+	// NoRecursion clears the recursion desired bit.
+	NoRecursion bool
+
+	// Port is used for servers given without a port. Defaults to
+	// DefaultPort.
+	Port string
+}
+
+// DefaultClient is a Client with default settings.
+var DefaultClient = &Client{}
+
+// NewQuery returns a query message for name and type t with a random ID.
+func NewQuery(name string, t dnsmessage.Type, recursion bool) (*dnsmessage.Message, error) {
+	n, err := dnsmessage.NewName(Fqdn(name))
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %q: %v", name, err)
+	}
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(udpSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	return &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               binary.BigEndian.Uint16(id[:]),
+			RecursionDesired: recursion,
+		},
+		Questions: []dnsmessage.Question{{
+			Name:  n,
+			Type:  t,
+			Class: dnsmessage.ClassINET,
+		}},
+		Additionals: []dnsmessage.Resource{{
+			Header: opt,
+			Body:   &dnsmessage.OPTResource{},
+		}},
+	}, nil
+}
+
+func (c *Client) addr(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	port := c.Port
+	if port == "" {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
+
+// Query sends a query for name and type t to server.
+func (c *Client) Query(ctx context.Context, name string, t dnsmessage.Type, server string) (*dnsmessage.Message, error) {
+	q, err := NewQuery(name, t, !c.NoRecursion)
+	if err != nil {
+		return nil, err
+	}
+	return c.Exchange(ctx, q, server)
+}
+
+// Exchange sends q to server and returns the response. Server is an IP or
+// host name, optionally with a port.
+func (c *Client) Exchange(ctx context.Context, q *dnsmessage.Message, server string) (*dnsmessage.Message, error) {
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	addr := c.addr(server)
+	if !c.TCP {
+		r, err := c.exchange(ctx, "udp", addr, b, q)
+		if err != nil || !r.Truncated {
+			return r, err
+		}
+	}
+	return c.exchange(ctx, "tcp", addr, b, q)
+}
+
+var errMismatch = errors.New("response does not match query")
+
+func (c *Client) exchange(ctx context.Context, network, addr string, b []byte, q *dnsmessage.Message) (*dnsmessage.Message, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	if network == "tcp" {
+		return exchangeTCP(conn, b, q)
+	}
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		r, err := parseResponse(buf[:n], q)
+		// Ignore spoofed and late responses.
+		if err == errMismatch {
+			continue
+		}
+		return r, err
+	}
+}
+
+// exchangeTCP sends b with the 2 byte length prefix of RFC 1035, Section
+// 4.2.2.
+func exchangeTCP(conn net.Conn, b []byte, q *dnsmessage.Message) (*dnsmessage.Message, error) {
+	if err := writeTCP(conn, b); err != nil {
+		return nil, err
+	}
+	resp, err := readTCP(conn)
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(resp, q)
+}
+
+func writeTCP(w io.Writer, b []byte) error {
+	if len(b) > 0xffff {
+		return fmt.Errorf("message too large: %d bytes", len(b))
+	}
+	m := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(m, uint16(len(b)))
+	copy(m[2:], b)
+	_, err := w.Write(m)
+	return err
+}
+
+func readTCP(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func parseResponse(b []byte, q *dnsmessage.Message) (*dnsmessage.Message, error) {
+	var r dnsmessage.Message
+	if err := r.Unpack(b); err != nil {
+		return nil, err
+	}
+	if !r.Response || r.ID != q.ID {
+		return nil, errMismatch
+	}
+	// Truncated responses may lack the question.
+	if len(r.Questions) == 0 && r.Truncated {
+		return &r, nil
+	}
+	if len(r.Questions) != 1 || len(q.Questions) != 1 ||
+		!strings.EqualFold(r.Questions[0].Name.String(), q.Questions[0].Name.String()) ||
+		r.Questions[0].Type != q.Questions[0].Type {
+		return nil, errMismatch
+	}
+	return &r, nil
+}
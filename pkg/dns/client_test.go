@@ -0,0 +1,145 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func exampleZone(t *testing.T) *fakeServer {
+	return &fakeServer{
+		rcode: dnsmessage.RCodeNameError,
+		records: map[string][]dnsmessage.Resource{
+			"www.example.com. A": {
+				rr(t, "www.example.com.", 300, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}),
+			},
+			"www.example.com. AAAA": {
+				rr(t, "www.example.com.", 300, &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}),
+			},
+			"alias.example.com. CNAME": {
+				rr(t, "alias.example.com.", 300, &dnsmessage.CNAMEResource{CNAME: mustName(t, "www.example.com.")}),
+			},
+			"_http._tcp.example.com. SRV": {
+				rr(t, "_http._tcp.example.com.", 300, &dnsmessage.SRVResource{Priority: 10, Weight: 5, Port: 80, Target: mustName(t, "www.example.com.")}),
+			},
+			"example.com. TXT": {
+				rr(t, "example.com.", 300, &dnsmessage.TXTResource{TXT: []string{strings.Repeat("x", 250), strings.Repeat("y", 250), strings.Repeat("z", 250)}}),
+			},
+			"1.2.0.192.in-addr.arpa. PTR": {
+				rr(t, "1.2.0.192.in-addr.arpa.", 300, &dnsmessage.PTRResource{PTR: mustName(t, "www.example.com.")}),
+			},
+		},
+	}
+}
+
+func TestQuery(t *testing.T) {
+	f := exampleZone(t)
+	server := net.JoinHostPort("127.0.0.1", f.start(t, "127.0.0.1", ""))
+	c := &Client{Timeout: time.Second}
+
+	for _, tt := range []struct {
+		name  string
+		t     dnsmessage.Type
+		rcode dnsmessage.RCode
+		want  string
+	}{
+		{"www.example.com", dnsmessage.TypeA, dnsmessage.RCodeSuccess, "192.0.2.1"},
+		{"WWW.example.com.", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, "2001:db8::1"},
+		{"alias.example.com", dnsmessage.TypeCNAME, dnsmessage.RCodeSuccess, "www.example.com."},
+		{"_http._tcp.example.com", dnsmessage.TypeSRV, dnsmessage.RCodeSuccess, "10 5 80 www.example.com."},
+		{"1.2.0.192.in-addr.arpa", dnsmessage.TypePTR, dnsmessage.RCodeSuccess, "www.example.com."},
+		{"nope.example.com", dnsmessage.TypeA, dnsmessage.RCodeNameError, ""},
+	} {
+		r, err := c.Query(context.Background(), tt.name, tt.t, server)
+		if err != nil {
+			t.Errorf("Query(%s, %s) = %v", tt.name, TypeString(tt.t), err)
+			continue
+		}
+		if r.RCode != tt.rcode {
+			t.Errorf("Query(%s, %s) rcode = %s, want %s", tt.name, TypeString(tt.t), RCodeString(r.RCode), RCodeString(tt.rcode))
+		}
+		if tt.want == "" {
+			continue
+		}
+		if len(r.Answers) != 1 || FormatBody(r.Answers[0].Body) != tt.want {
+			t.Errorf("Query(%s, %s) = %v, want %s", tt.name, TypeString(tt.t), r.Answers, tt.want)
+		}
+	}
+}
+
+func TestQueryTCPFallback(t *testing.T) {
+	f := exampleZone(t)
+	f.truncateUDP = true
+	server := net.JoinHostPort("127.0.0.1", f.start(t, "127.0.0.1", ""))
+
+	r, err := (&Client{Timeout: time.Second}).Query(context.Background(), "example.com", dnsmessage.TypeTXT, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Truncated || len(r.Answers) != 1 {
+		t.Fatalf("response = %+v, want the full TXT record", r)
+	}
+	if txt := r.Answers[0].Body.(*dnsmessage.TXTResource).TXT; len(txt) != 3 {
+		t.Errorf("TXT = %d strings, want 3", len(txt))
+	}
+	if atomic.LoadInt32(&f.queries) != 2 {
+		t.Errorf("server got %d queries, want 2 (UDP, then TCP)", f.queries)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	// A root, a com. server and an example.com. server, all on the same
+	// port on different loopback addresses.
+	auth := exampleZone(t)
+	port := auth.start(t, "127.0.0.3", "")
+
+	com := &fakeServer{referral: &dnsmessage.Message{
+		Authorities: []dnsmessage.Resource{
+			rr(t, "example.com.", 300, &dnsmessage.NSResource{NS: mustName(t, "ns.example.com.")}),
+		},
+		Additionals: []dnsmessage.Resource{
+			rr(t, "ns.example.com.", 300, &dnsmessage.AResource{A: [4]byte{127, 0, 0, 3}}),
+		},
+	}}
+	com.start(t, "127.0.0.2", port)
+
+	// The root has no glue for a.gtld-servers.net., so it must be
+	// looked up separately.
+	root := &fakeServer{
+		records: map[string][]dnsmessage.Resource{
+			"a.gtld-servers.net. A": {rr(t, "a.gtld-servers.net.", 300, &dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}})},
+		},
+		referral: &dnsmessage.Message{
+			Authorities: []dnsmessage.Resource{
+				rr(t, "com.", 300, &dnsmessage.NSResource{NS: mustName(t, "a.gtld-servers.net.")}),
+			},
+		},
+	}
+	root.start(t, "127.0.0.1", port)
+
+	c := &Client{Timeout: time.Second, Port: port}
+	steps, err := c.Trace(context.Background(), "www.example.com", dnsmessage.TypeA, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var servers []string
+	for _, s := range steps {
+		servers = append(servers, s.Server)
+	}
+	if got, want := strings.Join(servers, " "), "127.0.0.1 127.0.0.2 127.0.0.3"; got != want {
+		t.Errorf("Trace servers = %q, want %q", got, want)
+	}
+	last := steps[len(steps)-1].Response
+	if len(last.Answers) != 1 || FormatBody(last.Answers[0].Body) != "192.0.2.1" {
+		t.Errorf("Trace answer = %v, want 192.0.2.1", last.Answers)
+	}
+}
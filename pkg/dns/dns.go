@@ -0,0 +1,144 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dns implements a small DNS client with TCP fallback, an
+// iterative resolver to trace delegations from the root, and a caching stub
+// resolver for use in the initramfs.
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var typeNames = map[dnsmessage.Type]string{
+	dnsmessage.TypeA:     "A",
+	dnsmessage.TypeNS:    "NS",
+	dnsmessage.TypeCNAME: "CNAME",
+	dnsmessage.TypeSOA:   "SOA",
+	dnsmessage.TypePTR:   "PTR",
+	dnsmessage.TypeMX:    "MX",
+	dnsmessage.TypeTXT:   "TXT",
+	dnsmessage.TypeAAAA:  "AAAA",
+	dnsmessage.TypeSRV:   "SRV",
+	dnsmessage.TypeOPT:   "OPT",
+	dnsmessage.TypeALL:   "ANY",
+}
+
+// TypeString returns the mnemonic of t, e.g. "AAAA", or "TYPEn" for
+// unknown types (RFC 3597).
+func TypeString(t dnsmessage.Type) string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// ParseType parses a mnemonic like "aaaa" or "TYPE28".
+func ParseType(s string) (dnsmessage.Type, error) {
+	u := strings.ToUpper(s)
+	for t, n := range typeNames {
+		if n == u {
+			return t, nil
+		}
+	}
+	if strings.HasPrefix(u, "TYPE") {
+		n, err := strconv.ParseUint(u[4:], 10, 16)
+		if err == nil {
+			return dnsmessage.Type(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+// Fqdn adds the trailing dot to name if it is missing.
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name for PTR lookups of
+// ip.
+func ReverseName(ip net.IP) (string, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0]), nil
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		var b strings.Builder
+		for i := len(ip16) - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "%x.%x.", ip16[i]&0xf, ip16[i]>>4)
+		}
+		b.WriteString("ip6.arpa.")
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("invalid IP %q", ip)
+}
+
+// FormatBody returns the presentation format of a record body, e.g.
+// "10 mail.example.com." for MX.
+func FormatBody(b dnsmessage.ResourceBody) string {
+	switch r := b.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(r.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(r.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return r.CNAME.String()
+	case *dnsmessage.NSResource:
+		return r.NS.String()
+	case *dnsmessage.PTRResource:
+		return r.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", r.Pref, r.MX)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", r.NS, r.MBox, r.Serial, r.Refresh, r.Retry, r.Expire, r.MinTTL)
+	case *dnsmessage.TXTResource:
+		q := make([]string, len(r.TXT))
+		for i, t := range r.TXT {
+			q[i] = strconv.Quote(t)
+		}
+		return strings.Join(q, " ")
+	case *dnsmessage.UnknownResource:
+		return fmt.Sprintf("\\# %d %x", len(r.Data), r.Data)
+	}
+	return fmt.Sprintf("%v", b)
+}
+
+// FormatResource returns r in zone file format:
+//
+//	example.com.	300	IN	A	192.0.2.1
+func FormatResource(r dnsmessage.Resource) string {
+	class := "IN"
+	if r.Header.Class != dnsmessage.ClassINET {
+		class = fmt.Sprintf("CLASS%d", r.Header.Class)
+	}
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", r.Header.Name, r.Header.TTL, class, TypeString(r.Header.Type), FormatBody(r.Body))
+}
+
+// RCodeString returns the dig-style name of an RCode, e.g. "NXDOMAIN".
+func RCodeString(r dnsmessage.RCode) string {
+	switch r {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return fmt.Sprintf("RCODE%d", r)
+}
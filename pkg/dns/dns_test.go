@@ -0,0 +1,220 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeServer is an in-process authoritative DNS server over UDP and TCP.
+type fakeServer struct {
+	// records maps "name type" to answers.
+	records map[string][]dnsmessage.Resource
+	// referral, if set, is returned for names not in records.
+	referral *dnsmessage.Message
+	// rcode is returned for names not in records without referral.
+	rcode dnsmessage.RCode
+	// truncateUDP truncates all UDP responses.
+	truncateUDP bool
+
+	queries int32
+	udp     net.PacketConn
+}
+
+func (f *fakeServer) answer(b []byte, udp bool) []byte {
+	atomic.AddInt32(&f.queries, 1)
+	var q dnsmessage.Message
+	if err := q.Unpack(b); err != nil || len(q.Questions) != 1 {
+		return nil
+	}
+	r := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:            q.ID,
+			Response:      true,
+			Authoritative: true,
+		},
+		Questions: q.Questions,
+	}
+	qq := q.Questions[0]
+	if rs, ok := f.records[strings.ToLower(qq.Name.String())+" "+TypeString(qq.Type)]; ok {
+		r.Answers = rs
+	} else if f.referral != nil {
+		r.Authoritative = false
+		r.Authorities = f.referral.Authorities
+		r.Additionals = f.referral.Additionals
+	} else {
+		r.RCode = f.rcode
+	}
+	if udp && f.truncateUDP {
+		r.Truncated = true
+		r.Answers = nil
+	}
+	out, err := r.Pack()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// start serves f on ip with the given port, 0 for any. It returns the
+// port.
+func (f *fakeServer) start(t *testing.T, ip string, port string) string {
+	t.Helper()
+	if port == "" {
+		port = "0"
+	}
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(ip, port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ = net.SplitHostPort(pc.LocalAddr().String())
+	l, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		l.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if out := f.answer(buf[:n], true); out != nil {
+				pc.WriteTo(out, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b, err := readTCP(conn)
+				if err != nil {
+					return
+				}
+				if out := f.answer(b, false); out != nil {
+					writeTCP(conn, out)
+				}
+			}()
+		}
+	}()
+	return port
+}
+
+func mustName(t *testing.T, s string) dnsmessage.Name {
+	t.Helper()
+	n, err := dnsmessage.NewName(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func rr(t *testing.T, name string, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	t.Helper()
+	var typ dnsmessage.Type
+	switch body.(type) {
+	case *dnsmessage.AResource:
+		typ = dnsmessage.TypeA
+	case *dnsmessage.AAAAResource:
+		typ = dnsmessage.TypeAAAA
+	case *dnsmessage.CNAMEResource:
+		typ = dnsmessage.TypeCNAME
+	case *dnsmessage.NSResource:
+		typ = dnsmessage.TypeNS
+	case *dnsmessage.PTRResource:
+		typ = dnsmessage.TypePTR
+	case *dnsmessage.SOAResource:
+		typ = dnsmessage.TypeSOA
+	case *dnsmessage.SRVResource:
+		typ = dnsmessage.TypeSRV
+	case *dnsmessage.TXTResource:
+		typ = dnsmessage.TypeTXT
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: mustName(t, name), Type: typ, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   body,
+	}
+}
+
+func TestTypes(t *testing.T) {
+	for _, s := range []string{"A", "aaaa", "SRV", "txt", "PTR", "CNAME", "ANY", "TYPE99"} {
+		typ, err := ParseType(s)
+		if err != nil {
+			t.Errorf("ParseType(%q) = %v", s, err)
+			continue
+		}
+		if got := TypeString(typ); got != strings.ToUpper(s) {
+			t.Errorf("TypeString(ParseType(%q)) = %q", s, got)
+		}
+	}
+	if _, err := ParseType("BOGUS"); err == nil {
+		t.Errorf("ParseType(BOGUS) succeeded")
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	for _, tt := range []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.1", "1.2.0.192.in-addr.arpa."},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	} {
+		got, err := ReverseName(net.ParseIP(tt.ip))
+		if err != nil || got != tt.want {
+			t.Errorf("ReverseName(%s) = %q, %v, want %q", tt.ip, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatResource(t *testing.T) {
+	for _, tt := range []struct {
+		r    dnsmessage.Resource
+		want string
+	}{
+		{rr(t, "a.example.", 60, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}), "a.example.\t60\tIN\tA\t192.0.2.1"},
+		{rr(t, "_x._tcp.example.", 5, &dnsmessage.SRVResource{Priority: 1, Weight: 2, Port: 3, Target: mustName(t, "t.example.")}), "_x._tcp.example.\t5\tIN\tSRV\t1 2 3 t.example."},
+		{rr(t, "example.", 5, &dnsmessage.TXTResource{TXT: []string{"a b", `c"`}}), "example.\t5\tIN\tTXT\t\"a b\" \"c\\\"\""},
+	} {
+		if got := FormatResource(tt.r); got != tt.want {
+			t.Errorf("FormatResource = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	c, err := ParseResolvConf(strings.NewReader(`# comment
+domain example.com
+nameserver 192.0.2.53 ; trailing
+nameserver 2001:db8::53
+search a.example b.example
+options ndots:2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Nameservers) != 2 || c.Nameservers[0] != "192.0.2.53" || c.Nameservers[1] != "2001:db8::53" {
+		t.Errorf("Nameservers = %v", c.Nameservers)
+	}
+	if c.Domain != "example.com" || len(c.Search) != 2 || c.Search[1] != "b.example" {
+		t.Errorf("Domain, Search = %q, %v", c.Domain, c.Search)
+	}
+}
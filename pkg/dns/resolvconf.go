@@ -0,0 +1,66 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// ResolvConf is the system resolver configuration.
+	ResolvConf = "/etc/resolv.conf"
+
+	// StubAddr is where the stub resolver listens by default.
+	StubAddr = "127.0.0.53"
+
+	// UpstreamConf is a resolv.conf listing the servers the stub
+	// resolver forwards to, while ResolvConf points at the stub.
+	UpstreamConf = "/etc/resolv.conf.upstream"
+)
+
+// Config is the part of resolv.conf that matters to us.
+type Config struct {
+	Nameservers []string
+	Search      []string
+	Domain      string
+}
+
+// ParseResolvConf parses resolv.conf(5) syntax.
+func ParseResolvConf(r io.Reader) (*Config, error) {
+	var c Config
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		l := s.Text()
+		if i := strings.IndexAny(l, "#;"); i >= 0 {
+			l = l[:i]
+		}
+		f := strings.Fields(l)
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "nameserver":
+			c.Nameservers = append(c.Nameservers, f[1])
+		case "search":
+			c.Search = f[1:]
+		case "domain":
+			c.Domain = f[1]
+		}
+	}
+	return &c, s.Err()
+}
+
+// ReadResolvConf parses the resolv.conf file at path.
+func ReadResolvConf(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseResolvConf(f)
+}
@@ -0,0 +1,249 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxUDPSize is the largest UDP response to clients without EDNS0.
+const maxUDPSize = 512
+
+// Server is a caching stub resolver. It answers recursive queries from its
+// cache or by forwarding them to its upstream servers in order.
+type Server struct {
+	// Client forwards queries. Defaults to DefaultClient.
+	Client *Client
+	// Cache caches upstream responses. If nil, nothing is cached.
+	Cache *Cache
+	// Logf, if set, logs errors.
+	Logf func(string, ...interface{})
+
+	mu        sync.RWMutex
+	upstreams []string
+}
+
+// NewServer returns a stub resolver forwarding to upstreams with a
+// default size cache.
+func NewServer(upstreams []string) *Server {
+	return &Server{
+		Cache:     NewCache(DefaultCacheSize),
+		upstreams: upstreams,
+	}
+}
+
+// SetUpstreams replaces the upstream servers.
+func (s *Server) SetUpstreams(upstreams []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upstreams = upstreams
+}
+
+// Upstreams returns the upstream servers.
+func (s *Server) Upstreams() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.upstreams...)
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, v...)
+	}
+}
+
+var errNoUpstreams = errors.New("no upstream servers")
+
+// Resolve answers q from the cache or the upstream servers.
+func (s *Server) Resolve(ctx context.Context, q dnsmessage.Question) (*dnsmessage.Message, error) {
+	if s.Cache != nil {
+		if r, ok := s.Cache.Get(q); ok {
+			return r, nil
+		}
+	}
+
+	c := s.Client
+	if c == nil {
+		c = DefaultClient
+	}
+	query, err := NewQuery(q.Name.String(), q.Type, true)
+	if err != nil {
+		return nil, err
+	}
+	query.Questions[0].Class = q.Class
+
+	lastErr := errNoUpstreams
+	for _, u := range s.Upstreams() {
+		r, err := c.Exchange(ctx, query, u)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", u, err)
+			continue
+		}
+		// Another server may do better.
+		if r.RCode == dnsmessage.RCodeServerFailure || r.RCode == dnsmessage.RCodeRefused {
+			lastErr = fmt.Errorf("%s: %s", u, RCodeString(r.RCode))
+			continue
+		}
+		if s.Cache != nil {
+			s.Cache.Put(q, r)
+		}
+		return r, nil
+	}
+	return nil, lastErr
+}
+
+// handle computes the response to the query b. UDP responses larger than
+// the client's EDNS0 payload size are truncated.
+func (s *Server) handle(ctx context.Context, b []byte, udp bool) ([]byte, error) {
+	var q dnsmessage.Message
+	if err := q.Unpack(b); err != nil {
+		return nil, err
+	}
+	if q.Response {
+		return nil, errors.New("ignoring response")
+	}
+
+	reply := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 q.ID,
+			Response:           true,
+			OpCode:             q.OpCode,
+			RecursionDesired:   q.RecursionDesired,
+			RecursionAvailable: true,
+		},
+		Questions: q.Questions,
+	}
+	switch {
+	case q.OpCode != 0:
+		reply.RCode = dnsmessage.RCodeNotImplemented
+	case len(q.Questions) != 1:
+		reply.RCode = dnsmessage.RCodeFormatError
+	default:
+		r, err := s.Resolve(ctx, q.Questions[0])
+		if err != nil {
+			s.logf("dns: resolving %v %s: %v", q.Questions[0].Name, TypeString(q.Questions[0].Type), err)
+			reply.RCode = dnsmessage.RCodeServerFailure
+			break
+		}
+		reply.RCode = r.RCode
+		reply.Authoritative = false
+		reply.Answers = r.Answers
+		reply.Authorities = r.Authorities
+		for _, a := range r.Additionals {
+			if a.Header.Type != dnsmessage.TypeOPT {
+				reply.Additionals = append(reply.Additionals, a)
+			}
+		}
+	}
+
+	out, err := reply.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if !udp {
+		return out, nil
+	}
+
+	size := maxUDPSize
+	for _, a := range q.Additionals {
+		if a.Header.Type == dnsmessage.TypeOPT && int(a.Header.Class) > size {
+			size = int(a.Header.Class)
+		}
+	}
+	if len(out) > size {
+		reply.Truncated = true
+		reply.Answers, reply.Authorities, reply.Additionals = nil, nil, nil
+		return reply.Pack()
+	}
+	return out, nil
+}
+
+// ServeUDP answers queries on pc until it is closed.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		b := append([]byte(nil), buf[:n]...)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*DefaultTimeout)
+			defer cancel()
+			out, err := s.handle(ctx, b, true)
+			if err != nil {
+				s.logf("dns: query from %v: %v", addr, err)
+				return
+			}
+			if _, err := pc.WriteTo(out, addr); err != nil {
+				s.logf("dns: answering %v: %v", addr, err)
+			}
+		}()
+	}
+}
+
+// tcpIdleTimeout closes idle TCP connections, RFC 7766, Section 6.2.3.
+const tcpIdleTimeout = 10 * time.Second
+
+// ServeTCP answers queries on connections accepted from l until it is
+// closed.
+func (s *Server) ServeTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		b, err := readTCP(conn)
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*DefaultTimeout)
+		out, err := s.handle(ctx, b, false)
+		cancel()
+		if err != nil {
+			s.logf("dns: query from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		if err := writeTCP(conn, out); err != nil {
+			return
+		}
+	}
+}
+
+// ListenAndServe serves DNS over UDP and TCP on addr, e.g.
+// "127.0.0.53:53".
+func (s *Server) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.ServeUDP(pc) }()
+	go func() { errs <- s.ServeTCP(l) }()
+	return <-errs
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestServer(t *testing.T) {
+	broken := &fakeServer{rcode: dnsmessage.RCodeServerFailure}
+	brokenAddr := net.JoinHostPort("127.0.0.1", broken.start(t, "127.0.0.1", ""))
+	good := exampleZone(t)
+	goodAddr := net.JoinHostPort("127.0.0.1", good.start(t, "127.0.0.1", ""))
+
+	s := NewServer([]string{brokenAddr, goodAddr})
+	s.Client = &Client{Timeout: time.Second}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.ServeUDP(pc)
+	go s.ServeTCP(l)
+	stub := pc.LocalAddr().String()
+
+	c := &Client{Timeout: time.Second}
+	for i := 0; i < 2; i++ {
+		r, err := c.Query(context.Background(), "www.example.com", dnsmessage.TypeA, stub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Answers) != 1 || FormatBody(r.Answers[0].Body) != "192.0.2.1" {
+			t.Errorf("query %d = %v, want 192.0.2.1", i, r.Answers)
+		}
+	}
+	if atomic.LoadInt32(&good.queries) != 1 {
+		t.Errorf("upstream got %d queries, want 1 (then cached)", good.queries)
+	}
+
+	r, err := c.Query(context.Background(), "nope.example.com", dnsmessage.TypeA, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("rcode = %s, want NXDOMAIN", RCodeString(r.RCode))
+	}
+
+	// The TXT record does not fit in 512 bytes, so the client falls back
+	// to TCP.
+	noEDNS := &dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 1, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: mustName(t, "example.com."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+	}
+	r, err = c.Exchange(context.Background(), noEDNS, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Truncated || len(r.Answers) != 1 {
+		t.Errorf("TXT response = %+v, want full answer", r.Header)
+	}
+
+	s.SetUpstreams([]string{brokenAddr})
+	r, err = c.Query(context.Background(), "new.example.com", dnsmessage.TypeA, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RCode != dnsmessage.RCodeServerFailure {
+		t.Errorf("rcode with broken upstream = %s, want SERVFAIL", RCodeString(r.RCode))
+	}
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewCache(2)
+	c.now = func() time.Time { return now }
+
+	q := func(name string) dnsmessage.Question {
+		return dnsmessage.Question{Name: mustName(t, name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	}
+	a := &dnsmessage.Message{
+		Answers: []dnsmessage.Resource{
+			rr(t, "a.example.", 60, &dnsmessage.AResource{A: [4]byte{1, 2, 3, 4}}),
+			rr(t, "a.example.", 30, &dnsmessage.AResource{A: [4]byte{1, 2, 3, 5}}),
+		},
+	}
+	nx := &dnsmessage.Message{
+		Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError},
+		Authorities: []dnsmessage.Resource{
+			rr(t, "example.", 3600, &dnsmessage.SOAResource{NS: mustName(t, "ns.example."), MBox: mustName(t, "h.example."), MinTTL: 10}),
+		},
+	}
+	c.Put(q("a.example."), a)
+	c.Put(q("nx.example."), nx)
+	c.Put(q("servfail.example."), &dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}})
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+
+	now = now.Add(5 * time.Second)
+	r, ok := c.Get(q("A.example."))
+	if !ok {
+		t.Fatal("Get(a) missed")
+	}
+	if r.Answers[0].Header.TTL != 55 || r.Answers[1].Header.TTL != 25 {
+		t.Errorf("TTLs = %d, %d, want 55, 25", r.Answers[0].Header.TTL, r.Answers[1].Header.TTL)
+	}
+	if r, ok := c.Get(q("nx.example.")); !ok || r.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("Get(nx) = %v, %v, want NXDOMAIN", r, ok)
+	}
+
+	// The negative entry expires after the SOA minimum, the positive one
+	// after the lowest TTL.
+	now = now.Add(10 * time.Second)
+	if _, ok := c.Get(q("nx.example.")); ok {
+		t.Errorf("Get(nx) hit after SOA minimum")
+	}
+	if _, ok := c.Get(q("a.example.")); !ok {
+		t.Errorf("Get(a) missed before TTL")
+	}
+	now = now.Add(30 * time.Second)
+	if _, ok := c.Get(q("a.example.")); ok {
+		t.Errorf("Get(a) hit after TTL")
+	}
+
+	// Full caches evict.
+	for _, n := range []string{"1.example.", "2.example.", "3.example."} {
+		c.Put(q(n), a)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+}
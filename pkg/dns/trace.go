@@ -0,0 +1,144 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RootServers are the IPv4 addresses of the root name servers a to m.
+var RootServers = []string{
+	"198.41.0.4",
+	"199.9.14.201",
+	"192.33.4.12",
+	"199.7.91.13",
+	"192.203.230.10",
+	"192.5.5.241",
+	"192.112.36.4",
+	"198.97.190.53",
+	"192.36.148.17",
+	"192.58.128.30",
+	"193.0.14.129",
+	"199.7.83.42",
+	"202.12.27.33",
+}
+
+const (
+	// maxReferrals bounds the length of a delegation chain.
+	maxReferrals = 16
+	// maxGlueDepth bounds recursive lookups of name servers without glue.
+	maxGlueDepth = 4
+)
+
+// Step is one server's response while tracing a name.
+type Step struct {
+	// Server is the address the query was sent to.
+	Server string
+	// Response is the server's answer or referral.
+	Response *dnsmessage.Message
+}
+
+// Trace resolves name iteratively, starting at roots, following referrals
+// without asking for recursion, like dig +trace. It returns every response
+// along the way; the last is the final answer.
+func (c *Client) Trace(ctx context.Context, name string, t dnsmessage.Type, roots []string) ([]Step, error) {
+	return c.trace(ctx, name, t, roots, 0)
+}
+
+func (c *Client) trace(ctx context.Context, name string, t dnsmessage.Type, roots []string, depth int) ([]Step, error) {
+	if depth > maxGlueDepth {
+		return nil, errors.New("too many nested name server lookups")
+	}
+	nc := *c
+	nc.NoRecursion = true
+
+	var steps []Step
+	servers := roots
+	for i := 0; i < maxReferrals; i++ {
+		var (
+			r       *dnsmessage.Message
+			server  string
+			lastErr error = errors.New("no servers to ask")
+		)
+		for _, s := range servers {
+			var err error
+			if r, err = nc.Query(ctx, name, t, s); err == nil {
+				server = s
+				break
+			}
+			lastErr = fmt.Errorf("%s: %w", s, err)
+			r = nil
+		}
+		if r == nil {
+			return steps, lastErr
+		}
+		steps = append(steps, Step{Server: server, Response: r})
+
+		if r.RCode != dnsmessage.RCodeSuccess || len(r.Answers) > 0 || r.Authoritative {
+			return steps, nil
+		}
+
+		next, err := nc.referral(ctx, r, roots, depth)
+		if err != nil {
+			return steps, err
+		}
+		servers = next
+	}
+	return steps, errors.New("too many referrals")
+}
+
+// referral returns the addresses of the name servers r delegates to.
+func (c *Client) referral(ctx context.Context, r *dnsmessage.Message, roots []string, depth int) ([]string, error) {
+	var names []string
+	for _, a := range r.Authorities {
+		if ns, ok := a.Body.(*dnsmessage.NSResource); ok {
+			names = append(names, strings.ToLower(ns.NS.String()))
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("response is neither an answer nor a referral")
+	}
+
+	var addrs []string
+	for _, a := range r.Additionals {
+		for _, n := range names {
+			if !strings.EqualFold(a.Header.Name.String(), n) {
+				continue
+			}
+			switch b := a.Body.(type) {
+			case *dnsmessage.AResource:
+				addrs = append(addrs, net.IP(b.A[:]).String())
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, net.IP(b.AAAA[:]).String())
+			}
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	// No glue, look up the name servers' addresses from the root.
+	for _, n := range names {
+		steps, err := c.trace(ctx, n, dnsmessage.TypeA, roots, depth+1)
+		if err != nil || len(steps) == 0 {
+			continue
+		}
+		for _, a := range steps[len(steps)-1].Response.Answers {
+			if b, ok := a.Body.(*dnsmessage.AResource); ok {
+				addrs = append(addrs, net.IP(b.A[:]).String())
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot find addresses of name servers %v", names)
+}
@@ -0,0 +1,241 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package evdev reads input events, such as key presses, from the Linux
+// event devices /dev/input/event*.
+package evdev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Event types, from include/uapi/linux/input-event-codes.h.
+const (
+	EvSyn = 0x00
+	EvKey = 0x01
+)
+
+// Values of EvKey events.
+const (
+	Released = 0
+	Pressed  = 1
+	Repeated = 2
+)
+
+// Key is the code of a key of EvKey events.
+type Key uint16
+
+// Key codes, from include/uapi/linux/input-event-codes.h.
+const (
+	KeyEsc       Key = 1
+	Key1         Key = 2
+	Key2         Key = 3
+	Key3         Key = 4
+	Key4         Key = 5
+	Key5         Key = 6
+	Key6         Key = 7
+	Key7         Key = 8
+	Key8         Key = 9
+	Key9         Key = 10
+	Key0         Key = 11
+	KeyBackspace Key = 14
+	KeyTab       Key = 15
+	KeyE         Key = 18
+	KeyEnter     Key = 28
+	KeySpace     Key = 57
+	KeyKPEnter   Key = 96
+	KeyHome      Key = 102
+	KeyUp        Key = 103
+	KeyPageUp    Key = 104
+	KeyLeft      Key = 105
+	KeyRight     Key = 106
+	KeyEnd       Key = 107
+	KeyDown      Key = 108
+	KeyPageDown  Key = 109
+)
+
+// Digit returns the digit of a number key, or -1.
+func (k Key) Digit() int {
+	switch {
+	case k == Key0:
+		return 0
+	case k >= Key1 && k <= Key9:
+		return int(k-Key1) + 1
+	}
+	return -1
+}
+
+// inputEvent is struct input_event.
+type inputEvent struct {
+	Time  unix.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// eventSize is the size of struct input_event.
+const eventSize = int(unsafe.Sizeof(inputEvent{}))
+
+// Event is an input event.
+type Event struct {
+	Time time.Time
+	// Type is the type of the event, such as EvKey.
+	Type uint16
+	// Code is the code of the event, such as the Key of EvKey events.
+	Code uint16
+	// Value is the value of the event, such as Pressed for EvKey events.
+	Value int32
+}
+
+// Device is an event device.
+type Device struct {
+	f *os.File
+}
+
+// Open opens the event device at path, e.g. /dev/input/event0.
+func Open(path string) (*Device, error) {
+	// The file is non-blocking, so Close interrupts ReadEvent.
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &Device{f: os.NewFile(uintptr(fd), path)}, nil
+}
+
+// Close closes the device.
+func (d *Device) Close() error {
+	return d.f.Close()
+}
+
+// SetDeadline sets the deadline of ReadEvent and ReadKey.
+func (d *Device) SetDeadline(t time.Time) error {
+	return d.f.SetDeadline(t)
+}
+
+// ReadEvent waits for the next event.
+func (d *Device) ReadEvent() (*Event, error) {
+	var ev inputEvent
+	b := (*[eventSize]byte)(unsafe.Pointer(&ev))[:]
+	n, err := d.f.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != eventSize {
+		return nil, fmt.Errorf("evdev: short event of %d bytes", n)
+	}
+	return &Event{
+		Time:  time.Unix(ev.Time.Unix()),
+		Type:  ev.Type,
+		Code:  ev.Code,
+		Value: ev.Value,
+	}, nil
+}
+
+// ReadKey waits for the next key to be pressed or repeated, and skips all
+// other events.
+func (d *Device) ReadKey() (Key, error) {
+	for {
+		ev, err := d.ReadEvent()
+		if err != nil {
+			return 0, err
+		}
+		if ev.Type == EvKey && ev.Value != Released {
+			return Key(ev.Code), nil
+		}
+	}
+}
+
+// Keys returns a channel of the keys pressed on any of devs. It is closed
+// when reading fails on all of them, such as after closing them.
+func Keys(devs ...*Device) <-chan Key {
+	keys := make(chan Key)
+	var wg sync.WaitGroup
+	for _, d := range devs {
+		wg.Add(1)
+		go func(d *Device) {
+			defer wg.Done()
+			for {
+				k, err := d.ReadKey()
+				if err != nil {
+					return
+				}
+				keys <- k
+			}
+		}(d)
+	}
+	go func() {
+		wg.Wait()
+		close(keys)
+	}()
+	return keys
+}
+
+// devices is the list of input devices of the kernel.
+var devices = "/proc/bus/input/devices"
+
+// Keyboards returns the paths of the event devices of keyboards, the input
+// devices with an Enter key.
+func Keyboards() ([]string, error) {
+	f, err := os.Open(devices)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseKeyboards(f)
+}
+
+// parseKeyboards parses the input devices in the format of
+// /proc/bus/input/devices, and returns the event devices of the ones with
+// an Enter key.
+func parseKeyboards(r io.Reader) ([]string, error) {
+	var paths []string
+	var event string
+	var enter bool
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+			// The end of a device.
+			if event != "" && enter {
+				paths = append(paths, filepath.Join("/dev/input", event))
+			}
+			event, enter = "", false
+		case strings.HasPrefix(line, "H: Handlers="):
+			for _, h := range strings.Fields(strings.TrimPrefix(line, "H: Handlers=")) {
+				if strings.HasPrefix(h, "event") {
+					event = h
+				}
+			}
+		case strings.HasPrefix(line, "B: KEY="):
+			// The bitmap of keys is in hexadecimal words of the size
+			// of a long, most significant first. The Enter key is in
+			// the least significant word whatever its size.
+			words := strings.Fields(strings.TrimPrefix(line, "B: KEY="))
+			if len(words) == 0 {
+				continue
+			}
+			w, err := strconv.ParseUint(words[len(words)-1], 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("evdev: parsing %q: %v", line, err)
+			}
+			enter = w&(1<<KeyEnter) != 0
+		}
+	}
+	if event != "" && enter {
+		paths = append(paths, filepath.Join("/dev/input", event))
+	}
+	return paths, s.Err()
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package evdev
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStructSizes(t *testing.T) {
+	// struct timeval and 8 bytes.
+	if want := int(unsafe.Sizeof(unix.Timeval{})) + 8; eventSize != want {
+		t.Errorf("struct input_event has size %d, want %d", eventSize, want)
+	}
+}
+
+// testDevice returns a device reading the events written to w.
+func testDevice(t *testing.T) (*Device, func(...inputEvent)) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.Close()
+		w.Close()
+	})
+	write := func(evs ...inputEvent) {
+		for _, ev := range evs {
+			if _, err := w.Write((*[eventSize]byte)(unsafe.Pointer(&ev))[:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return &Device{f: r}, write
+}
+
+func key(k Key, value int32) inputEvent {
+	return inputEvent{Time: unix.NsecToTimeval(int64(time.Second)), Type: EvKey, Code: uint16(k), Value: value}
+}
+
+func TestReadEvent(t *testing.T) {
+	d, write := testDevice(t)
+	write(key(KeyEnter, Pressed))
+	ev, err := d.ReadEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Event{Time: time.Unix(1, 0), Type: EvKey, Code: uint16(KeyEnter), Value: Pressed}
+	if !reflect.DeepEqual(ev, want) {
+		t.Errorf("ReadEvent() = %+v, want %+v", ev, want)
+	}
+
+	syn := inputEvent{Type: EvSyn}
+	write(syn, key(KeyUp, Pressed), syn, key(KeyUp, Released), syn, key(KeyDown, Repeated))
+	for _, want := range []Key{KeyUp, KeyDown} {
+		if k, err := d.ReadKey(); k != want || err != nil {
+			t.Errorf("ReadKey() = %d, %v, want %d", k, err, want)
+		}
+	}
+
+	if err := d.SetDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadKey(); !os.IsTimeout(err) {
+		t.Errorf("ReadKey() without events = %v, want a timeout", err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	d1, write1 := testDevice(t)
+	d2, write2 := testDevice(t)
+	keys := Keys(d1, d2)
+
+	write1(key(Key1, Pressed))
+	if k := <-keys; k != Key1 {
+		t.Errorf("key %d, want %d", k, Key1)
+	}
+	write2(key(Key2, Pressed), key(Key2, Released))
+	if k := <-keys; k != Key2 {
+		t.Errorf("key %d, want %d", k, Key2)
+	}
+	d1.Close()
+	d2.Close()
+	if k, ok := <-keys; ok {
+		t.Errorf("key %d after closing the devices", k)
+	}
+}
+
+func TestDigit(t *testing.T) {
+	for k, want := range map[Key]int{Key0: 0, Key1: 1, Key9: 9, KeyEsc: -1, KeyBackspace: -1} {
+		if d := k.Digit(); d != want {
+			t.Errorf("Key(%d).Digit() = %d, want %d", k, d, want)
+		}
+	}
+}
+
+func TestParseKeyboards(t *testing.T) {
+	const devices = `I: Bus=0019 Vendor=0000 Product=0001 Version=0000
+N: Name="Power Button"
+P: Phys=LNXPWRBN/button/input0
+S: Sysfs=/devices/LNXSYSTM:00/LNXPWRBN:00/input/input0
+U: Uniq=
+H: Handlers=kbd event0
+B: PROP=0
+B: EV=3
+B: KEY=10000000000000 0
+
+I: Bus=0011 Vendor=0001 Product=0001 Version=ab41
+N: Name="AT Translated Set 2 keyboard"
+P: Phys=isa0060/serio0/input0
+S: Sysfs=/devices/platform/i8042/serio0/input/input1
+U: Uniq=
+H: Handlers=sysrq kbd event1 leds
+B: PROP=0
+B: EV=120013
+B: KEY=402000000 3803078f800d001 feffffdfffefffff fffffffffffffffe
+B: MSC=10
+B: LED=7
+
+I: Bus=0011 Vendor=0002 Product=0013 Version=0006
+N: Name="VirtualPS/2 VMware VMMouse"
+P: Phys=isa0060/serio1/input1
+S: Sysfs=/devices/platform/i8042/serio1/input/input4
+U: Uniq=
+H: Handlers=mouse0 event2
+B: PROP=0
+B: EV=b
+B: KEY=70000 0 0 0 0
+B: ABS=3
+
+I: Bus=0003 Vendor=046d Product=c31c Version=0110
+N: Name="USB Keyboard"
+H: Handlers=sysrq kbd leds event3
+B: EV=120013
+B: KEY=1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe`
+
+	got, err := parseKeyboards(strings.NewReader(devices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/dev/input/event1", "/dev/input/event3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKeyboards() = %q, want %q", got, want)
+	}
+
+	if _, err := parseKeyboards(strings.NewReader("B: KEY=xyz\n")); err == nil {
+		t.Errorf("parseKeyboards() of a bad bitmap succeeded")
+	}
+}
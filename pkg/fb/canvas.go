@@ -0,0 +1,156 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fb
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/orangecms/go-framebuffer/framebuffer"
+)
+
+// Canvas is an image in the memory layout of a framebuffer: rows of Stride
+// pixels of Bpp bytes, either BGR(A), or RGB565 for 2 bytes per pixel.
+//
+// The screen is opaque, so At always returns opaque colors.
+type Canvas struct {
+	Pix           []byte
+	Width, Height int
+	// Stride is the number of pixels between vertically adjacent pixels.
+	Stride int
+	// Bpp is the number of bytes per pixel.
+	Bpp int
+}
+
+var _ draw.Image = &Canvas{}
+
+// NewCanvas returns a black in-memory canvas.
+func NewCanvas(width, height, bpp int) *Canvas {
+	return &Canvas{
+		Pix:    make([]byte, width*height*bpp),
+		Width:  width,
+		Height: height,
+		Stride: width,
+		Bpp:    bpp,
+	}
+}
+
+// ColorModel implements image.Image.
+func (c *Canvas) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds implements image.Image.
+func (c *Canvas) Bounds() image.Rectangle {
+	return image.Rect(0, 0, c.Width, c.Height)
+}
+
+func (c *Canvas) offset(x, y int) int {
+	return c.Bpp * (y*c.Stride + x)
+}
+
+// At implements image.Image.
+func (c *Canvas) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(c.Bounds()) {
+		return color.RGBA{}
+	}
+	p := c.Pix[c.offset(x, y):]
+	if c.Bpp == 2 {
+		v := uint16(p[0]) | uint16(p[1])<<8
+		r, g, b := uint8(v>>11), uint8(v>>5&0x3f), uint8(v&0x1f)
+		return color.RGBA{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 0xff}
+	}
+	return color.RGBA{p[2], p[1], p[0], 0xff}
+}
+
+// pixel returns the bytes of a pixel of color col.
+func (c *Canvas) pixel(col color.Color) []byte {
+	r, g, b, a := col.RGBA()
+	if c.Bpp == 2 {
+		v := uint16(r>>11)<<11 | uint16(g>>10)<<5 | uint16(b>>11)
+		return []byte{byte(v), byte(v >> 8)}
+	}
+	return []byte{byte(b >> 8), byte(g >> 8), byte(r >> 8), byte(a >> 8)}[:c.Bpp]
+}
+
+// Set implements draw.Image.
+func (c *Canvas) Set(x, y int, col color.Color) {
+	if !image.Pt(x, y).In(c.Bounds()) {
+		return
+	}
+	copy(c.Pix[c.offset(x, y):], c.pixel(col))
+}
+
+// Fill fills r with col.
+func (c *Canvas) Fill(r image.Rectangle, col color.Color) {
+	r = r.Intersect(c.Bounds())
+	if r.Empty() {
+		return
+	}
+	px := c.pixel(col)
+	row := c.Pix[c.offset(r.Min.X, r.Min.Y):c.offset(r.Max.X, r.Min.Y)]
+	for i := 0; i < len(row); i += c.Bpp {
+		copy(row[i:], px)
+	}
+	for y := r.Min.Y + 1; y < r.Max.Y; y++ {
+		copy(c.Pix[c.offset(r.Min.X, y):], row)
+	}
+}
+
+// DrawImage draws img with its top left corner at x, y. Unlike
+// DrawOnBufAt, the parts of img outside of the canvas are clipped.
+func (c *Canvas) DrawImage(img image.Image, x, y int) {
+	b := img.Bounds()
+	draw.Draw(c, b.Sub(b.Min).Add(image.Pt(x, y)), img, b.Min, draw.Src)
+}
+
+// Fill fills r of dst with col. It is faster than draw.Draw for a Canvas.
+func Fill(dst draw.Image, r image.Rectangle, col color.Color) {
+	if c, ok := dst.(*Canvas); ok {
+		c.Fill(r, col)
+		return
+	}
+	draw.Draw(dst, r, image.NewUniform(col), image.Point{}, draw.Src)
+}
+
+// Device is a Canvas of the memory mapped framebuffer device.
+type Device struct {
+	*Canvas
+	fb *framebuffer.Framebuffer
+}
+
+// Open maps the framebuffer device dev, e.g. /dev/fb0, into memory. Drawing
+// on the canvas of the device draws on the screen.
+func Open(dev string) (*Device, error) {
+	fbo, err := framebuffer.Init(dev)
+	if err != nil {
+		return nil, err
+	}
+	bpp := fbo.Bpp()
+	if bpp < 2 || bpp > 4 {
+		fbo.Close()
+		return nil, fmt.Errorf("%s has %d bits per pixel, only 16, 24 and 32 are supported", dev, fbo.Vinfo.Bits_per_pixel)
+	}
+	width, height := fbo.Size()
+	return &Device{
+		Canvas: &Canvas{
+			Pix:    fbo.Data[fbo.Vinfo.Yoffset*fbo.Finfo.Line_length+fbo.Vinfo.Xoffset*uint32(bpp):],
+			Width:  width,
+			Height: height,
+			Stride: fbo.Stride(),
+			Bpp:    bpp,
+		},
+		fb: fbo,
+	}, nil
+}
+
+// Close unmaps the framebuffer and restores the contents of the screen from
+// before Open.
+func (d *Device) Close() error {
+	d.fb.Close()
+	return nil
+}
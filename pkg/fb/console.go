@@ -0,0 +1,206 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fb
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Console is a text console in a rectangle of an image, such as the canvas
+// of a framebuffer device. Lines wrap at the right edge and scroll up at the
+// bottom.
+//
+// Of the ANSI escape sequences, only clearing the screen (ESC [ 2 J) and
+// moving the cursor (ESC [ row ; col H) are supported; others are ignored.
+type Console struct {
+	// Fg and Bg are the colors of the text and of the background.
+	Fg, Bg color.Color
+
+	mu       sync.Mutex
+	dst      draw.Image
+	rect     image.Rectangle
+	font     *Font
+	cells    [][]rune
+	row, col int
+	// esc is the escape sequence being written.
+	esc []byte
+}
+
+// NewConsole returns a console of white text on black in r of dst. It does
+// not draw until written to or cleared.
+func NewConsole(dst draw.Image, r image.Rectangle, font *Font) *Console {
+	size := font.Size()
+	c := &Console{
+		Fg:   color.White,
+		Bg:   color.Black,
+		dst:  dst,
+		rect: r,
+		font: font,
+	}
+	c.cells = make([][]rune, r.Dy()/size.Y)
+	for i := range c.cells {
+		c.cells[i] = blank(r.Dx() / size.X)
+	}
+	return c
+}
+
+func blank(cols int) []rune {
+	return []rune(strings.Repeat(" ", cols))
+}
+
+// Size returns the number of columns and rows of the console.
+func (c *Console) Size() (cols, rows int) {
+	if len(c.cells) == 0 {
+		return 0, 0
+	}
+	return len(c.cells[0]), len(c.cells)
+}
+
+// Clear clears the console and moves the cursor to the top left.
+func (c *Console) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+}
+
+func (c *Console) clear() {
+	for _, row := range c.cells {
+		copy(row, blank(len(row)))
+	}
+	c.row, c.col = 0, 0
+	Fill(c.dst, c.rect, c.Bg)
+}
+
+// String returns the text of the console, with the trailing spaces of lines
+// trimmed.
+func (c *Console) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var b strings.Builder
+	for _, row := range c.cells {
+		b.WriteString(strings.TrimRight(string(row), " "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (c *Console) drawCell(row, col int) {
+	p := c.rect.Min.Add(image.Pt(col*c.font.Size().X, row*c.font.Size().Y))
+	c.font.DrawRune(c.dst, p, c.cells[row][col], c.Fg, c.Bg)
+}
+
+func (c *Console) redraw() {
+	for row := range c.cells {
+		for col := range c.cells[row] {
+			c.drawCell(row, col)
+		}
+	}
+}
+
+func (c *Console) newline() {
+	c.col = 0
+	if c.row < len(c.cells)-1 {
+		c.row++
+		return
+	}
+	c.cells = append(c.cells[1:], blank(len(c.cells[0])))
+	c.redraw()
+}
+
+func (c *Console) put(r rune) {
+	cols, _ := c.Size()
+	if c.col >= cols {
+		c.newline()
+	}
+	c.cells[c.row][c.col] = r
+	c.drawCell(c.row, c.col)
+	c.col++
+}
+
+// escape interprets the complete escape sequence in c.esc.
+func (c *Console) escape() {
+	params := strings.Split(string(c.esc[2:len(c.esc)-1]), ";")
+	switch c.esc[len(c.esc)-1] {
+	case 'J':
+		if params[0] == "2" {
+			row, col := c.row, c.col
+			c.clear()
+			c.row, c.col = row, col
+		}
+	case 'H':
+		c.row, c.col = 0, 0
+		cols, rows := c.Size()
+		if n, err := strconv.Atoi(params[0]); err == nil && n > 0 {
+			c.row = min(n, rows) - 1
+		}
+		if len(params) > 1 {
+			if n, err := strconv.Atoi(params[1]); err == nil && n > 0 {
+				c.col = min(n, cols) - 1
+			}
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Write implements io.Writer.
+func (c *Console) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cells) == 0 || len(c.cells[0]) == 0 {
+		return len(b), nil
+	}
+	for i := 0; i < len(b); {
+		r, n := utf8.DecodeRune(b[i:])
+		i += n
+		if len(c.esc) > 0 {
+			c.esc = append(c.esc, b[i-n:i]...)
+			switch {
+			case len(c.esc) == 2 && (r == '(' || r == ')'):
+				// Character set designation, ESC ( C.
+			case len(c.esc) == 2 && r != '[', c.esc[1] != '[':
+				// Not a control sequence, ignore it.
+				c.esc = nil
+			case len(c.esc) > 2 && r >= 0x40 && r <= 0x7e:
+				c.escape()
+				c.esc = nil
+			}
+			continue
+		}
+		switch r {
+		case '\033':
+			c.esc = []byte{byte(r)}
+		case '\n':
+			c.newline()
+		case '\r':
+			c.col = 0
+		case '\t':
+			c.put(' ')
+			for cols, _ := c.Size(); c.col%8 != 0 && c.col < cols; {
+				c.put(' ')
+			}
+		case '\b':
+			if c.col > 0 {
+				c.col--
+			}
+		default:
+			if r >= ' ' {
+				c.put(r)
+			}
+		}
+	}
+	return len(b), nil
+}
@@ -2,6 +2,8 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Package fb draws images, text and simple widgets on the Linux framebuffer,
+// or on an in-memory Canvas in its layout.
 package fb
 
 import (
@@ -0,0 +1,213 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fb
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{0xff, 0, 0, 0xff}
+	blue = color.RGBA{0, 0, 0xff, 0xff}
+)
+
+// ascii returns the pixels of r of img, '#' for the non-black ones.
+func ascii(img image.Image, r image.Rectangle) string {
+	var b strings.Builder
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if r, g, b_, _ := img.At(x, y).RGBA(); r|g|b_ != 0 {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestCanvas(t *testing.T) {
+	for _, bpp := range []int{2, 3, 4} {
+		c := NewCanvas(4, 3, bpp)
+		for _, col := range []color.RGBA{red, blue, {0xff, 0xff, 0xff, 0xff}} {
+			c.Set(1, 1, col)
+			if got := c.At(1, 1); got != col {
+				t.Errorf("%d bytes per pixel: At() = %v after Set(%v)", bpp, got, col)
+			}
+		}
+		// Drawing outside the canvas is clipped.
+		c.Set(4, 0, red)
+		c.Fill(image.Rect(-2, -2, 2, 1), red)
+		c.DrawImage(image.NewUniform(blue), 3, 2)
+		if got, want := ascii(c, c.Bounds()), "##..\n.#..\n...#\n"; got != want {
+			t.Errorf("%d bytes per pixel: canvas is\n%swant\n%s", bpp, got, want)
+		}
+		if got := c.At(0, 0); got != red {
+			t.Errorf("%d bytes per pixel: At(0, 0) = %v, want %v", bpp, got, red)
+		}
+	}
+
+	// BGRA, with a stride larger than the width.
+	c := &Canvas{Pix: make([]byte, 4*3*2), Width: 2, Height: 2, Stride: 3, Bpp: 4}
+	c.Fill(c.Bounds(), red)
+	want := []byte{
+		0, 0, 0xff, 0xff, 0, 0, 0xff, 0xff, 0, 0, 0, 0,
+		0, 0, 0xff, 0xff, 0, 0, 0xff, 0xff, 0, 0, 0, 0,
+	}
+	if string(c.Pix) != string(want) {
+		t.Errorf("Fill() set pixels % x, want % x", c.Pix, want)
+	}
+}
+
+func TestDrawString(t *testing.T) {
+	c := NewCanvas(24, 10, 4)
+	if p := Basic.DrawString(c, image.Pt(1, 1), "Hi!", red, nil); p != image.Pt(25, 1) {
+		t.Errorf("DrawString() = %v, want (25,1)", p)
+	}
+	want := `........................
+.##..##....##.......##..
+.##..##............####.
+.##..##...###......####.
+.######....##.......##..
+.##..##....##.......##..
+.##..##....##...........
+.##..##...####......##..
+........................
+........................
+`
+	if got := ascii(c, c.Bounds()); got != want {
+		t.Errorf("DrawString() drew\n%swant\n%s", got, want)
+	}
+
+	c = NewCanvas(16, 16, 4)
+	f := Basic.Scaled(2)
+	if s := f.Size(); s != image.Pt(16, 16) {
+		t.Errorf("Size() = %v, want (16,16)", s)
+	}
+	// Runes without a glyph are drawn as '?'.
+	f.DrawRune(c, image.Point{}, 'é', red, blue)
+	q := NewCanvas(16, 16, 4)
+	f.DrawRune(q, image.Point{}, '?', red, blue)
+	if string(c.Pix) != string(q.Pix) {
+		t.Errorf("DrawRune('é') is not '?':\n%s", ascii(c, c.Bounds()))
+	}
+	if got := c.At(0, 0); got != blue {
+		t.Errorf("background is %v, want %v", got, blue)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	c := NewCanvas(40, 24, 4)
+	con := NewConsole(c, image.Rect(0, 8, 40, 24), Basic)
+	if cols, rows := con.Size(); cols != 5 || rows != 2 {
+		t.Fatalf("Size() = %d, %d, want 5, 2", cols, rows)
+	}
+	for _, tt := range []struct {
+		write string
+		want  string
+	}{
+		{"ab\tc", "ab\nc\n"},
+		{"\rd\n", "d\n\n"},
+		{"efghijk", "efghi\njk\n"},
+		{"\033[1;2H\033[2Jx\033[5mx\033(B\bz", " xz\n\n"},
+		{"\033[Hy", "yxz\n\n"},
+	} {
+		con.Write([]byte(tt.write))
+		if got := con.String(); got != tt.want {
+			t.Errorf("after writing %q, console is\n%q, want\n%q", tt.write, got, tt.want)
+		}
+	}
+
+	con.Clear()
+	con.Write([]byte("\n!"))
+	want := strings.Repeat("........................................\n", 16) + `...##...................................
+..####..................................
+..####..................................
+...##...................................
+...##...................................
+........................................
+...##...................................
+........................................
+`
+	if got := ascii(c, c.Bounds()); got != want {
+		t.Errorf("console drew\n%swant\n%s", got, want)
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	c := NewCanvas(12, 4, 4)
+	p := &ProgressBar{Rect: image.Rect(1, 0, 11, 3), Fg: red, Bg: color.Black}
+	for _, tt := range []struct {
+		done, total int
+		want        string
+	}{
+		{0, 4, ".##########.\n.#........#.\n.##########.\n............\n"},
+		{2, 4, ".##########.\n.#####....#.\n.##########.\n............\n"},
+		{5, 4, ".##########.\n.##########.\n.##########.\n............\n"},
+		{1, 0, ".##########.\n.#........#.\n.##########.\n............\n"},
+	} {
+		p.Draw(c, tt.done, tt.total)
+		if got := ascii(c, c.Bounds()); got != tt.want {
+			t.Errorf("Draw(%d, %d) drew\n%swant\n%s", tt.done, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	c := NewCanvas(16, 16, 4)
+	l := &List{
+		Rect:  c.Bounds(),
+		Font:  Basic,
+		Items: []string{"a", "b", "c"},
+		Fg:    color.White,
+		Bg:    color.Black,
+	}
+	if l.Rows() != 2 {
+		t.Fatalf("Rows() = %d, want 2", l.Rows())
+	}
+	// row returns the text of row i of the list, from the glyphs drawn.
+	row := func(i int) (string, bool) {
+		r := image.Rect(0, 8*i, 8, 8*i+8)
+		selected := c.At(15, r.Min.Y) != color.RGBA{0, 0, 0, 0xff}
+		for _, s := range l.Items {
+			g := NewCanvas(8, 8, 4)
+			fg, bg := color.Color(color.White), color.Color(color.Black)
+			if selected {
+				fg, bg = bg, fg
+			}
+			Basic.DrawRune(g, image.Point{}, rune(s[0]), fg, bg)
+			if ascii(g, g.Bounds()) == ascii(c, r) {
+				return s, selected
+			}
+		}
+		return "", selected
+	}
+
+	for _, tt := range []struct {
+		move   func()
+		rows   [2]string
+		cursor int
+	}{
+		{func() {}, [2]string{"a", "b"}, 0},
+		{l.Up, [2]string{"a", "b"}, 0},
+		{l.Down, [2]string{"a", "b"}, 1},
+		{l.Down, [2]string{"b", "c"}, 1},
+		{l.Down, [2]string{"b", "c"}, 1},
+		{l.Up, [2]string{"b", "c"}, 0},
+		{l.Up, [2]string{"a", "b"}, 0},
+	} {
+		tt.move()
+		l.Draw(c)
+		for i, want := range tt.rows {
+			if got, selected := row(i); got != want || selected != (i == tt.cursor) {
+				t.Errorf("Selected %d: row %d is %q, selected %t, want %q, selected %t", l.Selected, i, got, selected, want, i == tt.cursor)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fb
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Font is a monospace bitmap font of glyphs 8 pixels wide.
+type Font struct {
+	// Height is the number of rows of a glyph.
+	Height int
+	// First is the rune of the first glyph. Runes without a glyph are
+	// drawn as '?'.
+	First rune
+	// Glyphs are the bitmaps of consecutive runes, Height bytes each,
+	// one byte per row with the leftmost pixel in the least significant
+	// bit.
+	Glyphs []byte
+	// Scale is the size of a pixel of a glyph in pixels of the screen. A
+	// scale of 0 is 1.
+	Scale int
+}
+
+// Basic is the built-in 8x8 font of the printable ASCII characters, the
+// public domain font8x8 derived from the IBM PC BIOS font.
+var Basic = &Font{Height: 8, First: ' ', Glyphs: basicGlyphs[:], Scale: 1}
+
+// Scaled returns a copy of the font with glyphs scale times as large.
+func (f *Font) Scaled(scale int) *Font {
+	s := *f
+	s.Scale = scale
+	return &s
+}
+
+func (f *Font) scale() int {
+	if f.Scale < 1 {
+		return 1
+	}
+	return f.Scale
+}
+
+// Size returns the size of a glyph on screen.
+func (f *Font) Size() image.Point {
+	return image.Pt(8*f.scale(), f.Height*f.scale())
+}
+
+// glyph returns the bitmap of r.
+func (f *Font) glyph(r rune) []byte {
+	i := int(r - f.First)
+	if r < f.First || (i+1)*f.Height > len(f.Glyphs) {
+		i = int('?' - f.First)
+	}
+	return f.Glyphs[i*f.Height : (i+1)*f.Height]
+}
+
+// DrawRune draws r with its top left corner at p, in fg on bg. A nil bg
+// leaves the background as it is.
+func (f *Font) DrawRune(dst draw.Image, p image.Point, r rune, fg, bg color.Color) {
+	s := f.scale()
+	if bg != nil {
+		Fill(dst, image.Rectangle{p, p.Add(f.Size())}, bg)
+	}
+	for y, row := range f.glyph(r) {
+		for x := 0; x < 8; x++ {
+			if row&(1<<x) == 0 {
+				continue
+			}
+			Fill(dst, image.Rect(x*s, y*s, (x+1)*s, (y+1)*s).Add(p), fg)
+		}
+	}
+}
+
+// DrawString draws s on a single line starting at p, and returns the point
+// after its last rune.
+func (f *Font) DrawString(dst draw.Image, p image.Point, s string, fg, bg color.Color) image.Point {
+	for _, r := range s {
+		f.DrawRune(dst, p, r, fg, bg)
+		p.X += f.Size().X
+	}
+	return p
+}
+
+// basicGlyphs are the glyphs of ' ' to '~' of the Basic font.
+var basicGlyphs = [...]byte{
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // ' '
+	0x18, 0x3c, 0x3c, 0x18, 0x18, 0x00, 0x18, 0x00, // '!'
+	0x36, 0x36, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // '"'
+	0x36, 0x36, 0x7f, 0x36, 0x7f, 0x36, 0x36, 0x00, // '#'
+	0x0c, 0x3e, 0x03, 0x1e, 0x30, 0x1f, 0x0c, 0x00, // '$'
+	0x00, 0x63, 0x33, 0x18, 0x0c, 0x66, 0x63, 0x00, // '%'
+	0x1c, 0x36, 0x1c, 0x6e, 0x3b, 0x33, 0x6e, 0x00, // '&'
+	0x06, 0x06, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, // '\''
+	0x18, 0x0c, 0x06, 0x06, 0x06, 0x0c, 0x18, 0x00, // '('
+	0x06, 0x0c, 0x18, 0x18, 0x18, 0x0c, 0x06, 0x00, // ')'
+	0x00, 0x66, 0x3c, 0xff, 0x3c, 0x66, 0x00, 0x00, // '*'
+	0x00, 0x0c, 0x0c, 0x3f, 0x0c, 0x0c, 0x00, 0x00, // '+'
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c, 0x06, // ','
+	0x00, 0x00, 0x00, 0x3f, 0x00, 0x00, 0x00, 0x00, // '-'
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c, 0x00, // '.'
+	0x60, 0x30, 0x18, 0x0c, 0x06, 0x03, 0x01, 0x00, // '/'
+	0x3e, 0x63, 0x73, 0x7b, 0x6f, 0x67, 0x3e, 0x00, // '0'
+	0x0c, 0x0e, 0x0c, 0x0c, 0x0c, 0x0c, 0x3f, 0x00, // '1'
+	0x1e, 0x33, 0x30, 0x1c, 0x06, 0x33, 0x3f, 0x00, // '2'
+	0x1e, 0x33, 0x30, 0x1c, 0x30, 0x33, 0x1e, 0x00, // '3'
+	0x38, 0x3c, 0x36, 0x33, 0x7f, 0x30, 0x78, 0x00, // '4'
+	0x3f, 0x03, 0x1f, 0x30, 0x30, 0x33, 0x1e, 0x00, // '5'
+	0x1c, 0x06, 0x03, 0x1f, 0x33, 0x33, 0x1e, 0x00, // '6'
+	0x3f, 0x33, 0x30, 0x18, 0x0c, 0x0c, 0x0c, 0x00, // '7'
+	0x1e, 0x33, 0x33, 0x1e, 0x33, 0x33, 0x1e, 0x00, // '8'
+	0x1e, 0x33, 0x33, 0x3e, 0x30, 0x18, 0x0e, 0x00, // '9'
+	0x00, 0x0c, 0x0c, 0x00, 0x00, 0x0c, 0x0c, 0x00, // ':'
+	0x00, 0x0c, 0x0c, 0x00, 0x00, 0x0c, 0x0c, 0x06, // ';'
+	0x18, 0x0c, 0x06, 0x03, 0x06, 0x0c, 0x18, 0x00, // '<'
+	0x00, 0x00, 0x3f, 0x00, 0x00, 0x3f, 0x00, 0x00, // '='
+	0x06, 0x0c, 0x18, 0x30, 0x18, 0x0c, 0x06, 0x00, // '>'
+	0x1e, 0x33, 0x30, 0x18, 0x0c, 0x00, 0x0c, 0x00, // '?'
+	0x3e, 0x63, 0x7b, 0x7b, 0x7b, 0x03, 0x1e, 0x00, // '@'
+	0x0c, 0x1e, 0x33, 0x33, 0x3f, 0x33, 0x33, 0x00, // 'A'
+	0x3f, 0x66, 0x66, 0x3e, 0x66, 0x66, 0x3f, 0x00, // 'B'
+	0x3c, 0x66, 0x03, 0x03, 0x03, 0x66, 0x3c, 0x00, // 'C'
+	0x1f, 0x36, 0x66, 0x66, 0x66, 0x36, 0x1f, 0x00, // 'D'
+	0x7f, 0x46, 0x16, 0x1e, 0x16, 0x46, 0x7f, 0x00, // 'E'
+	0x7f, 0x46, 0x16, 0x1e, 0x16, 0x06, 0x0f, 0x00, // 'F'
+	0x3c, 0x66, 0x03, 0x03, 0x73, 0x66, 0x7c, 0x00, // 'G'
+	0x33, 0x33, 0x33, 0x3f, 0x33, 0x33, 0x33, 0x00, // 'H'
+	0x1e, 0x0c, 0x0c, 0x0c, 0x0c, 0x0c, 0x1e, 0x00, // 'I'
+	0x78, 0x30, 0x30, 0x30, 0x33, 0x33, 0x1e, 0x00, // 'J'
+	0x67, 0x66, 0x36, 0x1e, 0x36, 0x66, 0x67, 0x00, // 'K'
+	0x0f, 0x06, 0x06, 0x06, 0x46, 0x66, 0x7f, 0x00, // 'L'
+	0x63, 0x77, 0x7f, 0x7f, 0x6b, 0x63, 0x63, 0x00, // 'M'
+	0x63, 0x67, 0x6f, 0x7b, 0x73, 0x63, 0x63, 0x00, // 'N'
+	0x1c, 0x36, 0x63, 0x63, 0x63, 0x36, 0x1c, 0x00, // 'O'
+	0x3f, 0x66, 0x66, 0x3e, 0x06, 0x06, 0x0f, 0x00, // 'P'
+	0x1e, 0x33, 0x33, 0x33, 0x3b, 0x1e, 0x38, 0x00, // 'Q'
+	0x3f, 0x66, 0x66, 0x3e, 0x36, 0x66, 0x67, 0x00, // 'R'
+	0x1e, 0x33, 0x07, 0x0e, 0x38, 0x33, 0x1e, 0x00, // 'S'
+	0x3f, 0x2d, 0x0c, 0x0c, 0x0c, 0x0c, 0x1e, 0x00, // 'T'
+	0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x3f, 0x00, // 'U'
+	0x33, 0x33, 0x33, 0x33, 0x33, 0x1e, 0x0c, 0x00, // 'V'
+	0x63, 0x63, 0x63, 0x6b, 0x7f, 0x77, 0x63, 0x00, // 'W'
+	0x63, 0x63, 0x36, 0x1c, 0x1c, 0x36, 0x63, 0x00, // 'X'
+	0x33, 0x33, 0x33, 0x1e, 0x0c, 0x0c, 0x1e, 0x00, // 'Y'
+	0x7f, 0x63, 0x31, 0x18, 0x4c, 0x66, 0x7f, 0x00, // 'Z'
+	0x1e, 0x06, 0x06, 0x06, 0x06, 0x06, 0x1e, 0x00, // '['
+	0x03, 0x06, 0x0c, 0x18, 0x30, 0x60, 0x40, 0x00, // '\\'
+	0x1e, 0x18, 0x18, 0x18, 0x18, 0x18, 0x1e, 0x00, // ']'
+	0x08, 0x1c, 0x36, 0x63, 0x00, 0x00, 0x00, 0x00, // '^'
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, // '_'
+	0x0c, 0x0c, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00, // '`'
+	0x00, 0x00, 0x1e, 0x30, 0x3e, 0x33, 0x6e, 0x00, // 'a'
+	0x07, 0x06, 0x06, 0x3e, 0x66, 0x66, 0x3b, 0x00, // 'b'
+	0x00, 0x00, 0x1e, 0x33, 0x03, 0x33, 0x1e, 0x00, // 'c'
+	0x38, 0x30, 0x30, 0x3e, 0x33, 0x33, 0x6e, 0x00, // 'd'
+	0x00, 0x00, 0x1e, 0x33, 0x3f, 0x03, 0x1e, 0x00, // 'e'
+	0x1c, 0x36, 0x06, 0x0f, 0x06, 0x06, 0x0f, 0x00, // 'f'
+	0x00, 0x00, 0x6e, 0x33, 0x33, 0x3e, 0x30, 0x1f, // 'g'
+	0x07, 0x06, 0x36, 0x6e, 0x66, 0x66, 0x67, 0x00, // 'h'
+	0x0c, 0x00, 0x0e, 0x0c, 0x0c, 0x0c, 0x1e, 0x00, // 'i'
+	0x30, 0x00, 0x30, 0x30, 0x30, 0x33, 0x33, 0x1e, // 'j'
+	0x07, 0x06, 0x66, 0x36, 0x1e, 0x36, 0x67, 0x00, // 'k'
+	0x0e, 0x0c, 0x0c, 0x0c, 0x0c, 0x0c, 0x1e, 0x00, // 'l'
+	0x00, 0x00, 0x33, 0x7f, 0x7f, 0x6b, 0x63, 0x00, // 'm'
+	0x00, 0x00, 0x1f, 0x33, 0x33, 0x33, 0x33, 0x00, // 'n'
+	0x00, 0x00, 0x1e, 0x33, 0x33, 0x33, 0x1e, 0x00, // 'o'
+	0x00, 0x00, 0x3b, 0x66, 0x66, 0x3e, 0x06, 0x0f, // 'p'
+	0x00, 0x00, 0x6e, 0x33, 0x33, 0x3e, 0x30, 0x78, // 'q'
+	0x00, 0x00, 0x3b, 0x6e, 0x66, 0x06, 0x0f, 0x00, // 'r'
+	0x00, 0x00, 0x3e, 0x03, 0x1e, 0x30, 0x1f, 0x00, // 's'
+	0x08, 0x0c, 0x3e, 0x0c, 0x0c, 0x2c, 0x18, 0x00, // 't'
+	0x00, 0x00, 0x33, 0x33, 0x33, 0x33, 0x6e, 0x00, // 'u'
+	0x00, 0x00, 0x33, 0x33, 0x33, 0x1e, 0x0c, 0x00, // 'v'
+	0x00, 0x00, 0x63, 0x6b, 0x7f, 0x7f, 0x36, 0x00, // 'w'
+	0x00, 0x00, 0x63, 0x36, 0x1c, 0x36, 0x63, 0x00, // 'x'
+	0x00, 0x00, 0x33, 0x33, 0x33, 0x3e, 0x30, 0x1f, // 'y'
+	0x00, 0x00, 0x3f, 0x19, 0x0c, 0x26, 0x3f, 0x00, // 'z'
+	0x38, 0x0c, 0x0c, 0x07, 0x0c, 0x0c, 0x38, 0x00, // '{'
+	0x18, 0x18, 0x18, 0x00, 0x18, 0x18, 0x18, 0x00, // '|'
+	0x07, 0x0c, 0x0c, 0x38, 0x0c, 0x0c, 0x07, 0x00, // '}'
+	0x6e, 0x3b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // '~'
+}
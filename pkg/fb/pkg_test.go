@@ -1,11 +0,0 @@
-// Copyright 2021 the u-root Authors. All rights reserved
-// Use of this source code is governed by a BSD-style
-// license that can be found in the LICENSE file.
-
-package fb
-
-import "testing"
-
-func TestTODO(t *testing.T) {
-	// TODO: Write a unit test.
-}
@@ -0,0 +1,99 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fb
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// ProgressBar is a framed horizontal bar, filled from the left.
+type ProgressBar struct {
+	Rect image.Rectangle
+	// Fg and Bg are the colors of the frame and the filled part, and of
+	// the rest.
+	Fg, Bg color.Color
+}
+
+// Draw draws the bar filled by done out of total.
+func (p *ProgressBar) Draw(dst draw.Image, done, total int) {
+	Fill(dst, p.Rect, p.Fg)
+	inner := p.Rect.Inset(1)
+	if inner.Empty() {
+		return
+	}
+	switch {
+	case total <= 0 || done < 0:
+		done, total = 0, 1
+	case done > total:
+		done = total
+	}
+	inner.Min.X += inner.Dx() * done / total
+	Fill(dst, inner, p.Bg)
+}
+
+// List is a list of one line items of which one is selected. The list
+// scrolls to keep the selected item visible.
+type List struct {
+	Rect  image.Rectangle
+	Font  *Font
+	Items []string
+	// Selected is the index of the selected item.
+	Selected int
+	// Fg and Bg are the colors of the items. The selected item is drawn
+	// in Bg on Fg.
+	Fg, Bg color.Color
+
+	// top is the index of the first visible item.
+	top int
+}
+
+// Rows returns the number of items that fit in the list.
+func (l *List) Rows() int {
+	return l.Rect.Dy() / l.Font.Size().Y
+}
+
+// Up selects the previous item.
+func (l *List) Up() {
+	if l.Selected > 0 {
+		l.Selected--
+	}
+}
+
+// Down selects the next item.
+func (l *List) Down() {
+	if l.Selected < len(l.Items)-1 {
+		l.Selected++
+	}
+}
+
+// Draw draws the visible items.
+func (l *List) Draw(dst draw.Image) {
+	rows := l.Rows()
+	if l.Selected < l.top {
+		l.top = l.Selected
+	}
+	if l.Selected >= l.top+rows {
+		l.top = l.Selected - rows + 1
+	}
+	Fill(dst, l.Rect, l.Bg)
+
+	size := l.Font.Size()
+	cols := l.Rect.Dx() / size.X
+	for i := l.top; i < len(l.Items) && i < l.top+rows; i++ {
+		line := []rune(l.Items[i])
+		if len(line) > cols {
+			line = line[:cols]
+		}
+		p := l.Rect.Min.Add(image.Pt(0, (i-l.top)*size.Y))
+		fg, bg := l.Fg, l.Bg
+		if i == l.Selected {
+			fg, bg = bg, fg
+			Fill(dst, image.Rect(l.Rect.Min.X, p.Y, l.Rect.Max.X, p.Y+size.Y), bg)
+		}
+		l.Font.DrawString(dst, p, string(line), fg, bg)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package chipset drives the SPI controllers built into chipsets, which
+// flashrom calls the internal programmer: the SPI controller of Intel ICH and
+// PCH chipsets, and the SPI100 controller of AMD FCH chipsets.
+//
+// The controllers are programmed through their memory-mapped registers. The
+// Registers interface is implemented by memio.MMap and, for tests, by
+// simulated controllers.
+//
+// Useful references:
+// * https://github.com/flashrom/flashrom/blob/master/ichspi.c
+// * https://github.com/flashrom/flashrom/blob/master/sb600spi.c
+package chipset
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/u-root/u-root/pkg/flash/op"
+	"github.com/u-root/u-root/pkg/memio"
+	"github.com/u-root/u-root/pkg/spidev"
+)
+
+// Registers are the memory-mapped registers of a controller. Offsets are
+// relative to the base of the registers.
+type Registers interface {
+	ReadAt(off int64, data memio.UintN) error
+	WriteAt(off int64, data memio.UintN) error
+}
+
+// ErrProtected is returned for accesses that the chipset does not allow.
+var ErrProtected = errors.New("protected")
+
+// timeout is how long to wait for a cycle of the controller.
+var timeout = 5 * time.Second
+
+func read8(r Registers, off int64) (uint8, error) {
+	var v memio.Uint8
+	err := r.ReadAt(off, &v)
+	return uint8(v), err
+}
+
+func read16(r Registers, off int64) (uint16, error) {
+	var v memio.Uint16
+	err := r.ReadAt(off, &v)
+	return uint16(v), err
+}
+
+func read32(r Registers, off int64) (uint32, error) {
+	var v memio.Uint32
+	err := r.ReadAt(off, &v)
+	return uint32(v), err
+}
+
+func write8(r Registers, off int64, v uint8) error {
+	d := memio.Uint8(v)
+	return r.WriteAt(off, &d)
+}
+
+func write16(r Registers, off int64, v uint16) error {
+	d := memio.Uint16(v)
+	return r.WriteAt(off, &d)
+}
+
+func write32(r Registers, off int64, v uint32) error {
+	d := memio.Uint32(v)
+	return r.WriteAt(off, &d)
+}
+
+// ProtectedRange is a range of the flash the chipset protects from reads or
+// writes, as set up by the firmware.
+type ProtectedRange struct {
+	// Base and Limit are the first and last byte of the range.
+	Base  int64
+	Limit int64
+
+	Read  bool
+	Write bool
+}
+
+// String formats the range like flashrom.
+func (p ProtectedRange) String() string {
+	s := fmt.Sprintf("%#08x-%#08x", p.Base, p.Limit)
+	switch {
+	case p.Read && p.Write:
+		s += " is read and write protected"
+	case p.Read:
+		s += " is read protected"
+	case p.Write:
+		s += " is write protected"
+	default:
+		s += " is not protected"
+	}
+	return s
+}
+
+// checkRanges returns an ErrProtected error if the n bytes at off overlap a
+// range that protects them from being read, or written if write is set.
+func checkRanges(ranges []ProtectedRange, off, n int64, write bool) error {
+	for _, p := range ranges {
+		if off > p.Limit || off+n <= p.Base {
+			continue
+		}
+		if (write && p.Write) || (!write && p.Read) {
+			return fmt.Errorf("chipset: %#x bytes at %#x: %#x-%#x is %w", n, off, p.Base, p.Limit, ErrProtected)
+		}
+	}
+	return nil
+}
+
+// command is a SPI command, the bytes exchanged while chip select is
+// asserted.
+type command struct {
+	// pre is the opcode of a command, such as write enable, to send
+	// before this one, or 0.
+	pre byte
+	// tx is the opcode followed by the address and data to write.
+	tx []byte
+	// rx is read after tx.
+	rx []byte
+	// alen is the length of addresses, 3 or 4 bytes. 0 means 3.
+	alen int
+}
+
+// commands groups transfers into commands. A write enable command is
+// attached to the command that follows it.
+func commands(transfers []spidev.Transfer) ([]command, error) {
+	var cmds []command
+	var c command
+	for i, t := range transfers {
+		switch {
+		case len(t.Tx) != 0 && len(t.Rx) != 0:
+			return nil, fmt.Errorf("chipset: full duplex transfers are not supported")
+		case len(t.Rx) != 0 && len(c.tx) == 0:
+			return nil, fmt.Errorf("chipset: transfer %d reads before sending an opcode", i)
+		case len(t.Rx) != 0 && c.rx != nil:
+			return nil, fmt.Errorf("chipset: transfer %d reads twice in a command", i)
+		case len(t.Tx) != 0 && c.rx != nil:
+			return nil, fmt.Errorf("chipset: transfer %d writes after reading in a command", i)
+		}
+		c.tx = append(c.tx, t.Tx...)
+		if len(t.Rx) != 0 {
+			c.rx = t.Rx
+		}
+		if !t.CSChange && i != len(transfers)-1 {
+			continue
+		}
+		if len(c.tx) != 0 {
+			cmds = append(cmds, c)
+		}
+		c = command{}
+	}
+	// Attach write enables.
+	var out []command
+	for i := 0; i < len(cmds); i++ {
+		if len(cmds[i].tx) == 1 && cmds[i].rx == nil && cmds[i].tx[0] == op.WriteEnable && i+1 < len(cmds) {
+			cmds[i+1].pre = op.WriteEnable
+			continue
+		}
+		out = append(out, cmds[i])
+	}
+	return out, nil
+}
+
+// addrLen returns the length of addresses.
+func (c *command) addrLen() int {
+	if c.alen == 0 {
+		return 3
+	}
+	return c.alen
+}
+
+// address returns the address of the command.
+func (c *command) address() (int64, bool) {
+	n := c.addrLen()
+	if len(c.tx) < 1+n {
+		return 0, false
+	}
+	var a int64
+	for _, b := range c.tx[1 : 1+n] {
+		a = a<<8 | int64(b)
+	}
+	return a, true
+}
+
+// withAddress returns tx with the address replaced by addr.
+func (c *command) withAddress(addr int64) []byte {
+	tx := append([]byte{}, c.tx...)
+	n := c.addrLen()
+	for i := n; i > 0; i-- {
+		tx[i] = byte(addr)
+		addr >>= 8
+	}
+	return tx
+}
+
+// split splits reads and page programs into commands with at most max bytes
+// of data. Other commands are returned as is.
+func (c command) split(max int) []command {
+	addr, ok := c.address()
+	if !ok || max <= 0 {
+		return []command{c}
+	}
+	var cmds []command
+	switch c.tx[0] {
+	case op.Read, op.ReadSFDP:
+		if len(c.rx) <= max {
+			return []command{c}
+		}
+		for i := 0; i < len(c.rx); i += max {
+			n := len(c.rx) - i
+			if n > max {
+				n = max
+			}
+			cmds = append(cmds, command{pre: c.pre, tx: c.withAddress(addr + int64(i)), rx: c.rx[i : i+n], alen: c.alen})
+		}
+	case op.PageProgram:
+		data := c.tx[1+c.addrLen():]
+		if len(data) <= max {
+			return []command{c}
+		}
+		for i := 0; i < len(data); i += max {
+			n := len(data) - i
+			if n > max {
+				n = max
+			}
+			tx := append(c.withAddress(addr + int64(i))[:1+c.addrLen()], data[i:i+n]...)
+			cmds = append(cmds, command{pre: c.pre, tx: tx, alen: c.alen})
+		}
+	default:
+		return []command{c}
+	}
+	return cmds
+}
+
+// access returns the range the command reads, or erases or programs if
+// write is set, if it does.
+func (c *command) access() (off int64, n int64, write bool, ok bool) {
+	addr, ok := c.address()
+	if !ok {
+		return 0, 0, false, false
+	}
+	switch c.tx[0] {
+	case op.Read:
+		return addr, int64(len(c.rx)), false, true
+	case op.PageProgram:
+		return addr, int64(len(c.tx) - 1 - c.addrLen()), true, true
+	case op.SectorErase:
+		return addr &^ 0xfff, 0x1000, true, true
+	case op.BlockErase32:
+		return addr &^ 0x7fff, 0x8000, true, true
+	case op.BlockErase:
+		return addr &^ 0xffff, 0x10000, true, true
+	}
+	return 0, 0, false, false
+}
+
+// poll waits until done returns true.
+func poll(done func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("chipset: timed out after %v", timeout)
+		}
+	}
+}
+
+// le32 is for filling data registers 4 bytes at a time.
+func le32(b []byte) uint32 {
+	var w [4]byte
+	copy(w[:], b)
+	return binary.LittleEndian.Uint32(w[:])
+}
@@ -0,0 +1,42 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chipset
+
+import (
+	"github.com/u-root/u-root/pkg/memio"
+)
+
+// memPath is the physical memory.
+var memPath = "/dev/mem"
+
+// MMIO are registers in physical memory.
+type MMIO struct {
+	m    *memio.MMap
+	base int64
+}
+
+// Map returns the registers at the physical address base.
+func Map(base int64) (*MMIO, error) {
+	m, err := memio.NewMMap(memPath)
+	if err != nil {
+		return nil, err
+	}
+	return &MMIO{m: m, base: base}, nil
+}
+
+// ReadAt implements Registers.
+func (r *MMIO) ReadAt(off int64, data memio.UintN) error {
+	return r.m.ReadAt(r.base+off, data)
+}
+
+// WriteAt implements Registers.
+func (r *MMIO) WriteAt(off int64, data memio.UintN) error {
+	return r.m.WriteAt(r.base+off, data)
+}
+
+// Close closes the physical memory.
+func (r *MMIO) Close() error {
+	return r.m.Close()
+}
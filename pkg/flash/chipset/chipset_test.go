@@ -0,0 +1,141 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chipset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/flash/op"
+	"github.com/u-root/u-root/pkg/flash/spimock"
+	"github.com/u-root/u-root/pkg/memio"
+	"github.com/u-root/u-root/pkg/spidev"
+)
+
+// regFile holds the registers of a simulated controller.
+type regFile struct {
+	b [0x100]byte
+}
+
+func (r *regFile) get8(off int64) uint8   { return r.b[off] }
+func (r *regFile) get16(off int64) uint16 { return binary.LittleEndian.Uint16(r.b[off:]) }
+func (r *regFile) get32(off int64) uint32 { return binary.LittleEndian.Uint32(r.b[off:]) }
+
+func (r *regFile) put(off int64, size int, v uint32) {
+	for i := 0; i < size; i++ {
+		r.b[off+int64(i)] = byte(v >> (8 * i))
+	}
+}
+
+func (r *regFile) ReadAt(off int64, data memio.UintN) error {
+	switch d := data.(type) {
+	case *memio.Uint8:
+		*d = memio.Uint8(r.get8(off))
+	case *memio.Uint16:
+		*d = memio.Uint16(r.get16(off))
+	case *memio.Uint32:
+		*d = memio.Uint32(r.get32(off))
+	default:
+		return fmt.Errorf("unsupported read of %T", data)
+	}
+	return nil
+}
+
+// value returns the value and size of a write.
+func value(data memio.UintN) (uint32, int, error) {
+	switch d := data.(type) {
+	case *memio.Uint8:
+		return uint32(*d), 1, nil
+	case *memio.Uint16:
+		return uint32(*d), 2, nil
+	case *memio.Uint32:
+		return uint32(*d), 4, nil
+	}
+	return 0, 0, fmt.Errorf("unsupported write of %T", data)
+}
+
+// smallSFDP returns the SFDP of spimock with a density of 1MiB, which
+// needs no 4-byte addresses.
+func smallSFDP() []byte {
+	s := append([]byte{}, spimock.FakeSFDP...)
+	binary.LittleEndian.PutUint32(s[0x34:], 1<<23-1)
+	return s
+}
+
+func TestCommands(t *testing.T) {
+	rx := make([]byte, 10)
+	cmds, err := commands([]spidev.Transfer{
+		{Tx: []byte{op.WriteEnable}, CSChange: true},
+		{Tx: []byte{op.PageProgram, 1, 2, 3}},
+		{Tx: []byte{0xa, 0xb, 0xc}, CSChange: true},
+		{Tx: []byte{op.Read, 0, 0, 0x10}},
+		{Rx: rx},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []command{
+		{pre: op.WriteEnable, tx: []byte{op.PageProgram, 1, 2, 3, 0xa, 0xb, 0xc}},
+		{tx: []byte{op.Read, 0, 0, 0x10}, rx: rx},
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %+v, want %+v", cmds, want)
+	}
+
+	split := want[0].split(2)
+	if len(split) != 2 || !bytes.Equal(split[1].tx, []byte{op.PageProgram, 1, 2, 5, 0xc}) || split[1].pre != op.WriteEnable {
+		t.Errorf("split(2) of a page program = %+v", split)
+	}
+	split = want[1].split(4)
+	if len(split) != 3 || !bytes.Equal(split[2].tx, []byte{op.Read, 0, 0, 0x18}) || len(split[2].rx) != 2 {
+		t.Errorf("split(4) of a read = %+v", split)
+	}
+	four := command{tx: []byte{op.Read, 1, 0, 0, 0}, rx: rx, alen: 4}
+	split = four.split(8)
+	if len(split) != 2 || !bytes.Equal(split[1].tx, []byte{op.Read, 1, 0, 0, 8}) {
+		t.Errorf("split(8) of a 4-byte read = %+v", split)
+	}
+
+	for _, tt := range [][]spidev.Transfer{
+		{{Tx: []byte{1}, Rx: []byte{1}}},
+		{{Rx: rx}},
+		{{Tx: []byte{op.Read}}, {Rx: rx}, {Rx: rx}},
+		{{Tx: []byte{op.Read}}, {Rx: rx}, {Tx: []byte{1}}},
+	} {
+		if _, err := commands(tt); err == nil {
+			t.Errorf("commands(%+v) succeeded", tt)
+		}
+	}
+}
+
+func TestCheckRanges(t *testing.T) {
+	ranges := []ProtectedRange{
+		{Base: 0x1000, Limit: 0x1fff, Write: true},
+		{Base: 0x3000, Limit: 0x3fff, Read: true},
+	}
+	for _, tt := range []struct {
+		off, n int64
+		write  bool
+		err    bool
+	}{
+		{0, 0x1000, true, false},
+		{0xfff, 2, true, true},
+		{0x1000, 0x1000, false, false},
+		{0x2000, 0x2000, true, false},
+		{0x2fff, 2, false, true},
+	} {
+		err := checkRanges(ranges, tt.off, tt.n, tt.write)
+		if tt.err != errors.Is(err, ErrProtected) {
+			t.Errorf("checkRanges(%#x, %#x, %v) = %v, want protected %v", tt.off, tt.n, tt.write, err, tt.err)
+		}
+	}
+	if s := ranges[0].String(); s != "0x00001000-0x00001fff is write protected" {
+		t.Errorf("String() = %q", s)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chipset
+
+import (
+	"fmt"
+
+	"github.com/u-root/u-root/pkg/flash/op"
+	"github.com/u-root/u-root/pkg/spidev"
+)
+
+// Registers of the SPI100 controller.
+const (
+	regCmdCode     = 0x45
+	regCmdTrigger  = 0x47
+	regTxByteCount = 0x48
+	regRxByteCount = 0x4b
+	regSPIStatus   = 0x4c
+	regFIFO        = 0x80
+)
+
+const (
+	cmdTriggerExec = 1 << 7
+	spiBusy        = 1 << 31
+)
+
+// fifoSize is the size of the FIFO, for the data written and read.
+const fifoSize = 71
+
+// Bits of the RomProtect registers.
+const (
+	romProtectWP     = 1 << 9
+	romProtectRP     = 1 << 10
+	romProtectUnit64 = 1 << 11
+)
+
+// ROMProtect decodes a RomProtect register from the config space of the LPC
+// bridge, 00:14.3, at 0x50 to 0x5c.
+func ROMProtect(v uint32) ProtectedRange {
+	unit := int64(0x1000)
+	if v&romProtectUnit64 != 0 {
+		unit = 0x10000
+	}
+	base := int64(v &^ 0xfff)
+	return ProtectedRange{
+		Base:  base,
+		Limit: base + (int64(v&0xff)+1)*unit - 1,
+		Read:  v&romProtectRP != 0,
+		Write: v&romProtectWP != 0,
+	}
+}
+
+// FCH is the SPI100 controller of AMD FCH chipsets. It sends commands
+// through a FIFO, and can be used with flash.New.
+type FCH struct {
+	regs   Registers
+	ranges []ProtectedRange
+	is4ba  bool
+}
+
+// NewFCH returns the controller with the registers regs. romProtect are the
+// RomProtect registers of the LPC bridge.
+func NewFCH(regs Registers, romProtect []uint32) *FCH {
+	c := &FCH{regs: regs}
+	for _, v := range romProtect {
+		if p := ROMProtect(v); p.Read || p.Write {
+			c.ranges = append(c.ranges, p)
+		}
+	}
+	return c
+}
+
+// ProtectedRanges returns the enabled protected ranges.
+func (c *FCH) ProtectedRanges() []ProtectedRange {
+	return c.ranges
+}
+
+// busy waits for the controller to be idle.
+func (c *FCH) busy() error {
+	return poll(func() (bool, error) {
+		s, err := read32(c.regs, regSPIStatus)
+		return s&spiBusy == 0, err
+	})
+}
+
+// exec runs a command.
+func (c *FCH) exec(cmd command) error {
+	if cmd.pre != 0 {
+		if err := c.exec(command{tx: []byte{cmd.pre}}); err != nil {
+			return err
+		}
+	}
+	tx := cmd.tx[1:]
+	if len(tx)+len(cmd.rx) > fifoSize {
+		return fmt.Errorf("chipset: opcode %#02x with %d bytes is too large for the FIFO", cmd.tx[0], len(tx)+len(cmd.rx))
+	}
+	if err := c.busy(); err != nil {
+		return err
+	}
+	if err := write8(c.regs, regCmdCode, cmd.tx[0]); err != nil {
+		return err
+	}
+	if err := write8(c.regs, regTxByteCount, uint8(len(tx))); err != nil {
+		return err
+	}
+	if err := write8(c.regs, regRxByteCount, uint8(len(cmd.rx))); err != nil {
+		return err
+	}
+	for i, b := range tx {
+		if err := write8(c.regs, regFIFO+int64(i), b); err != nil {
+			return err
+		}
+	}
+	if err := write8(c.regs, regCmdTrigger, cmdTriggerExec); err != nil {
+		return err
+	}
+	if err := c.busy(); err != nil {
+		return err
+	}
+	// The data read follows the data written in the FIFO.
+	for i := range cmd.rx {
+		b, err := read8(c.regs, regFIFO+int64(len(tx)+i))
+		if err != nil {
+			return err
+		}
+		cmd.rx[i] = b
+	}
+	return nil
+}
+
+// Transfer implements flash.SPI.
+func (c *FCH) Transfer(transfers []spidev.Transfer) error {
+	cmds, err := commands(transfers)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if c.is4ba {
+			cmd.alen = 4
+		}
+		if off, n, write, ok := cmd.access(); ok {
+			if err := checkRanges(c.ranges, off, n, write); err != nil {
+				return err
+			}
+		}
+		max := fifoSize - (len(cmd.tx) - 1)
+		if cmd.tx[0] == op.PageProgram {
+			max = fifoSize - cmd.addrLen()
+		}
+		for _, cmd := range cmd.split(max) {
+			if err := c.exec(cmd); err != nil {
+				return err
+			}
+		}
+		switch cmd.tx[0] {
+		case op.Enter4BA:
+			c.is4ba = true
+		case op.Exit4BA:
+			c.is4ba = false
+		}
+	}
+	return nil
+}
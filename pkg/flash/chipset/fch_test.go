@@ -0,0 +1,101 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chipset
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/flash"
+	"github.com/u-root/u-root/pkg/flash/spimock"
+	"github.com/u-root/u-root/pkg/memio"
+	"github.com/u-root/u-root/pkg/spidev"
+)
+
+// fchSim simulates the SPI100 controller of an AMD chipset with a spimock
+// chip.
+type fchSim struct {
+	regFile
+	chip *spimock.MockSPI
+}
+
+func (s *fchSim) WriteAt(off int64, data memio.UintN) error {
+	v, size, err := value(data)
+	if err != nil {
+		return err
+	}
+	s.put(off, size, v)
+	if off != regCmdTrigger || v&cmdTriggerExec == 0 {
+		return nil
+	}
+	n := int64(s.get8(regTxByteCount))
+	tx := append([]byte{s.get8(regCmdCode)}, s.b[regFIFO:regFIFO+n]...)
+	transfers := []spidev.Transfer{{Tx: tx}}
+	rx := make([]byte, s.get8(regRxByteCount))
+	if len(rx) != 0 {
+		transfers = append(transfers, spidev.Transfer{Rx: rx})
+	}
+	if err := s.chip.Transfer(transfers); err != nil {
+		return err
+	}
+	copy(s.b[regFIFO+n:], rx)
+	s.put(regCmdTrigger, 1, 0)
+	return nil
+}
+
+func TestROMProtect(t *testing.T) {
+	for _, tt := range []struct {
+		v    uint32
+		want ProtectedRange
+	}{
+		{0xfff00000 | romProtectWP | 0xf, ProtectedRange{Base: 0xfff00000, Limit: 0xfff0ffff, Write: true}},
+		{0x00010000 | romProtectUnit64 | romProtectRP | 1, ProtectedRange{Base: 0x10000, Limit: 0x2ffff, Read: true}},
+	} {
+		if got := ROMProtect(tt.v); got != tt.want {
+			t.Errorf("ROMProtect(%#x) = %+v, want %+v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestFCH(t *testing.T) {
+	s := &fchSim{chip: spimock.New()}
+	c := NewFCH(s, []uint32{0, 0x03ff0000 | romProtectUnit64 | romProtectWP})
+	if p := c.ProtectedRanges(); len(p) != 1 {
+		t.Errorf("ProtectedRanges() = %v, want one", p)
+	}
+
+	// The 64MiB chip needs 4-byte addresses.
+	f, err := flash.New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.chip.Is4BA || f.Size() != spimock.FakeSize {
+		t.Fatalf("chip in 4-byte mode %v of size %#x, want true and %#x", s.chip.Is4BA, f.Size(), spimock.FakeSize)
+	}
+
+	copy(s.chip.Data[0x2000000:], bytes.Repeat([]byte{0x5a}, 0x1000))
+	if _, err := f.EraseAt(0x1000, 0x2000000); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("u-root"), 100)
+	if _, err := f.WriteAt(data, 0x2000100); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len(data)+1)
+	if _, err := f.ReadAt(b, 0x2000100); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:len(data)], data) || b[len(data)] != 0xff {
+		t.Errorf("read %q after writing %q", b, data)
+	}
+
+	if _, err := f.EraseAt(0x1000, 0x3ff0000); !errors.Is(err, ErrProtected) {
+		t.Errorf("EraseAt of the protected range = %v, want %v", err, ErrProtected)
+	}
+	if err := c.exec(command{tx: make([]byte, 40), rx: make([]byte, 40)}); err == nil {
+		t.Errorf("exec of a command larger than the FIFO succeeded")
+	}
+}
@@ -0,0 +1,589 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chipset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/u-root/u-root/pkg/flash/ifd"
+	"github.com/u-root/u-root/pkg/flash/op"
+	"github.com/u-root/u-root/pkg/spidev"
+)
+
+// Generation is the generation of an Intel SPI controller.
+type Generation int
+
+const (
+	// ICH9 is the controller of the ICH9 up to the 9 series PCH. Its
+	// registers are at RCBA+0x3800.
+	ICH9 Generation = iota
+	// PCH100 is the controller of the 100 series PCH (Skylake) and later.
+	// Its registers are at BAR0 of the SPI device, 00:1f.5.
+	PCH100
+)
+
+// String returns the name of the generation.
+func (g Generation) String() string {
+	switch g {
+	case ICH9:
+		return "ICH9"
+	case PCH100:
+		return "PCH100"
+	}
+	return fmt.Sprintf("Generation(%d)", int(g))
+}
+
+// Registers common to all generations.
+const (
+	regHSFS  = 0x04
+	regHSFC  = 0x06
+	regFADDR = 0x08
+	regFDATA = 0x10
+	regFRAP  = 0x50
+	regFREG  = 0x54
+)
+
+// Bits of HSFS, the hardware sequencing flash status.
+const (
+	hsfsFDONE   = 1 << 0
+	hsfsFCERR   = 1 << 1
+	hsfsAEL     = 1 << 2
+	hsfsSCIP    = 1 << 5
+	hsfsFDV     = 1 << 14
+	hsfsFLOCKDN = 1 << 15
+)
+
+// Bits of HSFC, the hardware sequencing flash control.
+const (
+	hsfcFGO         = 1 << 0
+	hsfcFCycleShift = 1
+	hsfcFDBCShift   = 8
+)
+
+// Cycles of hardware sequencing.
+const (
+	cycleRead  = 0
+	cycleWrite = 2
+	cycleErase = 3
+)
+
+// Bits of SSFS, the software sequencing flash status, and SSFC, the control
+// that follows it, as one 32-bit register.
+const (
+	ssfsSCIP     = 1 << 0
+	ssfsFDONE    = 1 << 2
+	ssfsFCERR    = 1 << 3
+	ssfsAEL      = 1 << 4
+	ssfcSCGO     = 1 << 9
+	ssfcACS      = 1 << 10
+	ssfcSPOP     = 1 << 11
+	ssfcCOPShift = 12
+	ssfcDBCShift = 16
+	ssfcDS       = 1 << 22
+	ssfcSCF      = 7 << 24
+)
+
+// Types of opcodes in OPTYPE.
+const (
+	opTypeRead       = 0
+	opTypeWrite      = 1
+	opTypeReadAddr   = 2
+	opTypeWriteAddr  = 3
+	opTypeAddressBit = 2
+)
+
+// dataSize is the size of FDATA, the most a cycle can transfer.
+const dataSize = 64
+
+// ichLayout are the registers that moved between generations.
+type ichLayout struct {
+	fcycleMask uint16
+	regions    int
+	pr         int64
+	ssfs       int64
+	preop      int64
+	optype     int64
+	opmenu     int64
+}
+
+var ichLayouts = map[Generation]*ichLayout{
+	ICH9: {
+		fcycleMask: 0x3 << hsfcFCycleShift,
+		regions:    5,
+		pr:         0x74,
+		ssfs:       0x90,
+		preop:      0x94,
+		optype:     0x96,
+		opmenu:     0x98,
+	},
+	PCH100: {
+		fcycleMask: 0xf << hsfcFCycleShift,
+		regions:    12,
+		pr:         0x84,
+		ssfs:       0xa0,
+		preop:      0xa4,
+		optype:     0xa6,
+		opmenu:     0xa8,
+	},
+}
+
+// numPR is the number of protected range registers.
+const numPR = 5
+
+// ErrNoDescriptor is returned for hardware sequencing without a valid flash
+// descriptor.
+var ErrNoDescriptor = errors.New("hardware sequencing needs a valid flash descriptor")
+
+// ICH is the SPI controller of Intel ICH and PCH chipsets.
+//
+// ReadAt, WriteAt and EraseAt use hardware sequencing, where the controller
+// knows the flash chip from the flash descriptor. Transfer uses software
+// sequencing, which sends the opcodes of the opcode menu, so ICH can be used
+// with flash.New.
+type ICH struct {
+	regs Registers
+	gen  Generation
+	l    *ichLayout
+
+	size    int64
+	regions []ifd.Region
+	ranges  []ProtectedRange
+	frap    uint32
+}
+
+// NewICH returns the controller with the registers regs.
+func NewICH(regs Registers, gen Generation) (*ICH, error) {
+	l, ok := ichLayouts[gen]
+	if !ok {
+		return nil, fmt.Errorf("chipset: unknown generation %v", gen)
+	}
+	c := &ICH{regs: regs, gen: gen, l: l}
+
+	hsfs, err := read16(regs, regHSFS)
+	if err != nil {
+		return nil, err
+	}
+	if c.frap, err = read32(regs, regFRAP); err != nil {
+		return nil, err
+	}
+	if hsfs&hsfsFDV != 0 {
+		for i := 0; i < l.regions; i++ {
+			v, err := read32(regs, regFREG+int64(i)*4)
+			if err != nil {
+				return nil, err
+			}
+			r := ifd.Region{
+				Index: i,
+				Name:  ifd.RegionNames[i],
+				Base:  int64(v&0x7fff) << 12,
+				Limit: int64(v>>16&0x7fff)<<12 | 0xfff,
+			}
+			c.regions = append(c.regions, r)
+			if r.Used() && r.Limit+1 > c.size {
+				c.size = r.Limit + 1
+			}
+		}
+	}
+	for i := 0; i < numPR; i++ {
+		v, err := read32(regs, l.pr+int64(i)*4)
+		if err != nil {
+			return nil, err
+		}
+		p := ProtectedRange{
+			Base:  int64(v&0x7fff) << 12,
+			Limit: int64(v>>16&0x7fff)<<12 | 0xfff,
+			Read:  v&(1<<15) != 0,
+			Write: v&(1<<31) != 0,
+		}
+		if p.Read || p.Write {
+			c.ranges = append(c.ranges, p)
+		}
+	}
+	return c, nil
+}
+
+// Generation returns the generation of the controller.
+func (c *ICH) Generation() Generation {
+	return c.gen
+}
+
+// Locked returns whether the firmware locked down the configuration. The
+// protected ranges and the opcode menu cannot be changed until reset.
+func (c *ICH) Locked() (bool, error) {
+	hsfs, err := read16(c.regs, regHSFS)
+	return hsfs&hsfsFLOCKDN != 0, err
+}
+
+// Regions returns the regions of the flash descriptor, as the controller
+// sees them.
+func (c *ICH) Regions() []ifd.Region {
+	return c.regions
+}
+
+// ProtectedRanges returns the enabled protected ranges.
+func (c *ICH) ProtectedRanges() []ProtectedRange {
+	return c.ranges
+}
+
+// Size returns the size of the flash, up to the end of the last region of
+// the flash descriptor. It is 0 without a valid descriptor.
+func (c *ICH) Size() int64 {
+	return c.size
+}
+
+// checkAccess returns an ErrProtected error if the host may not read, or
+// write if write is set, the n bytes at off.
+func (c *ICH) checkAccess(off, n int64, write bool) error {
+	if err := checkRanges(c.ranges, off, n, write); err != nil {
+		return err
+	}
+	// FRAP has the access of the host to the first 8 regions.
+	for _, r := range c.regions {
+		if r.Index >= 8 || !r.Used() || off > r.Limit || off+n <= r.Base {
+			continue
+		}
+		if write && c.frap&(1<<(8+r.Index)) == 0 {
+			return fmt.Errorf("chipset: region %q is not writable by the host: %w", r.Name, ErrProtected)
+		}
+		if !write && c.frap&(1<<r.Index) == 0 {
+			return fmt.Errorf("chipset: region %q is not readable by the host: %w", r.Name, ErrProtected)
+		}
+	}
+	return nil
+}
+
+// readData reads b from FDATA.
+func (c *ICH) readData(b []byte) error {
+	for i := 0; i < len(b); i += 4 {
+		v, err := read32(c.regs, regFDATA+int64(i))
+		if err != nil {
+			return err
+		}
+		for j := 0; j < 4 && i+j < len(b); j++ {
+			b[i+j] = byte(v >> (8 * j))
+		}
+	}
+	return nil
+}
+
+// writeData writes b to FDATA.
+func (c *ICH) writeData(b []byte) error {
+	for i := 0; i < len(b); i += 4 {
+		if err := write32(c.regs, regFDATA+int64(i), le32(b[i:])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hwseq runs a hardware sequencing cycle of n bytes at addr.
+func (c *ICH) hwseq(cycle uint16, addr int64, n int) error {
+	if err := poll(func() (bool, error) {
+		s, err := read16(c.regs, regHSFS)
+		return s&hsfsSCIP == 0, err
+	}); err != nil {
+		return err
+	}
+	// Clear the status of the last cycle.
+	if err := write16(c.regs, regHSFS, hsfsFDONE|hsfsFCERR|hsfsAEL); err != nil {
+		return err
+	}
+	if err := write32(c.regs, regFADDR, uint32(addr)); err != nil {
+		return err
+	}
+	hsfc := hsfcFGO | cycle<<hsfcFCycleShift&c.l.fcycleMask | uint16(n-1)<<hsfcFDBCShift
+	if err := write16(c.regs, regHSFC, hsfc); err != nil {
+		return err
+	}
+	var s uint16
+	if err := poll(func() (bool, error) {
+		var err error
+		s, err = read16(c.regs, regHSFS)
+		return s&(hsfsFDONE|hsfsFCERR) != 0, err
+	}); err != nil {
+		return err
+	}
+	if s&(hsfsFCERR|hsfsAEL) != 0 {
+		return fmt.Errorf("chipset: cycle %d of %d bytes at %#x failed with status %#04x", cycle, n, addr, s)
+	}
+	return nil
+}
+
+// chunk returns how much of n bytes at off fit in one cycle without
+// crossing a boundary of dataSize.
+func chunk(off int64, n int) int {
+	if m := dataSize - int(off%dataSize); n > m {
+		return m
+	}
+	return n
+}
+
+// ReadAt reads from the flash with hardware sequencing.
+func (c *ICH) ReadAt(p []byte, off int64) (int, error) {
+	if c.size == 0 {
+		return 0, ErrNoDescriptor
+	}
+	if off < 0 || off > c.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.size-off {
+		p = p[:c.size-off]
+	}
+	if err := c.checkAccess(off, int64(len(p)), false); err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(p); {
+		n := chunk(off+int64(i), len(p)-i)
+		if err := c.hwseq(cycleRead, off+int64(i), n); err != nil {
+			return i, err
+		}
+		if err := c.readData(p[i : i+n]); err != nil {
+			return i, err
+		}
+		i += n
+	}
+	return len(p), nil
+}
+
+// WriteAt writes to the flash with hardware sequencing. Like flash.Flash, it
+// does not erase before writing.
+func (c *ICH) WriteAt(p []byte, off int64) (int, error) {
+	if c.size == 0 {
+		return 0, ErrNoDescriptor
+	}
+	if off < 0 || off > c.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.size-off {
+		p = p[:c.size-off]
+	}
+	if err := c.checkAccess(off, int64(len(p)), true); err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(p); {
+		n := chunk(off+int64(i), len(p)-i)
+		if err := c.writeData(p[i : i+n]); err != nil {
+			return i, err
+		}
+		if err := c.hwseq(cycleWrite, off+int64(i), n); err != nil {
+			return i, err
+		}
+		i += n
+	}
+	return len(p), nil
+}
+
+// SectorSize returns the size of erases with hardware sequencing.
+func (c *ICH) SectorSize() int64 {
+	return 0x1000
+}
+
+// EraseAt erases n bytes from offset off with hardware sequencing. Both must
+// be multiples of 4KiB.
+func (c *ICH) EraseAt(n int64, off int64) (int64, error) {
+	if c.size == 0 {
+		return 0, ErrNoDescriptor
+	}
+	if off < 0 || off+n > c.size {
+		return 0, io.EOF
+	}
+	if off%0x1000 != 0 || n%0x1000 != 0 {
+		return 0, fmt.Errorf("chipset: erase of %#x bytes at %#x is not 4KiB aligned", n, off)
+	}
+	if err := c.checkAccess(off, n, true); err != nil {
+		return 0, err
+	}
+	for i := int64(0); i < n; i += 0x1000 {
+		if err := c.hwseq(cycleErase, off+i, 1); err != nil {
+			return i, err
+		}
+	}
+	return n, nil
+}
+
+// addressed returns whether the opcode is followed by a 3-byte address.
+func addressed(o byte) bool {
+	switch o {
+	case op.Read, op.ReadSFDP, op.PageProgram, op.SectorErase, op.BlockErase32, op.BlockErase:
+		return true
+	}
+	return false
+}
+
+// opcode returns the index of o with type typ in the opcode menu. The menu
+// is changed if it is not locked.
+func (c *ICH) opcode(o byte, typ uint16) (int, error) {
+	lo, err := read32(c.regs, c.l.opmenu)
+	if err != nil {
+		return 0, err
+	}
+	hi, err := read32(c.regs, c.l.opmenu+4)
+	if err != nil {
+		return 0, err
+	}
+	menu := uint64(hi)<<32 | uint64(lo)
+	types, err := read16(c.regs, c.l.optype)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < 8; i++ {
+		if byte(menu>>(8*i)) == o && types>>(2*i)&3 == typ {
+			return i, nil
+		}
+	}
+	locked, err := c.Locked()
+	if err != nil {
+		return 0, err
+	}
+	if locked {
+		return 0, fmt.Errorf("chipset: opcode %#02x of type %d is not in the locked opcode menu", o, typ)
+	}
+	// Use the last entry.
+	const i = 7
+	menu = menu&^(0xff<<(8*i)) | uint64(o)<<(8*i)
+	types = types&^(3<<(2*i)) | typ<<(2*i)
+	if err := write32(c.regs, c.l.opmenu+4, uint32(menu>>32)); err != nil {
+		return 0, err
+	}
+	if err := write16(c.regs, c.l.optype, types); err != nil {
+		return 0, err
+	}
+	return i, nil
+}
+
+// preop returns the index of o in the prefix opcodes. They are changed if
+// they are not locked.
+func (c *ICH) preop(o byte) (int, error) {
+	pre, err := read16(c.regs, c.l.preop)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < 2; i++ {
+		if byte(pre>>(8*i)) == o {
+			return i, nil
+		}
+	}
+	locked, err := c.Locked()
+	if err != nil {
+		return 0, err
+	}
+	if locked {
+		return 0, fmt.Errorf("chipset: prefix opcode %#02x is not in the locked prefix opcodes", o)
+	}
+	return 0, write16(c.regs, c.l.preop, pre&0xff00|uint16(o))
+}
+
+// swseq runs a command with software sequencing.
+func (c *ICH) swseq(cmd command) error {
+	o := cmd.tx[0]
+	typ := uint16(opTypeWrite)
+	if cmd.rx != nil {
+		typ = opTypeRead
+	}
+	var addr int64
+	// data is written, or read followed by rx. Bytes after the address
+	// of a read, such as the dummy byte of ReadSFDP, are read instead and
+	// dropped.
+	data, skip := cmd.tx[1:], 0
+	if a, ok := cmd.address(); ok && addressed(o) {
+		typ |= opTypeAddressBit
+		addr, data = a, cmd.tx[4:]
+	}
+	if cmd.rx != nil {
+		if len(data) != 0 && typ&opTypeAddressBit == 0 {
+			return fmt.Errorf("chipset: opcode %#02x cannot write and read", o)
+		}
+		skip = len(data)
+		data = make([]byte, skip+len(cmd.rx))
+	}
+	if len(data) > dataSize {
+		return fmt.Errorf("chipset: opcode %#02x with %d bytes of data is too large", o, len(data))
+	}
+
+	idx, err := c.opcode(o, typ)
+	if err != nil {
+		return err
+	}
+	ssfc := uint32(ssfcSCGO | idx<<ssfcCOPShift)
+	if cmd.pre != 0 {
+		p, err := c.preop(cmd.pre)
+		if err != nil {
+			return err
+		}
+		ssfc |= ssfcACS | uint32(p)*ssfcSPOP
+	}
+	if len(data) != 0 {
+		ssfc |= ssfcDS | uint32(len(data)-1)<<ssfcDBCShift
+	}
+
+	if err := poll(func() (bool, error) {
+		s, err := read8(c.regs, c.l.ssfs)
+		return s&ssfsSCIP == 0, err
+	}); err != nil {
+		return err
+	}
+	if err := write32(c.regs, regFADDR, uint32(addr)); err != nil {
+		return err
+	}
+	if cmd.rx == nil {
+		if err := c.writeData(data); err != nil {
+			return err
+		}
+	}
+	v, err := read32(c.regs, c.l.ssfs)
+	if err != nil {
+		return err
+	}
+	// Clear the status of the last cycle while starting this one.
+	if err := write32(c.regs, c.l.ssfs, v&ssfcSCF|ssfsFDONE|ssfsFCERR|ssfsAEL|ssfc); err != nil {
+		return err
+	}
+	var s uint8
+	if err := poll(func() (bool, error) {
+		var err error
+		s, err = read8(c.regs, c.l.ssfs)
+		return s&(ssfsFDONE|ssfsFCERR) != 0, err
+	}); err != nil {
+		return err
+	}
+	if s&(ssfsFCERR|ssfsAEL) != 0 {
+		return fmt.Errorf("chipset: opcode %#02x failed with status %#02x", o, s)
+	}
+	if cmd.rx != nil {
+		if err := c.readData(data); err != nil {
+			return err
+		}
+		copy(cmd.rx, data[skip:])
+	}
+	return nil
+}
+
+// Transfer implements flash.SPI with software sequencing. Commands must be
+// in the opcode menu if it is locked and have 3-byte addresses.
+func (c *ICH) Transfer(transfers []spidev.Transfer) error {
+	cmds, err := commands(transfers)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if off, n, write, ok := cmd.access(); ok {
+			if err := c.checkAccess(off, n, write); err != nil {
+				return err
+			}
+		}
+		max := dataSize
+		if len(cmd.tx) > 4 && cmd.rx != nil {
+			max -= len(cmd.tx) - 4
+		}
+		for _, cmd := range cmd.split(max) {
+			if err := c.swseq(cmd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chipset
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/flash"
+	"github.com/u-root/u-root/pkg/flash/op"
+	"github.com/u-root/u-root/pkg/flash/spimock"
+	"github.com/u-root/u-root/pkg/memio"
+	"github.com/u-root/u-root/pkg/spidev"
+)
+
+// ichSim simulates the SPI controller of an Intel chipset with a spimock
+// chip.
+type ichSim struct {
+	regFile
+	l      *ichLayout
+	chip   *spimock.MockSPI
+	locked bool
+}
+
+// The default opcode menu, as firmware sets it up.
+var (
+	simMenu  = []byte{op.Read, op.PageProgram, op.SectorErase, op.ReadStatus, op.ReadJEDECID, 0x01, op.BlockErase, 0}
+	simTypes = []uint16{opTypeReadAddr, opTypeWriteAddr, opTypeWriteAddr, opTypeRead, opTypeRead, opTypeWrite, opTypeWriteAddr, 0}
+)
+
+// newICHSim returns a controller with a 1MiB flash of a descriptor region and
+// a BIOS region, which the host may read and write.
+func newICHSim(gen Generation) *ichSim {
+	s := &ichSim{l: ichLayouts[gen], chip: spimock.New()}
+	s.chip.SFDP = smallSFDP()
+	s.put(regHSFS, 2, hsfsFDV)
+	s.put(regFREG, 4, 0x00000000)
+	s.put(regFREG+4, 4, 0x00ff0001)
+	for i := int64(2); i < int64(s.l.regions); i++ {
+		s.put(regFREG+i*4, 4, 0x00007fff)
+	}
+	s.put(regFRAP, 4, 0xffff)
+	s.put(s.l.preop, 2, uint16Pair(op.WriteEnable, 0x50))
+	var types uint16
+	for i, o := range simMenu {
+		s.b[s.l.opmenu+int64(i)] = o
+		types |= simTypes[i] << (2 * i)
+	}
+	s.put(s.l.optype, 2, uint32(types))
+	return s
+}
+
+func uint16Pair(a, b byte) uint32 {
+	return uint32(a) | uint32(b)<<8
+}
+
+// lock locks down the configuration.
+func (s *ichSim) lock() {
+	s.locked = true
+	s.put(regHSFS, 2, uint32(s.get16(regHSFS)|hsfsFLOCKDN))
+}
+
+func (s *ichSim) WriteAt(off int64, data memio.UintN) error {
+	v, size, err := value(data)
+	if err != nil {
+		return err
+	}
+	switch {
+	case off == regHSFS && size == 2:
+		s.put(off, 2, uint32(s.get16(off))&^(v&(hsfsFDONE|hsfsFCERR|hsfsAEL)))
+	case off == regHSFC && size == 2:
+		s.put(off, 2, v)
+		if v&hsfcFGO != 0 {
+			s.hwseq(uint16(v))
+		}
+	case off == s.l.ssfs && size == 4:
+		status := uint32(s.get8(off)) &^ (v & (ssfsFDONE | ssfsFCERR | ssfsAEL))
+		s.put(off, 4, v&^0xff|status)
+		if v&ssfcSCGO != 0 {
+			s.swseq(v)
+		}
+	case s.locked && off >= s.l.pr && off < s.l.opmenu+8:
+		// Locked down until reset.
+	default:
+		s.put(off, size, v)
+	}
+	return nil
+}
+
+func (s *ichSim) setStatus(off int64, bit uint32) {
+	s.put(off, 1, uint32(s.get8(off))|bit)
+}
+
+func (s *ichSim) hwseq(hsfc uint16) {
+	cycle := hsfc & s.l.fcycleMask >> hsfcFCycleShift
+	n := int64(hsfc>>hsfcFDBCShift&0x3f) + 1
+	addr := int64(s.get32(regFADDR))
+	if addr+n > int64(len(s.chip.Data)) {
+		s.setStatus(regHSFS, hsfsFCERR)
+		return
+	}
+	switch cycle {
+	case cycleRead:
+		copy(s.b[regFDATA:], s.chip.Data[addr:addr+n])
+	case cycleWrite:
+		for i := int64(0); i < n; i++ {
+			s.chip.Data[addr+i] &= s.b[regFDATA+i]
+		}
+	case cycleErase:
+		addr &^= 0xfff
+		copy(s.chip.Data[addr:], bytes.Repeat([]byte{0xff}, 0x1000))
+	default:
+		s.setStatus(regHSFS, hsfsFCERR)
+		return
+	}
+	s.setStatus(regHSFS, hsfsFDONE)
+}
+
+func (s *ichSim) swseq(ssfc uint32) {
+	cop := int64(ssfc >> ssfcCOPShift & 7)
+	o := s.b[s.l.opmenu+cop]
+	typ := s.get16(s.l.optype) >> (2 * cop) & 3
+	var n int
+	if ssfc&ssfcDS != 0 {
+		n = int(ssfc>>ssfcDBCShift&0x3f) + 1
+	}
+	if ssfc&ssfcACS != 0 {
+		pre := s.b[s.l.preop+int64(ssfc&ssfcSPOP/ssfcSPOP)]
+		if err := s.chip.Transfer([]spidev.Transfer{{Tx: []byte{pre}}}); err != nil {
+			s.setStatus(s.l.ssfs, ssfsFCERR)
+			return
+		}
+	}
+	tx := []byte{o}
+	if typ&opTypeAddressBit != 0 {
+		addr := s.get32(regFADDR)
+		tx = append(tx, byte(addr>>16), byte(addr>>8), byte(addr))
+	}
+	transfers := []spidev.Transfer{{Tx: tx}}
+	var rx []byte
+	if typ&1 != 0 {
+		transfers[0].Tx = append(tx, s.b[regFDATA:regFDATA+n]...)
+	} else if n != 0 {
+		rx = make([]byte, n)
+		transfers = append(transfers, spidev.Transfer{Rx: rx})
+	}
+	if err := s.chip.Transfer(transfers); err != nil {
+		s.setStatus(s.l.ssfs, ssfsFCERR)
+		return
+	}
+	copy(s.b[regFDATA:], rx)
+	s.setStatus(s.l.ssfs, ssfsFDONE)
+}
+
+func TestICHHwseq(t *testing.T) {
+	for _, gen := range []Generation{ICH9, PCH100} {
+		t.Run(gen.String(), func(t *testing.T) {
+			s := newICHSim(gen)
+			c, err := NewICH(s, gen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Size() != 1<<20 {
+				t.Errorf("Size() = %#x, want %#x", c.Size(), 1<<20)
+			}
+			if r := c.Regions(); len(r) != s.l.regions || r[1].Name != "bios" || r[1].Base != 0x1000 || r[1].Limit != 0xfffff {
+				t.Errorf("Regions() = %+v, want the BIOS at 0x1000-0xfffff", r)
+			}
+
+			for i := range s.chip.Data[:0x4000] {
+				s.chip.Data[i] = byte(i)
+			}
+			b := make([]byte, 0x101)
+			if n, err := c.ReadAt(b, 0x1ff0); err != nil || n != len(b) || !bytes.Equal(b, s.chip.Data[0x1ff0:0x20f1]) {
+				t.Errorf("ReadAt(0x1ff0) = %d, %v with the wrong data", n, err)
+			}
+
+			if n, err := c.EraseAt(0x2000, 0x1000); err != nil || n != 0x2000 {
+				t.Fatalf("EraseAt(0x2000, 0x1000) = %#x, %v, want 0x2000, nil", n, err)
+			}
+			if s.chip.Data[0xffe] != 0xfe || s.chip.Data[0x1000] != 0xff || s.chip.Data[0x2fff] != 0xff || s.chip.Data[0x3001] != 0x01 {
+				t.Errorf("EraseAt erased the wrong sectors")
+			}
+			data := bytes.Repeat([]byte("u-root"), 50)
+			if n, err := c.WriteAt(data, 0x1020); err != nil || n != len(data) {
+				t.Fatalf("WriteAt = %d, %v", n, err)
+			}
+			if !bytes.Equal(s.chip.Data[0x1020:0x1020+len(data)], data) || s.chip.Data[0x101f] != 0xff {
+				t.Errorf("WriteAt wrote the wrong data")
+			}
+
+			if _, err := c.EraseAt(0x1000, 0x800); err == nil {
+				t.Errorf("unaligned EraseAt succeeded")
+			}
+			if n, err := c.ReadAt(b, c.Size()-1); err != nil || n != 1 {
+				t.Errorf("ReadAt(Size()-1) = %d, %v, want 1, nil", n, err)
+			}
+			// 0x1020 is written over, so its sector is erased.
+			if _, err := flash.Program(c, []byte("flash"), 0x1021); err != nil {
+				t.Errorf("flash.Program = %v", err)
+			}
+		})
+	}
+}
+
+func TestICHNoDescriptor(t *testing.T) {
+	s := newICHSim(PCH100)
+	s.put(regHSFS, 2, 0)
+	c, err := NewICH(s, PCH100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadAt(make([]byte, 1), 0); !errors.Is(err, ErrNoDescriptor) {
+		t.Errorf("ReadAt = %v, want %v", err, ErrNoDescriptor)
+	}
+}
+
+func TestICHProtected(t *testing.T) {
+	s := newICHSim(PCH100)
+	// The host may read but not write the descriptor, and the last 64KiB
+	// are write protected.
+	s.put(regFRAP, 4, 0xfe03)
+	s.put(s.l.pr, 4, 1<<31|0xff<<16|0xf0)
+	s.lock()
+	c, err := NewICH(s, PCH100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := c.ProtectedRanges(); len(p) != 1 || p[0].Base != 0xf0000 || p[0].Limit != 0xfffff || !p[0].Write || p[0].Read {
+		t.Errorf("ProtectedRanges() = %v, want 0xf0000-0xfffff write protected", p)
+	}
+	if locked, err := c.Locked(); err != nil || !locked {
+		t.Errorf("Locked() = %v, %v, want true, nil", locked, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"read descriptor", func() error { _, err := c.ReadAt(make([]byte, 16), 0); return err }()},
+		{"erase BIOS", func() error { _, err := c.EraseAt(0x1000, 0x1000); return err }()},
+		{"read protected range", func() error { _, err := c.ReadAt(make([]byte, 16), 0xffff0); return err }()},
+	} {
+		if tt.err != nil {
+			t.Errorf("%s = %v, want nil", tt.name, tt.err)
+		}
+	}
+	for _, tt := range []struct {
+		name string
+		err  error
+		msg  string
+	}{
+		{"write descriptor", func() error { _, err := c.WriteAt([]byte{0}, 0x10); return err }(), `region "fd" is not writable`},
+		{"erase protected range", func() error { _, err := c.EraseAt(0x1000, 0xff000); return err }(), "0xf0000-0xfffff is protected"},
+		{"write protected range", func() error { _, err := c.WriteAt([]byte{0}, 0xfffff); return err }(), "is protected"},
+	} {
+		if !errors.Is(tt.err, ErrProtected) || !strings.Contains(tt.err.Error(), tt.msg) {
+			t.Errorf("%s = %v, want a protected error containing %q", tt.name, tt.err, tt.msg)
+		}
+	}
+	if s.chip.Data[0x10] != 0 || s.chip.Data[0xff000] != 0 {
+		t.Errorf("protected bytes were changed")
+	}
+}
+
+func TestICHSwseq(t *testing.T) {
+	for _, gen := range []Generation{ICH9, PCH100} {
+		t.Run(gen.String(), func(t *testing.T) {
+			s := newICHSim(gen)
+			c, err := NewICH(s, gen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The firmware did not lock the opcode menu, so ReadSFDP
+			// is added to it.
+			f, err := flash.New(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.b[s.l.opmenu+7] != op.ReadSFDP {
+				t.Errorf("opcode menu = %#x, want ReadSFDP in the last entry", s.b[s.l.opmenu:s.l.opmenu+8])
+			}
+			if f.Size() != 1<<20 {
+				t.Errorf("Size() = %#x, want %#x", f.Size(), 1<<20)
+			}
+			if id, err := f.ReadJEDECID(); err != nil || id != 0xc2201a {
+				t.Errorf("ReadJEDECID() = %#x, %v, want 0xc2201a, nil", id, err)
+			}
+
+			copy(s.chip.Data[0x2000:], bytes.Repeat([]byte{0x5a}, 0x1000))
+			if n, err := f.EraseAt(0x1000, 0x2000); err != nil || n != 0x1000 {
+				t.Fatalf("EraseAt = %#x, %v", n, err)
+			}
+			data := bytes.Repeat([]byte("u-root"), 100)
+			if _, err := f.WriteAt(data, 0x2100); err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, len(data)+1)
+			if _, err := f.ReadAt(b, 0x2100); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b[:len(data)], data) || b[len(data)] != 0xff {
+				t.Errorf("read %q after writing %q", b, data)
+			}
+		})
+	}
+}
+
+func TestICHSwseqLocked(t *testing.T) {
+	s := newICHSim(ICH9)
+	s.lock()
+	c, err := NewICH(s, ICH9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := flash.New(c); err == nil || !strings.Contains(err.Error(), "not in the locked opcode menu") {
+		t.Errorf("flash.New = %v, want ReadSFDP not in the locked opcode menu", err)
+	}
+	rx := make([]byte, 3)
+	if err := c.Transfer([]spidev.Transfer{{Tx: []byte{op.ReadJEDECID}}, {Rx: rx}}); err != nil || !bytes.Equal(rx, []byte{0xc2, 0x20, 0x1a}) {
+		t.Errorf("ReadJEDECID = %#x, %v, want 0xc2201a, nil", rx, err)
+	}
+	s.put(regFRAP, 4, 0x00ff)
+	c, err = NewICH(s, ICH9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Transfer([]spidev.Transfer{{Tx: []byte{op.WriteEnable}, CSChange: true}, {Tx: []byte{op.SectorErase, 0, 0x10, 0}}})
+	if !errors.Is(err, ErrProtected) {
+		t.Errorf("SectorErase of a read-only BIOS = %v, want %v", err, ErrProtected)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/u-root/u-root/pkg/flash/op"
 	"github.com/u-root/u-root/pkg/flash/sfdp"
@@ -19,6 +20,23 @@ import (
 // sfdpMaxAddress is the highest possible SFDP address (24 bit address space).
 const sfdpMaxAddress = (1 << 24) - 1
 
+// busyTimeout is how long to wait for a program or erase to finish. Erasing
+// a 64KiB block takes up to 2 seconds on most chips.
+const busyTimeout = 10 * time.Second
+
+// Polling the status register backs off from minBusyPoll to maxBusyPoll. A
+// page program takes below a millisecond, erasing up to seconds.
+const (
+	minBusyPoll = 10 * time.Microsecond
+	maxBusyPoll = 10 * time.Millisecond
+)
+
+// defaultEraseTypes are used if the SFDP does not have erase types.
+var defaultEraseTypes = []sfdp.EraseType{
+	{Size: 4096, Opcode: op.SectorErase},
+	{Size: 65536, Opcode: op.BlockErase},
+}
+
 // SPI interface for the underlying calls to the SPI driver.
 type SPI interface {
 	Transfer(transfers []spidev.Transfer) error
@@ -41,9 +59,10 @@ type Flash struct {
 	// JEDEC ID is cached.
 	id uint32
 
-	pageSize   int64
-	sectorSize int64
-	blockSize  int64
+	pageSize int64
+
+	// eraseTypes are sorted from the smallest size to the largest.
+	eraseTypes []sfdp.EraseType
 }
 
 // New creates a new flash device from a SPI interface.
@@ -67,15 +86,21 @@ func New(spi SPI) (*Flash, error) {
 	}
 	f.size = (density + 1) / 8
 
-	// Assume 4ba if address if size requires 4 bytes.
+	// Use 4-byte addresses if the size requires them. The chip has to be
+	// told, too.
 	if f.size >= 0x1000000 {
+		if err := f.spi.Transfer([]spidev.Transfer{{Tx: []byte{op.Enter4BA}}}); err != nil {
+			return nil, fmt.Errorf("could not enter 4-byte addressing mode: %v", err)
+		}
 		f.is4ba = true
 	}
 
-	// TODO
+	// TODO: read the page size from JESD216A tables.
 	f.pageSize = 256
-	f.sectorSize = 4096
-	f.blockSize = 65536
+	f.eraseTypes, err = f.SFDP().EraseTypes()
+	if err != nil || len(f.eraseTypes) == 0 {
+		f.eraseTypes = defaultEraseTypes
+	}
 
 	return f, nil
 }
@@ -85,6 +110,17 @@ func (f *Flash) Size() int64 {
 	return f.size
 }
 
+// SectorSize returns the size of the smallest erase.
+func (f *Flash) SectorSize() int64 {
+	return f.eraseTypes[0].Size
+}
+
+// EraseTypes returns the erase instructions, from the smallest size to the
+// largest.
+func (f *Flash) EraseTypes() []sfdp.EraseType {
+	return f.eraseTypes
+}
+
 const maxTransferSize = 4096
 
 func min(x, y int64) int64 {
@@ -137,9 +173,33 @@ func (f *Flash) writeAt(p []byte, off int64) (int, error) {
 	}); err != nil {
 		return 0, err
 	}
+	if err := f.wait(); err != nil {
+		return 0, err
+	}
 	return len(p), nil
 }
 
+// wait waits for the chip to finish programming or erasing.
+func (f *Flash) wait() error {
+	deadline := time.Now().Add(busyTimeout)
+	for poll := minBusyPoll; ; {
+		s, err := f.ReadStatus()
+		if err != nil {
+			return err
+		}
+		if s&StatusWIP == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("flash: chip still busy after %v", busyTimeout)
+		}
+		time.Sleep(poll)
+		if poll *= 2; poll > maxBusyPoll {
+			poll = maxBusyPoll
+		}
+	}
+}
+
 // WriteAt writes to the flash chip.
 //
 // For optimal performance, call this function on boundaries of pageSize.
@@ -171,8 +231,8 @@ func (f *Flash) WriteAt(p []byte, off int64) (int, error) {
 		}
 	}
 	for i := firstAlignedOff; i < lastAlignedOff; i += f.pageSize {
-		if _, err := f.writeAt(p[i:i+f.pageSize], off+i); err != nil {
-			return int(i), err
+		if _, err := f.writeAt(p[i-off:i-off+f.pageSize], i); err != nil {
+			return int(i - off), err
 		}
 	}
 	if off+int64(len(p)) != lastAlignedOff {
@@ -183,32 +243,41 @@ func (f *Flash) WriteAt(p []byte, off int64) (int, error) {
 	return len(p), nil
 }
 
+// ProgramAt erases and writes p at off, leaving the rest of the erased
+// sectors as they were. Sectors which do not change are skipped. See
+// Program.
 func (f *Flash) ProgramAt(p []byte, off int64) (int, error) {
-	// TODO
-	return 0, nil
+	if _, err := Program(f, p, off); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// eraseType returns the largest erase type which erases at off, without
+// erasing more than n bytes.
+func (f *Flash) eraseType(n int64, off int64) sfdp.EraseType {
+	t := f.eraseTypes[0]
+	for _, e := range f.eraseTypes[1:] {
+		if off%e.Size == 0 && e.Size <= n {
+			t = e
+		}
+	}
+	return t
 }
 
 // EraseAt erases n bytes from offset off. Both parameters must be aligned to
-// sectorSize.
+// the sector size. The largest erase instructions which fit are used.
 func (f *Flash) EraseAt(n int64, off int64) (int64, error) {
 	if off < 0 || off > f.size || off+n > f.size {
 		return 0, io.EOF
 	}
 
-	if (off%f.sectorSize != 0) || (n%f.sectorSize != 0) {
+	if (off%f.SectorSize() != 0) || (n%f.SectorSize() != 0) {
 		return 0, fmt.Errorf("len(p) and off must be multiple of the sector size")
 	}
 
 	for i := int64(0); i < n; {
-		opcode := op.SectorErase
-		eraseSize := f.sectorSize
-
-		// Optimization to erase faster.
-		if i%f.blockSize == 0 && n-i > f.blockSize {
-			opcode = op.BlockErase
-			eraseSize = f.blockSize
-		}
-
+		t := f.eraseType(n-i, off+i)
 		if err := f.spi.Transfer([]spidev.Transfer{
 			// Enable writing.
 			{
@@ -216,12 +285,15 @@ func (f *Flash) EraseAt(n int64, off int64) (int64, error) {
 				CSChange: true,
 			},
 			// Send the address.
-			{Tx: append([]byte{opcode}, f.prepareAddress(off+i)...)},
+			{Tx: append([]byte{t.Opcode}, f.prepareAddress(off+i)...)},
 		}); err != nil {
 			return i, err
 		}
+		if err := f.wait(); err != nil {
+			return i, err
+		}
 
-		i += eraseSize
+		i += t.Size
 	}
 	return n, nil
 }
@@ -0,0 +1,310 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fmap parses the flash map (FMAP) of coreboot and ChromeOS firmware
+// images. The FMAP names the areas of the image, such as RO_VPD or
+// RW_SECTION_A, and may be anywhere in it.
+//
+// Useful references:
+// * https://github.com/coreboot/coreboot/blob/master/util/cbfstool/fmap.h
+// * https://github.com/coreboot/coreboot/blob/master/Documentation/lib/flashmap.md
+package fmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Signature starts the FMAP.
+const Signature = "__FMAP__"
+
+// Sizes of the header and of each area in bytes.
+const (
+	headerSize = 56
+	areaSize   = 42
+)
+
+// minAlign is the smallest alignment Find tries before searching all of the
+// image.
+const minAlign = 4096
+
+// ErrNotFound is returned when there is no FMAP or area.
+var ErrNotFound = errors.New("not found")
+
+// Flags are the flags of an area.
+type Flags uint16
+
+// Area flags.
+const (
+	FlagStatic     Flags = 1 << 0
+	FlagCompressed Flags = 1 << 1
+	FlagRO         Flags = 1 << 2
+	FlagPreserve   Flags = 1 << 3
+)
+
+var flagNames = []string{"static", "compressed", "ro", "preserve"}
+
+// String returns the flags separated by commas.
+func (f Flags) String() string {
+	var s []string
+	for i, name := range flagNames {
+		if f&(1<<i) != 0 {
+			s = append(s, name)
+		}
+	}
+	if rest := f &^ (1<<len(flagNames) - 1); rest != 0 {
+		s = append(s, fmt.Sprintf("%#x", uint16(rest)))
+	}
+	return strings.Join(s, ",")
+}
+
+// header is the FMAP as stored.
+type header struct {
+	Signature [8]byte
+	VerMajor  uint8
+	VerMinor  uint8
+	Base      uint64
+	Size      uint32
+	Name      [32]byte
+	NAreas    uint16
+}
+
+// area is an area as stored.
+type area struct {
+	Offset uint32
+	Size   uint32
+	Name   [32]byte
+	Flags  Flags
+}
+
+// FMap is a flash map.
+type FMap struct {
+	VerMajor uint8
+	VerMinor uint8
+
+	// Base is the address of the image in memory.
+	Base uint64
+
+	// Size is the size of the image.
+	Size uint32
+
+	Name  string
+	Areas []Area
+}
+
+// Area is a named range of the image.
+type Area struct {
+	Offset uint32
+	Size   uint32
+	Name   string
+	Flags  Flags
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func putName(b *[32]byte, name string) error {
+	// The name must leave room for a NUL.
+	if len(name) >= len(b) {
+		return fmt.Errorf("name %q is longer than %d bytes", name, len(b)-1)
+	}
+	copy(b[:], name)
+	return nil
+}
+
+// valid returns whether b, which starts with the signature, looks like an
+// FMAP header.
+func valid(b []byte) bool {
+	return len(b) >= headerSize && string(b[:len(Signature)]) == Signature && b[8] == 1
+}
+
+// Parse parses the FMAP at the start of b.
+func Parse(b []byte) (*FMap, error) {
+	if len(b) < headerSize || string(b[:len(Signature)]) != Signature {
+		return nil, fmt.Errorf("fmap: signature %w", ErrNotFound)
+	}
+	var h header
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if h.VerMajor != 1 {
+		return nil, fmt.Errorf("fmap: unsupported version %d.%d", h.VerMajor, h.VerMinor)
+	}
+	if len(b) < headerSize+int(h.NAreas)*areaSize {
+		return nil, fmt.Errorf("fmap: %d areas do not fit in %d bytes", h.NAreas, len(b))
+	}
+	areas := make([]area, h.NAreas)
+	if err := binary.Read(bytes.NewReader(b[headerSize:]), binary.LittleEndian, areas); err != nil {
+		return nil, err
+	}
+	f := &FMap{
+		VerMajor: h.VerMajor,
+		VerMinor: h.VerMinor,
+		Base:     h.Base,
+		Size:     h.Size,
+		Name:     cString(h.Name[:]),
+		Areas:    make([]Area, len(areas)),
+	}
+	for i, a := range areas {
+		f.Areas[i] = Area{
+			Offset: a.Offset,
+			Size:   a.Size,
+			Name:   cString(a.Name[:]),
+			Flags:  a.Flags,
+		}
+		if uint64(a.Offset)+uint64(a.Size) > uint64(h.Size) {
+			return nil, fmt.Errorf("fmap: area %q at %#x of %#x bytes is beyond the image size %#x", f.Areas[i].Name, a.Offset, a.Size, h.Size)
+		}
+	}
+	return f, nil
+}
+
+// MarshalBinary returns the FMAP as stored.
+func (f *FMap) MarshalBinary() ([]byte, error) {
+	if len(f.Areas) > 0xffff {
+		return nil, fmt.Errorf("fmap: %d areas are too many", len(f.Areas))
+	}
+	h := header{
+		VerMajor: f.VerMajor,
+		VerMinor: f.VerMinor,
+		Base:     f.Base,
+		Size:     f.Size,
+		NAreas:   uint16(len(f.Areas)),
+	}
+	copy(h.Signature[:], Signature)
+	if err := putName(&h.Name, f.Name); err != nil {
+		return nil, fmt.Errorf("fmap: %v", err)
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, &h)
+	for _, a := range f.Areas {
+		sa := area{Offset: a.Offset, Size: a.Size, Flags: a.Flags}
+		if err := putName(&sa.Name, a.Name); err != nil {
+			return nil, fmt.Errorf("fmap: area %v", err)
+		}
+		binary.Write(&b, binary.LittleEndian, &sa)
+	}
+	return b.Bytes(), nil
+}
+
+// Find returns the offset of the FMAP in the first size bytes of r.
+//
+// Like flashrom, offsets aligned to large powers of two are tried first,
+// which finds the FMAP of most images in a few small reads of a slow flash
+// chip. Only then is all of r searched.
+func Find(r io.ReaderAt, size int64) (int64, error) {
+	b := make([]byte, headerSize)
+	align := int64(minAlign)
+	for align*2 <= size {
+		align *= 2
+	}
+	for a := align; a >= minAlign; a /= 2 {
+		for off := int64(0); off+headerSize <= size; off += a {
+			// Skip what the larger alignment tried.
+			if a != align && off%(a*2) == 0 {
+				continue
+			}
+			if _, err := r.ReadAt(b, off); err != nil {
+				return 0, err
+			}
+			if valid(b) {
+				return off, nil
+			}
+		}
+	}
+
+	const chunk = 64 << 10
+	buf := make([]byte, chunk+headerSize)
+	for off := int64(0); off < size; off += chunk {
+		n := int64(len(buf))
+		if size-off < n {
+			n = size - off
+		}
+		if _, err := r.ReadAt(buf[:n], off); err != nil {
+			return 0, err
+		}
+		for i := 0; ; {
+			j := bytes.Index(buf[i:n], []byte(Signature))
+			if j < 0 || int64(i+j) >= chunk {
+				break
+			}
+			if valid(buf[i+j : n]) {
+				return off + int64(i+j), nil
+			}
+			i += j + 1
+		}
+	}
+	return 0, fmt.Errorf("fmap: %w", ErrNotFound)
+}
+
+// Read finds and reads the FMAP in the first size bytes of r. It returns the
+// FMAP and its offset.
+func Read(r io.ReaderAt, size int64) (*FMap, int64, error) {
+	off, err := Find(r, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	b := make([]byte, headerSize)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, 0, err
+	}
+	n := binary.LittleEndian.Uint16(b[headerSize-2:])
+	b = make([]byte, headerSize+int(n)*areaSize)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, 0, err
+	}
+	f, err := Parse(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, off, nil
+}
+
+// Area returns the area called name.
+func (f *FMap) Area(name string) (*Area, error) {
+	for i := range f.Areas {
+		if f.Areas[i].Name == name {
+			return &f.Areas[i], nil
+		}
+	}
+	return nil, fmt.Errorf("fmap: area %q %w", name, ErrNotFound)
+}
+
+// ReadArea reads the area called name from r, which may be a flash chip or
+// an image file.
+func (f *FMap) ReadArea(r io.ReaderAt, name string) ([]byte, error) {
+	a, err := f.Area(name)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, a.Size)
+	if _, err := r.ReadAt(b, int64(a.Offset)); err != nil {
+		return nil, fmt.Errorf("fmap: reading area %q: %w", name, err)
+	}
+	return b, nil
+}
+
+// WriteArea writes the area called name of w with data, which must be the
+// size of the area. w may be a flash chip or an image file.
+func (f *FMap) WriteArea(w io.WriterAt, name string, data []byte) error {
+	a, err := f.Area(name)
+	if err != nil {
+		return err
+	}
+	if len(data) != int(a.Size) {
+		return fmt.Errorf("fmap: area %q has %#x bytes, not %#x", name, a.Size, len(data))
+	}
+	if _, err := w.WriteAt(data, int64(a.Offset)); err != nil {
+		return fmt.Errorf("fmap: writing area %q: %w", name, err)
+	}
+	return nil
+}
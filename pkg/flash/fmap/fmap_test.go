@@ -0,0 +1,190 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testFMap = &FMap{
+	VerMajor: 1,
+	VerMinor: 1,
+	Base:     0xff000000,
+	Size:     0x100000,
+	Name:     "FLASH",
+	Areas: []Area{
+		{Offset: 0, Size: 0x80000, Name: "WP_RO", Flags: FlagStatic},
+		{Offset: 0, Size: 0x4000, Name: "RO_VPD", Flags: FlagPreserve},
+		{Offset: 0x4000, Size: 0x800, Name: "FMAP", Flags: FlagStatic | FlagRO},
+		{Offset: 0x80000, Size: 0x1000, Name: "RW_VPD", Flags: FlagPreserve},
+		{Offset: 0x81000, Size: 0x7f000, Name: "RW_SECTION_A"},
+	},
+}
+
+// image returns an image with the FMAP at off.
+func image(t *testing.T, off int) []byte {
+	t.Helper()
+	img := bytes.Repeat([]byte{0xff}, int(testFMap.Size))
+	b, err := testFMap.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(img[off:], b)
+	return img
+}
+
+func TestMarshal(t *testing.T) {
+	b, err := testFMap.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != headerSize+len(testFMap.Areas)*areaSize {
+		t.Errorf("FMAP of %d bytes, want %d", len(b), headerSize+len(testFMap.Areas)*areaSize)
+	}
+	f, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f, testFMap) {
+		t.Errorf("Parse(MarshalBinary()) = %+v, want %+v", f, testFMap)
+	}
+
+	bad := *testFMap
+	bad.Areas = []Area{{Name: strings.Repeat("x", 32)}}
+	if _, err := bad.MarshalBinary(); err == nil {
+		t.Errorf("MarshalBinary with a 32 byte name succeeded")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	good, err := testFMap.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		b    func([]byte) []byte
+		err  string
+	}{
+		{"signature", func(b []byte) []byte { b[0] = 'X'; return b }, "signature not found"},
+		{"short", func(b []byte) []byte { return b[:headerSize-1] }, "signature not found"},
+		{"version", func(b []byte) []byte { b[8] = 2; return b }, "unsupported version 2.1"},
+		{"areas", func(b []byte) []byte { return b[:len(b)-1] }, "do not fit"},
+		{"area size", func(b []byte) []byte { b[headerSize+4+2] = 0x20; return b }, "beyond the image size"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.b(append([]byte{}, good...))
+			if _, err := Parse(b); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, off := range []int{0, 0x4000, 0x80000, 0xfe000, 0x1234, 0xffe00} {
+		t.Run(fmt.Sprintf("%#x", off), func(t *testing.T) {
+			img := image(t, off)
+			// A signature that is no FMAP before it.
+			if off > 0x1000 {
+				copy(img[0x1000:], Signature)
+			}
+			f, got, err := Read(bytes.NewReader(img), int64(len(img)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != int64(off) {
+				t.Errorf("FMAP at %#x, want %#x", got, off)
+			}
+			if !reflect.DeepEqual(f, testFMap) {
+				t.Errorf("Read = %+v, want %+v", f, testFMap)
+			}
+		})
+	}
+
+	img := bytes.Repeat([]byte{0xff}, 0x10000)
+	if _, _, err := Read(bytes.NewReader(img), int64(len(img))); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read of an empty image = %v, want %v", err, ErrNotFound)
+	}
+}
+
+// countingReader counts calls to ReadAt.
+type countingReader struct {
+	*bytes.Reader
+	n int
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	c.n++
+	return c.Reader.ReadAt(p, off)
+}
+
+func TestFindAligned(t *testing.T) {
+	r := &countingReader{Reader: bytes.NewReader(image(t, 0x80000))}
+	if _, err := Find(r, r.Size()); err != nil {
+		t.Fatal(err)
+	}
+	if r.n > 2 {
+		t.Errorf("Find took %d reads, want 2", r.n)
+	}
+}
+
+func TestArea(t *testing.T) {
+	img := image(t, 0x4000)
+	copy(img[0x80000:], "rw vpd data")
+	f, _, err := Read(bytes.NewReader(img), int64(len(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := f.ReadArea(bytes.NewReader(img), "RW_VPD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0x1000 || !bytes.HasPrefix(b, []byte("rw vpd data")) {
+		t.Errorf("ReadArea(RW_VPD) = %.20q of %d bytes, want 4096 bytes starting with %q", b, len(b), "rw vpd data")
+	}
+
+	w := &writerAt{img}
+	data := bytes.Repeat([]byte{0x55}, 0x4000)
+	if err := f.WriteArea(w, "RO_VPD", data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img[:0x4000], data) || img[0x4000] != '_' {
+		t.Errorf("WriteArea(RO_VPD) wrote outside of the area")
+	}
+	if err := f.WriteArea(w, "RO_VPD", data[1:]); err == nil || !strings.Contains(err.Error(), "has 0x4000 bytes, not 0x3fff") {
+		t.Errorf("WriteArea of the wrong size = %v", err)
+	}
+	if _, err := f.ReadArea(bytes.NewReader(img), "GBB"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadArea(GBB) = %v, want %v", err, ErrNotFound)
+	}
+}
+
+type writerAt struct {
+	b []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(w.b[off:], p), nil
+}
+
+func TestFlags(t *testing.T) {
+	for f, want := range map[Flags]string{
+		0:                       "",
+		FlagStatic:              "static",
+		FlagRO | FlagPreserve:   "ro,preserve",
+		FlagCompressed | 0x8000: "compressed,0x8000",
+	} {
+		if got := f.String(); got != want {
+			t.Errorf("Flags(%#x) = %q, want %q", uint16(f), got, want)
+		}
+	}
+}
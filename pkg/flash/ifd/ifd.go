@@ -0,0 +1,256 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ifd parses the Intel Flash Descriptor (IFD) at the start of the
+// SPI flash of Intel platforms. It divides the flash into regions, such as
+// the BIOS and the ME, grants the masters on the SPI bus access to them, and
+// holds the soft straps of the PCH and processor.
+//
+// Useful references:
+// * https://github.com/coreboot/coreboot/blob/master/util/ifdtool/ifdtool.h
+// * https://github.com/flashrom/flashrom/blob/master/ich_descriptors.c
+package ifd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Signature is the flash valid signature, FLVALSIG.
+const Signature = 0x0ff0a55a
+
+// Size is the size of the descriptor region.
+const Size = 4096
+
+// ErrNotFound is returned when there is no descriptor or region.
+var ErrNotFound = errors.New("not found")
+
+// RegionNames are the names of the regions by index, as in flashrom.
+var RegionNames = []string{
+	"fd", "bios", "me", "gbe", "pd", "devexp", "bios2", "reg7",
+	"ec", "devexp2", "ie", "10gbe0", "10gbe1", "reg13", "reg14", "ptt",
+}
+
+// MasterNames are the names of the masters by index.
+var MasterNames = []string{"bios", "me", "gbe", "reserved", "ec"}
+
+// Region is a region of the flash.
+type Region struct {
+	Index int
+	Name  string
+
+	// Base and Limit are the first and last byte of the region. Limit is
+	// before Base in unused regions.
+	Base  int64
+	Limit int64
+}
+
+// Used returns whether the region is used.
+func (r *Region) Used() bool {
+	return r.Base <= r.Limit
+}
+
+// Size returns the size of the region in bytes.
+func (r *Region) Size() int64 {
+	if !r.Used() {
+		return 0
+	}
+	return r.Limit - r.Base + 1
+}
+
+// Master is a master on the SPI bus, such as the host CPU or the ME.
+type Master struct {
+	Index int
+	Name  string
+
+	// Read and Write have a bit for each region the master may read or
+	// write.
+	Read  uint16
+	Write uint16
+}
+
+// CanRead returns whether the master may read region i.
+func (m *Master) CanRead(i int) bool {
+	return m.Read&(1<<i) != 0
+}
+
+// CanWrite returns whether the master may write region i.
+func (m *Master) CanWrite(i int) bool {
+	return m.Write&(1<<i) != 0
+}
+
+// Descriptor is a flash descriptor.
+type Descriptor struct {
+	// Offset is the offset of the signature, 0x10, or 0 on very old
+	// chipsets.
+	Offset int64
+
+	// FLMAP0, FLMAP1 and FLMAP2 are the flash maps, which locate the
+	// sections of the descriptor.
+	FLMAP0 uint32
+	FLMAP1 uint32
+	FLMAP2 uint32
+
+	Regions []Region
+	Masters []Master
+
+	// PCHStraps and ProcStraps are the soft straps.
+	PCHStraps  []uint32
+	ProcStraps []uint32
+}
+
+// Parse parses the descriptor at the start of b, the first 4KiB of the
+// flash.
+//
+// The access bits of masters moved with Skylake. Descriptors with room for
+// more than 8 regions are taken to be of Skylake or later.
+func Parse(b []byte) (*Descriptor, error) {
+	d := &Descriptor{}
+	u32 := func(off int64) (uint32, error) {
+		if off < 0 || off+4 > int64(len(b)) {
+			return 0, fmt.Errorf("ifd: offset %#x is beyond the descriptor", off)
+		}
+		return binary.LittleEndian.Uint32(b[off:]), nil
+	}
+	switch {
+	case len(b) >= 0x20 && binary.LittleEndian.Uint32(b[0x10:]) == Signature:
+		d.Offset = 0x10
+	case len(b) >= 0x10 && binary.LittleEndian.Uint32(b) == Signature:
+		d.Offset = 0
+	default:
+		return nil, fmt.Errorf("ifd: signature %w", ErrNotFound)
+	}
+	d.FLMAP0 = binary.LittleEndian.Uint32(b[d.Offset+4:])
+	d.FLMAP1 = binary.LittleEndian.Uint32(b[d.Offset+8:])
+	d.FLMAP2 = binary.LittleEndian.Uint32(b[d.Offset+12:])
+
+	frba := int64(d.FLMAP0>>16&0xff) << 4
+	fmba := int64(d.FLMAP1&0xff) << 4
+	fpsba := int64(d.FLMAP1>>16&0xff) << 4
+	isl := int(d.FLMAP1 >> 24)
+	fmsba := int64(d.FLMAP2&0xff) << 4
+	msl := int(d.FLMAP2 >> 8 & 0xff)
+
+	// The regions run up to the masters, or there are as many as FLMAP0
+	// says.
+	nr := int(d.FLMAP0>>24&7) + 1
+	if fmba > frba {
+		nr = int(fmba-frba) / 4
+	}
+	if nr > len(RegionNames) {
+		nr = len(RegionNames)
+	}
+	for i := 0; i < nr; i++ {
+		v, err := u32(frba + int64(i)*4)
+		if err != nil {
+			return nil, err
+		}
+		d.Regions = append(d.Regions, Region{
+			Index: i,
+			Name:  RegionNames[i],
+			Base:  int64(v&0x7fff) << 12,
+			Limit: int64(v>>16&0x7fff)<<12 | 0xfff,
+		})
+	}
+
+	skylake := nr > 8
+	nm := int(d.FLMAP1>>8&7) + 1
+	if skylake {
+		nm = len(MasterNames)
+	}
+	if nm > len(MasterNames) {
+		nm = len(MasterNames)
+	}
+	for i := 0; i < nm; i++ {
+		v, err := u32(fmba + int64(i)*4)
+		if err != nil {
+			return nil, err
+		}
+		m := Master{Index: i, Name: MasterNames[i]}
+		if skylake {
+			m.Read, m.Write = uint16(v>>8&0xfff), uint16(v>>20&0xfff)
+		} else {
+			m.Read, m.Write = uint16(v>>16&0xff), uint16(v>>24&0xff)
+		}
+		d.Masters = append(d.Masters, m)
+	}
+
+	straps := func(off int64, n int) ([]uint32, error) {
+		var s []uint32
+		for i := 0; i < n; i++ {
+			v, err := u32(off + int64(i)*4)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+		return s, nil
+	}
+	var err error
+	if d.PCHStraps, err = straps(fpsba, isl); err != nil {
+		return nil, err
+	}
+	if d.ProcStraps, err = straps(fmsba, msl); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Read reads the descriptor from r, which may be a flash chip or an image
+// file.
+func Read(r io.ReaderAt) (*Descriptor, error) {
+	b := make([]byte, Size)
+	n, err := r.ReadAt(b, 0)
+	if err != nil && !(err == io.EOF && n > 0) {
+		return nil, err
+	}
+	return Parse(b[:n])
+}
+
+// Region returns the used region called name, as in RegionNames.
+func (d *Descriptor) Region(name string) (*Region, error) {
+	for i := range d.Regions {
+		r := &d.Regions[i]
+		if strings.EqualFold(r.Name, name) {
+			if !r.Used() {
+				return nil, fmt.Errorf("ifd: region %q is not used", name)
+			}
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("ifd: region %q %w", name, ErrNotFound)
+}
+
+// ReadRegion reads the region called name from r, which may be a flash chip
+// or an image file.
+func (d *Descriptor) ReadRegion(r io.ReaderAt, name string) ([]byte, error) {
+	reg, err := d.Region(name)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, reg.Size())
+	if _, err := r.ReadAt(b, reg.Base); err != nil {
+		return nil, fmt.Errorf("ifd: reading region %q: %w", name, err)
+	}
+	return b, nil
+}
+
+// WriteRegion writes the region called name of w with data, which must be
+// the size of the region. w may be a flash chip or an image file.
+func (d *Descriptor) WriteRegion(w io.WriterAt, name string, data []byte) error {
+	reg, err := d.Region(name)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != reg.Size() {
+		return fmt.Errorf("ifd: region %q has %#x bytes, not %#x", name, reg.Size(), len(data))
+	}
+	if _, err := w.WriteAt(data, reg.Base); err != nil {
+		return fmt.Errorf("ifd: writing region %q: %w", name, err)
+	}
+	return nil
+}
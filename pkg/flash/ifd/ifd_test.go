@@ -0,0 +1,197 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ifd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unused is the FLREG value of an unused region.
+const unused = 0x00007fff
+
+// descriptor returns a 4MiB image with a descriptor that has the regions
+// up to fmba, which is followed by the masters.
+func descriptor(fmba uint32, regions, masters []uint32, pch, proc []uint32) []byte {
+	img := bytes.Repeat([]byte{0xff}, 4<<20)
+	put := func(off uint32, v uint32) {
+		binary.LittleEndian.PutUint32(img[off:], v)
+	}
+	put(0x10, Signature)
+	put(0x14, 0x30>>4|0x40>>4<<16|uint32(len(regions)-1)<<24)
+	put(0x18, fmba>>4|uint32(len(masters)-1)<<8|0x100>>4<<16|uint32(len(pch))<<24)
+	put(0x1c, 0x200>>4|uint32(len(proc))<<8)
+	for i, v := range regions {
+		put(0x40+uint32(i)*4, v)
+	}
+	for i, v := range masters {
+		put(fmba+uint32(i)*4, v)
+	}
+	for i, v := range pch {
+		put(0x100+uint32(i)*4, v)
+	}
+	for i, v := range proc {
+		put(0x200+uint32(i)*4, v)
+	}
+	return img
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		img     []byte
+		regions []Region
+		masters []Master
+		pch     []uint32
+		proc    []uint32
+	}{
+		{
+			name: "ich",
+			img: descriptor(0x60,
+				[]uint32{0x00000000, 0x03ff0200, 0x01ff0001, unused, unused, unused, unused, unused},
+				[]uint32{0x0a0b0000, 0x0d0d0000, 0x08080118},
+				[]uint32{0x11111111, 0x22222222}, []uint32{0x33333333}),
+			regions: []Region{
+				{0, "fd", 0, 0xfff},
+				{1, "bios", 0x200000, 0x3fffff},
+				{2, "me", 0x1000, 0x1fffff},
+				{3, "gbe", 0x7fff000, 0xfff},
+				{4, "pd", 0x7fff000, 0xfff},
+				{5, "devexp", 0x7fff000, 0xfff},
+				{6, "bios2", 0x7fff000, 0xfff},
+				{7, "reg7", 0x7fff000, 0xfff},
+			},
+			masters: []Master{
+				{0, "bios", 0x0b, 0x0a},
+				{1, "me", 0x0d, 0x0d},
+				{2, "gbe", 0x08, 0x08},
+			},
+			pch:  []uint32{0x11111111, 0x22222222},
+			proc: []uint32{0x33333333},
+		},
+		{
+			name: "skylake",
+			img: descriptor(0x80,
+				[]uint32{0x00000000, 0x03ff0200, 0x01ff0001, unused, unused, unused, unused, unused,
+					0x00010001, unused, unused, unused, unused, unused, unused, unused},
+				[]uint32{0x00a00b00, 0x00d00d00, 0x00800800, 0, 0x10010000},
+				nil, nil),
+			regions: []Region{
+				{0, "fd", 0, 0xfff},
+				{1, "bios", 0x200000, 0x3fffff},
+				{2, "me", 0x1000, 0x1fffff},
+				{3, "gbe", 0x7fff000, 0xfff},
+				{4, "pd", 0x7fff000, 0xfff},
+				{5, "devexp", 0x7fff000, 0xfff},
+				{6, "bios2", 0x7fff000, 0xfff},
+				{7, "reg7", 0x7fff000, 0xfff},
+				{8, "ec", 0x1000, 0x1fff},
+				{9, "devexp2", 0x7fff000, 0xfff},
+				{10, "ie", 0x7fff000, 0xfff},
+				{11, "10gbe0", 0x7fff000, 0xfff},
+				{12, "10gbe1", 0x7fff000, 0xfff},
+				{13, "reg13", 0x7fff000, 0xfff},
+				{14, "reg14", 0x7fff000, 0xfff},
+				{15, "ptt", 0x7fff000, 0xfff},
+			},
+			masters: []Master{
+				{0, "bios", 0x0b, 0x0a},
+				{1, "me", 0x0d, 0x0d},
+				{2, "gbe", 0x08, 0x08},
+				{3, "reserved", 0, 0},
+				{4, "ec", 0x100, 0x100},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Read(bytes.NewReader(tt.img))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d.Regions, tt.regions) {
+				t.Errorf("regions = %+v, want %+v", d.Regions, tt.regions)
+			}
+			if !reflect.DeepEqual(d.Masters, tt.masters) {
+				t.Errorf("masters = %+v, want %+v", d.Masters, tt.masters)
+			}
+			if !reflect.DeepEqual(d.PCHStraps, tt.pch) || !reflect.DeepEqual(d.ProcStraps, tt.proc) {
+				t.Errorf("straps = %#x, %#x, want %#x, %#x", d.PCHStraps, d.ProcStraps, tt.pch, tt.proc)
+			}
+			if !d.Masters[0].CanRead(1) || !d.Masters[0].CanWrite(1) || d.Masters[0].CanWrite(0) || d.Masters[0].CanRead(2) {
+				t.Errorf("BIOS master %+v, want it to read and write the BIOS but not write the descriptor or read the ME", d.Masters[0])
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	img := descriptor(0x60, []uint32{0}, []uint32{0}, nil, nil)
+	for _, tt := range []struct {
+		name string
+		b    []byte
+		err  string
+	}{
+		{"no signature", make([]byte, Size), "signature not found"},
+		{"short", img[:0x1f], "signature not found"},
+		{"truncated", img[:0x50], "beyond the descriptor"},
+	} {
+		if _, err := Parse(tt.b); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: Parse = %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+
+	// Very old chipsets have the signature at 0.
+	old := append([]byte{}, img[0x10:]...)
+	if d, err := Parse(old); err != nil || d.Offset != 0 {
+		t.Errorf("Parse with the signature at 0 = %+v, %v, want it at 0", d, err)
+	}
+}
+
+type writerAt struct {
+	b []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(w.b[off:], p), nil
+}
+
+func TestRegion(t *testing.T) {
+	img := descriptor(0x60, []uint32{0x00000000, 0x03ff0200, 0x01ff0001, unused}, []uint32{0}, nil, nil)
+	copy(img[0x200000:], "bios")
+	d, err := Read(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := d.ReadRegion(bytes.NewReader(img), "BIOS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0x200000 || !bytes.HasPrefix(b, []byte("bios")) {
+		t.Errorf("ReadRegion(BIOS) = %.10q of %#x bytes, want %#x bytes starting with %q", b, len(b), 0x200000, "bios")
+	}
+
+	w := &writerAt{img}
+	data := bytes.Repeat([]byte{0x55}, 0x1ff000)
+	if err := d.WriteRegion(w, "me", data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img[0x1000:0x200000], data) || img[0xfff] != 0xff || img[0x200000] != 'b' {
+		t.Errorf("WriteRegion(me) wrote outside of the region")
+	}
+	if err := d.WriteRegion(w, "me", data[1:]); err == nil || !strings.Contains(err.Error(), "has 0x1ff000 bytes, not 0x1fefff") {
+		t.Errorf("WriteRegion of the wrong size = %v", err)
+	}
+	if _, err := d.Region("gbe"); err == nil || !strings.Contains(err.Error(), "not used") {
+		t.Errorf("Region(gbe) = %v, want it not used", err)
+	}
+	if _, err := d.Region("ec"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Region(ec) = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -7,6 +7,8 @@
 package op
 
 const (
+	// WriteStatus writes the status register.
+	WriteStatus byte = 0x01
 	// PageProgram programs a page on the flash chip.
 	PageProgram byte = 0x02
 	// Read reads from the flash chip.
@@ -19,6 +21,13 @@ const (
 	WriteEnable byte = 0x06
 	// SectorErase erases a sector to the value 0xff.
 	SectorErase byte = 0x20
+	// ReadSecurity reads the security register, which has the OTP lock
+	// bits.
+	ReadSecurity byte = 0x2b
+	// WriteSecurity sets the lock-down bit of the security register.
+	WriteSecurity byte = 0x2f
+	// BlockErase32 erases a 32KiB block to the value 0xff.
+	BlockErase32 byte = 0x52
 	// ReadSFDP reads from the SFDP.
 	ReadSFDP byte = 0x5a
 	// ReadID reads the JEDEC ID.
@@ -0,0 +1,137 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrVerify is returned if the flash does not have the contents written.
+var ErrVerify = errors.New("flash: verify failed")
+
+// Eraser is flash memory which has to be erased, setting all bits, before
+// bits can be cleared by writing. Flash and chipset controllers with
+// hardware sequencing implement it.
+type Eraser interface {
+	io.ReaderAt
+	io.WriterAt
+	// EraseAt erases n bytes at off. Both are multiples of SectorSize.
+	EraseAt(n int64, off int64) (int64, error)
+	// SectorSize returns the size of the smallest erase.
+	SectorSize() int64
+}
+
+// Stats counts the bytes Program erased, wrote and skipped.
+type Stats struct {
+	// Erased is the number of bytes erased.
+	Erased int64
+	// Written is the number of bytes written.
+	Written int64
+	// Skipped is the number of bytes of sectors which did not change.
+	Skipped int64
+}
+
+// needsErase returns whether writing want over old has to set bits.
+func needsErase(old, want []byte) bool {
+	for i := range old {
+		if want[i]&^old[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// diff returns the first and last index where a and b differ, plus one.
+func diff(a, b []byte) (int, int) {
+	first, last := 0, len(a)
+	for first < last && a[first] == b[first] {
+		first++
+	}
+	for last > first && a[last-1] == b[last-1] {
+		last--
+	}
+	return first, last
+}
+
+// Program writes p at off. The sectors around p are read and diffed against
+// it first. Sectors which do not change are skipped, sectors which only need
+// bits cleared are written without erasing them, and runs of the remaining
+// sectors are erased at once, so large erase instructions can be used.
+// Finally, the contents are verified.
+func Program(d Eraser, p []byte, off int64) (*Stats, error) {
+	sector := d.SectorSize()
+	start := off / sector * sector
+	end := (off + int64(len(p)) + sector - 1) / sector * sector
+	old := make([]byte, end-start)
+	if n, err := d.ReadAt(old, start); err != nil {
+		return nil, err
+	} else if n != len(old) {
+		return nil, fmt.Errorf("flash: %#x bytes at %#x are beyond the end of the flash", len(p), off)
+	}
+	want := append([]byte{}, old...)
+	copy(want[off-start:], p)
+
+	var s Stats
+	sectors := len(old) / int(sector)
+	erase := make([]bool, sectors)
+	for i := range erase {
+		o, w := old[int64(i)*sector:int64(i+1)*sector], want[int64(i)*sector:int64(i+1)*sector]
+		if bytes.Equal(o, w) {
+			s.Skipped += sector
+			continue
+		}
+		erase[i] = needsErase(o, w)
+	}
+
+	for i := 0; i < sectors; {
+		if !erase[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < sectors && erase[j] {
+			j++
+		}
+		eoff, n := int64(i)*sector, int64(j-i)*sector
+		if _, err := d.EraseAt(n, start+eoff); err != nil {
+			return &s, err
+		}
+		copy(old[eoff:eoff+n], bytes.Repeat([]byte{0xff}, int(n)))
+		s.Erased += n
+		i = j
+	}
+
+	// Writing a byte with its old value does not change it, so only the
+	// span of changed bytes of each sector is written.
+	for i := int64(0); i < end-start; i += sector {
+		first, last := diff(old[i:i+sector], want[i:i+sector])
+		if first == last {
+			continue
+		}
+		if _, err := d.WriteAt(want[i+int64(first):i+int64(last)], start+i+int64(first)); err != nil {
+			return &s, err
+		}
+		s.Written += int64(last - first)
+	}
+	return &s, Verify(d, p, off)
+}
+
+// Verify returns an error wrapping ErrVerify if the flash does not contain p
+// at off.
+func Verify(r io.ReaderAt, p []byte, off int64) error {
+	got := make([]byte, len(p))
+	if n, err := r.ReadAt(got, off); err != nil {
+		return err
+	} else if n != len(p) {
+		return fmt.Errorf("%w: read %#x of %#x bytes at %#x", ErrVerify, n, len(p), off)
+	}
+	if first, last := diff(got, p); first != last {
+		return fmt.Errorf("%w: %#x at %#x, want %#x", ErrVerify, got[first], off+int64(first), p[first])
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flash
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/flash/op"
+	"github.com/u-root/u-root/pkg/flash/spimock"
+)
+
+// erases returns the opcodes of the erases sent to the mock.
+func erases(s *spimock.MockSPI) []byte {
+	var o []byte
+	for _, t := range s.Transfers {
+		// The opcode is followed by a 4-byte address.
+		if len(t.Tx) != 5 {
+			continue
+		}
+		switch t.Tx[0] {
+		case op.SectorErase, op.BlockErase32, op.BlockErase:
+			o = append(o, t.Tx[0])
+		}
+	}
+	return o
+}
+
+func TestEraseAt(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		n, off  int64
+		opcodes []byte
+	}{
+		{"sector", 0x1000, 0x10000, []byte{op.SectorErase}},
+		{"block", 0x10000, 0x10000, []byte{op.BlockErase}},
+		{"unaligned block", 0x10000, 0x8000, []byte{op.BlockErase32, op.BlockErase32}},
+		{"mixed", 0x1a000, 0x7000, []byte{op.SectorErase, op.BlockErase32, op.BlockErase, op.SectorErase}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := spimock.New()
+			f, err := New(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range s.Data[:0x40000] {
+				s.Data[i] = 0
+			}
+			s.Transfers = nil
+			if n, err := f.EraseAt(tt.n, tt.off); err != nil || n != tt.n {
+				t.Fatalf("EraseAt(%#x, %#x) = %#x, %v", tt.n, tt.off, n, err)
+			}
+			if o := erases(s); !bytes.Equal(o, tt.opcodes) {
+				t.Errorf("EraseAt(%#x, %#x) sent %#x, want %#x", tt.n, tt.off, o, tt.opcodes)
+			}
+			if s.Data[tt.off-1] != 0 || s.Data[tt.off] != 0xff || s.Data[tt.off+tt.n-1] != 0xff || s.Data[tt.off+tt.n] != 0 {
+				t.Errorf("EraseAt(%#x, %#x) erased the wrong range", tt.n, tt.off)
+			}
+		})
+	}
+}
+
+func TestProgram(t *testing.T) {
+	s := spimock.New()
+	f, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(s.Data, bytes.Repeat([]byte{0xff}, 0x40000))
+	for i := range s.Data[0x10000:0x20000] {
+		s.Data[0x10000+i] = byte(i)
+	}
+	copy(s.Data[0x20000:], make([]byte, 0x10000))
+
+	// Of the sectors from 0xf000 to 0x31000, the one at 0x10000 only
+	// clears bits and the block at 0x20000 needs a 64KiB erase. The others
+	// are unchanged.
+	img := append([]byte{}, s.Data[0xf000:0x31000]...)
+	img[0x1001] = 0
+	copy(img[0x11000:], bytes.Repeat([]byte{0x5a}, 0x10000))
+	s.Transfers = nil
+	st, err := Program(f, img, 0xf000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Erased: 0x10000, Written: 0x10001, Skipped: 0x11000}
+	if *st != want {
+		t.Errorf("Program() = %+v, want %+v", *st, want)
+	}
+	if o := erases(s); !bytes.Equal(o, []byte{op.BlockErase}) {
+		t.Errorf("Program() erased with %#x, want a single BlockErase", o)
+	}
+	if !bytes.Equal(s.Data[0xf000:0x31000], img) {
+		t.Errorf("Program() wrote the wrong data")
+	}
+
+	// Programming it again does nothing.
+	if st, err := Program(f, img, 0xf000); err != nil || st.Skipped != 0x22000 {
+		t.Errorf("Program() again = %+v, %v, want only skipped sectors", st, err)
+	}
+	if n, err := f.ProgramAt([]byte("u-root"), 0x10100); err != nil || n != 6 {
+		t.Errorf("ProgramAt = %d, %v", n, err)
+	}
+
+	// The protected top of the chip is not changed.
+	if err := f.SetBlockProtect(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Program(f, []byte("u-root"), f.Size()-0x100); !errors.Is(err, ErrVerify) {
+		t.Errorf("Program() of a protected range = %v, want %v", err, ErrVerify)
+	}
+	if _, err := Program(f, []byte("u-root"), f.Size()-2); err == nil {
+		t.Errorf("Program() beyond the end succeeded")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flash
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/u-root/u-root/pkg/flash/op"
+	"github.com/u-root/u-root/pkg/spidev"
+)
+
+// Bits of the status register.
+const (
+	// StatusWIP is set while a write is in progress.
+	StatusWIP = 1 << 0
+	// StatusWEL is set after WriteEnable.
+	StatusWEL = 1 << 1
+	// StatusBP are the block protect bits BP3-BP0.
+	StatusBP = 0xf << 2
+	// StatusSRWD disables writing the status register while the WP# pin
+	// is low.
+	StatusSRWD = 1 << 7
+)
+
+// Bits of the security register.
+const (
+	// SecurityFactoryOTP is set if the factory locked the secured OTP
+	// area.
+	SecurityFactoryOTP = 1 << 0
+	// SecurityLockedOTP is set if the secured OTP area was locked down.
+	SecurityLockedOTP = 1 << 1
+)
+
+// MaxProtectLevel is the highest level of the block protect bits.
+const MaxProtectLevel = StatusBP >> 2
+
+// blockProtectUnit is the size protected at level 1.
+const blockProtectUnit = 0x10000
+
+// ErrStatusLocked is returned if the status register did not change because
+// it is locked by StatusSRWD and the WP# pin.
+var ErrStatusLocked = errors.New("flash: status register is locked")
+
+// ReadStatus reads the status register.
+func (f *Flash) ReadStatus() (byte, error) {
+	rx := make([]byte, 1)
+	if err := f.spi.Transfer([]spidev.Transfer{
+		{Tx: []byte{op.ReadStatus}},
+		{Rx: rx},
+	}); err != nil {
+		return 0, err
+	}
+	return rx[0], nil
+}
+
+// WriteStatus writes the status register. ErrStatusLocked is returned if
+// the chip ignored it.
+func (f *Flash) WriteStatus(v byte) error {
+	if err := f.spi.Transfer([]spidev.Transfer{
+		// Enable writing.
+		{Tx: []byte{op.WriteEnable}, CSChange: true},
+		{Tx: []byte{op.WriteStatus, v}},
+	}); err != nil {
+		return err
+	}
+	if err := f.wait(); err != nil {
+		return err
+	}
+	s, err := f.ReadStatus()
+	if err != nil {
+		return err
+	}
+	if s&(StatusBP|StatusSRWD) != v&(StatusBP|StatusSRWD) {
+		return fmt.Errorf("%w: wrote %#02x, read %#02x", ErrStatusLocked, v, s)
+	}
+	return nil
+}
+
+// BlockProtect returns the level of the block protect bits and whether the
+// status register is write disabled.
+func (f *Flash) BlockProtect() (level int, srwd bool, err error) {
+	s, err := f.ReadStatus()
+	if err != nil {
+		return 0, false, err
+	}
+	return int(s&StatusBP) >> 2, s&StatusSRWD != 0, nil
+}
+
+// SetBlockProtect sets the level of the block protect bits and the status
+// register write disable bit.
+func (f *Flash) SetBlockProtect(level int, srwd bool) error {
+	if level < 0 || level > MaxProtectLevel {
+		return fmt.Errorf("flash: block protect level %d is not between 0 and %d", level, MaxProtectLevel)
+	}
+	v := byte(level << 2)
+	if srwd {
+		v |= StatusSRWD
+	}
+	return f.WriteStatus(v)
+}
+
+// ProtectedRange returns the range protected at a level of the block protect
+// bits. As on most Macronix chips, the top 64KiB are protected at level 1,
+// and each level doubles the size up to the whole chip.
+func (f *Flash) ProtectedRange(level int) (off int64, n int64) {
+	if level <= 0 {
+		return f.size, 0
+	}
+	n = blockProtectUnit << (level - 1)
+	if level > 16 || n > f.size {
+		n = f.size
+	}
+	return f.size - n, n
+}
+
+// ProtectLevel returns the lowest level of the block protect bits which
+// protects exactly the range of n bytes at off.
+func (f *Flash) ProtectLevel(off int64, n int64) (int, error) {
+	for level := 0; level <= MaxProtectLevel; level++ {
+		o, l := f.ProtectedRange(level)
+		if l == n && (o == off || n == 0) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("flash: %#x bytes at %#x cannot be protected with the block protect bits", n, off)
+}
+
+// ReadSecurity reads the security register, which has the lock bits of the
+// secured OTP area.
+func (f *Flash) ReadSecurity() (byte, error) {
+	rx := make([]byte, 1)
+	if err := f.spi.Transfer([]spidev.Transfer{
+		{Tx: []byte{op.ReadSecurity}},
+		{Rx: rx},
+	}); err != nil {
+		return 0, err
+	}
+	return rx[0], nil
+}
+
+// LockOTP locks down the secured OTP area. This cannot be undone.
+func (f *Flash) LockOTP() error {
+	if err := f.spi.Transfer([]spidev.Transfer{
+		// Enable writing.
+		{Tx: []byte{op.WriteEnable}, CSChange: true},
+		{Tx: []byte{op.WriteSecurity}},
+	}); err != nil {
+		return err
+	}
+	if err := f.wait(); err != nil {
+		return err
+	}
+	s, err := f.ReadSecurity()
+	if err != nil {
+		return err
+	}
+	if s&SecurityLockedOTP == 0 {
+		return fmt.Errorf("flash: OTP area is not locked, security register %#02x", s)
+	}
+	return nil
+}
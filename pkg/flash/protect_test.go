@@ -0,0 +1,89 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flash
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/flash/spimock"
+)
+
+func TestProtectedRange(t *testing.T) {
+	f, err := New(spimock.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		level  int
+		off, n int64
+	}{
+		{0, spimock.FakeSize, 0},
+		{1, spimock.FakeSize - 0x10000, 0x10000},
+		{5, spimock.FakeSize - 0x100000, 0x100000},
+		{11, 0, spimock.FakeSize},
+		{MaxProtectLevel, 0, spimock.FakeSize},
+	} {
+		if off, n := f.ProtectedRange(tt.level); off != tt.off || n != tt.n {
+			t.Errorf("ProtectedRange(%d) = %#x, %#x, want %#x, %#x", tt.level, off, n, tt.off, tt.n)
+		}
+	}
+	if level, err := f.ProtectLevel(0, spimock.FakeSize); err != nil || level != 11 {
+		t.Errorf("ProtectLevel of the whole chip = %d, %v, want 11, nil", level, err)
+	}
+	if level, err := f.ProtectLevel(0, 0); err != nil || level != 0 {
+		t.Errorf("ProtectLevel of nothing = %d, %v, want 0, nil", level, err)
+	}
+	if _, err := f.ProtectLevel(0, 0x10000); err == nil {
+		t.Errorf("ProtectLevel of the bottom block succeeded")
+	}
+}
+
+func TestBlockProtect(t *testing.T) {
+	s := spimock.New()
+	f, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetBlockProtect(3, true); err != nil {
+		t.Fatal(err)
+	}
+	if level, srwd, err := f.BlockProtect(); err != nil || level != 3 || !srwd {
+		t.Errorf("BlockProtect() = %d, %v, %v, want 3, true, nil", level, srwd, err)
+	}
+	if s.Status != 3<<2|StatusSRWD {
+		t.Errorf("status register = %#02x, want %#02x", s.Status, 3<<2|StatusSRWD)
+	}
+	if err := f.SetBlockProtect(MaxProtectLevel+1, false); err == nil {
+		t.Errorf("SetBlockProtect(%d) succeeded", MaxProtectLevel+1)
+	}
+
+	// With the WP# pin asserted, the status register is locked.
+	s.WP = true
+	if err := f.SetBlockProtect(0, false); !errors.Is(err, ErrStatusLocked) {
+		t.Errorf("SetBlockProtect with WP# asserted = %v, want %v", err, ErrStatusLocked)
+	}
+	s.WP = false
+	if err := f.SetBlockProtect(0, false); err != nil {
+		t.Errorf("SetBlockProtect with WP# deasserted = %v", err)
+	}
+}
+
+func TestLockOTP(t *testing.T) {
+	s := spimock.New()
+	f, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := f.ReadSecurity(); err != nil || v != 0 {
+		t.Errorf("ReadSecurity() = %#02x, %v, want 0, nil", v, err)
+	}
+	if err := f.LockOTP(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := f.ReadSecurity(); err != nil || v&SecurityLockedOTP == 0 {
+		t.Errorf("ReadSecurity() = %#02x, %v, want it locked down", v, err)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -66,6 +67,14 @@ var (
 	Param122FastReadOpcode              = Param{0, 3, 0x18, 0x08}
 	Param222FastReadSupported           = Param{0, 4, 0x00, 0x01}
 	Param444FastReadSupported           = Param{0, 4, 0x04, 0x01}
+	ParamEraseType1Size                 = Param{0, 7, 0x00, 0x08}
+	ParamEraseType1Opcode               = Param{0, 7, 0x08, 0x08}
+	ParamEraseType2Size                 = Param{0, 7, 0x10, 0x08}
+	ParamEraseType2Opcode               = Param{0, 7, 0x18, 0x08}
+	ParamEraseType3Size                 = Param{0, 8, 0x00, 0x08}
+	ParamEraseType3Opcode               = Param{0, 8, 0x08, 0x08}
+	ParamEraseType4Size                 = Param{0, 8, 0x10, 0x08}
+	ParamEraseType4Opcode               = Param{0, 8, 0x18, 0x08}
 )
 
 // ParamLookupEntry is a single entry in the BasicTableLookup.
@@ -104,6 +113,14 @@ var BasicTableLookup = []ParamLookupEntry{
 	{"122FastReadOpcode", Param122FastReadOpcode},
 	{"222FastReadSupported", Param222FastReadSupported},
 	{"444FastReadSupported", Param444FastReadSupported},
+	{"EraseType1Size", ParamEraseType1Size},
+	{"EraseType1Opcode", ParamEraseType1Opcode},
+	{"EraseType2Size", ParamEraseType2Size},
+	{"EraseType2Opcode", ParamEraseType2Opcode},
+	{"EraseType3Size", ParamEraseType3Size},
+	{"EraseType3Opcode", ParamEraseType3Opcode},
+	{"EraseType4Size", ParamEraseType4Size},
+	{"EraseType4Opcode", ParamEraseType4Opcode},
 }
 
 // SFDP (Serial Flash Discoverable Parameters) holds a copy of the tables of the SFDP.
@@ -244,6 +261,43 @@ func (s *SFDP) Param(p Param) (int64, error) {
 	return (int64(dword) >> p.Shift) & ((1 << int64(p.Bits)) - 1), nil
 }
 
+// EraseType is an erase instruction of the flash chip.
+type EraseType struct {
+	// Size is the number of bytes erased.
+	Size int64
+	// Opcode is the instruction.
+	Opcode byte
+}
+
+// EraseTypes returns the erase types of the Basic Table, from the smallest
+// size to the largest.
+func (s *SFDP) EraseTypes() ([]EraseType, error) {
+	params := [][2]Param{
+		{ParamEraseType1Size, ParamEraseType1Opcode},
+		{ParamEraseType2Size, ParamEraseType2Opcode},
+		{ParamEraseType3Size, ParamEraseType3Opcode},
+		{ParamEraseType4Size, ParamEraseType4Opcode},
+	}
+	var types []EraseType
+	for _, p := range params {
+		size, err := s.Param(p[0])
+		if err != nil {
+			return nil, err
+		}
+		opcode, err := s.Param(p[1])
+		if err != nil {
+			return nil, err
+		}
+		// A size of 0 means the type is not supported.
+		if size == 0 {
+			continue
+		}
+		types = append(types, EraseType{Size: 1 << size, Opcode: byte(opcode)})
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Size < types[j].Size })
+	return types, nil
+}
+
 // PrettyPrint prints each parameter from the lookup in a human-readable format.
 func (s *SFDP) PrettyPrint(w io.Writer, l []ParamLookupEntry) error {
 	// Get the max width of the param name.
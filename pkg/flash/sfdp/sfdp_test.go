@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"reflect"
 	"testing"
 
 	"github.com/u-root/u-root/pkg/flash/spimock"
@@ -40,6 +41,14 @@ FlashMemoryDensity             0x1fffffff
 122FastReadOpcode              0xbb
 222FastReadSupported           0x0
 444FastReadSupported           0x1
+EraseType1Size                 0xc
+EraseType1Opcode               0x20
+EraseType2Size                 0xf
+EraseType2Opcode               0x52
+EraseType3Size                 0x10
+EraseType3Opcode               0xd8
+EraseType4Size                 0x0
+EraseType4Opcode               0xff
 `
 
 // errorLookupParams contains Params which will return an error when read.
@@ -135,6 +144,22 @@ func TestRead(t *testing.T) {
 	}
 }
 
+// TestEraseTypes checks the erase types are parsed and sorted by size.
+func TestEraseTypes(t *testing.T) {
+	sfdp, err := Read(bytes.NewReader(spimock.FakeSFDP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := sfdp.EraseTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EraseType{{0x1000, 0x20}, {0x8000, 0x52}, {0x10000, 0xd8}}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("sfdp.EraseTypes() = %#x; want %#x", types, want)
+	}
+}
+
 // TestRead16BitId checks that the v1.5 16-bit table IDs are supported.
 func TestRead16BitId(t *testing.T) {
 	sfdpV1_5 := []byte{
@@ -81,6 +81,16 @@ type MockSPI struct {
 	// WritePending is non-zero while a write is pending. It decreases on
 	// every read of the status register.
 	WritePending int
+	// Status holds the non-volatile bits of the status register: the
+	// block protect bits BP3-BP0 in bits 5-2, and the status register
+	// write disable bit in bit 7.
+	Status byte
+	// WP is set to true if the WP# pin is asserted. The status register
+	// cannot be written while it is and the write disable bit is set.
+	WP bool
+	// Security is the security register. Bit 0 is set if the factory
+	// locked the secured OTP area, and bit 1 if it was locked down.
+	Security byte
 
 	// Transfers is a recording of the transfers.
 	Transfers []spidev.Transfer
@@ -128,6 +138,31 @@ func (s *MockSPI) Close() error {
 	return nil
 }
 
+// protected returns whether addr is in the range protected by the block
+// protect bits: none for level 0, otherwise the top 64KiB shifted left by
+// the level minus one.
+func (s *MockSPI) protected(addr int64) bool {
+	level := s.Status >> 2 & 0xf
+	if level == 0 {
+		return false
+	}
+	return addr >= int64(len(s.Data))-int64(0x10000)<<(level-1)
+}
+
+// erase erases the block of the given size at addr, unless it is protected.
+func (s *MockSPI) erase(transfers []spidev.Transfer, size int64) {
+	if !s.IsWriteEnabled {
+		return
+	}
+	addr, _ := address(transfers, 1, s.Is4BA)
+	addr &^= size - 1
+	if !s.protected(addr) {
+		copy(s.Data[addr:], bytes.Repeat([]byte{0xff}, int(size)))
+	}
+	s.IsWriteEnabled = false
+	s.WritePending = WriteWaitStates
+}
+
 // tlen returns the length of a single transfers.
 func tlen(t *spidev.Transfer) int {
 	if len(t.Tx) > len(t.Rx) {
@@ -193,6 +228,22 @@ func (s *MockSPI) Transfer(transfers []spidev.Transfer) error {
 
 	s.Transfers = append(s.Transfers, transfers...)
 
+	// Each command ends where the chip select is deasserted.
+	for len(transfers) != 0 {
+		n := 1
+		for n < len(transfers) && !transfers[n-1].CSChange {
+			n++
+		}
+		if err := s.command(transfers[:n]); err != nil {
+			return err
+		}
+		transfers = transfers[n:]
+	}
+	return nil
+}
+
+// command runs a single command.
+func (s *MockSPI) command(transfers []spidev.Transfer) error {
 	o, err := tx(transfers, 0)
 	if err != nil {
 		return err
@@ -204,7 +255,7 @@ func (s *MockSPI) Transfer(transfers []spidev.Transfer) error {
 		}
 		addr, addrLen := address(transfers, 1, s.Is4BA)
 		// Copy each byte from tx to data with wrap-around within the page.
-		for i := 0; ; i++ {
+		for i := 0; !s.protected(addr); i++ {
 			b, err := tx(transfers, 1+int(addrLen)+i)
 			if err == io.EOF {
 				break
@@ -216,14 +267,23 @@ func (s *MockSPI) Transfer(transfers []spidev.Transfer) error {
 	case op.Read:
 		addr, addrLen := address(transfers, 1, s.Is4BA)
 		// Copy each byte from data to rx.
-		for i := int64(0); rx(transfers, int(1+addrLen+i), s.Data[addr+i]) == nil; i++ {
+		for i := int64(0); addr+i < int64(len(s.Data)) && rx(transfers, int(1+addrLen+i), s.Data[addr+i]) == nil; i++ {
 		}
 	case op.WriteDisable:
 		s.IsWriteEnabled = false
+	case op.WriteStatus:
+		if !s.IsWriteEnabled || s.WP && s.Status&0x80 != 0 {
+			break
+		}
+		b, _ := tx(transfers, 1)
+		s.Status = b & 0xbc
+		s.IsWriteEnabled = false
+		s.WritePending = WriteWaitStates
 	case op.ReadStatus:
-		var statusReg uint8
+		statusReg := s.Status
 		if s.WritePending != 0 {
 			statusReg |= 1
+			s.WritePending--
 		}
 		if s.IsWriteEnabled {
 			statusReg |= 2
@@ -232,18 +292,23 @@ func (s *MockSPI) Transfer(transfers []spidev.Transfer) error {
 	case op.WriteEnable:
 		s.IsWriteEnabled = true
 	case op.SectorErase:
+		s.erase(transfers, 0x1000)
+	case op.ReadSecurity:
+		rx(transfers, 1, s.Security)
+	case op.WriteSecurity:
 		if !s.IsWriteEnabled {
 			break
 		}
-		addr, _ := address(transfers, 1, s.Is4BA)
-		addr &= ^0xfff
-		copy(s.Data[addr:], bytes.Repeat([]byte{0xff}, 0x1000))
+		s.Security |= 2
 		s.IsWriteEnabled = false
+		s.WritePending = WriteWaitStates
+	case op.BlockErase32:
+		s.erase(transfers, 0x8000)
 	case op.ReadSFDP:
 		// ReadSFDP is always 3-byte addressing.
 		addr, addrLen := address(transfers, 1, false)
 		// Copy each byte from sfdp to rx.
-		for i := int64(0); rx(transfers, int(1+addrLen+1+i), s.SFDP[addr+i]) == nil; i++ {
+		for i := int64(0); addr+i < int64(len(s.SFDP)) && rx(transfers, int(1+addrLen+1+i), s.SFDP[addr+i]) == nil; i++ {
 		}
 	case op.ReadJEDECID:
 		rx(transfers, 1, 0xc2)
@@ -252,13 +317,7 @@ func (s *MockSPI) Transfer(transfers []spidev.Transfer) error {
 	case op.Enter4BA:
 		s.Is4BA = true
 	case op.BlockErase:
-		if !s.IsWriteEnabled {
-			break
-		}
-		addr, _ := address(transfers, 1, s.Is4BA)
-		addr &= ^0x7fff
-		copy(s.Data[addr:], bytes.Repeat([]byte{0xff}, 0x8000))
-		s.IsWriteEnabled = false
+		s.erase(transfers, 0x10000)
 	case op.Exit4BA:
 		s.Is4BA = false
 	default:
@@ -0,0 +1,564 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gpio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctls of the GPIO character device, from include/uapi/linux/gpio.h.
+const (
+	getChipInfo    = 0x8044b401 // _IOR(0xB4, 0x01, struct gpiochip_info)
+	getLineInfo    = 0xc100b405 // _IOWR(0xB4, 0x05, struct gpio_v2_line_info)
+	getLine        = 0xc250b407 // _IOWR(0xB4, 0x07, struct gpio_v2_line_request)
+	lineSetConfig  = 0xc110b40d // _IOWR(0xB4, 0x0D, struct gpio_v2_line_config)
+	lineGetValues  = 0xc010b40e // _IOWR(0xB4, 0x0E, struct gpio_v2_line_values)
+	lineSetValues  = 0xc010b40f // _IOWR(0xB4, 0x0F, struct gpio_v2_line_values)
+	maxLines       = 64
+	maxAttributes  = 10
+	maxNameSize    = 32
+	attrFlags      = 1
+	attrOutputVals = 2
+	attrDebounce   = 3
+)
+
+// LineFlag is a flag of a line, gpio_v2_line_flag.
+type LineFlag uint64
+
+// Line flags.
+const (
+	// FlagUsed is set in LineInfo if the line is requested or used by
+	// the kernel.
+	FlagUsed LineFlag = 1 << iota
+	FlagActiveLow
+	FlagInput
+	FlagOutput
+	FlagEdgeRising
+	FlagEdgeFalling
+	FlagOpenDrain
+	FlagOpenSource
+	FlagBiasPullUp
+	FlagBiasPullDown
+	FlagBiasDisabled
+	FlagEventClockRealtime
+	FlagEventClockHTE
+)
+
+var flagNames = []string{
+	"used", "active-low", "input", "output", "edge-rising", "edge-falling",
+	"open-drain", "open-source", "pull-up", "pull-down", "bias-disabled",
+	"clock-realtime", "clock-hte",
+}
+
+func (f LineFlag) String() string {
+	var s []string
+	for i, n := range flagNames {
+		if f&(1<<i) != 0 {
+			s = append(s, n)
+		}
+	}
+	if rest := f &^ (1<<len(flagNames) - 1); rest != 0 {
+		s = append(s, fmt.Sprintf("%#x", uint64(rest)))
+	}
+	return strings.Join(s, ",")
+}
+
+// ones returns whether at most one of the flags in mask is set.
+func (f LineFlag) ones(mask LineFlag) bool {
+	f &= mask
+	return f&(f-1) == 0
+}
+
+// Validate checks the flags can be requested, as the kernel does.
+func (f LineFlag) Validate() error {
+	switch {
+	case f&FlagUsed != 0:
+		return errors.New("gpio: the used flag cannot be requested")
+	case !f.ones(FlagInput | FlagOutput):
+		return errors.New("gpio: a line cannot be both input and output")
+	case f&(FlagEdgeRising|FlagEdgeFalling) != 0 && f&FlagInput == 0:
+		return errors.New("gpio: edge detection needs an input")
+	case f&(FlagOpenDrain|FlagOpenSource) != 0 && f&FlagOutput == 0:
+		return errors.New("gpio: open drain and open source need an output")
+	case !f.ones(FlagOpenDrain | FlagOpenSource):
+		return errors.New("gpio: a line cannot be both open drain and open source")
+	case f&(FlagBiasPullUp|FlagBiasPullDown|FlagBiasDisabled) != 0 && f&(FlagInput|FlagOutput) == 0:
+		return errors.New("gpio: bias needs an input or output")
+	case !f.ones(FlagBiasPullUp | FlagBiasPullDown | FlagBiasDisabled):
+		return errors.New("gpio: only one bias can be set")
+	case !f.ones(FlagEventClockRealtime | FlagEventClockHTE):
+		return errors.New("gpio: only one event clock can be set")
+	}
+	return nil
+}
+
+// chipInfo is struct gpiochip_info.
+type chipInfo struct {
+	Name  [maxNameSize]byte
+	Label [maxNameSize]byte
+	Lines uint32
+}
+
+// lineAttribute is struct gpio_v2_line_attribute. Value is the union of
+// flags, values and debounce_period_us.
+type lineAttribute struct {
+	ID      uint32
+	Padding uint32
+	Value   uint64
+}
+
+// lineInfo is struct gpio_v2_line_info.
+type lineInfo struct {
+	Name     [maxNameSize]byte
+	Consumer [maxNameSize]byte
+	Offset   uint32
+	NumAttrs uint32
+	Flags    uint64
+	Attrs    [maxAttributes]lineAttribute
+	Padding  [4]uint32
+}
+
+// lineConfigAttribute is struct gpio_v2_line_config_attribute.
+type lineConfigAttribute struct {
+	Attr lineAttribute
+	Mask uint64
+}
+
+// lineConfig is struct gpio_v2_line_config.
+type lineConfig struct {
+	Flags    uint64
+	NumAttrs uint32
+	Padding  [5]uint32
+	Attrs    [maxAttributes]lineConfigAttribute
+}
+
+// lineRequest is struct gpio_v2_line_request.
+type lineRequest struct {
+	Offsets         [maxLines]uint32
+	Consumer        [maxNameSize]byte
+	Config          lineConfig
+	NumLines        uint32
+	EventBufferSize uint32
+	Padding         [5]uint32
+	FD              int32
+}
+
+// lineValues is struct gpio_v2_line_values.
+type lineValues struct {
+	Bits uint64
+	Mask uint64
+}
+
+// lineEvent is struct gpio_v2_line_event.
+type lineEvent struct {
+	Timestamp uint64
+	ID        uint32
+	Offset    uint32
+	Seqno     uint32
+	LineSeqno uint32
+	Padding   [6]uint32
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func cstring(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i != -1 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// debouncePeriod reads the u32 debounce_period_us of the union.
+func debouncePeriod(v *uint64) time.Duration {
+	return time.Duration(*(*uint32)(unsafe.Pointer(v))) * time.Microsecond
+}
+
+// setDebouncePeriod sets the u32 debounce_period_us of the union.
+func setDebouncePeriod(v *uint64, d time.Duration) {
+	*(*uint32)(unsafe.Pointer(v)) = uint32(d / time.Microsecond)
+}
+
+// Chips returns the paths of the GPIO character devices, sorted by number.
+func Chips() ([]string, error) {
+	paths, err := filepath.Glob("/dev/gpiochip*")
+	if err != nil {
+		return nil, err
+	}
+	num := func(p string) int {
+		n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(p), "gpiochip"))
+		return n
+	}
+	sort.Slice(paths, func(i, j int) bool { return num(paths[i]) < num(paths[j]) })
+	return paths, nil
+}
+
+// Chip is a GPIO controller, accessed through its character device.
+type Chip struct {
+	f    *os.File
+	info chipInfo
+}
+
+// OpenChip opens the GPIO character device at path, e.g. /dev/gpiochip0.
+func OpenChip(path string) (*Chip, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Chip{f: f}
+	if err := ioctl(f.Fd(), getChipInfo, unsafe.Pointer(&c.info)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("gpio: %s is not a GPIO chip: %v", path, err)
+	}
+	return c, nil
+}
+
+// Close closes the chip. Requested lines stay requested.
+func (c *Chip) Close() error {
+	return c.f.Close()
+}
+
+// Name returns the name of the chip in the kernel, e.g. gpiochip0.
+func (c *Chip) Name() string {
+	return cstring(c.info.Name[:])
+}
+
+// Label returns the label of the chip, e.g. the name of its driver.
+func (c *Chip) Label() string {
+	return cstring(c.info.Label[:])
+}
+
+// NumLines returns the number of lines of the chip.
+func (c *Chip) NumLines() int {
+	return int(c.info.Lines)
+}
+
+// LineInfo is the state of a line.
+type LineInfo struct {
+	// Name is the name of the line, if it has one.
+	Name string
+	// Consumer is the name of the user of the line, if it is used.
+	Consumer string
+	// Offset is the number of the line on the chip.
+	Offset int
+	// Flags are the flags of the line.
+	Flags LineFlag
+	// Debounce is the debounce period of the line.
+	Debounce time.Duration
+}
+
+// LineInfo returns the state of the line at offset.
+func (c *Chip) LineInfo(offset int) (*LineInfo, error) {
+	info := lineInfo{Offset: uint32(offset)}
+	if err := ioctl(c.f.Fd(), getLineInfo, unsafe.Pointer(&info)); err != nil {
+		return nil, fmt.Errorf("gpio: line %d of %s: %v", offset, c.Name(), err)
+	}
+	l := &LineInfo{
+		Name:     cstring(info.Name[:]),
+		Consumer: cstring(info.Consumer[:]),
+		Offset:   int(info.Offset),
+		Flags:    LineFlag(info.Flags),
+	}
+	for i := 0; i < int(info.NumAttrs) && i < maxAttributes; i++ {
+		if info.Attrs[i].ID == attrDebounce {
+			l.Debounce = debouncePeriod(&info.Attrs[i].Value)
+		}
+	}
+	return l, nil
+}
+
+// FindLine returns the offset of the line called name.
+func (c *Chip) FindLine(name string) (int, error) {
+	for i := 0; i < c.NumLines(); i++ {
+		l, err := c.LineInfo(i)
+		if err != nil {
+			return 0, err
+		}
+		if l.Name == name {
+			return i, nil
+		}
+	}
+	return 0, &os.PathError{Op: "find line", Path: name, Err: os.ErrNotExist}
+}
+
+// FindLine returns the path of the chip with the line called name, and the
+// offset of the line.
+func FindLine(name string) (string, int, error) {
+	paths, err := Chips()
+	if err != nil {
+		return "", 0, err
+	}
+	for _, p := range paths {
+		c, err := OpenChip(p)
+		if err != nil {
+			return "", 0, err
+		}
+		off, err := c.FindLine(name)
+		c.Close()
+		if err == nil {
+			return p, off, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", 0, err
+		}
+	}
+	return "", 0, &os.PathError{Op: "find line", Path: name, Err: os.ErrNotExist}
+}
+
+// LineConfig is the configuration of requested lines.
+type LineConfig struct {
+	// Consumer is the name shown as the user of the lines.
+	Consumer string
+	// Flags are the flags of all the lines, such as FlagInput or
+	// FlagOutput, FlagActiveLow, a bias and a drive.
+	Flags LineFlag
+	// Values are the initial values of outputs, one per line. Lines
+	// without a value are set low.
+	Values []Value
+	// Debounce is the debounce period of inputs.
+	Debounce time.Duration
+	// EventBufferSize is the number of edge events the kernel buffers.
+	// The kernel picks a default if it is 0.
+	EventBufferSize int
+}
+
+// config returns the gpio_v2_line_config for n lines.
+func (cfg *LineConfig) config(n int) (lineConfig, error) {
+	var lc lineConfig
+	if err := cfg.Flags.Validate(); err != nil {
+		return lc, err
+	}
+	if len(cfg.Values) > n {
+		return lc, fmt.Errorf("gpio: %d values for %d lines", len(cfg.Values), n)
+	}
+	lc.Flags = uint64(cfg.Flags)
+	all := uint64(1)<<n - 1
+	if n == maxLines {
+		all = ^uint64(0)
+	}
+	if cfg.Flags&FlagOutput != 0 {
+		var bits uint64
+		for i, v := range cfg.Values {
+			if v == High {
+				bits |= 1 << i
+			}
+		}
+		lc.Attrs[lc.NumAttrs] = lineConfigAttribute{Attr: lineAttribute{ID: attrOutputVals, Value: bits}, Mask: all}
+		lc.NumAttrs++
+	}
+	if cfg.Debounce != 0 {
+		if cfg.Flags&FlagInput == 0 {
+			return lc, errors.New("gpio: debounce needs an input")
+		}
+		a := lineConfigAttribute{Attr: lineAttribute{ID: attrDebounce}, Mask: all}
+		setDebouncePeriod(&a.Attr.Value, cfg.Debounce)
+		lc.Attrs[lc.NumAttrs] = a
+		lc.NumAttrs++
+	}
+	return lc, nil
+}
+
+// request returns the gpio_v2_line_request for the lines at offsets.
+func (cfg *LineConfig) request(offsets []int) (*lineRequest, error) {
+	if len(offsets) == 0 || len(offsets) > maxLines {
+		return nil, fmt.Errorf("gpio: %d lines requested, want 1 to %d", len(offsets), maxLines)
+	}
+	if len(cfg.Consumer) >= maxNameSize {
+		return nil, fmt.Errorf("gpio: consumer %q is longer than %d bytes", cfg.Consumer, maxNameSize-1)
+	}
+	lc, err := cfg.config(len(offsets))
+	if err != nil {
+		return nil, err
+	}
+	r := &lineRequest{
+		Config:          lc,
+		NumLines:        uint32(len(offsets)),
+		EventBufferSize: uint32(cfg.EventBufferSize),
+	}
+	for i, o := range offsets {
+		r.Offsets[i] = uint32(o)
+	}
+	copy(r.Consumer[:], cfg.Consumer)
+	return r, nil
+}
+
+// Lines are lines requested from a chip.
+type Lines struct {
+	f       *os.File
+	offsets []int
+}
+
+// Request requests the lines at offsets with the configuration cfg. The
+// lines are released by Close.
+func (c *Chip) Request(offsets []int, cfg LineConfig) (*Lines, error) {
+	r, err := cfg.request(offsets)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioctl(c.f.Fd(), getLine, unsafe.Pointer(r)); err != nil {
+		return nil, fmt.Errorf("gpio: requesting lines %v of %s: %v", offsets, c.Name(), err)
+	}
+	// The file is non-blocking, so Close interrupts ReadEvent.
+	if err := unix.SetNonblock(int(r.FD), true); err != nil {
+		unix.Close(int(r.FD))
+		return nil, err
+	}
+	return &Lines{
+		f:       os.NewFile(uintptr(r.FD), fmt.Sprintf("%s lines %v", c.Name(), offsets)),
+		offsets: append([]int{}, offsets...),
+	}, nil
+}
+
+// Offsets returns the offsets of the lines.
+func (l *Lines) Offsets() []int {
+	return l.offsets
+}
+
+// Close releases the lines.
+func (l *Lines) Close() error {
+	return l.f.Close()
+}
+
+// control runs an ioctl on the request.
+func (l *Lines) control(req uintptr, arg unsafe.Pointer) error {
+	c, err := l.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ierr error
+	if err := c.Control(func(fd uintptr) {
+		ierr = ioctl(fd, req, arg)
+	}); err != nil {
+		return err
+	}
+	return ierr
+}
+
+// Values returns the values of the lines, in the order of the offsets.
+// Active low lines are inverted.
+func (l *Lines) Values() ([]Value, error) {
+	v := lineValues{Mask: uint64(1)<<len(l.offsets) - 1}
+	if len(l.offsets) == maxLines {
+		v.Mask = ^uint64(0)
+	}
+	if err := l.control(lineGetValues, unsafe.Pointer(&v)); err != nil {
+		return nil, fmt.Errorf("gpio: getting values: %v", err)
+	}
+	vals := make([]Value, len(l.offsets))
+	for i := range vals {
+		vals[i] = v.Bits&(1<<i) != 0
+	}
+	return vals, nil
+}
+
+// SetValues sets the values of the outputs, in the order of the offsets.
+// Lines after the values are left as they are.
+func (l *Lines) SetValues(vals []Value) error {
+	if len(vals) > len(l.offsets) {
+		return fmt.Errorf("gpio: %d values for %d lines", len(vals), len(l.offsets))
+	}
+	var v lineValues
+	for i, val := range vals {
+		v.Mask |= 1 << i
+		if val == High {
+			v.Bits |= 1 << i
+		}
+	}
+	if err := l.control(lineSetValues, unsafe.Pointer(&v)); err != nil {
+		return fmt.Errorf("gpio: setting values: %v", err)
+	}
+	return nil
+}
+
+// Reconfigure changes the configuration of the lines. The consumer and
+// event buffer size cannot be changed.
+func (l *Lines) Reconfigure(cfg LineConfig) error {
+	lc, err := cfg.config(len(l.offsets))
+	if err != nil {
+		return err
+	}
+	if err := l.control(lineSetConfig, unsafe.Pointer(&lc)); err != nil {
+		return fmt.Errorf("gpio: setting config: %v", err)
+	}
+	return nil
+}
+
+// EventType is the type of an edge event.
+type EventType uint32
+
+// Edge event types.
+const (
+	RisingEdge  EventType = 1
+	FallingEdge EventType = 2
+)
+
+func (t EventType) String() string {
+	switch t {
+	case RisingEdge:
+		return "rising"
+	case FallingEdge:
+		return "falling"
+	}
+	return fmt.Sprintf("EventType(%d)", uint32(t))
+}
+
+// Event is an edge event of a line.
+type Event struct {
+	// Timestamp is the time of the event, by default from
+	// CLOCK_MONOTONIC, or CLOCK_REALTIME with FlagEventClockRealtime.
+	Timestamp time.Duration
+	// Type is the edge.
+	Type EventType
+	// Offset is the offset of the line on the chip.
+	Offset int
+	// Seqno is the sequence number of the event among all the lines of
+	// the request.
+	Seqno uint32
+	// LineSeqno is the sequence number of the event on this line.
+	LineSeqno uint32
+}
+
+// eventSize is the size of struct gpio_v2_line_event.
+const eventSize = int(unsafe.Sizeof(lineEvent{}))
+
+// ReadEvent waits for the next edge event of lines requested with
+// FlagEdgeRising or FlagEdgeFalling.
+func (l *Lines) ReadEvent() (*Event, error) {
+	var ev lineEvent
+	b := (*[eventSize]byte)(unsafe.Pointer(&ev))[:]
+	n, err := l.f.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != eventSize {
+		return nil, fmt.Errorf("gpio: short event of %d bytes", n)
+	}
+	return &Event{
+		Timestamp: time.Duration(ev.Timestamp),
+		Type:      EventType(ev.ID),
+		Offset:    int(ev.Offset),
+		Seqno:     ev.Seqno,
+		LineSeqno: ev.LineSeqno,
+	}, nil
+}
+
+// SetDeadline sets the deadline of ReadEvent.
+func (l *Lines) SetDeadline(t time.Time) error {
+	return l.f.SetDeadline(t)
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gpio
+
+import (
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestStructSizes(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		got  uintptr
+		want uintptr
+	}{
+		{"gpiochip_info", unsafe.Sizeof(chipInfo{}), getChipInfo >> 16 & 0x3fff},
+		{"gpio_v2_line_info", unsafe.Sizeof(lineInfo{}), getLineInfo >> 16 & 0x3fff},
+		{"gpio_v2_line_request", unsafe.Sizeof(lineRequest{}), getLine >> 16 & 0x3fff},
+		{"gpio_v2_line_config", unsafe.Sizeof(lineConfig{}), lineSetConfig >> 16 & 0x3fff},
+		{"gpio_v2_line_values", unsafe.Sizeof(lineValues{}), lineGetValues >> 16 & 0x3fff},
+		{"gpio_v2_line_event", unsafe.Sizeof(lineEvent{}), 48},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("size of %s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		flags LineFlag
+		ok    bool
+	}{
+		{FlagInput | FlagEdgeRising | FlagEdgeFalling | FlagBiasPullUp, true},
+		{FlagOutput | FlagOpenDrain | FlagActiveLow, true},
+		{FlagInput | FlagOutput, false},
+		{FlagEdgeRising, false},
+		{FlagInput | FlagOpenSource, false},
+		{FlagOutput | FlagOpenDrain | FlagOpenSource, false},
+		{FlagBiasPullDown, false},
+		{FlagInput | FlagBiasPullUp | FlagBiasPullDown, false},
+		{FlagUsed | FlagInput, false},
+	} {
+		if err := tt.flags.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%v.Validate() = %v, want ok %v", tt.flags, err, tt.ok)
+		}
+	}
+	if s := (FlagOutput | FlagActiveLow | 1<<40).String(); s != "active-low,output,0x10000000000" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	cfg := LineConfig{
+		Consumer: "test",
+		Flags:    FlagOutput,
+		Values:   []Value{High, Low, High},
+	}
+	r, err := cfg.request([]int{4, 5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.NumLines != 3 || r.Offsets[2] != 6 || cstring(r.Consumer[:]) != "test" {
+		t.Errorf("request = %+v", r)
+	}
+	a := r.Config.Attrs[0]
+	if r.Config.NumAttrs != 1 || a.Attr.ID != attrOutputVals || a.Attr.Value != 0b101 || a.Mask != 0b111 {
+		t.Errorf("output values attribute = %+v", a)
+	}
+
+	cfg = LineConfig{Flags: FlagInput | FlagEdgeFalling, Debounce: 5 * time.Millisecond}
+	r, err = cfg.request([]int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := r.Config.Attrs[0]; a.Attr.ID != attrDebounce || debouncePeriod(&a.Attr.Value) != 5*time.Millisecond || a.Mask != 1 {
+		t.Errorf("debounce attribute = %+v", a)
+	}
+
+	for _, tt := range []struct {
+		cfg     LineConfig
+		offsets []int
+	}{
+		{LineConfig{Flags: FlagInput}, nil},
+		{LineConfig{Flags: FlagInput}, make([]int, maxLines+1)},
+		{LineConfig{Flags: FlagOutput, Values: []Value{High, High}}, []int{1}},
+		{LineConfig{Flags: FlagOutput, Debounce: time.Millisecond}, []int{1}},
+		{LineConfig{Flags: FlagInput, Consumer: string(make([]byte, maxNameSize))}, []int{1}},
+	} {
+		if _, err := tt.cfg.request(tt.offsets); err == nil {
+			t.Errorf("request(%v) with %+v succeeded", tt.offsets, tt.cfg)
+		}
+	}
+}
+
+// mockupChip returns the chip of the gpio-mockup driver with GPIOs 10 to 20.
+func mockupChip(t *testing.T) *Chip {
+	t.Helper()
+	paths, err := Chips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		c, err := OpenChip(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Label() == "gpio-mockup-A" {
+			return c
+		}
+		c.Close()
+	}
+	t.Skip("no gpio-mockup chip")
+	return nil
+}
+
+// The chardev tests use offsets 5 to 7 of the mock chip, GPIOs 15 to 17.
+func TestChipLines(t *testing.T) {
+	testutil.SkipIfNotRoot(t)
+	c := mockupChip(t)
+	defer c.Close()
+	if c.NumLines() != 10 {
+		t.Errorf("NumLines() = %d, want 10", c.NumLines())
+	}
+
+	l, err := c.Request([]int{5, 6}, LineConfig{Consumer: "u-root", Flags: FlagOutput, Values: []Value{High, Low}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	info, err := c.LineInfo(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Consumer != "u-root" || info.Flags&(FlagUsed|FlagOutput) != FlagUsed|FlagOutput {
+		t.Errorf("LineInfo(5) = %+v, want used as an output by u-root", info)
+	}
+	for _, want := range [][]Value{{High, Low}, {Low, High}} {
+		if err := l.SetValues(want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := l.Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("Values() = %v, want %v", got, want)
+		}
+	}
+	if _, err := c.Request([]int{5}, LineConfig{Flags: FlagInput}); err == nil {
+		t.Errorf("requesting a used line succeeded")
+	}
+	if _, err := c.FindLine("no such line"); !os.IsNotExist(err) {
+		t.Errorf("FindLine = %v, want not exist", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	testutil.SkipIfNotRoot(t)
+	c := mockupChip(t)
+	defer c.Close()
+
+	// The debugfs files of gpio-mockup pull the inputs up and down.
+	pull := "/sys/kernel/debug/gpio-mockup/" + c.Name() + "/7"
+	if _, err := os.Stat(pull); err != nil {
+		t.Skip(err)
+	}
+	l, err := c.Request([]int{7}, LineConfig{Flags: FlagInput | FlagEdgeRising | FlagEdgeFalling})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, v := range []string{"1", "0"} {
+		if err := os.WriteFile(pull, []byte(v), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []EventType{RisingEdge, FallingEdge} {
+		ev, err := l.ReadEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != want || ev.Offset != 7 || ev.LineSeqno != uint32(i+1) {
+			t.Errorf("ReadEvent() = %+v, want a %v edge of line 7", ev, want)
+		}
+	}
+}
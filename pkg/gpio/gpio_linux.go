@@ -3,7 +3,8 @@
 // license that can be found in the LICENSE file.
 
 // Package gpio provides functions for interacting with GPIO pins via the
-// GPIO Sysfs Interface for Userspace.
+// GPIO character devices, /dev/gpiochip*, or the deprecated GPIO Sysfs
+// Interface for Userspace, which newer kernels do not have.
 package gpio
 
 import (
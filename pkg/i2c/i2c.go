@@ -0,0 +1,121 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package i2c accesses I2C and SMBus devices through the i2c-dev interface,
+// /dev/i2c-*.
+package i2c
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Functionality are the transactions an adapter supports, I2C_FUNC_*.
+type Functionality uint32
+
+// Functionality bits.
+const (
+	FuncI2C                 Functionality = 0x00000001
+	Func10BitAddr           Functionality = 0x00000002
+	FuncProtocolMangling    Functionality = 0x00000004
+	FuncSMBusPEC            Functionality = 0x00000008
+	FuncNoStart             Functionality = 0x00000010
+	FuncSlave               Functionality = 0x00000020
+	FuncSMBusBlockProcCall  Functionality = 0x00008000
+	FuncSMBusQuick          Functionality = 0x00010000
+	FuncSMBusReadByte       Functionality = 0x00020000
+	FuncSMBusWriteByte      Functionality = 0x00040000
+	FuncSMBusReadByteData   Functionality = 0x00080000
+	FuncSMBusWriteByteData  Functionality = 0x00100000
+	FuncSMBusReadWordData   Functionality = 0x00200000
+	FuncSMBusWriteWordData  Functionality = 0x00400000
+	FuncSMBusProcCall       Functionality = 0x00800000
+	FuncSMBusReadBlockData  Functionality = 0x01000000
+	FuncSMBusWriteBlockData Functionality = 0x02000000
+	FuncSMBusReadI2CBlock   Functionality = 0x04000000
+	FuncSMBusWriteI2CBlock  Functionality = 0x08000000
+	FuncSMBusHostNotify     Functionality = 0x10000000
+)
+
+var funcNames = []struct {
+	f    Functionality
+	name string
+}{
+	{FuncI2C, "I2C"},
+	{Func10BitAddr, "10-bit addressing"},
+	{FuncProtocolMangling, "protocol mangling"},
+	{FuncSMBusPEC, "SMBus PEC"},
+	{FuncNoStart, "no start"},
+	{FuncSlave, "slave"},
+	{FuncSMBusBlockProcCall, "SMBus block process call"},
+	{FuncSMBusQuick, "SMBus quick command"},
+	{FuncSMBusReadByte, "SMBus receive byte"},
+	{FuncSMBusWriteByte, "SMBus send byte"},
+	{FuncSMBusReadByteData, "SMBus read byte"},
+	{FuncSMBusWriteByteData, "SMBus write byte"},
+	{FuncSMBusReadWordData, "SMBus read word"},
+	{FuncSMBusWriteWordData, "SMBus write word"},
+	{FuncSMBusProcCall, "SMBus process call"},
+	{FuncSMBusReadBlockData, "SMBus block read"},
+	{FuncSMBusWriteBlockData, "SMBus block write"},
+	{FuncSMBusReadI2CBlock, "I2C block read"},
+	{FuncSMBusWriteI2CBlock, "I2C block write"},
+	{FuncSMBusHostNotify, "SMBus host notify"},
+}
+
+// Has returns whether all the functionality of want is supported.
+func (f Functionality) Has(want Functionality) bool {
+	return f&want == want
+}
+
+func (f Functionality) String() string {
+	var s []string
+	for _, n := range funcNames {
+		if f.Has(n.f) {
+			s = append(s, n.name)
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// MsgFlags are flags of a Msg, I2C_M_*.
+type MsgFlags uint16
+
+// Msg flags.
+const (
+	// Read reads into Buf instead of writing it.
+	Read MsgFlags = 0x0001
+	// Ten uses a 10-bit address.
+	Ten MsgFlags = 0x0010
+	// RecvLen reads the length of the rest of the message from its first
+	// byte, as SMBus block reads do.
+	RecvLen MsgFlags = 0x0400
+	// NoStart leaves out the start condition and address, to continue
+	// the previous message.
+	NoStart MsgFlags = 0x4000
+)
+
+// Msg is a message of a combined transaction.
+type Msg struct {
+	Addr  uint16
+	Flags MsgFlags
+	Buf   []byte
+}
+
+// Maximum sizes.
+const (
+	// BlockMax is the maximum length of SMBus and I2C block transfers.
+	BlockMax = 32
+	// MaxMsgs is the maximum number of messages of a transaction.
+	MaxMsgs = 42
+)
+
+// CheckAddr checks that addr is a 7-bit address other than the reserved
+// ones, 0x00-0x02 and 0x78-0x7f, unless all is set.
+func CheckAddr(addr uint16, all bool) error {
+	if addr > 0x7f || !all && (addr < 0x03 || addr > 0x77) {
+		return fmt.Errorf("i2c: address %#02x is out of range", addr)
+	}
+	return nil
+}
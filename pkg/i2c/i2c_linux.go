@@ -0,0 +1,335 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package i2c
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctls, from include/uapi/linux/i2c-dev.h.
+const (
+	i2cSlave      = 0x0703
+	i2cSlaveForce = 0x0706
+	i2cFuncs      = 0x0705
+	i2cRDWR       = 0x0707
+	i2cPEC        = 0x0708
+	i2cSMBus      = 0x0720
+)
+
+// SMBus transaction sizes, from include/uapi/linux/i2c.h.
+const (
+	smbusRead  = 1
+	smbusWrite = 0
+
+	smbusQuick         = 0
+	smbusByte          = 1
+	smbusByteData      = 2
+	smbusWordData      = 3
+	smbusProcCall      = 4
+	smbusBlockData     = 5
+	smbusI2CBlockData  = 8
+	smbusBlockDataSize = BlockMax + 2
+)
+
+// smbusData is union i2c_smbus_data.
+type smbusData [smbusBlockDataSize]byte
+
+// word is the __u16 word of the union.
+func (d *smbusData) word() *uint16 {
+	return (*uint16)(unsafe.Pointer(&d[0]))
+}
+
+// smbusIoctlData is struct i2c_smbus_ioctl_data.
+type smbusIoctlData struct {
+	ReadWrite uint8
+	Command   uint8
+	Size      uint32
+	Data      uintptr
+}
+
+// i2cMsg is struct i2c_msg.
+type i2cMsg struct {
+	Addr  uint16
+	Flags uint16
+	Len   uint16
+	Buf   uintptr
+}
+
+// rdwrIoctlData is struct i2c_rdwr_ioctl_data.
+type rdwrIoctlData struct {
+	Msgs  uintptr
+	NMsgs uint32
+}
+
+// Adapter is an I2C adapter with an i2c-dev device.
+type Adapter struct {
+	// Number is the N of /dev/i2c-N.
+	Number int
+	// Name is the name of the adapter.
+	Name string
+}
+
+// Path returns the path of the i2c-dev device.
+func (a Adapter) Path() string {
+	return fmt.Sprintf("/dev/i2c-%d", a.Number)
+}
+
+// Adapters returns the adapters with i2c-dev devices, sorted by number.
+func Adapters() ([]Adapter, error) {
+	dirs, err := filepath.Glob("/sys/class/i2c-dev/i2c-*")
+	if err != nil {
+		return nil, err
+	}
+	var as []Adapter
+	for _, d := range dirs {
+		n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(d), "i2c-"))
+		if err != nil {
+			continue
+		}
+		name, err := os.ReadFile(filepath.Join(d, "name"))
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, Adapter{Number: n, Name: strings.TrimSpace(string(name))})
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].Number < as[j].Number })
+	return as, nil
+}
+
+// Bus is an I2C adapter. Each transaction takes the address of the device.
+type Bus struct {
+	f     *os.File
+	funcs Functionality
+	// addr is the address set with I2C_SLAVE, or -1.
+	addr int
+
+	// Force accesses devices even if a kernel driver uses them.
+	Force bool
+}
+
+func ioctl(f *os.File, req uintptr, arg uintptr) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Open opens the i2c-dev device at path, e.g. /dev/i2c-0.
+func Open(path string) (*Bus, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bus{f: f, addr: -1}
+	var funcs uint64
+	if err := ioctl(f, i2cFuncs, uintptr(unsafe.Pointer(&funcs))); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("i2c: %s is not an I2C adapter: %w", path, err)
+	}
+	b.funcs = Functionality(funcs)
+	return b, nil
+}
+
+// OpenBus opens /dev/i2c-n.
+func OpenBus(n int) (*Bus, error) {
+	return Open(fmt.Sprintf("/dev/i2c-%d", n))
+}
+
+// Close closes the bus.
+func (b *Bus) Close() error {
+	return b.f.Close()
+}
+
+// Functionality returns the transactions the adapter supports.
+func (b *Bus) Functionality() Functionality {
+	return b.funcs
+}
+
+// SetPEC enables or disables Packet Error Checking of SMBus transactions.
+func (b *Bus) SetPEC(on bool) error {
+	var v uintptr
+	if on {
+		v = 1
+	}
+	if err := ioctl(b.f, i2cPEC, v); err != nil {
+		return fmt.Errorf("i2c: setting PEC: %w", err)
+	}
+	return nil
+}
+
+// setAddr sets the address of SMBus transactions. The error wraps
+// unix.EBUSY if a driver uses the device and Force is not set.
+func (b *Bus) setAddr(addr uint16) error {
+	if int(addr) == b.addr {
+		return nil
+	}
+	req := uintptr(i2cSlave)
+	if b.Force {
+		req = i2cSlaveForce
+	}
+	if err := ioctl(b.f, req, uintptr(addr)); err != nil {
+		b.addr = -1
+		return fmt.Errorf("i2c: setting address %#02x: %w", addr, err)
+	}
+	b.addr = int(addr)
+	return nil
+}
+
+// smbus runs an SMBus transaction.
+func (b *Bus) smbus(addr uint16, rw uint8, cmd uint8, size uint32, data *smbusData) error {
+	if err := b.setAddr(addr); err != nil {
+		return err
+	}
+	args := smbusIoctlData{ReadWrite: rw, Command: cmd, Size: size}
+	if data != nil {
+		args.Data = uintptr(unsafe.Pointer(data))
+	}
+	err := ioctl(b.f, i2cSMBus, uintptr(unsafe.Pointer(&args)))
+	runtime.KeepAlive(data)
+	if err != nil {
+		return fmt.Errorf("i2c: SMBus transaction with %#02x: %w", addr, err)
+	}
+	return nil
+}
+
+// Quick sends only the address and the read or write bit.
+func (b *Bus) Quick(addr uint16, read bool) error {
+	rw := uint8(smbusWrite)
+	if read {
+		rw = smbusRead
+	}
+	return b.smbus(addr, rw, 0, smbusQuick, nil)
+}
+
+// ReceiveByte receives a byte.
+func (b *Bus) ReceiveByte(addr uint16) (byte, error) {
+	var d smbusData
+	err := b.smbus(addr, smbusRead, 0, smbusByte, &d)
+	return d[0], err
+}
+
+// SendByte sends a byte.
+func (b *Bus) SendByte(addr uint16, v byte) error {
+	return b.smbus(addr, smbusWrite, v, smbusByte, nil)
+}
+
+// ReadByteData reads the byte of register cmd.
+func (b *Bus) ReadByteData(addr uint16, cmd byte) (byte, error) {
+	var d smbusData
+	err := b.smbus(addr, smbusRead, cmd, smbusByteData, &d)
+	return d[0], err
+}
+
+// WriteByteData writes the byte of register cmd.
+func (b *Bus) WriteByteData(addr uint16, cmd byte, v byte) error {
+	d := smbusData{v}
+	return b.smbus(addr, smbusWrite, cmd, smbusByteData, &d)
+}
+
+// ReadWordData reads the word of register cmd.
+func (b *Bus) ReadWordData(addr uint16, cmd byte) (uint16, error) {
+	var d smbusData
+	err := b.smbus(addr, smbusRead, cmd, smbusWordData, &d)
+	return *d.word(), err
+}
+
+// WriteWordData writes the word of register cmd.
+func (b *Bus) WriteWordData(addr uint16, cmd byte, v uint16) error {
+	var d smbusData
+	*d.word() = v
+	return b.smbus(addr, smbusWrite, cmd, smbusWordData, &d)
+}
+
+// ProcessCall writes the word of register cmd and reads back a word.
+func (b *Bus) ProcessCall(addr uint16, cmd byte, v uint16) (uint16, error) {
+	var d smbusData
+	*d.word() = v
+	err := b.smbus(addr, smbusWrite, cmd, smbusProcCall, &d)
+	return *d.word(), err
+}
+
+// ReadBlockData reads an SMBus block, whose first byte is its length, from
+// register cmd.
+func (b *Bus) ReadBlockData(addr uint16, cmd byte) ([]byte, error) {
+	var d smbusData
+	if err := b.smbus(addr, smbusRead, cmd, smbusBlockData, &d); err != nil {
+		return nil, err
+	}
+	n := int(d[0])
+	if n > BlockMax {
+		return nil, fmt.Errorf("i2c: block of %d bytes is longer than %d", n, BlockMax)
+	}
+	return append([]byte{}, d[1:1+n]...), nil
+}
+
+// WriteBlockData writes an SMBus block to register cmd.
+func (b *Bus) WriteBlockData(addr uint16, cmd byte, p []byte) error {
+	if len(p) > BlockMax {
+		return fmt.Errorf("i2c: block of %d bytes is longer than %d", len(p), BlockMax)
+	}
+	var d smbusData
+	d[0] = byte(len(p))
+	copy(d[1:], p)
+	return b.smbus(addr, smbusWrite, cmd, smbusBlockData, &d)
+}
+
+// ReadI2CBlockData reads n bytes from register cmd.
+func (b *Bus) ReadI2CBlockData(addr uint16, cmd byte, n int) ([]byte, error) {
+	if n <= 0 || n > BlockMax {
+		return nil, fmt.Errorf("i2c: block of %d bytes is not 1 to %d", n, BlockMax)
+	}
+	var d smbusData
+	d[0] = byte(n)
+	if err := b.smbus(addr, smbusRead, cmd, smbusI2CBlockData, &d); err != nil {
+		return nil, err
+	}
+	return append([]byte{}, d[1:1+int(d[0])]...), nil
+}
+
+// WriteI2CBlockData writes p to register cmd.
+func (b *Bus) WriteI2CBlockData(addr uint16, cmd byte, p []byte) error {
+	if len(p) > BlockMax {
+		return fmt.Errorf("i2c: block of %d bytes is longer than %d", len(p), BlockMax)
+	}
+	var d smbusData
+	d[0] = byte(len(p))
+	copy(d[1:], p)
+	return b.smbus(addr, smbusWrite, cmd, smbusI2CBlockData, &d)
+}
+
+// Transfer runs a combined transaction of msgs, with a repeated start
+// between them. The adapter has to support FuncI2C.
+func (b *Bus) Transfer(msgs []Msg) error {
+	if len(msgs) == 0 || len(msgs) > MaxMsgs {
+		return fmt.Errorf("i2c: %d messages, want 1 to %d", len(msgs), MaxMsgs)
+	}
+	raw := make([]i2cMsg, len(msgs))
+	for i, m := range msgs {
+		if len(m.Buf) > 0xffff {
+			return fmt.Errorf("i2c: message of %d bytes is too long", len(m.Buf))
+		}
+		raw[i] = i2cMsg{Addr: m.Addr, Flags: uint16(m.Flags), Len: uint16(len(m.Buf))}
+		if len(m.Buf) != 0 {
+			raw[i].Buf = uintptr(unsafe.Pointer(&m.Buf[0]))
+		}
+	}
+	args := rdwrIoctlData{Msgs: uintptr(unsafe.Pointer(&raw[0])), NMsgs: uint32(len(raw))}
+	err := ioctl(b.f, i2cRDWR, uintptr(unsafe.Pointer(&args)))
+	runtime.KeepAlive(raw)
+	runtime.KeepAlive(msgs)
+	if err != nil {
+		return fmt.Errorf("i2c: transfer: %w", err)
+	}
+	return nil
+}
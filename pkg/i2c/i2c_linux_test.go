@@ -0,0 +1,93 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package i2c
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestStructSizes(t *testing.T) {
+	ptr := unsafe.Sizeof(uintptr(0))
+	for _, tt := range []struct {
+		name string
+		got  uintptr
+		want uintptr
+	}{
+		{"i2c_smbus_data", unsafe.Sizeof(smbusData{}), 34},
+		{"i2c_smbus_ioctl_data", unsafe.Sizeof(smbusIoctlData{}), 8 + ptr},
+		{"i2c_msg", unsafe.Sizeof(i2cMsg{}), 2 * ptr},
+		{"i2c_rdwr_ioctl_data", unsafe.Sizeof(rdwrIoctlData{}), 2 * ptr},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("size of %s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// stubBus returns the bus of the i2c-stub driver, loaded with
+// modprobe i2c-stub chip_addr=0x50.
+func stubBus(t *testing.T) *Bus {
+	t.Helper()
+	as, err := Adapters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range as {
+		if a.Name != "SMBus stub driver" {
+			continue
+		}
+		b, err := Open(a.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	t.Skip("no i2c-stub adapter")
+	return nil
+}
+
+func TestSMBus(t *testing.T) {
+	testutil.SkipIfNotRoot(t)
+	b := stubBus(t)
+	defer b.Close()
+	if !b.Functionality().Has(FuncSMBusQuick | FuncSMBusReadByteData | FuncSMBusWriteWordData) {
+		t.Fatalf("Functionality() = %v", b.Functionality())
+	}
+	if err := b.Quick(0x50, false); err != nil {
+		t.Errorf("Quick(0x50) = %v, want nil", err)
+	}
+	if err := b.Quick(0x51, false); err == nil {
+		t.Errorf("Quick(0x51) succeeded, want no device")
+	}
+
+	if err := b.WriteByteData(0x50, 0x10, 0xa5); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.ReadByteData(0x50, 0x10); err != nil || v != 0xa5 {
+		t.Errorf("ReadByteData(0x50, 0x10) = %#x, %v, want 0xa5", v, err)
+	}
+	if err := b.WriteWordData(0x50, 0x20, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.ReadWordData(0x50, 0x20); err != nil || v != 0x1234 {
+		t.Errorf("ReadWordData(0x50, 0x20) = %#x, %v, want 0x1234", v, err)
+	}
+	if v, err := b.ReadByteData(0x50, 0x21); err != nil || v != 0x12 {
+		t.Errorf("ReadByteData(0x50, 0x21) = %#x, %v, want 0x12", v, err)
+	}
+
+	want := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if err := b.WriteI2CBlockData(0x50, 0x40, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.ReadI2CBlockData(0x50, 0x40, len(want))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("ReadI2CBlockData(0x50, 0x40) = % x, %v, want % x", got, err, want)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package i2c
+
+import "testing"
+
+func TestFunctionality(t *testing.T) {
+	f := FuncI2C | FuncSMBusQuick | FuncSMBusReadByteData
+	if !f.Has(FuncI2C|FuncSMBusQuick) || f.Has(FuncI2C|FuncSMBusWriteByteData) {
+		t.Errorf("Has is wrong for %#x", uint32(f))
+	}
+	if got, want := f.String(), "I2C, SMBus quick command, SMBus read byte"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestCheckAddr(t *testing.T) {
+	for _, tt := range []struct {
+		addr uint16
+		all  bool
+		ok   bool
+	}{
+		{0x03, false, true},
+		{0x77, false, true},
+		{0x02, false, false},
+		{0x78, false, false},
+		{0x00, true, true},
+		{0x7f, true, true},
+		{0x80, true, false},
+	} {
+		if err := CheckAddr(tt.addr, tt.all); (err == nil) != tt.ok {
+			t.Errorf("CheckAddr(%#x, %v) = %v, want ok %v", tt.addr, tt.all, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spd
+
+import "fmt"
+
+// Bus is the SMBus access needed to read SPD EEPROMs. *i2c.Bus implements it.
+type Bus interface {
+	SendByte(addr uint16, v byte) error
+	ReadByteData(addr uint16, cmd byte) (byte, error)
+	WriteByteData(addr uint16, cmd byte, v byte) error
+}
+
+// Addresses of SPD devices.
+const (
+	// FirstAddr and LastAddr bound the addresses of SPD EEPROMs, one
+	// per DIMM slot.
+	FirstAddr = 0x50
+	LastAddr  = 0x57
+
+	// DDR4 EE1004 EEPROMs have two pages of 256 bytes, selected by
+	// writing to these addresses, which all EEPROMs on the bus share.
+	ee1004Page0 = 0x36
+	ee1004Page1 = 0x37
+
+	// DDR5 SPD5118 hubs have eight pages of 128 bytes, selected by
+	// register MR11, at offset 0x80 with 1-byte addressing.
+	spd5MR0   = 0x00
+	spd5MR1   = 0x01
+	spd5MR11  = 0x0b
+	spd5NVM   = 0x80
+	spd5Page  = 128
+	spd5Pages = DDR5Size / spd5Page
+)
+
+// isHub returns whether addr is an SPD5118 hub, whose device type in
+// MR0 and MR1 is 0x5118.
+func isHub(b Bus, addr uint16) bool {
+	hi, err := b.ReadByteData(addr, spd5MR0)
+	if err != nil {
+		return false
+	}
+	lo, err := b.ReadByteData(addr, spd5MR1)
+	return err == nil && hi == 0x51 && lo == 0x18
+}
+
+// Read reads the SPD EEPROM at addr, of a DDR4 or DDR5 module.
+func Read(b Bus, addr uint16) ([]byte, error) {
+	if isHub(b, addr) {
+		return readDDR5(b, addr)
+	}
+	// A bus without DDR4 modules may have no EE1004 page select, so
+	// only the reads tell.
+	_ = b.SendByte(ee1004Page0, 0)
+	t, err := b.ReadByteData(addr, 2)
+	if err != nil {
+		return nil, fmt.Errorf("spd: reading %#02x: %w", addr, err)
+	}
+	if Type(t) != DDR4 {
+		return nil, fmt.Errorf("%w: %v at %#02x", ErrUnsupported, Type(t), addr)
+	}
+	return readDDR4(b, addr)
+}
+
+func readDDR4(b Bus, addr uint16) ([]byte, error) {
+	d := make([]byte, 0, DDR4Size)
+	for _, page := range []uint16{ee1004Page0, ee1004Page1} {
+		if err := b.SendByte(page, 0); err != nil {
+			return nil, fmt.Errorf("spd: selecting page: %w", err)
+		}
+		for i := 0; i < 256; i++ {
+			v, err := b.ReadByteData(addr, byte(i))
+			if err != nil {
+				return nil, fmt.Errorf("spd: reading %#02x: %w", addr, err)
+			}
+			d = append(d, v)
+		}
+	}
+	// Leave page 0 selected, as the BIOS and drivers expect.
+	if err := b.SendByte(ee1004Page0, 0); err != nil {
+		return nil, fmt.Errorf("spd: selecting page: %w", err)
+	}
+	return d, nil
+}
+
+func readDDR5(b Bus, addr uint16) ([]byte, error) {
+	d := make([]byte, 0, DDR5Size)
+	for page := 0; page < spd5Pages; page++ {
+		if err := b.WriteByteData(addr, spd5MR11, byte(page)); err != nil {
+			return nil, fmt.Errorf("spd: selecting page %d of %#02x: %w", page, addr, err)
+		}
+		for i := 0; i < spd5Page; i++ {
+			v, err := b.ReadByteData(addr, byte(spd5NVM+i))
+			if err != nil {
+				return nil, fmt.Errorf("spd: reading %#02x: %w", addr, err)
+			}
+			d = append(d, v)
+		}
+	}
+	if err := b.WriteByteData(addr, spd5MR11, 0); err != nil {
+		return nil, fmt.Errorf("spd: selecting page 0 of %#02x: %w", addr, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// fakeBus has EE1004 EEPROMs, which share the page select, and SPD5118
+// hubs.
+type fakeBus struct {
+	ee1004 map[uint16][]byte
+	page   int
+
+	hubs map[uint16][]byte
+	mr11 map[uint16]byte
+}
+
+func (f *fakeBus) SendByte(addr uint16, v byte) error {
+	switch {
+	case len(f.ee1004) == 0:
+		return os.ErrNotExist
+	case addr == ee1004Page0:
+		f.page = 0
+	case addr == ee1004Page1:
+		f.page = 1
+	default:
+		return os.ErrInvalid
+	}
+	return nil
+}
+
+func (f *fakeBus) ReadByteData(addr uint16, cmd byte) (byte, error) {
+	if d, ok := f.ee1004[addr]; ok {
+		return d[f.page*256+int(cmd)], nil
+	}
+	d, ok := f.hubs[addr]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	switch {
+	case cmd == spd5MR0:
+		return 0x51, nil
+	case cmd == spd5MR1:
+		return 0x18, nil
+	case cmd >= spd5NVM:
+		return d[int(f.mr11[addr])*spd5Page+int(cmd-spd5NVM)], nil
+	}
+	return 0, nil
+}
+
+func (f *fakeBus) WriteByteData(addr uint16, cmd byte, v byte) error {
+	if _, ok := f.hubs[addr]; !ok || cmd != spd5MR11 {
+		return os.ErrInvalid
+	}
+	f.mr11[addr] = v
+	return nil
+}
+
+func TestRead(t *testing.T) {
+	d4, d5 := ddr4(), ddr5()
+	for _, tt := range []struct {
+		name string
+		bus  *fakeBus
+		addr uint16
+		want []byte
+		err  error
+	}{
+		{
+			name: "DDR4",
+			bus:  &fakeBus{ee1004: map[uint16][]byte{0x51: d4}, page: 1},
+			addr: 0x51,
+			want: d4,
+		},
+		{
+			name: "DDR5",
+			bus:  &fakeBus{hubs: map[uint16][]byte{0x50: d5}, mr11: map[uint16]byte{0x50: 3}},
+			addr: 0x50,
+			want: d5,
+		},
+		{
+			name: "empty slot",
+			bus:  &fakeBus{hubs: map[uint16][]byte{0x50: d5}, mr11: map[uint16]byte{}},
+			addr: 0x52,
+			err:  os.ErrNotExist,
+		},
+		{
+			name: "not SPD",
+			bus:  &fakeBus{ee1004: map[uint16][]byte{0x50: make([]byte, DDR4Size)}},
+			addr: 0x50,
+			err:  ErrUnsupported,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(tt.bus, tt.addr)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Read(%#02x) = %v, want %v", tt.addr, err, tt.err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Read(%#02x) = % x, want % x", tt.addr, got, tt.want)
+			}
+			if tt.bus.page != 0 {
+				t.Errorf("EE1004 page %d is selected, want 0", tt.bus.page)
+			}
+			for a, p := range tt.bus.mr11 {
+				if p != 0 {
+					t.Errorf("hub %#02x page %d is selected, want 0", a, p)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package spd reads and decodes the Serial Presence Detect EEPROMs of DDR4
+// and DDR5 memory modules, following JEDEC JESD21-C.
+package spd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Type is the memory type, byte 2.
+type Type byte
+
+// Memory types.
+const (
+	DDR4    Type = 0x0c
+	LPDDR4  Type = 0x10
+	LPDDR4X Type = 0x11
+	DDR5    Type = 0x12
+	LPDDR5  Type = 0x13
+)
+
+var typeNames = map[Type]string{
+	DDR4:    "DDR4",
+	LPDDR4:  "LPDDR4",
+	LPDDR4X: "LPDDR4X",
+	DDR5:    "DDR5",
+	LPDDR5:  "LPDDR5",
+}
+
+func (t Type) String() string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("type %#02x", byte(t))
+}
+
+// ModuleType is the base module type, the low nibble of byte 3.
+type ModuleType byte
+
+// Module types.
+const (
+	RDIMM  ModuleType = 1
+	UDIMM  ModuleType = 2
+	SODIMM ModuleType = 3
+	LRDIMM ModuleType = 4
+)
+
+var moduleNames = map[ModuleType]string{
+	RDIMM:  "RDIMM",
+	UDIMM:  "UDIMM",
+	SODIMM: "SO-DIMM",
+	LRDIMM: "LRDIMM",
+}
+
+func (m ModuleType) String() string {
+	if s, ok := moduleNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("module type %d", byte(m))
+}
+
+// Sizes of the SPD EEPROMs.
+const (
+	DDR4Size = 512
+	DDR5Size = 1024
+)
+
+var (
+	// ErrShort is returned for SPD data shorter than its memory type needs.
+	ErrShort = errors.New("spd: data is too short")
+	// ErrUnsupported is returned for memory types other than DDR4 and DDR5.
+	ErrUnsupported = errors.New("spd: unsupported memory type")
+)
+
+// SPD is decoded SPD data.
+type SPD struct {
+	Type   Type
+	Module ModuleType
+	// Size is the capacity of the module in bytes.
+	Size int64
+	// Ranks is the number of package ranks.
+	Ranks int
+	// DeviceWidth is the width of the SDRAM devices in bits.
+	DeviceWidth int
+	// BusWidth is the primary bus width of the module in bits, without
+	// ECC.
+	BusWidth int
+	ECC      bool
+	// Speed is the maximum data rate in MT/s.
+	Speed int
+
+	// ManufacturerID is the JEDEC ID of the module manufacturer, bank
+	// in the high byte and code, with its parity bit as JEP106 lists it, in
+	// the low byte.
+	ManufacturerID uint16
+	Manufacturer   string
+	Year, Week     int
+	Serial         uint32
+	PartNumber     string
+
+	// CRCValid is whether the CRC of the base configuration is valid.
+	CRCValid bool
+}
+
+// CRC16 is the CRC of SPD data, CRC-16/XMODEM.
+func CRC16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// manufacturers are some JEDEC JEP106 IDs, bank<<8 | code.
+var manufacturers = map[uint16]string{
+	0x012c: "Micron",
+	0x01ad: "SK Hynix",
+	0x01ce: "Samsung",
+	0x0198: "Kingston",
+	0x029e: "Corsair",
+	0x040b: "Nanya",
+	0x05cd: "G.Skill",
+	0x069b: "Crucial",
+}
+
+// manufacturer decodes a JEP106 ID in SPD order: the number of
+// continuation codes with odd parity in bit 7, then the code.
+func manufacturer(b []byte) (uint16, string) {
+	id := uint16(b[0]&0x7f+1)<<8 | uint16(b[1])
+	if s, ok := manufacturers[id]; ok {
+		return id, s
+	}
+	return id, fmt.Sprintf("bank %d code %#02x", id>>8, id&0xff)
+}
+
+func bcd(b byte) int {
+	return int(b>>4)*10 + int(b&0xf)
+}
+
+// speeds are the standard data rates, to round the rate computed from
+// tCKmin.
+var speeds = []int{1600, 1866, 2133, 2400, 2666, 2933, 3200, 3600, 4000, 4400, 4800, 5200, 5600, 6000, 6400, 6800, 7200, 7600, 8000, 8400, 8800}
+
+// speed returns the data rate in MT/s of a clock period in ps.
+func speed(tck int) int {
+	if tck <= 0 {
+		return 0
+	}
+	s := 2000000 / tck
+	for _, std := range speeds {
+		if d := s - std; d > -std/100 && d < std/100 {
+			return std
+		}
+	}
+	return s
+}
+
+func (s *SPD) manufacturing(b []byte, pn int) {
+	s.ManufacturerID, s.Manufacturer = manufacturer(b[0:2])
+	s.Year, s.Week = 2000+bcd(b[3]), bcd(b[4])
+	s.Serial = binary.BigEndian.Uint32(b[5:9])
+	s.PartNumber = strings.TrimRight(string(b[9:9+pn]), " \x00")
+}
+
+func decodeDDR4(b []byte) (*SPD, error) {
+	if len(b) < DDR4Size {
+		return nil, fmt.Errorf("%w: %d bytes of DDR4 SPD, want %d", ErrShort, len(b), DDR4Size)
+	}
+	s := &SPD{
+		Type:        DDR4,
+		Module:      ModuleType(b[3] & 0xf),
+		Ranks:       int(b[12]>>3&7) + 1,
+		DeviceWidth: 4 << (b[12] & 7),
+		BusWidth:    8 << (b[13] & 7),
+		ECC:         b[13]>>3&3 == 1,
+		CRCValid:    CRC16(b[0:126]) == binary.LittleEndian.Uint16(b[126:128]),
+	}
+	// Die capacity in Mb: 256 << n.
+	die := int64(256) << (b[4] & 0xf)
+	ranks := int64(s.Ranks)
+	// 3DS packages have a logical rank per die.
+	if b[6]&0x80 != 0 && b[6]&3 == 2 {
+		ranks *= int64(b[6]>>4&7) + 1
+	}
+	s.Size = die << 20 / 8 * int64(s.BusWidth) / int64(s.DeviceWidth) * ranks
+	// tCKAVGmin in 125 ps units, corrected in ps.
+	s.Speed = speed(int(b[18])*125 + int(int8(b[125])))
+	s.manufacturing(b[320:], 20)
+	return s, nil
+}
+
+// ddr5Density are the die densities of DDR5 in Gb.
+var ddr5Density = []int64{0, 4, 8, 12, 16, 24, 32, 48, 64}
+
+// ddr5Dies are the dies per package of DDR5.
+var ddr5Dies = []int64{1, 0, 2, 4, 8, 16}
+
+func decodeDDR5(b []byte) (*SPD, error) {
+	if len(b) < DDR5Size {
+		return nil, fmt.Errorf("%w: %d bytes of DDR5 SPD, want %d", ErrShort, len(b), DDR5Size)
+	}
+	channels := int(b[235]>>5&3) + 1
+	s := &SPD{
+		Type:        DDR5,
+		Module:      ModuleType(b[3] & 0xf),
+		Ranks:       int(b[234]>>3&7) + 1,
+		DeviceWidth: 4 << (b[6] >> 5),
+		BusWidth:    channels * 8 << (b[235] & 7),
+		ECC:         b[235]>>3&3 != 0,
+		CRCValid:    CRC16(b[0:510]) == binary.LittleEndian.Uint16(b[510:512]),
+	}
+	var density, dies int64
+	if n := int(b[4] & 0x1f); n < len(ddr5Density) {
+		density = ddr5Density[n]
+	}
+	if n := int(b[4] >> 5); n < len(ddr5Dies) {
+		dies = ddr5Dies[n]
+	}
+	s.Size = density << 30 / 8 * dies * int64(s.BusWidth) / int64(s.DeviceWidth) * int64(s.Ranks)
+	// tCKAVGmin in ps.
+	s.Speed = speed(int(binary.LittleEndian.Uint16(b[20:22])))
+	s.manufacturing(b[512:], 30)
+	return s, nil
+}
+
+// Decode decodes DDR4 or DDR5 SPD data.
+func Decode(b []byte) (*SPD, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrShort, len(b))
+	}
+	switch t := Type(b[2]); t {
+	case DDR4:
+		return decodeDDR4(b)
+	case DDR5:
+		return decodeDDR5(b)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, t)
+	}
+}
+
+func (s *SPD) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Type: %v %v\n", s.Type, s.Module)
+	ecc := ""
+	if s.ECC {
+		ecc = " ECC"
+	}
+	fmt.Fprintf(&b, "Size: %d MiB, %d rank(s), x%d devices, %d-bit%s\n", s.Size>>20, s.Ranks, s.DeviceWidth, s.BusWidth, ecc)
+	fmt.Fprintf(&b, "Speed: %v-%d\n", s.Type, s.Speed)
+	fmt.Fprintf(&b, "Manufacturer: %s\n", s.Manufacturer)
+	fmt.Fprintf(&b, "Part number: %s\n", s.PartNumber)
+	fmt.Fprintf(&b, "Serial number: %08x\n", s.Serial)
+	fmt.Fprintf(&b, "Manufactured: %d week %d\n", s.Year, s.Week)
+	crc := "ok"
+	if !s.CRCValid {
+		crc = "bad"
+	}
+	fmt.Fprintf(&b, "CRC: %s\n", crc)
+	return b.String()
+}
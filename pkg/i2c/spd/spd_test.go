@@ -0,0 +1,139 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spd
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// ddr4 returns the SPD of a DDR4-3200 8 GiB UDIMM.
+func ddr4() []byte {
+	b := make([]byte, DDR4Size)
+	b[0], b[1], b[2], b[3] = 0x23, 0x11, byte(DDR4), byte(UDIMM)
+	b[4] = 0x45  // 8 Gb, 4 bank groups
+	b[12] = 0x01 // 1 rank, x8
+	b[13] = 0x03 // 64 bits
+	b[18] = 0x05 // 625 ps
+	binary.LittleEndian.PutUint16(b[126:], CRC16(b[0:126]))
+	copy(b[320:], []byte{0x80, 0x2c, 0x00, 0x21, 0x14, 0x12, 0x34, 0x56, 0x78})
+	copy(b[329:], "8ATF1G64AZ-3G2J1    ")
+	return b
+}
+
+// ddr5 returns the SPD of a DDR5-4800 32 GiB ECC RDIMM.
+func ddr5() []byte {
+	b := make([]byte, DDR5Size)
+	b[0], b[1], b[2], b[3] = 0x30, 0x10, byte(DDR5), byte(RDIMM)
+	b[4] = 0x04 // 16 Gb, monolithic
+	b[6] = 0x00 // x4
+	binary.LittleEndian.PutUint16(b[20:], 416)
+	b[234] = 0x00 // 1 rank
+	b[235] = 0x3a // 2 channels of 32 bits with 8-bit ECC each
+	binary.LittleEndian.PutUint16(b[510:], CRC16(b[0:510]))
+	copy(b[512:], []byte{0x80, 0xce, 0x00, 0x22, 0x40, 0xde, 0xad, 0xbe, 0xef})
+	copy(b[521:], "M321R4GA0BB0-CQK")
+	return b
+}
+
+func TestDecode(t *testing.T) {
+	bad := ddr4()
+	bad[18] = 0x06
+	for _, tt := range []struct {
+		name string
+		b    []byte
+		want *SPD
+		err  error
+	}{
+		{
+			name: "DDR4",
+			b:    ddr4(),
+			want: &SPD{
+				Type:           DDR4,
+				Module:         UDIMM,
+				Size:           8 << 30,
+				Ranks:          1,
+				DeviceWidth:    8,
+				BusWidth:       64,
+				Speed:          3200,
+				ManufacturerID: 0x012c,
+				Manufacturer:   "Micron",
+				Year:           2021,
+				Week:           14,
+				Serial:         0x12345678,
+				PartNumber:     "8ATF1G64AZ-3G2J1",
+				CRCValid:       true,
+			},
+		},
+		{
+			name: "bad CRC",
+			b:    bad,
+			want: &SPD{
+				Type:           DDR4,
+				Module:         UDIMM,
+				Size:           8 << 30,
+				Ranks:          1,
+				DeviceWidth:    8,
+				BusWidth:       64,
+				Speed:          2666,
+				ManufacturerID: 0x012c,
+				Manufacturer:   "Micron",
+				Year:           2021,
+				Week:           14,
+				Serial:         0x12345678,
+				PartNumber:     "8ATF1G64AZ-3G2J1",
+			},
+		},
+		{
+			name: "DDR5",
+			b:    ddr5(),
+			want: &SPD{
+				Type:           DDR5,
+				Module:         RDIMM,
+				Size:           32 << 30,
+				Ranks:          1,
+				DeviceWidth:    4,
+				BusWidth:       64,
+				ECC:            true,
+				Speed:          4800,
+				ManufacturerID: 0x01ce,
+				Manufacturer:   "Samsung",
+				Year:           2022,
+				Week:           40,
+				Serial:         0xdeadbeef,
+				PartNumber:     "M321R4GA0BB0-CQK",
+				CRCValid:       true,
+			},
+		},
+		{
+			name: "short",
+			b:    ddr5()[:512],
+			err:  ErrShort,
+		},
+		{
+			name: "DDR3",
+			b:    []byte{0x92, 0x13, 0x0b, 0x02},
+			err:  ErrUnsupported,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.b)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Decode() = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	if got, want := CRC16([]byte("123456789")), uint16(0x31c3); got != want {
+		t.Errorf("CRC16(123456789) = %#04x, want %#04x", got, want)
+	}
+}
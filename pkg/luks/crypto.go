@@ -0,0 +1,194 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/xts"
+)
+
+var hashes = map[string]func() hash.Hash{
+	"sha1":      sha1.New,
+	"sha224":    sha256.New224,
+	"sha256":    sha256.New,
+	"sha384":    sha512.New384,
+	"sha512":    sha512.New,
+	"ripemd160": ripemd160.New,
+}
+
+func newHash(name string) (func() hash.Hash, error) {
+	h, ok := hashes[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash %q", name)
+	}
+	return h, nil
+}
+
+// derive derives a keyLen byte key from passphrase.
+func (k *KDF) derive(passphrase []byte, keyLen int) ([]byte, error) {
+	switch k.Type {
+	case "pbkdf2":
+		h, err := newHash(k.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if k.Iterations <= 0 {
+			return nil, fmt.Errorf("invalid PBKDF2 iterations %d", k.Iterations)
+		}
+		return pbkdf2.Key(passphrase, k.Salt, k.Iterations, keyLen, h), nil
+	case "argon2i", "argon2id":
+		if k.Time <= 0 || k.Memory <= 0 || k.CPUs <= 0 || k.CPUs > 255 {
+			return nil, fmt.Errorf("invalid %s costs: time %d, memory %d, cpus %d", k.Type, k.Time, k.Memory, k.CPUs)
+		}
+		if k.Type == "argon2i" {
+			return argon2.Key(passphrase, k.Salt, uint32(k.Time), uint32(k.Memory), uint8(k.CPUs), uint32(keyLen)), nil
+		}
+		return argon2.IDKey(passphrase, k.Salt, uint32(k.Time), uint32(k.Memory), uint8(k.CPUs), uint32(keyLen)), nil
+	default:
+		return nil, fmt.Errorf("unsupported KDF %q", k.Type)
+	}
+}
+
+// diffuse is the anti-forensic splitter's hash diffusion of b: every
+// digest sized block i is replaced by H(i || block).
+func diffuse(b []byte, newHash func() hash.Hash) {
+	h := newHash()
+	size := h.Size()
+	var idx [4]byte
+	for i := 0; i*size < len(b); i++ {
+		end := (i + 1) * size
+		if end > len(b) {
+			end = len(b)
+		}
+		h.Reset()
+		binary.BigEndian.PutUint32(idx[:], uint32(i))
+		h.Write(idx[:])
+		h.Write(b[i*size : end])
+		copy(b[i*size:end], h.Sum(nil))
+	}
+}
+
+// afMerge recovers a keyLen byte key from stripes anti-forensic stripes.
+func afMerge(material []byte, keyLen, stripes int, hashName string) ([]byte, error) {
+	h, err := newHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+	if stripes < 1 || len(material) < keyLen*stripes {
+		return nil, fmt.Errorf("%d bytes of key material for %d stripes of %d bytes", len(material), stripes, keyLen)
+	}
+	d := make([]byte, keyLen)
+	for i := 0; i < stripes-1; i++ {
+		for j, s := range material[i*keyLen : (i+1)*keyLen] {
+			d[j] ^= s
+		}
+		diffuse(d, h)
+	}
+	for j, s := range material[(stripes-1)*keyLen : stripes*keyLen] {
+		d[j] ^= s
+	}
+	return d, nil
+}
+
+// sectorCipher decrypts disk sectors like dm-crypt.
+type sectorCipher interface {
+	decrypt(dst, src []byte, sector uint64)
+}
+
+// newSectorCipher returns the cipher for a dm-crypt cipher spec like
+// aes-xts-plain64 or aes-cbc-essiv:sha256.
+func newSectorCipher(spec string, key []byte) (sectorCipher, error) {
+	f := strings.SplitN(spec, "-", 3)
+	if len(f) != 3 {
+		return nil, fmt.Errorf("invalid cipher %q", spec)
+	}
+	if f[0] != "aes" {
+		return nil, fmt.Errorf("unsupported cipher %q", spec)
+	}
+	iv, ivArg := f[2], ""
+	if i := strings.Index(iv, ":"); i >= 0 {
+		iv, ivArg = iv[:i], iv[i+1:]
+	}
+
+	switch f[1] {
+	case "xts":
+		if iv != "plain" && iv != "plain64" {
+			return nil, fmt.Errorf("unsupported IV %q for XTS", f[2])
+		}
+		c, err := xts.NewCipher(aes.NewCipher, key)
+		if err != nil {
+			return nil, err
+		}
+		return &xtsCipher{c: c, plain32: iv == "plain"}, nil
+
+	case "cbc":
+		b, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		c := &cbcCipher{b: b, plain32: iv == "plain"}
+		switch iv {
+		case "plain", "plain64":
+		case "essiv":
+			h, err := newHash(ivArg)
+			if err != nil {
+				return nil, err
+			}
+			d := h()
+			d.Write(key)
+			if c.essiv, err = aes.NewCipher(d.Sum(nil)); err != nil {
+				return nil, fmt.Errorf("ESSIV: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported IV %q for CBC", f[2])
+		}
+		return c, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported cipher mode %q", spec)
+	}
+}
+
+type xtsCipher struct {
+	c       *xts.Cipher
+	plain32 bool
+}
+
+func (x *xtsCipher) decrypt(dst, src []byte, sector uint64) {
+	if x.plain32 {
+		sector &= 0xffffffff
+	}
+	x.c.Decrypt(dst, src, sector)
+}
+
+type cbcCipher struct {
+	b       cipher.Block
+	essiv   cipher.Block
+	plain32 bool
+}
+
+func (c *cbcCipher) decrypt(dst, src []byte, sector uint64) {
+	if c.plain32 {
+		sector &= 0xffffffff
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.LittleEndian.PutUint64(iv, sector)
+	if c.essiv != nil {
+		c.essiv.Encrypt(iv, iv)
+	}
+	cipher.NewCBCDecrypter(c.b, iv).CryptBlocks(dst, src)
+}
@@ -0,0 +1,36 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/mount/dm"
+)
+
+// Table returns the dm-crypt table mapping the payload of device, of size
+// bytes, with key.
+func (h *Header) Table(device string, size uint64, key []byte) ([]dm.Target, error) {
+	end := size
+	if h.PayloadSize != 0 {
+		end = h.PayloadOffset + h.PayloadSize
+	}
+	if end <= h.PayloadOffset || end > size {
+		return nil, fmt.Errorf("payload at %d does not fit the %d byte device", h.PayloadOffset, size)
+	}
+	var opts []string
+	if h.SectorSize > dm.SectorSize {
+		opts = append(opts, fmt.Sprintf("sector_size:%d", h.SectorSize))
+	}
+	return []dm.Target{
+		dm.Crypt(0, (end-h.PayloadOffset)/dm.SectorSize, h.Cipher, key, h.IVOffset, device, h.PayloadOffset/dm.SectorSize, opts...),
+	}, nil
+}
+
+// uuid is the device-mapper UUID cryptsetup gives the mapping name.
+func (h *Header) uuid(name string) string {
+	return fmt.Sprintf("CRYPT-LUKS%d-%s-%s", h.Version, strings.ReplaceAll(h.UUID, "-", ""), name)
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/mount/dm"
+)
+
+// Open unlocks the LUKS volume device with passphrase and maps it as
+// /dev/mapper/name. It returns the path of the mapped device.
+//
+// device must be a block device; attach files to a loop device first.
+func Open(device, name string, passphrase []byte, readOnly bool) (string, error) {
+	return open(device, name, readOnly, func(h *Header, r io.ReaderAt) ([]byte, error) {
+		key, _, err := h.Unlock(r, passphrase)
+		return key, err
+	})
+}
+
+// OpenKeyslot is like Open, but only tries the keyslot id.
+func OpenKeyslot(device, name string, id int, passphrase []byte, readOnly bool) (string, error) {
+	return open(device, name, readOnly, func(h *Header, r io.ReaderAt) ([]byte, error) {
+		return h.UnlockKeyslot(r, id, passphrase)
+	})
+}
+
+func open(device, name string, readOnly bool, unlock func(*Header, io.ReaderAt) ([]byte, error)) (string, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h, err := ReadHeader(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", device, err)
+	}
+	key, err := unlock(h, f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", device, err)
+	}
+	defer func() {
+		for i := range key {
+			key[i] = 0
+		}
+	}()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	t, err := h.Table(device, uint64(size), key)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", device, err)
+	}
+	return dm.CreateDevice(name, h.uuid(name), t, readOnly)
+}
+
+// Close removes the mapping name.
+func Close(name string) error {
+	return dm.RemoveDevice(name)
+}
+
+// Status describes an open mapping.
+type Status struct {
+	dm.Info
+
+	// Cipher, KeySize in bytes, Device, and Offset and Size in sectors
+	// are from the crypt target.
+	Cipher  string
+	KeySize int
+	Device  string
+	Offset  uint64
+	Size    uint64
+}
+
+// GetStatus returns the status of the crypt mapping name.
+func GetStatus(name string) (*Status, error) {
+	c, err := dm.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	i, targets, err := c.Table(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &Status{Info: *i}
+	if len(targets) != 1 || targets[0].Type != "crypt" {
+		return nil, fmt.Errorf("%s is not a crypt mapping", name)
+	}
+	// cipher key iv_offset device offset [options]
+	var key string
+	if _, err := fmt.Sscanf(targets[0].Params, "%s %s %d %s %d", &s.Cipher, &key, new(uint64), &s.Device, &s.Offset); err != nil {
+		return nil, fmt.Errorf("parsing crypt table %q: %v", targets[0].Params, err)
+	}
+	// The key may be a keyring reference like :64:logon:name.
+	if strings.HasPrefix(key, ":") {
+		fmt.Sscanf(key, ":%d:", &s.KeySize)
+	} else {
+		s.KeySize = len(key) / 2
+	}
+	s.Size = targets[0].Length
+	return s, nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package luks reads LUKS1 and LUKS2 encrypted volume headers, recovers
+// the volume key from a passphrase and maps the volume with dm-crypt.
+//
+// The on-disk formats are described in the LUKS1 On-Disk Format
+// Specification and the LUKS2 On-Disk Format Specification from the
+// cryptsetup project.
+package luks
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var (
+	// ErrNotLUKS is returned for devices without a LUKS header.
+	ErrNotLUKS = errors.New("not a LUKS device")
+
+	// ErrWrongPassphrase is returned if no keyslot opens with the
+	// passphrase.
+	ErrWrongPassphrase = errors.New("no keyslot matches the passphrase")
+)
+
+var (
+	magic1 = []byte("LUKS\xba\xbe")
+	magic2 = []byte("SKUL\xba\xbe")
+)
+
+// KDF describes how a keyslot key is derived from a passphrase.
+type KDF struct {
+	// Type is pbkdf2, argon2i or argon2id.
+	Type string
+
+	// Hash is the PBKDF2 hash.
+	Hash string
+
+	// Iterations is the PBKDF2 iteration count.
+	Iterations int
+
+	// Time, Memory in KiB and CPUs are the Argon2 costs.
+	Time   int
+	Memory int
+	CPUs   int
+
+	Salt []byte
+}
+
+// Keyslot is an encrypted, anti-forensic split copy of the volume key.
+type Keyslot struct {
+	ID int
+
+	// Priority orders keyslots; 0 means only use when asked for, 2 try
+	// first.
+	Priority int
+
+	KDF KDF
+
+	// Offset and Size locate the key material in bytes.
+	Offset uint64
+	Size   uint64
+
+	// Cipher and KeySize encrypt the key material.
+	Cipher  string
+	KeySize int
+
+	// Stripes and AFHash are the anti-forensic splitter parameters.
+	Stripes int
+	AFHash  string
+
+	// digest verifies the volume key recovered from this keyslot.
+	digest *digest
+}
+
+// digest verifies a volume key: PBKDF2(key, Salt, Iterations) = Digest.
+type digest struct {
+	Hash       string
+	Iterations int
+	Salt       []byte
+	Digest     []byte
+}
+
+// Header is a parsed LUKS header.
+type Header struct {
+	// Version is 1 or 2.
+	Version int
+
+	UUID  string
+	Label string
+
+	// Cipher is the dm-crypt cipher of the data, e.g. aes-xts-plain64.
+	Cipher string
+
+	// KeySize is the volume key size in bytes.
+	KeySize int
+
+	// PayloadOffset is where the encrypted data starts, in bytes.
+	PayloadOffset uint64
+
+	// PayloadSize is the size of the encrypted data in bytes, or 0 if
+	// it extends to the end of the device.
+	PayloadSize uint64
+
+	// SectorSize is the encryption sector size of the data.
+	SectorSize int
+
+	// IVOffset is added to sector numbers to compute IVs, in sectors.
+	IVOffset uint64
+
+	// Keyslots are the active keyslots.
+	Keyslots []Keyslot
+}
+
+// ReadHeader reads the LUKS header of the volume r.
+func ReadHeader(r io.ReaderAt) (*Header, error) {
+	var b [8]byte
+	if _, err := r.ReadAt(b[:], 0); err != nil {
+		return nil, fmt.Errorf("reading LUKS header: %w", err)
+	}
+	if !bytes.Equal(b[:6], magic1) && !bytes.Equal(b[:6], magic2) {
+		// The primary LUKS2 header may be damaged.
+		if h, err := readLUKS2(r); err == nil {
+			return h, nil
+		}
+		return nil, ErrNotLUKS
+	}
+	switch v := int(b[6])<<8 | int(b[7]); v {
+	case 1:
+		return readLUKS1(r)
+	case 2:
+		return readLUKS2(r)
+	default:
+		return nil, fmt.Errorf("unsupported LUKS version %d", v)
+	}
+}
+
+// IsLUKS reports whether r starts with a LUKS header.
+func IsLUKS(r io.ReaderAt) bool {
+	_, err := ReadHeader(r)
+	return err == nil
+}
+
+// keyslotOrder returns the keyslots to try, highest priority first.
+func (h *Header) keyslotOrder() []Keyslot {
+	var ks []Keyslot
+	for _, k := range h.Keyslots {
+		if k.Priority > 0 {
+			ks = append(ks, k)
+		}
+	}
+	sort.SliceStable(ks, func(i, j int) bool { return ks[i].Priority > ks[j].Priority })
+	return ks
+}
+
+// Unlock recovers the volume key of r from passphrase, trying all
+// keyslots. It returns the key and the keyslot that opened.
+func (h *Header) Unlock(r io.ReaderAt, passphrase []byte) ([]byte, int, error) {
+	for _, k := range h.keyslotOrder() {
+		key, err := h.unlock(r, &k, passphrase)
+		if err == ErrWrongPassphrase {
+			continue
+		}
+		if err != nil {
+			return nil, -1, fmt.Errorf("keyslot %d: %w", k.ID, err)
+		}
+		return key, k.ID, nil
+	}
+	return nil, -1, ErrWrongPassphrase
+}
+
+// UnlockKeyslot recovers the volume key using only keyslot id.
+func (h *Header) UnlockKeyslot(r io.ReaderAt, id int, passphrase []byte) ([]byte, error) {
+	for _, k := range h.Keyslots {
+		if k.ID == id {
+			return h.unlock(r, &k, passphrase)
+		}
+	}
+	return nil, fmt.Errorf("keyslot %d is not active", id)
+}
+
+func (h *Header) unlock(r io.ReaderAt, k *Keyslot, passphrase []byte) ([]byte, error) {
+	if k.digest == nil {
+		return nil, errors.New("no digest for keyslot")
+	}
+	key, err := k.KDF.derive(passphrase, k.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newSectorCipher(k.Cipher, key)
+	if err != nil {
+		return nil, err
+	}
+
+	afSize := uint64(h.KeySize) * uint64(k.Stripes)
+	// The key material is encrypted in whole 512 byte sectors.
+	n := (afSize + keyslotSectorSize - 1) / keyslotSectorSize * keyslotSectorSize
+	if k.Size != 0 && n > k.Size {
+		return nil, fmt.Errorf("%d bytes of key material exceed the %d byte area", n, k.Size)
+	}
+	material := make([]byte, n)
+	if _, err := r.ReadAt(material, int64(k.Offset)); err != nil {
+		return nil, fmt.Errorf("reading key material: %w", err)
+	}
+	for s := uint64(0); s < n/keyslotSectorSize; s++ {
+		sector := material[s*keyslotSectorSize : (s+1)*keyslotSectorSize]
+		c.decrypt(sector, sector, s)
+	}
+
+	vk, err := afMerge(material[:afSize], h.KeySize, k.Stripes, k.AFHash)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := k.digest.verify(vk)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	return vk, nil
+}
+
+// keyslotSectorSize is the encryption sector size of key material.
+const keyslotSectorSize = 512
+
+func (d *digest) verify(key []byte) (bool, error) {
+	got, err := (&KDF{Type: "pbkdf2", Hash: d.Hash, Iterations: d.Iterations, Salt: d.Salt}).derive(key, len(d.Digest))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(got, d.Digest) == 1, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	luks1Keyslots = 8
+
+	luks1KeyEnabled  = 0x00ac71f3
+	luks1KeyDisabled = 0x0000dead
+)
+
+// luks1Header is the big endian LUKS1 phdr.
+type luks1Header struct {
+	Magic              [6]byte
+	Version            uint16
+	CipherName         [32]byte
+	CipherMode         [32]byte
+	HashSpec           [32]byte
+	PayloadOffset      uint32
+	KeyBytes           uint32
+	MKDigest           [20]byte
+	MKDigestSalt       [32]byte
+	MKDigestIterations uint32
+	UUID               [40]byte
+	Keyslots           [luks1Keyslots]struct {
+		Active            uint32
+		Iterations        uint32
+		Salt              [32]byte
+		KeyMaterialOffset uint32
+		Stripes           uint32
+	}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func readLUKS1(r io.ReaderAt) (*Header, error) {
+	var p luks1Header
+	if err := binary.Read(io.NewSectionReader(r, 0, int64(binary.Size(p))), binary.BigEndian, &p); err != nil {
+		return nil, fmt.Errorf("reading LUKS1 header: %w", err)
+	}
+	if !bytes.Equal(p.Magic[:], magic1) || p.Version != 1 {
+		return nil, ErrNotLUKS
+	}
+
+	hash := cString(p.HashSpec[:])
+	cipher := cString(p.CipherName[:]) + "-" + cString(p.CipherMode[:])
+	h := &Header{
+		Version:       1,
+		UUID:          cString(p.UUID[:]),
+		Cipher:        cipher,
+		KeySize:       int(p.KeyBytes),
+		PayloadOffset: uint64(p.PayloadOffset) * keyslotSectorSize,
+		SectorSize:    512,
+	}
+	d := &digest{
+		Hash:       hash,
+		Iterations: int(p.MKDigestIterations),
+		Salt:       p.MKDigestSalt[:],
+		Digest:     p.MKDigest[:],
+	}
+	for i, k := range p.Keyslots {
+		switch k.Active {
+		case luks1KeyDisabled:
+			continue
+		case luks1KeyEnabled:
+		default:
+			return nil, fmt.Errorf("keyslot %d: invalid state %#x", i, k.Active)
+		}
+		h.Keyslots = append(h.Keyslots, Keyslot{
+			ID:       i,
+			Priority: 1,
+			KDF: KDF{
+				Type:       "pbkdf2",
+				Hash:       hash,
+				Iterations: int(k.Iterations),
+				Salt:       append([]byte(nil), k.Salt[:]...),
+			},
+			Offset:  uint64(k.KeyMaterialOffset) * keyslotSectorSize,
+			Cipher:  cipher,
+			KeySize: int(p.KeyBytes),
+			Stripes: int(k.Stripes),
+			AFHash:  hash,
+			digest:  d,
+		})
+	}
+	return h, nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// luks2BinaryHeader is the big endian binary LUKS2 header preceding the
+// JSON metadata.
+type luks2BinaryHeader struct {
+	Magic       [6]byte
+	Version     uint16
+	HdrSize     uint64
+	SeqID       uint64
+	Label       [48]byte
+	ChecksumAlg [32]byte
+	Salt        [64]byte
+	UUID        [40]byte
+	Subsystem   [48]byte
+	HdrOffset   uint64
+	_           [184]byte
+	Checksum    [64]byte
+	_           [7 * 512]byte
+}
+
+const (
+	luks2BinarySize = 4096
+
+	// checksumOffset is the offset of Checksum in luks2BinaryHeader.
+	checksumOffset = 448
+
+	// maxHdrSize is the largest header size allowed, 4 MiB.
+	maxHdrSize = 4 << 20
+)
+
+// luks2SecondaryOffsets are where the secondary header may be, one header
+// size after the primary.
+var luks2SecondaryOffsets = []int64{16 << 10, 32 << 10, 64 << 10, 128 << 10, 256 << 10, 512 << 10, 1 << 20, 2 << 20, 4 << 20}
+
+type luks2JSON struct {
+	Keyslots map[string]struct {
+		Type     string `json:"type"`
+		KeySize  int    `json:"key_size"`
+		Priority *int   `json:"priority"`
+		AF       struct {
+			Type    string `json:"type"`
+			Stripes int    `json:"stripes"`
+			Hash    string `json:"hash"`
+		} `json:"af"`
+		Area struct {
+			Type       string `json:"type"`
+			Offset     string `json:"offset"`
+			Size       string `json:"size"`
+			Encryption string `json:"encryption"`
+			KeySize    int    `json:"key_size"`
+		} `json:"area"`
+		KDF struct {
+			Type       string `json:"type"`
+			Hash       string `json:"hash"`
+			Iterations int    `json:"iterations"`
+			Time       int    `json:"time"`
+			Memory     int    `json:"memory"`
+			CPUs       int    `json:"cpus"`
+			Salt       string `json:"salt"`
+		} `json:"kdf"`
+	} `json:"keyslots"`
+	Segments map[string]struct {
+		Type       string `json:"type"`
+		Offset     string `json:"offset"`
+		Size       string `json:"size"`
+		IVTweak    string `json:"iv_tweak"`
+		Encryption string `json:"encryption"`
+		SectorSize int    `json:"sector_size"`
+	} `json:"segments"`
+	Digests map[string]struct {
+		Type       string   `json:"type"`
+		Keyslots   []string `json:"keyslots"`
+		Segments   []string `json:"segments"`
+		Hash       string   `json:"hash"`
+		Iterations int      `json:"iterations"`
+		Salt       string   `json:"salt"`
+		Digest     string   `json:"digest"`
+	} `json:"digests"`
+}
+
+// readLUKS2At reads and verifies the header copy at off.
+func readLUKS2At(r io.ReaderAt, off int64) (*luks2BinaryHeader, []byte, error) {
+	bin := make([]byte, luks2BinarySize)
+	if _, err := r.ReadAt(bin, off); err != nil {
+		return nil, nil, err
+	}
+	var p luks2BinaryHeader
+	if err := binary.Read(bytes.NewReader(bin), binary.BigEndian, &p); err != nil {
+		return nil, nil, err
+	}
+	if (!bytes.Equal(p.Magic[:], magic1) && !bytes.Equal(p.Magic[:], magic2)) || p.Version != 2 {
+		return nil, nil, ErrNotLUKS
+	}
+	if p.HdrSize < luks2BinarySize || p.HdrSize > maxHdrSize || uint64(off) != p.HdrOffset {
+		return nil, nil, fmt.Errorf("invalid header size %d at offset %d", p.HdrSize, p.HdrOffset)
+	}
+	hdr := make([]byte, p.HdrSize)
+	if _, err := r.ReadAt(hdr, off); err != nil {
+		return nil, nil, err
+	}
+
+	// The checksum covers the whole header with the checksum zeroed.
+	h, err := newHash(cString(p.ChecksumAlg[:]))
+	if err != nil {
+		return nil, nil, err
+	}
+	d := h()
+	for i := checksumOffset; i < checksumOffset+len(p.Checksum); i++ {
+		hdr[i] = 0
+	}
+	d.Write(hdr)
+	if sum := d.Sum(nil); subtle.ConstantTimeCompare(sum, p.Checksum[:len(sum)]) != 1 {
+		return nil, nil, errors.New("header checksum mismatch")
+	}
+	return &p, bytes.TrimRight(hdr[luks2BinarySize:], "\x00"), nil
+}
+
+func readLUKS2(r io.ReaderAt) (*Header, error) {
+	// Use the valid copy with the highest sequence number.
+	p, js, err := readLUKS2At(r, 0)
+	for _, off := range luks2SecondaryOffsets {
+		sp, sjs, serr := readLUKS2At(r, off)
+		if serr != nil {
+			continue
+		}
+		if err != nil || sp.SeqID > p.SeqID {
+			p, js, err = sp, sjs, nil
+		}
+		break
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading LUKS2 header: %w", err)
+	}
+
+	var m luks2JSON
+	if err := json.Unmarshal(js, &m); err != nil {
+		return nil, fmt.Errorf("parsing LUKS2 metadata: %w", err)
+	}
+	h := &Header{
+		Version: 2,
+		UUID:    cString(p.UUID[:]),
+		Label:   cString(p.Label[:]),
+	}
+
+	// The data segment is the lowest numbered crypt segment.
+	seg := ""
+	for id, s := range m.Segments {
+		if s.Type == "crypt" && (seg == "" || lessID(id, seg)) {
+			seg = id
+		}
+	}
+	if seg == "" {
+		return nil, errors.New("no crypt segment")
+	}
+	s := m.Segments[seg]
+	h.Cipher = s.Encryption
+	h.SectorSize = s.SectorSize
+	if h.PayloadOffset, err = strconv.ParseUint(s.Offset, 10, 64); err != nil {
+		return nil, fmt.Errorf("segment %s offset: %v", seg, err)
+	}
+	if s.Size != "dynamic" {
+		if h.PayloadSize, err = strconv.ParseUint(s.Size, 10, 64); err != nil {
+			return nil, fmt.Errorf("segment %s size: %v", seg, err)
+		}
+	}
+	if s.IVTweak != "" {
+		if h.IVOffset, err = strconv.ParseUint(s.IVTweak, 10, 64); err != nil {
+			return nil, fmt.Errorf("segment %s iv_tweak: %v", seg, err)
+		}
+	}
+
+	// Keyslots verified by the segment's digest can open it.
+	digests := map[string]*digest{}
+	for id, d := range m.Digests {
+		if d.Type != "pbkdf2" || !contains(d.Segments, seg) {
+			continue
+		}
+		salt, err := base64.StdEncoding.DecodeString(d.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("digest %s salt: %v", id, err)
+		}
+		sum, err := base64.StdEncoding.DecodeString(d.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("digest %s: %v", id, err)
+		}
+		dg := &digest{Hash: d.Hash, Iterations: d.Iterations, Salt: salt, Digest: sum}
+		for _, k := range d.Keyslots {
+			digests[k] = dg
+		}
+	}
+
+	for id, k := range m.Keyslots {
+		if k.Type != "luks2" || digests[id] == nil {
+			continue
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyslot id %q", id)
+		}
+		if h.KeySize == 0 {
+			h.KeySize = k.KeySize
+		}
+		if k.KeySize != h.KeySize {
+			return nil, fmt.Errorf("keyslot %s: key size %d, want %d", id, k.KeySize, h.KeySize)
+		}
+		if k.AF.Type != "luks1" || k.Area.Type != "raw" {
+			return nil, fmt.Errorf("keyslot %s: unsupported af %q or area %q", id, k.AF.Type, k.Area.Type)
+		}
+		salt, err := base64.StdEncoding.DecodeString(k.KDF.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("keyslot %s salt: %v", id, err)
+		}
+		ks := Keyslot{
+			ID:       n,
+			Priority: 1,
+			KDF: KDF{
+				Type:       k.KDF.Type,
+				Hash:       k.KDF.Hash,
+				Iterations: k.KDF.Iterations,
+				Time:       k.KDF.Time,
+				Memory:     k.KDF.Memory,
+				CPUs:       k.KDF.CPUs,
+				Salt:       salt,
+			},
+			Cipher:  k.Area.Encryption,
+			KeySize: k.Area.KeySize,
+			Stripes: k.AF.Stripes,
+			AFHash:  k.AF.Hash,
+			digest:  digests[id],
+		}
+		if k.Priority != nil {
+			ks.Priority = *k.Priority
+		}
+		if ks.Offset, err = strconv.ParseUint(k.Area.Offset, 10, 64); err != nil {
+			return nil, fmt.Errorf("keyslot %s area offset: %v", id, err)
+		}
+		if ks.Size, err = strconv.ParseUint(k.Area.Size, 10, 64); err != nil {
+			return nil, fmt.Errorf("keyslot %s area size: %v", id, err)
+		}
+		h.Keyslots = append(h.Keyslots, ks)
+	}
+	sort.Slice(h.Keyslots, func(i, j int) bool { return h.Keyslots[i].ID < h.Keyslots[j].ID })
+	return h, nil
+}
+
+// lessID orders numeric JSON object keys.
+func lessID(a, b string) bool {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return x < y
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
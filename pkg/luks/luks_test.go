@@ -0,0 +1,175 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The images are made by testdata/gen.go.
+var images = []struct {
+	file       string
+	version    int
+	cipher     string
+	keySize    int
+	offset     uint64
+	sectorSize int
+	keyslots   []int
+}{
+	{"luks1.img", 1, "aes-xts-plain64", 32, 8192, 512, []int{0, 3}},
+	{"luks1-cbc.img", 1, "aes-cbc-essiv:sha256", 16, 8192, 512, []int{0, 3}},
+	{"luks2.img", 2, "aes-xts-plain64", 64, 40960, 4096, []int{0, 1}},
+}
+
+func readImage(t *testing.T, file string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestReadHeader(t *testing.T) {
+	for _, tt := range images {
+		t.Run(tt.file, func(t *testing.T) {
+			h, err := ReadHeader(bytes.NewReader(readImage(t, tt.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Version != tt.version || h.Cipher != tt.cipher || h.KeySize != tt.keySize || h.PayloadOffset != tt.offset || h.SectorSize != tt.sectorSize {
+				t.Errorf("header = %+v", h)
+			}
+			var ids []int
+			for _, k := range h.Keyslots {
+				ids = append(ids, k.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.keyslots) {
+				t.Errorf("keyslots = %v, want %v", ids, tt.keyslots)
+			}
+		})
+	}
+
+	if _, err := ReadHeader(bytes.NewReader(make([]byte, 64<<10))); !errors.Is(err, ErrNotLUKS) {
+		t.Errorf("ReadHeader(zeros) = %v, want %v", err, ErrNotLUKS)
+	}
+	if IsLUKS(strings.NewReader("LUKS")) {
+		t.Errorf("IsLUKS(short) = true")
+	}
+}
+
+func TestLUKS2Keyslots(t *testing.T) {
+	h, err := ReadHeader(bytes.NewReader(readImage(t, "luks2.img")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.UUID != "5f2a0c1e-8d3b-4a6f-b7e9-0c1d2e3f4a5b" || h.Label != "test" {
+		t.Errorf("UUID, Label = %q, %q", h.UUID, h.Label)
+	}
+	k := h.Keyslots[0].KDF
+	if k.Type != "argon2id" || k.Time != 4 || k.Memory != 32 || k.CPUs != 1 || len(k.Salt) != 32 {
+		t.Errorf("keyslot 0 KDF = %+v", k)
+	}
+	k = h.Keyslots[1].KDF
+	if k.Type != "pbkdf2" || k.Hash != "sha256" || k.Iterations != 1000 {
+		t.Errorf("keyslot 1 KDF = %+v", k)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	for _, tt := range images {
+		t.Run(tt.file, func(t *testing.T) {
+			r := bytes.NewReader(readImage(t, tt.file))
+			h, err := ReadHeader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, slot, err := h.Unlock(r, []byte("password"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slot != tt.keyslots[0] || len(key) != tt.keySize {
+				t.Errorf("Unlock = %d byte key from keyslot %d, want %d bytes from %d", len(key), slot, tt.keySize, tt.keyslots[0])
+			}
+			other, slot, err := h.Unlock(r, []byte("other passphrase"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slot != tt.keyslots[1] || !bytes.Equal(key, other) {
+				t.Errorf("Unlock(other) = keyslot %d, same key %v", slot, bytes.Equal(key, other))
+			}
+
+			if _, _, err := h.Unlock(r, []byte("wrong")); err != ErrWrongPassphrase {
+				t.Errorf("Unlock(wrong) = %v, want %v", err, ErrWrongPassphrase)
+			}
+			if _, err := h.UnlockKeyslot(r, tt.keyslots[1], []byte("password")); err != ErrWrongPassphrase {
+				t.Errorf("UnlockKeyslot(%d, password) = %v, want %v", tt.keyslots[1], err, ErrWrongPassphrase)
+			}
+			if _, err := h.UnlockKeyslot(r, 7, []byte("password")); err == nil {
+				t.Errorf("UnlockKeyslot(7) succeeded for an inactive keyslot")
+			}
+
+			p, err := NewReader(r, h, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				want := fmt.Sprintf("LUKS test payload sector %d", i)
+				got := make([]byte, len(want))
+				if _, err := p.ReadAt(got, int64(i*tt.sectorSize)); err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("payload sector %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecondaryHeader(t *testing.T) {
+	b := readImage(t, "luks2.img")
+	// Corrupt the primary header's JSON area.
+	b[4096] ^= 0xff
+
+	r := bytes.NewReader(b)
+	h, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.Unlock(r, []byte("other passphrase")); err != nil {
+		t.Errorf("Unlock with the secondary header: %v", err)
+	}
+
+	// Without either header the device is not LUKS.
+	b[16<<10+4096] ^= 0xff
+	if _, err := ReadHeader(bytes.NewReader(b)); err == nil {
+		t.Errorf("ReadHeader succeeded with both headers corrupted")
+	}
+}
+
+func TestTable(t *testing.T) {
+	h := &Header{Cipher: "aes-xts-plain64", PayloadOffset: 16 << 20, SectorSize: 4096}
+	got, err := h.Table("/dev/sda2", 1<<30, []byte{0xde, 0xad})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "0 2064384 crypt aes-xts-plain64 dead 0 /dev/sda2 32768 1 sector_size:4096"
+	if len(got) != 1 || got[0].String() != want {
+		t.Errorf("Table = %v, want %q", got, want)
+	}
+
+	h.PayloadSize = 1 << 30
+	if _, err := h.Table("/dev/sda2", 1<<30, nil); err == nil {
+		t.Errorf("Table accepted a payload past the end of the device")
+	}
+}
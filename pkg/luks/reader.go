@@ -0,0 +1,63 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"fmt"
+	"io"
+)
+
+// Reader decrypts the payload of an unlocked volume without dm-crypt.
+type Reader struct {
+	r          io.ReaderAt
+	h          *Header
+	c          sectorCipher
+	sectorSize int64
+}
+
+// NewReader returns a Reader for the payload of r decrypted with key.
+func NewReader(r io.ReaderAt, h *Header, key []byte) (*Reader, error) {
+	c, err := newSectorCipher(h.Cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	ss := int64(h.SectorSize)
+	if ss == 0 {
+		ss = 512
+	}
+	if ss < 512 || ss&(ss-1) != 0 {
+		return nil, fmt.Errorf("invalid sector size %d", ss)
+	}
+	return &Reader{r: r, h: h, c: c, sectorSize: ss}, nil
+}
+
+// ReadAt implements io.ReaderAt. Reads are done in whole sectors.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	var eof error
+	if size := int64(r.h.PayloadSize); size != 0 {
+		if off >= size {
+			return 0, io.EOF
+		}
+		if off+int64(len(p)) > size {
+			p, eof = p[:size-off], io.EOF
+		}
+	}
+	n := 0
+	sector := make([]byte, r.sectorSize)
+	for n < len(p) {
+		pos := off + int64(n)
+		start := pos / r.sectorSize * r.sectorSize
+		if _, err := r.r.ReadAt(sector, int64(r.h.PayloadOffset)+start); err != nil {
+			return n, err
+		}
+		// IVs count 512 byte sectors regardless of the sector size.
+		r.c.decrypt(sector, sector, r.h.IVOffset+uint64(start)/512)
+		n += copy(p[n:], sector[pos-start:])
+	}
+	return n, eof
+}
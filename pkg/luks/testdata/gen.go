@@ -0,0 +1,281 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build ignore
+// +build ignore
+
+// gen writes the LUKS test images. The images use few stripes and cheap
+// KDF costs to keep them small and the tests fast.
+//
+// Run with: go run gen.go
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/xts"
+)
+
+const stripes = 16
+
+func random(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
+
+func diffuse(b []byte, h func() hash.Hash) {
+	size := h().Size()
+	for i := 0; i*size < len(b); i++ {
+		end := (i + 1) * size
+		if end > len(b) {
+			end = len(b)
+		}
+		d := h()
+		binary.Write(d, binary.BigEndian, uint32(i))
+		d.Write(b[i*size : end])
+		copy(b[i*size:end], d.Sum(nil))
+	}
+}
+
+// afSplit splits key into stripes, padded to whole sectors.
+func afSplit(key []byte, h func() hash.Hash) []byte {
+	n := len(key) * stripes
+	out := make([]byte, (n+511)/512*512)
+	d := make([]byte, len(key))
+	for i := 0; i < stripes-1; i++ {
+		s := random(len(key))
+		copy(out[i*len(key):], s)
+		for j := range d {
+			d[j] ^= s[j]
+		}
+		diffuse(d, h)
+	}
+	for j := range d {
+		out[(stripes-1)*len(key)+j] = d[j] ^ key[j]
+	}
+	return out
+}
+
+// encrypt encrypts b in place in sectors of size ss, with IV sectors
+// counted in 512 byte units from iv.
+func encrypt(mode string, key, b []byte, ss int, iv uint64) {
+	for off := 0; off < len(b); off += ss {
+		s := b[off : off+ss]
+		sector := iv + uint64(off/512)
+		switch mode {
+		case "xts-plain64":
+			c, err := xts.NewCipher(aes.NewCipher, key)
+			if err != nil {
+				log.Fatal(err)
+			}
+			c.Encrypt(s, s, sector)
+		case "cbc-essiv:sha256":
+			blk, _ := aes.NewCipher(key)
+			salt := sha256.Sum256(key)
+			essiv, _ := aes.NewCipher(salt[:])
+			ivb := make([]byte, 16)
+			binary.LittleEndian.PutUint64(ivb, sector)
+			essiv.Encrypt(ivb, ivb)
+			cipher.NewCBCEncrypter(blk, ivb).CryptBlocks(s, s)
+		default:
+			log.Fatalf("mode %s", mode)
+		}
+	}
+}
+
+func payload(n, ss int) []byte {
+	b := make([]byte, n*ss)
+	for i := 0; i < n; i++ {
+		copy(b[i*ss:], fmt.Sprintf("LUKS test payload sector %d", i))
+	}
+	return b
+}
+
+func put(b []byte, off int, s string) {
+	copy(b[off:], s)
+}
+
+func luks1(file, mode, hashName string, h func() hash.Hash, keyLen int) {
+	const (
+		payloadSectors = 16
+		iterations     = 1000
+	)
+	img := make([]byte, payloadSectors*512)
+	vk := random(keyLen)
+
+	put(img, 0, "LUKS\xba\xbe")
+	binary.BigEndian.PutUint16(img[6:], 1)
+	put(img, 8, "aes")
+	put(img, 40, mode)
+	put(img, 72, hashName)
+	binary.BigEndian.PutUint32(img[104:], payloadSectors)
+	binary.BigEndian.PutUint32(img[108:], uint32(keyLen))
+	salt := random(32)
+	copy(img[112:], pbkdf2.Key(vk, salt, iterations, 20, h))
+	copy(img[132:], salt)
+	binary.BigEndian.PutUint32(img[164:], iterations)
+	put(img, 168, "3b1b6b52-6c4e-4b9e-9d0c-1a2b3c4d5e6f")
+
+	passphrases := map[int]string{0: "password", 3: "other passphrase"}
+	for i := 0; i < 8; i++ {
+		ks := img[208+i*48:]
+		binary.BigEndian.PutUint32(ks, 0x0000dead)
+		binary.BigEndian.PutUint32(ks[40:], uint32(8+i))
+		binary.BigEndian.PutUint32(ks[44:], stripes)
+		pass, ok := passphrases[i]
+		if !ok {
+			continue
+		}
+		binary.BigEndian.PutUint32(ks, 0x00ac71f3)
+		binary.BigEndian.PutUint32(ks[4:], iterations)
+		salt := random(32)
+		copy(ks[8:], salt)
+		k := pbkdf2.Key([]byte(pass), salt, iterations, keyLen, h)
+		m := afSplit(vk, h)
+		encrypt(mode, k, m, 512, 0)
+		copy(img[(8+i)*512:], m)
+	}
+
+	data := payload(2, 512)
+	encrypt(mode, vk, data, 512, 0)
+	img = append(img, data...)
+	if err := os.WriteFile(file, img, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func luks2(file string) {
+	const (
+		hdrSize     = 16 << 10
+		areaOffset  = 32 << 10
+		areaSize    = 4 << 10
+		dataOffset  = areaOffset + 2*areaSize
+		sectorSize  = 4096
+		keyLen      = 64
+		iterations  = 1000
+		areaCipher  = "aes-xts-plain64"
+		dataCipher  = "aes-xts-plain64"
+		dataSectors = 2
+	)
+	vk := random(keyLen)
+	b64 := base64.StdEncoding.EncodeToString
+	img := make([]byte, dataOffset)
+
+	type kdf map[string]interface{}
+	kdfs := []kdf{
+		{"type": "argon2id", "time": 4, "memory": 32, "cpus": 1, "salt": b64(random(32))},
+		{"type": "pbkdf2", "hash": "sha256", "iterations": iterations, "salt": b64(random(32))},
+	}
+	passphrases := []string{"password", "other passphrase"}
+	keyslots := map[string]interface{}{}
+	for i, k := range kdfs {
+		salt, _ := base64.StdEncoding.DecodeString(k["salt"].(string))
+		var key []byte
+		if k["type"] == "pbkdf2" {
+			key = pbkdf2.Key([]byte(passphrases[i]), salt, iterations, keyLen, sha256.New)
+		} else {
+			key = argon2.IDKey([]byte(passphrases[i]), salt, 4, 32, 1, keyLen)
+		}
+		m := afSplit(vk, sha256.New)
+		encrypt("xts-plain64", key, m, 512, 0)
+		off := areaOffset + i*areaSize
+		copy(img[off:], m)
+		keyslots[fmt.Sprint(i)] = map[string]interface{}{
+			"type":     "luks2",
+			"key_size": keyLen,
+			"af":       map[string]interface{}{"type": "luks1", "stripes": stripes, "hash": "sha256"},
+			"area": map[string]interface{}{
+				"type":       "raw",
+				"offset":     fmt.Sprint(off),
+				"size":       fmt.Sprint(areaSize),
+				"encryption": areaCipher,
+				"key_size":   keyLen,
+			},
+			"kdf": k,
+		}
+	}
+	dsalt := random(32)
+	meta := map[string]interface{}{
+		"keyslots": keyslots,
+		"tokens":   map[string]interface{}{},
+		"segments": map[string]interface{}{
+			"0": map[string]interface{}{
+				"type":        "crypt",
+				"offset":      fmt.Sprint(dataOffset),
+				"size":        "dynamic",
+				"iv_tweak":    "0",
+				"encryption":  dataCipher,
+				"sector_size": sectorSize,
+			},
+		},
+		"digests": map[string]interface{}{
+			"0": map[string]interface{}{
+				"type":       "pbkdf2",
+				"keyslots":   []string{"0", "1"},
+				"segments":   []string{"0"},
+				"hash":       "sha256",
+				"iterations": iterations,
+				"salt":       b64(dsalt),
+				"digest":     b64(pbkdf2.Key(vk, dsalt, iterations, 32, sha256.New)),
+			},
+		},
+		"config": map[string]interface{}{
+			"json_size":     fmt.Sprint(hdrSize - 4096),
+			"keyslots_size": fmt.Sprint(dataOffset - areaOffset),
+		},
+	}
+	js, err := json.Marshal(meta)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i, off := range []int{0, hdrSize} {
+		h := img[off : off+hdrSize]
+		if i == 0 {
+			put(h, 0, "LUKS\xba\xbe")
+		} else {
+			put(h, 0, "SKUL\xba\xbe")
+		}
+		binary.BigEndian.PutUint16(h[6:], 2)
+		binary.BigEndian.PutUint64(h[8:], hdrSize)
+		binary.BigEndian.PutUint64(h[16:], 1)
+		put(h, 24, "test")
+		put(h, 72, "sha256")
+		copy(h[104:168], random(64))
+		put(h, 168, "5f2a0c1e-8d3b-4a6f-b7e9-0c1d2e3f4a5b")
+		binary.BigEndian.PutUint64(h[256:], uint64(off))
+		copy(h[4096:], js)
+		sum := sha256.Sum256(h)
+		copy(h[448:], sum[:])
+	}
+
+	data := payload(dataSectors, sectorSize)
+	encrypt("xts-plain64", vk, data, sectorSize, 0)
+	img = append(img, data...)
+	if err := os.WriteFile(file, img, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	luks1("luks1.img", "xts-plain64", "sha256", sha256.New, 32)
+	luks1("luks1-cbc.img", "cbc-essiv:sha256", "sha1", sha1.New, 16)
+	luks2("luks2.img")
+}
@@ -7,7 +7,6 @@ package block
 
 import (
 	"bufio"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -21,6 +20,7 @@ import (
 
 	"github.com/rekby/gpt"
 	"github.com/u-root/u-root/pkg/mount"
+	"github.com/u-root/u-root/pkg/mount/fat"
 	"github.com/u-root/u-root/pkg/pci"
 	"golang.org/x/sys/unix"
 )
@@ -35,9 +35,12 @@ var (
 
 // BlockDev maps a device name to a BlockStat structure for a given block device
 type BlockDev struct {
-	Name   string
-	FSType string
-	FsUUID string
+	Name      string
+	FSType    string
+	FsUUID    string
+	FsLabel   string
+	PartUUID  string
+	PartLabel string
 }
 
 // Device makes sure the block device exists and returns a handle to it.
@@ -50,11 +53,65 @@ func Device(maybeDevpath string) (*BlockDev, error) {
 		return nil, err
 	}
 
-	devpath := filepath.Join("/dev/", devname)
-	if uuid, err := getFSUUID(devpath); err == nil {
-		return &BlockDev{Name: devname, FsUUID: uuid}, nil
+	b := &BlockDev{Name: devname}
+	if info, err := probeDevice(b.DevicePath()); err == nil {
+		b.FSType = info.Type
+		b.FsUUID = info.UUID
+		b.FsLabel = info.Label
 	}
-	return &BlockDev{Name: devname}, nil
+	if uuid, label, err := b.partInfo(); err == nil {
+		b.PartUUID = uuid
+		b.PartLabel = label
+	}
+	return b, nil
+}
+
+// probeDevice runs Probe on the device at devpath.
+func probeDevice(devpath string) (*FSInfo, error) {
+	f, err := os.Open(devpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return Probe(f, size)
+}
+
+// partInfo reads the PARTUUID and PARTLABEL of a partition from the
+// partition table of its parent device.
+func (b *BlockDev) partInfo() (string, string, error) {
+	sysPath, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", b.Name))
+	if err != nil {
+		return "", "", err
+	}
+	n, err := os.ReadFile(filepath.Join(sysPath, "partition"))
+	if err != nil {
+		return "", "", err
+	}
+	partno, err := strconv.Atoi(strings.TrimSpace(string(n)))
+	if err != nil {
+		return "", "", err
+	}
+
+	parent := &BlockDev{Name: filepath.Base(filepath.Dir(sysPath))}
+	blkSize, err := parent.BlockSize()
+	if err != nil {
+		blkSize = 512
+	}
+	f, err := os.Open(parent.DevicePath())
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", "", err
+	}
+	return PartInfo(f, size, int64(blkSize), partno)
 }
 
 // String implements fmt.Stringer.
@@ -75,10 +132,22 @@ func (b *BlockDev) DevName() string {
 	return b.Name
 }
 
+// readOnlyFSTypes can only be mounted read-only.
+var readOnlyFSTypes = map[string]bool{
+	"erofs":    true,
+	"iso9660":  true,
+	"squashfs": true,
+}
+
 // Mount implements mount.Mounter.
 func (b *BlockDev) Mount(path string, flags uintptr) (*mount.MountPoint, error) {
 	devpath := filepath.Join("/dev", b.Name)
-	if len(b.FSType) > 0 {
+	// FSType may be a probed type the kernel does not know by that name,
+	// or not a file system at all; let TryMount figure those out.
+	if len(b.FSType) > 0 && mount.FindFileSystem(b.FSType) == nil {
+		if readOnlyFSTypes[b.FSType] {
+			flags |= mount.MS_RDONLY
+		}
 		return mount.Mount(devpath, path, b.FSType, "", flags)
 	}
 
@@ -177,6 +246,43 @@ func (b *BlockDev) ReadPartitionTable() error {
 	return unix.IoctlSetInt(int(f.Fd()), unix.BLKRRPART, 0)
 }
 
+// FormatFAT creates a FAT file system on the block device, as fat.Format
+// does. Unless set in opts, the sector size is the device's logical block
+// size and the hidden sectors are the partition's start.
+func (b *BlockDev) FormatFAT(opts *fat.FormatOptions) error {
+	o := fat.FormatOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.SectorSize == 0 {
+		if bs, err := b.BlockSize(); err == nil {
+			o.SectorSize = bs
+		}
+	}
+	if o.HiddenSectors == 0 && o.SectorSize != 0 {
+		// The start is in 512 byte sectors.
+		if n, err := os.ReadFile(filepath.Join("/sys/class/block", b.Name, "start")); err == nil {
+			if start, err := strconv.ParseUint(strings.TrimSpace(string(n)), 10, 64); err == nil {
+				o.HiddenSectors = uint32(start * 512 / uint64(o.SectorSize))
+			}
+		}
+	}
+	size, err := b.Size()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(b.DevicePath(), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := fat.Format(f, int64(size), &o); err != nil {
+		return fmt.Errorf("formatting %s: %w", b.DevicePath(), err)
+	}
+	return f.Sync()
+}
+
 // PCIInfo searches sysfs for the PCI vendor and device id.
 // We fill in the PCI struct with just those two elements.
 func (b *BlockDev) PCIInfo() (*pci.PCI, error) {
@@ -255,166 +361,6 @@ func GetBlockDevices() (BlockDevices, error) {
 	return blockdevs, nil
 }
 
-func getFSUUID(devpath string) (string, error) {
-	file, err := os.Open(devpath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	fsuuid, err := tryFAT32(file)
-	if err == nil {
-		return fsuuid, nil
-	}
-	fsuuid, err = tryFAT16(file)
-	if err == nil {
-		return fsuuid, nil
-	}
-	fsuuid, err = tryEXT4(file)
-	if err == nil {
-		return fsuuid, nil
-	}
-	fsuuid, err = tryXFS(file)
-	if err == nil {
-		return fsuuid, nil
-	}
-	return "", fmt.Errorf("unknown UUID (not vfat, ext4, nor xfs)")
-}
-
-// See https://www.nongnu.org/ext2-doc/ext2.html#DISK-ORGANISATION.
-const (
-	// Offset of superblock in partition.
-	ext2SprblkOff = 1024
-
-	// Offset of magic number in suberblock.
-	ext2SprblkMagicOff  = 56
-	ext2SprblkMagicSize = 2
-
-	ext2SprblkMagic = 0xEF53
-
-	// Offset of UUID in superblock.
-	ext2SprblkUUIDOff  = 104
-	ext2SprblkUUIDSize = 16
-)
-
-func tryEXT4(file io.ReaderAt) (string, error) {
-	var off int64
-
-	// Read magic number.
-	b := make([]byte, ext2SprblkMagicSize)
-	off = ext2SprblkOff + ext2SprblkMagicOff
-	if _, err := file.ReadAt(b, off); err != nil {
-		return "", err
-	}
-	magic := binary.LittleEndian.Uint16(b[:2])
-	if magic != ext2SprblkMagic {
-		return "", fmt.Errorf("ext4 magic not found")
-	}
-
-	// Filesystem UUID.
-	b = make([]byte, ext2SprblkUUIDSize)
-	off = ext2SprblkOff + ext2SprblkUUIDOff
-	if _, err := file.ReadAt(b, off); err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
-}
-
-// See https://de.wikipedia.org/wiki/File_Allocation_Table#Aufbau.
-const (
-	fat12Magic = "FAT12   "
-	fat16Magic = "FAT16   "
-
-	// Offset of magic number.
-	fat16MagicOff  = 0x36
-	fat16MagicSize = 8
-
-	// Offset of filesystem ID / serial number. Treated as short filesystem UUID.
-	fat16IDOff  = 0x27
-	fat16IDSize = 4
-)
-
-func tryFAT16(file io.ReaderAt) (string, error) {
-	// Read magic number.
-	b := make([]byte, fat16MagicSize)
-	if _, err := file.ReadAt(b, fat16MagicOff); err != nil {
-		return "", err
-	}
-	magic := string(b)
-	if magic != fat16Magic && magic != fat12Magic {
-		return "", fmt.Errorf("fat16 magic not found")
-	}
-
-	// Filesystem UUID.
-	b = make([]byte, fat16IDSize)
-	if _, err := file.ReadAt(b, fat16IDOff); err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%02x%02x-%02x%02x", b[3], b[2], b[1], b[0]), nil
-}
-
-// See https://de.wikipedia.org/wiki/File_Allocation_Table#Aufbau.
-const (
-	fat32Magic = "FAT32   "
-
-	// Offset of magic number.
-	fat32MagicOff  = 0x52
-	fat32MagicSize = 8
-
-	// Offset of filesystem ID / serial number. Treated as short filesystem UUID.
-	fat32IDOff  = 67
-	fat32IDSize = 4
-)
-
-func tryFAT32(file io.ReaderAt) (string, error) {
-	// Read magic number.
-	b := make([]byte, fat32MagicSize)
-	if _, err := file.ReadAt(b, fat32MagicOff); err != nil {
-		return "", err
-	}
-	magic := string(b)
-	if magic != fat32Magic {
-		return "", fmt.Errorf("fat32 magic not found")
-	}
-
-	// Filesystem UUID.
-	b = make([]byte, fat32IDSize)
-	if _, err := file.ReadAt(b, fat32IDOff); err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%02x%02x-%02x%02x", b[3], b[2], b[1], b[0]), nil
-}
-
-const (
-	xfsMagic     = "XFSB"
-	xfsMagicSize = 4
-	xfsUUIDOff   = 32
-	xfsUUIDSize  = 16
-)
-
-func tryXFS(file io.ReaderAt) (string, error) {
-	// Read magic number.
-	b := make([]byte, xfsMagicSize)
-	if _, err := file.ReadAt(b, 0); err != nil {
-		return "", err
-	}
-	magic := string(b)
-	if magic != xfsMagic {
-		return "", fmt.Errorf("xfs magic not found")
-	}
-
-	// Filesystem UUID.
-	b = make([]byte, xfsUUIDSize)
-	if _, err := file.ReadAt(b, xfsUUIDOff); err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
-}
-
 // BlockDevices is a list of block devices.
 type BlockDevices []*BlockDev
 
@@ -546,11 +492,49 @@ func (b BlockDevices) FilterNames(names ...string) BlockDevices {
 }
 
 // FilterFSUUID returns a list of BlockDev objects whose underlying block
-// device has a filesystem with the given FSUUID.
+// device has a filesystem with the given FSUUID. The comparison is
+// case-insensitive.
 func (b BlockDevices) FilterFSUUID(fsuuid string) BlockDevices {
 	partitions := make(BlockDevices, 0)
 	for _, device := range b {
-		if device.FsUUID == fsuuid {
+		if strings.EqualFold(device.FsUUID, fsuuid) {
+			partitions = append(partitions, device)
+		}
+	}
+	return partitions
+}
+
+// FilterFSLabel returns a list of BlockDev objects whose underlying block
+// device has a filesystem with the given label.
+func (b BlockDevices) FilterFSLabel(label string) BlockDevices {
+	partitions := make(BlockDevices, 0)
+	for _, device := range b {
+		if device.FsLabel != "" && device.FsLabel == label {
+			partitions = append(partitions, device)
+		}
+	}
+	return partitions
+}
+
+// FilterFSType returns a list of BlockDev objects whose underlying block
+// device was probed to have the given type, e.g. "ext4" or "crypto_LUKS".
+func (b BlockDevices) FilterFSType(fstype string) BlockDevices {
+	partitions := make(BlockDevices, 0)
+	for _, device := range b {
+		if device.FSType == fstype {
+			partitions = append(partitions, device)
+		}
+	}
+	return partitions
+}
+
+// FilterPartUUID returns a list of BlockDev objects with the given
+// PARTUUID. It works for both GPT and MBR partitions. The comparison is
+// case-insensitive.
+func (b BlockDevices) FilterPartUUID(partuuid string) BlockDevices {
+	partitions := make(BlockDevices, 0)
+	for _, device := range b {
+		if device.PartUUID != "" && strings.EqualFold(device.PartUUID, partuuid) {
 			partitions = append(partitions, device)
 		}
 	}
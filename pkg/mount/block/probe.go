@@ -0,0 +1,573 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rekby/gpt"
+)
+
+// FSInfo describes what Probe found on a block device.
+//
+// Type uses the names blkid reports, e.g. "ext4", "vfat", "swap",
+// "crypto_LUKS", "LVM2_member" or "linux_raid_member".
+type FSInfo struct {
+	Type  string
+	UUID  string
+	Label string
+}
+
+// ErrUnknownFS is returned by Probe if no known signature was found.
+var ErrUnknownFS = errors.New("unknown file system")
+
+// probers are tried in order. RAID and volume manager members come first:
+// an md 1.0 or 0.90 member may also carry a file system signature at its
+// start.
+var probers = []func(r io.ReaderAt, size int64) *FSInfo{
+	probeMD1,
+	probeMD0,
+	probeLUKS,
+	probeLVM2,
+	probeExt,
+	probeBtrfs,
+	probeXFS,
+	probeF2FS,
+	probeEROFS,
+	probeSquashFS,
+	probeISO9660,
+	probeNTFS,
+	probeExFAT,
+	probeFAT32,
+	probeFAT16,
+	probeSwap,
+}
+
+// Probe identifies the file system, encrypted volume or RAID/LVM member in
+// r, which is size bytes long.
+func Probe(r io.ReaderAt, size int64) (*FSInfo, error) {
+	for _, p := range probers {
+		if info := p(r, size); info != nil {
+			return info, nil
+		}
+	}
+	return nil, ErrUnknownFS
+}
+
+// readAt reads n bytes at off and returns nil if that is not possible.
+func readAt(r io.ReaderAt, off int64, n int) []byte {
+	if off < 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil
+	}
+	return b
+}
+
+// cString returns b up to the first NUL, without trailing spaces.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), " ")
+}
+
+// utf16String decodes a little endian UTF-16 string up to the first NUL.
+func utf16String(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), " ")
+}
+
+// uuidString formats a 16 byte UUID. It returns "" if the UUID is all zeros.
+func uuidString(b []byte) string {
+	if bytes.Equal(b, make([]byte, len(b))) {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+const (
+	mdMagic = 0xa92b4efc
+
+	md0ReservedSize = 64 << 10
+)
+
+// probeMD1 finds md version 1.1, 1.2 and 1.0 superblocks, in that order.
+func probeMD1(r io.ReaderAt, size int64) *FSInfo {
+	for _, off := range []int64{0, 4096, (size - 8192) &^ 4095} {
+		b := readAt(r, off, 256)
+		if b == nil || binary.LittleEndian.Uint32(b[0:]) != mdMagic || binary.LittleEndian.Uint32(b[4:]) != 1 {
+			continue
+		}
+		return &FSInfo{
+			Type:  "linux_raid_member",
+			UUID:  uuidString(b[16:32]),
+			Label: cString(b[32:64]),
+		}
+	}
+	return nil
+}
+
+// probeMD0 finds md version 0.90 superblocks, which are in host byte order
+// in the last 64 KiB aligned block of the device.
+func probeMD0(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, size&^(md0ReservedSize-1)-md0ReservedSize, 64)
+	if b == nil {
+		return nil
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if order.Uint32(b[0:]) == mdMagic && order.Uint32(b[4:]) == 0 {
+			uuid := append(append([]byte{}, b[20:24]...), b[52:64]...)
+			return &FSInfo{Type: "linux_raid_member", UUID: uuidString(uuid)}
+		}
+	}
+	return nil
+}
+
+var luksMagic = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe}
+
+func probeLUKS(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 0, 208)
+	if b == nil || !bytes.Equal(b[:6], luksMagic) {
+		return nil
+	}
+	info := &FSInfo{Type: "crypto_LUKS", UUID: cString(b[168:208])}
+	// Only LUKS2 has a label.
+	if binary.BigEndian.Uint16(b[6:]) == 2 {
+		info.Label = cString(b[24:72])
+	}
+	return info
+}
+
+// probeLVM2 finds an LVM2 physical volume label in one of the first four
+// sectors.
+func probeLVM2(r io.ReaderAt, size int64) *FSInfo {
+	for sector := int64(0); sector < 4; sector++ {
+		b := readAt(r, sector*512, 512)
+		if b == nil || string(b[0:8]) != "LABELONE" || string(b[24:32]) != "LVM2 001" {
+			continue
+		}
+		off := int(binary.LittleEndian.Uint32(b[20:]))
+		if off+32 > len(b) {
+			return nil
+		}
+		id := b[off : off+32]
+		return &FSInfo{
+			Type: "LVM2_member",
+			UUID: fmt.Sprintf("%s-%s-%s-%s-%s-%s-%s", id[0:6], id[6:10], id[10:14], id[14:18], id[18:22], id[22:26], id[26:32]),
+		}
+	}
+	return nil
+}
+
+// See https://www.nongnu.org/ext2-doc/ext2.html#DISK-ORGANISATION.
+const (
+	// Offset of superblock in partition.
+	ext2SprblkOff = 1024
+
+	// Offset of magic number in suberblock.
+	ext2SprblkMagicOff = 56
+
+	ext2SprblkMagic = 0xEF53
+
+	// Offsets of feature flags, UUID and volume name in superblock.
+	ext2SprblkCompatOff   = 92
+	ext2SprblkIncompatOff = 96
+	ext2SprblkROCompatOff = 100
+	ext2SprblkUUIDOff     = 104
+	ext2SprblkLabelOff    = 120
+
+	ext3CompatHasJournal  = 0x4
+	ext3IncompatJournalDv = 0x8
+
+	// Features ext2 and ext3 know about. Anything else makes it ext4.
+	ext3IncompatSupported = 0x2 | 0x4 | 0x10
+	ext3ROCompatSupported = 0x1 | 0x2 | 0x4
+)
+
+func probeExt(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, ext2SprblkOff, 1024)
+	if b == nil || binary.LittleEndian.Uint16(b[ext2SprblkMagicOff:]) != ext2SprblkMagic {
+		return nil
+	}
+	compat := binary.LittleEndian.Uint32(b[ext2SprblkCompatOff:])
+	incompat := binary.LittleEndian.Uint32(b[ext2SprblkIncompatOff:])
+	roCompat := binary.LittleEndian.Uint32(b[ext2SprblkROCompatOff:])
+
+	typ := "ext2"
+	switch {
+	case incompat&ext3IncompatJournalDv != 0:
+		typ = "jbd"
+	case incompat&^ext3IncompatSupported != 0 || roCompat&^ext3ROCompatSupported != 0:
+		typ = "ext4"
+	case compat&ext3CompatHasJournal != 0:
+		typ = "ext3"
+	}
+	return &FSInfo{
+		Type:  typ,
+		UUID:  uuidString(b[ext2SprblkUUIDOff : ext2SprblkUUIDOff+16]),
+		Label: cString(b[ext2SprblkLabelOff : ext2SprblkLabelOff+16]),
+	}
+}
+
+const btrfsSprblkOff = 64 << 10
+
+func probeBtrfs(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, btrfsSprblkOff, 0x22b)
+	if b == nil || string(b[0x40:0x48]) != "_BHRfS_M" {
+		return nil
+	}
+	return &FSInfo{
+		Type:  "btrfs",
+		UUID:  uuidString(b[0x20:0x30]),
+		Label: cString(b[0x12b:0x22b]),
+	}
+}
+
+const (
+	xfsMagic    = "XFSB"
+	xfsUUIDOff  = 32
+	xfsLabelOff = 108
+)
+
+func probeXFS(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 0, 512)
+	if b == nil || string(b[:4]) != xfsMagic {
+		return nil
+	}
+	return &FSInfo{
+		Type:  "xfs",
+		UUID:  uuidString(b[xfsUUIDOff : xfsUUIDOff+16]),
+		Label: cString(b[xfsLabelOff : xfsLabelOff+12]),
+	}
+}
+
+const f2fsMagic = 0xF2F52010
+
+func probeF2FS(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 1024, 124+1024)
+	if b == nil || binary.LittleEndian.Uint32(b) != f2fsMagic {
+		return nil
+	}
+	return &FSInfo{
+		Type:  "f2fs",
+		UUID:  uuidString(b[108:124]),
+		Label: utf16String(b[124:]),
+	}
+}
+
+const erofsMagic = 0xE0F5E1E2
+
+func probeEROFS(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 1024, 80)
+	if b == nil || binary.LittleEndian.Uint32(b) != erofsMagic {
+		return nil
+	}
+	return &FSInfo{
+		Type:  "erofs",
+		UUID:  uuidString(b[48:64]),
+		Label: cString(b[64:80]),
+	}
+}
+
+func probeSquashFS(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 0, 32)
+	if b == nil || string(b[:4]) != "hsqs" {
+		return nil
+	}
+	if binary.LittleEndian.Uint16(b[28:]) < 4 {
+		return &FSInfo{Type: "squashfs3"}
+	}
+	return &FSInfo{Type: "squashfs"}
+}
+
+// isoDate turns an ISO 9660 "YYYYMMDDHHMMSScc" date into a UUID the way
+// blkid does. It returns "" for unset dates.
+func isoDate(b []byte) string {
+	s := string(b[:16])
+	if strings.Trim(s, "0") == "" {
+		return ""
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s-%s-%s-%s-%s-%s-%s", s[0:4], s[4:6], s[6:8], s[8:10], s[10:12], s[12:14], s[14:16])
+}
+
+func probeISO9660(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 0x8000, 2048)
+	if b == nil || b[0] != 1 || string(b[1:6]) != "CD001" {
+		return nil
+	}
+	info := &FSInfo{Type: "iso9660", Label: cString(b[40:72])}
+	// Like blkid, prefer the modification date.
+	if info.UUID = isoDate(b[830:]); info.UUID == "" {
+		info.UUID = isoDate(b[813:])
+	}
+	return info
+}
+
+const (
+	// ntfsVolumeRecord is the MFT record of the $Volume file.
+	ntfsVolumeRecord = 3
+
+	ntfsAttrVolumeName = 0x60
+	ntfsAttrEnd        = 0xffffffff
+)
+
+func probeNTFS(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 0, 512)
+	if b == nil || string(b[3:11]) != "NTFS    " {
+		return nil
+	}
+	info := &FSInfo{
+		Type: "ntfs",
+		UUID: fmt.Sprintf("%016X", binary.LittleEndian.Uint64(b[0x48:])),
+	}
+
+	// The label is the $VOLUME_NAME attribute of the $Volume MFT record.
+	sector := int64(binary.LittleEndian.Uint16(b[0x0b:]))
+	cluster := sector * int64(b[0x0d])
+	recSize := int64(int8(b[0x40]))
+	if recSize < 0 {
+		recSize = 1 << -recSize
+	} else {
+		recSize *= cluster
+	}
+	if sector < 256 || cluster == 0 || recSize < 256 || recSize > 64<<10 {
+		return info
+	}
+	mft := int64(binary.LittleEndian.Uint64(b[0x30:])) * cluster
+	rec := readAt(r, mft+ntfsVolumeRecord*recSize, int(recSize))
+	if rec == nil || string(rec[:4]) != "FILE" {
+		return info
+	}
+
+	// Undo the update sequence fixups at the end of each sector.
+	usa := int(binary.LittleEndian.Uint16(rec[4:]))
+	for i := 1; i < int(binary.LittleEndian.Uint16(rec[6:])); i++ {
+		end := i * int(sector)
+		if end > len(rec) || usa+2*i+2 > len(rec) {
+			break
+		}
+		copy(rec[end-2:end], rec[usa+2*i:])
+	}
+
+	for a := int(binary.LittleEndian.Uint16(rec[0x14:])); a+0x18 <= len(rec); {
+		typ := binary.LittleEndian.Uint32(rec[a:])
+		l := int(binary.LittleEndian.Uint32(rec[a+4:]))
+		if typ == ntfsAttrEnd || l < 0x18 || a+l > len(rec) {
+			break
+		}
+		// Only resident attributes.
+		if typ == ntfsAttrVolumeName && rec[a+8] == 0 {
+			vl := int(binary.LittleEndian.Uint32(rec[a+0x10:]))
+			vo := int(binary.LittleEndian.Uint16(rec[a+0x14:]))
+			if vo+vl <= l {
+				info.Label = utf16String(rec[a+vo : a+vo+vl])
+			}
+			break
+		}
+		a += l
+	}
+	return info
+}
+
+const (
+	exfatEntryEnd   = 0x00
+	exfatEntryLabel = 0x83
+
+	// exfatMaxClusters limits how far the root directory is searched.
+	exfatMaxClusters = 16
+)
+
+func probeExFAT(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 0, 512)
+	if b == nil || string(b[3:11]) != "EXFAT   " {
+		return nil
+	}
+	serial := binary.LittleEndian.Uint32(b[100:])
+	info := &FSInfo{
+		Type: "exfat",
+		UUID: fmt.Sprintf("%04X-%04X", serial>>16, serial&0xffff),
+	}
+
+	// The label is an entry in the root directory.
+	sectorShift, clusterShift := uint(b[108]), uint(b[109])
+	if sectorShift < 9 || sectorShift > 12 || sectorShift+clusterShift > 25 {
+		return info
+	}
+	fat := int64(binary.LittleEndian.Uint32(b[80:])) << sectorShift
+	heap := int64(binary.LittleEndian.Uint32(b[88:])) << sectorShift
+	clusterSize := 1 << (sectorShift + clusterShift)
+	c := binary.LittleEndian.Uint32(b[96:])
+	for i := 0; i < exfatMaxClusters && c >= 2 && c < 0xfffffff7; i++ {
+		dir := readAt(r, heap+int64(c-2)*int64(clusterSize), clusterSize)
+		if dir == nil {
+			break
+		}
+		for e := 0; e+32 <= len(dir); e += 32 {
+			switch dir[e] {
+			case exfatEntryEnd:
+				return info
+			case exfatEntryLabel:
+				n := int(dir[e+1])
+				if n > 11 {
+					n = 11
+				}
+				info.Label = utf16String(dir[e+2 : e+2+2*n])
+				return info
+			}
+		}
+		next := readAt(r, fat+int64(c)*4, 4)
+		if next == nil {
+			break
+		}
+		c = binary.LittleEndian.Uint32(next)
+	}
+	return info
+}
+
+// fatLabel returns a boot sector volume label. mkfs.vfat writes "NO NAME"
+// when there is none.
+func fatLabel(b []byte) string {
+	if l := cString(b); l != "NO NAME" {
+		return l
+	}
+	return ""
+}
+
+// fatSerial formats a FAT volume serial number like blkid does.
+func fatSerial(b []byte) string {
+	return fmt.Sprintf("%02X%02X-%02X%02X", b[3], b[2], b[1], b[0])
+}
+
+// See https://de.wikipedia.org/wiki/File_Allocation_Table#Aufbau.
+const (
+	fat12Magic = "FAT12   "
+	fat16Magic = "FAT16   "
+
+	// Offset of magic number.
+	fat16MagicOff = 0x36
+
+	// Offset of filesystem ID / serial number. Treated as short filesystem UUID.
+	fat16IDOff = 0x27
+
+	// Offset of volume label.
+	fat16LabelOff = 0x2b
+)
+
+func probeFAT16(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 0, 512)
+	if b == nil {
+		return nil
+	}
+	magic := string(b[fat16MagicOff : fat16MagicOff+8])
+	if magic != fat16Magic && magic != fat12Magic {
+		return nil
+	}
+	return &FSInfo{
+		Type:  "vfat",
+		UUID:  fatSerial(b[fat16IDOff:]),
+		Label: fatLabel(b[fat16LabelOff : fat16LabelOff+11]),
+	}
+}
+
+// See https://de.wikipedia.org/wiki/File_Allocation_Table#Aufbau.
+const (
+	fat32Magic = "FAT32   "
+
+	// Offset of magic number.
+	fat32MagicOff = 0x52
+
+	// Offset of filesystem ID / serial number. Treated as short filesystem UUID.
+	fat32IDOff = 67
+
+	// Offset of volume label.
+	fat32LabelOff = 71
+)
+
+func probeFAT32(r io.ReaderAt, size int64) *FSInfo {
+	b := readAt(r, 0, 512)
+	if b == nil || string(b[fat32MagicOff:fat32MagicOff+8]) != fat32Magic {
+		return nil
+	}
+	return &FSInfo{
+		Type:  "vfat",
+		UUID:  fatSerial(b[fat32IDOff:]),
+		Label: fatLabel(b[fat32LabelOff : fat32LabelOff+11]),
+	}
+}
+
+// swapPageSizes are the page sizes a swap signature is looked for at.
+var swapPageSizes = []int64{4096, 8192, 16384, 65536}
+
+func probeSwap(r io.ReaderAt, size int64) *FSInfo {
+	for _, ps := range swapPageSizes {
+		sig := readAt(r, ps-10, 10)
+		switch string(sig) {
+		case "SWAP-SPACE":
+			return &FSInfo{Type: "swap"}
+		case "SWAPSPACE2":
+			b := readAt(r, 1024, 44)
+			if b == nil {
+				return &FSInfo{Type: "swap"}
+			}
+			return &FSInfo{
+				Type:  "swap",
+				UUID:  uuidString(b[12:28]),
+				Label: cString(b[28:44]),
+			}
+		}
+	}
+	return nil
+}
+
+// PartInfo returns the PARTUUID and PARTLABEL of partition n, counting from
+// 1, of the disk r with the given logical block size.
+//
+// For GPT disks these are the partition GUID and name. For MBR disks, the
+// PARTUUID is made of the disk signature and partition number, and there is
+// no label.
+func PartInfo(r io.ReaderAt, size int64, blockSize int64, n int) (uuid, label string, err error) {
+	sr := io.NewSectionReader(r, 0, size)
+	if _, err := sr.Seek(blockSize, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	if table, err := gpt.ReadTable(sr, uint64(blockSize)); err == nil {
+		if n < 1 || n > len(table.Partitions) || table.Partitions[n-1].IsEmpty() {
+			return "", "", fmt.Errorf("no GPT partition %d", n)
+		}
+		p := table.Partitions[n-1]
+		return strings.ToLower(p.Id.String()), p.Name(), nil
+	}
+
+	mbr := readAt(r, 0, 512)
+	if mbr == nil || mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return "", "", errors.New("no partition table")
+	}
+	sig := binary.LittleEndian.Uint32(mbr[440:])
+	if sig == 0 {
+		return "", "", errors.New("MBR has no disk signature")
+	}
+	return fmt.Sprintf("%08x-%02x", sig, n), "", nil
+}
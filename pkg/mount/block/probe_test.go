@@ -0,0 +1,357 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/rekby/gpt"
+)
+
+var testUUID = []byte{0x2e, 0x83, 0xab, 0x4d, 0x1f, 0x0e, 0x4b, 0x47, 0x9a, 0x1c, 0x34, 0x95, 0xf0, 0x5d, 0x0b, 0x6e}
+
+const testUUIDString = "2e83ab4d-1f0e-4b47-9a1c-3495f05d0b6e"
+
+func le16(b []byte, v uint16) { binary.LittleEndian.PutUint16(b, v) }
+func le32(b []byte, v uint32) { binary.LittleEndian.PutUint32(b, v) }
+
+func putUTF16(b []byte, s string) {
+	for i, c := range utf16.Encode([]rune(s)) {
+		le16(b[2*i:], c)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		size int
+		make func(b []byte)
+		want FSInfo
+	}{
+		{
+			name: "ext2",
+			make: func(b []byte) {
+				le16(b[1024+56:], 0xef53)
+				copy(b[1024+104:], testUUID)
+				copy(b[1024+120:], "root")
+			},
+			want: FSInfo{Type: "ext2", UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "ext3",
+			make: func(b []byte) {
+				le16(b[1024+56:], 0xef53)
+				le32(b[1024+92:], 0x4)
+				le32(b[1024+96:], 0x2)
+				copy(b[1024+104:], testUUID)
+			},
+			want: FSInfo{Type: "ext3", UUID: testUUIDString},
+		},
+		{
+			name: "ext4",
+			make: func(b []byte) {
+				le16(b[1024+56:], 0xef53)
+				le32(b[1024+92:], 0x4)
+				// extents, 64bit, flex_bg
+				le32(b[1024+96:], 0x2|0x40|0x80|0x200)
+				copy(b[1024+104:], testUUID)
+				copy(b[1024+120:], "cloudimg-rootfs")
+			},
+			want: FSInfo{Type: "ext4", UUID: testUUIDString, Label: "cloudimg-rootfs"},
+		},
+		{
+			name: "xfs",
+			make: func(b []byte) {
+				copy(b, "XFSB")
+				copy(b[32:], testUUID)
+				copy(b[108:], "data")
+			},
+			want: FSInfo{Type: "xfs", UUID: testUUIDString, Label: "data"},
+		},
+		{
+			name: "btrfs",
+			size: 128 << 10,
+			make: func(b []byte) {
+				sb := b[64<<10:]
+				copy(sb[0x20:], testUUID)
+				copy(sb[0x40:], "_BHRfS_M")
+				copy(sb[0x12b:], "pool")
+			},
+			want: FSInfo{Type: "btrfs", UUID: testUUIDString, Label: "pool"},
+		},
+		{
+			name: "f2fs",
+			make: func(b []byte) {
+				le32(b[1024:], 0xf2f52010)
+				copy(b[1024+108:], testUUID)
+				putUTF16(b[1024+124:], "flash")
+			},
+			want: FSInfo{Type: "f2fs", UUID: testUUIDString, Label: "flash"},
+		},
+		{
+			name: "erofs",
+			make: func(b []byte) {
+				le32(b[1024:], 0xe0f5e1e2)
+				copy(b[1024+48:], testUUID)
+				copy(b[1024+64:], "system")
+			},
+			want: FSInfo{Type: "erofs", UUID: testUUIDString, Label: "system"},
+		},
+		{
+			name: "squashfs",
+			make: func(b []byte) {
+				copy(b, "hsqs")
+				le16(b[28:], 4)
+			},
+			want: FSInfo{Type: "squashfs"},
+		},
+		{
+			name: "iso9660",
+			make: func(b []byte) {
+				pvd := b[0x8000:]
+				pvd[0] = 1
+				copy(pvd[1:], "CD001")
+				copy(pvd[40:], "UBUNTU 20.04                    ")
+				copy(pvd[813:], "2020080610204900")
+				copy(pvd[830:], "0000000000000000")
+			},
+			want: FSInfo{Type: "iso9660", UUID: "2020-08-06-10-20-49-00", Label: "UBUNTU 20.04"},
+		},
+		{
+			name: "ntfs",
+			make: func(b []byte) {
+				copy(b[3:], "NTFS    ")
+				le16(b[0x0b:], 512)
+				b[0x0d] = 8
+				// MFT at cluster 2, 1 KiB records.
+				binary.LittleEndian.PutUint64(b[0x30:], 2)
+				b[0x40] = 0xf6
+				binary.LittleEndian.PutUint64(b[0x48:], 0x1a2b3c4d5e6f7081)
+
+				rec := b[2*4096+3*1024:]
+				copy(rec, "FILE")
+				// Update sequence at 0x30 with two entries; the
+				// label straddles the end of the first sector.
+				le16(rec[4:], 0x30)
+				le16(rec[6:], 3)
+				le16(rec[0x30:], 0xabcd)
+				le16(rec[0x32:], 'd')
+				le16(rec[0x34:], 0)
+				le16(rec[0x14:], 0x1e0)
+				a := rec[0x1e0:]
+				le32(a[0:], 0x60)
+				le32(a[4:], 0x18+16)
+				le32(a[0x10:], 16)
+				le16(a[0x14:], 0x18)
+				putUTF16(a[0x18:], "Windows")
+				le16(rec[510:], 0xabcd)
+				le32(rec[0x1e0+0x18+16:], 0xffffffff)
+			},
+			want: FSInfo{Type: "ntfs", UUID: "1A2B3C4D5E6F7081", Label: "Windows"},
+		},
+		{
+			name: "exfat",
+			make: func(b []byte) {
+				copy(b[3:], "EXFAT   ")
+				le32(b[80:], 8)
+				le32(b[88:], 16)
+				le32(b[96:], 4)
+				le32(b[100:], 0x1234abcd)
+				b[108] = 9
+				b[109] = 3
+				// Root directory in cluster 4: a bitmap entry, then the label.
+				dir := b[16*512+2*4096:]
+				dir[0] = 0x81
+				dir[32] = 0x83
+				dir[33] = 5
+				putUTF16(dir[34:], "STICK")
+			},
+			want: FSInfo{Type: "exfat", UUID: "1234-ABCD", Label: "STICK"},
+		},
+		{
+			name: "vfat32",
+			make: func(b []byte) {
+				copy(b[0x52:], "FAT32   ")
+				copy(b[67:], []byte{0x44, 0x33, 0x22, 0x11})
+				copy(b[71:], "EFI        ")
+			},
+			want: FSInfo{Type: "vfat", UUID: "1122-3344", Label: "EFI"},
+		},
+		{
+			name: "vfat16 no label",
+			make: func(b []byte) {
+				copy(b[0x36:], "FAT16   ")
+				copy(b[0x27:], []byte{0xef, 0xbe, 0xad, 0xde})
+				copy(b[0x2b:], "NO NAME    ")
+			},
+			want: FSInfo{Type: "vfat", UUID: "DEAD-BEEF"},
+		},
+		{
+			name: "swap",
+			make: func(b []byte) {
+				copy(b[4096-10:], "SWAPSPACE2")
+				copy(b[1024+12:], testUUID)
+				copy(b[1024+28:], "swap0")
+			},
+			want: FSInfo{Type: "swap", UUID: testUUIDString, Label: "swap0"},
+		},
+		{
+			name: "LUKS1",
+			make: func(b []byte) {
+				copy(b, luksMagic)
+				b[7] = 1
+				copy(b[168:], testUUIDString)
+			},
+			want: FSInfo{Type: "crypto_LUKS", UUID: testUUIDString},
+		},
+		{
+			name: "LUKS2",
+			make: func(b []byte) {
+				copy(b, luksMagic)
+				b[7] = 2
+				copy(b[24:], "secret")
+				copy(b[168:], testUUIDString)
+			},
+			want: FSInfo{Type: "crypto_LUKS", UUID: testUUIDString, Label: "secret"},
+		},
+		{
+			name: "LVM2",
+			make: func(b []byte) {
+				l := b[512:]
+				copy(l, "LABELONE")
+				le32(l[20:], 32)
+				copy(l[24:], "LVM2 001")
+				copy(l[32:], "QvMo3tJm8vUSh1Q9vXkB2sNkVnO5ep0d")
+			},
+			want: FSInfo{Type: "LVM2_member", UUID: "QvMo3t-Jm8v-USh1-Q9vX-kB2s-NkVn-O5ep0d"},
+		},
+		{
+			name: "md 1.2",
+			make: func(b []byte) {
+				sb := b[4096:]
+				le32(sb, 0xa92b4efc)
+				le32(sb[4:], 1)
+				copy(sb[16:], testUUID)
+				copy(sb[32:], "host:0")
+			},
+			want: FSInfo{Type: "linux_raid_member", UUID: testUUIDString, Label: "host:0"},
+		},
+		{
+			name: "md 1.0 over ext4",
+			size: 256 << 10,
+			make: func(b []byte) {
+				le16(b[1024+56:], 0xef53)
+				sb := b[len(b)-8192:]
+				le32(sb, 0xa92b4efc)
+				le32(sb[4:], 1)
+				copy(sb[16:], testUUID)
+			},
+			want: FSInfo{Type: "linux_raid_member", UUID: testUUIDString},
+		},
+		{
+			name: "md 0.90",
+			size: 256<<10 + 4096,
+			make: func(b []byte) {
+				sb := b[256<<10-64<<10:]
+				le32(sb, 0xa92b4efc)
+				copy(sb[20:], testUUID[:4])
+				copy(sb[52:], testUUID[4:])
+			},
+			want: FSInfo{Type: "linux_raid_member", UUID: testUUIDString},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			size := tt.size
+			if size == 0 {
+				size = 64 << 10
+			}
+			b := make([]byte, size)
+			tt.make(b)
+			got, err := Probe(bytes.NewReader(b), int64(size))
+			if err != nil {
+				t.Fatalf("Probe = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("Probe = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeUnknown(t *testing.T) {
+	b := make([]byte, 64<<10)
+	if _, err := Probe(bytes.NewReader(b), int64(len(b))); !errors.Is(err, ErrUnknownFS) {
+		t.Errorf("Probe(zeros) = %v, want %v", err, ErrUnknownFS)
+	}
+	if _, err := Probe(bytes.NewReader(nil), 0); !errors.Is(err, ErrUnknownFS) {
+		t.Errorf("Probe(empty) = %v, want %v", err, ErrUnknownFS)
+	}
+}
+
+func TestPartInfoGPT(t *testing.T) {
+	const size = 1 << 20
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+
+	table := gpt.NewTable(size, nil)
+	id, err := gpt.StringToGuid("0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &table.Partitions[1]
+	p.Type = gpt.PartType(id)
+	p.Id = gpt.Guid(id)
+	p.FirstLBA, p.LastLBA = 100, 200
+	putUTF16(p.PartNameUTF16[:], "boot")
+	if _, err := f.Seek(512, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Write(f); err != nil {
+		t.Fatal(err)
+	}
+
+	uuid, label, err := PartInfo(f, size, 512, 2)
+	if err != nil {
+		t.Fatalf("PartInfo = %v", err)
+	}
+	if uuid != "0fc63daf-8483-4772-8e79-3d69d8477de4" || label != "boot" {
+		t.Errorf("PartInfo = %q, %q, want %q, %q", uuid, label, "0fc63daf-8483-4772-8e79-3d69d8477de4", "boot")
+	}
+	if _, _, err := PartInfo(f, size, 512, 1); err == nil {
+		t.Errorf("PartInfo(empty partition) = nil, want error")
+	}
+}
+
+func TestPartInfoMBR(t *testing.T) {
+	b := make([]byte, 4096)
+	le32(b[440:], 0x8a1b2c3d)
+	b[510], b[511] = 0x55, 0xaa
+
+	uuid, label, err := PartInfo(bytes.NewReader(b), int64(len(b)), 512, 3)
+	if err != nil {
+		t.Fatalf("PartInfo = %v", err)
+	}
+	if want := []string{"8a1b2c3d-03", ""}; !reflect.DeepEqual([]string{uuid, label}, want) {
+		t.Errorf("PartInfo = %q, %q, want %q", uuid, label, want)
+	}
+
+	b[510] = 0
+	if _, _, err := PartInfo(bytes.NewReader(b), int64(len(b)), 512, 1); err == nil {
+		t.Errorf("PartInfo(no table) = nil, want error")
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dm controls Linux device-mapper devices through the ioctl
+// interface on /dev/mapper/control.
+//
+// A device-mapper device maps ranges of its sectors to targets, such as
+// a range of another block device (linear) or an encrypted view of one
+// (crypt). Devices are created empty, loaded with a table of targets and
+// then resumed to make the table live.
+package dm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+// SectorSize is the unit of target start and length.
+const SectorSize = 512
+
+// Target maps a range of a device's sectors.
+type Target struct {
+	// Start is the first sector of the device mapped by this target.
+	Start uint64
+
+	// Length is the number of sectors mapped.
+	Length uint64
+
+	// Type is the target type, e.g. linear or crypt.
+	Type string
+
+	// Params are the target type specific parameters.
+	Params string
+}
+
+// String formats t as a line of a dmsetup table.
+func (t Target) String() string {
+	if t.Params == "" {
+		return fmt.Sprintf("%d %d %s", t.Start, t.Length, t.Type)
+	}
+	return fmt.Sprintf("%d %d %s %s", t.Start, t.Length, t.Type, t.Params)
+}
+
+// Info describes a device.
+type Info struct {
+	Name        string
+	UUID        string
+	Major       uint32
+	Minor       uint32
+	OpenCount   int32
+	TargetCount uint32
+	EventNr     uint32
+
+	ReadOnly      bool
+	Suspended     bool
+	LiveTable     bool
+	InactiveTable bool
+}
+
+// ErrBufferFull is returned if a response does not fit the largest
+// buffer we are willing to use.
+var ErrBufferFull = errors.New("device-mapper response too large")
+
+// ioctl commands, from include/uapi/linux/dm-ioctl.h.
+const (
+	cmdVersion = iota
+	cmdRemoveAll
+	cmdListDevices
+	cmdDevCreate
+	cmdDevRemove
+	cmdDevRename
+	cmdDevSuspend
+	cmdDevStatus
+	cmdDevWait
+	cmdTableLoad
+	cmdTableClear
+	cmdTableDeps
+	cmdTableStatus
+	cmdListVersions
+	cmdTargetMsg
+	cmdDevSetGeometry
+)
+
+// dm_ioctl flags.
+const (
+	flagReadOnly        = 1 << 0
+	flagSuspend         = 1 << 1
+	flagPersistentDev   = 1 << 3
+	flagStatusTable     = 1 << 4
+	flagActivePresent   = 1 << 5
+	flagInactivePresent = 1 << 6
+	flagBufferFull      = 1 << 8
+	flagSkipBdget       = 1 << 9
+	flagSkipLockfs      = 1 << 10
+	flagNoFlush         = 1 << 11
+	flagQueryInactive   = 1 << 12
+	flagUeventGenerated = 1 << 13
+	flagUUID            = 1 << 14
+	flagSecureData      = 1 << 15
+	flagDeferredRemove  = 1 << 17
+)
+
+const (
+	// Sizes of struct dm_ioctl and its fields.
+	headerSize = 312
+	nameLen    = 128
+	uuidLen    = 129
+
+	// specSize is the size of struct dm_target_spec.
+	specSize = 40
+	typeLen  = 16
+
+	// The interface version we speak. The kernel accepts any minor.
+	versionMajor = 4
+	versionMinor = 0
+	versionPatch = 0
+)
+
+// header is struct dm_ioctl.
+type header struct {
+	Version     [3]uint32
+	DataSize    uint32
+	DataStart   uint32
+	TargetCount uint32
+	OpenCount   int32
+	Flags       uint32
+	EventNr     uint32
+	_           uint32
+	Dev         uint64
+	Name        [nameLen]byte
+	UUID        [uuidLen]byte
+	_           [7]byte
+}
+
+// spec is struct dm_target_spec.
+type spec struct {
+	SectorStart uint64
+	Length      uint64
+	Status      int32
+	Next        uint32
+	TargetType  [typeLen]byte
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+// newHeader returns a header for name with the given flags.
+func newHeader(name string, flags uint32) (*header, error) {
+	h := &header{
+		Version:   [3]uint32{versionMajor, versionMinor, versionPatch},
+		DataStart: headerSize,
+		Flags:     flags,
+	}
+	if len(name) >= nameLen {
+		return nil, fmt.Errorf("device name %q longer than %d bytes", name, nameLen-1)
+	}
+	copy(h.Name[:], name)
+	return h, nil
+}
+
+func (h *header) setUUID(uuid string) error {
+	if len(uuid) >= uuidLen {
+		return fmt.Errorf("device uuid %q longer than %d bytes", uuid, uuidLen-1)
+	}
+	copy(h.UUID[:], uuid)
+	return nil
+}
+
+func (h *header) info() *Info {
+	return &Info{
+		Name:          cString(h.Name[:]),
+		UUID:          cString(h.UUID[:]),
+		Major:         uint32((h.Dev >> 8) & 0xfff),
+		Minor:         uint32((h.Dev & 0xff) | ((h.Dev >> 12) & 0xfff00)),
+		OpenCount:     h.OpenCount,
+		TargetCount:   h.TargetCount,
+		EventNr:       h.EventNr,
+		ReadOnly:      h.Flags&flagReadOnly != 0,
+		Suspended:     h.Flags&flagSuspend != 0,
+		LiveTable:     h.Flags&flagActivePresent != 0,
+		InactiveTable: h.Flags&flagInactivePresent != 0,
+	}
+}
+
+// encodeDev encodes a device number like the kernel's huge_encode_dev.
+func encodeDev(major, minor uint32) uint64 {
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12
+}
+
+// marshal returns the ioctl buffer for h followed by data, at least size
+// bytes long.
+func marshal(h *header, data []byte, size int) []byte {
+	if n := headerSize + len(data); n > size {
+		size = n
+	}
+	h.DataSize = uint32(size)
+	b := make([]byte, size)
+	w := bytes.NewBuffer(b[:0])
+	if err := binary.Write(w, ubinary.NativeEndian, h); err != nil {
+		panic(err)
+	}
+	copy(b[headerSize:], data)
+	return b
+}
+
+// unmarshal parses the header of the ioctl buffer b and returns the data
+// following it.
+func unmarshal(b []byte) (*header, []byte, error) {
+	if len(b) < headerSize {
+		return nil, nil, fmt.Errorf("short device-mapper response: %d bytes", len(b))
+	}
+	var h header
+	if err := binary.Read(bytes.NewReader(b), ubinary.NativeEndian, &h); err != nil {
+		return nil, nil, err
+	}
+	if h.DataStart < headerSize || int(h.DataStart) > len(b) || h.DataSize > uint32(len(b)) || h.DataSize < h.DataStart {
+		return nil, nil, fmt.Errorf("invalid device-mapper response: data %d-%d of %d bytes", h.DataStart, h.DataSize, len(b))
+	}
+	return &h, b[h.DataStart:h.DataSize], nil
+}
+
+// marshalTargets encodes targets as dm_target_specs for DM_TABLE_LOAD.
+func marshalTargets(targets []Target) ([]byte, error) {
+	var data []byte
+	for _, t := range targets {
+		if len(t.Type) >= typeLen {
+			return nil, fmt.Errorf("target type %q longer than %d bytes", t.Type, typeLen-1)
+		}
+		if strings.ContainsRune(t.Params, 0) {
+			return nil, fmt.Errorf("target parameters contain NUL")
+		}
+		s := spec{
+			SectorStart: t.Start,
+			Length:      t.Length,
+			// Params are NUL terminated; the next spec is 8 byte
+			// aligned.
+			Next: uint32(align8(specSize + len(t.Params) + 1)),
+		}
+		copy(s.TargetType[:], t.Type)
+		b := make([]byte, s.Next)
+		w := bytes.NewBuffer(b[:0])
+		if err := binary.Write(w, ubinary.NativeEndian, &s); err != nil {
+			return nil, err
+		}
+		copy(b[specSize:], t.Params)
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+// unmarshalTargets decodes the n dm_target_specs returned by
+// DM_TABLE_STATUS. Their Next fields are relative to the start of data.
+func unmarshalTargets(data []byte, n uint32) ([]Target, error) {
+	var ts []Target
+	off := 0
+	for i := uint32(0); i < n; i++ {
+		if off+specSize > len(data) {
+			return nil, fmt.Errorf("target %d at %d beyond %d bytes of data", i, off, len(data))
+		}
+		var s spec
+		if err := binary.Read(bytes.NewReader(data[off:]), ubinary.NativeEndian, &s); err != nil {
+			return nil, err
+		}
+		end := len(data)
+		if i+1 < n {
+			end = int(s.Next)
+		}
+		if end < off+specSize || end > len(data) {
+			return nil, fmt.Errorf("target %d: invalid next offset %d", i, s.Next)
+		}
+		ts = append(ts, Target{
+			Start:  s.SectorStart,
+			Length: s.Length,
+			Type:   cString(s.TargetType[:]),
+			Params: cString(data[off+specSize : end]),
+		})
+		off = int(s.Next)
+	}
+	return ts, nil
+}
+
+// Device is an entry of DM_LIST_DEVICES.
+type Device struct {
+	Name  string
+	Major uint32
+	Minor uint32
+}
+
+// unmarshalNames decodes the struct dm_name_list entries returned by
+// DM_LIST_DEVICES.
+func unmarshalNames(data []byte) ([]Device, error) {
+	var ds []Device
+	off := 0
+	for len(data)-off >= 12 {
+		dev := ubinary.NativeEndian.Uint64(data[off:])
+		next := ubinary.NativeEndian.Uint32(data[off+8:])
+		// An empty list has a single zero entry.
+		if dev == 0 && next == 0 && len(ds) == 0 {
+			break
+		}
+		h := header{Dev: dev}
+		i := h.info()
+		ds = append(ds, Device{Name: cString(data[off+12:]), Major: i.Major, Minor: i.Minor})
+		if next == 0 {
+			break
+		}
+		off += int(next)
+	}
+	return ds, nil
+}
+
+// TargetVersion is a target type loaded in the kernel.
+type TargetVersion struct {
+	Name    string
+	Version [3]uint32
+}
+
+// unmarshalVersions decodes the struct dm_target_versions entries returned
+// by DM_LIST_VERSIONS. Their next fields are relative to each entry.
+func unmarshalVersions(data []byte) ([]TargetVersion, error) {
+	var vs []TargetVersion
+	for off := 0; len(data)-off >= 16; {
+		next := ubinary.NativeEndian.Uint32(data[off:])
+		v := TargetVersion{Name: cString(data[off+16:])}
+		for i := range v.Version {
+			v.Version[i] = ubinary.NativeEndian.Uint32(data[off+4+4*i:])
+		}
+		vs = append(vs, v)
+		if next == 0 {
+			break
+		}
+		off += int(next)
+	}
+	return vs, nil
+}
+
+// unmarshalDeps decodes the struct dm_target_deps returned by
+// DM_TABLE_DEPS.
+func unmarshalDeps(data []byte) ([]Device, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("short dependency list: %d bytes", len(data))
+	}
+	n := int(ubinary.NativeEndian.Uint32(data))
+	if len(data) < 8+8*n {
+		return nil, fmt.Errorf("%d dependencies in %d bytes", n, len(data))
+	}
+	ds := make([]Device, n)
+	for i := range ds {
+		h := header{Dev: ubinary.NativeEndian.Uint64(data[8+8*i:])}
+		in := h.info()
+		ds[i] = Device{Major: in.Major, Minor: in.Minor}
+	}
+	return ds, nil
+}
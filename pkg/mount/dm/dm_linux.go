@@ -0,0 +1,387 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ControlPath is the device-mapper control device.
+	ControlPath = "/dev/mapper/control"
+
+	// MapperDir holds the device nodes of named devices.
+	MapperDir = "/dev/mapper"
+
+	// ioctlBase is _IOWR(0xfd, 0, struct dm_ioctl).
+	ioctlBase = 0xc0000000 | headerSize<<16 | 0xfd<<8
+
+	// Response buffers start at bufSize and grow up to maxBufSize.
+	bufSize    = 16 << 10
+	maxBufSize = 4 << 20
+)
+
+// Control is an open device-mapper control device.
+type Control struct {
+	f *os.File
+}
+
+// Open opens the device-mapper control device.
+func Open() (*Control, error) {
+	f, err := os.OpenFile(ControlPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Control{f: f}, nil
+}
+
+// Close closes the control device.
+func (c *Control) Close() error {
+	return c.f.Close()
+}
+
+// ioctl issues cmd with header h and data. It returns the response header
+// and data, growing the buffer while the kernel reports it full.
+func (c *Control) ioctl(cmd uintptr, h *header, data []byte) (*header, []byte, error) {
+	for size := bufSize; ; size *= 2 {
+		req := *h
+		b := marshal(&req, data, size)
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, c.f.Fd(), ioctlBase|cmd, uintptr(unsafe.Pointer(&b[0]))); errno != 0 {
+			return nil, nil, errno
+		}
+		rh, rdata, err := unmarshal(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rh.Flags&flagBufferFull == 0 {
+			return rh, rdata, nil
+		}
+		if size >= maxBufSize {
+			return nil, nil, ErrBufferFull
+		}
+	}
+}
+
+// simple issues a command that only needs a device name.
+func (c *Control) simple(cmd uintptr, name string, flags uint32) (*Info, error) {
+	h, err := newHeader(name, flags)
+	if err != nil {
+		return nil, err
+	}
+	rh, _, err := c.ioctl(cmd, h, nil)
+	if err != nil {
+		return nil, &Error{Op: cmdNames[cmd], Name: name, Err: err}
+	}
+	return rh.info(), nil
+}
+
+// Error is a failed device-mapper operation.
+type Error struct {
+	Op   string
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("dm %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("dm %s %s: %v", e.Op, e.Name, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+var cmdNames = map[uintptr]string{
+	cmdVersion:      "version",
+	cmdRemoveAll:    "remove all",
+	cmdListDevices:  "list",
+	cmdDevCreate:    "create",
+	cmdDevRemove:    "remove",
+	cmdDevRename:    "rename",
+	cmdDevSuspend:   "suspend",
+	cmdDevStatus:    "info",
+	cmdTableLoad:    "load",
+	cmdTableClear:   "clear",
+	cmdTableDeps:    "deps",
+	cmdTableStatus:  "status",
+	cmdListVersions: "targets",
+	cmdTargetMsg:    "message",
+}
+
+// Version returns the kernel's device-mapper interface version.
+func (c *Control) Version() ([3]uint32, error) {
+	h, _ := newHeader("", 0)
+	rh, _, err := c.ioctl(cmdVersion, h, nil)
+	if err != nil {
+		return [3]uint32{}, &Error{Op: "version", Err: err}
+	}
+	return rh.Version, nil
+}
+
+// Create creates an empty device.
+func (c *Control) Create(name, uuid string, readOnly bool) (*Info, error) {
+	var flags uint32
+	if readOnly {
+		flags |= flagReadOnly
+	}
+	h, err := newHeader(name, flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.setUUID(uuid); err != nil {
+		return nil, err
+	}
+	rh, _, err := c.ioctl(cmdDevCreate, h, nil)
+	if err != nil {
+		return nil, &Error{Op: "create", Name: name, Err: err}
+	}
+	return rh.info(), nil
+}
+
+// Load loads targets as the inactive table of the device name.
+func (c *Control) Load(name string, targets []Target, readOnly bool) error {
+	var flags uint32
+	if readOnly {
+		flags |= flagReadOnly
+	}
+	h, err := newHeader(name, flags)
+	if err != nil {
+		return err
+	}
+	data, err := marshalTargets(targets)
+	if err != nil {
+		return err
+	}
+	h.TargetCount = uint32(len(targets))
+	// Tables can contain keys.
+	h.Flags |= flagSecureData
+	if _, _, err := c.ioctl(cmdTableLoad, h, data); err != nil {
+		return &Error{Op: "load", Name: name, Err: err}
+	}
+	return nil
+}
+
+// Resume makes the inactive table of name live and resumes I/O.
+func (c *Control) Resume(name string) (*Info, error) {
+	return c.simple(cmdDevSuspend, name, 0)
+}
+
+// Suspend suspends I/O to name.
+func (c *Control) Suspend(name string) (*Info, error) {
+	return c.simple(cmdDevSuspend, name, flagSuspend)
+}
+
+// Remove removes the device name.
+func (c *Control) Remove(name string) error {
+	_, err := c.simple(cmdDevRemove, name, 0)
+	return err
+}
+
+// Info returns information about the device name.
+func (c *Control) Info(name string) (*Info, error) {
+	return c.simple(cmdDevStatus, name, 0)
+}
+
+// table returns the live table targets of name, with their parameters
+// (statusTable) or status.
+func (c *Control) table(name string, flags uint32) (*Info, []Target, error) {
+	h, err := newHeader(name, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	rh, data, err := c.ioctl(cmdTableStatus, h, nil)
+	if err != nil {
+		return nil, nil, &Error{Op: "status", Name: name, Err: err}
+	}
+	ts, err := unmarshalTargets(data, rh.TargetCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rh.info(), ts, nil
+}
+
+// Table returns the live table of name. Keys in crypt tables are only
+// shown to privileged callers.
+func (c *Control) Table(name string) (*Info, []Target, error) {
+	return c.table(name, flagStatusTable|flagSecureData)
+}
+
+// Status returns the target status of name, e.g. whether a verity target
+// has seen corruption.
+func (c *Control) Status(name string) (*Info, []Target, error) {
+	return c.table(name, 0)
+}
+
+// List lists all devices.
+func (c *Control) List() ([]Device, error) {
+	h, _ := newHeader("", 0)
+	_, data, err := c.ioctl(cmdListDevices, h, nil)
+	if err != nil {
+		return nil, &Error{Op: "list", Err: err}
+	}
+	return unmarshalNames(data)
+}
+
+// Rename renames the device name to newName.
+func (c *Control) Rename(name, newName string) error {
+	h, err := newHeader(name, 0)
+	if err != nil {
+		return err
+	}
+	if len(newName) >= nameLen {
+		return fmt.Errorf("device name %q longer than %d bytes", newName, nameLen-1)
+	}
+	if _, _, err := c.ioctl(cmdDevRename, h, append([]byte(newName), 0)); err != nil {
+		return &Error{Op: "rename", Name: name, Err: err}
+	}
+	return nil
+}
+
+// Clear removes the inactive table of name.
+func (c *Control) Clear(name string) error {
+	_, err := c.simple(cmdTableClear, name, 0)
+	return err
+}
+
+// RemoveAll removes all unused devices.
+func (c *Control) RemoveAll() error {
+	h, _ := newHeader("", 0)
+	if _, _, err := c.ioctl(cmdRemoveAll, h, nil); err != nil {
+		return &Error{Op: "remove all", Err: err}
+	}
+	return nil
+}
+
+// Deps returns the devices the live table of name uses. Their names are
+// not set.
+func (c *Control) Deps(name string) ([]Device, error) {
+	h, err := newHeader(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := c.ioctl(cmdTableDeps, h, nil)
+	if err != nil {
+		return nil, &Error{Op: "deps", Name: name, Err: err}
+	}
+	return unmarshalDeps(data)
+}
+
+// Targets lists the target types the kernel supports.
+func (c *Control) Targets() ([]TargetVersion, error) {
+	h, _ := newHeader("", 0)
+	_, data, err := c.ioctl(cmdListVersions, h, nil)
+	if err != nil {
+		return nil, &Error{Op: "targets", Err: err}
+	}
+	return unmarshalVersions(data)
+}
+
+// Message sends msg to the target of name at sector.
+func (c *Control) Message(name string, sector uint64, msg string) error {
+	h, err := newHeader(name, 0)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 8, 8+len(msg)+1)
+	ubinary.NativeEndian.PutUint64(data, sector)
+	data = append(append(data, msg...), 0)
+	if _, _, err := c.ioctl(cmdTargetMsg, h, data); err != nil {
+		return &Error{Op: "message", Name: name, Err: err}
+	}
+	return nil
+}
+
+// DevPath returns the device node of the device name.
+func DevPath(name string) string {
+	return filepath.Join(MapperDir, name)
+}
+
+// mknod creates the device node for i, as there may not be a udev to do
+// it.
+func mknod(i *Info) error {
+	if err := os.MkdirAll(MapperDir, 0o755); err != nil {
+		return err
+	}
+	p := DevPath(i.Name)
+	if err := unix.Mknod(p, unix.S_IFBLK|0o600, int(unix.Mkdev(i.Major, i.Minor))); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// CreateDevice creates the device name with the given table, makes it
+// live and returns its device node.
+func CreateDevice(name, uuid string, targets []Target, readOnly bool) (string, error) {
+	c, err := Open()
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	if _, err := c.Create(name, uuid, readOnly); err != nil {
+		return "", err
+	}
+	if err := c.Load(name, targets, readOnly); err != nil {
+		c.Remove(name)
+		return "", err
+	}
+	i, err := c.Resume(name)
+	if err != nil {
+		c.Remove(name)
+		return "", err
+	}
+	if err := mknod(i); err != nil {
+		c.Remove(name)
+		return "", err
+	}
+	return DevPath(name), nil
+}
+
+// RemoveDevice removes the device name and its device node.
+func RemoveDevice(name string) error {
+	c, err := Open()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Remove(name); err != nil {
+		return err
+	}
+	if err := os.Remove(DevPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// MkNodes creates missing device nodes for all devices.
+func MkNodes() error {
+	c, err := Open()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ds, err := c.List()
+	if err != nil {
+		return err
+	}
+	for _, d := range ds {
+		if err := mknod(&Info{Name: d.Name, Major: d.Major, Minor: d.Minor}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dm
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+func TestHeader(t *testing.T) {
+	h, err := newHeader("root", flagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.setUUID("CRYPT-LUKS2-1234-root"); err != nil {
+		t.Fatal(err)
+	}
+	h.Dev = encodeDev(253, 300)
+	b := marshal(h, []byte("data"), 1024)
+	if len(b) != 1024 {
+		t.Fatalf("marshal = %d bytes, want 1024", len(b))
+	}
+	if got := ubinary.NativeEndian.Uint32(b[12:]); got != 1024 {
+		t.Errorf("data_size = %d, want 1024", got)
+	}
+	if got := cString(b[48:]); got != "root" {
+		t.Errorf("name = %q, want root", got)
+	}
+
+	rh, data, err := unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("data")) || len(data) != 1024-headerSize {
+		t.Errorf("data = %q...", data[:8])
+	}
+	want := &Info{Name: "root", UUID: "CRYPT-LUKS2-1234-root", Major: 253, Minor: 300, ReadOnly: true}
+	if got := rh.info(); !reflect.DeepEqual(got, want) {
+		t.Errorf("info = %+v, want %+v", got, want)
+	}
+
+	if _, err := newHeader(string(make([]byte, nameLen)), 0); err == nil {
+		t.Errorf("newHeader accepted a %d byte name", nameLen)
+	}
+	if _, _, err := unmarshal(b[:100]); err == nil {
+		t.Errorf("unmarshal accepted a short buffer")
+	}
+}
+
+func TestTargets(t *testing.T) {
+	targets := []Target{
+		{Start: 0, Length: 2048, Type: "linear", Params: "/dev/sda 0"},
+		{Start: 2048, Length: 4096, Type: "crypt", Params: "aes-xts-plain64 00112233 0 8:1 4096"},
+	}
+	data, err := marshalTargets(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data)%8 != 0 {
+		t.Errorf("targets are %d bytes, want 8 byte aligned", len(data))
+	}
+	// Loading uses offsets from each spec, status offsets from the
+	// start of the data.
+	first := ubinary.NativeEndian.Uint32(data[20:])
+	if first != 56 {
+		t.Errorf("first next = %d, want 56", first)
+	}
+	second := ubinary.NativeEndian.Uint32(data[first+20:])
+	ubinary.NativeEndian.PutUint32(data[first+20:], first+second)
+
+	got, err := unmarshalTargets(data, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, targets) {
+		t.Errorf("unmarshalTargets = %v, want %v", got, targets)
+	}
+	if got[1].String() != "2048 4096 crypt aes-xts-plain64 00112233 0 8:1 4096" {
+		t.Errorf("String = %q", got[1].String())
+	}
+
+	if _, err := marshalTargets([]Target{{Type: "much-too-long-type"}}); err == nil {
+		t.Errorf("marshalTargets accepted a long type")
+	}
+	if _, err := unmarshalTargets(data[:30], 1); err == nil {
+		t.Errorf("unmarshalTargets accepted truncated data")
+	}
+}
+
+func TestNames(t *testing.T) {
+	entry := func(dev uint64, next uint32, name string) []byte {
+		b := make([]byte, next)
+		if next == 0 {
+			b = make([]byte, align8(12+len(name)+1))
+		}
+		ubinary.NativeEndian.PutUint64(b, dev)
+		ubinary.NativeEndian.PutUint32(b[8:], next)
+		copy(b[12:], name)
+		return b
+	}
+	data := append(entry(encodeDev(253, 0), 24, "root"), entry(encodeDev(253, 1), 0, "swap")...)
+	got, err := unmarshalNames(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Device{{"root", 253, 0}, {"swap", 253, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unmarshalNames = %v, want %v", got, want)
+	}
+
+	if got, _ := unmarshalNames(make([]byte, 16)); len(got) != 0 {
+		t.Errorf("empty list = %v", got)
+	}
+}
+
+func TestTargetHelpers(t *testing.T) {
+	for _, tt := range []struct {
+		t    Target
+		want string
+	}{
+		{Linear(0, 100, "/dev/sda1", 2048), "0 100 linear /dev/sda1 2048"},
+		{Striped(0, 256, 128, []Stripe{{"8:1", 0}, {"8:17", 2048}}), "0 256 striped 2 128 8:1 0 8:17 2048"},
+		{Crypt(0, 100, "aes-xts-plain64", []byte{0xca, 0xfe}, 0, "/dev/sda2", 4096), "0 100 crypt aes-xts-plain64 cafe 0 /dev/sda2 4096"},
+		{Crypt(0, 100, "aes-xts-plain64", []byte{0xca, 0xfe}, 0, "/dev/sda2", 4096, "allow_discards", "sector_size:4096"), "0 100 crypt aes-xts-plain64 cafe 0 /dev/sda2 4096 2 allow_discards sector_size:4096"},
+		{Verity(&VerityParams{DataDevice: "/dev/sda1", DataBlockSize: 4096, DataBlocks: 10, HashDevice: "/dev/sda2", HashBlockSize: 4096, HashStart: 1, Algorithm: "sha256", RootDigest: []byte{1}}), "0 80 verity 1 /dev/sda1 /dev/sda2 4096 4096 10 1 sha256 01 -"},
+		{SnapshotOrigin(0, 100, "/dev/vg/root"), "0 100 snapshot-origin /dev/vg/root"},
+		{Snapshot(0, 100, "/dev/vg/root", "/dev/vg/cow", true, 8), "0 100 snapshot /dev/vg/root /dev/vg/cow P 8"},
+		{Zero(100, 50), "100 50 zero"},
+	} {
+		if got := tt.t.String(); got != tt.want {
+			t.Errorf("target = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParseTable(t *testing.T) {
+	got, err := ParseTable(strings.NewReader("# root\n0 100 linear /dev/sda1 0\n\n100 50   zero\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{Linear(0, 100, "/dev/sda1", 0), Zero(100, 50)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseTable = %v, want %v", got, want)
+	}
+
+	for _, s := range []string{"0 100", "x 100 zero", "0 -1 zero"} {
+		if _, err := ParseTable(strings.NewReader(s)); err == nil {
+			t.Errorf("ParseTable(%q) succeeded", s)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	entry := func(next uint32, v [3]uint32, name string) []byte {
+		b := make([]byte, next)
+		if next == 0 {
+			b = make([]byte, align8(16+len(name)+1))
+		}
+		ubinary.NativeEndian.PutUint32(b, next)
+		for i, n := range v {
+			ubinary.NativeEndian.PutUint32(b[4+4*i:], n)
+		}
+		copy(b[16:], name)
+		return b
+	}
+	data := append(entry(24, [3]uint32{1, 14, 0}, "linear"), entry(0, [3]uint32{1, 23, 0}, "crypt")...)
+	got, err := unmarshalVersions(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TargetVersion{{"linear", [3]uint32{1, 14, 0}}, {"crypt", [3]uint32{1, 23, 0}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unmarshalVersions = %v, want %v", got, want)
+	}
+}
+
+func TestDeps(t *testing.T) {
+	data := make([]byte, 24)
+	ubinary.NativeEndian.PutUint32(data, 2)
+	ubinary.NativeEndian.PutUint64(data[8:], encodeDev(8, 1))
+	ubinary.NativeEndian.PutUint64(data[16:], encodeDev(259, 300))
+	got, err := unmarshalDeps(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Device{{Major: 8, Minor: 1}, {Major: 259, Minor: 300}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unmarshalDeps = %v, want %v", got, want)
+	}
+	if _, err := unmarshalDeps(data[:16]); err == nil {
+		t.Errorf("unmarshalDeps accepted truncated data")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Linear maps length sectors at start to device starting at offset.
+func Linear(start, length uint64, device string, offset uint64) Target {
+	return Target{
+		Start:  start,
+		Length: length,
+		Type:   "linear",
+		Params: fmt.Sprintf("%s %d", device, offset),
+	}
+}
+
+// Stripe is a device range of a striped target.
+type Stripe struct {
+	Device string
+	Offset uint64
+}
+
+// Striped maps length sectors at start across stripes in chunks of
+// chunkSize sectors. length must be a multiple of len(stripes)*chunkSize.
+func Striped(start, length, chunkSize uint64, stripes []Stripe) Target {
+	p := []string{strconv.Itoa(len(stripes)), strconv.FormatUint(chunkSize, 10)}
+	for _, s := range stripes {
+		p = append(p, s.Device, strconv.FormatUint(s.Offset, 10))
+	}
+	return Target{
+		Start:  start,
+		Length: length,
+		Type:   "striped",
+		Params: strings.Join(p, " "),
+	}
+}
+
+// Crypt maps length sectors at start to device starting at offset,
+// encrypted with cipher, e.g. aes-xts-plain64, and key. ivOffset is added
+// to sector numbers to compute IVs. opts are optional parameters, such
+// as allow_discards or sector_size:4096.
+func Crypt(start, length uint64, cipher string, key []byte, ivOffset uint64, device string, offset uint64, opts ...string) Target {
+	p := fmt.Sprintf("%s %s %d %s %d", cipher, hex.EncodeToString(key), ivOffset, device, offset)
+	if len(opts) > 0 {
+		p += fmt.Sprintf(" %d %s", len(opts), strings.Join(opts, " "))
+	}
+	return Target{
+		Start:  start,
+		Length: length,
+		Type:   "crypt",
+		Params: p,
+	}
+}
+
+// VerityParams are the parameters of a verity target.
+type VerityParams struct {
+	// DataDevice holds DataBlocks blocks of DataBlockSize bytes.
+	DataDevice    string
+	DataBlockSize uint32
+	DataBlocks    uint64
+
+	// HashDevice holds the hash tree of HashBlockSize byte blocks,
+	// starting at block HashStart.
+	HashDevice    string
+	HashBlockSize uint32
+	HashStart     uint64
+
+	// Algorithm is the hash, e.g. sha256.
+	Algorithm string
+
+	// RootDigest is the digest of the top hash block.
+	RootDigest []byte
+
+	// Salt is hashed before each block.
+	Salt []byte
+
+	// Opts are optional parameters, such as restart_on_corruption.
+	Opts []string
+}
+
+// Verity maps the data device of p read-only, verifying every block read
+// against the hash tree. The target covers the whole data device.
+func Verity(p *VerityParams) Target {
+	salt := "-"
+	if len(p.Salt) > 0 {
+		salt = hex.EncodeToString(p.Salt)
+	}
+	s := fmt.Sprintf("1 %s %s %d %d %d %d %s %s %s",
+		p.DataDevice, p.HashDevice, p.DataBlockSize, p.HashBlockSize,
+		p.DataBlocks, p.HashStart, p.Algorithm, hex.EncodeToString(p.RootDigest), salt)
+	if len(p.Opts) > 0 {
+		s += fmt.Sprintf(" %d %s", len(p.Opts), strings.Join(p.Opts, " "))
+	}
+	return Target{
+		Length: p.DataBlocks * uint64(p.DataBlockSize) / SectorSize,
+		Type:   "verity",
+		Params: s,
+	}
+}
+
+// SnapshotOrigin maps length sectors at start to origin, copying chunks
+// to the snapshots of origin before they are overwritten.
+func SnapshotOrigin(start, length uint64, origin string) Target {
+	return Target{
+		Start:  start,
+		Length: length,
+		Type:   "snapshot-origin",
+		Params: origin,
+	}
+}
+
+// Snapshot maps length sectors at start to a snapshot of origin, keeping
+// changed chunks of chunkSize sectors in cow. A persistent snapshot
+// survives reboots.
+func Snapshot(start, length uint64, origin, cow string, persistent bool, chunkSize uint64) Target {
+	mode := "N"
+	if persistent {
+		mode = "P"
+	}
+	return Target{
+		Start:  start,
+		Length: length,
+		Type:   "snapshot",
+		Params: fmt.Sprintf("%s %s %s %d", origin, cow, mode, chunkSize),
+	}
+}
+
+// Zero maps length sectors at start to zeros that ignore writes.
+func Zero(start, length uint64) Target {
+	return Target{Start: start, Length: length, Type: "zero"}
+}
+
+// ParseTable parses a dmsetup table: one target per line of the form
+// "start length type params". Empty lines and lines starting with # are
+// skipped.
+func ParseTable(r io.Reader) ([]Target, error) {
+	var ts []Target
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			return nil, fmt.Errorf("line %d: want start, length and type, got %q", n, line)
+		}
+		start, err := strconv.ParseUint(f[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid start: %v", n, err)
+		}
+		length, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid length: %v", n, err)
+		}
+		ts = append(ts, Target{
+			Start:  start,
+			Length: length,
+			Type:   f[2],
+			Params: strings.Join(f[3:], " "),
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
@@ -0,0 +1,373 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package verity builds and checks dm-verity hash trees.
+//
+// A hash tree has one digest of salt || block for every data block. The
+// digests are packed into hash blocks, which are hashed in turn, level by
+// level, until a single block remains. The digest of that block is the
+// root digest, which is all that needs to be trusted.
+//
+// The layout is that of veritysetup with format version 1: the top level
+// is stored first, and the tree may be preceded by a superblock.
+package verity
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+
+	"github.com/u-root/u-root/pkg/mount/dm"
+)
+
+// ErrCorrupt is returned by Verify for data that does not match the tree.
+var ErrCorrupt = errors.New("verity: data corruption")
+
+var hashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Params describe a hash tree.
+type Params struct {
+	// Algorithm is the hash, e.g. sha256.
+	Algorithm string
+
+	DataBlockSize uint32
+	HashBlockSize uint32
+
+	// DataBlocks is the number of data blocks covered.
+	DataBlocks uint64
+
+	// Salt is hashed before each block.
+	Salt []byte
+
+	// UUID identifies the tree in its superblock.
+	UUID [16]byte
+}
+
+// DefaultParams returns veritysetup's defaults for dataSize bytes of
+// data: sha256 over 4096 byte blocks.
+func DefaultParams(dataSize int64) *Params {
+	return &Params{
+		Algorithm:     "sha256",
+		DataBlockSize: 4096,
+		HashBlockSize: 4096,
+		DataBlocks:    uint64(dataSize) / 4096,
+	}
+}
+
+func (p *Params) check() (func() hash.Hash, error) {
+	h, ok := hashes[p.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("verity: unsupported hash %q", p.Algorithm)
+	}
+	for _, s := range []uint32{p.DataBlockSize, p.HashBlockSize} {
+		if s < 512 || s&(s-1) != 0 {
+			return nil, fmt.Errorf("verity: invalid block size %d", s)
+		}
+	}
+	if int(p.HashBlockSize) < h().Size()*2 {
+		return nil, fmt.Errorf("verity: hash block size %d too small for %s", p.HashBlockSize, p.Algorithm)
+	}
+	if p.DataBlocks == 0 {
+		return nil, errors.New("verity: no data blocks")
+	}
+	if len(p.Salt) > saltLen {
+		return nil, fmt.Errorf("verity: salt longer than %d bytes", saltLen)
+	}
+	return h, nil
+}
+
+// tree is the shape of a hash tree.
+type tree struct {
+	newHash func() hash.Hash
+
+	// perBlockBits is log2 of the digests per hash block; each digest
+	// takes hashBlockSize >> perBlockBits bytes.
+	perBlockBits uint
+
+	// levelStart is the first hash block of each level, relative to
+	// the tree, and levelBlocks their sizes. Level 0 hashes data.
+	levelStart  []uint64
+	levelBlocks []uint64
+}
+
+func (p *Params) tree() (*tree, error) {
+	h, err := p.check()
+	if err != nil {
+		return nil, err
+	}
+	t := &tree{
+		newHash:      h,
+		perBlockBits: uint(bits.Len32(p.HashBlockSize/uint32(h().Size())) - 1),
+	}
+	levels := 0
+	for t.perBlockBits*uint(levels) < 64 && (p.DataBlocks-1)>>(t.perBlockBits*uint(levels)) != 0 {
+		levels++
+	}
+	t.levelStart = make([]uint64, levels)
+	t.levelBlocks = make([]uint64, levels)
+	var pos uint64
+	for i := levels - 1; i >= 0; i-- {
+		shift := t.perBlockBits * uint(i+1)
+		n := p.DataBlocks >> shift
+		if p.DataBlocks&(1<<shift-1) != 0 {
+			n++
+		}
+		t.levelStart[i] = pos
+		t.levelBlocks[i] = n
+		pos += n
+	}
+	return t, nil
+}
+
+// Blocks returns the number of hash blocks in the tree.
+func (p *Params) Blocks() (uint64, error) {
+	t, err := p.tree()
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, b := range t.levelBlocks {
+		n += b
+	}
+	return n, nil
+}
+
+func (p *Params) digest(h hash.Hash, b []byte) []byte {
+	h.Reset()
+	h.Write(p.Salt)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// Build computes the hash tree of data. It returns the tree, to be stored
+// from a hash block boundary, and the root digest.
+func (p *Params) Build(data io.ReaderAt) ([]byte, []byte, error) {
+	t, err := p.tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	n, _ := p.Blocks()
+	hb := uint64(p.HashBlockSize)
+	out := make([]byte, n*hb)
+	h := t.newHash()
+	slot := hb >> t.perBlockBits
+
+	block := make([]byte, p.DataBlockSize)
+	if len(t.levelBlocks) == 0 {
+		// A single data block is its own root.
+		if _, err := data.ReadAt(block, 0); err != nil {
+			return nil, nil, fmt.Errorf("verity: reading data block 0: %w", err)
+		}
+		return out, p.digest(h, block), nil
+	}
+	for i := uint64(0); i < p.DataBlocks; i++ {
+		if _, err := data.ReadAt(block, int64(i)*int64(len(block))); err != nil {
+			return nil, nil, fmt.Errorf("verity: reading data block %d: %w", i, err)
+		}
+		copy(out[t.levelStart[0]*hb+i*slot:], p.digest(h, block))
+	}
+	for l := 1; l < len(t.levelBlocks); l++ {
+		for i := uint64(0); i < t.levelBlocks[l-1]; i++ {
+			off := (t.levelStart[l-1] + i) * hb
+			copy(out[t.levelStart[l]*hb+i*slot:], p.digest(h, out[off:off+hb]))
+		}
+	}
+	top := t.levelStart[len(t.levelStart)-1] * hb
+	return out, p.digest(h, out[top:top+hb]), nil
+}
+
+// Verify checks every data block against the tree stored in hashes
+// starting at hash block hashStart, and the tree against root.
+func (p *Params) Verify(data, hashes io.ReaderAt, hashStart uint64, root []byte) error {
+	t, err := p.tree()
+	if err != nil {
+		return err
+	}
+	n, _ := p.Blocks()
+	hb := uint64(p.HashBlockSize)
+	tr := make([]byte, n*hb)
+	if n > 0 {
+		if _, err := hashes.ReadAt(tr, int64(hashStart*hb)); err != nil {
+			return fmt.Errorf("verity: reading hash tree: %w", err)
+		}
+	}
+	h := t.newHash()
+	slot := hb >> t.perBlockBits
+	check := func(what string, want, b []byte) error {
+		if got := p.digest(h, b); len(want) < len(got) || subtle.ConstantTimeCompare(got, want[:len(got)]) != 1 {
+			return fmt.Errorf("%w: %s", ErrCorrupt, what)
+		}
+		return nil
+	}
+
+	// Verify top-down so a bad hash block is reported as such.
+	levels := len(t.levelBlocks)
+	if levels > 0 {
+		top := t.levelStart[levels-1] * hb
+		if err := check("root hash block", root, tr[top:top+hb]); err != nil {
+			return err
+		}
+		for l := levels - 1; l > 0; l-- {
+			for i := uint64(0); i < t.levelBlocks[l-1]; i++ {
+				off := (t.levelStart[l-1] + i) * hb
+				want := tr[t.levelStart[l]*hb+i*slot:]
+				if err := check(fmt.Sprintf("hash block %d of level %d", i, l-1), want, tr[off:off+hb]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	block := make([]byte, p.DataBlockSize)
+	for i := uint64(0); i < p.DataBlocks; i++ {
+		if _, err := data.ReadAt(block, int64(i)*int64(len(block))); err != nil {
+			return fmt.Errorf("verity: reading data block %d: %w", i, err)
+		}
+		want := root
+		if levels > 0 {
+			want = tr[t.levelStart[0]*hb+i*slot:]
+		}
+		if err := check(fmt.Sprintf("data block %d", i), want, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Target returns the verity target for data on dataDev with its tree on
+// hashDev from hash block hashStart.
+func (p *Params) Target(dataDev, hashDev string, hashStart uint64, root []byte, opts ...string) dm.Target {
+	return dm.Verity(&dm.VerityParams{
+		DataDevice:    dataDev,
+		DataBlockSize: p.DataBlockSize,
+		DataBlocks:    p.DataBlocks,
+		HashDevice:    hashDev,
+		HashBlockSize: p.HashBlockSize,
+		HashStart:     hashStart,
+		Algorithm:     p.Algorithm,
+		RootDigest:    root,
+		Salt:          p.Salt,
+		Opts:          opts,
+	})
+}
+
+const (
+	// SuperblockSize is the size of the veritysetup superblock.
+	SuperblockSize = 512
+
+	saltLen = 256
+)
+
+var signature = [8]byte{'v', 'e', 'r', 'i', 't', 'y'}
+
+// superblock is struct verity_sb.
+type superblock struct {
+	Signature     [8]byte
+	Version       uint32
+	HashType      uint32
+	UUID          [16]byte
+	Algorithm     [32]byte
+	DataBlockSize uint32
+	HashBlockSize uint32
+	DataBlocks    uint64
+	SaltSize      uint16
+	_             [6]byte
+	Salt          [saltLen]byte
+	_             [168]byte
+}
+
+// MarshalSuperblock returns the superblock describing p.
+func (p *Params) MarshalSuperblock() ([]byte, error) {
+	if _, err := p.check(); err != nil {
+		return nil, err
+	}
+	sb := superblock{
+		Signature:     signature,
+		Version:       1,
+		HashType:      1,
+		UUID:          p.UUID,
+		DataBlockSize: p.DataBlockSize,
+		HashBlockSize: p.HashBlockSize,
+		DataBlocks:    p.DataBlocks,
+		SaltSize:      uint16(len(p.Salt)),
+	}
+	copy(sb.Algorithm[:], p.Algorithm)
+	copy(sb.Salt[:], p.Salt)
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, &sb); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// ReadSuperblock reads the superblock at off of r.
+func ReadSuperblock(r io.ReaderAt, off int64) (*Params, error) {
+	var sb superblock
+	if err := binary.Read(io.NewSectionReader(r, off, SuperblockSize), binary.LittleEndian, &sb); err != nil {
+		return nil, fmt.Errorf("verity: reading superblock: %w", err)
+	}
+	if sb.Signature != signature {
+		return nil, errors.New("verity: no superblock")
+	}
+	if sb.Version != 1 || sb.HashType != 1 {
+		return nil, fmt.Errorf("verity: unsupported version %d, hash type %d", sb.Version, sb.HashType)
+	}
+	if sb.SaltSize > saltLen {
+		return nil, fmt.Errorf("verity: invalid salt size %d", sb.SaltSize)
+	}
+	alg := sb.Algorithm[:]
+	if i := bytes.IndexByte(alg, 0); i >= 0 {
+		alg = alg[:i]
+	}
+	p := &Params{
+		Algorithm:     string(alg),
+		DataBlockSize: sb.DataBlockSize,
+		HashBlockSize: sb.HashBlockSize,
+		DataBlocks:    sb.DataBlocks,
+		Salt:          append([]byte(nil), sb.Salt[:sb.SaltSize]...),
+		UUID:          sb.UUID,
+	}
+	if _, err := p.check(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Format writes a superblock at off of hashes, followed by the hash tree
+// of data from the next hash block. It returns the hash block the tree
+// starts at and the root digest.
+func (p *Params) Format(data io.ReaderAt, hashes io.WriterAt, off int64) (uint64, []byte, error) {
+	sb, err := p.MarshalSuperblock()
+	if err != nil {
+		return 0, nil, err
+	}
+	tr, root, err := p.Build(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	hb := int64(p.HashBlockSize)
+	if off%hb != 0 {
+		return 0, nil, fmt.Errorf("verity: offset %d is not a multiple of the hash block size", off)
+	}
+	start := uint64((off + SuperblockSize + hb - 1) / hb)
+	if _, err := hashes.WriteAt(sb, off); err != nil {
+		return 0, nil, err
+	}
+	if _, err := hashes.WriteAt(tr, int64(start)*hb); err != nil {
+		return 0, nil, err
+	}
+	return start, root, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type writerAt struct {
+	b []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	if n := int(off) + len(p); n > len(w.b) {
+		w.b = append(w.b, make([]byte, n-len(w.b))...)
+	}
+	return copy(w.b[off:], p), nil
+}
+
+func testData(blocks int) []byte {
+	b := make([]byte, blocks*4096)
+	for i := range b {
+		b[i] = byte(i / 4096)
+	}
+	return b
+}
+
+func TestBuild(t *testing.T) {
+	// The roots were computed independently of this package.
+	for _, tt := range []struct {
+		blocks     int
+		hashBlocks uint64
+		root       string
+	}{
+		{1, 0, "04c94e489488c1f6e2ab1fae3283ab07b693fe079b723246e8bccf7851d03e7d"},
+		{3, 1, "df652d1fdff0585d6e4ee0edd1ae648dcf2cf4163ed2017fbcaf0a83eca56858"},
+		{130, 3, "e681155196f3a443606c859a7c768bf6cdf09ef75f219ec87afa9401e27aa731"},
+	} {
+		data := testData(tt.blocks)
+		p := DefaultParams(int64(len(data)))
+		p.Salt = []byte{0xde, 0xad, 0xbe, 0xef}
+		if n, err := p.Blocks(); err != nil || n != tt.hashBlocks {
+			t.Errorf("%d blocks: Blocks() = %d, %v, want %d", tt.blocks, n, err, tt.hashBlocks)
+		}
+		tree, root, err := p.Build(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(root); got != tt.root {
+			t.Errorf("%d blocks: root = %s, want %s", tt.blocks, got, tt.root)
+		}
+		if uint64(len(tree)) != tt.hashBlocks*4096 {
+			t.Errorf("%d blocks: tree is %d bytes", tt.blocks, len(tree))
+		}
+		if err := p.Verify(bytes.NewReader(data), bytes.NewReader(tree), 0, root); err != nil {
+			t.Errorf("%d blocks: Verify = %v", tt.blocks, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := testData(130)
+	p := DefaultParams(int64(len(data)))
+	w := &writerAt{}
+	start, root, err := p.Format(bytes.NewReader(data), w, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 1 {
+		t.Errorf("Format: tree at block %d, want 1", start)
+	}
+
+	got, err := ReadSuperblock(bytes.NewReader(w.b), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Salt = nil
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("ReadSuperblock = %+v, want %+v", got, p)
+	}
+
+	if err := p.Verify(bytes.NewReader(data), bytes.NewReader(w.b), start, root); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+
+	bad := append([]byte(nil), data...)
+	bad[129*4096] ^= 1
+	if err := p.Verify(bytes.NewReader(bad), bytes.NewReader(w.b), start, root); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Verify(corrupt data) = %v, want %v", err, ErrCorrupt)
+	}
+	badTree := append([]byte(nil), w.b...)
+	badTree[3*4096] ^= 1
+	if err := p.Verify(bytes.NewReader(data), bytes.NewReader(badTree), start, root); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Verify(corrupt tree) = %v, want %v", err, ErrCorrupt)
+	}
+	if err := p.Verify(bytes.NewReader(data), bytes.NewReader(w.b), start, root[:4]); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Verify(short root) = %v, want %v", err, ErrCorrupt)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	p := DefaultParams(1 << 20)
+	p.Salt = []byte{1, 2}
+	got := p.Target("/dev/sda1", "/dev/sda2", 1, []byte{0xab}, "restart_on_corruption").String()
+	want := "0 2048 verity 1 /dev/sda1 /dev/sda2 4096 4096 256 1 sha256 ab 0102 1 restart_on_corruption"
+	if got != want {
+		t.Errorf("Target = %q, want %q", got, want)
+	}
+}
+
+func TestParams(t *testing.T) {
+	for _, p := range []*Params{
+		{Algorithm: "md5", DataBlockSize: 4096, HashBlockSize: 4096, DataBlocks: 1},
+		{Algorithm: "sha256", DataBlockSize: 4000, HashBlockSize: 4096, DataBlocks: 1},
+		{Algorithm: "sha256", DataBlockSize: 4096, HashBlockSize: 4096},
+		{Algorithm: "sha256", DataBlockSize: 4096, HashBlockSize: 4096, DataBlocks: 1, Salt: make([]byte, 257)},
+	} {
+		if _, err := p.Blocks(); err == nil {
+			t.Errorf("Blocks(%+v) succeeded", p)
+		}
+	}
+	if _, err := ReadSuperblock(bytes.NewReader(make([]byte, 512)), 0); err == nil {
+		t.Errorf("ReadSuperblock(zeros) succeeded")
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ext4 reads ext2, ext3 and ext4 file systems without the kernel.
+//
+// FS implements io/fs.FS on an io.ReaderAt, such as a block device or an
+// image file. It supports extent mapped and indirect block mapped files,
+// 64 bit block numbers, meta_bg, inline data and hashed (htree)
+// directories, which are read linearly. Symbolic links are followed
+// within the file system. Journals are not replayed.
+package ext4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Debug can be set to a logging function.
+var Debug = func(string, ...interface{}) {}
+
+// See https://www.kernel.org/doc/html/latest/filesystems/ext4/globals.html.
+const (
+	superblockOff   = 1024
+	superblockSize  = 1024
+	superblockMagic = 0xef53
+
+	rootInode = 2
+
+	// maxSymlinks bounds symlinks followed while resolving a path.
+	maxSymlinks = 40
+)
+
+// Feature flags.
+const (
+	incompatCompression = 0x1
+	incompatFiletype    = 0x2
+	incompatRecover     = 0x4
+	incompatJournalDev  = 0x8
+	incompatMetaBG      = 0x10
+	incompat64Bit       = 0x80
+
+	roCompatSparseSuper = 0x1
+)
+
+// ErrNotExt is returned by New if there is no ext2/3/4 superblock.
+var ErrNotExt = errors.New("not an ext2/3/4 file system")
+
+// superblock holds the superblock fields used here.
+type superblock struct {
+	inodesCount    uint32
+	firstDataBlock uint32
+	logBlockSize   uint32
+	blocksPerGroup uint32
+	inodesPerGroup uint32
+	inodeSize      uint16
+	incompat       uint32
+	roCompat       uint32
+	uuid           [16]byte
+	label          string
+	descSize       uint16
+	firstMetaBG    uint32
+}
+
+func parseSuperblock(b []byte) (*superblock, error) {
+	if binary.LittleEndian.Uint16(b[56:]) != superblockMagic {
+		return nil, ErrNotExt
+	}
+	sb := &superblock{
+		inodesCount:    binary.LittleEndian.Uint32(b[0:]),
+		firstDataBlock: binary.LittleEndian.Uint32(b[20:]),
+		logBlockSize:   binary.LittleEndian.Uint32(b[24:]),
+		blocksPerGroup: binary.LittleEndian.Uint32(b[32:]),
+		inodesPerGroup: binary.LittleEndian.Uint32(b[40:]),
+		inodeSize:      128,
+		incompat:       binary.LittleEndian.Uint32(b[96:]),
+		roCompat:       binary.LittleEndian.Uint32(b[100:]),
+		label:          strings.TrimRight(string(b[120:136]), "\x00"),
+		descSize:       32,
+		firstMetaBG:    binary.LittleEndian.Uint32(b[260:]),
+	}
+	copy(sb.uuid[:], b[104:120])
+	// Revision 0 file systems have fixed size inodes.
+	if binary.LittleEndian.Uint32(b[76:]) >= 1 {
+		sb.inodeSize = binary.LittleEndian.Uint16(b[88:])
+	}
+	if sb.incompat&incompat64Bit != 0 {
+		sb.descSize = binary.LittleEndian.Uint16(b[254:])
+	}
+
+	switch {
+	case sb.logBlockSize > 6:
+		return nil, fmt.Errorf("block size 1024<<%d is out of range", sb.logBlockSize)
+	case sb.inodeSize < 128 || sb.inodeSize&(sb.inodeSize-1) != 0:
+		return nil, fmt.Errorf("inode size %d is invalid", sb.inodeSize)
+	case sb.descSize < 32 || sb.descSize&(sb.descSize-1) != 0:
+		return nil, fmt.Errorf("group descriptor size %d is invalid", sb.descSize)
+	case sb.blocksPerGroup == 0 || sb.inodesPerGroup == 0:
+		return nil, fmt.Errorf("empty block groups")
+	case sb.incompat&incompatCompression != 0:
+		return nil, fmt.Errorf("compressed file systems are not supported")
+	case sb.incompat&incompatJournalDev != 0:
+		return nil, fmt.Errorf("external journal device")
+	}
+	return sb, nil
+}
+
+// FS is a read-only ext2, ext3 or ext4 file system.
+type FS struct {
+	r         io.ReaderAt
+	sb        *superblock
+	blockSize int64
+}
+
+// New reads the file system in r.
+func New(r io.ReaderAt) (*FS, error) {
+	b := make([]byte, superblockSize)
+	if _, err := r.ReadAt(b, superblockOff); err != nil {
+		return nil, fmt.Errorf("reading superblock: %w", err)
+	}
+	sb, err := parseSuperblock(b)
+	if err != nil {
+		return nil, err
+	}
+	if sb.incompat&incompatRecover != 0 {
+		Debug("ext4: journal needs recovery; recent changes may be missing")
+	}
+	return &FS{r: r, sb: sb, blockSize: 1024 << sb.logBlockSize}, nil
+}
+
+// Label returns the volume label.
+func (fsys *FS) Label() string {
+	return fsys.sb.label
+}
+
+// UUID returns the file system UUID.
+func (fsys *FS) UUID() string {
+	u := fsys.sb.uuid
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// readBlock reads file system block n.
+func (fsys *FS) readBlock(n uint64) ([]byte, error) {
+	b := make([]byte, fsys.blockSize)
+	if _, err := fsys.r.ReadAt(b, int64(n)*fsys.blockSize); err != nil {
+		return nil, fmt.Errorf("reading block %d: %w", n, err)
+	}
+	return b, nil
+}
+
+// hasSuper returns true if block group g has a superblock backup, which
+// offsets the group descriptors of a meta block group.
+func (fsys *FS) hasSuper(g uint64) bool {
+	if g <= 1 || fsys.sb.roCompat&roCompatSparseSuper == 0 {
+		return true
+	}
+	for _, p := range []uint64{3, 5, 7} {
+		n := p
+		for n < g {
+			n *= p
+		}
+		if n == g {
+			return true
+		}
+	}
+	return false
+}
+
+// descOffset returns the byte offset of the descriptor of group g.
+func (fsys *FS) descOffset(g uint64) int64 {
+	ds := uint64(fsys.sb.descSize)
+	perBlock := uint64(fsys.blockSize) / ds
+	first := uint64(fsys.sb.firstDataBlock)
+	mg := g / perBlock
+	if fsys.sb.incompat&incompatMetaBG == 0 || mg < uint64(fsys.sb.firstMetaBG) {
+		return int64(first+1)*fsys.blockSize + int64(g*ds)
+	}
+	// Each meta block group keeps its descriptors in its first group.
+	start := mg * perBlock
+	block := first + start*uint64(fsys.sb.blocksPerGroup)
+	if fsys.hasSuper(start) {
+		block++
+	}
+	return int64(block)*fsys.blockSize + int64(g%perBlock*ds)
+}
+
+// readInode reads inode number n.
+func (fsys *FS) readInode(n uint32) (*inode, error) {
+	if n == 0 || n > fsys.sb.inodesCount {
+		return nil, fmt.Errorf("inode %d is out of range", n)
+	}
+	g := uint64((n - 1) / fsys.sb.inodesPerGroup)
+	idx := int64((n - 1) % fsys.sb.inodesPerGroup)
+
+	d := make([]byte, fsys.sb.descSize)
+	if _, err := fsys.r.ReadAt(d, fsys.descOffset(g)); err != nil {
+		return nil, fmt.Errorf("reading group descriptor %d: %w", g, err)
+	}
+	table := uint64(binary.LittleEndian.Uint32(d[8:]))
+	if fsys.sb.descSize >= 64 {
+		table |= uint64(binary.LittleEndian.Uint32(d[0x28:])) << 32
+	}
+
+	b := make([]byte, fsys.sb.inodeSize)
+	if _, err := fsys.r.ReadAt(b, int64(table)*fsys.blockSize+idx*int64(fsys.sb.inodeSize)); err != nil {
+		return nil, fmt.Errorf("reading inode %d: %w", n, err)
+	}
+	return parseInode(n, b), nil
+}
+
+// splitPath splits a slash separated path into its elements.
+func splitPath(name string) []string {
+	var elems []string
+	for _, e := range strings.Split(name, "/") {
+		if e != "" && e != "." {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// resolve returns the inode at name. Symlinks are followed in all but the
+// last element, and in the last if follow is true. Absolute link targets
+// are relative to the root of the file system.
+func (fsys *FS) resolve(op, name string, follow bool) (*inode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := fsys.readInode(rootInode)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	// stack holds the directories walked, for "..".
+	stack := []*inode{root}
+	todo := splitPath(name)
+	links := 0
+	for len(todo) > 0 {
+		e := todo[0]
+		todo = todo[1:]
+		if e == ".." {
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		dir := stack[len(stack)-1]
+		if !dir.isDir() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("not a directory")}
+		}
+		n, err := fsys.lookup(dir, e)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		in, err := fsys.readInode(n)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if in.isSymlink() && (len(todo) > 0 || follow) {
+			if links++; links > maxSymlinks {
+				return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("too many levels of symbolic links")}
+			}
+			target, err := fsys.readlink(in)
+			if err != nil {
+				return nil, &fs.PathError{Op: op, Path: name, Err: err}
+			}
+			if strings.HasPrefix(target, "/") {
+				stack = stack[:1]
+			}
+			todo = append(splitPath(target), todo...)
+			continue
+		}
+		stack = append(stack, in)
+	}
+	return stack[len(stack)-1], nil
+}
+
+// Open opens the named file, following symbolic links.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	in, err := fsys.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := fsys.fileInfo(path.Base(name), in)
+	if in.isDir() {
+		return &dir{fsys: fsys, info: info, in: in}, nil
+	}
+	f := &file{fsys: fsys, info: info, in: in}
+	if in.isRegular() {
+		if f.extents, err = fsys.extents(in); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	return f, nil
+}
+
+// Stat returns a FileInfo describing the named file, following symbolic
+// links.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	in, err := fsys.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.fileInfo(path.Base(name), in), nil
+}
+
+// Lstat returns a FileInfo describing the named file without following a
+// final symbolic link.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	in, err := fsys.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.fileInfo(path.Base(name), in), nil
+}
+
+// ReadLink returns the target of the named symbolic link.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	in, err := fsys.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if !in.isSymlink() {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	target, err := fsys.readlink(in)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by
+// name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	in, err := fsys.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !in.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	entries, err := fsys.readDir(in)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
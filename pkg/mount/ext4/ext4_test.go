@@ -0,0 +1,177 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext4
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// The images are written by testdata/gen.sh, and compressed with gzip.
+var images = []struct {
+	name  string
+	label string
+	uuid  string
+}{
+	{"ext2", "ext2", "4a3c1e5a-1f4b-4c9d-9f5e-2d6b8a7c0e11"},
+	{"ext4", "ext4", "8d1f4a2e-6b3c-4e5d-8a9f-0c1b2d3e4f50"},
+}
+
+func open(t *testing.T, name string) *FS {
+	t.Helper()
+	f, err := os.Open("testdata/" + name + ".img.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func kernel() []byte {
+	var b bytes.Buffer
+	for i := 0; i < 300*1024/8; i++ {
+		fmt.Fprintf(&b, "%07x\n", i)
+	}
+	return b.Bytes()
+}
+
+func TestFS(t *testing.T) {
+	for _, tt := range images {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := open(t, tt.name)
+			if fsys.Label() != tt.label || fsys.UUID() != tt.uuid {
+				t.Errorf("label %q UUID %q, want %q %q", fsys.Label(), fsys.UUID(), tt.label, tt.uuid)
+			}
+			if err := fstest.TestFS(fsys, "boot/grub/grub.cfg", "boot/vmlinuz-5.10.0", "loader/entries/entry-199.conf", "hole", "sparse", "empty"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	hole := append(make([]byte, 65536), "end"...)
+	var sparse []byte
+	for i := 0; i < 8; i++ {
+		sparse = append(sparse, make([]byte, i*2048-len(sparse))...)
+		sparse = append(sparse, fmt.Sprintf("block %d", i)...)
+	}
+	for _, tt := range images {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := open(t, tt.name)
+			for _, f := range []struct {
+				name string
+				want []byte
+			}{
+				{"boot/grub/grub.cfg", []byte("menuentry \"Linux\" {\n\tlinux /boot/vmlinuz\n\tinitrd /boot/initrd\n}\n")},
+				// Relative, absolute and directory symlinks.
+				{"boot/vmlinuz", kernel()},
+				{"boot/initrd", []byte("initramfs\n")},
+				{"boot/loader/entries/entry-42.conf", []byte("title Entry 42\nlinux /boot/vmlinuz\n")},
+				{"hole", hole},
+				{"sparse", sparse},
+			} {
+				got, err := fs.ReadFile(fsys, f.name)
+				if err != nil {
+					t.Errorf("ReadFile(%s): %v", f.name, err)
+					continue
+				}
+				if !bytes.Equal(got, f.want) {
+					t.Errorf("ReadFile(%s) = %d bytes %.40q, want %d bytes %.40q", f.name, len(got), got, len(f.want), f.want)
+				}
+			}
+
+			entries, err := fsys.ReadDir("loader/entries")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 200 || entries[0].Name() != "entry-0.conf" || entries[199].Name() != "entry-99.conf" {
+				t.Errorf("ReadDir returned %d entries from %s to %s, want 200 sorted", len(entries), entries[0].Name(), entries[len(entries)-1].Name())
+			}
+
+			target, err := fsys.ReadLink("boot/initrd")
+			if want := "/boot/initrd.img-5.10.0-generic-with-a-name-too-long-for-a-fast-symlink"; err != nil || target != want {
+				t.Errorf("ReadLink = %q, %v, want %q", target, err, want)
+			}
+			fi, err := fsys.Lstat("boot/vmlinuz")
+			if err != nil || fi.Mode().Type() != fs.ModeSymlink {
+				t.Errorf("Lstat = %v, %v, want a symlink", fi, err)
+			}
+			fi, err = fsys.Stat("boot/vmlinuz")
+			if err != nil || !fi.Mode().IsRegular() || fi.Size() != int64(len(kernel())) || fi.Name() != "vmlinuz" {
+				t.Errorf("Stat = %v, %v, want a regular file", fi, err)
+			}
+		})
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	fsys := open(t, "ext4")
+	f, err := fsys.Open("boot/vmlinuz-5.10.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := f.(io.ReaderAt)
+	want := kernel()
+	// Across the boundary of the two extents.
+	b := make([]byte, 100)
+	if _, err := r.ReadAt(b, 12*1024-50); err != nil || !bytes.Equal(b, want[12*1024-50:12*1024+50]) {
+		t.Errorf("ReadAt across extents = %q, %v", b, err)
+	}
+	if n, err := r.ReadAt(b, int64(len(want))-10); n != 10 || err != io.EOF {
+		t.Errorf("ReadAt at end = %d, %v, want 10, EOF", n, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fsys := open(t, "ext4")
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"nonexistent", fs.ErrNotExist},
+		{"boot/nonexistent/file", fs.ErrNotExist},
+		{"/boot", fs.ErrInvalid},
+		{"boot/../boot", fs.ErrInvalid},
+	} {
+		if _, err := fsys.Open(tt.name); !errors.Is(err, tt.err) {
+			t.Errorf("Open(%q) = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if _, err := fsys.Open("boot/grub/grub.cfg/x"); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("Open through a file = %v, want not a directory", err)
+	}
+	if _, err := fsys.ReadLink("boot/grub"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("ReadLink of a directory = %v, want %v", err, fs.ErrInvalid)
+	}
+	if _, err := fsys.readlink(&inode{size: 1 << 40, flags: flagExtents}); err == nil {
+		t.Errorf("readlink of a 1 TiB symlink succeeded")
+	}
+
+	if _, err := New(bytes.NewReader(make([]byte, 4096))); err != ErrNotExt {
+		t.Errorf("New(zeros) = %v, want %v", err, ErrNotExt)
+	}
+}
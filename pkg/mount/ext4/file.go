@@ -0,0 +1,291 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// Directory entry file types.
+var dirTypes = map[byte]fs.FileMode{
+	1: 0,
+	2: fs.ModeDir,
+	3: fs.ModeDevice | fs.ModeCharDevice,
+	4: fs.ModeDevice,
+	5: fs.ModeNamedPipe,
+	6: fs.ModeSocket,
+	7: fs.ModeSymlink,
+}
+
+type fileInfo struct {
+	name string
+	in   *inode
+}
+
+func (fsys *FS) fileInfo(name string, in *inode) *fileInfo {
+	return &fileInfo{name: name, in: in}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.in.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.in.fileMode() }
+func (fi *fileInfo) ModTime() time.Time { return fi.in.mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.in.isDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// file is an open file other than a directory.
+type file struct {
+	fsys    *FS
+	info    *fileInfo
+	in      *inode
+	extents []extent
+	off     int64
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt. Holes and uninitialized extents read as
+// zeros.
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= f.in.size {
+		return 0, io.EOF
+	}
+	var err error
+	if rest := f.in.size - off; int64(len(p)) > rest {
+		p, err = p[:rest], io.EOF
+	}
+	if f.in.flags&flagInlineData != 0 {
+		return copy(p, f.in.block[off:]), err
+	}
+
+	bs := uint64(f.fsys.blockSize)
+	for n := 0; n < len(p); {
+		pos := uint64(off) + uint64(n)
+		l := pos / bs
+		// Find the first extent ending after l.
+		i := sort.Search(len(f.extents), func(i int) bool {
+			e := f.extents[i]
+			return e.logical+e.length > l
+		})
+		var e *extent
+		if i < len(f.extents) && f.extents[i].logical <= l {
+			e = &f.extents[i]
+		}
+		chunk := uint64(len(p) - n)
+		var end uint64
+		switch {
+		case e != nil:
+			end = (e.logical + e.length) * bs
+		case i < len(f.extents):
+			// A hole up to the next extent.
+			end = f.extents[i].logical * bs
+		default:
+			end = pos + chunk
+		}
+		if end-pos < chunk {
+			chunk = end - pos
+		}
+		b := p[n : n+int(chunk)]
+		if e == nil || e.uninit {
+			for j := range b {
+				b[j] = 0
+			}
+		} else if _, rerr := f.fsys.r.ReadAt(b, int64((e.physical+l-e.logical)*bs+pos%bs)); rerr != nil {
+			return n, rerr
+		}
+		n += int(chunk)
+	}
+	return len(p), err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.in.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// dir is an open directory.
+type dir struct {
+	fsys    *FS
+	info    *fileInfo
+	in      *inode
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the entries in directory order.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.readDir(d.in)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.info.name, Err: err}
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		e := d.entries
+		d.entries = nil
+		return e, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	e := d.entries[:n]
+	d.entries = d.entries[n:]
+	return e, nil
+}
+
+type dirEntry struct {
+	fsys  *FS
+	name  string
+	num   uint32
+	typ   fs.FileMode
+	known bool
+}
+
+func (e *dirEntry) Name() string { return e.name }
+
+func (e *dirEntry) IsDir() bool { return e.Type().IsDir() }
+
+func (e *dirEntry) Type() fs.FileMode {
+	if !e.known {
+		// Without the filetype feature only the inode has the type.
+		if in, err := e.fsys.readInode(e.num); err == nil {
+			e.typ = in.fileMode().Type()
+		}
+		e.known = true
+	}
+	return e.typ
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	in, err := e.fsys.readInode(e.num)
+	if err != nil {
+		return nil, err
+	}
+	return e.fsys.fileInfo(e.name, in), nil
+}
+
+// forEntry calls fn for each entry of directory in, other than "." and
+// "..", until it returns false. Hash tree nodes look like unused entries
+// and are skipped.
+func (fsys *FS) forEntry(in *inode, fn func(name string, num uint32, typ byte) bool) error {
+	extents, err := fsys.extents(in)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, in.size)
+	if _, err := (&file{fsys: fsys, in: in, extents: extents}).ReadAt(b, 0); err != nil && err != io.EOF {
+		return err
+	}
+	// Inline directories start with the parent inode number.
+	bs := int(fsys.blockSize)
+	if in.flags&flagInlineData != 0 {
+		b, bs = b[4:], len(b)-4
+	}
+	for blk := 0; blk+bs <= len(b); blk += bs {
+		for off := 0; off+8 <= bs; {
+			e := b[blk+off : blk+bs]
+			num := binary.LittleEndian.Uint32(e[0:])
+			recLen := int(binary.LittleEndian.Uint16(e[4:]))
+			if recLen == 0 || recLen == 0xffff {
+				recLen = bs
+			}
+			nameLen := int(e[6])
+			typ := e[7]
+			if fsys.sb.incompat&incompatFiletype == 0 {
+				nameLen |= int(e[7]) << 8
+				typ = 0
+			}
+			if recLen < 8 || recLen > len(e) || 8+nameLen > recLen {
+				return fmt.Errorf("inode %d: bad directory entry at %d", in.num, blk+off)
+			}
+			name := string(e[8 : 8+nameLen])
+			if num != 0 && name != "." && name != ".." {
+				if !fn(name, num, typ) {
+					return nil
+				}
+			}
+			off += recLen
+		}
+	}
+	return nil
+}
+
+// readDir returns the entries of directory in.
+func (fsys *FS) readDir(in *inode) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	err := fsys.forEntry(in, func(name string, num uint32, typ byte) bool {
+		t, known := dirTypes[typ]
+		entries = append(entries, &dirEntry{fsys: fsys, name: name, num: num, typ: t, known: known})
+		return true
+	})
+	return entries, err
+}
+
+// lookup returns the inode number of name in directory in.
+func (fsys *FS) lookup(in *inode, name string) (uint32, error) {
+	var num uint32
+	if err := fsys.forEntry(in, func(n string, i uint32, _ byte) bool {
+		if n == name {
+			num = i
+			return false
+		}
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	if num == 0 {
+		return 0, fs.ErrNotExist
+	}
+	return num, nil
+}
@@ -0,0 +1,282 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// File types in the inode mode.
+const (
+	modeTypeMask = 0xf000
+	modeFIFO     = 0x1000
+	modeChar     = 0x2000
+	modeDir      = 0x4000
+	modeBlock    = 0x6000
+	modeRegular  = 0x8000
+	modeSymlink  = 0xa000
+	modeSocket   = 0xc000
+)
+
+// Inode flags.
+const (
+	flagExtents    = 0x80000
+	flagInlineData = 0x10000000
+)
+
+// Extent tree constants.
+const (
+	extentMagic    = 0xf30a
+	extentInitMax  = 32768
+	extentMaxDepth = 5
+)
+
+// inlineSize is the size of i_block, which holds fast symlink targets and
+// inline data.
+const inlineSize = 60
+
+type inode struct {
+	num   uint32
+	mode  uint16
+	size  int64
+	mtime time.Time
+	flags uint32
+	block [inlineSize]byte
+}
+
+func parseInode(n uint32, b []byte) *inode {
+	in := &inode{
+		num:   n,
+		mode:  binary.LittleEndian.Uint16(b[0:]),
+		size:  int64(binary.LittleEndian.Uint32(b[4:])) | int64(binary.LittleEndian.Uint32(b[108:]))<<32,
+		flags: binary.LittleEndian.Uint32(b[32:]),
+	}
+	copy(in.block[:], b[40:100])
+
+	sec := int64(int32(binary.LittleEndian.Uint32(b[16:])))
+	var nsec int64
+	// i_mtime_extra holds epoch bits and nanoseconds in large inodes.
+	if len(b) > 128 && binary.LittleEndian.Uint16(b[128:]) >= 12 {
+		extra := binary.LittleEndian.Uint32(b[136:])
+		sec += int64(extra&3) << 32
+		nsec = int64(extra >> 2)
+	}
+	in.mtime = time.Unix(sec, nsec)
+	return in
+}
+
+func (in *inode) isDir() bool {
+	return in.mode&modeTypeMask == modeDir
+}
+
+func (in *inode) isRegular() bool {
+	return in.mode&modeTypeMask == modeRegular
+}
+
+func (in *inode) isSymlink() bool {
+	return in.mode&modeTypeMask == modeSymlink
+}
+
+// fileMode converts the inode mode.
+func (in *inode) fileMode() fs.FileMode {
+	m := fs.FileMode(in.mode & 0o777)
+	if in.mode&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if in.mode&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if in.mode&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+	switch in.mode & modeTypeMask {
+	case modeDir:
+		m |= fs.ModeDir
+	case modeSymlink:
+		m |= fs.ModeSymlink
+	case modeChar:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case modeBlock:
+		m |= fs.ModeDevice
+	case modeFIFO:
+		m |= fs.ModeNamedPipe
+	case modeSocket:
+		m |= fs.ModeSocket
+	}
+	return m
+}
+
+// extent maps length blocks starting at logical block logical to
+// physical. Uninitialized extents read as zeros.
+type extent struct {
+	logical  uint64
+	physical uint64
+	length   uint64
+	uninit   bool
+}
+
+// extents returns the sorted block mapping of a file. Files with inline
+// data have none.
+func (fsys *FS) extents(in *inode) ([]extent, error) {
+	var e []extent
+	var err error
+	switch {
+	case in.flags&flagInlineData != 0:
+		if in.size > inlineSize {
+			return nil, fmt.Errorf("inline data beyond i_block is not supported")
+		}
+		return nil, nil
+	case in.flags&flagExtents != 0:
+		err = fsys.walkExtents(in.block[:], -1, &e)
+	default:
+		e, err = fsys.indirectExtents(in)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("inode %d: %w", in.num, err)
+	}
+	sort.Slice(e, func(i, j int) bool { return e[i].logical < e[j].logical })
+	return e, nil
+}
+
+// walkExtents appends the leaf extents of the extent tree node in b. depth
+// is the expected depth of the node, or -1 for the root.
+func (fsys *FS) walkExtents(b []byte, depth int, e *[]extent) error {
+	if binary.LittleEndian.Uint16(b[0:]) != extentMagic {
+		return fmt.Errorf("bad extent header magic %#x", binary.LittleEndian.Uint16(b[0:]))
+	}
+	entries := int(binary.LittleEndian.Uint16(b[2:]))
+	d := int(binary.LittleEndian.Uint16(b[6:]))
+	if (depth >= 0 && d != depth) || d > extentMaxDepth {
+		return fmt.Errorf("extent node depth %d, want %d", d, depth)
+	}
+	if 12+12*entries > len(b) {
+		return fmt.Errorf("%d extents overrun their node", entries)
+	}
+	for i := 0; i < entries; i++ {
+		x := b[12+12*i:]
+		if d == 0 {
+			l := uint64(binary.LittleEndian.Uint16(x[4:]))
+			ex := extent{
+				logical:  uint64(binary.LittleEndian.Uint32(x[0:])),
+				physical: uint64(binary.LittleEndian.Uint16(x[6:]))<<32 | uint64(binary.LittleEndian.Uint32(x[8:])),
+				length:   l,
+			}
+			if l > extentInitMax {
+				ex.length, ex.uninit = l-extentInitMax, true
+			}
+			*e = append(*e, ex)
+			continue
+		}
+		leaf := uint64(binary.LittleEndian.Uint16(x[8:]))<<32 | uint64(binary.LittleEndian.Uint32(x[4:]))
+		child, err := fsys.readBlock(leaf)
+		if err != nil {
+			return err
+		}
+		if err := fsys.walkExtents(child, d-1, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indirectMapper builds extents from an indirect block map.
+type indirectMapper struct {
+	fsys     *FS
+	perBlock uint64
+	blocks   uint64
+	next     uint64
+	e        []extent
+}
+
+// add maps the next logical block to physical block p, 0 being a hole.
+func (m *indirectMapper) add(p uint64) {
+	l := m.next
+	m.next++
+	if p == 0 {
+		return
+	}
+	if n := len(m.e); n > 0 {
+		last := &m.e[n-1]
+		if last.logical+last.length == l && last.physical+last.length == p {
+			last.length++
+			return
+		}
+	}
+	m.e = append(m.e, extent{logical: l, physical: p, length: 1})
+}
+
+// walk maps the blocks of an indirect block at level, 1 being a single
+// indirect block.
+func (m *indirectMapper) walk(p uint64, level int) error {
+	span := uint64(1)
+	for i := 0; i < level; i++ {
+		span *= m.perBlock
+	}
+	if p == 0 {
+		m.next += span
+		return nil
+	}
+	b, err := m.fsys.readBlock(p)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < m.perBlock && m.next < m.blocks; i++ {
+		c := uint64(binary.LittleEndian.Uint32(b[4*i:]))
+		if level == 1 {
+			m.add(c)
+			continue
+		}
+		if err := m.walk(c, level-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indirectExtents maps a file using 12 direct blocks followed by a single,
+// double and triple indirect block.
+func (fsys *FS) indirectExtents(in *inode) ([]extent, error) {
+	bs := uint64(fsys.blockSize)
+	m := &indirectMapper{
+		fsys:     fsys,
+		perBlock: bs / 4,
+		blocks:   (uint64(in.size) + bs - 1) / bs,
+	}
+	for i := 0; i < 15 && m.next < m.blocks; i++ {
+		p := uint64(binary.LittleEndian.Uint32(in.block[4*i:]))
+		if i < 12 {
+			m.add(p)
+			continue
+		}
+		if err := m.walk(p, i-11); err != nil {
+			return nil, err
+		}
+	}
+	return m.e, nil
+}
+
+// readlink returns the target of a symlink. Fast symlinks keep it in
+// i_block, and slow ones in a single block.
+func (fsys *FS) readlink(in *inode) (string, error) {
+	if in.size < 0 || in.size > fsys.blockSize {
+		return "", fmt.Errorf("symlink of %d bytes does not fit in a block", in.size)
+	}
+	if in.size < inlineSize && in.flags&flagExtents == 0 {
+		return string(in.block[:in.size]), nil
+	}
+	e, err := fsys.extents(in)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, in.size)
+	if _, err := (&file{fsys: fsys, in: in, extents: e}).ReadAt(b, 0); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,44 @@
+#!/bin/sh
+# Copyright 2021 the u-root Authors. All rights reserved
+# Use of this source code is governed by a BSD-style
+# license that can be found in the LICENSE file.
+
+# gen.sh writes the ext2 and ext4 test images with mke2fs, indexes their
+# large directories with e2fsck -D and compresses them with gzip.
+#
+# Run with: sh gen.sh
+set -e
+
+root=$(mktemp -d)
+trap 'rm -rf "$root"' EXIT
+
+mkdir -p "$root/boot/grub" "$root/loader/entries" "$root/empty"
+printf 'menuentry "Linux" {\n\tlinux /boot/vmlinuz\n\tinitrd /boot/initrd\n}\n' > "$root/boot/grub/grub.cfg"
+
+# The kernel is large enough for double indirect blocks on ext2.
+awk 'BEGIN { for (i = 0; i < 300 * 1024 / 8; i++) printf "%07x\n", i }' > "$root/boot/vmlinuz-5.10.0"
+echo initramfs > "$root/boot/initrd.img-5.10.0-generic-with-a-name-too-long-for-a-fast-symlink"
+ln -s vmlinuz-5.10.0 "$root/boot/vmlinuz"
+ln -s /boot/initrd.img-5.10.0-generic-with-a-name-too-long-for-a-fast-symlink "$root/boot/initrd"
+ln -s ../loader "$root/boot/loader"
+
+for i in $(seq 0 199); do
+	printf 'title Entry %d\nlinux /boot/vmlinuz\n' "$i" > "$root/loader/entries/entry-$i.conf"
+done
+
+# hole has a hole before its data.
+printf 'end' | dd of="$root/hole" bs=1 seek=65536 2>/dev/null
+
+# sparse has more extents than fit in the inode, for an extent tree of
+# depth 1 on ext4.
+for i in $(seq 0 7); do
+	printf 'block %d' "$i" | dd of="$root/sparse" bs=1 seek=$((i * 2048)) conv=notrunc 2>/dev/null
+done
+
+rm -f ext2.img ext4.img ext2.img.gz ext4.img.gz
+mke2fs -q -t ext2 -b 1024 -N 256 -L ext2 -U 4a3c1e5a-1f4b-4c9d-9f5e-2d6b8a7c0e11 -E root_owner=0:0 -d "$root" ext2.img 2M
+mke2fs -q -t ext4 -b 1024 -N 256 -O 64bit,metadata_csum,^has_journal -L ext4 -U 8d1f4a2e-6b3c-4e5d-8a9f-0c1b2d3e4f50 -E root_owner=0:0 -d "$root" ext4.img 2M
+for i in ext2.img ext4.img; do
+	e2fsck -fyD "$i" >/dev/null || [ $? -eq 1 ]
+	gzip -9 -n "$i"
+done
@@ -0,0 +1,312 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fat reads FAT12, FAT16 and FAT32 file systems without the kernel.
+//
+// FS implements io/fs.FS on an io.ReaderAt, such as an EFI system
+// partition or an image file. Long (VFAT) file names are supported, and
+// names are looked up case-insensitively like Windows and Linux do.
+//
+// If the io.ReaderAt is also an io.WriterAt, directories and files can be
+// created, and Format creates new file systems.
+package fat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Debug can be set to a logging function.
+var Debug = func(string, ...interface{}) {}
+
+// ErrNotFAT is returned by New if there is no FAT boot sector.
+var ErrNotFAT = errors.New("not a FAT file system")
+
+// Cluster counts from which FAT16 and FAT32 must be used.
+const (
+	minFAT16Clusters = 4085
+	minFAT32Clusters = 65525
+)
+
+// FS is a FAT file system.
+type FS struct {
+	r io.ReaderAt
+	w io.WriterAt
+
+	// Bits is the FAT entry size: 12, 16 or 32.
+	Bits int
+
+	label  string
+	serial uint32
+
+	sectorSize  int64
+	clusterSize int64
+	fatOff      int64
+	fatBytes    int64
+	nfats       int64
+	dataOff     int64
+	clusters    uint32
+
+	// fsInfoOff is the offset of the FAT32 FSInfo sector, or 0.
+	fsInfoOff int64
+
+	// rootOff and rootSize locate the FAT12 and FAT16 root directory.
+	// FAT32 keeps it in a cluster chain starting at rootCluster.
+	rootOff     int64
+	rootSize    int64
+	rootCluster uint32
+
+	// fatSector caches a sector of the FAT.
+	mu        sync.Mutex
+	fatSector []byte
+	fatIndex  int64
+
+	// freeHint is where to start looking for free clusters.
+	freeHint uint32
+	// changed is set once the FAT has been written.
+	changed bool
+}
+
+// New reads the file system in r.
+func New(r io.ReaderAt) (*FS, error) {
+	b := make([]byte, 512)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("reading boot sector: %w", err)
+	}
+	bps := int64(binary.LittleEndian.Uint16(b[11:]))
+	spc := int64(b[13])
+	reserved := int64(binary.LittleEndian.Uint16(b[14:]))
+	nfats := int64(b[16])
+	rootEntries := int64(binary.LittleEndian.Uint16(b[17:]))
+	sectors := int64(binary.LittleEndian.Uint16(b[19:]))
+	fatSize := int64(binary.LittleEndian.Uint16(b[22:]))
+	if sectors == 0 {
+		sectors = int64(binary.LittleEndian.Uint32(b[32:]))
+	}
+	if (b[0] != 0xeb && b[0] != 0xe9) || bps < 512 || bps > 4096 || bps&(bps-1) != 0 ||
+		spc == 0 || spc&(spc-1) != 0 || reserved == 0 || nfats == 0 {
+		return nil, ErrNotFAT
+	}
+
+	fsys := &FS{
+		r:           r,
+		sectorSize:  bps,
+		clusterSize: bps * spc,
+		fatOff:      reserved * bps,
+		nfats:       nfats,
+		fatIndex:    -1,
+		freeHint:    2,
+	}
+	if w, ok := r.(io.WriterAt); ok {
+		fsys.w = w
+	}
+	// Like Linux, take a zero FAT16 size to mean FAT32, whatever the
+	// cluster count.
+	ebpb := b[36:]
+	if fatSize == 0 {
+		fatSize = int64(binary.LittleEndian.Uint32(b[36:]))
+		fsys.rootCluster = binary.LittleEndian.Uint32(b[44:])
+		if info := int64(binary.LittleEndian.Uint16(b[48:])); info != 0 && info != 0xffff && info < reserved {
+			fsys.fsInfoOff = info * bps
+		}
+		ebpb = b[64:]
+		fsys.Bits = 32
+	}
+	fsys.fatBytes = fatSize * bps
+	rootSectors := (rootEntries*32 + bps - 1) / bps
+	fsys.rootOff = (reserved + nfats*fatSize) * bps
+	fsys.rootSize = rootEntries * 32
+	fsys.dataOff = fsys.rootOff + rootSectors*bps
+	if fatSize == 0 || fsys.dataOff/bps >= sectors {
+		return nil, ErrNotFAT
+	}
+	fsys.clusters = uint32((sectors - fsys.dataOff/bps) / spc)
+	if fsys.Bits == 0 {
+		fsys.Bits = 16
+		if fsys.clusters < minFAT16Clusters {
+			fsys.Bits = 12
+		}
+	}
+	if fsys.Bits == 32 && fsys.clusters >= minFAT32Clusters && rootEntries != 0 {
+		return nil, fmt.Errorf("FAT32 with %d fixed root directory entries", rootEntries)
+	}
+	if fatEntries := fatSize * bps * 8 / int64(fsys.Bits); fatEntries < int64(fsys.clusters)+2 {
+		return nil, fmt.Errorf("FAT of %d entries for %d clusters", fatEntries, fsys.clusters)
+	}
+
+	// The extended BPB has the serial and label if its signature is
+	// present.
+	if ebpb[2] == 0x28 || ebpb[2] == 0x29 {
+		fsys.serial = binary.LittleEndian.Uint32(ebpb[3:])
+	}
+	if ebpb[2] == 0x29 {
+		if l := strings.TrimRight(string(ebpb[7:18]), " "); l != "NO NAME" {
+			fsys.label = l
+		}
+	}
+	Debug("fat: FAT%d, %d clusters of %d bytes", fsys.Bits, fsys.clusters, fsys.clusterSize)
+	return fsys, nil
+}
+
+// Label returns the volume label of the boot sector.
+func (fsys *FS) Label() string {
+	return fsys.label
+}
+
+// UUID returns the volume serial number formatted like blkid does.
+func (fsys *FS) UUID() string {
+	return fmt.Sprintf("%04X-%04X", fsys.serial>>16, fsys.serial&0xffff)
+}
+
+// next returns the FAT entry of cluster c.
+func (fsys *FS) next(c uint32) (uint32, error) {
+	off := int64(c) * int64(fsys.Bits) / 8
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	// FAT12 entries may straddle sectors, so read two at a time.
+	idx := off / fsys.sectorSize
+	if idx != fsys.fatIndex {
+		b := make([]byte, 2*fsys.sectorSize)
+		n, err := fsys.r.ReadAt(b, fsys.fatOff+idx*fsys.sectorSize)
+		if n < len(b)/2+2 && err != nil {
+			return 0, fmt.Errorf("reading FAT: %w", err)
+		}
+		fsys.fatSector, fsys.fatIndex = b, idx
+	}
+	b := fsys.fatSector[off-idx*fsys.sectorSize:]
+	switch fsys.Bits {
+	case 12:
+		v := uint32(binary.LittleEndian.Uint16(b))
+		if c&1 != 0 {
+			return v >> 4, nil
+		}
+		return v & 0xfff, nil
+	case 16:
+		return uint32(binary.LittleEndian.Uint16(b)), nil
+	}
+	return binary.LittleEndian.Uint32(b) & 0x0fffffff, nil
+}
+
+// chain returns the clusters of the chain starting at c.
+func (fsys *FS) chain(c uint32) ([]uint32, error) {
+	eoc := uint32(1)<<fsys.Bits - 8
+	if fsys.Bits == 32 {
+		eoc = 0x0ffffff8
+	}
+	var chain []uint32
+	for c != 0 && c < eoc {
+		if c < 2 || c >= fsys.clusters+2 {
+			return nil, fmt.Errorf("cluster %d is out of range", c)
+		}
+		if len(chain) > int(fsys.clusters) {
+			return nil, fmt.Errorf("cluster chain loops")
+		}
+		chain = append(chain, c)
+		var err error
+		if c, err = fsys.next(c); err != nil {
+			return nil, err
+		}
+	}
+	return chain, nil
+}
+
+func (fsys *FS) clusterOff(c uint32) int64 {
+	return fsys.dataOff + int64(c-2)*fsys.clusterSize
+}
+
+// root returns the root directory entry.
+func (fsys *FS) root() *entry {
+	return &entry{name: ".", attr: attrDir, cluster: fsys.rootCluster, root: true}
+}
+
+// resolve returns the entry at name.
+func (fsys *FS) resolve(op, name string) (*entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e := fsys.root()
+	if name == "." {
+		return e, nil
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if !e.isDir() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("not a directory")}
+		}
+		entries, err := fsys.readDir(e)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		var found *entry
+		for _, c := range entries {
+			if strings.EqualFold(c.name, elem) || strings.EqualFold(c.shortName, elem) {
+				found = c
+				break
+			}
+		}
+		if found == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		e = found
+	}
+	return e, nil
+}
+
+// Open opens the named file. Names are case-insensitive and may also be
+// the 8.3 short names.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	e, err := fsys.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := &fileInfo{name: path.Base(name), e: e}
+	if e.isDir() {
+		return &dir{fsys: fsys, info: info, e: e}, nil
+	}
+	chain, err := fsys.chain(e.cluster)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if int64(len(chain))*fsys.clusterSize < e.size {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("%d clusters for %d bytes", len(chain), e.size)}
+	}
+	return &file{fsys: fsys, info: info, chain: chain}, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := fsys.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), e: e}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by
+// name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := fsys.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	entries, err := fsys.readDir(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	d := make([]fs.DirEntry, len(entries))
+	for i, e := range entries {
+		d[i] = &dirEntry{e}
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i].Name() < d[j].Name() })
+	return d, nil
+}
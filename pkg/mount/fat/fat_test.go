@@ -0,0 +1,180 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The images are written by testdata/gen.go, and compressed with gzip.
+var images = []struct {
+	name string
+	bits int
+	uuid string
+}{
+	{"fat12", 12, "1234-ABD9"},
+	{"fat16", 16, "1234-ABDD"},
+	{"fat32", 32, "1234-ABED"},
+}
+
+func open(t *testing.T, name string) *FS {
+	t.Helper()
+	f, err := os.Open("testdata/" + name + ".img.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func pattern(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, "%07x\n", i)
+	}
+	return b.Bytes()[:n]
+}
+
+func TestFS(t *testing.T) {
+	for _, tt := range images {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := open(t, tt.name)
+			if fsys.Bits != tt.bits {
+				t.Errorf("Bits = %d, want %d", fsys.Bits, tt.bits)
+			}
+			if want := fmt.Sprintf("FAT%d", tt.bits); fsys.Label() != want || fsys.UUID() != tt.uuid {
+				t.Errorf("label %q UUID %q, want %q %q", fsys.Label(), fsys.UUID(), want, tt.uuid)
+			}
+			if err := fstest.TestFS(fsys, "EFI/BOOT/BOOTX64.EFI", "loader/loader.conf", "loader/entries/linux-5.10.19.conf",
+				"README.TXT", "readme.md", "Ünïcode name.txt", "vmlinuz", "EMPTY", "RO.TXT"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, tt := range images {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := open(t, tt.name)
+			for _, f := range []struct {
+				name string
+				want []byte
+			}{
+				// Fragmented across every other cluster.
+				{"EFI/BOOT/BOOTX64.EFI", pattern(5000)},
+				{"vmlinuz", pattern(3000)},
+				// Case-insensitive and 8.3 lookups.
+				{"efi/boot/bootx64.efi", pattern(5000)},
+				{"LOADER/LOADER~1.CON", []byte("default linux-5.10.19.conf\ntimeout 3\n")},
+				{"loader/entries/LINUX-5.10.7.CONF", []byte("title Linux 5.10.7\nlinux /vmlinuz\noptions console=ttyS0\n")},
+				{"ÜNÏCODE NAME.TXT", []byte("unicode\n")},
+				{"EMPTY", nil},
+			} {
+				got, err := fs.ReadFile(fsys, f.name)
+				if err != nil {
+					t.Errorf("ReadFile(%s): %v", f.name, err)
+					continue
+				}
+				if !bytes.Equal(got, f.want) {
+					t.Errorf("ReadFile(%s) = %d bytes %.40q, want %d bytes %.40q", f.name, len(got), got, len(f.want), f.want)
+				}
+			}
+
+			entries, err := fsys.ReadDir(".")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			if got, want := fmt.Sprint(names), "[EFI EMPTY README.TXT RO.TXT loader readme.md vmlinuz Ünïcode name.txt]"; got != want {
+				t.Errorf("ReadDir(.) = %s, want %s", got, want)
+			}
+			entries, err = fsys.ReadDir("loader/entries")
+			if err != nil || len(entries) != 20 {
+				t.Errorf("ReadDir(loader/entries) = %d entries, %v, want 20", len(entries), err)
+			}
+
+			fi, err := fsys.Stat("RO.TXT")
+			if err != nil || fi.Mode() != 0o444 || fi.Size() != 10 {
+				t.Errorf("Stat(RO.TXT) = %v, %v, want a 10 byte read-only file", fi, err)
+			}
+			if want := time.Date(2021, 6, 1, 12, 34, 56, 0, time.UTC); err == nil && !fi.ModTime().Equal(want) {
+				t.Errorf("ModTime = %v, want %v", fi.ModTime(), want)
+			}
+			fi, err = fsys.Stat("loader")
+			if err != nil || !fi.IsDir() || fi.Name() != "loader" {
+				t.Errorf("Stat(loader) = %v, %v, want a directory", fi, err)
+			}
+		})
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	fsys := open(t, "fat16")
+	f, err := fsys.Open("EFI/BOOT/BOOTX64.EFI")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := f.(io.ReaderAt)
+	want := pattern(5000)
+	b := make([]byte, 1100)
+	if _, err := r.ReadAt(b, 500); err != nil || !bytes.Equal(b, want[500:1600]) {
+		t.Errorf("ReadAt across clusters = %.40q, %v", b, err)
+	}
+	if n, err := r.ReadAt(b, 4990); n != 10 || err != io.EOF {
+		t.Errorf("ReadAt at end = %d, %v, want 10, EOF", n, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fsys := open(t, "fat32")
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"nonexistent", fs.ErrNotExist},
+		{"old file.txt", fs.ErrNotExist},
+		{"FAT32", fs.ErrNotExist},
+		{"EFI/nonexistent/file", fs.ErrNotExist},
+		{"/EFI", fs.ErrInvalid},
+		{"EFI/../EFI", fs.ErrInvalid},
+	} {
+		if _, err := fsys.Open(tt.name); !errors.Is(err, tt.err) {
+			t.Errorf("Open(%q) = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if _, err := fsys.ReadDir("README.TXT"); err == nil {
+		t.Errorf("ReadDir of a file succeeded")
+	}
+
+	if _, err := New(bytes.NewReader(make([]byte, 4096))); err != ErrNotFAT {
+		t.Errorf("New(zeros) = %v, want %v", err, ErrNotFAT)
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Directory entry attributes.
+const (
+	attrReadOnly = 0x01
+	attrVolumeID = 0x08
+	attrDir      = 0x10
+	attrLongName = 0x0f
+)
+
+// Case flags of 8.3 names, as written by Windows NT and Linux.
+const (
+	lowerBase = 0x08
+	lowerExt  = 0x10
+)
+
+const dirEntrySize = 32
+
+// entry is a parsed directory entry.
+type entry struct {
+	name      string
+	shortName string
+	short     [11]byte
+	attr      byte
+	cluster   uint32
+	size      int64
+	mtime     time.Time
+	root      bool
+
+	// off is the device offset of the 8.3 entry.
+	off int64
+}
+
+func (e *entry) isDir() bool {
+	return e.attr&attrDir != 0
+}
+
+func (e *entry) fileMode() fs.FileMode {
+	m := fs.FileMode(0o644)
+	if e.isDir() {
+		m = fs.ModeDir | 0o755
+	}
+	if e.attr&attrReadOnly != 0 {
+		m &^= 0o222
+	}
+	return m
+}
+
+type fileInfo struct {
+	name string
+	e    *entry
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.e.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.e.fileMode() }
+func (fi *fileInfo) ModTime() time.Time { return fi.e.mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.e.isDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// file is an open regular file.
+type file struct {
+	fsys  *FS
+	info  *fileInfo
+	chain []uint32
+	off   int64
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	size := f.info.e.size
+	if off >= size {
+		return 0, io.EOF
+	}
+	var err error
+	if rest := size - off; int64(len(p)) > rest {
+		p, err = p[:rest], io.EOF
+	}
+	cs := f.fsys.clusterSize
+	for n := 0; n < len(p); {
+		pos := off + int64(n)
+		i := pos / cs
+		// Read contiguous clusters at once.
+		j := i + 1
+		for j < int64(len(f.chain)) && f.chain[j] == f.chain[j-1]+1 && (j-i)*cs < int64(len(p)-n)+pos%cs {
+			j++
+		}
+		chunk := (j-i)*cs - pos%cs
+		if rest := int64(len(p) - n); chunk > rest {
+			chunk = rest
+		}
+		if _, rerr := f.fsys.r.ReadAt(p[n:n+int(chunk)], f.fsys.clusterOff(f.chain[i])+pos%cs); rerr != nil {
+			return n, rerr
+		}
+		n += int(chunk)
+	}
+	return len(p), err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.info.e.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// dir is an open directory.
+type dir struct {
+	fsys    *FS
+	info    *fileInfo
+	e       *entry
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the entries in directory order.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.readDir(d.e)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.info.name, Err: err}
+		}
+		for _, e := range entries {
+			d.entries = append(d.entries, &dirEntry{e})
+		}
+		d.read = true
+	}
+	if n <= 0 {
+		e := d.entries
+		d.entries = nil
+		return e, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	e := d.entries[:n]
+	d.entries = d.entries[n:]
+	return e, nil
+}
+
+type dirEntry struct {
+	e *entry
+}
+
+func (d *dirEntry) Name() string               { return d.e.name }
+func (d *dirEntry) IsDir() bool                { return d.e.isDir() }
+func (d *dirEntry) Type() fs.FileMode          { return d.e.fileMode().Type() }
+func (d *dirEntry) Info() (fs.FileInfo, error) { return &fileInfo{name: d.e.name, e: d.e}, nil }
+
+// dirData is the contents of a directory and where they are.
+type dirData struct {
+	b []byte
+	// chain is nil for the FAT12 and FAT16 root directory.
+	chain []uint32
+}
+
+// off returns the device offset of byte i of d.
+func (fsys *FS) off(d *dirData, i int) int64 {
+	if d.chain == nil {
+		return fsys.rootOff + int64(i)
+	}
+	return fsys.clusterOff(d.chain[int64(i)/fsys.clusterSize]) + int64(i)%fsys.clusterSize
+}
+
+// readDirData returns the raw entries of directory e.
+func (fsys *FS) readDirData(e *entry) (*dirData, error) {
+	if e.root && fsys.Bits != 32 {
+		b := make([]byte, fsys.rootSize)
+		if _, err := fsys.r.ReadAt(b, fsys.rootOff); err != nil {
+			return nil, fmt.Errorf("reading root directory: %w", err)
+		}
+		return &dirData{b: b}, nil
+	}
+	chain, err := fsys.chain(e.cluster)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, int64(len(chain))*fsys.clusterSize)
+	for i, c := range chain {
+		if _, err := fsys.r.ReadAt(b[int64(i)*fsys.clusterSize:int64(i+1)*fsys.clusterSize], fsys.clusterOff(c)); err != nil {
+			return nil, fmt.Errorf("reading directory cluster %d: %w", c, err)
+		}
+	}
+	return &dirData{b: b, chain: chain}, nil
+}
+
+// checksum returns the checksum of an 8.3 name kept in long name entries.
+func checksum(name []byte) byte {
+	var sum byte
+	for _, c := range name[:11] {
+		sum = (sum>>1 | sum<<7) + c
+	}
+	return sum
+}
+
+// shortName returns the 8.3 name of an entry, lowercased as its case flags
+// say.
+func shortName(b []byte) string {
+	base := strings.TrimRight(string(b[0:8]), " ")
+	if base != "" && base[0] == 0x05 {
+		base = "\xe5" + base[1:]
+	}
+	ext := strings.TrimRight(string(b[8:11]), " ")
+	if b[12]&lowerBase != 0 {
+		base = strings.ToLower(base)
+	}
+	if b[12]&lowerExt != 0 {
+		ext = strings.ToLower(ext)
+	}
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+// fatTime converts a FAT date and time, which has no time zone and is
+// taken to be UTC.
+func fatTime(d, t uint16) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Date(1980+int(d>>9), time.Month(d>>5&0xf), int(d&0x1f),
+		int(t>>11), int(t>>5&0x3f), int(t&0x1f)*2, 0, time.UTC)
+}
+
+// readDir returns the entries of directory e other than "." and "..".
+// Volume labels and deleted entries are skipped.
+func (fsys *FS) readDir(e *entry) ([]*entry, error) {
+	d, err := fsys.readDirData(e)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.parseDir(d), nil
+}
+
+// parseDir parses the entries of a directory.
+func (fsys *FS) parseDir(dd *dirData) []*entry {
+	b := dd.b
+	var (
+		entries []*entry
+		lfn     []uint16
+		lfnNext byte
+		lfnSum  byte
+	)
+	for off := 0; off+dirEntrySize <= len(b); off += dirEntrySize {
+		d := b[off : off+dirEntrySize]
+		if d[0] == 0 {
+			break
+		}
+		if d[0] == 0xe5 {
+			lfn = nil
+			continue
+		}
+		attr := d[11]
+		if attr&attrLongName == attrLongName {
+			// Long name entries come last piece first, numbered down
+			// to 1.
+			ord := d[0] & 0x1f
+			switch {
+			case d[0]&0x40 != 0 && ord > 0:
+				lfn = make([]uint16, int(ord)*13)
+				lfnSum = d[13]
+			case lfn == nil || ord != lfnNext || d[13] != lfnSum:
+				lfn = nil
+				continue
+			}
+			lfnNext = ord - 1
+			p := lfn[int(ord-1)*13:]
+			for i, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				p[i] = binary.LittleEndian.Uint16(d[o:])
+			}
+			continue
+		}
+		name := shortName(d)
+		long := lfn
+		lfn = nil
+		if attr&attrVolumeID != 0 || name == "." || name == ".." {
+			continue
+		}
+		c := &entry{
+			name:      name,
+			shortName: name,
+			attr:      attr,
+			cluster:   uint32(binary.LittleEndian.Uint16(d[26:])),
+			size:      int64(binary.LittleEndian.Uint32(d[28:])),
+			mtime:     fatTime(binary.LittleEndian.Uint16(d[24:]), binary.LittleEndian.Uint16(d[22:])),
+			off:       fsys.off(dd, off),
+		}
+		copy(c.short[:], d[:11])
+		if fsys.Bits == 32 {
+			c.cluster |= uint32(binary.LittleEndian.Uint16(d[20:])) << 16
+		}
+		if c.isDir() {
+			c.size = 0
+		}
+		if long != nil && lfnNext == 0 && lfnSum == checksum(d) {
+			for i, u := range long {
+				if u == 0 {
+					long = long[:i]
+					break
+				}
+			}
+			c.name = string(utf16.Decode(long))
+		}
+		entries = append(entries, c)
+	}
+	return entries
+}
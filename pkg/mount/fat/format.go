@@ -0,0 +1,311 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Largest cluster counts of each FAT type.
+const (
+	maxFAT12Clusters = minFAT16Clusters - 1
+	maxFAT16Clusters = minFAT32Clusters - 1
+	maxFAT32Clusters = 0x0ffffff5 - 2
+)
+
+// ReadWriterAt is a device or image file that can be formatted.
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// FormatOptions configure Format. The zero value chooses everything by
+// size, like mkfs.fat.
+type FormatOptions struct {
+	// Bits is the FAT type: 12, 16 or 32, or 0 to choose by size.
+	Bits int
+
+	// Label is the volume label of up to 11 characters.
+	Label string
+
+	// ClusterSize is the cluster size in bytes, or 0 to choose by size.
+	ClusterSize int
+
+	// SectorSize is the logical sector size, or 0 for 512.
+	SectorSize int
+
+	// Serial is the volume serial number, or 0 to derive one from the
+	// time.
+	Serial uint32
+
+	// HiddenSectors is the offset of the partition on its disk in
+	// sectors, which some boot code needs.
+	HiddenSectors uint32
+}
+
+// geometry is the layout of a new file system.
+type geometry struct {
+	bits        int
+	bps         int64
+	spc         int64
+	reserved    int64
+	nfats       int64
+	rootEntries int64
+	sectors     int64
+	fatSize     int64
+	clusters    int64
+}
+
+// fit sizes the FAT for the clusters that remain beside it.
+func (g *geometry) fit() {
+	rootSectors := (g.rootEntries*32 + g.bps - 1) / g.bps
+	g.fatSize = 1
+	for {
+		g.clusters = (g.sectors - g.reserved - g.nfats*g.fatSize - rootSectors) / g.spc
+		if g.clusters < 0 {
+			g.clusters = 0
+		}
+		need := ((g.clusters+2)*int64(g.bits)/8 + 1 + g.bps - 1) / g.bps
+		if need <= g.fatSize {
+			return
+		}
+		g.fatSize = need
+	}
+}
+
+func (g *geometry) maxClusters() int64 {
+	switch g.bits {
+	case 12:
+		return maxFAT12Clusters
+	case 16:
+		return maxFAT16Clusters
+	}
+	return maxFAT32Clusters
+}
+
+// layout chooses the geometry of a file system of size bytes.
+func layout(size int64, opts *FormatOptions) (*geometry, error) {
+	g := &geometry{bits: opts.Bits, bps: int64(opts.SectorSize), nfats: 2}
+	if g.bps == 0 {
+		g.bps = 512
+	}
+	if g.bps < 512 || g.bps > 4096 || g.bps&(g.bps-1) != 0 {
+		return nil, fmt.Errorf("invalid sector size %d", g.bps)
+	}
+	g.sectors = size / g.bps
+	if g.sectors > 0xffffffff {
+		return nil, fmt.Errorf("%d sectors are too many for FAT", g.sectors)
+	}
+	if g.bits == 0 {
+		switch {
+		case size >= 512<<20:
+			g.bits = 32
+		case size <= maxFAT12Clusters*4096:
+			g.bits = 12
+		default:
+			g.bits = 16
+		}
+	}
+	switch g.bits {
+	case 12, 16:
+		g.reserved, g.rootEntries = 1, 512
+	case 32:
+		g.reserved = 32
+	default:
+		return nil, fmt.Errorf("invalid FAT type %d", g.bits)
+	}
+
+	cs := int64(opts.ClusterSize)
+	if cs == 0 && g.bits == 32 {
+		// The cluster sizes Windows uses.
+		switch {
+		case size <= 260<<20:
+			cs = 512
+		case size <= 8<<30:
+			cs = 4096
+		case size <= 16<<30:
+			cs = 8192
+		case size <= 32<<30:
+			cs = 16384
+		default:
+			cs = 32768
+		}
+		if cs < g.bps {
+			cs = g.bps
+		}
+	}
+	if cs != 0 {
+		if cs < g.bps || cs > 64<<10 || cs&(cs-1) != 0 {
+			return nil, fmt.Errorf("invalid cluster size %d", cs)
+		}
+		g.spc = cs / g.bps
+		g.fit()
+	} else {
+		// Use the smallest clusters that are few enough.
+		for g.spc = 1; g.spc <= 128 && g.spc*g.bps <= 64<<10; g.spc *= 2 {
+			if g.fit(); g.clusters <= g.maxClusters() {
+				break
+			}
+		}
+	}
+
+	switch {
+	case g.clusters < 1:
+		return nil, fmt.Errorf("%d bytes are too small for FAT%d", size, g.bits)
+	case g.clusters > g.maxClusters():
+		return nil, fmt.Errorf("%d clusters are too many for FAT%d; use larger clusters", g.clusters, g.bits)
+	case g.bits == 16 && g.clusters < minFAT16Clusters:
+		// It would be taken for FAT12.
+		return nil, fmt.Errorf("%d clusters are too few for FAT16; use smaller clusters or FAT12", g.clusters)
+	}
+	return g, nil
+}
+
+// span is data to write at an offset.
+type span struct {
+	b   []byte
+	off int64
+}
+
+// labelBytes returns the 11 byte volume label.
+func labelBytes(label string) ([11]byte, error) {
+	var b [11]byte
+	copy(b[:], "NO NAME    ")
+	if label == "" {
+		return b, nil
+	}
+	label = strings.ToUpper(label)
+	if len(label) > len(b) {
+		return b, fmt.Errorf("label %q is longer than %d characters", label, len(b))
+	}
+	for _, r := range label {
+		if r < 0x20 || r >= 0x80 || strings.ContainsRune(`"*+,./:;<=>?[\]|`, r) {
+			return b, fmt.Errorf("label %q has invalid character %q", label, r)
+		}
+	}
+	copy(b[:], "           ")
+	copy(b[:], label)
+	return b, nil
+}
+
+// Format creates a file system of size bytes on dev and returns it. Only
+// the boot sector, FATs and root directory are written.
+func Format(dev ReadWriterAt, size int64, opts *FormatOptions) (*FS, error) {
+	if opts == nil {
+		opts = &FormatOptions{}
+	}
+	g, err := layout(size, opts)
+	if err != nil {
+		return nil, err
+	}
+	label, err := labelBytes(opts.Label)
+	if err != nil {
+		return nil, err
+	}
+	serial := opts.Serial
+	if serial == 0 {
+		serial = uint32(now().Unix())
+	}
+	Debug("fat: formatting FAT%d, %d clusters of %d bytes", g.bits, g.clusters, g.spc*g.bps)
+
+	// Everything up to the data area and the FAT32 root directory
+	// cluster starts out as zeros.
+	rootSectors := (g.rootEntries*32 + g.bps - 1) / g.bps
+	dataOff := (g.reserved + g.nfats*g.fatSize + rootSectors) * g.bps
+	meta := dataOff
+	if g.bits == 32 {
+		meta += g.spc * g.bps
+	}
+	zeros := make([]byte, 1<<20)
+	for off := int64(0); off < meta; off += int64(len(zeros)) {
+		n := int64(len(zeros))
+		if meta-off < n {
+			n = meta - off
+		}
+		if _, err := dev.WriteAt(zeros[:n], off); err != nil {
+			return nil, err
+		}
+	}
+
+	b := make([]byte, g.bps)
+	copy(b, []byte{0xeb, 0x3c, 0x90})
+	copy(b[3:], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(b[11:], uint16(g.bps))
+	b[13] = byte(g.spc)
+	binary.LittleEndian.PutUint16(b[14:], uint16(g.reserved))
+	b[16] = byte(g.nfats)
+	binary.LittleEndian.PutUint16(b[17:], uint16(g.rootEntries))
+	if g.sectors < 0x10000 && g.bits != 32 {
+		binary.LittleEndian.PutUint16(b[19:], uint16(g.sectors))
+	} else {
+		binary.LittleEndian.PutUint32(b[32:], uint32(g.sectors))
+	}
+	b[21] = 0xf8
+	binary.LittleEndian.PutUint16(b[24:], 63)
+	binary.LittleEndian.PutUint16(b[26:], 255)
+	binary.LittleEndian.PutUint32(b[28:], opts.HiddenSectors)
+	ebpb := b[36:]
+	if g.bits == 32 {
+		b[1] = 0x58
+		binary.LittleEndian.PutUint32(b[36:], uint32(g.fatSize))
+		binary.LittleEndian.PutUint32(b[44:], 2)
+		binary.LittleEndian.PutUint16(b[48:], 1)
+		binary.LittleEndian.PutUint16(b[50:], 6)
+		ebpb = b[64:]
+	} else {
+		binary.LittleEndian.PutUint16(b[22:], uint16(g.fatSize))
+	}
+	ebpb[0] = 0x80
+	ebpb[2] = 0x29
+	binary.LittleEndian.PutUint32(ebpb[3:], serial)
+	copy(ebpb[7:], label[:])
+	copy(ebpb[18:], fmt.Sprintf("FAT%-5d", g.bits))
+	// The boot code asks the BIOS to try the next device: int 0x18,
+	// then hangs.
+	copy(ebpb[26:], []byte{0xcd, 0x18, 0xeb, 0xfe})
+	b[510], b[511] = 0x55, 0xaa
+
+	writes := []span{{b, 0}}
+	if g.bits == 32 {
+		info := make([]byte, g.bps)
+		binary.LittleEndian.PutUint32(info[0:], 0x41615252)
+		binary.LittleEndian.PutUint32(info[484:], 0x61417272)
+		// The root directory has the first cluster.
+		binary.LittleEndian.PutUint32(info[488:], uint32(g.clusters-1))
+		binary.LittleEndian.PutUint32(info[492:], 3)
+		binary.LittleEndian.PutUint32(info[508:], 0xaa550000)
+		// Backups of both follow at sector 6.
+		writes = append(writes, span{info, g.bps}, span{b, 6 * g.bps}, span{info, 7 * g.bps})
+	}
+
+	// The first two FAT entries hold the media type and an end of chain
+	// marker, and FAT32 has the root directory in cluster 2.
+	var fat []byte
+	switch g.bits {
+	case 12:
+		fat = []byte{0xf8, 0xff, 0xff}
+	case 16:
+		fat = []byte{0xf8, 0xff, 0xff, 0xff}
+	case 32:
+		fat = []byte{0xf8, 0xff, 0xff, 0x0f, 0xff, 0xff, 0xff, 0x0f, 0xff, 0xff, 0xff, 0x0f}
+	}
+	for i := int64(0); i < g.nfats; i++ {
+		writes = append(writes, span{fat, (g.reserved + i*g.fatSize) * g.bps})
+	}
+	if opts.Label != "" {
+		var short [11]byte
+		copy(short[:], label[:])
+		writes = append(writes, span{newDirent(short, 0, attrVolumeID, 0, 0, now()), (g.reserved + g.nfats*g.fatSize) * g.bps})
+	}
+	for _, w := range writes {
+		if _, err := dev.WriteAt(w.b, w.off); err != nil {
+			return nil, err
+		}
+	}
+	return New(dev)
+}
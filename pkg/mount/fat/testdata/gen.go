@@ -0,0 +1,336 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build ignore
+// +build ignore
+
+// gen writes the FAT test images, compressed with gzip. They are built by
+// hand rather than with mkfs.fat and mtools so that they have long names,
+// lowercase 8.3 names, deleted entries, fragmented files and directories
+// spanning clusters.
+//
+// Run with: go run gen.go
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"unicode/utf16"
+)
+
+const sectorSize = 512
+
+// All entries have this time stamp, 2021-06-01 12:34:56.
+const (
+	fatDate = (2021-1980)<<9 | 6<<5 | 1
+	fatTime = 12<<11 | 34<<5 | 56/2
+)
+
+type node struct {
+	short    string // the 11 byte 8.3 name
+	long     string
+	lower    byte
+	attr     byte
+	data     []byte
+	children []*node
+	deleted  bool
+	// stride spaces out the clusters of the file.
+	stride uint32
+
+	cluster uint32
+}
+
+type image struct {
+	b        []byte
+	bits     int
+	fatOff   int
+	fatSize  int
+	rootOff  int
+	rootSize int
+	dataOff  int
+	clusters uint32
+	next     uint32
+}
+
+func pattern(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, "%07x\n", i)
+	}
+	return b.Bytes()[:n]
+}
+
+func tree() []*node {
+	var entries []*node
+	for i := 0; i < 20; i++ {
+		entries = append(entries, &node{
+			short: fmt.Sprintf("LINUX~%-2dCON", i+1),
+			long:  fmt.Sprintf("linux-5.10.%d.conf", i),
+			data:  []byte(fmt.Sprintf("title Linux 5.10.%d\nlinux /vmlinuz\noptions console=ttyS0\n", i)),
+		})
+	}
+	return []*node{
+		{short: "EFI        ", attr: 0x10, children: []*node{
+			{short: "BOOT       ", attr: 0x10, children: []*node{
+				{short: "BOOTX64 EFI", data: pattern(5000), stride: 2},
+			}},
+		}},
+		{short: "LOADER     ", lower: 0x08, attr: 0x10, children: []*node{
+			{short: "LOADER~1CON", long: "loader.conf", data: []byte("default linux-5.10.19.conf\ntimeout 3\n")},
+			{short: "ENTRIES    ", lower: 0x08, attr: 0x10, children: entries},
+		}},
+		{short: "OLD     TXT", long: "old file.txt", data: []byte("deleted\n"), deleted: true},
+		{short: "README  TXT", data: []byte("README\n")},
+		{short: "README  MD ", lower: 0x18, data: []byte("# readme\n")},
+		{short: "NICODE~1TXT", long: "Ünïcode name.txt", data: []byte("unicode\n")},
+		{short: "VMLINUZ    ", lower: 0x08, data: pattern(3000)},
+		{short: "EMPTY      "},
+		{short: "RO      TXT", attr: 0x01, data: []byte("read only\n")},
+	}
+}
+
+func newImage(bits, sectors, rootEntries int) *image {
+	img := &image{b: make([]byte, sectors*sectorSize), bits: bits, next: 2}
+	reserved := 1
+	if bits == 32 {
+		reserved = 32
+	}
+	img.fatOff = reserved * sectorSize
+	img.rootSize = rootEntries * 32
+	// Size the FAT for every sector, which is a little too big.
+	img.fatSize = ((sectors+2)*bits/8 + sectorSize - 1) / sectorSize
+	img.rootOff = img.fatOff + 2*img.fatSize*sectorSize
+	img.dataOff = img.rootOff + img.rootSize
+	img.clusters = uint32(sectors - img.dataOff/sectorSize)
+
+	b := img.b
+	copy(b, []byte{0xeb, 0x3c, 0x90})
+	copy(b[3:], "mkfs.fat")
+	binary.LittleEndian.PutUint16(b[11:], sectorSize)
+	b[13] = 1
+	binary.LittleEndian.PutUint16(b[14:], uint16(reserved))
+	b[16] = 2
+	binary.LittleEndian.PutUint16(b[17:], uint16(rootEntries))
+	binary.LittleEndian.PutUint16(b[19:], uint16(sectors))
+	b[21] = 0xf8
+	binary.LittleEndian.PutUint16(b[24:], 32)
+	binary.LittleEndian.PutUint16(b[26:], 64)
+	ebpb := b[36:]
+	if bits == 32 {
+		b[0] = 0xeb
+		b[1] = 0x58
+		binary.LittleEndian.PutUint32(b[36:], uint32(img.fatSize))
+		binary.LittleEndian.PutUint32(b[44:], 2)
+		binary.LittleEndian.PutUint16(b[48:], 1)
+		binary.LittleEndian.PutUint16(b[50:], 6)
+		ebpb = b[64:]
+	} else {
+		binary.LittleEndian.PutUint16(b[22:], uint16(img.fatSize))
+	}
+	ebpb[0] = 0x80
+	ebpb[2] = 0x29
+	binary.LittleEndian.PutUint32(ebpb[3:], 0x1234abcd+uint32(bits))
+	copy(ebpb[7:], fmt.Sprintf("FAT%-8d", bits))
+	copy(ebpb[18:], fmt.Sprintf("FAT%-5d", bits))
+	b[510], b[511] = 0x55, 0xaa
+	if bits == 32 {
+		fsinfo := b[sectorSize:]
+		copy(fsinfo, "RRaA")
+		copy(fsinfo[484:], "rrAa")
+		binary.LittleEndian.PutUint32(fsinfo[488:], 0xffffffff)
+		binary.LittleEndian.PutUint32(fsinfo[492:], 0xffffffff)
+		fsinfo[510], fsinfo[511] = 0x55, 0xaa
+		copy(b[6*sectorSize:], b[:sectorSize])
+	}
+
+	img.set(0, 0x0ffffff8)
+	img.set(1, 0x0fffffff)
+	return img
+}
+
+// set writes FAT entry c in both FATs.
+func (img *image) set(c, v uint32) {
+	for i := 0; i < 2; i++ {
+		fat := img.b[img.fatOff+i*img.fatSize*sectorSize:]
+		switch img.bits {
+		case 12:
+			off := c * 3 / 2
+			old := binary.LittleEndian.Uint16(fat[off:])
+			if c&1 != 0 {
+				binary.LittleEndian.PutUint16(fat[off:], old&0x000f|uint16(v&0xfff)<<4)
+			} else {
+				binary.LittleEndian.PutUint16(fat[off:], old&0xf000|uint16(v&0xfff))
+			}
+		case 16:
+			binary.LittleEndian.PutUint16(fat[c*2:], uint16(v))
+		case 32:
+			binary.LittleEndian.PutUint32(fat[c*4:], v&0x0fffffff)
+		}
+	}
+}
+
+// alloc allocates and fills a cluster chain for data, returning its first
+// cluster or 0 if data is empty.
+func (img *image) alloc(data []byte, stride uint32) uint32 {
+	if stride == 0 {
+		stride = 1
+	}
+	var first, prev uint32
+	for len(data) > 0 {
+		c := img.next
+		img.next += stride
+		if c >= img.clusters+2 {
+			log.Fatalf("FAT%d image is full", img.bits)
+		}
+		if prev == 0 {
+			first = c
+		} else {
+			img.set(prev, c)
+		}
+		img.set(c, 0x0fffffff)
+		n := copy(img.b[img.dataOff+int(c-2)*sectorSize:img.dataOff+int(c-1)*sectorSize], data)
+		data = data[n:]
+		prev = c
+	}
+	return first
+}
+
+func checksum(name string) byte {
+	var sum byte
+	for i := 0; i < 11; i++ {
+		sum = (sum>>1 | sum<<7) + name[i]
+	}
+	return sum
+}
+
+// entries returns the directory entries for the nodes in dir.
+func entries(dir *node, parent uint32, nodes []*node) []byte {
+	var b []byte
+	if dir != nil {
+		b = append(b, dirent(".          ", 0x10, 0, dir.cluster, 0)...)
+		b = append(b, dirent("..         ", 0x10, 0, parent, 0)...)
+	}
+	for _, n := range nodes {
+		var e []byte
+		if n.long != "" {
+			u := utf16.Encode([]rune(n.long))
+			count := (len(u) + 12) / 13
+			if len(u)%13 != 0 {
+				u = append(u, 0)
+			}
+			for len(u)%13 != 0 {
+				u = append(u, 0xffff)
+			}
+			for ord := count; ord > 0; ord-- {
+				l := make([]byte, 32)
+				l[0] = byte(ord)
+				if ord == count {
+					l[0] |= 0x40
+				}
+				l[11] = 0x0f
+				l[13] = checksum(n.short)
+				for i, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+					binary.LittleEndian.PutUint16(l[o:], u[(ord-1)*13+i])
+				}
+				e = append(e, l...)
+			}
+		}
+		size := uint32(len(n.data))
+		if n.attr&0x10 != 0 {
+			size = 0
+		}
+		e = append(e, dirent(n.short, n.attr, n.lower, n.cluster, size)...)
+		if n.deleted {
+			for i := 0; i < len(e); i += 32 {
+				e[i] = 0xe5
+			}
+		}
+		b = append(b, e...)
+	}
+	return b
+}
+
+func dirent(name string, attr, lower byte, cluster, size uint32) []byte {
+	e := make([]byte, 32)
+	copy(e, name)
+	e[11] = attr
+	e[12] = lower
+	binary.LittleEndian.PutUint16(e[14:], fatTime)
+	binary.LittleEndian.PutUint16(e[16:], fatDate)
+	binary.LittleEndian.PutUint16(e[18:], fatDate)
+	binary.LittleEndian.PutUint16(e[20:], uint16(cluster>>16))
+	binary.LittleEndian.PutUint16(e[22:], fatTime)
+	binary.LittleEndian.PutUint16(e[24:], fatDate)
+	binary.LittleEndian.PutUint16(e[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(e[28:], size)
+	return e
+}
+
+// write allocates the files below dir, then dir itself. Directory sizes
+// only depend on their entries, so clusters can be given out first.
+func (img *image) write(dir *node, parent uint32, nodes []*node) []byte {
+	for _, n := range nodes {
+		if n.attr&0x10 == 0 {
+			n.cluster = img.alloc(n.data, n.stride)
+		}
+	}
+	for _, n := range nodes {
+		if n.attr&0x10 != 0 {
+			// Reserve the directory's clusters, then fill them in.
+			size := len(entries(n, 0, n.children))
+			n.cluster = img.alloc(make([]byte, size), 1)
+			data := img.write(n, dir.clusterOr0(), n.children)
+			for c, off := n.cluster, 0; off < len(data); off += sectorSize {
+				copy(img.b[img.dataOff+int(c-2)*sectorSize:], data[off:])
+				c++
+			}
+		}
+	}
+	return entries(dir, parent, nodes)
+}
+
+func (n *node) clusterOr0() uint32 {
+	if n == nil {
+		return 0
+	}
+	return n.cluster
+}
+
+func gen(name string, bits, sectors, rootEntries int) {
+	img := newImage(bits, sectors, rootEntries)
+	nodes := append([]*node{{short: fmt.Sprintf("FAT%-8d", bits), attr: 0x08}}, tree()...)
+	if bits == 32 {
+		// The root directory is a chain from cluster 2, allocated up front.
+		img.alloc(make([]byte, len(entries(nil, 0, nodes))), 1)
+		data := img.write(nil, 0, nodes)
+		copy(img.b[img.dataOff:], data)
+	} else {
+		data := img.write(nil, 0, nodes)
+		if len(data) > img.rootSize {
+			log.Fatalf("%d bytes of root directory entries for %d", len(data), img.rootSize)
+		}
+		copy(img.b[img.rootOff:], data)
+	}
+	var b bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if _, err := w.Write(img.b); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(name, b.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	gen("fat12.img.gz", 12, 512, 64)
+	gen("fat16.img.gz", 16, 4400, 512)
+	gen("fat32.img.gz", 32, 2048, 0)
+}
@@ -0,0 +1,521 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ErrReadOnly is returned when writing to a file system that is not an
+// io.WriterAt.
+var ErrReadOnly = errors.New("file system is read-only")
+
+// maxNameLen is the most UTF-16 code units in a long name.
+const maxNameLen = 255
+
+// now returns the time stamp of new and written entries.
+var now = time.Now
+
+// eoc returns the end of chain marker.
+func (fsys *FS) eoc() uint32 {
+	if fsys.Bits == 32 {
+		return 0x0fffffff
+	}
+	return uint32(1)<<fsys.Bits - 1
+}
+
+// setNext sets the FAT entry of cluster c in every FAT.
+func (fsys *FS) setNext(c, v uint32) error {
+	if !fsys.changed && fsys.fsInfoOff != 0 {
+		// The free cluster count and hint will be stale, so mark them
+		// unknown.
+		unknown := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		if _, err := fsys.w.WriteAt(unknown, fsys.fsInfoOff+488); err != nil {
+			return fmt.Errorf("writing FSInfo: %w", err)
+		}
+	}
+	fsys.changed = true
+
+	off := int64(c) * int64(fsys.Bits) / 8
+	var b []byte
+	switch fsys.Bits {
+	case 12:
+		b = make([]byte, 2)
+		if _, err := fsys.r.ReadAt(b, fsys.fatOff+off); err != nil {
+			return fmt.Errorf("reading FAT: %w", err)
+		}
+		old := binary.LittleEndian.Uint16(b)
+		if c&1 != 0 {
+			binary.LittleEndian.PutUint16(b, old&0x000f|uint16(v)<<4)
+		} else {
+			binary.LittleEndian.PutUint16(b, old&0xf000|uint16(v&0xfff))
+		}
+	case 16:
+		b = make([]byte, 2)
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	default:
+		b = make([]byte, 4)
+		if _, err := fsys.r.ReadAt(b, fsys.fatOff+off); err != nil {
+			return fmt.Errorf("reading FAT: %w", err)
+		}
+		// The top 4 bits are reserved and kept.
+		binary.LittleEndian.PutUint32(b, binary.LittleEndian.Uint32(b)&0xf0000000|v&0x0fffffff)
+	}
+	for i := int64(0); i < fsys.nfats; i++ {
+		if _, err := fsys.w.WriteAt(b, fsys.fatOff+i*fsys.fatBytes+off); err != nil {
+			return fmt.Errorf("writing FAT: %w", err)
+		}
+	}
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if start := fsys.fatIndex * fsys.sectorSize; fsys.fatIndex >= 0 && off >= start && off+int64(len(b)) <= start+int64(len(fsys.fatSector)) {
+		copy(fsys.fatSector[off-start:], b)
+	}
+	return nil
+}
+
+// alloc allocates a chain of n zeroed clusters, linked after last unless
+// it is 0.
+func (fsys *FS) alloc(n int, last uint32) ([]uint32, error) {
+	var chain []uint32
+	c := fsys.freeHint
+	for scanned := uint32(0); len(chain) < n; c++ {
+		if scanned++; scanned > fsys.clusters {
+			fsys.free(chain)
+			return nil, fmt.Errorf("no space left on device")
+		}
+		if c < 2 || c >= fsys.clusters+2 {
+			c = 2
+		}
+		v, err := fsys.next(c)
+		if err != nil {
+			return nil, err
+		}
+		if v != 0 {
+			continue
+		}
+		chain = append(chain, c)
+		// Claim it now so that the scan does not find it again.
+		if err := fsys.setNext(c, fsys.eoc()); err != nil {
+			return nil, err
+		}
+	}
+	fsys.freeHint = c
+	for i, c := range chain {
+		if i > 0 {
+			if err := fsys.setNext(chain[i-1], c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if last != 0 && len(chain) > 0 {
+		if err := fsys.setNext(last, chain[0]); err != nil {
+			return nil, err
+		}
+	}
+	return chain, nil
+}
+
+// free releases the clusters of a chain.
+func (fsys *FS) free(chain []uint32) error {
+	for _, c := range chain {
+		if err := fsys.setNext(c, 0); err != nil {
+			return err
+		}
+	}
+	if len(chain) > 0 && chain[0] < fsys.freeHint {
+		fsys.freeHint = chain[0]
+	}
+	return nil
+}
+
+// writeChain writes data to the clusters of chain, zeroing the rest of
+// the last cluster.
+func (fsys *FS) writeChain(chain []uint32, data []byte) error {
+	cs := fsys.clusterSize
+	for i := 0; i < len(chain); {
+		// Write contiguous clusters at once.
+		j := i + 1
+		for j < len(chain) && chain[j] == chain[j-1]+1 {
+			j++
+		}
+		b := make([]byte, int64(j-i)*cs)
+		if start := int64(i) * cs; start < int64(len(data)) {
+			copy(b, data[start:])
+		}
+		if _, err := fsys.w.WriteAt(b, fsys.clusterOff(chain[i])); err != nil {
+			return err
+		}
+		i = j
+	}
+	return nil
+}
+
+// fatDateTime converts t to a FAT date and time in UTC.
+func fatDateTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		return 0x21, 0
+	}
+	return uint16(t.Year()-1980)<<9 | uint16(t.Month())<<5 | uint16(t.Day()),
+		uint16(t.Hour())<<11 | uint16(t.Minute())<<5 | uint16(t.Second()/2)
+}
+
+// newDirent returns an 8.3 directory entry.
+func newDirent(short [11]byte, lower, attr byte, cluster uint32, size uint32, t time.Time) []byte {
+	d := make([]byte, dirEntrySize)
+	copy(d, short[:])
+	d[11] = attr
+	d[12] = lower
+	date, tm := fatDateTime(t)
+	binary.LittleEndian.PutUint16(d[14:], tm)
+	binary.LittleEndian.PutUint16(d[16:], date)
+	binary.LittleEndian.PutUint16(d[18:], date)
+	binary.LittleEndian.PutUint16(d[20:], uint16(cluster>>16))
+	binary.LittleEndian.PutUint16(d[22:], tm)
+	binary.LittleEndian.PutUint16(d[24:], date)
+	binary.LittleEndian.PutUint16(d[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(d[28:], size)
+	return d
+}
+
+// longDirents returns the long name entries for name, which come before
+// its 8.3 entry.
+func longDirents(name string, short [11]byte) []byte {
+	u := utf16.Encode([]rune(name))
+	count := (len(u) + 12) / 13
+	if len(u)%13 != 0 {
+		u = append(u, 0)
+	}
+	for len(u)%13 != 0 {
+		u = append(u, 0xffff)
+	}
+	sum := checksum(short[:])
+	var b []byte
+	for ord := count; ord > 0; ord-- {
+		d := make([]byte, dirEntrySize)
+		d[0] = byte(ord)
+		if ord == count {
+			d[0] |= 0x40
+		}
+		d[11] = attrLongName
+		d[13] = sum
+		for i, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+			binary.LittleEndian.PutUint16(d[o:], u[(ord-1)*13+i])
+		}
+		b = append(b, d...)
+	}
+	return b
+}
+
+// validName reports whether name can be a long name.
+func validName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(utf16.Encode([]rune(name))) > maxNameLen ||
+		strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`"*/:<>?\|`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// shortChar reports whether c may be in an 8.3 name. Other characters,
+// which would be in the OEM code page, are left to long names.
+func shortChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$%&'()-@^_`{}~", c) >= 0
+}
+
+// caseOf returns whether s is upper or lower case. Mixed case is neither.
+func caseOf(s string) (upper, lower bool) {
+	return strings.ToUpper(s) == s, strings.ToLower(s) == s
+}
+
+// fitShort returns the 8.3 name and case flags for a name that fits one
+// without a long name.
+func fitShort(name string) (short [11]byte, lower byte, ok bool) {
+	base, ext := name, ""
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	if base == "" || len(base) > 8 || len(ext) > 3 || strings.Contains(ext, ".") {
+		return short, 0, false
+	}
+	for _, part := range []struct {
+		s    string
+		flag byte
+	}{{base, lowerBase}, {ext, lowerExt}} {
+		upper, low := caseOf(part.s)
+		switch {
+		case upper:
+		case low:
+			lower |= part.flag
+		default:
+			return short, 0, false
+		}
+		for i := 0; i < len(part.s); i++ {
+			if !shortChar(strings.ToUpper(part.s)[i]) {
+				return short, 0, false
+			}
+		}
+	}
+	copy(short[:], "           ")
+	copy(short[:8], strings.ToUpper(base))
+	copy(short[8:], strings.ToUpper(ext))
+	return short, lower, true
+}
+
+// makeShort returns a unique 8.3 name with a numeric tail, like Windows
+// does, for a name that needs a long name.
+func makeShort(name string, exists func([11]byte) bool) ([11]byte, error) {
+	clean := func(s string) []byte {
+		var b []byte
+		for _, r := range strings.ToUpper(s) {
+			switch {
+			case r == ' ' || r == '.':
+			case r < 0x80 && shortChar(byte(r)):
+				b = append(b, byte(r))
+			default:
+				b = append(b, '_')
+			}
+		}
+		return b
+	}
+	base, ext := name, ""
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	b, e := clean(base), clean(ext)
+	if len(e) > 3 {
+		e = e[:3]
+	}
+	if len(b) == 0 {
+		b = []byte("_")
+	}
+	for n := 1; n < 1000000; n++ {
+		tail := fmt.Sprintf("~%d", n)
+		keep := 8 - len(tail)
+		if keep > len(b) {
+			keep = len(b)
+		}
+		var short [11]byte
+		copy(short[:], "           ")
+		copy(short[:], b[:keep])
+		copy(short[keep:8], tail)
+		copy(short[8:], e)
+		if !exists(short) {
+			return short, nil
+		}
+	}
+	return [11]byte{}, fmt.Errorf("no unique short name for %q", name)
+}
+
+// addEntry adds the entries for name to directory parent.
+func (fsys *FS) addEntry(parent *entry, name string, attr byte, cluster uint32, size uint32) error {
+	dd, err := fsys.readDirData(parent)
+	if err != nil {
+		return err
+	}
+	entries := fsys.parseDir(dd)
+	short, lower, ok := fitShort(name)
+	exists := func(s [11]byte) bool {
+		for _, e := range entries {
+			if e.short == s {
+				return true
+			}
+		}
+		return false
+	}
+	var b []byte
+	if !ok || exists(short) {
+		if short, err = makeShort(name, exists); err != nil {
+			return err
+		}
+		lower = 0
+		b = longDirents(name, short)
+	}
+	b = append(b, newDirent(short, lower, attr, cluster, size, now())...)
+
+	// Use a run of deleted entries, or else the free space at the end,
+	// growing the directory if needed.
+	need := len(b) / dirEntrySize
+	start, run, off := 0, 0, 0
+	for ; off+dirEntrySize <= len(dd.b) && dd.b[off] != 0; off += dirEntrySize {
+		if dd.b[off] != 0xe5 {
+			run = 0
+			continue
+		}
+		if run == 0 {
+			start = off
+		}
+		if run++; run == need {
+			break
+		}
+	}
+	if run < need && (run == 0 || start+run*dirEntrySize != off) {
+		start = off
+	}
+	if grow := start + len(b) - len(dd.b); grow > 0 {
+		if dd.chain == nil {
+			return fmt.Errorf("root directory is full")
+		}
+		chain, err := fsys.alloc(int((int64(grow)+fsys.clusterSize-1)/fsys.clusterSize), dd.chain[len(dd.chain)-1])
+		if err != nil {
+			return err
+		}
+		if err := fsys.writeChain(chain, nil); err != nil {
+			return err
+		}
+		dd.chain = append(dd.chain, chain...)
+	}
+	for i := 0; i < len(b); i += dirEntrySize {
+		if _, err := fsys.w.WriteAt(b[i:i+dirEntrySize], fsys.off(dd, start+i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parentOf resolves the parent directory of name and checks that name
+// does not exist.
+func (fsys *FS) parentOf(op, name string) (*entry, string, error) {
+	if fsys.w == nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+	}
+	if !fs.ValidPath(name) || name == "." || !validName(path.Base(name)) {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	parent, err := fsys.resolve(op, path.Dir(name))
+	if err != nil {
+		return nil, "", err
+	}
+	if !parent.isDir() {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	return parent, path.Base(name), nil
+}
+
+// Mkdir creates directory name. Its parent must exist.
+func (fsys *FS) Mkdir(name string) error {
+	parent, base, err := fsys.parentOf("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, err := fsys.resolve("mkdir", name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	chain, err := fsys.alloc(1, 0)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	dot := [11]byte{'.', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' '}
+	dotdot := dot
+	dotdot[1] = '.'
+	// ".." is 0 for the root directory, even on FAT32.
+	up := parent.cluster
+	if parent.root {
+		up = 0
+	}
+	t := now()
+	b := append(newDirent(dot, 0, attrDir, chain[0], 0, t), newDirent(dotdot, 0, attrDir, up, 0, t)...)
+	if err := fsys.writeChain(chain, b); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if err := fsys.addEntry(parent, base, attrDir, chain[0], 0); err != nil {
+		fsys.free(chain)
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// MkdirAll creates directory name and any missing parents.
+func (fsys *FS) MkdirAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil
+	}
+	if e, err := fsys.resolve("mkdir", name); err == nil {
+		if e.isDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	if err := fsys.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	return fsys.Mkdir(name)
+}
+
+// WriteFile writes data to file name, creating it or replacing its
+// contents. Its parent directory must exist.
+func (fsys *FS) WriteFile(name string, data []byte) error {
+	parent, base, err := fsys.parentOf("write", name)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > 0xffffffff {
+		return &fs.PathError{Op: "write", Path: name, Err: fmt.Errorf("%d bytes is too large for FAT", len(data))}
+	}
+	old, err := fsys.resolve("write", name)
+	if err == nil && old.isDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: errors.New("is a directory")}
+	}
+
+	chain, err := fsys.alloc(int((int64(len(data))+fsys.clusterSize-1)/fsys.clusterSize), 0)
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	if err := fsys.writeChain(chain, data); err != nil {
+		fsys.free(chain)
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	var first uint32
+	if len(chain) > 0 {
+		first = chain[0]
+	}
+	if old == nil {
+		if err := fsys.addEntry(parent, base, 0, first, uint32(len(data))); err != nil {
+			fsys.free(chain)
+			return &fs.PathError{Op: "write", Path: name, Err: err}
+		}
+		return nil
+	}
+
+	// Point the existing entry at the new data, then free the old.
+	oldChain, err := fsys.chain(old.cluster)
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	d := make([]byte, dirEntrySize)
+	if _, err := fsys.r.ReadAt(d, old.off); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	date, tm := fatDateTime(now())
+	binary.LittleEndian.PutUint16(d[18:], date)
+	binary.LittleEndian.PutUint16(d[20:], uint16(first>>16))
+	binary.LittleEndian.PutUint16(d[22:], tm)
+	binary.LittleEndian.PutUint16(d[24:], date)
+	binary.LittleEndian.PutUint16(d[26:], uint16(first))
+	binary.LittleEndian.PutUint32(d[28:], uint32(len(data)))
+	if _, err := fsys.w.WriteAt(d, old.off); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	if err := fsys.free(oldChain); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// memDev is an in-memory device.
+type memDev []byte
+
+func (m memDev) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m memDev) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(m)) {
+		return 0, fmt.Errorf("write of %d bytes at %d beyond %d", len(p), off, len(m))
+	}
+	return copy(m[off:], p), nil
+}
+
+// freeClusters counts the free clusters.
+func freeClusters(t *testing.T, fsys *FS) int {
+	t.Helper()
+	var n int
+	for c := uint32(2); c < fsys.clusters+2; c++ {
+		v, err := fsys.next(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct {
+		size        int64
+		opts        FormatOptions
+		bits        int
+		clusterSize int64
+	}{
+		{size: 1 << 20, bits: 12, clusterSize: 512},
+		{size: 15 << 20, bits: 12, clusterSize: 4096},
+		{size: 32 << 20, bits: 16, clusterSize: 512},
+		{size: 100 << 20, bits: 16, clusterSize: 2048},
+		{size: 100 << 20, opts: FormatOptions{Bits: 32}, bits: 32, clusterSize: 512},
+		{size: 600 << 20, bits: 32, clusterSize: 4096},
+		{size: 64 << 20, opts: FormatOptions{Bits: 32, ClusterSize: 4096, SectorSize: 4096}, bits: 32, clusterSize: 4096},
+		{size: 8 << 20, opts: FormatOptions{Bits: 16, SectorSize: 1024}, bits: 16, clusterSize: 1024},
+	} {
+		t.Run(fmt.Sprintf("%d/%+v", tt.size, tt.opts), func(t *testing.T) {
+			opts := tt.opts
+			opts.Label, opts.Serial = "efi", 0x0123abcd
+			fsys, err := Format(make(memDev, tt.size), tt.size, &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fsys.Bits != tt.bits || fsys.clusterSize != tt.clusterSize {
+				t.Errorf("FAT%d with %d byte clusters, want FAT%d with %d", fsys.Bits, fsys.clusterSize, tt.bits, tt.clusterSize)
+			}
+			if fsys.Label() != "EFI" || fsys.UUID() != "0123-ABCD" {
+				t.Errorf("label %q UUID %q, want %q %q", fsys.Label(), fsys.UUID(), "EFI", "0123-ABCD")
+			}
+			if free := freeClusters(t, fsys); free != int(fsys.clusters) && free != int(fsys.clusters)-1 {
+				t.Errorf("%d of %d clusters free", free, fsys.clusters)
+			}
+			entries, err := fsys.ReadDir(".")
+			if err != nil || len(entries) != 0 {
+				t.Errorf("ReadDir(.) = %v, %v, want no entries", entries, err)
+			}
+		})
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	for _, tt := range []struct {
+		size int64
+		opts FormatOptions
+		err  string
+	}{
+		{size: 1 << 20, opts: FormatOptions{Bits: 16}, err: "too few for FAT16"},
+		{size: 64 << 20, opts: FormatOptions{Bits: 12, ClusterSize: 512}, err: "too many for FAT12"},
+		{size: 4096, err: "too small"},
+		{size: 1 << 20, opts: FormatOptions{ClusterSize: 1000}, err: "invalid cluster size"},
+		{size: 1 << 20, opts: FormatOptions{SectorSize: 256}, err: "invalid sector size"},
+		{size: 1 << 20, opts: FormatOptions{Bits: 24}, err: "invalid FAT type"},
+		{size: 1 << 20, opts: FormatOptions{Label: "a label too long"}, err: "longer than"},
+		{size: 1 << 20, opts: FormatOptions{Label: "a/b"}, err: "invalid character"},
+	} {
+		if _, err := Format(make(memDev, tt.size), tt.size, &tt.opts); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Format(%d, %+v) = %v, want an error containing %q", tt.size, tt.opts, err, tt.err)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	mtime := time.Date(2021, 7, 4, 10, 20, 30, 0, time.UTC)
+	now = func() time.Time { return mtime }
+
+	for _, tt := range []struct {
+		bits int
+		size int
+	}{
+		{12, 4 << 20},
+		{16, 8 << 20},
+		{32, 8 << 20},
+	} {
+		t.Run(fmt.Sprintf("FAT%d", tt.bits), func(t *testing.T) {
+			dev := make(memDev, tt.size)
+			fsys, err := Format(dev, int64(len(dev)), &FormatOptions{Bits: tt.bits, ClusterSize: 1024})
+			if err != nil {
+				t.Fatal(err)
+			}
+			free := freeClusters(t, fsys)
+
+			files := map[string][]byte{
+				"EFI/BOOT/BOOTX64.EFI":                 pattern(100000),
+				"loader/loader.conf":                   []byte("default fedora*\n"),
+				"README.TXT":                           []byte("readme\n"),
+				"readme.md":                            []byte("# readme\n"),
+				"A long file name.txt":                 []byte("long\n"),
+				"A long file name with more words.txt": []byte("longer\n"),
+				"Ünïcode.txt":                          []byte("unicode\n"),
+				".hidden":                              nil,
+			}
+			// Enough entries to grow the directory past a cluster.
+			for i := 0; i < 40; i++ {
+				files[fmt.Sprintf("loader/entries/fedora-5.%d.conf", i)] = []byte(fmt.Sprintf("title Fedora 5.%d\n", i))
+			}
+			for _, dir := range []string{"EFI/BOOT", "loader/entries"} {
+				if err := fsys.MkdirAll(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var names []string
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if err := fsys.WriteFile(name, files[name]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Replace a file with a shorter one.
+			files["EFI/BOOT/BOOTX64.EFI"] = []byte("smaller\n")
+			if err := fsys.WriteFile("efi/boot/bootx64.efi", files["EFI/BOOT/BOOTX64.EFI"]); err != nil {
+				t.Fatal(err)
+			}
+
+			// Read it back from scratch.
+			fsys, err = New(dev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fstest.TestFS(fsys, names...); err != nil {
+				t.Error(err)
+			}
+			for name, want := range files {
+				got, err := fs.ReadFile(fsys, name)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Errorf("ReadFile(%s) = %.40q, %v, want %.40q", name, got, err, want)
+				}
+			}
+			// Short names get numeric tails in the order written.
+			for short, long := range map[string]string{
+				"ALONGF~1.TXT": "A long file name with more words.txt",
+				"ALONGF~2.TXT": "A long file name.txt",
+				"_N_COD~1.TXT": "Ünïcode.txt",
+				"HIDDEN~1":     ".hidden",
+				"README.MD":    "readme.md",
+			} {
+				got, err := fs.ReadFile(fsys, short)
+				if err != nil || !bytes.Equal(got, files[long]) {
+					t.Errorf("ReadFile(%s) = %q, %v, want the contents of %s", short, got, err, long)
+				}
+			}
+			if fi, err := fsys.Stat("README.TXT"); err != nil || !fi.ModTime().Equal(mtime) {
+				t.Errorf("Stat(README.TXT) = %v, %v, want mtime %v", fi, err, mtime)
+			}
+
+			// 1024 byte clusters: BOOTX64.EFI, loader.conf, 40 entries
+			// and 5 other files with data, 4 directories, and 3 more
+			// for the 122 entries of loader/entries.
+			if got, want := free-freeClusters(t, fsys), 1+1+40+5+4+3; got != want {
+				t.Errorf("%d clusters used, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	dev := make(memDev, 1<<20)
+	fsys, err := Format(dev, int64(len(dev)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.WriteFile("file", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		op   func(string) error
+		err  error
+	}{
+		{"nodir/file", func(n string) error { return fsys.WriteFile(n, nil) }, fs.ErrNotExist},
+		{"bad:name", func(n string) error { return fsys.WriteFile(n, nil) }, fs.ErrInvalid},
+		{"trailing.", fsys.Mkdir, fs.ErrInvalid},
+		{"/abs", fsys.Mkdir, fs.ErrInvalid},
+		{"FILE", fsys.Mkdir, fs.ErrExist},
+	} {
+		if err := tt.op(tt.name); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if err := fsys.Mkdir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.WriteFile("dir", nil); err == nil {
+		t.Errorf("WriteFile of a directory succeeded")
+	}
+	if err := fsys.WriteFile("file/x", nil); err == nil {
+		t.Errorf("WriteFile below a file succeeded")
+	}
+	if err := fsys.WriteFile("big", make([]byte, 2<<20)); err == nil || !strings.Contains(err.Error(), "no space") {
+		t.Errorf("WriteFile of 2MB on 1MB = %v, want no space", err)
+	}
+	// The failed write must not leak clusters.
+	if err := fsys.WriteFile("fits", make([]byte, 900<<10)); err != nil {
+		t.Errorf("WriteFile after a failed one: %v", err)
+	}
+
+	// The FAT12 root directory is fixed.
+	var err2 error
+	for i := 0; i < 600 && err2 == nil; i++ {
+		err2 = fsys.WriteFile(fmt.Sprintf("F%d", i), nil)
+	}
+	if err2 == nil || !strings.Contains(err2.Error(), "root directory is full") {
+		t.Errorf("filling the root directory = %v, want it full", err2)
+	}
+
+	ro, err := New(bytes.NewReader(dev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ro.WriteFile("file", nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("WriteFile on a bytes.Reader = %v, want %v", err, ErrReadOnly)
+	}
+}
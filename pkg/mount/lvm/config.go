@@ -0,0 +1,225 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// section is a parsed section of LVM text metadata: name { ... }.
+type section struct {
+	values   map[string]interface{}
+	sections map[string]*section
+
+	// order is the order of the subsections.
+	order []string
+}
+
+func newSection() *section {
+	return &section{values: map[string]interface{}{}, sections: map[string]*section{}}
+}
+
+// str returns the string value key.
+func (s *section) str(key string) (string, error) {
+	v, ok := s.values[key].(string)
+	if !ok {
+		return "", fmt.Errorf("missing string %q", key)
+	}
+	return v, nil
+}
+
+// int returns the integer value key.
+func (s *section) int(key string) (int64, error) {
+	v, ok := s.values[key].(int64)
+	if !ok {
+		return 0, fmt.Errorf("missing integer %q", key)
+	}
+	return v, nil
+}
+
+// list returns the list value key, or nil if it is missing.
+func (s *section) list(key string) []interface{} {
+	v, _ := s.values[key].([]interface{})
+	return v
+}
+
+// strings returns the strings in the list value key.
+func (s *section) strings(key string) []string {
+	var l []string
+	for _, v := range s.list(key) {
+		if v, ok := v.(string); ok {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// configParser parses LVM's configuration syntax:
+//
+//	key = value
+//	name {
+//		...
+//	}
+//
+// where values are integers, "strings" or [lists, of, values], and #
+// starts a comment.
+type configParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func parseConfig(text string) (*section, error) {
+	p := &configParser{s: text, line: 1}
+	s, err := p.section(true)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", p.line, err)
+	}
+	return s, nil
+}
+
+func (p *configParser) skip() {
+	for p.pos < len(p.s) {
+		switch c := p.s[p.pos]; {
+		case c == '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '\n':
+			p.line++
+			p.pos++
+		case unicode.IsSpace(rune(c)):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *configParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := rune(p.s[p.pos])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("_-+.", c) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *configParser) section(top bool) (*section, error) {
+	s := newSection()
+	for {
+		p.skip()
+		if p.pos == len(p.s) {
+			if !top {
+				return nil, fmt.Errorf("unterminated section")
+			}
+			return s, nil
+		}
+		if p.s[p.pos] == '}' {
+			if top {
+				return nil, fmt.Errorf("unexpected }")
+			}
+			p.pos++
+			return s, nil
+		}
+		name := p.ident()
+		if name == "" {
+			return nil, fmt.Errorf("unexpected %q", p.s[p.pos])
+		}
+		p.skip()
+		if p.pos == len(p.s) {
+			return nil, fmt.Errorf("unexpected end after %q", name)
+		}
+		switch p.s[p.pos] {
+		case '{':
+			p.pos++
+			sub, err := p.section(false)
+			if err != nil {
+				return nil, err
+			}
+			s.sections[name] = sub
+			s.order = append(s.order, name)
+		case '=':
+			p.pos++
+			v, err := p.value()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			s.values[name] = v
+		default:
+			return nil, fmt.Errorf("unexpected %q after %q", p.s[p.pos], name)
+		}
+	}
+}
+
+func (p *configParser) value() (interface{}, error) {
+	p.skip()
+	if p.pos == len(p.s) {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch c := p.s[p.pos]; {
+	case c == '"':
+		var b strings.Builder
+		for p.pos++; p.pos < len(p.s); p.pos++ {
+			switch c := p.s[p.pos]; c {
+			case '\\':
+				p.pos++
+				if p.pos < len(p.s) {
+					b.WriteByte(p.s[p.pos])
+				}
+			case '"':
+				p.pos++
+				return b.String(), nil
+			default:
+				if c == '\n' {
+					p.line++
+				}
+				b.WriteByte(c)
+			}
+		}
+		return nil, fmt.Errorf("unterminated string")
+
+	case c == '[':
+		p.pos++
+		l := []interface{}{}
+		for {
+			p.skip()
+			if p.pos < len(p.s) && p.s[p.pos] == ']' {
+				p.pos++
+				return l, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+			p.skip()
+			if p.pos < len(p.s) && p.s[p.pos] == ',' {
+				p.pos++
+			}
+		}
+
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.s) && (p.s[p.pos] >= '0' && p.s[p.pos] <= '9' || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		n := p.s[start:p.pos]
+		if strings.Contains(n, ".") {
+			return strconv.ParseFloat(n, 64)
+		}
+		return strconv.ParseInt(n, 10, 64)
+
+	default:
+		return nil, fmt.Errorf("unexpected %q", c)
+	}
+}
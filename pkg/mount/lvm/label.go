@@ -0,0 +1,180 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ErrNoLabel is returned for devices that are not physical volumes.
+var ErrNoLabel = errors.New("no LVM2 label")
+
+const (
+	sectorSize = 512
+
+	// The label is in one of the first labelScanSectors sectors.
+	labelScanSectors = 4
+
+	labelID   = "LABELONE"
+	labelType = "LVM2 001"
+
+	mdaMagic      = " LVM2 x[5A%r0N*>"
+	mdaHeaderSize = 512
+
+	// initialCRC seeds all LVM checksums.
+	initialCRC = 0xf597a6cf
+)
+
+// crc is LVM's CRC-32: the IEEE polynomial without the final inversion.
+func crc(b []byte) uint32 {
+	return ^crc32.Update(^uint32(initialCRC), crc32.IEEETable, b)
+}
+
+// Area is a data or metadata area of a physical volume, in bytes.
+type Area struct {
+	Offset uint64
+	Size   uint64
+}
+
+// Label is the LVM2 label of a physical volume.
+type Label struct {
+	// UUID is the physical volume UUID, without dashes.
+	UUID string
+
+	// DeviceSize is the size of the device in bytes.
+	DeviceSize uint64
+
+	DataAreas     []Area
+	MetadataAreas []Area
+}
+
+// labelHeader is struct label_header.
+type labelHeader struct {
+	ID     [8]byte
+	Sector uint64
+	CRC    uint32
+	Offset uint32
+	Type   [8]byte
+}
+
+// ReadLabel reads the LVM2 label of r.
+func ReadLabel(r io.ReaderAt) (*Label, error) {
+	b := make([]byte, labelScanSectors*sectorSize)
+	if n, err := r.ReadAt(b, 0); n < len(b) && err != nil && !(err == io.EOF && n >= sectorSize) {
+		return nil, err
+	}
+	for s := 0; s < labelScanSectors; s++ {
+		sector := b[s*sectorSize : (s+1)*sectorSize]
+		var h labelHeader
+		binary.Read(bytes.NewReader(sector), binary.LittleEndian, &h)
+		if string(h.ID[:]) != labelID || h.Sector != uint64(s) {
+			continue
+		}
+		if got := crc(sector[20:]); got != h.CRC {
+			return nil, fmt.Errorf("label checksum %#x, want %#x", got, h.CRC)
+		}
+		if string(h.Type[:]) != labelType {
+			return nil, fmt.Errorf("unsupported label type %q", h.Type)
+		}
+		if h.Offset < 32 || h.Offset > sectorSize-48 {
+			return nil, fmt.Errorf("invalid PV header offset %d", h.Offset)
+		}
+		return parsePVHeader(sector[h.Offset:])
+	}
+	return nil, ErrNoLabel
+}
+
+// parsePVHeader parses struct pv_header: the UUID, device size and two
+// zero terminated lists of areas.
+func parsePVHeader(b []byte) (*Label, error) {
+	l := &Label{
+		UUID:       string(b[:32]),
+		DeviceSize: binary.LittleEndian.Uint64(b[32:]),
+	}
+	b = b[40:]
+	for _, areas := range []*[]Area{&l.DataAreas, &l.MetadataAreas} {
+		for {
+			if len(b) < 16 {
+				return nil, errors.New("unterminated PV area list")
+			}
+			a := Area{Offset: binary.LittleEndian.Uint64(b), Size: binary.LittleEndian.Uint64(b[8:])}
+			b = b[16:]
+			if a.Offset == 0 {
+				break
+			}
+			*areas = append(*areas, a)
+		}
+	}
+	return l, nil
+}
+
+// ReadMetadata returns the text metadata in the first valid metadata area
+// of the physical volume r.
+func ReadMetadata(r io.ReaderAt, l *Label) (string, error) {
+	if len(l.MetadataAreas) == 0 {
+		return "", errors.New("physical volume has no metadata areas")
+	}
+	var err error
+	for _, a := range l.MetadataAreas {
+		var text string
+		if text, err = readMetadataArea(r, a); err == nil {
+			return text, nil
+		}
+	}
+	return "", err
+}
+
+func readMetadataArea(r io.ReaderAt, a Area) (string, error) {
+	h := make([]byte, mdaHeaderSize)
+	if _, err := r.ReadAt(h, int64(a.Offset)); err != nil {
+		return "", fmt.Errorf("reading metadata area header: %w", err)
+	}
+	if got, want := crc(h[4:]), binary.LittleEndian.Uint32(h); got != want {
+		return "", fmt.Errorf("metadata area header checksum %#x, want %#x", got, want)
+	}
+	if string(h[4:20]) != mdaMagic {
+		return "", errors.New("bad metadata area magic")
+	}
+	if v := binary.LittleEndian.Uint32(h[20:]); v != 1 {
+		return "", fmt.Errorf("unsupported metadata area version %d", v)
+	}
+	start := binary.LittleEndian.Uint64(h[24:])
+	size := binary.LittleEndian.Uint64(h[32:])
+	if start != a.Offset || size <= mdaHeaderSize {
+		return "", fmt.Errorf("invalid metadata area at %d, %d bytes", start, size)
+	}
+
+	// The first raw_locn points to the current metadata. The metadata
+	// area after the header is a ring buffer.
+	off := binary.LittleEndian.Uint64(h[40:])
+	n := binary.LittleEndian.Uint64(h[48:])
+	sum := binary.LittleEndian.Uint32(h[56:])
+	if off == 0 {
+		return "", errors.New("empty metadata area")
+	}
+	if off < mdaHeaderSize || off >= size || n > size-mdaHeaderSize {
+		return "", fmt.Errorf("invalid metadata location %d, %d bytes", off, n)
+	}
+	text := make([]byte, n)
+	first := n
+	if off+n > size {
+		first = size - off
+	}
+	if _, err := r.ReadAt(text[:first], int64(start+off)); err != nil {
+		return "", fmt.Errorf("reading metadata: %w", err)
+	}
+	if _, err := r.ReadAt(text[first:], int64(start+mdaHeaderSize)); err != nil && first < n {
+		return "", fmt.Errorf("reading metadata: %w", err)
+	}
+	if got := crc(text); got != sum {
+		return "", fmt.Errorf("metadata checksum %#x, want %#x", got, sum)
+	}
+	return string(bytes.TrimRight(text, "\x00")), nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lvm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/u-root/u-root/pkg/mount/block"
+	"github.com/u-root/u-root/pkg/mount/dm"
+)
+
+// DevDir holds the /dev/VG/LV links of active logical volumes.
+var DevDir = "/dev"
+
+// ScanDevices finds the volume groups on the devices at paths.
+func ScanDevices(paths []string) []*VG {
+	var devs []device
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			Debug("lvm: %v", err)
+			continue
+		}
+		defer f.Close()
+		devs = append(devs, device{path: p, r: f})
+	}
+	return scan(devs)
+}
+
+// Scan finds the volume groups on all block devices.
+func Scan() ([]*VG, error) {
+	bs, err := block.GetBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, b := range bs {
+		paths = append(paths, b.DevicePath())
+	}
+	return ScanDevices(paths), nil
+}
+
+// Activate maps lv as /dev/mapper/VG-LV and links it as /dev/VG/LV. It
+// returns the device-mapper device path.
+func (vg *VG) Activate(lv *LV) (string, error) {
+	ts, err := vg.Targets(lv)
+	if err != nil {
+		return "", fmt.Errorf("volume group %s: %v", vg.Name, err)
+	}
+	name := vg.DMName(lv)
+	path, err := dm.CreateDevice(name, vg.DMUUID(lv), ts, !lv.Writable())
+	if err != nil {
+		return "", err
+	}
+	link := filepath.Join(DevDir, vg.Name, lv.Name)
+	if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+		return path, err
+	}
+	os.Remove(link)
+	if err := os.Symlink(path, link); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// Deactivate removes the mapping of lv.
+func (vg *VG) Deactivate(lv *LV) error {
+	if err := dm.RemoveDevice(vg.DMName(lv)); err != nil {
+		return err
+	}
+	link := filepath.Join(DevDir, vg.Name, lv.Name)
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Only succeeds once the last link is gone.
+	os.Remove(filepath.Dir(link))
+	return nil
+}
+
+// ActivateAll activates the visible logical volumes of vg that can be
+// activated. It returns the device paths and the first error.
+func (vg *VG) ActivateAll() ([]string, error) {
+	var paths []string
+	var firstErr error
+	for _, lv := range vg.LVs {
+		if !lv.Visible() {
+			continue
+		}
+		p, err := vg.Activate(lv)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return paths, firstErr
+}
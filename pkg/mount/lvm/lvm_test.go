@@ -0,0 +1,373 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/mount/dm"
+)
+
+const (
+	pv0UUID = "jmFb9Z-Hbxn-Gq2L-nB1G-3YbV-8WfP-2XJ0xd"
+	pv1UUID = "Q8cT3e-0xkA-KqvR-zM4T-jJ2c-a0Fh-LhU1Nr"
+)
+
+// metadata is a volume group as written by vgcreate and lvcreate.
+func metadata(seqno int) string {
+	return fmt.Sprintf(`vg-0 {
+id = "t4Rz8q-5nWj-LkH3-vX2P-9sTd-Qw1E-mY7uBc"
+seqno = %d
+format = "lvm2"			# informational
+status = ["RESIZEABLE", "READ", "WRITE"]
+flags = []
+extent_size = 8192		# 4 Megabytes
+max_lv = 0
+max_pv = 0
+metadata_copies = 0
+
+physical_volumes {
+
+pv0 {
+id = "%s"
+device = "/dev/sda2"	# Hint only
+
+status = ["ALLOCATABLE"]
+flags = []
+dev_size = 2097152	# 1024 Megabytes
+pe_start = 2048
+pe_count = 255	# 1020 Megabytes
+}
+
+pv1 {
+id = "%s"
+device = "/dev/sdb"	# Hint only
+
+status = ["ALLOCATABLE"]
+flags = []
+dev_size = 2097152	# 1024 Megabytes
+pe_start = 2048
+pe_count = 255	# 1020 Megabytes
+}
+}
+
+logical_volumes {
+
+root {
+id = "2Hb7cK-Vq9X-pL3a-Wn0E-dR5j-Ty8M-uZ1sFo"
+status = ["READ", "WRITE", "VISIBLE"]
+flags = []
+creation_time = 1617187200	# 2021-03-31 10:40:00 +0000
+creation_host = "builder"
+segment_count = 2
+
+segment1 {
+start_extent = 0
+extent_count = 100	# 400 Megabytes
+
+type = "striped"
+stripe_count = 1	# linear
+
+stripes = [
+"pv0", 0
+]
+}
+segment2 {
+start_extent = 100
+extent_count = 20	# 80 Megabytes
+
+type = "striped"
+stripe_count = 2
+stripe_size = 128	# 64 Kilobytes
+
+stripes = [
+"pv0", 100,
+"pv1", 0
+]
+}
+}
+
+boot-a {
+id = "Nc4Ws1-Jr6D-xE8b-Qm2T-oV9g-Hp3L-kY5aZi"
+status = ["READ", "VISIBLE"]
+flags = []
+segment_count = 1
+
+segment1 {
+start_extent = 0
+extent_count = 10	# 40 Megabytes
+
+type = "striped"
+stripe_count = 1	# linear
+
+stripes = [
+"pv1", 10
+]
+}
+}
+
+pool_tmeta {
+id = "Xa8Vb2-Lk4N-cQ7e-Rm1W-tZ9p-Fj3S-hU6dGo"
+status = ["READ", "WRITE"]
+flags = []
+segment_count = 1
+
+segment1 {
+start_extent = 0
+extent_count = 1
+
+type = "thin-pool"
+metadata = "pool_tmeta"
+}
+}
+}
+}
+# Generated by LVM2 version 2.03.11(2) (2021-01-08): Wed Mar 31 10:40:00 2021
+
+contents = "Text Format Volume Group"
+version = 1
+
+description = "Created *after* executing 'lvcreate -n boot-a -L 40M vg-0'"
+
+creation_host = "builder"	# Linux builder 5.10.0 #1 SMP x86_64
+creation_time = 1617187200	# Wed Mar 31 10:40:00 2021
+`, seqno, pv0UUID, pv1UUID)
+}
+
+const (
+	mdaOffset = 4096
+	mdaSize   = 1 << 20
+)
+
+// makePV returns a physical volume with text in a metadata area starting
+// at off past its header, or without a metadata area if text is empty.
+func makePV(uuid, text string, off uint64) []byte {
+	b := make([]byte, mdaOffset+mdaSize)
+
+	// The label is in the second sector.
+	l := b[sectorSize : 2*sectorSize]
+	copy(l, labelID)
+	binary.LittleEndian.PutUint64(l[8:], 1)
+	binary.LittleEndian.PutUint32(l[20:], 32)
+	copy(l[24:], labelType)
+	ph := l[32:]
+	copy(ph, strings.ReplaceAll(uuid, "-", ""))
+	binary.LittleEndian.PutUint64(ph[32:], 1<<30)
+	binary.LittleEndian.PutUint64(ph[40:], 1<<20)
+	// Data area list terminator at ph[56:72].
+	if text != "" {
+		binary.LittleEndian.PutUint64(ph[72:], mdaOffset)
+		binary.LittleEndian.PutUint64(ph[80:], mdaSize)
+	}
+	binary.LittleEndian.PutUint32(l[16:], crc(l[20:]))
+	if text == "" {
+		return b
+	}
+
+	m := b[mdaOffset:]
+	copy(m[4:], mdaMagic)
+	binary.LittleEndian.PutUint32(m[20:], 1)
+	binary.LittleEndian.PutUint64(m[24:], mdaOffset)
+	binary.LittleEndian.PutUint64(m[32:], mdaSize)
+	binary.LittleEndian.PutUint64(m[40:], off)
+	binary.LittleEndian.PutUint64(m[48:], uint64(len(text)))
+	binary.LittleEndian.PutUint32(m[56:], crc([]byte(text)))
+	binary.LittleEndian.PutUint32(m, crc(m[4:mdaHeaderSize]))
+
+	// Write the text to the ring buffer.
+	n := copy(m[off:mdaSize], text)
+	copy(m[mdaHeaderSize:], text[n:])
+	return b
+}
+
+func TestCRC(t *testing.T) {
+	// Computed with LVM's calc_crc.
+	if got := crc([]byte("123456789")); got != 0x4991cf02 {
+		t.Errorf("crc = %#x, want 0x4991cf02", got)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	s, err := parseConfig(`# comment
+a = 1
+b = "x \"y\"" # trailing
+c = [ "p", 2, -3 ]
+d = 1.5
+e { f = [] }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": int64(1),
+		"b": `x "y"`,
+		"c": []interface{}{"p", int64(2), int64(-3)},
+		"d": 1.5,
+	}
+	if !reflect.DeepEqual(s.values, want) {
+		t.Errorf("values = %v, want %v", s.values, want)
+	}
+	if e := s.sections["e"]; e == nil || !reflect.DeepEqual(e.values["f"], []interface{}{}) {
+		t.Errorf("section e = %+v", e)
+	}
+
+	for _, bad := range []string{"a {", "}", "a = ", `a = "x`, "a b", "a = [1, 2"} {
+		if _, err := parseConfig(bad); err == nil {
+			t.Errorf("parseConfig(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	vg, err := ParseMetadata(metadata(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vg.Name != "vg-0" || vg.SeqNo != 3 || vg.ExtentSize != 8192 || len(vg.PVs) != 2 || len(vg.LVs) != 3 {
+		t.Fatalf("vg = %+v", vg)
+	}
+	root, err := vg.LV("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Segment{
+		{StartExtent: 0, ExtentCount: 100, Type: "striped", Stripes: []Stripe{{"pv0", 0}}},
+		{StartExtent: 100, ExtentCount: 20, Type: "striped", StripeSize: 128, Stripes: []Stripe{{"pv0", 100}, {"pv1", 0}}},
+	}
+	if !reflect.DeepEqual(root.Segments, want) {
+		t.Errorf("root segments = %+v, want %+v", root.Segments, want)
+	}
+	if !root.Visible() || !root.Writable() || root.Size() != 120 {
+		t.Errorf("root: visible %v, writable %v, size %d", root.Visible(), root.Writable(), root.Size())
+	}
+	if meta, _ := vg.LV("pool_tmeta"); meta.Visible() {
+		t.Errorf("pool_tmeta is visible")
+	}
+	if _, err := vg.LV("swap"); err == nil {
+		t.Errorf("LV(swap) succeeded")
+	}
+
+	for _, bad := range []string{
+		"",
+		"a { seqno = 1 extent_size = 8 }",
+		`a { id = "x" seqno = 1 extent_size = 0 }`,
+		`a { id = "x" seqno = 1 extent_size = 8 logical_volumes { lv { id = "y" segment_count = 2 segment1 { start_extent = 0 extent_count = 1 type = "striped" stripes = ["pv0", 0] } } } }`,
+		`a { id = "x" seqno = 1 extent_size = 8 logical_volumes { lv { id = "y" segment1 { start_extent = 0 extent_count = 2 type = "striped" stripes = ["pv0", 0, "pv1", 0] } } } }`,
+	} {
+		if _, err := ParseMetadata(bad); err == nil {
+			t.Errorf("ParseMetadata(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestReadLabel(t *testing.T) {
+	text := metadata(1)
+	// Wrap the metadata around the end of the ring buffer.
+	b := makePV(pv0UUID, text, mdaSize-100)
+	l, err := ReadLabel(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Label{
+		UUID:          strings.ReplaceAll(pv0UUID, "-", ""),
+		DeviceSize:    1 << 30,
+		DataAreas:     []Area{{1 << 20, 0}},
+		MetadataAreas: []Area{{mdaOffset, mdaSize}},
+	}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("ReadLabel = %+v, want %+v", l, want)
+	}
+	got, err := ReadMetadata(bytes.NewReader(b), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != text {
+		t.Errorf("ReadMetadata = %q, want %q", got, text)
+	}
+
+	b[mdaOffset+mdaHeaderSize] ^= 1
+	if _, err := ReadMetadata(bytes.NewReader(b), l); err == nil {
+		t.Errorf("ReadMetadata succeeded with corrupt metadata")
+	}
+	b[sectorSize+40] ^= 1
+	if _, err := ReadLabel(bytes.NewReader(b)); err == nil {
+		t.Errorf("ReadLabel succeeded with a corrupt label")
+	}
+	if _, err := ReadLabel(bytes.NewReader(make([]byte, 4096))); !errors.Is(err, ErrNoLabel) {
+		t.Errorf("ReadLabel(zeros) = %v, want %v", err, ErrNoLabel)
+	}
+}
+
+func TestScan(t *testing.T) {
+	devs := []device{
+		{"/dev/sdc", bytes.NewReader(make([]byte, 4096))},
+		{"/dev/sdb", bytes.NewReader(makePV(pv1UUID, metadata(2), mdaHeaderSize))},
+		{"/dev/sda2", bytes.NewReader(makePV(pv0UUID, metadata(3), 8192))},
+	}
+	vgs := scan(devs)
+	if len(vgs) != 1 {
+		t.Fatalf("scan = %d volume groups, want 1", len(vgs))
+	}
+	vg := vgs[0]
+	if vg.SeqNo != 3 {
+		t.Errorf("scan used metadata seqno %d, want 3", vg.SeqNo)
+	}
+	if vg.PVs[0].Device != "/dev/sda2" || vg.PVs[1].Device != "/dev/sdb" || len(vg.Missing()) != 0 {
+		t.Errorf("physical volumes = %+v, %+v", vg.PVs[0], vg.PVs[1])
+	}
+
+	root, _ := vg.LV("root")
+	ts, err := vg.Targets(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dm.Target{
+		dm.Linear(0, 819200, "/dev/sda2", 2048),
+		dm.Striped(819200, 163840, 128, []dm.Stripe{{Device: "/dev/sda2", Offset: 821248}, {Device: "/dev/sdb", Offset: 2048}}),
+	}
+	if !reflect.DeepEqual(ts, want) {
+		t.Errorf("Targets(root) = %v, want %v", ts, want)
+	}
+	if got := vg.DMName(root); got != "vg--0-root" {
+		t.Errorf("DMName(root) = %q", got)
+	}
+	boot, _ := vg.LV("boot-a")
+	if got := vg.DMName(boot); got != "vg--0-boot--a" {
+		t.Errorf("DMName(boot-a) = %q", got)
+	}
+	if got := vg.DMUUID(boot); got != "LVM-t4Rz8q5nWjLkH3vX2P9sTdQw1EmY7uBcNc4Ws1Jr6DxE8bQm2ToV9gHp3LkY5aZi" {
+		t.Errorf("DMUUID(boot-a) = %q", got)
+	}
+	meta, _ := vg.LV("pool_tmeta")
+	if _, err := vg.Targets(meta); err == nil {
+		t.Errorf("Targets(pool_tmeta) succeeded for a thin pool")
+	}
+
+	// Without pv1, only logical volumes on pv0 can be activated.
+	vgs = scan(devs[2:])
+	if m := vgs[0].Missing(); len(m) != 1 || m[0].Name != "pv1" {
+		t.Errorf("Missing = %v, want pv1", m)
+	}
+	if _, err := vgs[0].Targets(root); err == nil {
+		t.Errorf("Targets(root) succeeded without pv1")
+	}
+}
+
+func TestNoMetadataArea(t *testing.T) {
+	devs := []device{
+		{"/dev/sdb", bytes.NewReader(makePV(pv1UUID, "", 0))},
+		{"/dev/sda2", bytes.NewReader(makePV(pv0UUID, metadata(1), mdaHeaderSize))},
+	}
+	vgs := scan(devs)
+	if len(vgs) != 1 || len(vgs[0].Missing()) != 0 {
+		t.Errorf("scan = %+v, want one complete volume group", vgs)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lvm reads LVM2 physical volume labels and volume group metadata
+// and activates logical volumes with device-mapper.
+//
+// A physical volume starts with a label pointing to metadata areas, which
+// hold the text metadata of its volume group. The metadata maps the
+// extents of each logical volume to extents of physical volumes.
+package lvm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/mount/dm"
+)
+
+// VG is a volume group.
+type VG struct {
+	Name  string
+	UUID  string
+	SeqNo int64
+
+	// ExtentSize is the size of an extent in sectors.
+	ExtentSize uint64
+
+	Status []string
+
+	PVs []*PV
+	LVs []*LV
+}
+
+// PV is a physical volume of a volume group.
+type PV struct {
+	// Name is the name of the physical volume in the metadata, e.g. pv0.
+	Name string
+	UUID string
+
+	// DeviceHint is the device the physical volume was last seen on.
+	DeviceHint string
+
+	// PEStart is the first sector of the first extent, PECount the
+	// number of extents.
+	PEStart uint64
+	PECount uint64
+
+	// Device is where the physical volume was found by Scan, or empty
+	// if it is missing.
+	Device string
+}
+
+// LV is a logical volume.
+type LV struct {
+	Name     string
+	UUID     string
+	Status   []string
+	Segments []*Segment
+}
+
+// Segment maps a range of extents of a logical volume.
+type Segment struct {
+	StartExtent uint64
+	ExtentCount uint64
+
+	// Type is the segment type. Only striped segments, which include
+	// linear ones, can be activated.
+	Type string
+
+	// StripeSize is the stripe size in sectors, if there are several
+	// stripes.
+	StripeSize uint64
+
+	Stripes []Stripe
+}
+
+// Stripe is a range of extents on a physical volume.
+type Stripe struct {
+	PV     string
+	Extent uint64
+}
+
+func hasFlag(flags []string, f string) bool {
+	for _, s := range flags {
+		if s == f {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible reports whether the logical volume is user visible. Invisible
+// logical volumes are internal, like RAID images or thin pool metadata.
+func (lv *LV) Visible() bool {
+	return hasFlag(lv.Status, "VISIBLE")
+}
+
+// Writable reports whether the logical volume may be written.
+func (lv *LV) Writable() bool {
+	return hasFlag(lv.Status, "WRITE")
+}
+
+// Size returns the size of the logical volume in extents.
+func (lv *LV) Size() uint64 {
+	var n uint64
+	for _, s := range lv.Segments {
+		n += s.ExtentCount
+	}
+	return n
+}
+
+// ParseMetadata parses the text metadata of a volume group.
+func ParseMetadata(text string) (*VG, error) {
+	top, err := parseConfig(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(top.order) != 1 {
+		return nil, fmt.Errorf("want one volume group, got %d", len(top.order))
+	}
+	vg := &VG{Name: top.order[0]}
+	if err := vg.parse(top.sections[vg.Name]); err != nil {
+		return nil, fmt.Errorf("volume group %s: %v", vg.Name, err)
+	}
+	return vg, nil
+}
+
+func (vg *VG) parse(s *section) error {
+	var err error
+	if vg.UUID, err = s.str("id"); err != nil {
+		return err
+	}
+	if vg.SeqNo, err = s.int("seqno"); err != nil {
+		return err
+	}
+	es, err := s.int("extent_size")
+	if err != nil {
+		return err
+	}
+	if es <= 0 {
+		return fmt.Errorf("invalid extent size %d", es)
+	}
+	vg.ExtentSize = uint64(es)
+	vg.Status = s.strings("status")
+
+	if pvs := s.sections["physical_volumes"]; pvs != nil {
+		for _, name := range pvs.order {
+			pv, err := parsePV(name, pvs.sections[name])
+			if err != nil {
+				return fmt.Errorf("physical volume %s: %v", name, err)
+			}
+			vg.PVs = append(vg.PVs, pv)
+		}
+	}
+	if lvs := s.sections["logical_volumes"]; lvs != nil {
+		for _, name := range lvs.order {
+			lv, err := parseLV(name, lvs.sections[name])
+			if err != nil {
+				return fmt.Errorf("logical volume %s: %v", name, err)
+			}
+			vg.LVs = append(vg.LVs, lv)
+		}
+	}
+	return nil
+}
+
+func parsePV(name string, s *section) (*PV, error) {
+	pv := &PV{Name: name}
+	var err error
+	if pv.UUID, err = s.str("id"); err != nil {
+		return nil, err
+	}
+	pv.DeviceHint, _ = s.str("device")
+	start, err := s.int("pe_start")
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.int("pe_count")
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || count < 0 {
+		return nil, fmt.Errorf("invalid extents %d+%d", start, count)
+	}
+	pv.PEStart, pv.PECount = uint64(start), uint64(count)
+	return pv, nil
+}
+
+func parseLV(name string, s *section) (*LV, error) {
+	lv := &LV{Name: name, Status: s.strings("status")}
+	var err error
+	if lv.UUID, err = s.str("id"); err != nil {
+		return nil, err
+	}
+	for _, sn := range s.order {
+		if !strings.HasPrefix(sn, "segment") {
+			continue
+		}
+		seg, err := parseSegment(s.sections[sn])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", sn, err)
+		}
+		lv.Segments = append(lv.Segments, seg)
+	}
+	if n, err := s.int("segment_count"); err == nil && int(n) != len(lv.Segments) {
+		return nil, fmt.Errorf("%d segments, want %d", len(lv.Segments), n)
+	}
+	sort.Slice(lv.Segments, func(i, j int) bool { return lv.Segments[i].StartExtent < lv.Segments[j].StartExtent })
+	return lv, nil
+}
+
+func parseSegment(s *section) (*Segment, error) {
+	start, err := s.int("start_extent")
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.int("extent_count")
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || count <= 0 {
+		return nil, fmt.Errorf("invalid extents %d+%d", start, count)
+	}
+	seg := &Segment{StartExtent: uint64(start), ExtentCount: uint64(count)}
+	if seg.Type, err = s.str("type"); err != nil {
+		return nil, err
+	}
+	if seg.Type != "striped" {
+		return seg, nil
+	}
+
+	if n, err := s.int("stripe_size"); err == nil {
+		seg.StripeSize = uint64(n)
+	}
+	l := s.list("stripes")
+	if len(l) == 0 || len(l)%2 != 0 {
+		return nil, fmt.Errorf("invalid stripes %v", l)
+	}
+	for i := 0; i < len(l); i += 2 {
+		pv, ok1 := l[i].(string)
+		ext, ok2 := l[i+1].(int64)
+		if !ok1 || !ok2 || ext < 0 {
+			return nil, fmt.Errorf("invalid stripe %v, %v", l[i], l[i+1])
+		}
+		seg.Stripes = append(seg.Stripes, Stripe{PV: pv, Extent: uint64(ext)})
+	}
+	if n, err := s.int("stripe_count"); err == nil && int(n) != len(seg.Stripes) {
+		return nil, fmt.Errorf("%d stripes, want %d", len(seg.Stripes), n)
+	}
+	if len(seg.Stripes) > 1 && seg.StripeSize == 0 {
+		return nil, fmt.Errorf("%d stripes without stripe size", len(seg.Stripes))
+	}
+	return seg, nil
+}
+
+// LV returns the logical volume name.
+func (vg *VG) LV(name string) (*LV, error) {
+	for _, lv := range vg.LVs {
+		if lv.Name == name {
+			return lv, nil
+		}
+	}
+	return nil, fmt.Errorf("volume group %s has no logical volume %s", vg.Name, name)
+}
+
+func (vg *VG) pv(name string) *PV {
+	for _, pv := range vg.PVs {
+		if pv.Name == name {
+			return pv
+		}
+	}
+	return nil
+}
+
+// Missing returns the physical volumes that were not found.
+func (vg *VG) Missing() []*PV {
+	var m []*PV
+	for _, pv := range vg.PVs {
+		if pv.Device == "" {
+			m = append(m, pv)
+		}
+	}
+	return m
+}
+
+// DMName returns the device-mapper name of lv: the volume group and
+// logical volume names joined by a dash, with dashes in them doubled.
+func (vg *VG) DMName(lv *LV) string {
+	return strings.ReplaceAll(vg.Name, "-", "--") + "-" + strings.ReplaceAll(lv.Name, "-", "--")
+}
+
+// DMUUID returns the device-mapper UUID of lv.
+func (vg *VG) DMUUID(lv *LV) string {
+	return "LVM-" + strings.ReplaceAll(vg.UUID, "-", "") + strings.ReplaceAll(lv.UUID, "-", "")
+}
+
+// Targets returns the device-mapper table of lv.
+func (vg *VG) Targets(lv *LV) ([]dm.Target, error) {
+	var ts []dm.Target
+	var next uint64
+	for _, seg := range lv.Segments {
+		if seg.StartExtent != next {
+			return nil, fmt.Errorf("%s: gap before extent %d", lv.Name, seg.StartExtent)
+		}
+		next += seg.ExtentCount
+		if seg.Type != "striped" {
+			return nil, fmt.Errorf("%s: unsupported segment type %q", lv.Name, seg.Type)
+		}
+
+		var stripes []dm.Stripe
+		for _, st := range seg.Stripes {
+			pv := vg.pv(st.PV)
+			if pv == nil {
+				return nil, fmt.Errorf("%s: unknown physical volume %s", lv.Name, st.PV)
+			}
+			if pv.Device == "" {
+				return nil, fmt.Errorf("%s: physical volume %s (%s) is missing", lv.Name, pv.Name, pv.UUID)
+			}
+			if st.Extent+seg.ExtentCount/uint64(len(seg.Stripes)) > pv.PECount {
+				return nil, fmt.Errorf("%s: extents beyond the end of %s", lv.Name, pv.Name)
+			}
+			stripes = append(stripes, dm.Stripe{Device: pv.Device, Offset: pv.PEStart + st.Extent*vg.ExtentSize})
+		}
+
+		start, length := seg.StartExtent*vg.ExtentSize, seg.ExtentCount*vg.ExtentSize
+		if len(stripes) == 1 {
+			ts = append(ts, dm.Linear(start, length, stripes[0].Device, stripes[0].Offset))
+		} else {
+			ts = append(ts, dm.Striped(start, length, seg.StripeSize, stripes))
+		}
+	}
+	if len(ts) == 0 {
+		return nil, fmt.Errorf("%s has no segments", lv.Name)
+	}
+	return ts, nil
+}
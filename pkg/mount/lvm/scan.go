@@ -0,0 +1,70 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lvm
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// Debug function to override for verbose logging.
+var Debug = func(string, ...interface{}) {}
+
+// device is a device to scan.
+type device struct {
+	path string
+	r    io.ReaderAt
+}
+
+// scan finds the volume groups on devs. The newest metadata of each
+// volume group wins, and its physical volumes are matched to devices by
+// UUID.
+func scan(devs []device) []*VG {
+	pvs := map[string]string{}
+	vgs := map[string]*VG{}
+	for _, d := range devs {
+		l, err := ReadLabel(d.r)
+		if err != nil {
+			if err != ErrNoLabel {
+				Debug("lvm: %s: %v", d.path, err)
+			}
+			continue
+		}
+		if p, ok := pvs[l.UUID]; ok {
+			Debug("lvm: %s: duplicate physical volume %s, using %s", d.path, l.UUID, p)
+			continue
+		}
+		pvs[l.UUID] = d.path
+
+		// Physical volumes may have no metadata areas.
+		if len(l.MetadataAreas) == 0 {
+			continue
+		}
+		text, err := ReadMetadata(d.r, l)
+		if err != nil {
+			Debug("lvm: %s: %v", d.path, err)
+			continue
+		}
+		vg, err := ParseMetadata(text)
+		if err != nil {
+			Debug("lvm: %s: %v", d.path, err)
+			continue
+		}
+		if old, ok := vgs[vg.UUID]; !ok || vg.SeqNo > old.SeqNo {
+			vgs[vg.UUID] = vg
+		}
+	}
+
+	var l []*VG
+	for _, vg := range vgs {
+		for _, pv := range vg.PVs {
+			pv.Device = pvs[strings.ReplaceAll(pv.UUID, "-", "")]
+		}
+		l = append(l, vg)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
@@ -16,7 +16,9 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-const blocksize = 65536
+// blocksize is how much is read to find a magic. It covers the btrfs
+// superblock at 64 KiB.
+const blocksize = 65536 + 4096
 
 // These are inferred magic numbers from documents and partitions.
 // Ones known to work are first, followed by a gap, followed by not
@@ -28,6 +30,13 @@ var (
 	ISOFS    = []byte{1, 'C', 'D', '0', '0', '1'}
 	SQUASHFS = []byte{'h', 's', 'q', 's'}
 	XFS      = []byte{'X', 'F', 'S', 'B'}
+	// On-disk superblock magics, which differ from the statfs magics of
+	// the same file systems below.
+	BTRFSSB = []byte{'_', 'B', 'H', 'R', 'f', 'S', '_', 'M'}
+	EROFSSB = []byte{0xe2, 0xe1, 0xf5, 0xe0}
+	EXFATSB = []byte{'E', 'X', 'F', 'A', 'T', ' ', ' ', ' '}
+	F2FSSB  = []byte{0x10, 0x20, 0xf5, 0xf2}
+	NTFSSB  = []byte{'N', 'T', 'F', 'S', ' ', ' ', ' ', ' '}
 	// There's no fixed magic number for the different FAT varieties
 	// Usually they start with 0xEB but it's not mandatory.
 	// Therefore we just list a few examples that we have seen in the wild.
@@ -129,6 +138,13 @@ var magics = []magic{
 	{magic: VFAT, name: "vfat", off: 0},
 	{magic: VVFAT, name: "vfat", off: 0},
 	{magic: XFS, name: "xfs", off: 0},
+	{magic: BTRFSSB, name: "btrfs", off: 0x10040},
+	{magic: EROFSSB, name: "erofs", flags: MS_RDONLY, off: 1024},
+	{magic: F2FSSB, name: "f2fs", off: 1024},
+	{magic: EXFATSB, name: "exfat", off: 3},
+	// ntfs3 is the newer driver; try it first.
+	{magic: NTFSSB, name: "ntfs3", off: 3},
+	{magic: NTFSSB, name: "ntfs", off: 3},
 }
 
 var unknownMagics = []magic{
@@ -194,7 +210,7 @@ func FindMagics(blk []byte) []magic {
 // a map and return a bool, not an error, since there are so many bogus
 // block devices and we don't care about most of them.
 func FSFromBlock(n string) (fs string, flags uintptr, err error) {
-	// Make sure we can open, read 64k, stat it, find the magic in magics,
+	// Make sure we can open, read 68k, stat it, find the magic in magics,
 	// and find the file system it names.
 	f, err := os.Open(n)
 	if err != nil {
@@ -202,11 +218,13 @@ func FSFromBlock(n string) (fs string, flags uintptr, err error) {
 	}
 	defer f.Close()
 	block := make([]byte, blocksize)
-	if _, err := io.ReadAtLeast(f, block, len(block)); err != nil {
+	// Small devices can still have a magic near the start.
+	c, err := io.ReadFull(f, block)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		return "", 0, fmt.Errorf("no suitable filesystem for %q: %v", n, err)
 	}
 
-	magics := FindMagics(block)
+	magics := FindMagics(block[:c])
 	if len(magics) == 0 {
 		return "", 0, fmt.Errorf("no suitable filesystem for %q", n)
 	}
@@ -0,0 +1,158 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package md
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Debug function to override for verbose logging.
+var Debug = func(string, ...interface{}) {}
+
+// Member is a device with an md superblock.
+type Member struct {
+	Device string
+	*Superblock
+}
+
+// Array is the set of current members of an array.
+type Array struct {
+	// Superblock is the superblock of a current member.
+	*Superblock
+
+	// Members are the current members, ordered by role. Spares come
+	// last.
+	Members []Member
+
+	// Stale are members with outdated superblocks, e.g. ones that were
+	// missing while the array was written.
+	Stale []Member
+}
+
+// Active returns the number of members with an active role.
+func (a *Array) Active() int {
+	n := 0
+	for _, m := range a.Members {
+		if m.Role >= 0 && m.Role < a.RaidDisks {
+			n++
+		}
+	}
+	return n
+}
+
+// Degraded reports whether active members are missing.
+func (a *Array) Degraded() bool {
+	return a.Active() < a.RaidDisks
+}
+
+// Runnable reports whether the array can run with its current members.
+func (a *Array) Runnable() bool {
+	missing := a.RaidDisks - a.Active()
+	switch a.Level {
+	case 1:
+		return missing < a.RaidDisks
+	case 4, 5:
+		return missing <= 1
+	case 6:
+		return missing <= 2
+	case 10:
+		// The near layout keeps copies in adjacent slots; any copy
+		// of each chunk is enough.
+		near := a.Layout & 0xff
+		if near < 2 || a.Layout>>8 != 1 {
+			return missing == 0
+		}
+		have := map[int]bool{}
+		for _, m := range a.Members {
+			if m.Role >= 0 && m.Role < a.RaidDisks {
+				have[m.Role] = true
+			}
+		}
+		for i := 0; i < a.RaidDisks; i += near {
+			ok := false
+			for j := i; j < i+near && j < a.RaidDisks; j++ {
+				ok = ok || have[j]
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	default:
+		return missing == 0
+	}
+}
+
+// ShortName returns the array's name: its version 1 name without the host,
+// or its preferred minor for version 0.90.
+func (a *Array) ShortName() string {
+	if a.Version == "0.90" {
+		return fmt.Sprint(a.PreferredMinor)
+	}
+	if i := strings.IndexByte(a.Name, ':'); i >= 0 {
+		return a.Name[i+1:]
+	}
+	return a.Name
+}
+
+// Group groups members into arrays by UUID. Like mdadm, members more than
+// one event behind the newest member of their array are stale. Arrays are
+// ordered by UUID.
+func Group(members []Member) []*Array {
+	byUUID := map[[16]byte][]Member{}
+	for _, m := range members {
+		byUUID[m.UUID] = append(byUUID[m.UUID], m)
+	}
+	var arrays []*Array
+	for _, ms := range byUUID {
+		sort.SliceStable(ms, func(i, j int) bool { return ms[i].Events > ms[j].Events })
+		a := &Array{Superblock: ms[0].Superblock}
+		for _, m := range ms {
+			if m.Events+1 >= a.Events {
+				a.Members = append(a.Members, m)
+			} else {
+				a.Stale = append(a.Stale, m)
+			}
+		}
+		sort.SliceStable(a.Members, func(i, j int) bool {
+			ri, rj := a.Members[i].Role, a.Members[j].Role
+			if (ri < 0) != (rj < 0) {
+				return rj < 0
+			}
+			return ri < rj
+		})
+		arrays = append(arrays, a)
+	}
+	sort.Slice(arrays, func(i, j int) bool {
+		return strings.Compare(string(arrays[i].UUID[:]), string(arrays[j].UUID[:])) < 0
+	})
+	return arrays
+}
+
+// device is a device to scan.
+type device struct {
+	path string
+	r    io.ReaderAt
+	size int64
+}
+
+// scan reads the superblocks of devs.
+func scan(devs []device) []Member {
+	var ms []Member
+	for _, d := range devs {
+		s, err := ReadSuperblock(d.r, d.size)
+		if err != nil {
+			if err != ErrNoSuperblock {
+				Debug("md: %s: %v", d.path, err)
+			}
+			continue
+		}
+		ms = append(ms, Member{Device: d.path, Superblock: s})
+	}
+	return ms
+}
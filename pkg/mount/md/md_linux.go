@@ -0,0 +1,283 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package md
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/u-root/u-root/pkg/mount/block"
+	"golang.org/x/sys/unix"
+)
+
+// Major is the block device major of md devices.
+const Major = 9
+
+// ioctls, from include/uapi/linux/raid/md_u.h.
+const (
+	getArrayInfo = 0x80480911 // _IOR(MD_MAJOR, 0x11, mdu_array_info_t)
+	getDiskInfo  = 0x80140912 // _IOR(MD_MAJOR, 0x12, mdu_disk_info_t)
+	addNewDisk   = 0x40140921 // _IOW(MD_MAJOR, 0x21, mdu_disk_info_t)
+	setArrayInfo = 0x40480923 // _IOW(MD_MAJOR, 0x23, mdu_array_info_t)
+	runArray     = 0x400c0930 // _IOW(MD_MAJOR, 0x30, mdu_param_t)
+	stopArray    = 0x00000932 // _IO(MD_MAJOR, 0x32)
+)
+
+// ArrayInfo is mdu_array_info_t.
+type ArrayInfo struct {
+	MajorVersion  int32
+	MinorVersion  int32
+	PatchVersion  int32
+	CTime         int32
+	Level         int32
+	Size          int32
+	NrDisks       int32
+	RaidDisks     int32
+	MDMinor       int32
+	NotPersistent int32
+	UTime         int32
+	State         int32
+	ActiveDisks   int32
+	WorkingDisks  int32
+	FailedDisks   int32
+	SpareDisks    int32
+	Layout        int32
+	ChunkSize     int32
+}
+
+// DiskInfo is mdu_disk_info_t.
+type DiskInfo struct {
+	Number   int32
+	Major    int32
+	Minor    int32
+	RaidDisk int32
+	State    int32
+}
+
+// Faulty reports whether the disk failed.
+func (d *DiskInfo) Faulty() bool { return d.State&diskFaulty != 0 }
+
+// Active reports whether the disk is an active member.
+func (d *DiskInfo) Active() bool { return d.State&diskActive != 0 }
+
+// Sync reports whether the disk is in sync with the array.
+func (d *DiskInfo) Sync() bool { return d.State&diskSync != 0 }
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ScanDevices reads the md superblocks of the devices at paths.
+func ScanDevices(paths []string) []Member {
+	var devs []device
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			Debug("md: %v", err)
+			continue
+		}
+		defer f.Close()
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			Debug("md: %s: %v", p, err)
+			continue
+		}
+		devs = append(devs, device{path: p, r: f, size: size})
+	}
+	return scan(devs)
+}
+
+// Scan reads the md superblocks of all block devices but md devices.
+func Scan() ([]Member, error) {
+	bs, err := block.GetBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, b := range bs {
+		if !strings.HasPrefix(b.Name, "md") {
+			paths = append(paths, b.DevicePath())
+		}
+	}
+	return ScanDevices(paths), nil
+}
+
+// inUse reports whether /dev/md<minor> exists as an array.
+func inUse(minor int) bool {
+	_, err := os.Stat(fmt.Sprintf("/sys/block/md%d/md", minor))
+	return err == nil
+}
+
+// DevicePath returns the md device to assemble a as: its preferred minor
+// for version 0.90 arrays, the first free minor from 127 down otherwise.
+func (a *Array) DevicePath() (string, error) {
+	if a.PreferredMinor >= 0 && !inUse(a.PreferredMinor) {
+		return fmt.Sprintf("/dev/md%d", a.PreferredMinor), nil
+	}
+	for m := 127; m >= 0; m-- {
+		if !inUse(m) {
+			return fmt.Sprintf("/dev/md%d", m), nil
+		}
+	}
+	return "", fmt.Errorf("no free md device")
+}
+
+// Assemble assembles a as the md device path, e.g. /dev/md0, and starts
+// it. If path is empty, DevicePath is used. Version 1 arrays with a name
+// are linked as /dev/md/NAME.
+func (a *Array) Assemble(path string) (string, error) {
+	if !a.Runnable() {
+		return "", fmt.Errorf("array %s: %d of %d devices are not enough to start", a.UUIDString(), a.Active(), a.RaidDisks)
+	}
+	if path == "" {
+		var err error
+		if path, err = a.DevicePath(); err != nil {
+			return "", err
+		}
+	}
+	var minor int
+	if _, err := fmt.Sscanf(filepath.Base(path), "md%d", &minor); err != nil {
+		return "", fmt.Errorf("%s is not an md device", path)
+	}
+	if err := unix.Mknod(path, unix.S_IFBLK|0o600, int(unix.Mkdev(Major, uint32(minor)))); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info := ArrayInfo{MajorVersion: 0, MinorVersion: 90}
+	if a.Version != "0.90" {
+		info.MajorVersion = 1
+		fmt.Sscanf(a.Version, "1.%d", &info.MinorVersion)
+	}
+	if err := ioctl(f, setArrayInfo, unsafe.Pointer(&info)); err != nil {
+		return "", fmt.Errorf("%s: SET_ARRAY_INFO: %v", path, err)
+	}
+	for _, m := range a.Members {
+		var st unix.Stat_t
+		if err := unix.Stat(m.Device, &st); err != nil {
+			return "", err
+		}
+		d := DiskInfo{
+			Number:   int32(m.Number),
+			Major:    int32(unix.Major(uint64(st.Rdev))),
+			Minor:    int32(unix.Minor(uint64(st.Rdev))),
+			RaidDisk: int32(m.Role),
+		}
+		if m.Role >= 0 {
+			d.State = diskActive | diskSync
+		}
+		if err := ioctl(f, addNewDisk, unsafe.Pointer(&d)); err != nil {
+			ioctl(f, stopArray, nil)
+			return "", fmt.Errorf("%s: adding %s: %v", path, m.Device, err)
+		}
+	}
+	if err := ioctl(f, runArray, nil); err != nil {
+		ioctl(f, stopArray, nil)
+		return "", fmt.Errorf("%s: RUN_ARRAY: %v", path, err)
+	}
+
+	if n := a.ShortName(); a.Version != "0.90" && n != "" {
+		link := filepath.Join("/dev/md", n)
+		if err := os.MkdirAll(filepath.Dir(link), 0o755); err == nil {
+			os.Remove(link)
+			os.Symlink(path, link)
+		}
+	}
+	return path, nil
+}
+
+// Stop stops the md device at path.
+func Stop(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := ioctl(f, stopArray, nil); err != nil {
+		return fmt.Errorf("%s: STOP_ARRAY: %v", path, err)
+	}
+	return nil
+}
+
+// Detail returns the array information and disks of the md device at
+// path.
+func Detail(path string) (*ArrayInfo, []DiskInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var info ArrayInfo
+	if err := ioctl(f, getArrayInfo, unsafe.Pointer(&info)); err != nil {
+		return nil, nil, fmt.Errorf("%s: GET_ARRAY_INFO: %v", path, err)
+	}
+	var disks []DiskInfo
+	for i := int32(0); len(disks) < int(info.NrDisks) && i < sb1MaxDev; i++ {
+		d := DiskInfo{Number: i}
+		if err := ioctl(f, getDiskInfo, unsafe.Pointer(&d)); err != nil {
+			return nil, nil, fmt.Errorf("%s: GET_DISK_INFO: %v", path, err)
+		}
+		if d.Major != 0 || d.Minor != 0 {
+			disks = append(disks, d)
+		}
+	}
+	return &info, disks, nil
+}
+
+// DeviceName returns the /dev path of the block device major:minor.
+func DeviceName(major, minor int32) string {
+	p, err := os.Readlink(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return fmt.Sprintf("%d:%d", major, minor)
+	}
+	return filepath.Join("/dev", filepath.Base(p))
+}
+
+// running reports whether an md device holds any member of a.
+func (a *Array) running() bool {
+	for _, m := range a.Members {
+		hs, _ := filepath.Glob(filepath.Join("/sys/class/block", filepath.Base(m.Device), "holders", "md*"))
+		if len(hs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// AssembleAll assembles all arrays found on block devices that are not
+// running yet. It returns the md devices and the first error.
+func AssembleAll() ([]string, error) {
+	ms, err := Scan()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	var firstErr error
+	for _, a := range Group(ms) {
+		if a.running() {
+			continue
+		}
+		p, err := a.Assemble("")
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return paths, firstErr
+}
@@ -0,0 +1,252 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+const devSize = 1 << 20
+
+var arrayUUID = [16]byte{0x6f, 0x2a, 0x1b, 0x3c, 0x4d, 0x5e, 0x6f, 0x70, 0x81, 0x92, 0xa3, 0xb4, 0xc5, 0xd6, 0xe7, 0xf8}
+
+// makeSB1 returns a device with a version 1 superblock at off, for the
+// member with role in a RAID1 of two.
+func makeSB1(off int64, number int, role uint16, events uint64) []byte {
+	b := make([]byte, devSize)
+	s := b[off:]
+	binary.LittleEndian.PutUint32(s, magic)
+	binary.LittleEndian.PutUint32(s[4:], 1)
+	copy(s[16:], arrayUUID[:])
+	copy(s[32:], "builder:boot")
+	binary.LittleEndian.PutUint64(s[64:], 1617187200)
+	binary.LittleEndian.PutUint32(s[72:], 1)
+	binary.LittleEndian.PutUint64(s[80:], 1024)
+	binary.LittleEndian.PutUint32(s[92:], 2)
+	binary.LittleEndian.PutUint64(s[128:], 2048)
+	binary.LittleEndian.PutUint64(s[144:], uint64(off/512))
+	binary.LittleEndian.PutUint32(s[160:], uint32(number))
+	s[168] = byte(number)
+	binary.LittleEndian.PutUint64(s[192:], 1617190000)
+	binary.LittleEndian.PutUint64(s[200:], events)
+	binary.LittleEndian.PutUint64(s[208:], ^uint64(0))
+	binary.LittleEndian.PutUint32(s[220:], 3)
+	for i := 0; i < 3; i++ {
+		binary.LittleEndian.PutUint16(s[256+2*i:], 0xffff)
+	}
+	binary.LittleEndian.PutUint16(s[256+2*number:], role)
+	binary.LittleEndian.PutUint32(s[216:], csum1(s[:262]))
+	return b
+}
+
+// makeSB0 returns a device with a version 0.90 superblock for the member
+// in slot raidDisk of a RAID5 of three.
+func makeSB0(raidDisk uint32, state uint32) []byte {
+	b := make([]byte, devSize)
+	s := b[sb0Offset(devSize):]
+	w := func(word int, v uint32) { ubinary.NativeEndian.PutUint32(s[4*word:], v) }
+	w(0, magic)
+	w(2, 90)
+	w(5, 0x01020304)
+	w(6, 1617187200)
+	w(7, 5)
+	w(8, 512)
+	w(9, 3)
+	w(10, 3)
+	w(11, 3)
+	w(13, 0x05060708)
+	w(14, 0x090a0b0c)
+	w(15, 0x0d0e0f10)
+	w(32, 1617190000)
+	w(33, 1)
+	ubinary.NativeEndian.PutUint64(s[39*4:], 42)
+	w(64, 2)
+	w(65, 64<<10)
+	w(992, raidDisk)
+	w(995, raidDisk)
+	w(996, state)
+	w(38, csum0(s[:sb0Size]))
+	return b
+}
+
+func TestSB1(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		off     int64
+	}{
+		{"1.2", 4096},
+		{"1.1", 0},
+		{"1.0", 1040384},
+	} {
+		s, err := ReadSuperblock(bytes.NewReader(makeSB1(tt.off, 1, 1, 7)), devSize)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.version, err)
+		}
+		want := &Superblock{
+			Version:        tt.version,
+			UUID:           arrayUUID,
+			Name:           "builder:boot",
+			Level:          1,
+			RaidDisks:      2,
+			Size:           1024,
+			Events:         7,
+			CTime:          time.Unix(1617187200, 0),
+			UTime:          time.Unix(1617190000, 0),
+			Clean:          true,
+			Number:         1,
+			Role:           1,
+			DeviceUUID:     [16]byte{1},
+			DataOffset:     2048,
+			PreferredMinor: -1,
+			Offset:         tt.off,
+		}
+		if *s != *want {
+			t.Errorf("%s: ReadSuperblock = %+v, want %+v", tt.version, s, want)
+		}
+	}
+
+	s, err := ReadSuperblock(bytes.NewReader(makeSB1(4096, 2, 0xffff, 7)), devSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Role != RoleSpare || s.UUIDString() != "6f2a1b3c:4d5e6f70:8192a3b4:c5d6e7f8" || s.LevelString() != "raid1" {
+		t.Errorf("spare: role %d, UUID %s, level %s", s.Role, s.UUIDString(), s.LevelString())
+	}
+
+	b := makeSB1(4096, 0, 0, 7)
+	b[4096+40] ^= 1
+	if _, err := ReadSuperblock(bytes.NewReader(b), devSize); err == nil || errors.Is(err, ErrNoSuperblock) {
+		t.Errorf("ReadSuperblock(bad checksum) = %v, want checksum error", err)
+	}
+	if _, err := ReadSuperblock(bytes.NewReader(make([]byte, devSize)), devSize); err != ErrNoSuperblock {
+		t.Errorf("ReadSuperblock(zeros) = %v, want %v", err, ErrNoSuperblock)
+	}
+}
+
+func TestSB0(t *testing.T) {
+	s, err := ReadSuperblock(bytes.NewReader(makeSB0(2, diskActive|diskSync)), devSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Superblock{
+		Version:        "0.90",
+		UUID:           [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Level:          5,
+		Layout:         2,
+		ChunkSize:      64 << 10,
+		RaidDisks:      3,
+		Size:           1024,
+		Events:         42,
+		CTime:          time.Unix(1617187200, 0),
+		UTime:          time.Unix(1617190000, 0),
+		Clean:          true,
+		Number:         2,
+		Role:           2,
+		PreferredMinor: 3,
+		Offset:         983040,
+	}
+	if *s != *want {
+		t.Errorf("ReadSuperblock = %+v, want %+v", s, want)
+	}
+
+	for _, tt := range []struct {
+		state uint32
+		role  int
+	}{
+		{diskFaulty, RoleFaulty},
+		{0, RoleSpare},
+	} {
+		s, err := ReadSuperblock(bytes.NewReader(makeSB0(1, tt.state)), devSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Role != tt.role {
+			t.Errorf("state %#x: role %d, want %d", tt.state, s.Role, tt.role)
+		}
+	}
+
+	b := makeSB0(0, diskActive|diskSync)
+	b[983040+4*8] ^= 1
+	if _, err := ReadSuperblock(bytes.NewReader(b), devSize); err == nil {
+		t.Errorf("ReadSuperblock succeeded with a bad checksum")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	dev := func(path string, b []byte) device {
+		return device{path: path, r: bytes.NewReader(b), size: devSize}
+	}
+	ms := scan([]device{
+		dev("/dev/sdb1", makeSB1(4096, 1, 1, 10)),
+		dev("/dev/sdc1", makeSB1(4096, 2, 0xffff, 10)),
+		dev("/dev/sda1", makeSB1(4096, 0, 0, 9)),
+		dev("/dev/sdd", make([]byte, devSize)),
+		dev("/dev/sde", makeSB0(0, diskActive|diskSync)),
+		dev("/dev/sdf", makeSB0(1, diskActive|diskSync)),
+	})
+	if len(ms) != 5 {
+		t.Fatalf("scan found %d members, want 5", len(ms))
+	}
+	arrays := Group(ms)
+	if len(arrays) != 2 {
+		t.Fatalf("Group = %d arrays, want 2", len(arrays))
+	}
+
+	r5, r1 := arrays[0], arrays[1]
+	var got []string
+	for _, m := range r1.Members {
+		got = append(got, m.Device)
+	}
+	if len(got) != 3 || got[0] != "/dev/sda1" || got[1] != "/dev/sdb1" || got[2] != "/dev/sdc1" {
+		t.Errorf("RAID1 members = %v, want sda1, sdb1, sdc1", got)
+	}
+	if r1.Events != 10 || r1.Degraded() || !r1.Runnable() || r1.ShortName() != "boot" {
+		t.Errorf("RAID1: events %d, degraded %v, runnable %v, name %q", r1.Events, r1.Degraded(), r1.Runnable(), r1.ShortName())
+	}
+
+	if !r5.Degraded() || !r5.Runnable() || r5.ShortName() != "3" {
+		t.Errorf("RAID5: degraded %v, runnable %v, name %q", r5.Degraded(), r5.Runnable(), r5.ShortName())
+	}
+
+	// A member two events behind is stale.
+	arrays = Group(scan([]device{
+		dev("/dev/sda1", makeSB1(4096, 0, 0, 8)),
+		dev("/dev/sdb1", makeSB1(4096, 1, 1, 10)),
+	}))
+	if a := arrays[0]; len(a.Members) != 1 || len(a.Stale) != 1 || a.Stale[0].Device != "/dev/sda1" {
+		t.Errorf("members %v, stale %v, want sda1 stale", a.Members, a.Stale)
+	}
+}
+
+func TestRunnable(t *testing.T) {
+	for _, tt := range []struct {
+		level, layout, disks int
+		roles                []int
+		want                 bool
+	}{
+		{1, 0, 2, []int{1}, true},
+		{1, 0, 2, []int{RoleSpare}, false},
+		{0, 0, 2, []int{0}, false},
+		{5, 2, 3, []int{0, 2}, true},
+		{5, 2, 3, []int{0}, false},
+		{6, 2, 4, []int{1, 3}, true},
+		{10, 0x102, 4, []int{1, 2}, true},
+		{10, 0x102, 4, []int{2, 3}, false},
+	} {
+		a := &Array{Superblock: &Superblock{Level: tt.level, Layout: tt.layout, RaidDisks: tt.disks}}
+		for _, r := range tt.roles {
+			a.Members = append(a.Members, Member{Superblock: &Superblock{Role: r}})
+		}
+		if got := a.Runnable(); got != tt.want {
+			t.Errorf("raid%d with roles %v: Runnable = %v, want %v", tt.level, tt.roles, got, tt.want)
+		}
+	}
+}
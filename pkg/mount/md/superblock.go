@@ -0,0 +1,432 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package md reads Linux software RAID (md) superblocks and assembles
+// arrays from their members.
+//
+// Members carry a version 0.90 superblock at the end of the device, or a
+// version 1.x superblock at the end (1.0), start (1.1) or 4 KiB from the
+// start (1.2) of the device.
+package md
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+// ErrNoSuperblock is returned for devices that are not md members.
+var ErrNoSuperblock = errors.New("no md superblock")
+
+const (
+	magic = 0xa92b4efc
+
+	sectorSize = 512
+
+	// Version 0.90 superblocks are 4 KiB in the last 64 KiB aligned
+	// 64 KiB of the device.
+	sb0Size    = 4096
+	sb0Reserve = 64 << 10
+	sb0Disks   = 27
+
+	// Version 1 superblocks have 256 fixed bytes followed by the
+	// roles of max_dev devices.
+	sb1Size   = 256
+	sb1MaxDev = 1920
+)
+
+// Special roles of version 1 members.
+const (
+	RoleSpare   = -1
+	RoleFaulty  = -2
+	RoleJournal = -3
+)
+
+// Disk states of version 0.90 members.
+const (
+	diskFaulty = 1 << 0
+	diskActive = 1 << 1
+	diskSync   = 1 << 2
+)
+
+// Superblock is the md superblock of an array member.
+type Superblock struct {
+	// Version is 0.90, 1.0, 1.1 or 1.2.
+	Version string
+
+	// UUID identifies the array.
+	UUID [16]byte
+
+	// Name is the array name of version 1 superblocks, e.g. host:boot.
+	Name string
+
+	// Level is the RAID level, -1 for linear.
+	Level     int
+	Layout    int
+	ChunkSize int
+
+	// RaidDisks is the number of active devices of the array.
+	RaidDisks int
+
+	// Size is the size of the data of each device in sectors.
+	Size uint64
+
+	// Events counts superblock updates; stale members have fewer.
+	Events uint64
+
+	CTime time.Time
+	UTime time.Time
+
+	// Clean reports whether the array was shut down cleanly.
+	Clean bool
+
+	// Number is the member's index in the superblock's device table.
+	Number int
+
+	// Role is the member's slot in the array, or a Role constant.
+	Role int
+
+	// DeviceUUID identifies version 1 members.
+	DeviceUUID [16]byte
+
+	// DataOffset is the offset of the data on the member in sectors.
+	DataOffset uint64
+
+	// PreferredMinor is the md device minor of version 0.90 arrays,
+	// or -1.
+	PreferredMinor int
+
+	// Offset is where the superblock is, in bytes.
+	Offset int64
+}
+
+// UUIDString formats the array UUID like mdadm.
+func (s *Superblock) UUIDString() string {
+	return formatUUID(s.UUID)
+}
+
+func formatUUID(u [16]byte) string {
+	h := hex.EncodeToString(u[:])
+	return strings.Join([]string{h[:8], h[8:16], h[16:24], h[24:]}, ":")
+}
+
+// LevelString returns the RAID level like mdadm, e.g. raid1.
+func (s *Superblock) LevelString() string {
+	switch s.Level {
+	case -1:
+		return "linear"
+	case -4:
+		return "multipath"
+	case -5:
+		return "faulty"
+	default:
+		return fmt.Sprintf("raid%d", s.Level)
+	}
+}
+
+// ReadSuperblock reads the superblock of the member r of size bytes. It
+// tries version 1.2, 1.1, 1.0 and 0.90 in turn.
+func ReadSuperblock(r io.ReaderAt, size int64) (*Superblock, error) {
+	var errs []string
+	for _, v := range []struct {
+		version string
+		off     int64
+	}{
+		{"1.2", 8 * sectorSize},
+		{"1.1", 0},
+		{"1.0", sb1EndOffset(size)},
+	} {
+		if v.off < 0 {
+			continue
+		}
+		s, err := readSB1(r, v.off, v.version)
+		if err == nil {
+			return s, nil
+		}
+		if err != ErrNoSuperblock {
+			errs = append(errs, fmt.Sprintf("%s: %v", v.version, err))
+		}
+	}
+	if off := sb0Offset(size); off >= 0 {
+		s, err := readSB0(r, off)
+		if err == nil {
+			return s, nil
+		}
+		if err != ErrNoSuperblock {
+			errs = append(errs, fmt.Sprintf("0.90: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid md superblock: %s", strings.Join(errs, "; "))
+	}
+	return nil, ErrNoSuperblock
+}
+
+// sb0Offset is where a version 0.90 superblock is on a device of size
+// bytes, or -1 if it is too small.
+func sb0Offset(size int64) int64 {
+	return size&^(sb0Reserve-1) - sb0Reserve
+}
+
+// sb1EndOffset is where a version 1.0 superblock is: at least 8 KiB from
+// the end, 4 KiB aligned.
+func sb1EndOffset(size int64) int64 {
+	return (size/sectorSize - 8*2) &^ (4*2 - 1) * sectorSize
+}
+
+// sb0 is mdp_superblock_t, in host byte order.
+type sb0 struct {
+	// Constant generic information.
+	Magic         uint32
+	MajorVersion  uint32
+	MinorVersion  uint32
+	PatchVersion  uint32
+	GValidWords   uint32
+	SetUUID0      uint32
+	CTime         uint32
+	Level         int32
+	Size          uint32
+	NrDisks       uint32
+	RaidDisks     uint32
+	MDMinor       uint32
+	NotPersistent uint32
+	SetUUID1      uint32
+	SetUUID2      uint32
+	SetUUID3      uint32
+	_             [16]uint32
+
+	// Generic state information.
+	UTime        uint32
+	State        uint32
+	ActiveDisks  uint32
+	WorkingDisks uint32
+	FailedDisks  uint32
+	SpareDisks   uint32
+	Checksum     uint32
+	Events       uint64
+	CPEvents     uint64
+	RecoveryCP   uint32
+	ReshapePos   uint64
+	_            [18]uint32
+
+	// Personality information.
+	Layout    uint32
+	ChunkSize uint32
+	_         [62]uint32
+
+	Disks    [sb0Disks]sb0Disk
+	ThisDisk sb0Disk
+}
+
+// sb0Disk is mdp_disk_t.
+type sb0Disk struct {
+	Number   uint32
+	Major    uint32
+	Minor    uint32
+	RaidDisk uint32
+	State    uint32
+	_        [27]uint32
+}
+
+// csum0 is the 0.90 checksum: the sum of the superblock's words, with the
+// carry folded in.
+func csum0(b []byte) uint32 {
+	var sum uint64
+	for i := 0; i < sb0Size; i += 4 {
+		sum += uint64(ubinary.NativeEndian.Uint32(b[i:]))
+	}
+	return uint32(sum) + uint32(sum>>32)
+}
+
+// sb0ChecksumOffset is the offset of sb0.Checksum.
+const sb0ChecksumOffset = 38 * 4
+
+func readSB0(r io.ReaderAt, off int64) (*Superblock, error) {
+	b := make([]byte, sb0Size)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	if ubinary.NativeEndian.Uint32(b) != magic {
+		return nil, ErrNoSuperblock
+	}
+	var s sb0
+	if err := binary.Read(strings.NewReader(string(b)), ubinary.NativeEndian, &s); err != nil {
+		return nil, err
+	}
+	if s.MajorVersion != 0 || s.MinorVersion != 90 {
+		return nil, fmt.Errorf("unsupported version %d.%d", s.MajorVersion, s.MinorVersion)
+	}
+	ubinary.NativeEndian.PutUint32(b[sb0ChecksumOffset:], 0)
+	if got := csum0(b); got != s.Checksum {
+		return nil, fmt.Errorf("checksum %#x, want %#x", got, s.Checksum)
+	}
+
+	sb := &Superblock{
+		Version:        "0.90",
+		Level:          int(s.Level),
+		Layout:         int(s.Layout),
+		ChunkSize:      int(s.ChunkSize),
+		RaidDisks:      int(s.RaidDisks),
+		Size:           uint64(s.Size) * 2,
+		Events:         s.Events,
+		CTime:          time.Unix(int64(s.CTime), 0),
+		UTime:          time.Unix(int64(s.UTime), 0),
+		Clean:          s.State&1 != 0,
+		Number:         int(s.ThisDisk.Number),
+		Role:           int(s.ThisDisk.RaidDisk),
+		PreferredMinor: int(s.MDMinor),
+		Offset:         off,
+	}
+	for i, w := range []uint32{s.SetUUID0, s.SetUUID1, s.SetUUID2, s.SetUUID3} {
+		binary.BigEndian.PutUint32(sb.UUID[4*i:], w)
+	}
+	switch st := s.ThisDisk.State; {
+	case st&diskFaulty != 0:
+		sb.Role = RoleFaulty
+	case st&diskSync == 0 || int(s.ThisDisk.RaidDisk) >= sb.RaidDisks:
+		sb.Role = RoleSpare
+	}
+	return sb, nil
+}
+
+// sb1 is struct mdp_superblock_1, little endian.
+type sb1 struct {
+	Magic          uint32
+	MajorVersion   uint32
+	FeatureMap     uint32
+	_              uint32
+	SetUUID        [16]byte
+	SetName        [32]byte
+	CTime          uint64
+	Level          int32
+	Layout         uint32
+	Size           uint64
+	ChunkSize      uint32
+	RaidDisks      uint32
+	BitmapOffset   uint32
+	NewLevel       uint32
+	ReshapePos     uint64
+	DeltaDisks     uint32
+	NewLayout      uint32
+	NewChunk       uint32
+	NewOffset      uint32
+	DataOffset     uint64
+	DataSize       uint64
+	SuperOffset    uint64
+	RecoveryOffset uint64
+	DevNumber      uint32
+	CorrectedReads uint32
+	DeviceUUID     [16]byte
+	DevFlags       uint8
+	BBLogShift     uint8
+	BBLogSize      uint16
+	BBLogOffset    uint32
+	UTime          uint64
+	Events         uint64
+	ResyncOffset   uint64
+	Checksum       uint32
+	MaxDev         uint32
+	_              [32]byte
+}
+
+// sb1ChecksumOffset is the offset of sb1.Checksum.
+const sb1ChecksumOffset = 216
+
+// csum1 is the version 1 checksum over the superblock and roles in b.
+func csum1(b []byte) uint32 {
+	var sum uint64
+	i := 0
+	for ; i+4 <= len(b); i += 4 {
+		sum += uint64(binary.LittleEndian.Uint32(b[i:]))
+	}
+	if i+2 <= len(b) {
+		sum += uint64(binary.LittleEndian.Uint16(b[i:]))
+	}
+	return uint32(sum) + uint32(sum>>32)
+}
+
+// sb1Time converts version 1 times: seconds in the low 40 bits.
+func sb1Time(t uint64) time.Time {
+	return time.Unix(int64(t&(1<<40-1)), 0)
+}
+
+func readSB1(r io.ReaderAt, off int64, version string) (*Superblock, error) {
+	b := make([]byte, sb1Size)
+	if _, err := r.ReadAt(b, off); err != nil {
+		if err == io.EOF {
+			return nil, ErrNoSuperblock
+		}
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(b) != magic {
+		return nil, ErrNoSuperblock
+	}
+	var s sb1
+	if err := binary.Read(strings.NewReader(string(b)), binary.LittleEndian, &s); err != nil {
+		return nil, err
+	}
+	if s.MajorVersion != 1 {
+		return nil, fmt.Errorf("unsupported major version %d", s.MajorVersion)
+	}
+	if s.SuperOffset != uint64(off/sectorSize) {
+		// Another version's superblock, e.g. 1.0 on a partition at
+		// the end of a 1.0 member disk.
+		return nil, ErrNoSuperblock
+	}
+	if s.MaxDev > sb1MaxDev {
+		return nil, fmt.Errorf("invalid max_dev %d", s.MaxDev)
+	}
+	full := make([]byte, sb1Size+2*int(s.MaxDev))
+	if _, err := r.ReadAt(full, off); err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(full[sb1ChecksumOffset:], 0)
+	if got := csum1(full); got != s.Checksum {
+		return nil, fmt.Errorf("checksum %#x, want %#x", got, s.Checksum)
+	}
+
+	name := string(s.SetName[:])
+	if i := strings.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	sb := &Superblock{
+		Version:        version,
+		UUID:           s.SetUUID,
+		Name:           name,
+		Level:          int(s.Level),
+		Layout:         int(s.Layout),
+		ChunkSize:      int(s.ChunkSize) * sectorSize,
+		RaidDisks:      int(s.RaidDisks),
+		Size:           s.Size,
+		Events:         s.Events,
+		CTime:          sb1Time(s.CTime),
+		UTime:          sb1Time(s.UTime),
+		Clean:          s.ResyncOffset == ^uint64(0),
+		Number:         int(s.DevNumber),
+		Role:           RoleSpare,
+		DeviceUUID:     s.DeviceUUID,
+		DataOffset:     s.DataOffset,
+		PreferredMinor: -1,
+		Offset:         off,
+	}
+	if s.DevNumber < s.MaxDev {
+		switch role := binary.LittleEndian.Uint16(full[sb1Size+2*s.DevNumber:]); role {
+		case 0xffff:
+			sb.Role = RoleSpare
+		case 0xfffe:
+			sb.Role = RoleFaulty
+		case 0xfffd:
+			sb.Role = RoleJournal
+		default:
+			sb.Role = int(role)
+		}
+	}
+	return sb, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvme
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Controller is the Identify Controller data structure.
+type Controller struct {
+	VendorID          uint16
+	SubsystemVendorID uint16
+	Serial            string
+	Model             string
+	FirmwareRevision  string
+	ControllerID      uint16
+
+	// Version is the NVMe version, e.g. 0x10400 for 1.4.
+	Version uint32
+
+	// OACS is the Optional Admin Command Support bit mask.
+	OACS uint16
+
+	// FNA is the Format NVM Attributes bit mask.
+	FNA uint8
+
+	// SANICAP is the Sanitize Capabilities bit mask.
+	SANICAP uint32
+
+	NumNamespaces       uint32
+	TotalCapacity       uint64
+	UnallocatedCapacity uint64
+	SubsystemNQN        string
+
+	// OrigSerial is the space padded serial number, as used by tools
+	// deriving keys from it.
+	OrigSerial string
+}
+
+// Optional admin commands in OACS.
+const (
+	oacsSecurity = 1 << 0
+	oacsFormat   = 1 << 1
+)
+
+// Format NVM attributes in FNA.
+const (
+	fnaAllNamespaces = 1 << 0
+	fnaCryptoErase   = 1 << 2
+)
+
+// Sanitize capabilities in SANICAP.
+const (
+	sanicapCryptoErase = 1 << 0
+	sanicapBlockErase  = 1 << 1
+	sanicapOverwrite   = 1 << 2
+)
+
+// asciiString returns a space padded ASCII field.
+func asciiString(b []byte) string {
+	return strings.TrimRight(strings.TrimRight(string(b), "\x00"), " ")
+}
+
+// uint128 returns a 128 bit little endian counter, saturated to 64 bits.
+func uint128(b []byte) uint64 {
+	if binary.LittleEndian.Uint64(b[8:]) != 0 {
+		return math.MaxUint64
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func parseController(b []byte) (*Controller, error) {
+	if len(b) < identifySize {
+		return nil, fmt.Errorf("identify controller data is %d bytes, want %d", len(b), identifySize)
+	}
+	return &Controller{
+		VendorID:            binary.LittleEndian.Uint16(b[0:]),
+		SubsystemVendorID:   binary.LittleEndian.Uint16(b[2:]),
+		Serial:              asciiString(b[4:24]),
+		Model:               asciiString(b[24:64]),
+		FirmwareRevision:    asciiString(b[64:72]),
+		ControllerID:        binary.LittleEndian.Uint16(b[78:]),
+		Version:             binary.LittleEndian.Uint32(b[80:]),
+		OACS:                binary.LittleEndian.Uint16(b[256:]),
+		TotalCapacity:       uint128(b[280:]),
+		UnallocatedCapacity: uint128(b[296:]),
+		SANICAP:             binary.LittleEndian.Uint32(b[328:]),
+		NumNamespaces:       binary.LittleEndian.Uint32(b[516:]),
+		FNA:                 b[524],
+		SubsystemNQN:        asciiString(b[768:1024]),
+		OrigSerial:          string(b[4:24]),
+	}, nil
+}
+
+// VersionString returns the NVMe version as major.minor[.tertiary].
+func (c *Controller) VersionString() string {
+	major, minor, ter := c.Version>>16, c.Version>>8&0xff, c.Version&0xff
+	if ter != 0 {
+		return fmt.Sprintf("%d.%d.%d", major, minor, ter)
+	}
+	return fmt.Sprintf("%d.%d", major, minor)
+}
+
+// SupportsSecurity returns true if Security Send and Receive are supported.
+func (c *Controller) SupportsSecurity() bool {
+	return c.OACS&oacsSecurity != 0
+}
+
+// SupportsFormat returns true if Format NVM is supported.
+func (c *Controller) SupportsFormat() bool {
+	return c.OACS&oacsFormat != 0
+}
+
+// SupportsCryptoErase returns true if Format NVM supports a
+// cryptographic erase.
+func (c *Controller) SupportsCryptoErase() bool {
+	return c.SupportsFormat() && c.FNA&fnaCryptoErase != 0
+}
+
+// FormatsAllNamespaces returns true if Format NVM always applies to all
+// namespaces.
+func (c *Controller) FormatsAllNamespaces() bool {
+	return c.FNA&fnaAllNamespaces != 0
+}
+
+// SupportsSanitize returns true if the sanitize action a is supported.
+func (c *Controller) SupportsSanitize(a SanitizeAction) bool {
+	switch a {
+	case SanitizeCryptoErase:
+		return c.SANICAP&sanicapCryptoErase != 0
+	case SanitizeBlockErase:
+		return c.SANICAP&sanicapBlockErase != 0
+	case SanitizeOverwrite:
+		return c.SANICAP&sanicapOverwrite != 0
+	case SanitizeExitFailure:
+		return c.SANICAP&(sanicapCryptoErase|sanicapBlockErase|sanicapOverwrite) != 0
+	}
+	return false
+}
+
+// String prints a nice JSON-formatted controller.
+func (c *Controller) String() string {
+	s, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return fmt.Sprintf("%v", err)
+	}
+	return string(s)
+}
+
+// LBAFormat is an LBA format supported by a namespace.
+type LBAFormat struct {
+	MetadataSize uint16
+	DataSize     uint32
+
+	// RelativePerformance is 0 for the best and 3 for degraded
+	// performance.
+	RelativePerformance uint8
+}
+
+// Namespace is the Identify Namespace data structure.
+type Namespace struct {
+	// Size, Capacity and Utilization are in logical blocks.
+	Size        uint64
+	Capacity    uint64
+	Utilization uint64
+
+	// FormattedLBA is the index of the LBA format in use.
+	FormattedLBA int
+	LBAFormats   []LBAFormat
+
+	NGUID [16]byte
+	EUI64 [8]byte
+}
+
+func parseNamespace(b []byte) (*Namespace, error) {
+	if len(b) < identifySize {
+		return nil, fmt.Errorf("identify namespace data is %d bytes, want %d", len(b), identifySize)
+	}
+	ns := &Namespace{
+		Size:        binary.LittleEndian.Uint64(b[0:]),
+		Capacity:    binary.LittleEndian.Uint64(b[8:]),
+		Utilization: binary.LittleEndian.Uint64(b[16:]),
+		// FLBAS bits 3:0 are the low and bits 6:5 the high bits.
+		FormattedLBA: int(b[26]&0xf | (b[26]>>5&0x3)<<4),
+	}
+	copy(ns.NGUID[:], b[104:120])
+	copy(ns.EUI64[:], b[120:128])
+
+	n := int(b[25]) + 1
+	for i := 0; i < n && 128+4*i < 384; i++ {
+		f := binary.LittleEndian.Uint32(b[128+4*i:])
+		ns.LBAFormats = append(ns.LBAFormats, LBAFormat{
+			MetadataSize:        uint16(f),
+			DataSize:            1 << (f >> 16 & 0xff),
+			RelativePerformance: uint8(f>>24) & 0x3,
+		})
+	}
+	if ns.FormattedLBA >= len(ns.LBAFormats) {
+		return nil, fmt.Errorf("formatted LBA format %d of %d", ns.FormattedLBA, len(ns.LBAFormats))
+	}
+	return ns, nil
+}
+
+// BlockSize returns the logical block size in bytes.
+func (ns *Namespace) BlockSize() uint32 {
+	return ns.LBAFormats[ns.FormattedLBA].DataSize
+}
+
+// String prints a nice JSON-formatted namespace.
+func (ns *Namespace) String() string {
+	s, err := json.MarshalIndent(ns, "", "\t")
+	if err != nil {
+		return fmt.Sprintf("%v", err)
+	}
+	return string(s)
+}
@@ -0,0 +1,198 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvme
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	smartLogSize    = 512
+	sanitizeLogSize = 512
+)
+
+// Critical warning bits of the SMART log.
+const (
+	WarningSpare       = 1 << 0
+	WarningTemperature = 1 << 1
+	WarningReliability = 1 << 2
+	WarningReadOnly    = 1 << 3
+	WarningBackup      = 1 << 4
+)
+
+// SMARTLog is the SMART / Health Information log page.
+//
+// The 128 bit counters are saturated to 64 bits.
+type SMARTLog struct {
+	CriticalWarning uint8
+
+	// Temperature is the composite temperature in Kelvin.
+	Temperature uint16
+
+	// AvailableSpare and AvailableSpareThreshold are percentages.
+	AvailableSpare          uint8
+	AvailableSpareThreshold uint8
+
+	// PercentageUsed is an estimate of the life used. It may exceed
+	// 100.
+	PercentageUsed uint8
+
+	// DataUnitsRead and DataUnitsWritten are in thousands of 512 byte
+	// units.
+	DataUnitsRead    uint64
+	DataUnitsWritten uint64
+
+	HostReadCommands   uint64
+	HostWriteCommands  uint64
+	ControllerBusyTime uint64
+	PowerCycles        uint64
+	PowerOnHours       uint64
+	UnsafeShutdowns    uint64
+	MediaErrors        uint64
+	ErrorLogEntries    uint64
+
+	// WarningTempTime and CriticalTempTime are minutes spent above the
+	// warning and critical thresholds.
+	WarningTempTime  uint32
+	CriticalTempTime uint32
+
+	// TemperatureSensors are in Kelvin, 0 if not implemented.
+	TemperatureSensors [8]uint16
+}
+
+func parseSMARTLog(b []byte) (*SMARTLog, error) {
+	if len(b) < smartLogSize {
+		return nil, fmt.Errorf("SMART log is %d bytes, want %d", len(b), smartLogSize)
+	}
+	l := &SMARTLog{
+		CriticalWarning:         b[0],
+		Temperature:             binary.LittleEndian.Uint16(b[1:]),
+		AvailableSpare:          b[3],
+		AvailableSpareThreshold: b[4],
+		PercentageUsed:          b[5],
+		DataUnitsRead:           uint128(b[32:]),
+		DataUnitsWritten:        uint128(b[48:]),
+		HostReadCommands:        uint128(b[64:]),
+		HostWriteCommands:       uint128(b[80:]),
+		ControllerBusyTime:      uint128(b[96:]),
+		PowerCycles:             uint128(b[112:]),
+		PowerOnHours:            uint128(b[128:]),
+		UnsafeShutdowns:         uint128(b[144:]),
+		MediaErrors:             uint128(b[160:]),
+		ErrorLogEntries:         uint128(b[176:]),
+		WarningTempTime:         binary.LittleEndian.Uint32(b[192:]),
+		CriticalTempTime:        binary.LittleEndian.Uint32(b[196:]),
+	}
+	for i := range l.TemperatureSensors {
+		l.TemperatureSensors[i] = binary.LittleEndian.Uint16(b[200+2*i:])
+	}
+	return l, nil
+}
+
+// Celsius returns the composite temperature in degrees Celsius.
+func (l *SMARTLog) Celsius() int {
+	return int(l.Temperature) - 273
+}
+
+// String prints a nice JSON-formatted log.
+func (l *SMARTLog) String() string {
+	s, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return fmt.Sprintf("%v", err)
+	}
+	return string(s)
+}
+
+// SanitizeState is the status of the most recent sanitize operation.
+type SanitizeState uint8
+
+// Sanitize states.
+const (
+	SanitizeNever              SanitizeState = 0
+	SanitizeSucceeded          SanitizeState = 1
+	SanitizeInProgress         SanitizeState = 2
+	SanitizeFailed             SanitizeState = 3
+	SanitizeSucceededNoDealloc SanitizeState = 4
+)
+
+// Fields of the SSTAT sanitize status.
+const (
+	sanitizeStateMask        = 0x7
+	sanitizeGlobalDataErased = 1 << 8
+)
+
+var sanitizeStateStrings = map[SanitizeState]string{
+	SanitizeNever:              "never sanitized",
+	SanitizeSucceeded:          "completed successfully",
+	SanitizeInProgress:         "in progress",
+	SanitizeFailed:             "failed",
+	SanitizeSucceededNoDealloc: "completed successfully without deallocation",
+}
+
+func (s SanitizeState) String() string {
+	if str, ok := sanitizeStateStrings[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("SanitizeState(%d)", uint8(s))
+}
+
+// SanitizeStatus is the Sanitize Status log page.
+type SanitizeStatus struct {
+	// Progress is the fraction completed, in 65536ths, while State is
+	// SanitizeInProgress.
+	Progress uint16
+	State    SanitizeState
+
+	// OverwritePasses is the number of completed overwrite passes.
+	OverwritePasses uint8
+
+	// GlobalDataErased is true if no user data has been written since
+	// the last sanitize or since manufacture.
+	GlobalDataErased bool
+
+	// CDW10 is the command dword 10 of the sanitize command.
+	CDW10 uint32
+
+	// Estimated times in seconds, 0xffffffff if unknown.
+	OverwriteTime   uint32
+	BlockEraseTime  uint32
+	CryptoEraseTime uint32
+}
+
+func parseSanitizeStatus(b []byte) (*SanitizeStatus, error) {
+	if len(b) < 20 {
+		return nil, fmt.Errorf("sanitize status log is %d bytes, want at least 20", len(b))
+	}
+	sstat := binary.LittleEndian.Uint16(b[2:])
+	return &SanitizeStatus{
+		Progress:         binary.LittleEndian.Uint16(b[0:]),
+		State:            SanitizeState(sstat & sanitizeStateMask),
+		OverwritePasses:  uint8(sstat>>3) & 0x1f,
+		GlobalDataErased: sstat&sanitizeGlobalDataErased != 0,
+		CDW10:            binary.LittleEndian.Uint32(b[4:]),
+		OverwriteTime:    binary.LittleEndian.Uint32(b[8:]),
+		BlockEraseTime:   binary.LittleEndian.Uint32(b[12:]),
+		CryptoEraseTime:  binary.LittleEndian.Uint32(b[16:]),
+	}, nil
+}
+
+// Percent returns the progress in percent.
+func (s *SanitizeStatus) Percent() float64 {
+	if s.State != SanitizeInProgress {
+		return 100
+	}
+	return float64(s.Progress) * 100 / 65536
+}
+
+// String prints a nice JSON-formatted status.
+func (s *SanitizeStatus) String() string {
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Sprintf("%v", err)
+	}
+	return string(b)
+}
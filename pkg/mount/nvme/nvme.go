@@ -0,0 +1,390 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package nvme sends admin commands to NVMe controllers.
+//
+// It supports Identify, the SMART/health and sanitize status log pages,
+// Format NVM, Sanitize, and Security Send/Receive, which carry TCG storage
+// protocols such as Opal (see package opal).
+//
+// Commands are built and responses parsed portably; only submitting them
+// uses the Linux NVME_IOCTL_ADMIN_CMD passthrough.
+//
+// Other info:
+//
+//	https://nvmexpress.org/developers/nvme-specification/ NVM Express Base Specification.
+package nvme
+
+import (
+	"fmt"
+	"time"
+)
+
+// Debug is an empty function you can replace with, e.g., log.Printf
+var Debug = func(string, ...interface{}) {}
+
+// DefaultTimeout is the default timeout for admin commands.
+const DefaultTimeout time.Duration = 15 * time.Second
+
+// FormatTimeout is the default timeout for Format NVM, which may erase the
+// whole namespace before completing.
+const FormatTimeout time.Duration = 30 * time.Minute
+
+// Admin command opcodes.
+const (
+	opGetLogPage   = 0x02
+	opIdentify     = 0x06
+	opFormatNVM    = 0x80
+	opSecuritySend = 0x81
+	opSecurityRecv = 0x82
+	opSanitize     = 0x84
+)
+
+// Identify CNS values.
+const (
+	cnsNamespace  = 0x00
+	cnsController = 0x01
+)
+
+// Log page identifiers.
+const (
+	LogSMART    = 0x02
+	LogSanitize = 0x81
+)
+
+// NSIDAll addresses all namespaces, or the controller.
+const NSIDAll = 0xffffffff
+
+// identifySize is the size of Identify data structures.
+const identifySize = 4096
+
+// Command is an NVMe admin command.
+//
+// Data is sent to the controller or filled in by it, depending on the
+// opcode.
+type Command struct {
+	Opcode uint8
+	NSID   uint32
+	CDW10  uint32
+	CDW11  uint32
+	CDW12  uint32
+	CDW13  uint32
+	CDW14  uint32
+	CDW15  uint32
+	Data   []byte
+
+	Timeout time.Duration
+}
+
+// StatusError is the status field of a failed command completion.
+type StatusError uint16
+
+var statusStrings = map[StatusError]string{
+	0x0001: "invalid command opcode",
+	0x0002: "invalid field in command",
+	0x0006: "internal error",
+	0x000b: "invalid namespace or format",
+	0x001c: "sanitize failed",
+	0x001d: "sanitize in progress",
+	0x0106: "invalid firmware slot",
+	0x010a: "invalid format",
+	0x010b: "firmware activation requires conventional reset",
+	0x0115: "namespace is write protected",
+	0x0281: "unrecovered read error",
+	0x0286: "access denied",
+}
+
+// Type is the status code type, e.g. 0 for generic and 1 for command
+// specific status.
+func (s StatusError) Type() uint8 {
+	return uint8(s>>8) & 0x7
+}
+
+// Code is the status code.
+func (s StatusError) Code() uint8 {
+	return uint8(s)
+}
+
+// DoNotRetry is true if retrying the command is expected to fail again.
+func (s StatusError) DoNotRetry() bool {
+	return s&0x4000 != 0
+}
+
+func (s StatusError) Error() string {
+	if str, ok := statusStrings[s&0x7ff]; ok {
+		return fmt.Sprintf("NVMe status %#x: %s", uint16(s), str)
+	}
+	return fmt.Sprintf("NVMe status %#x (type %d, code %#x)", uint16(s), s.Type(), s.Code())
+}
+
+// deviceFile is an open NVMe controller or namespace device.
+type deviceFile interface {
+	Close() error
+	Name() string
+	Fd() uintptr
+}
+
+// Device is an NVMe controller, opened through its character device
+// (/dev/nvme0) or one of its namespaces (/dev/nvme0n1).
+type Device struct {
+	f deviceFile
+
+	// admin submits c and returns the completion's command specific
+	// result. It is an ioctl on Linux and a fake in tests.
+	admin func(f deviceFile, c *Command) (uint32, error)
+
+	// Timeout is the timeout on commands which do not set one.
+	Timeout time.Duration
+}
+
+// Close closes the device.
+func (d *Device) Close() error {
+	return d.f.Close()
+}
+
+// Name returns the name of the device.
+func (d *Device) Name() string {
+	return d.f.Name()
+}
+
+// Admin submits an admin command and returns the command specific result
+// of its completion. Failed commands return a StatusError, wrapped in an
+// *os.PathError.
+func (d *Device) Admin(c *Command) (uint32, error) {
+	if c.Timeout == 0 {
+		c.Timeout = d.Timeout
+	}
+	Debug("nvme %s: opcode %#02x nsid %#x cdw10 %#08x cdw11 %#08x, %d bytes", d.f.Name(), c.Opcode, c.NSID, c.CDW10, c.CDW11, len(c.Data))
+	return d.admin(d.f, c)
+}
+
+func identifyCmd(cns uint8, nsid uint32) *Command {
+	return &Command{
+		Opcode: opIdentify,
+		NSID:   nsid,
+		CDW10:  uint32(cns),
+		Data:   make([]byte, identifySize),
+	}
+}
+
+// IdentifyController returns the Identify Controller data structure.
+func (d *Device) IdentifyController() (*Controller, error) {
+	c := identifyCmd(cnsController, 0)
+	if _, err := d.Admin(c); err != nil {
+		return nil, err
+	}
+	return parseController(c.Data)
+}
+
+// IdentifyNamespace returns the Identify Namespace data structure of
+// namespace nsid.
+func (d *Device) IdentifyNamespace(nsid uint32) (*Namespace, error) {
+	c := identifyCmd(cnsNamespace, nsid)
+	if _, err := d.Admin(c); err != nil {
+		return nil, err
+	}
+	return parseNamespace(c.Data)
+}
+
+func getLogPageCmd(lid uint8, nsid uint32, size int) *Command {
+	// The number of dwords is 0's based and split over CDW10 and CDW11.
+	numd := uint32(size/4 - 1)
+	return &Command{
+		Opcode: opGetLogPage,
+		NSID:   nsid,
+		CDW10:  uint32(lid) | numd<<16,
+		CDW11:  numd >> 16,
+		Data:   make([]byte, size),
+	}
+}
+
+// GetLogPage returns size bytes of log page lid. size must be a multiple of
+// 4.
+func (d *Device) GetLogPage(lid uint8, nsid uint32, size int) ([]byte, error) {
+	if size < 4 || size%4 != 0 {
+		return nil, fmt.Errorf("log page size %d is not a positive multiple of 4", size)
+	}
+	c := getLogPageCmd(lid, nsid, size)
+	if _, err := d.Admin(c); err != nil {
+		return nil, err
+	}
+	return c.Data, nil
+}
+
+// SMARTLog returns the SMART / Health Information log page for the whole
+// controller.
+func (d *Device) SMARTLog() (*SMARTLog, error) {
+	b, err := d.GetLogPage(LogSMART, NSIDAll, smartLogSize)
+	if err != nil {
+		return nil, err
+	}
+	return parseSMARTLog(b)
+}
+
+// SanitizeStatus returns the Sanitize Status log page.
+func (d *Device) SanitizeStatus() (*SanitizeStatus, error) {
+	b, err := d.GetLogPage(LogSanitize, NSIDAll, sanitizeLogSize)
+	if err != nil {
+		return nil, err
+	}
+	return parseSanitizeStatus(b)
+}
+
+// SecureErase is the Secure Erase Settings field of Format NVM.
+type SecureErase uint8
+
+// Secure erase settings.
+const (
+	NoSecureErase SecureErase = 0
+	UserDataErase SecureErase = 1
+	CryptoErase   SecureErase = 2
+)
+
+// FormatOptions are the parameters of Format NVM.
+type FormatOptions struct {
+	// LBAFormat is the index of the LBA format in the namespace's
+	// LBAFormats.
+	LBAFormat uint8
+
+	SecureErase SecureErase
+
+	// ExtendedMetadata transfers metadata as part of an extended LBA
+	// rather than in a separate buffer.
+	ExtendedMetadata bool
+
+	// ProtectionInfo is the end-to-end protection type, 0 to disable.
+	ProtectionInfo uint8
+
+	// ProtectionInfoFirst puts protection information in the first
+	// bytes of metadata.
+	ProtectionInfoFirst bool
+
+	// Timeout overrides FormatTimeout.
+	Timeout time.Duration
+}
+
+func formatCmd(nsid uint32, o FormatOptions) *Command {
+	cdw10 := uint32(o.LBAFormat&0xf) | uint32(o.ProtectionInfo&0x7)<<5 | uint32(o.SecureErase&0x7)<<9 | uint32(o.LBAFormat>>4&0x3)<<12
+	if o.ExtendedMetadata {
+		cdw10 |= 1 << 4
+	}
+	if o.ProtectionInfoFirst {
+		cdw10 |= 1 << 8
+	}
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = FormatTimeout
+	}
+	return &Command{Opcode: opFormatNVM, NSID: nsid, CDW10: cdw10, Timeout: timeout}
+}
+
+// Format formats namespace nsid, or all namespaces for NSIDAll. It
+// returns when the format has completed.
+func (d *Device) Format(nsid uint32, o FormatOptions) error {
+	_, err := d.Admin(formatCmd(nsid, o))
+	return err
+}
+
+// SanitizeAction is the Sanitize Action field of Sanitize.
+type SanitizeAction uint8
+
+// Sanitize actions.
+const (
+	SanitizeExitFailure SanitizeAction = 1
+	SanitizeBlockErase  SanitizeAction = 2
+	SanitizeOverwrite   SanitizeAction = 3
+	SanitizeCryptoErase SanitizeAction = 4
+)
+
+var sanitizeActionStrings = map[SanitizeAction]string{
+	SanitizeExitFailure: "exit-failure",
+	SanitizeBlockErase:  "block-erase",
+	SanitizeOverwrite:   "overwrite",
+	SanitizeCryptoErase: "crypto-erase",
+}
+
+func (a SanitizeAction) String() string {
+	if s, ok := sanitizeActionStrings[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("SanitizeAction(%d)", uint8(a))
+}
+
+// ParseSanitizeAction parses the names returned by SanitizeAction.String.
+func ParseSanitizeAction(s string) (SanitizeAction, error) {
+	for a, n := range sanitizeActionStrings {
+		if n == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sanitize action %q", s)
+}
+
+// SanitizeOptions are the parameters of Sanitize.
+type SanitizeOptions struct {
+	Action SanitizeAction
+
+	// AllowUnrestrictedExit lets a failed sanitize be exited with any
+	// action, rather than only a new sanitize.
+	AllowUnrestrictedExit bool
+
+	// OverwritePasses is the number of overwrite passes, 1 to 16. 0
+	// means 16.
+	OverwritePasses uint8
+
+	// OverwritePattern is the 32 bit pattern written by overwrite.
+	OverwritePattern uint32
+
+	// InvertPattern inverts the pattern between passes.
+	InvertPattern bool
+
+	// NoDeallocate asks the controller not to deallocate LBAs
+	// afterwards.
+	NoDeallocate bool
+}
+
+func sanitizeCmd(o SanitizeOptions) *Command {
+	cdw10 := uint32(o.Action&0x7) | uint32(o.OverwritePasses&0xf)<<4
+	if o.AllowUnrestrictedExit {
+		cdw10 |= 1 << 3
+	}
+	if o.InvertPattern {
+		cdw10 |= 1 << 8
+	}
+	if o.NoDeallocate {
+		cdw10 |= 1 << 9
+	}
+	return &Command{Opcode: opSanitize, CDW10: cdw10, CDW11: o.OverwritePattern}
+}
+
+// Sanitize starts a sanitize operation on the whole NVM subsystem. It
+// returns once the operation has started; use SanitizeStatus for its
+// progress.
+func (d *Device) Sanitize(o SanitizeOptions) error {
+	_, err := d.Admin(sanitizeCmd(o))
+	return err
+}
+
+func securityCmd(op uint8, protocol uint8, spsp uint16, data []byte) *Command {
+	return &Command{
+		Opcode: op,
+		CDW10:  uint32(protocol)<<24 | uint32(spsp)<<8,
+		CDW11:  uint32(len(data)),
+		Data:   data,
+	}
+}
+
+// SecuritySend sends data for security protocol protocol with protocol
+// specific field spsp, e.g. a TCG ComID.
+func (d *Device) SecuritySend(protocol uint8, spsp uint16, data []byte) error {
+	_, err := d.Admin(securityCmd(opSecuritySend, protocol, spsp, data))
+	return err
+}
+
+// SecurityReceive fills data with the response for security protocol
+// protocol with protocol specific field spsp.
+func (d *Device) SecurityReceive(protocol uint8, spsp uint16, data []byte) error {
+	_, err := d.Admin(securityCmd(opSecurityRecv, protocol, spsp, data))
+	return err
+}
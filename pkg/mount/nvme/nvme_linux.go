@@ -0,0 +1,146 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvme
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// passthruCmd is struct nvme_passthru_cmd, also known as
+// struct nvme_admin_cmd, from linux/nvme_ioctl.h.
+type passthruCmd struct {
+	opcode      uint8
+	flags       uint8
+	rsvd1       uint16
+	nsid        uint32
+	cdw2        uint32
+	cdw3        uint32
+	metadata    uint64
+	addr        uint64
+	metadataLen uint32
+	dataLen     uint32
+	cdw10       uint32
+	cdw11       uint32
+	cdw12       uint32
+	cdw13       uint32
+	cdw14       uint32
+	cdw15       uint32
+	timeoutMS   uint32
+	result      uint32
+}
+
+const (
+	passthruCmdSize = 72
+
+	// _NVME_IOCTL_ID is _IO('N', 0x40).
+	_NVME_IOCTL_ID = 0x4e40
+
+	// _NVME_IOCTL_ADMIN_CMD is _IOWR('N', 0x41, struct nvme_admin_cmd).
+	_NVME_IOCTL_ADMIN_CMD = 0xc0484e41
+)
+
+func (c *Command) passthru() *passthruCmd {
+	p := &passthruCmd{
+		opcode:    c.Opcode,
+		nsid:      c.NSID,
+		cdw10:     c.CDW10,
+		cdw11:     c.CDW11,
+		cdw12:     c.CDW12,
+		cdw13:     c.CDW13,
+		cdw14:     c.CDW14,
+		cdw15:     c.CDW15,
+		timeoutMS: uint32(c.Timeout / time.Millisecond),
+	}
+	if len(c.Data) > 0 {
+		p.addr = uint64(uintptr(unsafe.Pointer(&c.Data[0])))
+		p.dataLen = uint32(len(c.Data))
+	}
+	return p
+}
+
+// ioctlAdmin submits c with NVME_IOCTL_ADMIN_CMD. The ioctl returns a
+// positive NVMe status if the command itself failed.
+func ioctlAdmin(f deviceFile, c *Command) (uint32, error) {
+	p := c.passthru()
+	r, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), _NVME_IOCTL_ADMIN_CMD, uintptr(unsafe.Pointer(p)))
+	runtime.KeepAlive(c.Data)
+	if errno != 0 {
+		return 0, &os.PathError{Op: "ioctl NVME_IOCTL_ADMIN_CMD", Path: f.Name(), Err: errno}
+	}
+	if r != 0 {
+		return 0, &os.PathError{Op: "ioctl NVME_IOCTL_ADMIN_CMD", Path: f.Name(), Err: StatusError(r)}
+	}
+	return p.result, nil
+}
+
+// Open opens an NVMe controller character device, e.g. /dev/nvme0, or a
+// namespace block device, e.g. /dev/nvme0n1.
+func Open(path string, opt ...Opt) (*Device, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := &Device{f: f, admin: ioctlAdmin, Timeout: DefaultTimeout}
+	for _, o := range opt {
+		o(d)
+	}
+	return d, nil
+}
+
+// Opt allows callers of Open to set values.
+type Opt func(*Device)
+
+// WithTimeout returns an Opt that sets a non-default Timeout.
+func WithTimeout(timeout time.Duration) Opt {
+	return func(d *Device) {
+		d.Timeout = timeout
+	}
+}
+
+// NamespaceID returns the namespace ID of a namespace block device.
+func (d *Device) NamespaceID() (uint32, error) {
+	r, _, errno := unix.Syscall(unix.SYS_IOCTL, d.f.Fd(), _NVME_IOCTL_ID, 0)
+	if errno != 0 {
+		return 0, &os.PathError{Op: "ioctl NVME_IOCTL_ID", Path: d.f.Name(), Err: errno}
+	}
+	return uint32(r), nil
+}
+
+// Controllers returns the paths of the NVMe controller character devices.
+func Controllers() ([]string, error) {
+	names, err := filepath.Glob("/sys/class/nvme/nvme*")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, n := range names {
+		paths = append(paths, filepath.Join("/dev", filepath.Base(n)))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Namespaces returns the paths of the namespace block devices of the
+// controller at path, e.g. /dev/nvme0.
+func Namespaces(path string) ([]string, error) {
+	name := filepath.Base(path)
+	names, err := filepath.Glob(filepath.Join("/sys/class/nvme", name, name+"n*"))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, n := range names {
+		paths = append(paths, filepath.Join("/dev", filepath.Base(n)))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvme
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// TestSizes makes sure the passthrough command matches struct
+// nvme_admin_cmd, whose size is part of the ioctl number.
+func TestSizes(t *testing.T) {
+	if s := unsafe.Sizeof(passthruCmd{}); s != passthruCmdSize {
+		t.Errorf("passthruCmd size: got %d, want %d", s, passthruCmdSize)
+	}
+	if want := uintptr(3<<30 | passthruCmdSize<<16 | 'N'<<8 | 0x41); _NVME_IOCTL_ADMIN_CMD != want {
+		t.Errorf("_NVME_IOCTL_ADMIN_CMD: got %#x, want %#x", uintptr(_NVME_IOCTL_ADMIN_CMD), want)
+	}
+}
+
+func TestPassthru(t *testing.T) {
+	c := identifyCmd(cnsController, 0)
+	c.Timeout = 2 * time.Second
+	p := c.passthru()
+	want := passthruCmd{
+		opcode:    0x06,
+		cdw10:     1,
+		addr:      uint64(uintptr(unsafe.Pointer(&c.Data[0]))),
+		dataLen:   4096,
+		timeoutMS: 2000,
+	}
+	if *p != want {
+		t.Errorf("passthru = %+v, want %+v", *p, want)
+	}
+
+	p = formatCmd(1, FormatOptions{}).passthru()
+	if p.addr != 0 || p.dataLen != 0 || p.timeoutMS != uint32(FormatTimeout/time.Millisecond) {
+		t.Errorf("format passthru = %+v, want no data and the format timeout", *p)
+	}
+}
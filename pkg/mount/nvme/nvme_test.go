@@ -0,0 +1,353 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvme
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeFile struct{}
+
+func (fakeFile) Close() error { return nil }
+func (fakeFile) Name() string { return "/dev/nvme0" }
+func (fakeFile) Fd() uintptr  { return ^uintptr(0) }
+
+// fakeController records commands and answers them from canned data.
+type fakeController struct {
+	cmds []Command
+
+	// responses are copied into the data of commands by opcode.
+	responses map[uint8][]byte
+	status    StatusError
+	result    uint32
+}
+
+func (c *fakeController) admin(f deviceFile, cmd *Command) (uint32, error) {
+	c.cmds = append(c.cmds, *cmd)
+	if c.status != 0 {
+		return 0, &os.PathError{Op: "ioctl NVME_IOCTL_ADMIN_CMD", Path: f.Name(), Err: c.status}
+	}
+	copy(cmd.Data, c.responses[cmd.Opcode])
+	return c.result, nil
+}
+
+func newFakeDevice(c *fakeController) *Device {
+	return &Device{f: fakeFile{}, admin: c.admin, Timeout: DefaultTimeout}
+}
+
+func identifyControllerData() []byte {
+	b := make([]byte, identifySize)
+	binary.LittleEndian.PutUint16(b[0:], 0x144d)
+	binary.LittleEndian.PutUint16(b[2:], 0x144d)
+	copy(b[4:24], "S4EWNX0R123456      ")
+	copy(b[24:64], "Samsung SSD 970 EVO Plus 1TB            ")
+	copy(b[64:72], "2B2QEXM7")
+	binary.LittleEndian.PutUint16(b[78:], 4)
+	binary.LittleEndian.PutUint32(b[80:], 0x10300)
+	binary.LittleEndian.PutUint16(b[256:], 0x17)
+	binary.LittleEndian.PutUint64(b[280:], 1000204886016)
+	binary.LittleEndian.PutUint32(b[328:], 0x3)
+	binary.LittleEndian.PutUint32(b[516:], 1)
+	b[524] = 0x4
+	copy(b[768:], "nqn.2014.08.org.nvmexpress:144d144dS4EWNX0R123456")
+	return b
+}
+
+func TestIdentifyController(t *testing.T) {
+	c := &fakeController{responses: map[uint8][]byte{opIdentify: identifyControllerData()}}
+	got, err := newFakeDevice(c).IdentifyController()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Controller{
+		VendorID:          0x144d,
+		SubsystemVendorID: 0x144d,
+		Serial:            "S4EWNX0R123456",
+		Model:             "Samsung SSD 970 EVO Plus 1TB",
+		FirmwareRevision:  "2B2QEXM7",
+		ControllerID:      4,
+		Version:           0x10300,
+		OACS:              0x17,
+		FNA:               0x4,
+		SANICAP:           0x3,
+		NumNamespaces:     1,
+		TotalCapacity:     1000204886016,
+		SubsystemNQN:      "nqn.2014.08.org.nvmexpress:144d144dS4EWNX0R123456",
+		OrigSerial:        "S4EWNX0R123456      ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("IdentifyController = %v, want %v", got, want)
+	}
+
+	cmd := c.cmds[0]
+	if cmd.Opcode != 0x06 || cmd.CDW10 != 1 || cmd.NSID != 0 || len(cmd.Data) != 4096 || cmd.Timeout != DefaultTimeout {
+		t.Errorf("identify command = %+v", cmd)
+	}
+
+	if v := got.VersionString(); v != "1.3" {
+		t.Errorf("VersionString = %q, want 1.3", v)
+	}
+	if !got.SupportsSecurity() || !got.SupportsFormat() || !got.SupportsCryptoErase() || got.FormatsAllNamespaces() {
+		t.Errorf("OACS/FNA capabilities wrong for %#x/%#x", got.OACS, got.FNA)
+	}
+	for a, want := range map[SanitizeAction]bool{
+		SanitizeCryptoErase: true,
+		SanitizeBlockErase:  true,
+		SanitizeOverwrite:   false,
+		SanitizeExitFailure: true,
+	} {
+		if got.SupportsSanitize(a) != want {
+			t.Errorf("SupportsSanitize(%v) = %v, want %v", a, !want, want)
+		}
+	}
+}
+
+func TestIdentifyNamespace(t *testing.T) {
+	b := make([]byte, identifySize)
+	binary.LittleEndian.PutUint64(b[0:], 1953525168)
+	binary.LittleEndian.PutUint64(b[8:], 1953525168)
+	binary.LittleEndian.PutUint64(b[16:], 126378888)
+	b[25] = 1
+	b[26] = 1
+	copy(b[120:], []byte{0, 0x25, 0x38, 0x5c, 0x91, 0x50, 0x33, 0x1e})
+	binary.LittleEndian.PutUint32(b[128:], 9<<16|2<<24)
+	binary.LittleEndian.PutUint32(b[132:], 12<<16)
+
+	c := &fakeController{responses: map[uint8][]byte{opIdentify: b}}
+	ns, err := newFakeDevice(c).IdentifyNamespace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Namespace{
+		Size:         1953525168,
+		Capacity:     1953525168,
+		Utilization:  126378888,
+		FormattedLBA: 1,
+		LBAFormats: []LBAFormat{
+			{DataSize: 512, RelativePerformance: 2},
+			{DataSize: 4096},
+		},
+		EUI64: [8]byte{0, 0x25, 0x38, 0x5c, 0x91, 0x50, 0x33, 0x1e},
+	}
+	if !reflect.DeepEqual(ns, want) {
+		t.Errorf("IdentifyNamespace = %v, want %v", ns, want)
+	}
+	if ns.BlockSize() != 4096 {
+		t.Errorf("BlockSize = %d, want 4096", ns.BlockSize())
+	}
+	if cmd := c.cmds[0]; cmd.CDW10 != 0 || cmd.NSID != 1 {
+		t.Errorf("identify namespace command = %+v", cmd)
+	}
+
+	// A formatted LBA format beyond the list is invalid.
+	b[26] = 2
+	if _, err := newFakeDevice(c).IdentifyNamespace(1); err == nil {
+		t.Errorf("IdentifyNamespace with bad FLBAS = nil, want error")
+	}
+}
+
+func TestSMARTLog(t *testing.T) {
+	b := make([]byte, smartLogSize)
+	b[0] = WarningSpare | WarningReadOnly
+	binary.LittleEndian.PutUint16(b[1:], 310)
+	b[3], b[4], b[5] = 100, 10, 3
+	binary.LittleEndian.PutUint64(b[32:], 12345678)
+	binary.LittleEndian.PutUint64(b[48:], 23456789)
+	binary.LittleEndian.PutUint64(b[112:], 42)
+	binary.LittleEndian.PutUint64(b[128:], 1234)
+	binary.LittleEndian.PutUint64(b[160:], 1)
+	// A counter which overflows 64 bits.
+	binary.LittleEndian.PutUint64(b[184:], 1)
+	binary.LittleEndian.PutUint16(b[200:], 305)
+
+	c := &fakeController{responses: map[uint8][]byte{opGetLogPage: b}}
+	l, err := newFakeDevice(c).SMARTLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SMARTLog{
+		CriticalWarning:         WarningSpare | WarningReadOnly,
+		Temperature:             310,
+		AvailableSpare:          100,
+		AvailableSpareThreshold: 10,
+		PercentageUsed:          3,
+		DataUnitsRead:           12345678,
+		DataUnitsWritten:        23456789,
+		PowerCycles:             42,
+		PowerOnHours:            1234,
+		MediaErrors:             1,
+		ErrorLogEntries:         ^uint64(0),
+		TemperatureSensors:      [8]uint16{305},
+	}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("SMARTLog = %v, want %v", l, want)
+	}
+	if l.Celsius() != 37 {
+		t.Errorf("Celsius = %d, want 37", l.Celsius())
+	}
+
+	// 512 bytes are 128 dwords, 0's based.
+	if cmd := c.cmds[0]; cmd.Opcode != 0x02 || cmd.NSID != NSIDAll || cmd.CDW10 != 0x007f0002 || cmd.CDW11 != 0 {
+		t.Errorf("get log page command = %+v", cmd)
+	}
+}
+
+func TestGetLogPageSize(t *testing.T) {
+	c := &fakeController{}
+	d := newFakeDevice(c)
+	if _, err := d.GetLogPage(LogSMART, NSIDAll, 6); err == nil {
+		t.Errorf("GetLogPage(6 bytes) = nil, want error")
+	}
+	if _, err := d.GetLogPage(0xc0, NSIDAll, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	// 1 MiB is 0x40000 dwords; the upper half of NUMD is in CDW11.
+	if cmd := c.cmds[0]; cmd.CDW10 != 0xffff00c0 || cmd.CDW11 != 0x3 {
+		t.Errorf("get log page command = %+v", cmd)
+	}
+}
+
+func TestSanitizeStatus(t *testing.T) {
+	b := make([]byte, sanitizeLogSize)
+	binary.LittleEndian.PutUint16(b[0:], 0x8000)
+	binary.LittleEndian.PutUint16(b[2:], uint16(SanitizeInProgress)|2<<3|1<<8)
+	binary.LittleEndian.PutUint32(b[4:], 0x24)
+	binary.LittleEndian.PutUint32(b[8:], 0xffffffff)
+	binary.LittleEndian.PutUint32(b[12:], 30)
+	binary.LittleEndian.PutUint32(b[16:], 2)
+
+	c := &fakeController{responses: map[uint8][]byte{opGetLogPage: b}}
+	s, err := newFakeDevice(c).SanitizeStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SanitizeStatus{
+		Progress:         0x8000,
+		State:            SanitizeInProgress,
+		OverwritePasses:  2,
+		GlobalDataErased: true,
+		CDW10:            0x24,
+		OverwriteTime:    0xffffffff,
+		BlockEraseTime:   30,
+		CryptoEraseTime:  2,
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("SanitizeStatus = %+v, want %+v", s, want)
+	}
+	if s.Percent() != 50 {
+		t.Errorf("Percent = %v, want 50", s.Percent())
+	}
+	if s.State.String() != "in progress" {
+		t.Errorf("State = %q, want %q", s.State, "in progress")
+	}
+}
+
+func TestFormatAndSanitize(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		run  func(d *Device) error
+		want Command
+	}{
+		{
+			name: "format crypto erase",
+			run: func(d *Device) error {
+				return d.Format(1, FormatOptions{LBAFormat: 1, SecureErase: CryptoErase})
+			},
+			want: Command{Opcode: 0x80, NSID: 1, CDW10: 0x401, Timeout: FormatTimeout},
+		},
+		{
+			name: "format with protection info",
+			run: func(d *Device) error {
+				return d.Format(NSIDAll, FormatOptions{LBAFormat: 17, SecureErase: UserDataErase, ExtendedMetadata: true, ProtectionInfo: 1, ProtectionInfoFirst: true, Timeout: time.Minute})
+			},
+			want: Command{Opcode: 0x80, NSID: NSIDAll, CDW10: 0x1331, Timeout: time.Minute},
+		},
+		{
+			name: "sanitize block erase",
+			run: func(d *Device) error {
+				return d.Sanitize(SanitizeOptions{Action: SanitizeBlockErase, AllowUnrestrictedExit: true})
+			},
+			want: Command{Opcode: 0x84, CDW10: 0xa, Timeout: DefaultTimeout},
+		},
+		{
+			name: "sanitize overwrite",
+			run: func(d *Device) error {
+				return d.Sanitize(SanitizeOptions{Action: SanitizeOverwrite, OverwritePasses: 3, OverwritePattern: 0xdeadbeef, InvertPattern: true, NoDeallocate: true})
+			},
+			want: Command{Opcode: 0x84, CDW10: 0x333, CDW11: 0xdeadbeef, Timeout: DefaultTimeout},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeController{}
+			if err := tt.run(newFakeDevice(c)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.cmds[0], tt.want) {
+				t.Errorf("command = %+v, want %+v", c.cmds[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	c := &fakeController{responses: map[uint8][]byte{opSecurityRecv: {1, 2, 3}}}
+	d := newFakeDevice(c)
+	if err := d.SecuritySend(1, 0x7fe, make([]byte, 512)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	if err := d.SecurityReceive(1, 0x7fe, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 1 || buf[2] != 3 {
+		t.Errorf("SecurityReceive data = %v, want response", buf[:4])
+	}
+	for i, want := range []struct {
+		op           uint8
+		cdw10, cdw11 uint32
+	}{
+		{0x81, 0x0107fe00, 512},
+		{0x82, 0x0107fe00, 2048},
+	} {
+		if cmd := c.cmds[i]; cmd.Opcode != want.op || cmd.CDW10 != want.cdw10 || cmd.CDW11 != want.cdw11 {
+			t.Errorf("security command %d = %+v, want opcode %#x cdw10 %#x cdw11 %d", i, cmd, want.op, want.cdw10, want.cdw11)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	c := &fakeController{status: 0x4002}
+	_, err := newFakeDevice(c).IdentifyController()
+	var s StatusError
+	if !errors.As(err, &s) {
+		t.Fatalf("IdentifyController = %v, want a StatusError", err)
+	}
+	if !s.DoNotRetry() || s.Type() != 0 || s.Code() != 2 {
+		t.Errorf("StatusError %#x: DNR %v, type %d, code %d", uint16(s), s.DoNotRetry(), s.Type(), s.Code())
+	}
+	if want := "ioctl NVME_IOCTL_ADMIN_CMD /dev/nvme0: NVMe status 0x4002: invalid field in command"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+	if got := StatusError(0x0180).Error(); got != "NVMe status 0x180 (type 1, code 0x80)" {
+		t.Errorf("unknown status = %q", got)
+	}
+}
+
+func TestParseSanitizeAction(t *testing.T) {
+	for _, a := range []SanitizeAction{SanitizeExitFailure, SanitizeBlockErase, SanitizeOverwrite, SanitizeCryptoErase} {
+		got, err := ParseSanitizeAction(a.String())
+		if err != nil || got != a {
+			t.Errorf("ParseSanitizeAction(%q) = %v, %v, want %v", a, got, err, a)
+		}
+	}
+	if _, err := ParseSanitizeAction("shred"); err == nil {
+		t.Errorf("ParseSanitizeAction(shred) = nil, want error")
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package opal implements enough of the TCG Storage Architecture Core
+// Specification to discover a self-encrypting drive's capabilities and
+// unlock Opal, Opalite, Pyrite and Ruby locking ranges.
+//
+// Commands are carried by a Transport, the Security Send and Security
+// Receive commands of the drive, e.g. an *nvme.Device.
+//
+// Other info:
+//
+//	https://trustedcomputinggroup.org/resource/storage-work-group-storage-security-subsystem-class-opal/
+//	https://github.com/Drive-Trust-Alliance/sedutil the de facto reference tool.
+package opal
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Debug is an empty function you can replace with, e.g., log.Printf
+var Debug = func(string, ...interface{}) {}
+
+// Transport sends and receives security protocol data.
+type Transport interface {
+	SecuritySend(protocol uint8, spsp uint16, data []byte) error
+	SecurityReceive(protocol uint8, spsp uint16, data []byte) error
+}
+
+// protocolTCG is the security protocol of TCG storage commands.
+const protocolTCG = 0x01
+
+// ErrNoLocking is returned when a drive does not support the locking
+// feature.
+var ErrNoLocking = errors.New("drive does not support TCG locking")
+
+// UID is the unique identifier of a table row, method or authority.
+type UID [8]byte
+
+func (u UID) String() string {
+	return hex.EncodeToString(u[:])
+}
+
+// Session manager, methods and well known objects.
+var (
+	smUID              = UID{0, 0, 0, 0, 0, 0, 0, 0xff}
+	methodStartSession = UID{0, 0, 0, 0, 0, 0, 0xff, 0x02}
+	methodSyncSession  = UID{0, 0, 0, 0, 0, 0, 0xff, 0x03}
+	methodSet          = UID{0, 0, 0, 0x06, 0, 0, 0, 0x17}
+	mbrControl         = UID{0, 0, 0x08, 0x03, 0, 0, 0, 0x01}
+
+	// AdminSP and LockingSP are the security providers.
+	AdminSP   = UID{0, 0, 0x02, 0x05, 0, 0, 0, 0x01}
+	LockingSP = UID{0, 0, 0x02, 0x05, 0, 0, 0, 0x02}
+
+	// Admin1 is the first admin authority of the Locking SP.
+	Admin1 = UID{0, 0, 0, 0x09, 0, 0x01, 0, 0x01}
+
+	// GlobalRange is the locking range covering all LBAs not in
+	// another range.
+	GlobalRange = UID{0, 0, 0x08, 0x02, 0, 0, 0, 0x01}
+)
+
+// User returns the UID of user authority n of the Locking SP, starting
+// at 1.
+func User(n uint8) UID {
+	return UID{0, 0, 0, 0x09, 0, 0x03, 0, n}
+}
+
+// LockingRange returns the UID of locking range n, where 0 is the global
+// range.
+func LockingRange(n uint8) UID {
+	if n == 0 {
+		return GlobalRange
+	}
+	return UID{0, 0, 0x08, 0x02, 0, 0x03, 0, n}
+}
+
+// Feature codes of Level 0 Discovery.
+const (
+	featureTPer       = 0x0001
+	featureLocking    = 0x0002
+	featureEnterprise = 0x0100
+	featureOpal1      = 0x0200
+	featureOpal2      = 0x0203
+	featureOpalite    = 0x0301
+	featurePyrite1    = 0x0302
+	featurePyrite2    = 0x0303
+	featureRuby       = 0x0304
+)
+
+// sscNames are the security subsystem classes, in order of preference
+// when a drive reports more than one.
+var sscNames = []struct {
+	code uint16
+	name string
+}{
+	{featureOpal2, "Opal 2"},
+	{featureRuby, "Ruby"},
+	{featureOpal1, "Opal 1"},
+	{featureOpalite, "Opalite"},
+	{featurePyrite2, "Pyrite 2"},
+	{featurePyrite1, "Pyrite 1"},
+	{featureEnterprise, "Enterprise"},
+}
+
+// Locking is the Locking feature of Level 0 Discovery.
+type Locking struct {
+	Supported       bool
+	Enabled         bool
+	Locked          bool
+	MediaEncryption bool
+	MBREnabled      bool
+	MBRDone         bool
+}
+
+// Feature is a Level 0 Discovery feature descriptor.
+type Feature struct {
+	Code    uint16
+	Version uint8
+	Data    []byte `json:"-"`
+}
+
+// Discovery is the result of Level 0 Discovery.
+type Discovery struct {
+	Version  uint32
+	Features []Feature
+
+	// SSC is the security subsystem class, e.g. "Opal 2", or empty if
+	// the drive reports none.
+	SSC       string
+	BaseComID uint16
+	NumComIDs uint16
+
+	// Locking is nil if the drive does not report the Locking feature.
+	Locking *Locking
+}
+
+// discoverySize is the transfer size of Level 0 Discovery.
+const discoverySize = 2048
+
+// Discover performs Level 0 Discovery.
+func Discover(t Transport) (*Discovery, error) {
+	b := make([]byte, discoverySize)
+	if err := t.SecurityReceive(protocolTCG, 0x0001, b); err != nil {
+		return nil, err
+	}
+	return parseDiscovery(b)
+}
+
+func parseDiscovery(b []byte) (*Discovery, error) {
+	if len(b) < 48 {
+		return nil, fmt.Errorf("discovery data is %d bytes, want at least 48", len(b))
+	}
+	// The length does not include the length field itself.
+	l := int(binary.BigEndian.Uint32(b[0:])) + 4
+	if l < 48 || l > len(b) {
+		return nil, fmt.Errorf("discovery length %d is out of range [48, %d]", l, len(b))
+	}
+	d := &Discovery{Version: binary.BigEndian.Uint32(b[4:])}
+	for p := b[48:l]; len(p) >= 4; {
+		fl := 4 + int(p[3])
+		if fl > len(p) {
+			return nil, fmt.Errorf("feature %#04x overruns discovery data", binary.BigEndian.Uint16(p))
+		}
+		f := Feature{Code: binary.BigEndian.Uint16(p), Version: p[2] >> 4, Data: p[4:fl]}
+		d.Features = append(d.Features, f)
+		p = p[fl:]
+
+		switch f.Code {
+		case featureLocking:
+			if len(f.Data) < 1 {
+				return nil, fmt.Errorf("locking feature is %d bytes", len(f.Data))
+			}
+			v := f.Data[0]
+			d.Locking = &Locking{
+				Supported:       v&0x01 != 0,
+				Enabled:         v&0x02 != 0,
+				Locked:          v&0x04 != 0,
+				MediaEncryption: v&0x08 != 0,
+				MBREnabled:      v&0x10 != 0,
+				MBRDone:         v&0x20 != 0,
+			}
+		}
+	}
+	for _, s := range sscNames {
+		f := d.feature(s.code)
+		if f == nil || len(f.Data) < 4 {
+			continue
+		}
+		d.SSC = s.name
+		d.BaseComID = binary.BigEndian.Uint16(f.Data[0:])
+		d.NumComIDs = binary.BigEndian.Uint16(f.Data[2:])
+		break
+	}
+	return d, nil
+}
+
+func (d *Discovery) feature(code uint16) *Feature {
+	for i := range d.Features {
+		if d.Features[i].Code == code {
+			return &d.Features[i]
+		}
+	}
+	return nil
+}
+
+// String prints a nice JSON-formatted discovery.
+func (d *Discovery) String() string {
+	s, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return fmt.Sprintf("%v", err)
+	}
+	return string(s)
+}
+
+// SedutilHash derives a credential from a password the way sedutil-cli
+// does: PBKDF2-HMAC-SHA1 with 75000 iterations, salted with the drive's
+// 20 byte serial number as reported, including padding.
+func SedutilHash(password string, serial []byte) []byte {
+	return pbkdf2.Key([]byte(password), serial, 75000, 32, sha1.New)
+}
+
+// UnlockOptions are the parameters of Unlock.
+type UnlockOptions struct {
+	// Authority defaults to Admin1.
+	Authority UID
+
+	// Credential is the authority's PIN.
+	Credential []byte
+
+	// Ranges default to the global range.
+	Ranges []UID
+
+	// MBRDone hides the shadow MBR, exposing the unlocked ranges.
+	MBRDone bool
+}
+
+// Unlock authenticates to the Locking SP and clears the read and write
+// locks of the locking ranges.
+func Unlock(t Transport, o UnlockOptions) error {
+	d, err := Discover(t)
+	if err != nil {
+		return err
+	}
+	if d.Locking == nil || !d.Locking.Supported || d.BaseComID == 0 {
+		return ErrNoLocking
+	}
+	if o.Authority == (UID{}) {
+		o.Authority = Admin1
+	}
+	if len(o.Ranges) == 0 {
+		o.Ranges = []UID{GlobalRange}
+	}
+
+	s, err := StartSession(t, d.BaseComID, LockingSP, o.Authority, o.Credential)
+	if err != nil {
+		return err
+	}
+	for _, r := range o.Ranges {
+		if err := s.Set(r, Column{lockingReadLocked, 0}, Column{lockingWriteLocked, 0}); err != nil {
+			s.Close()
+			return fmt.Errorf("unlocking range %v: %w", r, err)
+		}
+	}
+	if o.MBRDone {
+		if err := s.Set(mbrControl, Column{mbrControlDone, 1}); err != nil {
+			s.Close()
+			return fmt.Errorf("setting MBR done: %w", err)
+		}
+	}
+	return s.Close()
+}
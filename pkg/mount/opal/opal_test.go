@@ -0,0 +1,279 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package opal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	long := bytes.Repeat([]byte{0x55}, 3000)
+	for _, tt := range []struct {
+		name   string
+		encode func(e *encoder)
+		prefix string
+		want   token
+	}{
+		{"tiny", func(e *encoder) { e.uint(0x3f) }, "3f", token{u: 0x3f}},
+		{"short uint", func(e *encoder) { e.uint(0x40) }, "8140", token{u: 0x40}},
+		{"short uint 2", func(e *encoder) { e.uint(0x1234) }, "821234", token{u: 0x1234}},
+		{"short bytes", func(e *encoder) { e.bytes(Admin1[:]) }, "a80000000900010001", token{isBytes: true, b: Admin1[:]}},
+		{"medium bytes", func(e *encoder) { e.bytes(make([]byte, 32)) }, "d020", token{isBytes: true, b: make([]byte, 32)}},
+		{"long bytes", func(e *encoder) { e.bytes(long) }, "e2000bb8", token{isBytes: true, b: long}},
+		{"control", func(e *encoder) { e.token(tokEndOfSession) }, "fa", token{ctrl: tokEndOfSession}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &encoder{}
+			tt.encode(e)
+			if got := hex.EncodeToString(e.b); len(got) < len(tt.prefix) || got[:len(tt.prefix)] != tt.prefix {
+				t.Errorf("encoding = %.20s..., want prefix %s", got, tt.prefix)
+			}
+			toks, err := decodeTokens(e.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(toks) != 1 || !reflect.DeepEqual(toks[0], tt.want) {
+				t.Errorf("decodeTokens = %v, want [%v]", toks, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTokensErrors(t *testing.T) {
+	for _, b := range [][]byte{
+		{0xa8, 0, 0},
+		{0xd0},
+		{0xe2, 0, 0},
+		{0xe4},
+		{0x89, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+	} {
+		if toks, err := decodeTokens(b); err == nil {
+			t.Errorf("decodeTokens(%x) = %v, want error", b, toks)
+		}
+	}
+	// Empty atoms pad the payload.
+	if toks, err := decodeTokens([]byte{tokEndOfSession, tokEmptyAtom, tokEmptyAtom}); err != nil || len(toks) != 1 {
+		t.Errorf("decodeTokens with empty atoms = %v, %v, want one token", toks, err)
+	}
+}
+
+const testComID = 0x07fe
+
+func discoveryData(locking byte, ssc uint16) []byte {
+	b := make([]byte, 48)
+	binary.BigEndian.PutUint32(b[4:], 1)
+	b = append(b, 0x00, 0x01, 0x10, 0x0c, 0x11, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	if locking != 0 {
+		b = append(b, 0x00, 0x02, 0x10, 0x0c, locking, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	}
+	if ssc != 0 {
+		f := make([]byte, 20)
+		binary.BigEndian.PutUint16(f[0:], ssc)
+		f[2], f[3] = 0x20, 16
+		binary.BigEndian.PutUint16(f[4:], testComID)
+		binary.BigEndian.PutUint16(f[6:], 1)
+		b = append(b, f...)
+	}
+	binary.BigEndian.PutUint32(b[0:], uint32(len(b)-4))
+	return b
+}
+
+// fakeTPer is a TPer with a Locking SP and a single credential.
+type fakeTPer struct {
+	discovery []byte
+	pin       []byte
+
+	// busy is the number of empty ComPackets returned before each
+	// response.
+	busy int
+
+	// sent are the payloads received by the TPer.
+	sent [][]byte
+	// tsns are the TPer session numbers of the packets.
+	tsns []uint32
+
+	pending  []byte
+	busyLeft int
+}
+
+const fakeTSN = 0x1001
+
+func (f *fakeTPer) SecuritySend(protocol uint8, spsp uint16, data []byte) error {
+	if protocol != protocolTCG || spsp != testComID {
+		return fmt.Errorf("security send to protocol %d ComID %#x", protocol, spsp)
+	}
+	if len(data)%512 != 0 {
+		return fmt.Errorf("security send of %d bytes", len(data))
+	}
+	payload, err := parseResponse(data)
+	if err != nil {
+		return err
+	}
+	f.sent = append(f.sent, payload)
+	f.tsns = append(f.tsns, binary.BigEndian.Uint32(data[comPacketHeaderLen:]))
+	toks, err := decodeTokens(payload)
+	if err != nil {
+		return err
+	}
+	f.pending = comPacket(spsp, fakeTSN, hostSessionID, f.respond(toks))
+	f.busyLeft = f.busy
+	return nil
+}
+
+func (f *fakeTPer) respond(toks []token) []byte {
+	e := &encoder{}
+	status := StatusSuccess
+	switch {
+	case toks[0].is(tokEndOfSession):
+		return []byte{tokEndOfSession}
+	case toks[1].isUID(smUID):
+		// StartSession; the credential is the token after
+		// StartName and name 0.
+		var cred []byte
+		for i := range toks {
+			if toks[i].is(tokStartName) && toks[i+1].u == 0 {
+				cred = toks[i+2].b
+			}
+		}
+		if !bytes.Equal(cred, f.pin) {
+			status = StatusNotAuthorized
+			break
+		}
+		e.token(tokCall)
+		e.bytes(smUID[:])
+		e.bytes(methodSyncSession[:])
+		e.token(tokStartList)
+		e.uint(hostSessionID)
+		e.uint(fakeTSN)
+		e.token(tokEndList)
+	default:
+		e.token(tokStartList, tokEndList)
+	}
+	e.token(tokEndOfData, tokStartList, byte(status), 0, 0, tokEndList)
+	return e.b
+}
+
+func (f *fakeTPer) SecurityReceive(protocol uint8, spsp uint16, data []byte) error {
+	for i := range data {
+		data[i] = 0
+	}
+	switch {
+	case protocol == protocolTCG && spsp == 0x0001:
+		copy(data, f.discovery)
+	case protocol == protocolTCG && spsp == testComID:
+		if f.busyLeft > 0 {
+			f.busyLeft--
+			return nil
+		}
+		copy(data, f.pending)
+	default:
+		return fmt.Errorf("security receive from protocol %d ComID %#x", protocol, spsp)
+	}
+	return nil
+}
+
+func TestDiscover(t *testing.T) {
+	f := &fakeTPer{discovery: discoveryData(0x0f, featureOpal2)}
+	d, err := Discover(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Discovery{
+		Version: 1,
+		Features: []Feature{
+			{Code: featureTPer, Version: 1, Data: f.discovery[52:64]},
+			{Code: featureLocking, Version: 1, Data: f.discovery[68:80]},
+			{Code: featureOpal2, Version: 2, Data: f.discovery[84:100]},
+		},
+		SSC:       "Opal 2",
+		BaseComID: testComID,
+		NumComIDs: 1,
+		Locking: &Locking{
+			Supported:       true,
+			Enabled:         true,
+			Locked:          true,
+			MediaEncryption: true,
+		},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("Discover = %v, want %v", d, want)
+	}
+
+	bad := discoveryData(0x0f, featureOpal2)
+	bad[51] = 0xff
+	if _, err := parseDiscovery(bad); err == nil {
+		t.Errorf("parseDiscovery with overrunning feature = nil, want error")
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	PollInterval = 0
+	pin := SedutilHash("password", []byte("S4EWNX0R123456      "))
+	f := &fakeTPer{discovery: discoveryData(0x0f, featureOpalite), pin: pin, busy: 2}
+	if err := Unlock(f, UnlockOptions{
+		Authority:  User(1),
+		Credential: pin,
+		Ranges:     []UID{LockingRange(0), LockingRange(2)},
+		MBRDone:    true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	statusList := "f9f0000000f1"
+	want := []string{
+		// StartSession(HostSessionID, LockingSP, Write, HostChallenge, HostSigningAuthority)
+		"f8a800000000000000ffa8000000000000ff02f0" + "8169" + "a80000020500000002" + "01" +
+			"f200d020" + hex.EncodeToString(pin) + "f3" + "f203a80000000900030001f3" + "f1" + statusList,
+		// Set(Values = [ReadLocked = 0, WriteLocked = 0])
+		"f8a80000080200000001a80000000600000017f0f201f0f20700f3f20800f3f1f3f1" + statusList,
+		"f8a80000080200030002a80000000600000017f0f201f0f20700f3f20800f3f1f3f1" + statusList,
+		// Set(Values = [Done = 1])
+		"f8a80000080300000001a80000000600000017f0f201f0f20201f3f1f3f1" + statusList,
+		"fa",
+	}
+	if len(f.sent) != len(want) {
+		t.Fatalf("TPer received %d packets, want %d", len(f.sent), len(want))
+	}
+	for i, w := range want {
+		if got := hex.EncodeToString(f.sent[i]); got != w {
+			t.Errorf("packet %d = %s, want %s", i, got, w)
+		}
+	}
+	if !reflect.DeepEqual(f.tsns, []uint32{0, fakeTSN, fakeTSN, fakeTSN, fakeTSN}) {
+		t.Errorf("TSNs = %#x, want the session manager's then the session's", f.tsns)
+	}
+}
+
+func TestUnlockErrors(t *testing.T) {
+	PollInterval = 0
+	f := &fakeTPer{discovery: discoveryData(0x0f, featureOpal2), pin: []byte("right")}
+	err := Unlock(f, UnlockOptions{Credential: []byte("wrong")})
+	if !errors.Is(err, StatusNotAuthorized) {
+		t.Errorf("Unlock with wrong PIN = %v, want %v", err, StatusNotAuthorized)
+	}
+
+	for _, d := range [][]byte{
+		discoveryData(0, featureOpal2),
+		discoveryData(0x0f, 0),
+	} {
+		f := &fakeTPer{discovery: d}
+		if err := Unlock(f, UnlockOptions{}); err != ErrNoLocking {
+			t.Errorf("Unlock = %v, want %v", err, ErrNoLocking)
+		}
+	}
+}
+
+func TestSedutilHash(t *testing.T) {
+	got := hex.EncodeToString(SedutilHash("password", []byte("S4EWNX0R123456      ")))
+	if want := "dedefedcb71aa86a4b8bb1523dd394d1fed60688f35e1e94cff6c103530dfc7b"; got != want {
+		t.Errorf("SedutilHash = %s, want %s", got, want)
+	}
+}
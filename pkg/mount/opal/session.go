@@ -0,0 +1,276 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package opal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sizes of the headers wrapping a subpacket of tokens.
+const (
+	comPacketHeaderLen = 20
+	packetHeaderLen    = 24
+	subPacketHeaderLen = 12
+
+	// transferSize is the size of Security Receive transfers, the
+	// minimum every TPer must support.
+	transferSize = 2048
+)
+
+// hostSessionID is the host's number for the sessions it starts.
+const hostSessionID = 0x69
+
+// Columns set by Unlock.
+const (
+	valuesName         = 0x01
+	lockingReadLocked  = 0x07
+	lockingWriteLocked = 0x08
+	mbrControlDone     = 0x02
+)
+
+// PollInterval and PollTimeout control how long a Session waits for the
+// TPer to produce a response.
+var (
+	PollInterval = 10 * time.Millisecond
+	PollTimeout  = 10 * time.Second
+)
+
+// MethodStatus is the status of a failed method call.
+type MethodStatus uint8
+
+// Method status codes.
+const (
+	StatusSuccess             MethodStatus = 0x00
+	StatusNotAuthorized       MethodStatus = 0x01
+	StatusSPBusy              MethodStatus = 0x03
+	StatusSPFailed            MethodStatus = 0x04
+	StatusSPDisabled          MethodStatus = 0x05
+	StatusSPFrozen            MethodStatus = 0x06
+	StatusNoSessionsAvailable MethodStatus = 0x07
+	StatusUniquenessConflict  MethodStatus = 0x08
+	StatusInsufficientSpace   MethodStatus = 0x09
+	StatusInsufficientRows    MethodStatus = 0x0a
+	StatusInvalidParameter    MethodStatus = 0x0c
+	StatusTPerMalfunction     MethodStatus = 0x0f
+	StatusTransactionFailure  MethodStatus = 0x10
+	StatusResponseOverflow    MethodStatus = 0x11
+	StatusAuthorityLockedOut  MethodStatus = 0x12
+	StatusFail                MethodStatus = 0x3f
+)
+
+var methodStatusStrings = map[MethodStatus]string{
+	StatusSuccess:             "success",
+	StatusNotAuthorized:       "not authorized",
+	StatusSPBusy:              "SP busy",
+	StatusSPFailed:            "SP failed",
+	StatusSPDisabled:          "SP disabled",
+	StatusSPFrozen:            "SP frozen",
+	StatusNoSessionsAvailable: "no sessions available",
+	StatusUniquenessConflict:  "uniqueness conflict",
+	StatusInsufficientSpace:   "insufficient space",
+	StatusInsufficientRows:    "insufficient rows",
+	StatusInvalidParameter:    "invalid parameter",
+	StatusTPerMalfunction:     "TPer malfunction",
+	StatusTransactionFailure:  "transaction failure",
+	StatusResponseOverflow:    "response overflow",
+	StatusAuthorityLockedOut:  "authority locked out",
+	StatusFail:                "fail",
+}
+
+func (s MethodStatus) Error() string {
+	if str, ok := methodStatusStrings[s]; ok {
+		return "TCG method status: " + str
+	}
+	return fmt.Sprintf("TCG method status %#02x", uint8(s))
+}
+
+// ErrSessionClosed is returned when the TPer aborts a session.
+var ErrSessionClosed = errors.New("session closed by TPer")
+
+// Column is a column number and value of a table row.
+type Column struct {
+	ID    uint64
+	Value uint64
+}
+
+// Session is a session with a security provider. Before StartSession
+// completes, it is a session with the session manager.
+type Session struct {
+	t     Transport
+	comID uint16
+
+	// tsn and hsn are the TPer and host session numbers.
+	tsn uint32
+	hsn uint32
+}
+
+// StartSession opens a read-write session with security provider sp,
+// authenticating as authority with credential. An empty authority opens
+// an anonymous session.
+func StartSession(t Transport, comID uint16, sp, authority UID, credential []byte) (*Session, error) {
+	s := &Session{t: t, comID: comID}
+	res, err := s.call(smUID, methodStartSession, func(e *encoder) {
+		e.uint(hostSessionID)
+		e.bytes(sp[:])
+		e.uint(1) // Write
+		if authority != (UID{}) {
+			e.namedBytes(0, credential)   // HostChallenge
+			e.namedBytes(3, authority[:]) // HostSigningAuthority
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("starting session with SP %v: %w", sp, err)
+	}
+	// The session manager answers with a call to SyncSession.
+	if len(res) < 6 || !res[0].is(tokCall) || !res[1].isUID(smUID) || !res[2].isUID(methodSyncSession) ||
+		!res[3].is(tokStartList) || res[4].ctrl != 0 || res[5].ctrl != 0 {
+		return nil, fmt.Errorf("starting session with SP %v: unexpected response %v", sp, res)
+	}
+	s.hsn, s.tsn = uint32(res[4].u), uint32(res[5].u)
+	Debug("opal: started session TSN %#x HSN %#x with SP %v", s.tsn, s.hsn, sp)
+	return s, nil
+}
+
+// Set sets columns of a table row.
+func (s *Session) Set(row UID, cols ...Column) error {
+	_, err := s.call(row, methodSet, func(e *encoder) {
+		e.token(tokStartName)
+		e.uint(valuesName)
+		e.token(tokStartList)
+		for _, c := range cols {
+			e.namedUint(c.ID, c.Value)
+		}
+		e.token(tokEndList)
+		e.token(tokEndName)
+	})
+	return err
+}
+
+// Close ends the session.
+func (s *Session) Close() error {
+	if err := s.send([]byte{tokEndOfSession}); err != nil {
+		return err
+	}
+	res, err := s.receive()
+	if err != nil {
+		return err
+	}
+	toks, err := decodeTokens(res)
+	if err != nil {
+		return err
+	}
+	if len(toks) != 1 || !toks[0].is(tokEndOfSession) {
+		return fmt.Errorf("ending session: unexpected response %v", toks)
+	}
+	return nil
+}
+
+// call invokes method on invoking, with the arguments encoded by args,
+// and returns the results preceding the status list.
+func (s *Session) call(invoking, method UID, args func(e *encoder)) ([]token, error) {
+	e := &encoder{}
+	e.token(tokCall)
+	e.bytes(invoking[:])
+	e.bytes(method[:])
+	e.token(tokStartList)
+	args(e)
+	e.token(tokEndList)
+	e.token(tokEndOfData)
+	e.token(tokStartList, 0, 0, 0, tokEndList)
+	if err := s.send(e.b); err != nil {
+		return nil, err
+	}
+
+	res, err := s.receive()
+	if err != nil {
+		return nil, err
+	}
+	toks, err := decodeTokens(res)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) > 0 && toks[0].is(tokEndOfSession) {
+		return nil, ErrSessionClosed
+	}
+	// The results end with EndOfData and the status list.
+	n := len(toks)
+	if n < 6 || !toks[n-6].is(tokEndOfData) || !toks[n-5].is(tokStartList) || !toks[n-1].is(tokEndList) {
+		return nil, fmt.Errorf("method response has no status list: %v", toks)
+	}
+	if st := MethodStatus(toks[n-4].u); st != StatusSuccess {
+		return nil, st
+	}
+	return toks[:n-6], nil
+}
+
+// send wraps a subpacket payload in packet headers and sends it.
+func (s *Session) send(payload []byte) error {
+	return s.t.SecuritySend(protocolTCG, s.comID, comPacket(s.comID, s.tsn, s.hsn, payload))
+}
+
+// comPacket returns a ComPacket holding one packet with one subpacket.
+func comPacket(comID uint16, tsn, hsn uint32, payload []byte) []byte {
+	subLen := len(payload)
+	padded := (subLen + 3) &^ 3
+	packetLen := subPacketHeaderLen + padded
+	comPacketLen := packetHeaderLen + packetLen
+	// Transfers are whole blocks.
+	b := make([]byte, (comPacketHeaderLen+comPacketLen+511)&^511)
+
+	binary.BigEndian.PutUint16(b[4:], comID)
+	binary.BigEndian.PutUint32(b[16:], uint32(comPacketLen))
+
+	p := b[comPacketHeaderLen:]
+	binary.BigEndian.PutUint32(p[0:], tsn)
+	binary.BigEndian.PutUint32(p[4:], hsn)
+	binary.BigEndian.PutUint32(p[20:], uint32(packetLen))
+
+	sp := p[packetHeaderLen:]
+	binary.BigEndian.PutUint32(sp[8:], uint32(subLen))
+	copy(sp[subPacketHeaderLen:], payload)
+	return b
+}
+
+// receive polls for a response and returns its subpacket payload.
+func (s *Session) receive() ([]byte, error) {
+	deadline := time.Now().Add(PollTimeout)
+	b := make([]byte, transferSize)
+	for {
+		if err := s.t.SecurityReceive(protocolTCG, s.comID, b); err != nil {
+			return nil, err
+		}
+		// A ComPacket without packets means the response is not
+		// ready yet.
+		if binary.BigEndian.Uint32(b[16:]) != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no response from TPer after %v", PollTimeout)
+		}
+		time.Sleep(PollInterval)
+	}
+	return parseResponse(b)
+}
+
+func parseResponse(b []byte) ([]byte, error) {
+	comPacketLen := int(binary.BigEndian.Uint32(b[16:]))
+	if comPacketLen < packetHeaderLen+subPacketHeaderLen || comPacketHeaderLen+comPacketLen > len(b) {
+		return nil, fmt.Errorf("ComPacket length %d is out of range", comPacketLen)
+	}
+	p := b[comPacketHeaderLen : comPacketHeaderLen+comPacketLen]
+	packetLen := int(binary.BigEndian.Uint32(p[20:]))
+	if packetLen < subPacketHeaderLen || packetHeaderLen+packetLen > len(p) {
+		return nil, fmt.Errorf("packet length %d is out of range", packetLen)
+	}
+	sp := p[packetHeaderLen : packetHeaderLen+packetLen]
+	subLen := int(binary.BigEndian.Uint32(sp[8:]))
+	if subPacketHeaderLen+subLen > len(sp) {
+		return nil, fmt.Errorf("subpacket length %d is out of range", subLen)
+	}
+	return sp[subPacketHeaderLen : subPacketHeaderLen+subLen], nil
+}
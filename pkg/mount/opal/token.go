@@ -0,0 +1,166 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package opal
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Control tokens.
+const (
+	tokStartList        = 0xf0
+	tokEndList          = 0xf1
+	tokStartName        = 0xf2
+	tokEndName          = 0xf3
+	tokCall             = 0xf8
+	tokEndOfData        = 0xf9
+	tokEndOfSession     = 0xfa
+	tokStartTransaction = 0xfb
+	tokEndTransaction   = 0xfc
+	tokEmptyAtom        = 0xff
+)
+
+// encoder builds a token stream.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) token(t ...byte) {
+	e.b = append(e.b, t...)
+}
+
+// uint encodes v as a tiny atom if it fits, else as a short atom.
+func (e *encoder) uint(v uint64) {
+	if v < 0x40 {
+		e.b = append(e.b, byte(v))
+		return
+	}
+	n := 0
+	for x := v; x != 0; x >>= 8 {
+		n++
+	}
+	e.b = append(e.b, 0x80|byte(n))
+	for i := n - 1; i >= 0; i-- {
+		e.b = append(e.b, byte(v>>(8*i)))
+	}
+}
+
+// bytes encodes b as a short, medium or long byte atom.
+func (e *encoder) bytes(b []byte) {
+	switch l := len(b); {
+	case l < 0x10:
+		e.b = append(e.b, 0xa0|byte(l))
+	case l < 0x800:
+		e.b = append(e.b, 0xd0|byte(l>>8), byte(l))
+	default:
+		e.b = append(e.b, 0xe2, byte(l>>16), byte(l>>8), byte(l))
+	}
+	e.b = append(e.b, b...)
+}
+
+// namedUint encodes the named value name = v.
+func (e *encoder) namedUint(name, v uint64) {
+	e.token(tokStartName)
+	e.uint(name)
+	e.uint(v)
+	e.token(tokEndName)
+}
+
+// namedBytes encodes the named value name = b.
+func (e *encoder) namedBytes(name uint64, b []byte) {
+	e.token(tokStartName)
+	e.uint(name)
+	e.bytes(b)
+	e.token(tokEndName)
+}
+
+// token is a decoded control token or atom.
+type token struct {
+	// ctrl is the control token, or 0 for atoms.
+	ctrl byte
+
+	// isBytes is true for byte atoms, whose value is in b. Integer
+	// atoms are in u.
+	isBytes bool
+	u       uint64
+	b       []byte
+}
+
+func (t token) String() string {
+	switch {
+	case t.ctrl != 0:
+		return fmt.Sprintf("%#02x", t.ctrl)
+	case t.isBytes:
+		return fmt.Sprintf("%x", t.b)
+	}
+	return fmt.Sprintf("%d", t.u)
+}
+
+func (t token) is(ctrl byte) bool {
+	return t.ctrl == ctrl
+}
+
+func (t token) isUID(u UID) bool {
+	return t.isBytes && bytes.Equal(t.b, u[:])
+}
+
+// decodeTokens splits a subpacket payload into tokens. Empty atoms are
+// dropped.
+func decodeTokens(b []byte) ([]token, error) {
+	var toks []token
+	for len(b) > 0 {
+		h := b[0]
+		var l, hl int
+		var isBytes bool
+		switch {
+		case h < 0x80:
+			// Tiny atom; signed tiny atoms are not used by the
+			// methods here.
+			toks = append(toks, token{u: uint64(h & 0x3f)})
+			b = b[1:]
+			continue
+		case h < 0xc0:
+			hl, l, isBytes = 1, int(h&0xf), h&0x20 != 0
+		case h < 0xe0:
+			if len(b) < 2 {
+				return nil, fmt.Errorf("truncated medium atom")
+			}
+			hl, l, isBytes = 2, int(h&0x7)<<8|int(b[1]), h&0x10 != 0
+		case h < 0xe4:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("truncated long atom")
+			}
+			hl, l, isBytes = 4, int(b[1])<<16|int(b[2])<<8|int(b[3]), h&0x2 != 0
+		case h == tokEmptyAtom:
+			b = b[1:]
+			continue
+		case h >= tokStartList:
+			toks = append(toks, token{ctrl: h})
+			b = b[1:]
+			continue
+		default:
+			return nil, fmt.Errorf("reserved token %#02x", h)
+		}
+		if len(b) < hl+l {
+			return nil, fmt.Errorf("atom of %d bytes overruns payload of %d bytes", l, len(b)-hl)
+		}
+		v := b[hl : hl+l]
+		b = b[hl+l:]
+		if isBytes {
+			toks = append(toks, token{isBytes: true, b: v})
+			continue
+		}
+		if l > 8 {
+			return nil, fmt.Errorf("integer atom of %d bytes", l)
+		}
+		var u uint64
+		for _, c := range v {
+			u = u<<8 | uint64(c)
+		}
+		toks = append(toks, token{u: u})
+	}
+	return toks, nil
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // direction is the transfer direction.
@@ -27,7 +28,8 @@ const (
 
 	//	ataUsingLBA uint8 = (1 << 6)  nolint:golint,unused
 	//	ataStatDRQ  uint8 = (1 << 3)  nolint:golint,unused
-	//	ataStatErr  uint8 = (1 << 0)  nolint:golint,unused
+	ataStatErr uint8 = (1 << 0)
+	ataStatDF  uint8 = (1 << 5)
 
 	//	read  uint8 = 0  nolint:golint,unused
 	// ataTo int32 = 1
@@ -152,5 +154,93 @@ func unpackIdentify(s statusBlock, d dataBlock, w wordBlock) *Info {
 	info.SecurityStatus = DiskSecurityStatus(binary.LittleEndian.Uint16(d[256:258]))
 
 	info.TrustedComputingSupport = w[48]
+
+	info.NormalEraseTime = eraseTime(binary.LittleEndian.Uint16(d[178:180]))
+	info.EnhancedEraseTime = eraseTime(binary.LittleEndian.Uint16(d[180:182]))
+	info.SanitizeFeatures = SanitizeFeatures(binary.LittleEndian.Uint16(d[118:120]))
+	info.RotationRate = binary.LittleEndian.Uint16(d[434:436])
 	return &info
 }
+
+// eraseTime decodes the SECURITY ERASE UNIT time estimates of words 89 and
+// 90, in units of 2 minutes. Bit 15 selects the extended 15 bit format;
+// otherwise only the low byte is used.
+func eraseTime(w uint16) time.Duration {
+	if w&0x8000 != 0 {
+		w &= 0x7fff
+	} else {
+		w &= 0xff
+	}
+	return time.Duration(w) * 2 * time.Minute
+}
+
+// ataSanitize is the SANITIZE DEVICE command. The action is in the
+// feature register.
+const ataSanitize Cmd = 0xb4
+
+const (
+	sanitizeStatusExt = 0x0000
+
+	// The LBA of sanitize commands must hold a signature to guard
+	// against accidents.
+	cryptoScrambleSignature = 0x43727970   // "Cryp"
+	blockEraseSignature     = 0x426b4572   // "BkEr"
+	overwriteSignature      = 0x4f57 << 32 // "OW", above the pattern
+
+	sanitizeFailureMode = 1 << 4
+	sanitizeInvert      = 1 << 7
+
+	// Bits of the SANITIZE STATUS EXT count.
+	sanitizeStatusSucceeded  = 1 << 15
+	sanitizeStatusInProgress = 1 << 14
+	sanitizeStatusFrozen     = 1 << 13
+)
+
+// ataReturn are the output registers of an ATA command.
+type ataReturn struct {
+	status uint8
+	err    uint8
+	count  uint16
+	lba    uint64
+}
+
+// parseATAReturn returns the ATA Status Return descriptor of descriptor
+// format sense data, as returned for ATA PASS-THROUGH commands with
+// CK_COND set.
+func parseATAReturn(sb statusBlock) (*ataReturn, bool) {
+	if sb[0]&0x7f != 0x72 {
+		return nil, false
+	}
+	l := 8 + int(sb[7])
+	if l > len(sb) {
+		l = len(sb)
+	}
+	for i := 8; i+14 <= l; i += 2 + int(sb[i+1]) {
+		d := sb[i:]
+		if d[0] != 0x09 {
+			continue
+		}
+		r := &ataReturn{
+			err:    d[3],
+			status: d[13],
+			count:  uint16(d[5]),
+			lba:    uint64(d[7]) | uint64(d[9])<<8 | uint64(d[11])<<16,
+		}
+		if d[2]&1 != 0 {
+			r.count |= uint16(d[4]) << 8
+			r.lba |= uint64(d[6])<<24 | uint64(d[8])<<32 | uint64(d[10])<<40
+		}
+		return r, true
+	}
+	return nil, false
+}
+
+// unpackSanitizeStatus unpacks the output of SANITIZE STATUS EXT.
+func unpackSanitizeStatus(r *ataReturn) *SanitizeStatus {
+	return &SanitizeStatus{
+		InProgress: r.count&sanitizeStatusInProgress != 0,
+		Succeeded:  r.count&sanitizeStatusSucceeded != 0,
+		Frozen:     r.count&sanitizeStatusFrozen != 0,
+		Progress:   uint16(r.lba),
+	}
+}
@@ -5,7 +5,9 @@
 package scuzz
 
 import (
+	"encoding/binary"
 	"testing"
+	"time"
 )
 
 func TestAtaString(t *testing.T) {
@@ -51,3 +53,73 @@ func TestMustLBA(t *testing.T) {
 		t.Errorf("good mustLBA: got %v, want nil", err)
 	}
 }
+
+func TestEraseTime(t *testing.T) {
+	for _, tt := range []struct {
+		w    uint16
+		want time.Duration
+	}{
+		{0, 0},
+		{30, time.Hour},
+		// Bits 14:8 are reserved in the short format.
+		{0x7f1e, time.Hour},
+		{0x8000 | 600, 20 * time.Hour},
+	} {
+		if got := eraseTime(tt.w); got != tt.want {
+			t.Errorf("eraseTime(%#x) = %v, want %v", tt.w, got, tt.want)
+		}
+	}
+}
+
+func TestUnpackIdentifySanitize(t *testing.T) {
+	var d dataBlock
+	binary.LittleEndian.PutUint16(d[118:], 0xf000)
+	binary.LittleEndian.PutUint16(d[178:], 5)
+	binary.LittleEndian.PutUint16(d[180:], 0x8000|7)
+	binary.LittleEndian.PutUint16(d[256:], 0x21)
+	binary.LittleEndian.PutUint16(d[434:], 1)
+	info := unpackIdentify(statusBlock{}, d, wordBlock{})
+	if info.NormalEraseTime != 10*time.Minute || info.EnhancedEraseTime != 14*time.Minute {
+		t.Errorf("erase times = %v, %v, want 10m, 14m", info.NormalEraseTime, info.EnhancedEraseTime)
+	}
+	if !info.SecurityStatus.EnhancedEraseSupported() {
+		t.Errorf("EnhancedEraseSupported = false, want true")
+	}
+	for _, a := range []SanitizeAction{SanitizeCryptoScramble, SanitizeBlockErase, SanitizeOverwrite} {
+		if !info.SanitizeFeatures.Supports(a) {
+			t.Errorf("Supports(%v) = false, want true", a)
+		}
+	}
+	if info.RotationRate != 1 {
+		t.Errorf("RotationRate = %d, want 1", info.RotationRate)
+	}
+
+	// Sanitize commands are not supported without the feature set.
+	if SanitizeFeatures(0xe000).Supports(SanitizeBlockErase) {
+		t.Errorf("Supports(block-erase) without the SANITIZE feature set = true, want false")
+	}
+}
+
+func TestParseATAReturn(t *testing.T) {
+	// SANITIZE STATUS EXT in progress at 50%.
+	sb := statusBlock{
+		0x72, 0x01, 0x00, 0x1d, 0, 0, 0, 0x0e,
+		0x09, 0x0c, 0x01, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x40, 0x50,
+	}
+	r, ok := parseATAReturn(sb)
+	if !ok {
+		t.Fatalf("parseATAReturn: no descriptor")
+	}
+	if r.status != 0x50 || r.err != 0 || r.count != 0x4000 || r.lba != 0x8000 {
+		t.Errorf("parseATAReturn = %+v", r)
+	}
+	s := unpackSanitizeStatus(r)
+	if !s.InProgress || s.Succeeded || s.Frozen || s.Percent() != 50 {
+		t.Errorf("unpackSanitizeStatus = %+v", s)
+	}
+
+	// Fixed format sense has no descriptors.
+	if _, ok := parseATAReturn(statusBlock{0x70, 0, 0x05}); ok {
+		t.Errorf("parseATAReturn(fixed format) = ok, want !ok")
+	}
+}
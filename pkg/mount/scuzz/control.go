@@ -19,6 +19,7 @@ const (
 	securityLocked       DiskSecurityStatus = 0x4
 	securityFrozen       DiskSecurityStatus = 0x8
 	securityCountExpired DiskSecurityStatus = 0x10
+	securityEnhanced     DiskSecurityStatus = 0x20
 	securityLevelMax     DiskSecurityStatus = 0x100
 )
 
@@ -28,6 +29,7 @@ var securityStatusStrings = map[DiskSecurityStatus]string{
 	securityLocked:       "LOCKED",
 	securityFrozen:       "FROZEN",
 	securityCountExpired: "COUNT EXPIRED",
+	securityEnhanced:     "ENHANCED ERASE",
 	securityLevelMax:     "LEVEL MAX",
 }
 
@@ -39,6 +41,17 @@ type Info struct {
 	SecurityStatus          DiskSecurityStatus
 	TrustedComputingSupport uint16
 
+	// NormalEraseTime and EnhancedEraseTime are the drive's estimates
+	// for SECURITY ERASE UNIT, or 0 if it does not report them.
+	NormalEraseTime   time.Duration
+	EnhancedEraseTime time.Duration
+
+	SanitizeFeatures SanitizeFeatures
+
+	// RotationRate is the nominal media rotation rate in rpm, 1 for
+	// solid state devices, or 0 if not reported.
+	RotationRate uint16
+
 	Serial           string
 	Model            string
 	FirmwareRevision string
@@ -92,6 +105,12 @@ func (d DiskSecurityStatus) SecurityCountExpired() bool {
 	return (d & securityCountExpired) != 0
 }
 
+// EnhancedEraseSupported returns true if the disk supports the enhanced
+// mode of SECURITY ERASE UNIT.
+func (d DiskSecurityStatus) EnhancedEraseSupported() bool {
+	return (d & securityEnhanced) != 0
+}
+
 func (d DiskSecurityStatus) String() string {
 	s := "Security Status: "
 	for v, name := range securityStatusStrings {
@@ -110,3 +129,104 @@ func (i *Info) String() string {
 	}
 	return string(s)
 }
+
+const (
+	sanitizeSupported      SanitizeFeatures = 1 << 12
+	sanitizeCryptoScramble SanitizeFeatures = 1 << 13
+	sanitizeOverwrite      SanitizeFeatures = 1 << 14
+	sanitizeBlockErase     SanitizeFeatures = 1 << 15
+)
+
+// SanitizeFeatures are the SANITIZE commands a disk supports.
+type SanitizeFeatures uint16
+
+// Supported returns true if the disk supports the SANITIZE feature set.
+func (f SanitizeFeatures) Supported() bool {
+	return (f & sanitizeSupported) != 0
+}
+
+// Supports returns true if the disk supports the sanitize action a.
+func (f SanitizeFeatures) Supports(a SanitizeAction) bool {
+	if !f.Supported() {
+		return false
+	}
+	switch a {
+	case SanitizeCryptoScramble:
+		return (f & sanitizeCryptoScramble) != 0
+	case SanitizeBlockErase:
+		return (f & sanitizeBlockErase) != 0
+	case SanitizeOverwrite:
+		return (f & sanitizeOverwrite) != 0
+	}
+	return false
+}
+
+// SanitizeAction is a SANITIZE DEVICE command, named by its feature code.
+type SanitizeAction uint16
+
+// Sanitize actions.
+const (
+	SanitizeCryptoScramble SanitizeAction = 0x0011
+	SanitizeBlockErase     SanitizeAction = 0x0012
+	SanitizeOverwrite      SanitizeAction = 0x0014
+)
+
+var sanitizeActionStrings = map[SanitizeAction]string{
+	SanitizeCryptoScramble: "crypto-scramble",
+	SanitizeBlockErase:     "block-erase",
+	SanitizeOverwrite:      "overwrite",
+}
+
+func (a SanitizeAction) String() string {
+	if s, ok := sanitizeActionStrings[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("SanitizeAction(%#x)", uint16(a))
+}
+
+// SanitizeOptions are the parameters of Sanitize.
+type SanitizeOptions struct {
+	Action SanitizeAction
+
+	// AllowUnrestrictedExit lets a failed sanitize be exited with any
+	// command, rather than only a new sanitize (the FAILURE MODE bit).
+	AllowUnrestrictedExit bool
+
+	// OverwritePattern, OverwritePasses and InvertPattern are used by
+	// SanitizeOverwrite. 0 passes means 16.
+	OverwritePattern uint32
+	OverwritePasses  uint8
+	InvertPattern    bool
+}
+
+// SanitizeStatus is the output of SANITIZE STATUS EXT.
+type SanitizeStatus struct {
+	InProgress bool
+	Succeeded  bool
+	Frozen     bool
+
+	// Progress is the fraction completed, in 65536ths, while
+	// InProgress.
+	Progress uint16
+}
+
+// Percent returns the progress in percent.
+func (s *SanitizeStatus) Percent() float64 {
+	if !s.InProgress {
+		return 100
+	}
+	return float64(s.Progress) * 100 / 65536
+}
+
+// Capacity is the result of the SCSI READ CAPACITY command.
+type Capacity struct {
+	// Blocks is the number of logical blocks.
+	Blocks    uint64
+	BlockSize uint32
+
+	// Provisioned is true if logical block provisioning management,
+	// and thus UNMAP, is enabled. ReadsZeros is true if unmapped
+	// blocks read as zeros.
+	Provisioned bool
+	ReadsZeros  bool
+}
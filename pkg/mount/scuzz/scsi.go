@@ -0,0 +1,84 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scuzz
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SCSI commands, for disks which are not ATA, or which are behind a SCSI
+// translation layer.
+const (
+	scsiFormatUnit     = 0x04
+	scsiInquiry        = 0x12
+	scsiUnmap          = 0x42
+	scsiServiceIn16    = 0x9e
+	readCapacity16     = 0x10
+	readCapacity16Len  = 32
+	vpdBlockLimits     = 0xb0
+	vpdBlockLimitsLen  = 64
+	unmapParametersLen = 24
+)
+
+func readCapacityCDB() []byte {
+	cdb := make([]byte, 16)
+	cdb[0] = scsiServiceIn16
+	cdb[1] = readCapacity16
+	binary.BigEndian.PutUint32(cdb[10:], readCapacity16Len)
+	return cdb
+}
+
+func unpackCapacity(b []byte) *Capacity {
+	return &Capacity{
+		Blocks:      binary.BigEndian.Uint64(b[0:]) + 1,
+		BlockSize:   binary.BigEndian.Uint32(b[8:]),
+		Provisioned: b[14]&0x80 != 0,
+		ReadsZeros:  b[14]&0x40 != 0,
+	}
+}
+
+func blockLimitsCDB() []byte {
+	return []byte{scsiInquiry, 0x01, vpdBlockLimits, 0, vpdBlockLimitsLen, 0}
+}
+
+// unpackMaxUnmap returns the MAXIMUM UNMAP LBA COUNT of the Block Limits
+// VPD page.
+func unpackMaxUnmap(b []byte) uint32 {
+	return binary.BigEndian.Uint32(b[20:])
+}
+
+// unmapCommand returns the CDB and parameter list of an UNMAP of count
+// blocks at lba.
+func unmapCommand(lba uint64, count uint32) ([]byte, []byte) {
+	cdb := make([]byte, 10)
+	cdb[0] = scsiUnmap
+	binary.BigEndian.PutUint16(cdb[7:], unmapParametersLen)
+
+	p := make([]byte, unmapParametersLen)
+	// The data lengths do not include their own fields.
+	binary.BigEndian.PutUint16(p[0:], unmapParametersLen-2)
+	binary.BigEndian.PutUint16(p[2:], 16)
+	binary.BigEndian.PutUint64(p[8:], lba)
+	binary.BigEndian.PutUint32(p[16:], count)
+	return cdb, p
+}
+
+// formatUnitCDB returns a FORMAT UNIT without parameter list, which
+// formats with the device's defaults.
+func formatUnitCDB() []byte {
+	return []byte{scsiFormatUnit, 0, 0, 0, 0, 0}
+}
+
+// senseError describes fixed or descriptor format sense data.
+func senseError(sb statusBlock) error {
+	switch sb[0] & 0x7f {
+	case 0x70, 0x71:
+		return fmt.Errorf("sense key %#x, ASC/ASCQ %#02x/%#02x", sb[2]&0xf, sb[12], sb[13])
+	case 0x72, 0x73:
+		return fmt.Errorf("sense key %#x, ASC/ASCQ %#02x/%#02x", sb[1]&0xf, sb[2], sb[3])
+	}
+	return fmt.Errorf("sense data %#02x", sb[0])
+}
@@ -0,0 +1,51 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scuzz
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUnpackCapacity(t *testing.T) {
+	b := make([]byte, readCapacity16Len)
+	copy(b, []byte{0, 0, 0, 0, 0x74, 0x70, 0x6d, 0xaf, 0, 0, 0x02, 0})
+	b[14] = 0xc0
+	c := unpackCapacity(b)
+	want := Capacity{Blocks: 1953525168, BlockSize: 512, Provisioned: true, ReadsZeros: true}
+	if *c != want {
+		t.Errorf("unpackCapacity = %+v, want %+v", *c, want)
+	}
+}
+
+func TestUnmapCommand(t *testing.T) {
+	cdb, params := unmapCommand(0x0102030405060708, 0x0a0b0c0d)
+	if want := []byte{0x42, 0, 0, 0, 0, 0, 0, 0, 24, 0}; !bytes.Equal(cdb, want) {
+		t.Errorf("UNMAP CDB = %x, want %x", cdb, want)
+	}
+	want := []byte{
+		0, 22, 0, 16, 0, 0, 0, 0,
+		1, 2, 3, 4, 5, 6, 7, 8,
+		0x0a, 0x0b, 0x0c, 0x0d, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(params, want) {
+		t.Errorf("UNMAP parameters = %x, want %x", params, want)
+	}
+}
+
+func TestSenseError(t *testing.T) {
+	for _, tt := range []struct {
+		sb   statusBlock
+		want string
+	}{
+		{statusBlock{0x70, 0, 0x05, 0, 0, 0, 0, 0x0a, 0, 0, 0, 0, 0x20, 0x00}, "sense key 0x5, ASC/ASCQ 0x20/0x00"},
+		{statusBlock{0x72, 0x03, 0x11, 0x00}, "sense key 0x3, ASC/ASCQ 0x11/0x00"},
+		{statusBlock{0x7f}, "sense data 0x7f"},
+	} {
+		if got := senseError(tt.sb).Error(); got != tt.want {
+			t.Errorf("senseError(%x) = %q, want %q", tt.sb[:4], got, tt.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package scuzz
 import (
 	"fmt"
 	"os"
+	"runtime"
 	"time"
 	"unsafe"
 
@@ -118,6 +119,13 @@ type packet struct {
 	status  statusBlock
 	block   dataBlock
 	word    wordBlock
+
+	// buf is the data of SCSI commands, which may be larger than a
+	// block.
+	buf []byte
+
+	// ret are the output registers of ATA commands that return them.
+	ret *ataReturn
 }
 
 type diskFile interface {
@@ -144,7 +152,27 @@ func NewSGDisk(n string, opt ...SGDiskOpt) (*SGDisk, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewSGDiskFromFile(f, opt...)
+	s, err := NewSGDiskFromFile(f, opt...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSCSIDisk returns a Disk that uses the Linux SCSI Generic Device
+// without requiring ATA pass-through, for the SCSI commands such as
+// ReadCapacity, Unmap and FormatUnit.
+func NewSCSIDisk(n string, opt ...SGDiskOpt) (*SGDisk, error) {
+	f, err := os.OpenFile(n, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &SGDisk{f: f, Timeout: DefaultTimeout}
+	for _, o := range opt {
+		o(s)
+	}
+	return s, nil
 }
 
 func NewSGDiskFromFile(f diskFile, opt ...SGDiskOpt) (*SGDisk, error) {
@@ -198,12 +226,12 @@ func (p *packet) genCommandDataBlock() {
 	p.command[4] = uint8(p.features)
 	p.command[5] = uint8(p.nsect >> 8)
 	p.command[6] = uint8(p.nsect)
-	p.command[7] = uint8(p.lba >> 8)
+	p.command[7] = uint8(p.lba >> 24)
 	p.command[8] = uint8(p.lba)
-	p.command[9] = uint8(p.lba >> 24)
-	p.command[10] = uint8(p.lba >> 16)
+	p.command[9] = uint8(p.lba >> 32)
+	p.command[10] = uint8(p.lba >> 8)
 	p.command[11] = uint8(p.lba >> 40)
-	p.command[12] = uint8(p.lba >> 32)
+	p.command[12] = uint8(p.lba >> 16)
 	p.command[13] = p.dev
 	p.command[14] = uint8(p.cmd)
 }
@@ -270,18 +298,135 @@ func (s *SGDisk) Identify() (*Info, error) {
 	return unpackIdentify(p.status, p.block, p.word), nil
 }
 
+func (s *SGDisk) setPasswordPacket(password string, admin bool) *packet {
+	p := s.newPacket(unix.WIN_SECURITY_SET_PASS, _SG_DXFER_TO_DEV, 0)
+	p.genCommandDataBlock()
+	if admin {
+		p.block[0] = 1
+	}
+	copy(p.block[2:34], []byte(password))
+	return p
+}
+
+// SetPassword sets the user password, or the master password if admin is
+// true. Setting the user password enables security.
+func (s *SGDisk) SetPassword(password string, admin bool) error {
+	return s.operate(s.setPasswordPacket(password, admin))
+}
+
+// nonDataPacket returns a packet for an ATA command without data, which
+// returns its output registers.
+func (s *SGDisk) nonDataPacket(cmd Cmd, ataType uint8, features, count uint16, lba uint64) *packet {
+	p := s.newPacket(cmd, _SG_DXFER_NONE, ataType)
+	p.dataLen = 0
+	p.features = features
+	p.nsect = count
+	p.lba = lba
+	p.genCommandDataBlock()
+	return p
+}
+
+func (s *SGDisk) erasePreparePacket() *packet {
+	return s.nonDataPacket(unix.WIN_SECURITY_ERASE_PREPARE, 0, 0, 0, 0)
+}
+
+func (s *SGDisk) eraseUnitPacket(password string, admin, enhanced bool, timeout time.Duration) *packet {
+	p := s.newPacket(unix.WIN_SECURITY_ERASE_UNIT, _SG_DXFER_TO_DEV, 0)
+	p.timeout = uint32(timeout / time.Millisecond)
+	p.genCommandDataBlock()
+	if admin {
+		p.block[0] |= 1
+	}
+	if enhanced {
+		p.block[0] |= 2
+	}
+	copy(p.block[2:34], []byte(password))
+	return p
+}
+
+// SecurityErase erases the disk with SECURITY ERASE PREPARE and SECURITY
+// ERASE UNIT, in enhanced mode if enhanced is true. Security must be
+// enabled with SetPassword; a successful erase disables it again.
+//
+// The erase takes about the disk's NormalEraseTime or EnhancedEraseTime;
+// timeout should allow for that.
+func (s *SGDisk) SecurityErase(password string, admin, enhanced bool, timeout time.Duration) error {
+	if err := s.operate(s.erasePreparePacket()); err != nil {
+		return err
+	}
+	return s.operate(s.eraseUnitPacket(password, admin, enhanced, timeout))
+}
+
+func (s *SGDisk) sanitizePacket(o SanitizeOptions) *packet {
+	var count uint16
+	var lba uint64
+	if o.AllowUnrestrictedExit {
+		count |= sanitizeFailureMode
+	}
+	switch o.Action {
+	case SanitizeCryptoScramble:
+		lba = cryptoScrambleSignature
+	case SanitizeBlockErase:
+		lba = blockEraseSignature
+	case SanitizeOverwrite:
+		lba = overwriteSignature | uint64(o.OverwritePattern)
+		count |= uint16(o.OverwritePasses & 0xf)
+		if o.InvertPattern {
+			count |= sanitizeInvert
+		}
+	}
+	return s.nonDataPacket(ataSanitize, lba48, uint16(o.Action), count, lba)
+}
+
+// Sanitize starts a SANITIZE DEVICE operation. It returns once the
+// operation has started; use SanitizeStatus for its progress.
+func (s *SGDisk) Sanitize(o SanitizeOptions) error {
+	if _, ok := sanitizeActionStrings[o.Action]; !ok {
+		return fmt.Errorf("unknown sanitize action %v", o.Action)
+	}
+	return s.operate(s.sanitizePacket(o))
+}
+
+func (s *SGDisk) sanitizeStatusPacket() *packet {
+	return s.nonDataPacket(ataSanitize, lba48, sanitizeStatusExt, 0, 0)
+}
+
+// SanitizeStatus returns the status of the last sanitize operation.
+func (s *SGDisk) SanitizeStatus() (*SanitizeStatus, error) {
+	p := s.sanitizeStatusPacket()
+	if err := s.operate(p); err != nil {
+		return nil, err
+	}
+	if p.ret == nil {
+		return nil, &os.PathError{Op: "SANITIZE STATUS EXT", Path: s.f.Name(), Err: fmt.Errorf("no ATA return descriptor")}
+	}
+	return unpackSanitizeStatus(p.ret), nil
+}
+
 // _SG_IO is the ioctl request number for SCSI operations.
 const _SG_IO = 0x2285
 
 func (s *SGDisk) operate(p *packet) error {
 	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.f.Fd()), _SG_IO, uintptr(unsafe.Pointer(&p.packetHeader)))
-	sb := p.status[0]
-	if errno != 0 || sb != 0 {
-		return &os.PathError{
-			Op:   "ioctl SG_IO",
-			Path: s.f.Name(),
-			Err:  fmt.Errorf("SCSI generic error %v and drive error status %#02x", errno, sb),
+	if sb := p.status[0]; errno != 0 || sb != 0 {
+		// Commands with CK_COND set return their output registers
+		// as sense data.
+		r, ok := parseATAReturn(p.status)
+		if errno != 0 || !ok {
+			return &os.PathError{
+				Op:   "ioctl SG_IO",
+				Path: s.f.Name(),
+				Err:  fmt.Errorf("SCSI generic error %v and drive error status %#02x", errno, sb),
+			}
+		}
+		if r.status&(ataStatErr|ataStatDF) != 0 {
+			return &os.PathError{
+				Op:   "ioctl SG_IO",
+				Path: s.f.Name(),
+				Err:  fmt.Errorf("ATA status %#02x, error %#02x", r.status, r.err),
+			}
 		}
+		p.ret = r
 	}
 	w, err := p.block.toWordBlock()
 	if err != nil {
@@ -299,6 +444,74 @@ func (s *SGDisk) operate(p *packet) error {
 	return nil
 }
 
+// scsiPacket returns a packet for a SCSI command transferring data.
+func (s *SGDisk) scsiPacket(cdb []byte, direction direction, data []byte, timeout time.Duration) *packet {
+	p := s.newPacket(0, direction, 0)
+	p.cmdLen = uint8(copy(p.command[:], cdb))
+	p.buf = data
+	p.dataLen = uint32(len(data))
+	p.data = 0
+	if len(data) > 0 {
+		p.data = uintptr(unsafe.Pointer(&data[0]))
+	}
+	p.timeout = uint32(timeout / time.Millisecond)
+	return p
+}
+
+// _DRIVER_SENSE is set in driverStatus when there is sense data.
+const _DRIVER_SENSE = 0x08
+
+func (s *SGDisk) operateSCSI(p *packet) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.f.Fd()), _SG_IO, uintptr(unsafe.Pointer(&p.packetHeader)))
+	runtime.KeepAlive(p.buf)
+	var err error
+	switch {
+	case errno != 0:
+		err = errno
+	case p.packetHeader.status != 0 && p.sbLen != 0:
+		err = senseError(p.status)
+	case p.packetHeader.status != 0:
+		err = fmt.Errorf("SCSI status %#02x", p.packetHeader.status)
+	case p.hostStatus != 0 || p.driverStatus&^_DRIVER_SENSE != 0:
+		err = fmt.Errorf("SCSI generic host status %#x, driver status %#x", p.hostStatus, p.driverStatus)
+	}
+	if err != nil {
+		return &os.PathError{Op: fmt.Sprintf("ioctl SG_IO %#02x", p.command[0]), Path: s.f.Name(), Err: err}
+	}
+	return nil
+}
+
+// ReadCapacity returns the capacity of the disk with READ CAPACITY (16).
+func (s *SGDisk) ReadCapacity() (*Capacity, error) {
+	b := make([]byte, readCapacity16Len)
+	if err := s.operateSCSI(s.scsiPacket(readCapacityCDB(), _SG_DXFER_FROM_DEV, b, s.Timeout)); err != nil {
+		return nil, err
+	}
+	return unpackCapacity(b), nil
+}
+
+// MaxUnmap returns the maximum number of blocks a single Unmap may
+// cover, from the Block Limits VPD page.
+func (s *SGDisk) MaxUnmap() (uint32, error) {
+	b := make([]byte, vpdBlockLimitsLen)
+	if err := s.operateSCSI(s.scsiPacket(blockLimitsCDB(), _SG_DXFER_FROM_DEV, b, s.Timeout)); err != nil {
+		return 0, err
+	}
+	return unpackMaxUnmap(b), nil
+}
+
+// Unmap deallocates count blocks at lba with UNMAP.
+func (s *SGDisk) Unmap(lba uint64, count uint32) error {
+	cdb, params := unmapCommand(lba, count)
+	return s.operateSCSI(s.scsiPacket(cdb, _SG_DXFER_TO_DEV, params, s.Timeout))
+}
+
+// FormatUnit formats the disk with FORMAT UNIT and the device's default
+// parameters. Formatting can take hours; timeout should allow for that.
+func (s *SGDisk) FormatUnit(timeout time.Duration) error {
+	return s.operateSCSI(s.scsiPacket(formatUnitCDB(), _SG_DXFER_NONE, nil, timeout))
+}
+
 // SGDiskOpt allows callers of NewSGDisk to set values
 type SGDiskOpt func(*SGDisk)
 
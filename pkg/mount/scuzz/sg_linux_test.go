@@ -9,6 +9,7 @@ package scuzz
 
 import (
 	"testing"
+	"time"
 	"unsafe"
 )
 
@@ -187,3 +188,125 @@ func TestIdentify(t *testing.T) {
 	p := (&SGDisk{dev: 0x40, Timeout: DefaultTimeout}).identifyPacket()
 	check(t, p, want)
 }
+
+func TestSecurityErasePackets(t *testing.T) {
+	d := &SGDisk{dev: 0x40, Timeout: DefaultTimeout}
+
+	want := &packet{
+		packetHeader: packetHeader{
+			interfaceID:       'S',
+			direction:         -2,
+			cmdLen:            16,
+			maxStatusBlockLen: 32,
+			dataLen:           512,
+			timeout:           15000,
+		},
+		command: commandDataBlock{0x85, 0x0a, 0x06, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0xf1, 0x00},
+		block:   dataBlock{0x00, 0x00, 'w', 'i', 'p', 'e'},
+	}
+	check(t, d.setPasswordPacket("wipe", false), want)
+
+	want = &packet{
+		packetHeader: packetHeader{
+			interfaceID:       'S',
+			direction:         -1,
+			cmdLen:            16,
+			maxStatusBlockLen: 32,
+			timeout:           15000,
+		},
+		command: commandDataBlock{0x85, 0x06, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0xf3, 0x00},
+	}
+	check(t, d.erasePreparePacket(), want)
+
+	want = &packet{
+		packetHeader: packetHeader{
+			interfaceID:       'S',
+			direction:         -2,
+			cmdLen:            16,
+			maxStatusBlockLen: 32,
+			dataLen:           512,
+			timeout:           7200000,
+		},
+		command: commandDataBlock{0x85, 0x0a, 0x06, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0xf4, 0x00},
+		block:   dataBlock{0x03, 0x00, 'w', 'i', 'p', 'e'},
+	}
+	check(t, d.eraseUnitPacket("wipe", true, true, 2*time.Hour), want)
+}
+
+func TestSanitizePackets(t *testing.T) {
+	d := &SGDisk{dev: 0x40, Timeout: DefaultTimeout}
+	header := packetHeader{
+		interfaceID:       'S',
+		direction:         -1,
+		cmdLen:            16,
+		maxStatusBlockLen: 32,
+		timeout:           15000,
+	}
+	for _, tt := range []struct {
+		name string
+		p    *packet
+		cdb  commandDataBlock
+	}{
+		{
+			name: "crypto scramble",
+			p:    d.sanitizePacket(SanitizeOptions{Action: SanitizeCryptoScramble}),
+			cdb:  commandDataBlock{0x85, 0x07, 0x20, 0x00, 0x11, 0x00, 0x00, 0x43, 0x70, 0x00, 0x79, 0x00, 0x72, 0x40, 0xb4, 0x00},
+		},
+		{
+			name: "block erase",
+			p:    d.sanitizePacket(SanitizeOptions{Action: SanitizeBlockErase, AllowUnrestrictedExit: true}),
+			cdb:  commandDataBlock{0x85, 0x07, 0x20, 0x00, 0x12, 0x00, 0x10, 0x42, 0x72, 0x00, 0x45, 0x00, 0x6b, 0x40, 0xb4, 0x00},
+		},
+		{
+			name: "overwrite",
+			p:    d.sanitizePacket(SanitizeOptions{Action: SanitizeOverwrite, OverwritePattern: 0xdeadbeef, OverwritePasses: 3, InvertPattern: true}),
+			cdb:  commandDataBlock{0x85, 0x07, 0x20, 0x00, 0x14, 0x00, 0x83, 0xde, 0xef, 0x57, 0xbe, 0x4f, 0xad, 0x40, 0xb4, 0x00},
+		},
+		{
+			name: "status",
+			p:    d.sanitizeStatusPacket(),
+			cdb:  commandDataBlock{0x85, 0x07, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0xb4, 0x00},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			check(t, tt.p, &packet{packetHeader: header, command: tt.cdb})
+		})
+	}
+}
+
+func TestSCSIPackets(t *testing.T) {
+	d := &SGDisk{Timeout: DefaultTimeout}
+	cdb, params := unmapCommand(0x12345678, 0x10000)
+	p := d.scsiPacket(cdb, _SG_DXFER_TO_DEV, params, time.Minute)
+	want := &packet{
+		packetHeader: packetHeader{
+			interfaceID:       'S',
+			direction:         -2,
+			cmdLen:            10,
+			maxStatusBlockLen: 32,
+			dataLen:           24,
+			timeout:           60000,
+		},
+		command: commandDataBlock{0x42, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x18, 0x00},
+	}
+	check(t, p, want)
+	if p.data != uintptr(unsafe.Pointer(&params[0])) {
+		t.Errorf("data does not point at the parameter list")
+	}
+
+	p = d.scsiPacket(formatUnitCDB(), _SG_DXFER_NONE, nil, 4*time.Hour)
+	want = &packet{
+		packetHeader: packetHeader{
+			interfaceID:       'S',
+			direction:         -1,
+			cmdLen:            6,
+			maxStatusBlockLen: 32,
+			timeout:           14400000,
+		},
+		command: commandDataBlock{0x04},
+	}
+	check(t, p, want)
+	if p.data != 0 {
+		t.Errorf("data = %#x, want 0 for FORMAT UNIT", p.data)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultNTPKeys is where ntpd keeps its symmetric keys.
+const DefaultNTPKeys = "/etc/ntp.keys"
+
+// maxDigestLen is the maximum length of the digest in the NTP MAC field;
+// longer digests are truncated, as ntpd does.
+const maxDigestLen = 20
+
+// Key is a symmetric key for NTP authentication, RFC 5905, Section 7.3.
+//
+// The message authentication code is the digest of the key followed by
+// the NTP packet.
+type Key struct {
+	ID   uint32
+	Type string
+	Key  []byte
+}
+
+func (k *Key) hash() (hash.Hash, error) {
+	switch strings.ToUpper(k.Type) {
+	case "MD5", "M":
+		return md5.New(), nil
+	case "SHA1", "SHA-1":
+		return sha1.New(), nil
+	case "SHA256", "SHA-256":
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Type)
+}
+
+func (k *Key) digest(pkt []byte) ([]byte, error) {
+	h, err := k.hash()
+	if err != nil {
+		return nil, err
+	}
+	h.Write(k.Key)
+	h.Write(pkt)
+	d := h.Sum(nil)
+	if len(d) > maxDigestLen {
+		d = d[:maxDigestLen]
+	}
+	return d, nil
+}
+
+// sign appends the MAC of pkt to pkt.
+func (k *Key) sign(pkt []byte) ([]byte, error) {
+	d, err := k.digest(pkt)
+	if err != nil {
+		return nil, err
+	}
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], k.ID)
+	pkt = append(pkt, id[:]...)
+	return append(pkt, d...), nil
+}
+
+var errNoMAC = errors.New("response is not authenticated")
+
+// verify checks the MAC that follows the NTP header in pkt.
+func (k *Key) verify(pkt []byte) error {
+	if len(pkt) < packetLen+4 {
+		return errNoMAC
+	}
+	// Extension fields may sit between header and MAC; the MAC is
+	// always at the end.
+	d, err := k.digest(nil)
+	if err != nil {
+		return err
+	}
+	macLen := 4 + len(d)
+	if len(pkt) < packetLen+macLen {
+		return errNoMAC
+	}
+	mac := pkt[len(pkt)-macLen:]
+	if id := binary.BigEndian.Uint32(mac); id != k.ID {
+		return fmt.Errorf("response signed with key %d, want %d", id, k.ID)
+	}
+	want, err := k.digest(pkt[:len(pkt)-macLen])
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(mac[4:], want) != 1 {
+		return errors.New("response has a bad MAC")
+	}
+	return nil
+}
+
+// ReadKeys parses keys in ntpd's ntp.keys format:
+//
+//	# keyid type key
+//	1 MD5 secret
+//	2 SHA1 0123456789abcdef0123456789abcdef01234567
+//
+// Keys of up to 20 characters are taken literally, longer keys are hex.
+func ReadKeys(r io.Reader) (map[uint32]*Key, error) {
+	keys := make(map[uint32]*Key)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := s.Text()
+		if i := strings.IndexByte(l, '#'); i >= 0 {
+			l = l[:i]
+		}
+		f := strings.Fields(l)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) < 3 {
+			return nil, fmt.Errorf("line %d: want keyid type key, got %q", n, l)
+		}
+		id, err := strconv.ParseUint(f[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad key id: %v", n, err)
+		}
+		k := &Key{ID: uint32(id), Type: f[1], Key: []byte(f[2])}
+		if len(f[2]) > maxDigestLen {
+			if k.Key, err = hex.DecodeString(f[2]); err != nil {
+				return nil, fmt.Errorf("line %d: bad hex key: %v", n, err)
+			}
+		}
+		if _, err := k.hash(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		keys[k.ID] = k
+	}
+	return keys, s.Err()
+}
+
+// ReadKey returns the key with the given ID from an ntp.keys file.
+func ReadKey(path string, id uint32) (*Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys, err := ReadKeys(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	k, ok := keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: no key %d", path, id)
+	}
+	return k, nil
+}
@@ -9,11 +9,10 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/beevik/ntp"
-
 	"github.com/u-root/u-root/pkg/rtc"
 )
 
@@ -41,26 +40,92 @@ func parseServers(r *bufio.Reader) []string {
 	return uri
 }
 
+// Options are optional parameters for SetTimeWithOptions.
+type Options struct {
+	// Samples is the number of queries sent to each server. The one with
+	// the lowest round trip delay is used. Defaults to a single query, as
+	// more take at least 2s each to not be rate limited by the servers.
+	Samples int
+
+	// Timeout is how long to wait for each response. Defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// Key, if set, authenticates requests and responses with a
+	// symmetric key.
+	Key *Key
+
+	// Slew gradually adjusts the clock with adjtimex instead of stepping
+	// it, if the offset is at most MaxSlew.
+	Slew bool
+}
+
+// MaxSlew is the largest offset that is slewed rather than stepped. The
+// kernel slews at 500ppm, so this takes about 17 minutes.
+const MaxSlew = 500 * time.Millisecond
+
 func getTime(servers []string) (time.Time, string, error) {
-	for _, s := range servers {
-		Debug("Getting time from %v", s)
-		t, err := ntp.Time(s)
-		if err == nil {
-			// Right now we return on the first valid time.
-			// We can implement better heuristics here.
-			Debug("Got time %v", t)
-			return t, s, nil
+	return getTimeWithOptions(servers, Options{})
+}
+
+// getTimeWithOptions queries all servers concurrently, drops servers that
+// disagree with the majority and combines the remaining offsets.
+func getTimeWithOptions(servers []string, opts Options) (time.Time, string, error) {
+	if opts.Samples <= 0 {
+		opts.Samples = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	type result struct {
+		p   *peer
+		err error
+	}
+	results := make([]result, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s string) {
+			defer wg.Done()
+			Debug("Getting time from %v", s)
+			p, err := queryServer(s, opts.Samples, opts.Key, opts.Timeout)
+			results[i] = result{p, err}
+		}(i, s)
+	}
+	wg.Wait()
+
+	var peers []*peer
+	for i, r := range results {
+		if r.err != nil {
+			Debug("Error getting time from %s: %v", servers[i], r.err)
+			continue
 		}
-		Debug("Error getting time from %s: %v", s, err)
+		peers = append(peers, r.p)
+	}
+	if len(peers) == 0 {
+		return time.Time{}, "", fmt.Errorf("unable to get any time from servers %v", servers)
 	}
 
-	return time.Time{}, "", fmt.Errorf("unable to get any time from servers %v", servers)
+	survivors, err := selectPeers(peers)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("unable to get time from servers %v: %w", servers, err)
+	}
+	offset, best := combine(survivors)
+	t := time.Now().Add(offset)
+	Debug("Got time %v from %d of %d servers, offset %v", t, len(survivors), len(servers), offset)
+	return t, best.server, nil
 }
 
 // SetTime sets system and optionally RTC time from NTP servers specified in sersers or the config file.
 // If successful, returns the server used to set the time and the offset, in seconds.
 func SetTime(servers []string, config string, fallback string, setRTC bool) (string, float64, error) {
-	return setTime(servers, config, fallback, setRTC, &realGetterSetter{})
+	return SetTimeWithOptions(servers, config, fallback, setRTC, Options{})
+}
+
+// SetTimeWithOptions is SetTime with Options.
+func SetTimeWithOptions(servers []string, config string, fallback string, setRTC bool, opts Options) (string, float64, error) {
+	return setTime(servers, config, fallback, setRTC, &realGetterSetter{opts: opts})
 }
 
 type timeGetterSetter interface {
@@ -69,13 +134,19 @@ type timeGetterSetter interface {
 	SetRTCTime(time.Time) error
 }
 
-type realGetterSetter struct{}
+type realGetterSetter struct {
+	opts Options
+}
 
-func (*realGetterSetter) GetTime(servers []string) (time.Time, string, error) {
-	return getTime(servers)
+func (r *realGetterSetter) GetTime(servers []string) (time.Time, string, error) {
+	return getTimeWithOptions(servers, r.opts)
 }
 
-func (*realGetterSetter) SetSystemTime(t time.Time) error {
+func (r *realGetterSetter) SetSystemTime(t time.Time) error {
+	if offset := time.Until(t); r.opts.Slew && offset <= MaxSlew && offset >= -MaxSlew {
+		Debug("Slewing clock by %v", offset)
+		return slew(offset)
+	}
 	tv := syscall.NsecToTimeval(t.UnixNano())
 	return syscall.Settimeofday(&tv)
 }
@@ -0,0 +1,225 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NTP packet format, RFC 5905, Section 7.3.
+const (
+	packetLen = 48
+
+	version    = 4
+	modeClient = 3
+	modeServer = 4
+
+	// leapAlarm means the server clock is unsynchronized.
+	leapAlarm = 3
+
+	// maxStratum is the highest valid stratum; 16 means unsynchronized.
+	maxStratum = 15
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch
+// (1900-01-01) and the Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// ntpTime is an NTP timestamp: seconds since 1900 in the upper 32 bits
+// and the fraction in the lower 32 bits.
+type ntpTime uint64
+
+func toNTPTime(t time.Time) ntpTime {
+	sec := uint64(t.Unix()) + ntpEpochOffset
+	frac := (uint64(t.Nanosecond()) << 32) / 1e9
+	return ntpTime(sec<<32 | frac)
+}
+
+// Time converts t to a time.Time. Era 0 timestamps before 1968 are
+// assumed to be in era 1 (after 2036).
+func (t ntpTime) Time() time.Time {
+	sec := int64(t >> 32)
+	if sec < 0x80000000 {
+		sec += 1 << 32
+	}
+	nsec := (int64(t&0xffffffff)*1e9 + 1<<31) >> 32
+	return time.Unix(sec-ntpEpochOffset, nsec)
+}
+
+// ntpShort is the NTP short format: 16.16 seconds.
+type ntpShort uint32
+
+func (s ntpShort) Duration() time.Duration {
+	return time.Duration((int64(s)*1e9 + 1<<15) >> 16)
+}
+
+func toNTPShort(d time.Duration) ntpShort {
+	return ntpShort((int64(d) << 16) / 1e9)
+}
+
+// packet is an NTP packet without extension fields.
+type packet struct {
+	leap      uint8
+	version   uint8
+	mode      uint8
+	stratum   uint8
+	poll      int8
+	precision int8
+	rootDelay ntpShort
+	rootDisp  ntpShort
+	refID     uint32
+	refTime   ntpTime
+	origTime  ntpTime
+	recvTime  ntpTime
+	xmitTime  ntpTime
+}
+
+var errShortPacket = errors.New("NTP packet too short")
+
+func (p *packet) marshal() []byte {
+	b := make([]byte, packetLen)
+	b[0] = p.leap<<6 | (p.version&7)<<3 | p.mode&7
+	b[1] = p.stratum
+	b[2] = byte(p.poll)
+	b[3] = byte(p.precision)
+	binary.BigEndian.PutUint32(b[4:], uint32(p.rootDelay))
+	binary.BigEndian.PutUint32(b[8:], uint32(p.rootDisp))
+	binary.BigEndian.PutUint32(b[12:], p.refID)
+	binary.BigEndian.PutUint64(b[16:], uint64(p.refTime))
+	binary.BigEndian.PutUint64(b[24:], uint64(p.origTime))
+	binary.BigEndian.PutUint64(b[32:], uint64(p.recvTime))
+	binary.BigEndian.PutUint64(b[40:], uint64(p.xmitTime))
+	return b
+}
+
+func (p *packet) unmarshal(b []byte) error {
+	if len(b) < packetLen {
+		return errShortPacket
+	}
+	p.leap = b[0] >> 6
+	p.version = (b[0] >> 3) & 7
+	p.mode = b[0] & 7
+	p.stratum = b[1]
+	p.poll = int8(b[2])
+	p.precision = int8(b[3])
+	p.rootDelay = ntpShort(binary.BigEndian.Uint32(b[4:]))
+	p.rootDisp = ntpShort(binary.BigEndian.Uint32(b[8:]))
+	p.refID = binary.BigEndian.Uint32(b[12:])
+	p.refTime = ntpTime(binary.BigEndian.Uint64(b[16:]))
+	p.origTime = ntpTime(binary.BigEndian.Uint64(b[24:]))
+	p.recvTime = ntpTime(binary.BigEndian.Uint64(b[32:]))
+	p.xmitTime = ntpTime(binary.BigEndian.Uint64(b[40:]))
+	return nil
+}
+
+// kissCode returns the ASCII kiss code of a stratum 0 packet, e.g. "RATE"
+// or "DENY".
+func (p *packet) kissCode() string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], p.refID)
+	for i, c := range b {
+		if c < ' ' || c > '~' {
+			return string(b[:i])
+		}
+	}
+	return string(b[:])
+}
+
+// precisionDuration converts a log2 seconds precision to a duration.
+func precisionDuration(p int8) time.Duration {
+	if p >= 0 {
+		return time.Duration(1<<uint(p)) * time.Second
+	}
+	return time.Duration(int64(time.Second) >> uint(-p))
+}
+
+// sample is the result of a single query of a server, RFC 5905, Section 8.
+type sample struct {
+	// offset is how much the local clock is behind the server.
+	offset time.Duration
+	// delay is the round trip delay minus server processing time.
+	delay time.Duration
+	// dispersion is the error due to precision and frequency tolerance.
+	dispersion time.Duration
+
+	stratum   uint8
+	leap      uint8
+	rootDelay time.Duration
+	rootDisp  time.Duration
+}
+
+// phi is the frequency tolerance, 15 ppm.
+const phi = 15e-6
+
+// localPrecision is the assumed precision of the local clock.
+const localPrecision = time.Microsecond
+
+// newSample computes the clock offset and round trip delay from a server
+// response to a request sent at local time t1 and received at t4.
+//
+//	offset = ((t2 - t1) + (t3 - t4)) / 2
+//	delay  = (t4 - t1) - (t3 - t2)
+func newSample(p *packet, t1, t4 time.Time) *sample {
+	t2 := p.recvTime.Time()
+	t3 := p.xmitTime.Time()
+
+	s := &sample{
+		offset:    (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		delay:     t4.Sub(t1) - t3.Sub(t2),
+		stratum:   p.stratum,
+		leap:      p.leap,
+		rootDelay: p.rootDelay.Duration(),
+		rootDisp:  p.rootDisp.Duration(),
+	}
+	if s.delay < localPrecision {
+		s.delay = localPrecision
+	}
+	s.dispersion = precisionDuration(p.precision) + localPrecision +
+		time.Duration(phi*float64(t4.Sub(t1)))
+	return s
+}
+
+// rootDistance is the maximum error of the sample relative to the primary
+// reference clock at the root of the synchronization subnet.
+func (s *sample) rootDistance() time.Duration {
+	return (s.rootDelay+s.delay)/2 + s.rootDisp + s.dispersion
+}
+
+// maxDistance is the highest acceptable root distance, RFC 5905 MAXDIST
+// plus some slack for a single poll.
+const maxDistance = 1500 * time.Millisecond
+
+// validate checks a server response p to a request with transmit timestamp
+// xmit, RFC 5905, Section 8.
+func validate(p *packet, xmit ntpTime) error {
+	if p.mode != modeServer {
+		return fmt.Errorf("unexpected mode %d in response", p.mode)
+	}
+	if p.origTime != xmit {
+		return errors.New("response does not match request")
+	}
+	if p.stratum == 0 {
+		return fmt.Errorf("kiss of death: %q", p.kissCode())
+	}
+	if p.stratum > maxStratum {
+		return fmt.Errorf("server is unsynchronized (stratum %d)", p.stratum)
+	}
+	if p.leap == leapAlarm {
+		return errors.New("server clock is unsynchronized (leap alarm)")
+	}
+	if p.xmitTime == 0 || p.recvTime == 0 {
+		return errors.New("response has zero timestamps")
+	}
+	if p.xmitTime.Time().Before(p.recvTime.Time()) {
+		return errors.New("server transmitted before it received")
+	}
+	if d := p.rootDelay.Duration()/2 + p.rootDisp.Duration(); d > maxDistance {
+		return fmt.Errorf("server root distance %v exceeds %v", d, maxDistance)
+	}
+	return nil
+}
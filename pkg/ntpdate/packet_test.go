@@ -0,0 +1,163 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNTPTime(t *testing.T) {
+	for _, tm := range []time.Time{
+		time.Unix(0, 0),
+		time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.UTC),
+		// After the NTP era rollover.
+		time.Date(2040, 1, 1, 0, 0, 0, 500000000, time.UTC),
+	} {
+		got := toNTPTime(tm).Time()
+		if d := got.Sub(tm); d > time.Nanosecond || d < -time.Nanosecond {
+			t.Errorf("toNTPTime(%v).Time() = %v", tm, got)
+		}
+	}
+	if got, want := ntpShort(0x00018000).Duration(), 1500*time.Millisecond; got != want {
+		t.Errorf("ntpShort(1.5).Duration() = %v, want %v", got, want)
+	}
+}
+
+func TestPacket(t *testing.T) {
+	p := &packet{
+		leap:      1,
+		version:   version,
+		mode:      modeServer,
+		stratum:   3,
+		poll:      6,
+		precision: -23,
+		rootDelay: 0x1234,
+		rootDisp:  0x5678,
+		refID:     0x7f000001,
+		refTime:   1,
+		origTime:  2,
+		recvTime:  3,
+		xmitTime:  4,
+	}
+	b := p.marshal()
+	if len(b) != packetLen || b[0] != 0x64 {
+		t.Fatalf("marshal = %x", b)
+	}
+	var got packet
+	if err := got.unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if got != *p {
+		t.Errorf("unmarshal(marshal(%+v)) = %+v", p, got)
+	}
+	if err := got.unmarshal(b[:40]); err != errShortPacket {
+		t.Errorf("unmarshal(short) = %v, want %v", err, errShortPacket)
+	}
+}
+
+func TestNewSample(t *testing.T) {
+	t1 := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	// The server is 1s ahead, the network takes 10ms each way and the
+	// server needs 1ms to answer.
+	p := &packet{
+		precision: -20,
+		recvTime:  toNTPTime(t1.Add(time.Second + 10*time.Millisecond)),
+		xmitTime:  toNTPTime(t1.Add(time.Second + 11*time.Millisecond)),
+		rootDelay: toNTPShort(20 * time.Millisecond),
+		rootDisp:  toNTPShort(5 * time.Millisecond),
+	}
+	t4 := t1.Add(21 * time.Millisecond)
+	s := newSample(p, t1, t4)
+	if d := s.offset - time.Second; d > time.Microsecond || d < -time.Microsecond {
+		t.Errorf("offset = %v, want 1s", s.offset)
+	}
+	if d := s.delay - 20*time.Millisecond; d > time.Microsecond || d < -time.Microsecond {
+		t.Errorf("delay = %v, want 20ms", s.delay)
+	}
+	if s.rootDistance() < 24*time.Millisecond || s.rootDistance() > 26*time.Millisecond {
+		t.Errorf("root distance = %v, want about 25ms", s.rootDistance())
+	}
+}
+
+func TestKeys(t *testing.T) {
+	keys, err := ReadKeys(strings.NewReader(`
+# keyid type key
+1 MD5 secret
+2 SHA1 0123456789abcdef0123456789abcdef01234567 # hex
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || string(keys[1].Key) != "secret" || len(keys[2].Key) != 20 {
+		t.Fatalf("ReadKeys = %v", keys)
+	}
+
+	for _, bad := range []string{"1 MD5", "x MD5 secret", "1 FOO secret", "1 SHA1 0123456789abcdef0123456789abcdef0123456z"} {
+		if _, err := ReadKeys(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadKeys(%q) succeeded", bad)
+		}
+	}
+
+	pkt := (&packet{version: version, mode: modeClient, xmitTime: 42}).marshal()
+	for id, k := range keys {
+		b, err := k.sign(append([]byte(nil), pkt...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:packetLen], pkt) {
+			t.Errorf("key %d: sign modified the packet", id)
+		}
+		if err := k.verify(b); err != nil {
+			t.Errorf("key %d: verify = %v", id, err)
+		}
+		b[10] ^= 1
+		if err := k.verify(b); err == nil {
+			t.Errorf("key %d: verify of modified packet succeeded", id)
+		}
+		if err := k.verify(pkt); err != errNoMAC {
+			t.Errorf("key %d: verify of unsigned packet = %v, want %v", id, err, errNoMAC)
+		}
+	}
+}
+
+func TestSelectPeers(t *testing.T) {
+	mk := func(name string, offset, dist time.Duration) *peer {
+		return &peer{server: name, sample: &sample{offset: offset, dispersion: dist}}
+	}
+	a := mk("a", 100*time.Millisecond, 20*time.Millisecond)
+	b := mk("b", 110*time.Millisecond, 20*time.Millisecond)
+	c := mk("c", 90*time.Millisecond, 10*time.Millisecond)
+	bad := mk("bad", 5*time.Second, 20*time.Millisecond)
+
+	got, err := selectPeers([]*peer{a, bad, b, c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("selectPeers = %d peers, want 3", len(got))
+	}
+	for _, p := range got {
+		if p == bad {
+			t.Errorf("falseticker %s selected", p.server)
+		}
+	}
+	offset, best := combine(got)
+	if best != c {
+		t.Errorf("best = %s, want c", best.server)
+	}
+	if offset < 90*time.Millisecond || offset > 110*time.Millisecond {
+		t.Errorf("combined offset = %v, want about 100ms", offset)
+	}
+
+	if _, err := selectPeers([]*peer{a, bad}); err != errNoMajority {
+		t.Errorf("selectPeers(a, bad) = %v, want %v", err, errNoMajority)
+	}
+	if got, err := selectPeers([]*peer{bad}); err != nil || len(got) != 1 {
+		t.Errorf("selectPeers(bad) = %v, %v, want [bad]", got, err)
+	}
+}
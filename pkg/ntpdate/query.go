@@ -0,0 +1,152 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultTimeout is how long to wait for each response.
+	DefaultTimeout = 2 * time.Second
+
+	port = "123"
+)
+
+// sampleInterval is the pause between queries to the same server. Most
+// servers rate limit clients polling more often than every 2s.
+var sampleInterval = 2 * time.Second
+
+// serverAddr adds the NTP port to server unless it has one.
+func serverAddr(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, port)
+}
+
+// query sends a single request to server and computes a sample from the
+// response. If key is not nil, the request is signed and the response
+// must be signed with the same key.
+func query(server string, key *Key, timeout time.Duration) (*sample, error) {
+	conn, err := net.Dial("udp", serverAddr(server))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	// The transmit timestamp only has to be echoed by the server, so
+	// randomize it rather than reveal the local time.
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	req := &packet{
+		version:  version,
+		mode:     modeClient,
+		xmitTime: ntpTime(binary.BigEndian.Uint64(nonce[:])),
+	}
+	b := req.marshal()
+	if key != nil {
+		if b, err = key.sign(b); err != nil {
+			return nil, err
+		}
+	}
+
+	t1 := time.Now()
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		t4 := time.Now()
+
+		var resp packet
+		if err := resp.unmarshal(buf[:n]); err != nil {
+			return nil, err
+		}
+		// Stray or replayed packet; keep waiting for ours.
+		if resp.origTime != req.xmitTime {
+			Debug("%s: ignoring response to another request", server)
+			continue
+		}
+		if key != nil {
+			if err := key.verify(buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if err := validate(&resp, req.xmitTime); err != nil {
+			return nil, err
+		}
+		return newSample(&resp, t1, t4), nil
+	}
+}
+
+// peer is the filtered result of several queries to one server.
+type peer struct {
+	server string
+	*sample
+	// jitter is the RMS difference of the sample offsets to the
+	// chosen sample's offset.
+	jitter time.Duration
+}
+
+// queryServer queries server n times and applies the clock filter of RFC
+// 5905, Section 10: the sample with the lowest delay wins, because it has
+// the least asymmetric network delay.
+func queryServer(server string, n int, key *Key, timeout time.Duration) (*peer, error) {
+	var (
+		samples []*sample
+		lastErr error
+	)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			time.Sleep(sampleInterval)
+		}
+		s, err := query(server, key, timeout)
+		if err != nil {
+			Debug("%s: query %d: %v", server, i, err)
+			lastErr = err
+			continue
+		}
+		Debug("%s: offset %v delay %v dispersion %v stratum %d", server, s.offset, s.delay, s.dispersion, s.stratum)
+		samples = append(samples, s)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%s: %w", server, lastErr)
+	}
+
+	best := samples[0]
+	for _, s := range samples[1:] {
+		if s.delay < best.delay {
+			best = s
+		}
+	}
+	var sum float64
+	for _, s := range samples {
+		d := (s.offset - best.offset).Seconds()
+		sum += d * d
+	}
+	p := &peer{
+		server: server,
+		sample: best,
+	}
+	if len(samples) > 1 {
+		p.jitter = time.Duration(math.Sqrt(sum/float64(len(samples)-1)) * float64(time.Second))
+	}
+	return p, nil
+}
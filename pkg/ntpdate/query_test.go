@@ -0,0 +1,152 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer is a local NTP server whose clock is off by offset.
+type fakeServer struct {
+	conn    net.PacketConn
+	offset  time.Duration
+	stratum uint8
+	leap    uint8
+	key     *Key
+	// kiss, if set, is sent as kiss code with stratum 0.
+	kiss string
+}
+
+func newFakeServer(t *testing.T, f *fakeServer) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.conn = conn
+	if f.stratum == 0 && f.kiss == "" {
+		f.stratum = 2
+	}
+	go f.serve()
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String()
+}
+
+func (f *fakeServer) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		recv := time.Now().Add(f.offset)
+		var req packet
+		if err := req.unmarshal(buf[:n]); err != nil {
+			continue
+		}
+		if f.key != nil && f.key.verify(buf[:n]) != nil {
+			continue
+		}
+		resp := &packet{
+			leap:      f.leap,
+			version:   version,
+			mode:      modeServer,
+			stratum:   f.stratum,
+			precision: -20,
+			rootDelay: toNTPShort(time.Millisecond),
+			rootDisp:  toNTPShort(time.Millisecond),
+			refTime:   toNTPTime(recv.Add(-time.Minute)),
+			origTime:  req.xmitTime,
+			recvTime:  toNTPTime(recv),
+		}
+		if f.kiss != "" {
+			resp.refID = binary.BigEndian.Uint32([]byte(f.kiss))
+		}
+		resp.xmitTime = toNTPTime(time.Now().Add(f.offset))
+		b := resp.marshal()
+		if f.key != nil {
+			b, _ = f.key.sign(b)
+		}
+		f.conn.WriteTo(b, addr)
+	}
+}
+
+func init() {
+	sampleInterval = 0
+}
+
+func TestQuery(t *testing.T) {
+	key := &Key{ID: 7, Type: "SHA1", Key: []byte("secret")}
+	for _, tt := range []struct {
+		name   string
+		server fakeServer
+		key    *Key
+		err    string
+	}{
+		{name: "ahead", server: fakeServer{offset: time.Hour}},
+		{name: "behind", server: fakeServer{offset: -3 * time.Second}},
+		{name: "kiss of death", server: fakeServer{kiss: "RATE"}, err: `kiss of death: "RATE"`},
+		{name: "unsynchronized", server: fakeServer{stratum: 16}, err: "unsynchronized"},
+		{name: "leap alarm", server: fakeServer{leap: leapAlarm}, err: "leap alarm"},
+		{name: "authenticated", server: fakeServer{offset: time.Minute, key: key}, key: key},
+		{name: "unauthenticated response", server: fakeServer{offset: time.Minute}, key: key, err: "not authenticated"},
+		{
+			name:   "wrong key",
+			server: fakeServer{key: &Key{ID: 7, Type: "SHA1", Key: []byte("other")}},
+			key:    key,
+			err:    "timeout",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := newFakeServer(t, &tt.server)
+			s, err := query(addr, tt.key, 200*time.Millisecond)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("query = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := s.offset - tt.server.offset; d > 50*time.Millisecond || d < -50*time.Millisecond {
+				t.Errorf("offset = %v, want about %v", s.offset, tt.server.offset)
+			}
+			if s.delay <= 0 || s.delay > 50*time.Millisecond {
+				t.Errorf("delay = %v, want small and positive", s.delay)
+			}
+			if s.stratum != 2 {
+				t.Errorf("stratum = %d, want 2", s.stratum)
+			}
+		})
+	}
+}
+
+func TestGetTimeWithOptions(t *testing.T) {
+	good1 := newFakeServer(t, &fakeServer{offset: 10 * time.Second})
+	good2 := newFakeServer(t, &fakeServer{offset: 10*time.Second + time.Millisecond})
+	bad := newFakeServer(t, &fakeServer{offset: time.Hour})
+
+	now := time.Now()
+	tm, server, err := getTimeWithOptions([]string{bad, good1, good2, "127.0.0.1:1"}, Options{Samples: 2, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != good1 && server != good2 {
+		t.Errorf("server = %s, want %s or %s", server, good1, good2)
+	}
+	if d := tm.Sub(now) - 10*time.Second; d < 0 || d > time.Second {
+		t.Errorf("time is %v ahead, want about 10s", tm.Sub(now))
+	}
+
+	// Two servers disagreeing with no majority.
+	if _, _, err := getTimeWithOptions([]string{bad, good1}, Options{Samples: 1, Timeout: 200 * time.Millisecond}); err == nil {
+		t.Errorf("getTimeWithOptions with no majority succeeded")
+	}
+}
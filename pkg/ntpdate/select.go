@@ -0,0 +1,105 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var errNoMajority = errors.New("no majority of servers agrees on the time")
+
+// selectPeers implements the intersection algorithm of RFC 5905, Section
+// 11.2.1. Each peer's correctness interval is its offset plus and minus its
+// root distance. The smallest interval containing points of at least a
+// majority of the intervals is found; peers whose interval does not
+// intersect it are falsetickers and dropped.
+func selectPeers(peers []*peer) ([]*peer, error) {
+	n := len(peers)
+	if n == 0 {
+		return nil, errNoMajority
+	}
+
+	type edge struct {
+		at   time.Duration
+		kind int // -1 lower, +1 upper
+	}
+	var edges []edge
+	for _, p := range peers {
+		d := p.rootDistance()
+		edges = append(edges,
+			edge{p.offset - d, -1},
+			edge{p.offset + d, +1})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at != edges[j].at {
+			return edges[i].at < edges[j].at
+		}
+		// Lower edges first, so touching intervals overlap.
+		return edges[i].kind < edges[j].kind
+	})
+
+	// Allow f falsetickers, f < n/2.
+	for f := 0; 2*f < n; f++ {
+		var low, high time.Duration
+		found := 0
+		c := 0
+		for _, e := range edges {
+			c -= e.kind
+			if c >= n-f {
+				low = e.at
+				found++
+				break
+			}
+		}
+		c = 0
+		for i := len(edges) - 1; i >= 0; i-- {
+			c += edges[i].kind
+			if c >= n-f {
+				high = edges[i].at
+				found++
+				break
+			}
+		}
+		if found < 2 || low > high {
+			continue
+		}
+
+		var truechimers []*peer
+		for _, p := range peers {
+			d := p.rootDistance()
+			if p.offset+d >= low && p.offset-d <= high {
+				truechimers = append(truechimers, p)
+			} else {
+				Debug("%s: falseticker, offset %v not in [%v, %v]", p.server, p.offset, low, high)
+			}
+		}
+		return truechimers, nil
+	}
+	return nil, errNoMajority
+}
+
+// combine computes the system offset as the average of the peer offsets
+// weighted by the inverse of their root distance, RFC 5905, Section 11.2.3.
+// It also returns the peer with the lowest root distance.
+func combine(peers []*peer) (time.Duration, *peer) {
+	var (
+		x, y float64
+		best *peer
+	)
+	for _, p := range peers {
+		d := p.rootDistance().Seconds()
+		if d <= 0 {
+			d = localPrecision.Seconds()
+		}
+		x += p.offset.Seconds() / d
+		y += 1 / d
+		if best == nil || p.rootDistance() < best.rootDistance() {
+			best = p
+		}
+	}
+	return time.Duration(x / y * float64(time.Second)), best
+}
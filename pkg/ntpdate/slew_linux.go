@@ -0,0 +1,22 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// adjOffsetSingleshot makes adjtimex behave like adjtime(2): the offset,
+// in microseconds, is slewed away at 500ppm.
+const adjOffsetSingleshot = 0x8001
+
+func slew(offset time.Duration) error {
+	tx := unix.Timex{Modes: adjOffsetSingleshot}
+	setTimexOffset(&tx, offset.Microseconds())
+	_, err := unix.Adjtimex(&tx)
+	return err
+}
@@ -0,0 +1,17 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package ntpdate
+
+import (
+	"errors"
+	"time"
+)
+
+func slew(offset time.Duration) error {
+	return errors.New("slewing the clock is not supported")
+}
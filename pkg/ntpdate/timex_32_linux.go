@@ -0,0 +1,14 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build 386 || arm || mips || mipsle || ppc
+// +build 386 arm mips mipsle ppc
+
+package ntpdate
+
+import "golang.org/x/sys/unix"
+
+func setTimexOffset(tx *unix.Timex, us int64) {
+	tx.Offset = int32(us)
+}
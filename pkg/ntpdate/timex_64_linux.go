@@ -0,0 +1,14 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build amd64 || arm64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x || sparc64
+// +build amd64 arm64 mips64 mips64le ppc64 ppc64le riscv64 s390x sparc64
+
+package ntpdate
+
+import "golang.org/x/sys/unix"
+
+func setTimexOffset(tx *unix.Timex, us int64) {
+	tx.Offset = us
+}
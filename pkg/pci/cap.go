@@ -0,0 +1,604 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pci
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Capability list registers.
+const (
+	// CapPointer is the offset of the first capability.
+	CapPointer = 0x34
+	// StatusCap is set in Status if the device has a capability list.
+	StatusCap = 0x10
+	// ExtCapBase is the offset of the first extended capability.
+	ExtCapBase = 0x100
+)
+
+// CapID is the ID of a capability. Standard and extended capabilities have
+// their own IDs.
+type CapID uint16
+
+// Standard capability IDs.
+const (
+	CapPM      CapID = 0x01
+	CapAGP     CapID = 0x02
+	CapVPD     CapID = 0x03
+	CapSlotID  CapID = 0x04
+	CapMSI     CapID = 0x05
+	CapHotSwap CapID = 0x06
+	CapPCIX    CapID = 0x07
+	CapHT      CapID = 0x08
+	CapVendor  CapID = 0x09
+	CapDebug   CapID = 0x0a
+	CapSHPC    CapID = 0x0c
+	CapSSVID   CapID = 0x0d
+	CapSecure  CapID = 0x0f
+	CapExpress CapID = 0x10
+	CapMSIX    CapID = 0x11
+	CapSATA    CapID = 0x12
+	CapAF      CapID = 0x13
+	CapEA      CapID = 0x14
+)
+
+// Extended capability IDs.
+const (
+	ExtCapAER    CapID = 0x0001
+	ExtCapVC     CapID = 0x0002
+	ExtCapDSN    CapID = 0x0003
+	ExtCapPower  CapID = 0x0004
+	ExtCapVendor CapID = 0x000b
+	ExtCapACS    CapID = 0x000d
+	ExtCapARI    CapID = 0x000e
+	ExtCapATS    CapID = 0x000f
+	ExtCapSRIOV  CapID = 0x0010
+	ExtCapLTR    CapID = 0x0018
+	ExtCapSecPCI CapID = 0x0019
+	ExtCapPASID  CapID = 0x001b
+	ExtCapL1SS   CapID = 0x001e
+	ExtCapDLF    CapID = 0x0025
+	ExtCapPL16   CapID = 0x0026
+)
+
+var capNames = map[CapID]string{
+	CapPM:      "Power Management",
+	CapAGP:     "AGP",
+	CapVPD:     "Vital Product Data",
+	CapSlotID:  "Slot ID",
+	CapMSI:     "MSI",
+	CapHotSwap: "CompactPCI hot-swap",
+	CapPCIX:    "PCI-X",
+	CapHT:      "HyperTransport",
+	CapVendor:  "Vendor Specific Information",
+	CapDebug:   "Debug port",
+	CapSHPC:    "PCI Hot-plug",
+	CapSSVID:   "Subsystem",
+	CapSecure:  "Secure device",
+	CapExpress: "Express",
+	CapMSIX:    "MSI-X",
+	CapSATA:    "SATA HBA",
+	CapAF:      "PCI Advanced Features",
+	CapEA:      "Enhanced Allocation",
+}
+
+var extCapNames = map[CapID]string{
+	ExtCapAER:    "Advanced Error Reporting",
+	ExtCapVC:     "Virtual Channel",
+	ExtCapDSN:    "Device Serial Number",
+	ExtCapPower:  "Power Budgeting",
+	ExtCapVendor: "Vendor Specific Information",
+	ExtCapACS:    "Access Control Services",
+	ExtCapARI:    "Alternative Routing-ID Interpretation (ARI)",
+	ExtCapATS:    "Address Translation Service (ATS)",
+	ExtCapSRIOV:  "Single Root I/O Virtualization (SR-IOV)",
+	ExtCapLTR:    "Latency Tolerance Reporting",
+	ExtCapSecPCI: "Secondary PCI Express",
+	ExtCapPASID:  "Process Address Space ID (PASID)",
+	ExtCapL1SS:   "L1 PM Substates",
+	ExtCapDLF:    "Data Link Feature",
+	ExtCapPL16:   "Physical Layer 16.0 GT/s",
+}
+
+// ErrNoCapability is returned when a device lacks a capability, or the
+// config space read is too short to hold it.
+var ErrNoCapability = errors.New("no such capability")
+
+// Capability is an entry of the capability list or of the extended
+// capability list.
+type Capability struct {
+	ID CapID
+	// Extended is set for PCI Express extended capabilities.
+	Extended bool
+	// Version is the version of an extended capability.
+	Version byte
+	// Offset is the offset of the capability in config space.
+	Offset int
+}
+
+// Name returns the name of the capability.
+func (c Capability) Name() string {
+	names := capNames
+	if c.Extended {
+		names = extCapNames
+	}
+	if n, ok := names[c.ID]; ok {
+		return n
+	}
+	return fmt.Sprintf("Unknown (%#02x)", uint16(c.ID))
+}
+
+// String implements Stringer, as lspci does.
+func (c Capability) String() string {
+	if c.Extended {
+		return fmt.Sprintf("[%03x v%d] %s", c.Offset, c.Version, c.Name())
+	}
+	return fmt.Sprintf("[%02x] %s", c.Offset, c.Name())
+}
+
+// Capabilities returns the capabilities in the config space read, first the
+// standard then the extended ones. Standard capabilities need the 256 byte
+// config space, and extended ones the 4K PCI Express config space.
+func (p *PCI) Capabilities() ([]Capability, error) {
+	c := p.Config
+	if len(c) < ConfigSize || binary.LittleEndian.Uint16(c[6:8])&StatusCap == 0 {
+		return nil, nil
+	}
+	var caps []Capability
+	// Each capability takes at least 4 bytes, which bounds a looping list.
+	for off, n := int(c[CapPointer]&^3), 0; off != 0; off, n = int(c[off+1]&^3), n+1 {
+		if off < StdConfigSize || n > (ConfigSize-StdConfigSize)/4 {
+			return caps, fmt.Errorf("%s: bad capability pointer %#x", p.Addr, off)
+		}
+		caps = append(caps, Capability{ID: CapID(c[off]), Offset: off})
+	}
+	if len(c) < FullConfigSize {
+		return caps, nil
+	}
+	for off, n := ExtCapBase, 0; off != 0; n++ {
+		if off < ExtCapBase || n > (FullConfigSize-ExtCapBase)/4 {
+			return caps, fmt.Errorf("%s: bad extended capability pointer %#x", p.Addr, off)
+		}
+		h := binary.LittleEndian.Uint32(c[off:])
+		if h == 0 || h == 0xffffffff {
+			break
+		}
+		caps = append(caps, Capability{ID: CapID(h), Extended: true, Version: byte(h >> 16 & 0xf), Offset: off})
+		off = int(h>>20) &^ 3
+	}
+	return caps, nil
+}
+
+// FindCapability returns the first capability with id.
+func (p *PCI) FindCapability(id CapID, extended bool) (Capability, error) {
+	caps, err := p.Capabilities()
+	for _, c := range caps {
+		if c.ID == id && c.Extended == extended {
+			return c, nil
+		}
+	}
+	if err != nil {
+		return Capability{}, err
+	}
+	return Capability{}, fmt.Errorf("%s: %w %s", p.Addr, ErrNoCapability, Capability{ID: id, Extended: extended}.Name())
+}
+
+// capBytes returns the n bytes of the capability with id.
+func (p *PCI) capBytes(id CapID, extended bool, n int) ([]byte, error) {
+	c, err := p.FindCapability(id, extended)
+	if err != nil {
+		return nil, err
+	}
+	if c.Offset+n > len(p.Config) {
+		return nil, fmt.Errorf("%s: %w: %s is beyond the config space read", p.Addr, ErrNoCapability, c)
+	}
+	return p.Config[c.Offset : c.Offset+n], nil
+}
+
+func u16(b []byte, off int) uint16 { return binary.LittleEndian.Uint16(b[off:]) }
+func u32(b []byte, off int) uint32 { return binary.LittleEndian.Uint32(b[off:]) }
+
+// flags formats bits like lspci, as Name+ or Name-.
+func flags(v uint32, bits []struct {
+	bit  uint
+	name string
+}) string {
+	s := make([]string, len(bits))
+	for i, b := range bits {
+		s[i] = b.name + plus(v&(1<<b.bit) != 0)
+	}
+	return strings.Join(s, " ")
+}
+
+func plus(b bool) string {
+	if b {
+		return "+"
+	}
+	return "-"
+}
+
+// PowerManagement is the Power Management capability.
+type PowerManagement struct {
+	Version byte
+	D1, D2  bool
+	// PME are the states that can assert PME#, D0 in bit 0 to D3cold in
+	// bit 4.
+	PME byte
+	// State is the power state, 0 to 3 for D0 to D3hot.
+	State       int
+	NoSoftReset bool
+	PMEEnable   bool
+	PMEStatus   bool
+}
+
+// PowerManagement decodes the Power Management capability.
+func (p *PCI) PowerManagement() (*PowerManagement, error) {
+	b, err := p.capBytes(CapPM, false, 8)
+	if err != nil {
+		return nil, err
+	}
+	pmc, csr := u16(b, 2), u16(b, 4)
+	return &PowerManagement{
+		Version:     byte(pmc & 7),
+		D1:          pmc&(1<<9) != 0,
+		D2:          pmc&(1<<10) != 0,
+		PME:         byte(pmc >> 11),
+		State:       int(csr & 3),
+		NoSoftReset: csr&(1<<3) != 0,
+		PMEEnable:   csr&(1<<8) != 0,
+		PMEStatus:   csr&(1<<15) != 0,
+	}, nil
+}
+
+func (pw *PowerManagement) info() []string {
+	var pme []string
+	for i, d := range []string{"D0", "D1", "D2", "D3hot", "D3cold"} {
+		pme = append(pme, d+plus(pw.PME&(1<<i) != 0))
+	}
+	return []string{
+		fmt.Sprintf("Flags: version %d D1%s D2%s PME(%s)", pw.Version, plus(pw.D1), plus(pw.D2), strings.Join(pme, ",")),
+		fmt.Sprintf("Status: D%d NoSoftRst%s PME-Enable%s PME%s", pw.State, plus(pw.NoSoftReset), plus(pw.PMEEnable), plus(pw.PMEStatus)),
+	}
+}
+
+// MSI is the Message Signaled Interrupts capability.
+type MSI struct {
+	Enable bool
+	// Count is the number of vectors enabled, out of Max.
+	Count, Max int
+	Is64       bool
+	Maskable   bool
+	Address    uint64
+	Data       uint16
+}
+
+// MSI decodes the MSI capability.
+func (p *PCI) MSI() (*MSI, error) {
+	b, err := p.capBytes(CapMSI, false, 10)
+	if err != nil {
+		return nil, err
+	}
+	ctl := u16(b, 2)
+	m := &MSI{
+		Enable:   ctl&1 != 0,
+		Max:      1 << (ctl >> 1 & 7),
+		Count:    1 << (ctl >> 4 & 7),
+		Is64:     ctl&(1<<7) != 0,
+		Maskable: ctl&(1<<8) != 0,
+		Address:  uint64(u32(b, 4)),
+		Data:     u16(b, 8),
+	}
+	if m.Is64 {
+		if b, err = p.capBytes(CapMSI, false, 14); err != nil {
+			return nil, err
+		}
+		m.Address |= uint64(u32(b, 8)) << 32
+		m.Data = u16(b, 12)
+	}
+	return m, nil
+}
+
+func (m *MSI) info() []string {
+	return []string{
+		fmt.Sprintf("Enable%s Count=%d/%d Maskable%s 64bit%s", plus(m.Enable), m.Count, m.Max, plus(m.Maskable), plus(m.Is64)),
+		fmt.Sprintf("Address: %016x  Data: %04x", m.Address, m.Data),
+	}
+}
+
+// MSIX is the MSI-X capability.
+type MSIX struct {
+	Enable bool
+	// Masked is set if all vectors are masked.
+	Masked bool
+	// Count is the number of vectors.
+	Count int
+	// TableBAR and TableOffset locate the vector table.
+	TableBAR    int
+	TableOffset uint32
+	// PBABAR and PBAOffset locate the pending bit array.
+	PBABAR    int
+	PBAOffset uint32
+}
+
+// MSIX decodes the MSI-X capability.
+func (p *PCI) MSIX() (*MSIX, error) {
+	b, err := p.capBytes(CapMSIX, false, 12)
+	if err != nil {
+		return nil, err
+	}
+	ctl, table, pba := u16(b, 2), u32(b, 4), u32(b, 8)
+	return &MSIX{
+		Enable:      ctl&(1<<15) != 0,
+		Masked:      ctl&(1<<14) != 0,
+		Count:       int(ctl&0x7ff) + 1,
+		TableBAR:    int(table & 7),
+		TableOffset: table &^ 7,
+		PBABAR:      int(pba & 7),
+		PBAOffset:   pba &^ 7,
+	}, nil
+}
+
+func (m *MSIX) info() []string {
+	return []string{
+		fmt.Sprintf("Enable%s Count=%d Masked%s", plus(m.Enable), m.Count, plus(m.Masked)),
+		fmt.Sprintf("Vector table: BAR=%d offset=%08x", m.TableBAR, m.TableOffset),
+		fmt.Sprintf("PBA: BAR=%d offset=%08x", m.PBABAR, m.PBAOffset),
+	}
+}
+
+// ExpressType is the device or port type of a PCI Express function.
+type ExpressType byte
+
+// PCI Express device and port types.
+const (
+	ExpressEndpoint       ExpressType = 0
+	ExpressLegacyEndpoint ExpressType = 1
+	ExpressRootPort       ExpressType = 4
+	ExpressUpstream       ExpressType = 5
+	ExpressDownstream     ExpressType = 6
+	ExpressPCIBridge      ExpressType = 7
+	ExpressPCIeBridge     ExpressType = 8
+	ExpressRCEndpoint     ExpressType = 9
+	ExpressRCEC           ExpressType = 10
+)
+
+var expressTypes = map[ExpressType]string{
+	ExpressEndpoint:       "Endpoint",
+	ExpressLegacyEndpoint: "Legacy Endpoint",
+	ExpressRootPort:       "Root Port",
+	ExpressUpstream:       "Upstream Port",
+	ExpressDownstream:     "Downstream Port",
+	ExpressPCIBridge:      "PCI-Express to PCI/PCI-X Bridge",
+	ExpressPCIeBridge:     "PCI/PCI-X to PCI-Express Bridge",
+	ExpressRCEndpoint:     "Root Complex Integrated Endpoint",
+	ExpressRCEC:           "Root Complex Event Collector",
+}
+
+// String implements Stringer.
+func (t ExpressType) String() string {
+	if s, ok := expressTypes[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown type %d", byte(t))
+}
+
+// LinkSpeed is a PCI Express link speed, 1 for 2.5GT/s to 6 for 64GT/s.
+type LinkSpeed byte
+
+var linkSpeeds = []string{1: "2.5GT/s", 2: "5GT/s", 3: "8GT/s", 4: "16GT/s", 5: "32GT/s", 6: "64GT/s"}
+
+// String implements Stringer.
+func (s LinkSpeed) String() string {
+	if int(s) < len(linkSpeeds) && s != 0 {
+		return linkSpeeds[s]
+	}
+	return fmt.Sprintf("unknown (%d)", byte(s))
+}
+
+// Express is the PCI Express capability.
+type Express struct {
+	Version byte
+	Type    ExpressType
+	// FLR is set if the function supports Function Level Reset.
+	FLR bool
+	// MaxPayload and MaxReadReq are in bytes.
+	MaxPayload int
+	MaxReadReq int
+
+	// The link fields are only set for functions with a link.
+	Port     int
+	MaxSpeed LinkSpeed
+	MaxWidth int
+	Speed    LinkSpeed
+	Width    int
+}
+
+// HasLink returns whether the function has a link.
+func (e *Express) HasLink() bool {
+	return e.Type != ExpressRCEndpoint && e.Type != ExpressRCEC
+}
+
+// Express decodes the PCI Express capability.
+func (p *PCI) Express() (*Express, error) {
+	b, err := p.capBytes(CapExpress, false, 0x14)
+	if err != nil {
+		return nil, err
+	}
+	flags, devcap, devctl := u16(b, 2), u32(b, 4), u16(b, 8)
+	e := &Express{
+		Version:    byte(flags & 0xf),
+		Type:       ExpressType(flags >> 4 & 0xf),
+		FLR:        devcap&(1<<28) != 0,
+		MaxPayload: 128 << (devctl >> 5 & 7),
+		MaxReadReq: 128 << (devctl >> 12 & 7),
+	}
+	if e.HasLink() {
+		lnkcap, lnksta := u32(b, 0xc), u16(b, 0x12)
+		e.Port = int(lnkcap >> 24)
+		e.MaxSpeed = LinkSpeed(lnkcap & 0xf)
+		e.MaxWidth = int(lnkcap >> 4 & 0x3f)
+		e.Speed = LinkSpeed(lnksta & 0xf)
+		e.Width = int(lnksta >> 4 & 0x3f)
+	}
+	return e, nil
+}
+
+func (e *Express) info() []string {
+	s := []string{
+		fmt.Sprintf("(v%d) %v", e.Version, e.Type),
+		fmt.Sprintf("DevCap: FLReset%s", plus(e.FLR)),
+		fmt.Sprintf("DevCtl: MaxPayload %d bytes, MaxReadReq %d bytes", e.MaxPayload, e.MaxReadReq),
+	}
+	if !e.HasLink() {
+		return s
+	}
+	sta := fmt.Sprintf("LnkSta: Speed %v, Width x%d", e.Speed, e.Width)
+	if e.Speed < e.MaxSpeed || e.Width < e.MaxWidth {
+		sta += " (downgraded)"
+	}
+	return append(s, fmt.Sprintf("LnkCap: Port #%d, Speed %v, Width x%d", e.Port, e.MaxSpeed, e.MaxWidth), sta)
+}
+
+// AER is the Advanced Error Reporting extended capability.
+type AER struct {
+	UncorrectableStatus   uint32
+	UncorrectableMask     uint32
+	UncorrectableSeverity uint32
+	CorrectableStatus     uint32
+	CorrectableMask       uint32
+	// FirstError is the bit of the first uncorrectable error reported.
+	FirstError int
+}
+
+// AER decodes the AER extended capability.
+func (p *PCI) AER() (*AER, error) {
+	b, err := p.capBytes(ExtCapAER, true, 0x1c)
+	if err != nil {
+		return nil, err
+	}
+	return &AER{
+		UncorrectableStatus:   u32(b, 4),
+		UncorrectableMask:     u32(b, 8),
+		UncorrectableSeverity: u32(b, 0xc),
+		CorrectableStatus:     u32(b, 0x10),
+		CorrectableMask:       u32(b, 0x14),
+		FirstError:            int(u32(b, 0x18) & 0x1f),
+	}, nil
+}
+
+var uncorrectableBits = []struct {
+	bit  uint
+	name string
+}{
+	{4, "DLP"}, {5, "SDES"}, {12, "TLP"}, {13, "FCP"}, {14, "CmpltTO"}, {15, "CmpltAbrt"},
+	{16, "UnxCmplt"}, {17, "RxOF"}, {18, "MalfTLP"}, {19, "ECRC"}, {20, "UnsupReq"}, {21, "ACSViol"},
+}
+
+var correctableBits = []struct {
+	bit  uint
+	name string
+}{
+	{0, "RxErr"}, {6, "BadTLP"}, {7, "BadDLLP"}, {8, "Rollover"}, {12, "Timeout"}, {13, "AdvNonFatalErr"},
+}
+
+func (a *AER) info() []string {
+	return []string{
+		"UESta: " + flags(a.UncorrectableStatus, uncorrectableBits),
+		"UEMsk: " + flags(a.UncorrectableMask, uncorrectableBits),
+		"UESvrt: " + flags(a.UncorrectableSeverity, uncorrectableBits),
+		"CESta: " + flags(a.CorrectableStatus, correctableBits),
+		"CEMsk: " + flags(a.CorrectableMask, correctableBits),
+		fmt.Sprintf("AERCap: First Error Pointer: %02x", a.FirstError),
+	}
+}
+
+// SRIOV is the Single Root I/O Virtualization extended capability.
+type SRIOV struct {
+	// Enable is set if the virtual functions are enabled.
+	Enable     bool
+	InitialVFs int
+	TotalVFs   int
+	NumVFs     int
+	// Offset and Stride give the routing IDs of the virtual functions.
+	Offset int
+	Stride int
+	// VFDevice is the device ID of the virtual functions.
+	VFDevice uint16
+}
+
+// SRIOV decodes the SR-IOV extended capability.
+func (p *PCI) SRIOV() (*SRIOV, error) {
+	b, err := p.capBytes(ExtCapSRIOV, true, 0x1c)
+	if err != nil {
+		return nil, err
+	}
+	return &SRIOV{
+		Enable:     u16(b, 8)&1 != 0,
+		InitialVFs: int(u16(b, 0xc)),
+		TotalVFs:   int(u16(b, 0xe)),
+		NumVFs:     int(u16(b, 0x10)),
+		Offset:     int(u16(b, 0x14)),
+		Stride:     int(u16(b, 0x16)),
+		VFDevice:   u16(b, 0x1a),
+	}, nil
+}
+
+func (s *SRIOV) info() []string {
+	return []string{
+		fmt.Sprintf("IOVCtl: Enable%s", plus(s.Enable)),
+		fmt.Sprintf("Initial VFs: %d, Total VFs: %d, Number of VFs: %d", s.InitialVFs, s.TotalVFs, s.NumVFs),
+		fmt.Sprintf("VF offset: %d, stride: %d, Device ID: %04x", s.Offset, s.Stride, s.VFDevice),
+	}
+}
+
+// SerialNumber returns the Device Serial Number extended capability.
+func (p *PCI) SerialNumber() (uint64, error) {
+	b, err := p.capBytes(ExtCapDSN, true, 12)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[4:]), nil
+}
+
+// CapabilityInfo returns decoded lines of the capabilities that are known.
+func (p *PCI) CapabilityInfo(c Capability) []string {
+	var i interface{ info() []string }
+	var err error
+	switch {
+	case c.ID == CapPM && !c.Extended:
+		i, err = p.PowerManagement()
+	case c.ID == CapMSI && !c.Extended:
+		i, err = p.MSI()
+	case c.ID == CapMSIX && !c.Extended:
+		i, err = p.MSIX()
+	case c.ID == CapExpress && !c.Extended:
+		i, err = p.Express()
+	case c.ID == ExtCapAER && c.Extended:
+		i, err = p.AER()
+	case c.ID == ExtCapSRIOV && c.Extended:
+		i, err = p.SRIOV()
+	case c.ID == ExtCapDSN && c.Extended:
+		sn, err := p.SerialNumber()
+		if err != nil {
+			return []string{err.Error()}
+		}
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], sn)
+		s := make([]string, 8)
+		for i, v := range b {
+			s[i] = fmt.Sprintf("%02x", v)
+		}
+		return []string{strings.Join(s, "-")}
+	default:
+		return nil
+	}
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return i.info()
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pci
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testConfig returns the config space of a PCIe endpoint with PM, MSI,
+// Express and MSI-X capabilities, and AER, DSN and SR-IOV extended
+// capabilities.
+func testConfig() []byte {
+	c := make([]byte, FullConfigSize)
+	le := binary.LittleEndian
+	le.PutUint16(c[VID:], 0x8086)
+	le.PutUint16(c[DID:], 0x1521)
+	le.PutUint16(c[6:], StatusCap)
+	c[CapPointer] = 0x40
+
+	// PM v3, D3hot and D3cold PME, in D0 with NoSoftRst.
+	copy(c[0x40:], []byte{byte(CapPM), 0x50})
+	le.PutUint16(c[0x42:], 0xc003)
+	le.PutUint16(c[0x44:], 0x0008)
+
+	// MSI, 64-bit, 1 of 8 vectors enabled.
+	copy(c[0x50:], []byte{byte(CapMSI), 0x70})
+	le.PutUint16(c[0x52:], 0x0087)
+	le.PutUint32(c[0x54:], 0xfee00000)
+	le.PutUint32(c[0x58:], 0)
+	le.PutUint16(c[0x5c:], 0x4021)
+
+	// Express v2 endpoint with FLR, 8GT/s x4 link trained at 5GT/s x4.
+	copy(c[0x70:], []byte{byte(CapExpress), 0xa0})
+	le.PutUint16(c[0x72:], 0x0002)
+	le.PutUint32(c[0x74:], 1<<28)
+	le.PutUint16(c[0x78:], 0x2020)
+	le.PutUint32(c[0x7c:], 0x00000043)
+	le.PutUint16(c[0x82:], 0x0042)
+
+	// MSI-X, enabled, 10 vectors.
+	copy(c[0xa0:], []byte{byte(CapMSIX), 0x00})
+	le.PutUint16(c[0xa2:], 0x8009)
+	le.PutUint32(c[0xa4:], 0x00002003)
+	le.PutUint32(c[0xa8:], 0x00003003)
+
+	// AER v2, with a correctable receiver error.
+	le.PutUint32(c[0x100:], 0x140<<20|2<<16|uint32(ExtCapAER))
+	le.PutUint32(c[0x10c:], 0x00062030)
+	le.PutUint32(c[0x110:], 1)
+	le.PutUint32(c[0x114:], 0x2000)
+
+	// Serial number.
+	le.PutUint32(c[0x140:], 0x150<<20|1<<16|uint32(ExtCapDSN))
+	le.PutUint64(c[0x144:], 0xa0369fffff123456)
+
+	// SR-IOV with 2 of 8 VFs.
+	le.PutUint32(c[0x150:], 0<<20|1<<16|uint32(ExtCapSRIOV))
+	le.PutUint16(c[0x158:], 0x0019)
+	le.PutUint16(c[0x15c:], 8)
+	le.PutUint16(c[0x15e:], 8)
+	le.PutUint16(c[0x160:], 2)
+	le.PutUint16(c[0x164:], 0x80)
+	le.PutUint16(c[0x166:], 4)
+	le.PutUint16(c[0x16a:], 0x1520)
+	return c
+}
+
+func TestCapabilities(t *testing.T) {
+	p := &PCI{Addr: "0000:01:00.0", Config: testConfig()}
+	caps, err := p.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Capability{
+		{ID: CapPM, Offset: 0x40},
+		{ID: CapMSI, Offset: 0x50},
+		{ID: CapExpress, Offset: 0x70},
+		{ID: CapMSIX, Offset: 0xa0},
+		{ID: ExtCapAER, Extended: true, Version: 2, Offset: 0x100},
+		{ID: ExtCapDSN, Extended: true, Version: 1, Offset: 0x140},
+		{ID: ExtCapSRIOV, Extended: true, Version: 1, Offset: 0x150},
+	}
+	if !reflect.DeepEqual(caps, want) {
+		t.Errorf("Capabilities() = %v, want %v", caps, want)
+	}
+
+	// Without the extended config space, only the standard capabilities
+	// are found.
+	p.Config = p.Config[:ConfigSize]
+	if caps, err = p.Capabilities(); err != nil || len(caps) != 4 {
+		t.Errorf("Capabilities() of 256 bytes = %v, %v, want 4 capabilities", caps, err)
+	}
+	if _, err := p.SRIOV(); !errors.Is(err, ErrNoCapability) {
+		t.Errorf("SRIOV() = %v, want %v", err, ErrNoCapability)
+	}
+
+	// A looping list is an error.
+	p.Config[0xa1] = 0x40
+	if _, err := p.Capabilities(); err == nil {
+		t.Errorf("Capabilities() of a looping list succeeded")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	p := &PCI{Addr: "0000:01:00.0", Config: testConfig()}
+	e, err := p.Express()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Express{Version: 2, Type: ExpressEndpoint, FLR: true, MaxPayload: 256, MaxReadReq: 512, MaxSpeed: 3, MaxWidth: 4, Speed: 2, Width: 4}); !reflect.DeepEqual(e, want) {
+		t.Errorf("Express() = %+v, want %+v", e, want)
+	}
+	m, err := p.MSI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&MSI{Enable: true, Count: 1, Max: 8, Is64: true, Address: 0xfee00000, Data: 0x4021}); !reflect.DeepEqual(m, want) {
+		t.Errorf("MSI() = %+v, want %+v", m, want)
+	}
+	s, err := p.SRIOV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&SRIOV{Enable: true, InitialVFs: 8, TotalVFs: 8, NumVFs: 2, Offset: 0x80, Stride: 4, VFDevice: 0x1520}); !reflect.DeepEqual(s, want) {
+		t.Errorf("SRIOV() = %+v, want %+v", s, want)
+	}
+}
+
+func TestPrintCapabilities(t *testing.T) {
+	p := &PCI{Addr: "0000:01:00.0", Config: testConfig()}
+	var b bytes.Buffer
+	if err := p.printCapabilities(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `	Capabilities: [40] Power Management
+		Flags: version 3 D1- D2- PME(D0-,D1-,D2-,D3hot+,D3cold+)
+		Status: D0 NoSoftRst+ PME-Enable- PME-
+	Capabilities: [50] MSI
+		Enable+ Count=1/8 Maskable- 64bit+
+		Address: 00000000fee00000  Data: 4021
+	Capabilities: [70] Express
+		(v2) Endpoint
+		DevCap: FLReset+
+		DevCtl: MaxPayload 256 bytes, MaxReadReq 512 bytes
+		LnkCap: Port #0, Speed 8GT/s, Width x4
+		LnkSta: Speed 5GT/s, Width x4 (downgraded)
+	Capabilities: [a0] MSI-X
+		Enable+ Count=10 Masked-
+		Vector table: BAR=3 offset=00002000
+		PBA: BAR=3 offset=00003000
+	Capabilities: [100 v2] Advanced Error Reporting
+		UESta: DLP- SDES- TLP- FCP- CmpltTO- CmpltAbrt- UnxCmplt- RxOF- MalfTLP- ECRC- UnsupReq- ACSViol-
+		UEMsk: DLP- SDES- TLP- FCP- CmpltTO- CmpltAbrt- UnxCmplt- RxOF- MalfTLP- ECRC- UnsupReq- ACSViol-
+		UESvrt: DLP+ SDES+ TLP- FCP+ CmpltTO- CmpltAbrt- UnxCmplt- RxOF+ MalfTLP+ ECRC- UnsupReq- ACSViol-
+		CESta: RxErr+ BadTLP- BadDLLP- Rollover- Timeout- AdvNonFatalErr-
+		CEMsk: RxErr- BadTLP- BadDLLP- Rollover- Timeout- AdvNonFatalErr+
+		AERCap: First Error Pointer: 00
+	Capabilities: [140 v1] Device Serial Number
+		a0-36-9f-ff-ff-12-34-56
+	Capabilities: [150 v1] Single Root I/O Virtualization (SR-IOV)
+		IOVCtl: Enable+
+		Initial VFs: 8, Total VFs: 8, Number of VFs: 2
+		VF offset: 128, stride: 4, Device ID: 1520
+`
+	if b.String() != want {
+		t.Errorf("printCapabilities() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -79,6 +79,11 @@ func (d Devices) Print(o io.Writer, verbose, confSize int) error {
 			}
 			extraNL = true
 		}
+		if verbose >= 2 {
+			if err := pci.printCapabilities(o); err != nil {
+				return err
+			}
+		}
 
 		if confSize > 0 {
 			r := io.LimitReader(bytes.NewBuffer(pci.Config), int64(confSize))
@@ -96,6 +101,24 @@ func (d Devices) Print(o io.Writer, verbose, confSize int) error {
 	return nil
 }
 
+func (p *PCI) printCapabilities(o io.Writer) error {
+	caps, err := p.Capabilities()
+	for _, c := range caps {
+		if _, err := fmt.Fprintf(o, "\tCapabilities: %v\n", c); err != nil {
+			return err
+		}
+		for _, l := range p.CapabilityInfo(c) {
+			if _, err := fmt.Fprintf(o, "\t\t%s\n", l); err != nil {
+				return err
+			}
+		}
+	}
+	if err != nil {
+		_, err = fmt.Fprintf(o, "\tCapabilities: %v\n", err)
+	}
+	return err
+}
+
 // SetVendorDeviceName sets all numeric IDs of all the devices
 // using the pci device SetVendorDeviceName.
 func (d Devices) SetVendorDeviceName() {
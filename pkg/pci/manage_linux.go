@@ -0,0 +1,175 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pci
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sysBusPCI is where the kernel's PCI bus attributes live. It is a variable
+// for tests.
+var sysBusPCI = "/sys/bus/pci"
+
+// sleep is a variable for tests.
+var sleep = time.Sleep
+
+func writeString(dir, file, s string) error {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(s), 0o200); err != nil {
+		return fmt.Errorf("writing %q to %s: %w", s, filepath.Join(dir, file), err)
+	}
+	return nil
+}
+
+// Rescan rescans all PCI buses for devices, e.g. after Remove.
+func Rescan() error {
+	return writeString(sysBusPCI, "rescan", "1")
+}
+
+// Rescan rescans the buses below a bridge.
+func (p *PCI) Rescan() error {
+	return writeString(p.FullPath, "rescan", "1")
+}
+
+// Remove removes the device, and all devices below it. Rescan finds it
+// again.
+func (p *PCI) Remove() error {
+	return writeString(p.FullPath, "remove", "1")
+}
+
+// ResetMethods returns the reset methods the kernel may use for the device,
+// in the order it tries them, e.g. flr, pm and bus.
+func (p *PCI) ResetMethods() ([]string, error) {
+	s, err := readString(p.FullPath, "reset_method")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(s), nil
+}
+
+// Reset resets the device through the kernel, which saves and restores its
+// config space. If method is not empty, only that method is tried: flr for
+// a Function Level Reset, bus for a secondary bus reset of the bridge above
+// the device, pm for a D3hot to D0 transition.
+func (p *PCI) Reset(method string) error {
+	if method != "" {
+		old, err := p.ResetMethods()
+		if err != nil {
+			return err
+		}
+		if err := writeString(p.FullPath, "reset_method", method); err != nil {
+			return err
+		}
+		defer writeString(p.FullPath, "reset_method", strings.Join(old, " "))
+	}
+	return writeString(p.FullPath, "reset", "1")
+}
+
+// SecondaryBusReset resets the buses below a bridge by toggling Secondary
+// Bus Reset in its Bridge Control register. Unlike Reset, the config space
+// of the devices below is lost, so remove them first and rescan after.
+func (p *PCI) SecondaryBusReset() error {
+	if !p.Bridge {
+		return fmt.Errorf("%s is not a bridge", p.Addr)
+	}
+	ctl, err := p.ReadConfigRegister(BridgeControl, 16)
+	if err != nil {
+		return err
+	}
+	if err := p.WriteConfigRegister(BridgeControl, 16, ctl|BridgeBusReset); err != nil {
+		return err
+	}
+	// Trst is at least 1ms.
+	sleep(2 * time.Millisecond)
+	if err := p.WriteConfigRegister(BridgeControl, 16, ctl&^BridgeBusReset); err != nil {
+		return err
+	}
+	// Devices have 1s to become ready after the reset.
+	sleep(time.Second)
+	return nil
+}
+
+// Driver returns the name of the driver bound to the device, or "" if none
+// is.
+func (p *PCI) Driver() (string, error) {
+	l, err := os.Readlink(filepath.Join(p.FullPath, "driver"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(l), nil
+}
+
+// Unbind unbinds the device from its driver.
+func (p *PCI) Unbind() error {
+	d, err := p.Driver()
+	if err != nil {
+		return err
+	}
+	if d == "" {
+		return fmt.Errorf("%s has no driver", p.Addr)
+	}
+	return writeString(filepath.Join(p.FullPath, "driver"), "unbind", p.Addr)
+}
+
+// Bind binds the device to a driver, which has to support it. See
+// SetDriverOverride for drivers that do not.
+func (p *PCI) Bind(driver string) error {
+	return writeString(filepath.Join(sysBusPCI, "drivers", driver), "bind", p.Addr)
+}
+
+// SetDriverOverride makes the device only bind to driver, or clears the
+// override if driver is "". It takes effect the next time a driver is
+// probed, e.g. with Probe.
+func (p *PCI) SetDriverOverride(driver string) error {
+	return writeString(p.FullPath, "driver_override", driver+"\n")
+}
+
+// Probe probes the drivers for a device without one.
+func (p *PCI) Probe() error {
+	return writeString(sysBusPCI, "drivers_probe", p.Addr)
+}
+
+// TotalVFs returns the number of SR-IOV virtual functions the device
+// supports.
+func (p *PCI) TotalVFs() (int, error) {
+	n, err := readUint(p.FullPath, "sriov_totalvfs", 10, 16)
+	return int(n), err
+}
+
+// NumVFs returns the number of SR-IOV virtual functions enabled.
+func (p *PCI) NumVFs() (int, error) {
+	n, err := readUint(p.FullPath, "sriov_numvfs", 10, 16)
+	return int(n), err
+}
+
+// SetNumVFs enables n SR-IOV virtual functions, or disables them if n is 0.
+func (p *PCI) SetNumVFs(n int) error {
+	total, err := p.TotalVFs()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > total {
+		return fmt.Errorf("%s: %d VFs, want 0 to %d", p.Addr, n, total)
+	}
+	cur, err := p.NumVFs()
+	if err != nil {
+		return err
+	}
+	// The kernel only changes the number of VFs from or to 0.
+	if cur != 0 && n != 0 && cur != n {
+		if err := writeString(p.FullPath, "sriov_numvfs", "0"); err != nil {
+			return err
+		}
+	}
+	return writeString(p.FullPath, "sriov_numvfs", strconv.Itoa(n))
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pci
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSysfs returns a device in a directory laid out like /sys/bus/pci.
+func fakeSysfs(t *testing.T) *PCI {
+	t.Helper()
+	d := t.TempDir()
+	old := sysBusPCI
+	sysBusPCI = d
+	t.Cleanup(func() { sysBusPCI = old })
+	dev := filepath.Join(d, "devices", "0000:01:00.0")
+	drv := filepath.Join(d, "drivers", "igb")
+	for _, dir := range []string{dev, drv} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(drv, filepath.Join(dev, "driver")); err != nil {
+		t.Fatal(err)
+	}
+	for f, v := range map[string]string{
+		"reset_method":   "flr bus\n",
+		"sriov_totalvfs": "8\n",
+		"sriov_numvfs":   "2\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dev, f), []byte(v), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &PCI{Addr: "0000:01:00.0", FullPath: dev}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestDriver(t *testing.T) {
+	p := fakeSysfs(t)
+	d, err := p.Driver()
+	if err != nil || d != "igb" {
+		t.Fatalf("Driver() = %q, %v, want igb", d, err)
+	}
+	if err := p.Unbind(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(sysBusPCI, "drivers", "igb", "unbind")); got != p.Addr {
+		t.Errorf("unbind = %q, want %q", got, p.Addr)
+	}
+	if err := p.SetDriverOverride("vfio-pci"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(p.FullPath, "driver_override")); got != "vfio-pci\n" {
+		t.Errorf("driver_override = %q, want vfio-pci", got)
+	}
+	if err := p.Probe(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(sysBusPCI, "drivers_probe")); got != p.Addr {
+		t.Errorf("drivers_probe = %q, want %q", got, p.Addr)
+	}
+	if err := p.Bind("nvme"); err == nil {
+		t.Errorf("Bind(nvme) without the driver succeeded")
+	}
+
+	if err := os.Remove(filepath.Join(p.FullPath, "driver")); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := p.Driver(); err != nil || d != "" {
+		t.Errorf("Driver() = %q, %v, want none", d, err)
+	}
+	if err := p.Unbind(); err == nil {
+		t.Errorf("Unbind() without a driver succeeded")
+	}
+}
+
+func TestReset(t *testing.T) {
+	p := fakeSysfs(t)
+	if err := p.Reset("bus"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(p.FullPath, "reset")); got != "1" {
+		t.Errorf("reset = %q, want 1", got)
+	}
+	// The kernel's methods are restored.
+	if got := readFile(t, filepath.Join(p.FullPath, "reset_method")); got != "flr bus" {
+		t.Errorf("reset_method = %q, want flr bus", got)
+	}
+}
+
+func TestSecondaryBusReset(t *testing.T) {
+	p := fakeSysfs(t)
+	if err := p.SecondaryBusReset(); err == nil {
+		t.Errorf("SecondaryBusReset() of an endpoint succeeded")
+	}
+	p.Bridge = true
+	c := make([]byte, ConfigSize)
+	binary.LittleEndian.PutUint16(c[BridgeControl:], BridgeSERR)
+	if err := os.WriteFile(filepath.Join(p.FullPath, "config"), c, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var ctls []uint64
+	old := sleep
+	sleep = func(time.Duration) {
+		v, err := p.ReadConfigRegister(BridgeControl, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctls = append(ctls, v)
+	}
+	defer func() { sleep = old }()
+	if err := p.SecondaryBusReset(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctls) != 2 || ctls[0] != BridgeSERR|BridgeBusReset || ctls[1] != BridgeSERR {
+		t.Errorf("Bridge Control during the reset = %#x, want [%#x %#x]", ctls, BridgeSERR|BridgeBusReset, BridgeSERR)
+	}
+}
+
+func TestSetNumVFs(t *testing.T) {
+	p := fakeSysfs(t)
+	if err := p.SetNumVFs(9); err == nil {
+		t.Errorf("SetNumVFs(9) of 8 succeeded")
+	}
+	if err := p.SetNumVFs(4); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := p.NumVFs(); err != nil || n != 4 {
+		t.Errorf("NumVFs() = %d, %v, want 4", n, err)
+	}
+}
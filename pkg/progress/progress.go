@@ -78,6 +78,13 @@ func (p *progressData) End() {
 	}
 }
 
+// Print prints the current status on a line of its own, as dd does when
+// it gets SIGUSR1.
+func (p *progressData) Print() {
+	p.print(os.Stderr)
+	fmt.Fprint(os.Stderr, "\n")
+}
+
 // With "status=progress", this is called from 3 places:
 // - Once at the beginning to appear responsive
 // - Every 1s afterwards
@@ -8,5 +8,6 @@
 // Package uefivars manipulates UEFI variables, and can encode and decode
 // the mixed-endianness GUIDs used by UEFI (and MS).
 //
-// Subpackage boot deals specifically with UEFI boot variables.
+// Subpackage boot deals specifically with UEFI boot variables, and subpackage
+// secureboot with the Secure Boot state and signature databases.
 package uefivars
@@ -9,7 +9,9 @@ package uefivars
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
 // MixedGUID is a mixed-endianness guid, as used by MS and UEFI.
@@ -50,3 +52,16 @@ func (u UUID) ToMixedGUID() (m MixedGUID) {
 func (u UUID) String() string {
 	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", u[:4], u[4:6], u[6:8], u[8:10], u[10:])
 }
+
+// ParseGUID parses a GUID in the usual xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx
+// form into a MixedGUID.
+func ParseGUID(s string) (m MixedGUID, err error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return m, fmt.Errorf("%q is not a GUID", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(strings.ReplaceAll(s, "-", ""))); err != nil {
+		return m, fmt.Errorf("%q is not a GUID: %w", s, err)
+	}
+	return u.ToMixedGUID(), nil
+}
@@ -45,6 +45,18 @@ func TestEncodeDecode(t *testing.T) {
 			if !bytes.Equal(guid[:], td.in[:]) {
 				t.Errorf("mismatch\nwant %x\n got %x", td.in, guid)
 			}
+			guid, err := ParseGUID(td.want)
+			if err != nil || guid != td.in {
+				t.Errorf("ParseGUID(%s) = %x, %v, want %x", td.want, guid, err, td.in)
+			}
 		})
 	}
 }
+
+func TestParseGUIDErrors(t *testing.T) {
+	for _, s := range []string{"", "81635ccd1b4f4d3fb7b7f78a5b029f35", "81635ccd-1b4f-4d3f-b7b7-f78a5b029f3z", "81635ccd-1b4f-4d3f-b7b7-f78a5b029f35a"} {
+		if g, err := ParseGUID(s); err == nil {
+			t.Errorf("ParseGUID(%q) = %s, want an error", s, g)
+		}
+	}
+}
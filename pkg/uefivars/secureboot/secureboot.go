@@ -0,0 +1,134 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package secureboot reads the UEFI Secure Boot state and signature
+// databases, and verifies PE images against them like firmware does.
+//
+// The databases are PK, KEK, db and dbx, and the MokListRT and MokListXRT
+// copies of the shim MOK lists. See the UEFI spec, section 32.4 "Firmware/OS
+// Key Exchange".
+package secureboot
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/u-root/u-root/pkg/authenticode"
+	"github.com/u-root/u-root/pkg/uefivars"
+)
+
+const (
+	// GlobalVariable is EFI_GLOBAL_VARIABLE, the GUID of SecureBoot,
+	// SetupMode, PK and KEK.
+	GlobalVariable = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+	// ImageSecurityDatabase is EFI_IMAGE_SECURITY_DATABASE_GUID, the GUID
+	// of db and dbx.
+	ImageSecurityDatabase = "d719b2cb-3d3a-4596-a3bc-dad00e67656f"
+	// Shim is the GUID of the shim MOK variables.
+	Shim = "605dab50-e046-4300-abb6-3dd810dd8b23"
+)
+
+// Databases are the names of the signature databases, in the order of
+// trust.
+var Databases = []string{"PK", "KEK", "db", "dbx", "MokListRT", "MokListXRT"}
+
+// ErrForbidden is returned by Verify for an image that dbx forbids.
+var ErrForbidden = errors.New("image is forbidden by dbx")
+
+func guidOf(name string) string {
+	switch name {
+	case "db", "dbx", "dbt", "dbr":
+		return ImageSecurityDatabase
+	case "MokListRT", "MokListXRT":
+		return Shim
+	}
+	return GlobalVariable
+}
+
+// ReadDatabase reads a signature database, such as db, from the UEFI
+// variables.
+func ReadDatabase(name string) (Database, error) {
+	v, err := uefivars.ReadVar(guidOf(name), name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ParseDatabase(v.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+// State is the Secure Boot state of the firmware.
+type State struct {
+	// SecureBoot is whether images are verified.
+	SecureBoot bool
+	// SetupMode is whether no PK is enrolled, so that the keys can be
+	// changed without authentication.
+	SetupMode bool
+}
+
+func readBool(name string) (bool, error) {
+	v, err := uefivars.ReadVar(GlobalVariable, name)
+	if err != nil {
+		return false, err
+	}
+	if len(v.Data) != 1 {
+		return false, fmt.Errorf("%s has %d bytes, want 1", name, len(v.Data))
+	}
+	return v.Data[0] == 1, nil
+}
+
+// ReadState reads the SecureBoot and SetupMode variables.
+func ReadState() (*State, error) {
+	var s State
+	var err error
+	if s.SecureBoot, err = readBool("SecureBoot"); err != nil {
+		return nil, err
+	}
+	if s.SetupMode, err = readBool("SetupMode"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Verify verifies a PE image like firmware with Secure Boot enabled. The
+// image must be neither signed by a certificate in dbx nor have its
+// SHA-256 digest in it, and either have its digest in db or a signature
+// that chains to a certificate in db.
+func Verify(img []byte, db, dbx Database) error {
+	d, err := authenticode.Hash(img, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	if dbx.HasSHA256(d) {
+		return ErrForbidden
+	}
+	sigs, err := authenticode.Signatures(img)
+	if err != nil {
+		return err
+	}
+
+	trusted := db.HasSHA256(d)
+	err = authenticode.ErrNotSigned
+	for _, s := range sigs {
+		if e := s.Verify(img); e != nil {
+			err = e
+			continue
+		}
+		if s.ChainsTo(dbx.Certificates()) {
+			return ErrForbidden
+		}
+		if s.ChainsTo(db.Certificates()) {
+			trusted = true
+		} else {
+			err = authenticode.ErrUntrusted
+		}
+	}
+	if trusted {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secureboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/internal/authenticodetest"
+	"github.com/u-root/u-root/pkg/authenticode"
+	"github.com/u-root/u-root/pkg/uefivars"
+)
+
+var owner = mustGUID("77fa9abd-0359-4d32-bd60-28f4e78f784b")
+
+func testCert(t *testing.T, name string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, key
+}
+
+func x509Sig(c *x509.Certificate) Signature {
+	return Signature{Type: CertX509, Owner: owner, Data: c.Raw, Certificate: c}
+}
+
+func sha256Sig(d []byte) Signature {
+	return Signature{Type: CertSHA256, Owner: owner, Data: d}
+}
+
+// writeVar writes a variable in the format of the sysfs efivars.
+func writeVar(t *testing.T, dir, guid, name string, data []byte) {
+	t.Helper()
+	d := filepath.Join(dir, name+"-"+guid)
+	if err := os.MkdirAll(d, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(d, "data"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	ca, _ := testCert(t, "CA", nil, nil)
+	other, _ := testCert(t, "Another CA with a longer name", nil, nil)
+	d := Database{
+		x509Sig(ca),
+		x509Sig(other),
+		sha256Sig(bytes.Repeat([]byte{1}, 32)),
+		sha256Sig(bytes.Repeat([]byte{2}, 32)),
+		{Type: mustGUID("826ca512-cf10-4ac9-b187-be01496631bd"), Owner: owner, Data: bytes.Repeat([]byte{3}, 20)},
+	}
+	b := d.Marshal()
+	// The certificates differ in size, so there are 4 lists.
+	if want := 4*28 + 2*16 + len(ca.Raw) + len(other.Raw) + 2*(16+32) + 16 + 20; len(b) != want {
+		t.Errorf("Marshal() is %d bytes, want %d", len(b), want)
+	}
+
+	got, err := ParseDatabase(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(d) {
+		t.Fatalf("ParseDatabase() returned %d signatures, want %d", len(got), len(d))
+	}
+	for i := range d {
+		if got[i].Type != d[i].Type || got[i].Owner != d[i].Owner || !bytes.Equal(got[i].Data, d[i].Data) {
+			t.Errorf("signature %d is %v, want %v", i, got[i], d[i])
+		}
+	}
+	if c := got.Certificates(); len(c) != 2 || !c[0].Equal(ca) || !c[1].Equal(other) {
+		t.Errorf("Certificates() = %v, want the 2 CAs", c)
+	}
+	if !got.HasSHA256(bytes.Repeat([]byte{2}, 32)) || got.HasSHA256(bytes.Repeat([]byte{3}, 32)) {
+		t.Errorf("HasSHA256() does not find exactly the SHA-256 signatures")
+	}
+
+	for _, bad := range [][]byte{b[:20], b[:len(b)-1], append(b[:len(b):len(b)], 0)} {
+		if _, err := ParseDatabase(bad); err == nil {
+			t.Errorf("ParseDatabase() of %d bytes succeeded", len(bad))
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	old := uefivars.EfiVarDir
+	uefivars.EfiVarDir = dir
+	defer func() { uefivars.EfiVarDir = old }()
+
+	if _, err := ReadState(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadState() without variables = %v, want %v", err, os.ErrNotExist)
+	}
+	writeVar(t, dir, GlobalVariable, "SecureBoot", []byte{1})
+	writeVar(t, dir, GlobalVariable, "SetupMode", []byte{0})
+	s, err := ReadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (State{SecureBoot: true}); *s != want {
+		t.Errorf("ReadState() = %+v, want %+v", s, want)
+	}
+
+	ca, _ := testCert(t, "CA", nil, nil)
+	writeVar(t, dir, ImageSecurityDatabase, "db", Database{x509Sig(ca)}.Marshal())
+	writeVar(t, dir, Shim, "MokListRT", Database{sha256Sig(make([]byte, 32))}.Marshal())
+	db, err := ReadDatabase("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db) != 1 || !db[0].Certificate.Equal(ca) {
+		t.Errorf("ReadDatabase(db) = %v, want the CA", db)
+	}
+	mok, err := ReadDatabase("MokListRT")
+	if err != nil || len(mok) != 1 || !mok.HasSHA256(make([]byte, 32)) {
+		t.Errorf("ReadDatabase(MokListRT) = %v, %v, want a SHA-256 digest", mok, err)
+	}
+	if _, err := ReadDatabase("dbx"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadDatabase(dbx) = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	img, err := os.ReadFile("../../boot/bls/testdata/uki/EFI/Linux/fedora-5.17.5.efi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := authenticode.Hash(img, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, caKey := testCert(t, "CA", nil, nil)
+	signer, key := testCert(t, "Signer", ca, caKey)
+	other, _ := testCert(t, "Other CA", nil, nil)
+	signed, err := authenticodetest.Sign(img, key, []*x509.Certificate{signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedDigest, err := authenticode.Hash(signed, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		img     []byte
+		db, dbx Database
+		err     error
+	}{
+		{"unsigned", img, Database{x509Sig(ca)}, nil, authenticode.ErrNotSigned},
+		{"unsigned in db", img, Database{x509Sig(ca), sha256Sig(digest)}, nil, nil},
+		{"unsigned in db and dbx", img, Database{sha256Sig(digest)}, Database{sha256Sig(digest)}, ErrForbidden},
+		{"signed", signed, Database{x509Sig(ca)}, Database{x509Sig(other)}, nil},
+		{"signer in db", signed, Database{x509Sig(signer)}, nil, nil},
+		{"untrusted", signed, Database{x509Sig(other)}, nil, authenticode.ErrUntrusted},
+		{"untrusted in db", signed, Database{x509Sig(other), sha256Sig(signedDigest)}, nil, nil},
+		{"signer in dbx", signed, Database{x509Sig(ca)}, Database{x509Sig(signer)}, ErrForbidden},
+		{"CA in dbx", signed, Database{sha256Sig(signedDigest)}, Database{x509Sig(ca)}, ErrForbidden},
+		{"digest in dbx", signed, Database{x509Sig(ca)}, Database{sha256Sig(signedDigest)}, ErrForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.img, tt.db, tt.dbx); !errors.Is(err, tt.err) {
+				t.Errorf("Verify() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
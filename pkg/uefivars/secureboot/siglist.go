@@ -0,0 +1,157 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secureboot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/u-root/u-root/pkg/uefivars"
+)
+
+func mustGUID(s string) uefivars.MixedGUID {
+	g, err := uefivars.ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Signature types.
+var (
+	// CertX509 is EFI_CERT_X509_GUID, a DER X.509 certificate.
+	CertX509 = mustGUID("a5c059a1-94e4-4aa7-87b5-ab155c2bf072")
+	// CertSHA256 is EFI_CERT_SHA256_GUID, the SHA-256 Authenticode
+	// digest of an image.
+	CertSHA256 = mustGUID("c1c41626-504c-4092-aca9-41f936934328")
+)
+
+// sigListHeader is EFI_SIGNATURE_LIST, without the variable sized header and
+// signatures that follow it.
+type sigListHeader struct {
+	SignatureType       uefivars.MixedGUID
+	SignatureListSize   uint32
+	SignatureHeaderSize uint32
+	SignatureSize       uint32
+}
+
+// Signature is an EFI_SIGNATURE_DATA of a signature list.
+type Signature struct {
+	// Type is the signature type of the list, such as CertX509.
+	Type uefivars.MixedGUID
+	// Owner identifies the agent that added the signature.
+	Owner uefivars.MixedGUID
+	// Data is the signature, such as a certificate or a digest.
+	Data []byte
+	// Certificate is the parsed Data of a CertX509 signature.
+	Certificate *x509.Certificate
+}
+
+// String implements fmt.Stringer.
+func (s Signature) String() string {
+	switch {
+	case s.Certificate != nil:
+		return fmt.Sprintf("x509 owner=%s subject=%q issuer=%q", s.Owner, s.Certificate.Subject, s.Certificate.Issuer)
+	case s.Type == CertSHA256:
+		return fmt.Sprintf("sha256 owner=%s %x", s.Owner, s.Data)
+	}
+	return fmt.Sprintf("type=%s owner=%s %d bytes", s.Type, s.Owner, len(s.Data))
+}
+
+// Database is a signature database, such as the value of db or dbx, made of
+// EFI_SIGNATURE_LISTs.
+type Database []Signature
+
+// ParseDatabase parses the EFI_SIGNATURE_LISTs of a signature database.
+// Signatures of types other than CertX509 and CertSHA256 are kept, but
+// not parsed.
+func ParseDatabase(b []byte) (Database, error) {
+	var d Database
+	for off := 0; off < len(b); {
+		var h sigListHeader
+		if err := binary.Read(bytes.NewReader(b[off:]), binary.LittleEndian, &h); err != nil {
+			return nil, fmt.Errorf("signature list at %#x: %w", off, err)
+		}
+		hdr := binary.Size(h)
+		size := int(h.SignatureListSize)
+		if size < hdr || size > len(b)-off {
+			return nil, fmt.Errorf("signature list at %#x has size %d, %d bytes are left", off, size, len(b)-off)
+		}
+		sigs := size - hdr - int(h.SignatureHeaderSize)
+		ssize := int(h.SignatureSize)
+		if sigs < 0 || ssize < 16 || sigs%ssize != 0 {
+			return nil, fmt.Errorf("signature list at %#x: %d bytes of signatures of size %d", off, sigs, ssize)
+		}
+		if h.SignatureType == CertSHA256 && ssize != 16+sha256.Size {
+			return nil, fmt.Errorf("signature list at %#x: SHA-256 signatures of size %d", off, ssize)
+		}
+
+		for p := off + hdr + int(h.SignatureHeaderSize); p < off+size; p += ssize {
+			s := Signature{
+				Type: h.SignatureType,
+				Data: b[p+16 : p+ssize],
+			}
+			copy(s.Owner[:], b[p:])
+			if s.Type == CertX509 {
+				c, err := x509.ParseCertificate(s.Data)
+				if err != nil {
+					return nil, fmt.Errorf("signature list at %#x: %w", off, err)
+				}
+				s.Certificate = c
+			}
+			d = append(d, s)
+		}
+		off += size
+	}
+	return d, nil
+}
+
+// Marshal encodes the database as EFI_SIGNATURE_LISTs. Consecutive
+// signatures of the same type and size share a list.
+func (d Database) Marshal() []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(d); {
+		j := i + 1
+		for j < len(d) && d[j].Type == d[i].Type && len(d[j].Data) == len(d[i].Data) {
+			j++
+		}
+		h := sigListHeader{
+			SignatureType: d[i].Type,
+			SignatureSize: uint32(16 + len(d[i].Data)),
+		}
+		h.SignatureListSize = uint32(binary.Size(h)) + uint32(j-i)*h.SignatureSize
+		binary.Write(&b, binary.LittleEndian, h)
+		for _, s := range d[i:j] {
+			b.Write(s.Owner[:])
+			b.Write(s.Data)
+		}
+		i = j
+	}
+	return b.Bytes()
+}
+
+// Certificates returns the X.509 certificates of the database.
+func (d Database) Certificates() []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, s := range d {
+		if s.Certificate != nil {
+			certs = append(certs, s.Certificate)
+		}
+	}
+	return certs
+}
+
+// HasSHA256 returns whether the database has a SHA-256 digest.
+func (d Database) HasSHA256(digest []byte) bool {
+	for _, s := range d {
+		if s.Type == CertSHA256 && bytes.Equal(s.Data, digest) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vpd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/u-root/u-root/pkg/flash/fmap"
+)
+
+// FMAP areas of the VPD partitions.
+const (
+	ROArea = "RO_VPD"
+	RWArea = "RW_VPD"
+)
+
+// queueWriter is implemented by flash devices with lazy writes, such as the
+// mtd Flasher.
+type queueWriter interface {
+	QueueWrite([]byte, int64) (int, error)
+	SyncWrite() error
+}
+
+// eraser is implemented by flash chips that must be erased before they are
+// written, such as flash.Flash.
+type eraser interface {
+	EraseAt(n int64, off int64) (int64, error)
+}
+
+// Image gives access to the VPD partitions of a flash image or chip, found
+// through its FMAP, without flashrom or the kernel.
+type Image struct {
+	rw   io.ReaderAt
+	fmap *fmap.FMap
+}
+
+// NewImage returns the Image of the first size bytes of rw. rw may be an
+// image file, an mtd Flasher or a flash.Flash. To write, it must also be an
+// io.WriterAt or have QueueWrite and SyncWrite.
+func NewImage(rw io.ReaderAt, size int64) (*Image, error) {
+	f, _, err := fmap.Read(rw, size)
+	if err != nil {
+		return nil, fmt.Errorf("vpd: %w", err)
+	}
+	return &Image{rw: rw, fmap: f}, nil
+}
+
+func area(readOnly bool) string {
+	if readOnly {
+		return ROArea
+	}
+	return RWArea
+}
+
+// Partition reads and decodes the RO_VPD or RW_VPD partition.
+func (i *Image) Partition(readOnly bool) (*Partition, error) {
+	b, err := i.fmap.ReadArea(i.rw, area(readOnly))
+	if err != nil {
+		return nil, fmt.Errorf("vpd: %w", err)
+	}
+	p, err := Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("vpd: %s: %w", area(readOnly), err)
+	}
+	return p, nil
+}
+
+// WritePartition encodes p and writes it to the RO_VPD or RW_VPD partition.
+func (i *Image) WritePartition(p *Partition, readOnly bool) error {
+	name := area(readOnly)
+	a, err := i.fmap.Area(name)
+	if err != nil {
+		return fmt.Errorf("vpd: %w", err)
+	}
+	b, err := p.Encode(int(a.Size))
+	if err != nil {
+		return err
+	}
+	switch w := i.rw.(type) {
+	case queueWriter:
+		if _, err := w.QueueWrite(b, int64(a.Offset)); err != nil {
+			return fmt.Errorf("vpd: writing %s: %w", name, err)
+		}
+		if err := w.SyncWrite(); err != nil {
+			return fmt.Errorf("vpd: writing %s: %w", name, err)
+		}
+		return nil
+	case io.WriterAt:
+		if e, ok := w.(eraser); ok {
+			if _, err := e.EraseAt(int64(a.Size), int64(a.Offset)); err != nil {
+				return fmt.Errorf("vpd: erasing %s: %w", name, err)
+			}
+		}
+		if err := i.fmap.WriteArea(w, name, b); err != nil {
+			return fmt.Errorf("vpd: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("vpd: %T cannot be written", i.rw)
+}
+
+// Get returns the value of key. A missing key is an os.ErrNotExist error.
+func (i *Image) Get(key string, readOnly bool) ([]byte, error) {
+	p, err := i.Partition(readOnly)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := p.Get(key)
+	if !ok {
+		return nil, &os.PathError{Op: "get", Path: area(readOnly) + "/" + key, Err: os.ErrNotExist}
+	}
+	return v, nil
+}
+
+// Set sets key to value and writes the partition back.
+func (i *Image) Set(key string, value []byte, readOnly bool) error {
+	p, err := i.Partition(readOnly)
+	if err != nil {
+		return err
+	}
+	p.Set(key, value)
+	return i.WritePartition(p, readOnly)
+}
+
+// Delete deletes key and writes the partition back. A missing key is an
+// os.ErrNotExist error.
+func (i *Image) Delete(key string, readOnly bool) error {
+	p, err := i.Partition(readOnly)
+	if err != nil {
+		return err
+	}
+	if !p.Delete(key) {
+		return &os.PathError{Op: "delete", Path: area(readOnly) + "/" + key, Err: os.ErrNotExist}
+	}
+	return i.WritePartition(p, readOnly)
+}
+
+// GetAll returns all the variables of the partition.
+func (i *Image) GetAll(readOnly bool) (map[string][]byte, error) {
+	p, err := i.Partition(readOnly)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]byte, len(p.Vars))
+	for _, v := range p.Vars {
+		m[v.Key] = v.Value
+	}
+	return m, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vpd
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/flash/fmap"
+)
+
+// image is an in-memory flash image.
+type image struct {
+	b      []byte
+	erased int
+}
+
+func (i *image) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, i.b[off:]), nil
+}
+
+func (i *image) WriteAt(p []byte, off int64) (int, error) {
+	return copy(i.b[off:], p), nil
+}
+
+func (i *image) EraseAt(n int64, off int64) (int64, error) {
+	i.erased++
+	copy(i.b[off:off+n], bytes.Repeat([]byte{0xff}, int(n)))
+	return n, nil
+}
+
+// testImage returns a 1MiB image with an FMAP, an RO_VPD with a serial
+// number and an erased RW_VPD.
+func testImage(t *testing.T) *image {
+	t.Helper()
+	img := &image{b: bytes.Repeat([]byte{0xff}, 1<<20)}
+	m := &fmap.FMap{
+		VerMajor: 1,
+		Size:     1 << 20,
+		Name:     "FLASH",
+		Areas: []fmap.Area{
+			{Offset: 0, Size: 0x1000, Name: "FMAP"},
+			{Offset: 0x1000, Size: 0x4000, Name: ROArea},
+			{Offset: 0x5000, Size: 0x2000, Name: RWArea},
+		},
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(img.b, b)
+	ro, err := (&Partition{Vars: []Var{{"serial_number", []byte("1234")}}}).Encode(0x4000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(img.b[0x1000:], ro)
+	return img
+}
+
+func TestImage(t *testing.T) {
+	img := testImage(t)
+	i, err := NewImage(img, int64(len(img.b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reader{Image: i}
+
+	if v, err := r.Get("serial_number", true); err != nil || string(v) != "1234" {
+		t.Errorf(`Get("serial_number", true) = %q, %v, want "1234", nil`, v, err)
+	}
+	if _, err := r.Get("Boot0001", false); !os.IsNotExist(err) {
+		t.Errorf(`Get("Boot0001", false) = %v, want a not exist error`, err)
+	}
+
+	for _, kv := range []string{"Boot0001", "Boot0002"} {
+		if err := r.Set(kv, []byte(kv+" data"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if img.erased != 2 {
+		t.Errorf("erased %d times, want 2", img.erased)
+	}
+	if img.b[0x1000+vpd20Offset] != infoMagic[0] || img.b[0x7000] != 0xff {
+		t.Errorf("Set wrote outside of RW_VPD")
+	}
+	if err := r.Delete("Boot0001", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete("Boot0001", false); !os.IsNotExist(err) {
+		t.Errorf(`Delete("Boot0001", false) twice = %v, want a not exist error`, err)
+	}
+
+	all, err := r.GetAll(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]byte{"Boot0002": []byte("Boot0002 data")}; !reflect.DeepEqual(all, want) {
+		t.Errorf("GetAll(false) = %q, want %q", all, want)
+	}
+
+	// A fresh Image reads what was written.
+	i, err = NewImage(bytes.NewReader(img.b), int64(len(img.b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := i.Get("Boot0002", false); err != nil || string(v) != "Boot0002 data" {
+		t.Errorf(`Get("Boot0002", false) = %q, %v, want "Boot0002 data", nil`, v, err)
+	}
+	if err := i.Set("a", nil, false); err == nil {
+		t.Errorf("Set on a read-only reader succeeded")
+	}
+}
+
+func TestNewImageNoFMAP(t *testing.T) {
+	b := make([]byte, 0x10000)
+	if _, err := NewImage(bytes.NewReader(b), int64(len(b))); err == nil {
+		t.Errorf("NewImage without an FMAP succeeded")
+	}
+}
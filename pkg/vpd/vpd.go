@@ -50,12 +50,19 @@ func (r *Reader) getBaseDir(readOnly bool) string {
 // Reader is a VPD reader object.
 type Reader struct {
 	VpdDir string
+
+	// Image, if set, is used instead of VpdDir to read and write the VPD
+	// directly in flash.
+	Image *Image
 }
 
 // Get reads a VPD variable by name and returns its value as a sequence of
 // bytes. The `readOnly` flag specifies whether the variable is read-only or
 // read-write.
 func (r *Reader) Get(key string, readOnly bool) ([]byte, error) {
+	if r.Image != nil {
+		return r.Image.Get(key, readOnly)
+	}
 	buf, err := os.ReadFile(path.Join(r.getBaseDir(readOnly), key))
 	if err != nil {
 		return []byte{}, err
@@ -66,10 +73,12 @@ func (r *Reader) Get(key string, readOnly bool) ([]byte, error) {
 // Set sets a VPD variable with `key` as name and `value` as its byte-stream
 // value. The `readOnly` flag specifies whether the variable is read-only or
 // read-write.
-// NOTE Unfortunately Set doesn't currently work, because the sysfs interface
-// does not support writing. To write, this library needs a backend able to
-// write to flash chips, like the command line tool flashrom or flashtools.
+// NOTE Unfortunately Set doesn't currently work without an Image, because the
+// sysfs interface does not support writing.
 func (r *Reader) Set(key string, value []byte, readOnly bool) error {
+	if r.Image != nil {
+		return r.Image.Set(key, value, readOnly)
+	}
 	// NOTE this is not implemented yet in the kernel interface, and will always
 	// return a permission denied error
 	return os.WriteFile(path.Join(r.getBaseDir(readOnly), key), value, 0o644)
@@ -79,6 +88,9 @@ func (r *Reader) Set(key string, value []byte, readOnly bool) error {
 // name:value couple. The `readOnly` flag specifies whether the variable is
 // read-only or read-write.
 func (r *Reader) GetAll(readOnly bool) (map[string][]byte, error) {
+	if r.Image != nil {
+		return r.Image.GetAll(readOnly)
+	}
 	vpdMap := make(map[string][]byte)
 	baseDir := r.getBaseDir(readOnly)
 	err := filepath.Walk(baseDir, func(fpath string, info os.FileInfo, _ error) error {
@@ -96,3 +108,11 @@ func (r *Reader) GetAll(readOnly bool) (map[string][]byte, error) {
 	})
 	return vpdMap, err
 }
+
+// Delete deletes a VPD variable. Like Set, it only works with an Image.
+func (r *Reader) Delete(key string, readOnly bool) error {
+	if r.Image != nil {
+		return r.Image.Delete(key, readOnly)
+	}
+	return os.Remove(path.Join(r.getBaseDir(readOnly), key))
+}
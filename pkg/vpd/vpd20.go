@@ -0,0 +1,233 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vpd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Entry types of the Google VPD 2.0 format.
+const (
+	typeTerminator         = 0x00
+	typeString             = 0x01
+	typeInfo               = 0xfe
+	typeImplicitTerminator = 0xff
+)
+
+// infoMagic starts the info header, which is itself an entry of typeInfo
+// whose value is the size of the entries that follow.
+const infoMagic = "\xfe\x09\x01gVpdInfo\x04"
+
+// infoSize is the size of the info header.
+const infoSize = len(infoMagic) + 4
+
+// vpd20Offset is where coreboot looks for the info header. The SMBIOS entry
+// point and table that older readers want come before it.
+const vpd20Offset = 0x600
+
+// epsSize is the size of the SMBIOS entry point.
+const epsSize = 0x1f
+
+// ErrNoVPD is returned for a partition that has no VPD.
+var ErrNoVPD = errors.New("no VPD 2.0 found")
+
+// Var is a VPD variable.
+type Var struct {
+	Key   string
+	Value []byte
+}
+
+// Partition is the contents of an RO_VPD or RW_VPD partition in the Google
+// VPD 2.0 format.
+type Partition struct {
+	// Vars are the variables in the order stored.
+	Vars []Var
+
+	// atStart is whether the info header is at the start of the
+	// partition, without the SMBIOS entry point before it.
+	atStart bool
+}
+
+// decodeLen decodes a length of 7 bits per byte, most significant first,
+// where all but the last byte have the top bit set.
+func decodeLen(b []byte) (int, int, error) {
+	var n int
+	for i, c := range b {
+		if i >= 4 {
+			break
+		}
+		n = n<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			return n, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("vpd: bad length encoding")
+}
+
+// encodeLen encodes a length for decodeLen.
+func encodeLen(n int) []byte {
+	b := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		b = append([]byte{byte(n&0x7f) | 0x80}, b...)
+	}
+	return b
+}
+
+// Decode decodes a VPD partition. An erased partition has no variables.
+func Decode(b []byte) (*Partition, error) {
+	off := vpd20Offset
+	if len(b) < vpd20Offset+infoSize {
+		off = 0
+	}
+	hasInfo := func(off int) bool {
+		return len(b) >= off+infoSize && string(b[off:off+len(infoMagic)]) == infoMagic
+	}
+	p := &Partition{atStart: off == 0}
+	switch {
+	case hasInfo(off):
+	case hasInfo(0):
+		off, p.atStart = 0, true
+	case len(b) > off && (b[off] == typeImplicitTerminator || b[off] == typeTerminator):
+		return p, nil
+	default:
+		return nil, ErrNoVPD
+	}
+	start := off + infoSize
+	size := int(binary.LittleEndian.Uint32(b[off+len(infoMagic):]))
+	if size > len(b)-start {
+		return nil, fmt.Errorf("vpd: %d bytes of entries do not fit in %d", size, len(b)-start)
+	}
+	data := b[start : start+size]
+	for i := 0; i < len(data); {
+		typ := data[i]
+		if typ == typeTerminator || typ == typeImplicitTerminator {
+			break
+		}
+		i++
+		var fields [2][]byte
+		for f := range fields {
+			n, l, err := decodeLen(data[i:])
+			if err != nil {
+				return nil, err
+			}
+			i += l
+			if n > len(data)-i {
+				return nil, fmt.Errorf("vpd: entry of %d bytes at %d is beyond the end", n, start+i)
+			}
+			fields[f] = data[i : i+n]
+			i += n
+		}
+		switch typ {
+		case typeString:
+			p.Vars = append(p.Vars, Var{Key: string(fields[0]), Value: append([]byte{}, fields[1]...)})
+		case typeInfo:
+		default:
+			return nil, fmt.Errorf("vpd: unknown entry type %#x at %d", typ, start+i)
+		}
+	}
+	return p, nil
+}
+
+// Get returns the value of key.
+func (p *Partition) Get(key string) ([]byte, bool) {
+	for _, v := range p.Vars {
+		if v.Key == key {
+			return v.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set sets key to value. A new variable is added at the end.
+func (p *Partition) Set(key string, value []byte) {
+	for i := range p.Vars {
+		if p.Vars[i].Key == key {
+			p.Vars[i].Value = value
+			return
+		}
+	}
+	p.Vars = append(p.Vars, Var{Key: key, Value: value})
+}
+
+// Delete deletes key and returns whether it was there.
+func (p *Partition) Delete(key string) bool {
+	for i := range p.Vars {
+		if p.Vars[i].Key == key {
+			p.Vars = append(p.Vars[:i], p.Vars[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// checksum returns the byte that makes the sum of b zero.
+func checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return -sum
+}
+
+// eps returns the SMBIOS entry point and the type 241 table that points
+// to the VPD at vpd20Offset, as the vpd tool writes them.
+func eps(size int) []byte {
+	// The type 241 binary blob pointer, its strings, and the end of table
+	// structure.
+	var t bytes.Buffer
+	t.Write([]byte{241, 0x28, 0, 0})
+	t.Write([]byte{1, 0, 1, 2, 2, 0, 0})
+	t.Write(make([]byte, 5+16))
+	binary.Write(&t, binary.LittleEndian, uint32(vpd20Offset))
+	binary.Write(&t, binary.LittleEndian, uint32(size))
+	t.WriteString("Google\x00VPD 2.0\x00\x00")
+	t.Write([]byte{127, 4, 1, 0, 0, 0})
+
+	e := make([]byte, epsSize+1)
+	copy(e, "_SM_")
+	e[5] = epsSize
+	e[6], e[7] = 2, 6
+	binary.LittleEndian.PutUint16(e[8:], uint16(t.Len()))
+	copy(e[0x10:], "_DMI_")
+	binary.LittleEndian.PutUint16(e[0x16:], uint16(t.Len()))
+	binary.LittleEndian.PutUint32(e[0x18:], uint32(len(e)))
+	binary.LittleEndian.PutUint16(e[0x1c:], 2)
+	e[0x1e] = 0x26
+	e[0x15] = checksum(e[0x10:epsSize])
+	e[4] = checksum(e[:epsSize])
+	return append(e, t.Bytes()...)
+}
+
+// Encode encodes the partition into size bytes. Unused bytes are 0xff, as
+// in erased flash.
+func (p *Partition) Encode(size int) ([]byte, error) {
+	var data bytes.Buffer
+	for _, v := range p.Vars {
+		data.WriteByte(typeString)
+		data.Write(encodeLen(len(v.Key)))
+		data.WriteString(v.Key)
+		data.Write(encodeLen(len(v.Value)))
+		data.Write(v.Value)
+	}
+
+	b := bytes.Repeat([]byte{0xff}, size)
+	off := vpd20Offset
+	if p.atStart || size < vpd20Offset+infoSize {
+		off = 0
+	}
+	if off+infoSize+data.Len() > size {
+		return nil, fmt.Errorf("vpd: %d bytes of variables do not fit in %d", data.Len(), size-off-infoSize)
+	}
+	if off == vpd20Offset {
+		copy(b, eps(data.Len()))
+	}
+	copy(b[off:], infoMagic)
+	binary.LittleEndian.PutUint32(b[off+len(infoMagic):], uint32(data.Len()))
+	copy(b[off+infoSize:], data.Bytes())
+	return b, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vpd
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLen(t *testing.T) {
+	for _, tt := range []struct {
+		n int
+		b []byte
+	}{
+		{0, []byte{0}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x81, 0x00}},
+		{0x3fff, []byte{0xff, 0x7f}},
+		{0x12345, []byte{0x84, 0xc6, 0x45}},
+	} {
+		if b := encodeLen(tt.n); !bytes.Equal(b, tt.b) {
+			t.Errorf("encodeLen(%#x) = %#x, want %#x", tt.n, b, tt.b)
+		}
+		if n, l, err := decodeLen(tt.b); err != nil || n != tt.n || l != len(tt.b) {
+			t.Errorf("decodeLen(%#x) = %#x, %d, %v, want %#x, %d, nil", tt.b, n, l, err, tt.n, len(tt.b))
+		}
+	}
+	if _, _, err := decodeLen([]byte{0x80, 0x80}); err == nil {
+		t.Errorf("decodeLen of an unterminated length succeeded")
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	p := &Partition{}
+	p.Set("serial_number", []byte("1234"))
+	p.Set("Boot0001", bytes.Repeat([]byte{'x'}, 300))
+	p.Set("empty", nil)
+	p.Set("serial_number", []byte("5678"))
+
+	for _, size := range []int{0x4000, 0x400} {
+		b, err := p.Encode(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != size || b[size-1] != 0xff {
+			t.Errorf("Encode(%#x) = %#x bytes ending with %#x, want %#x bytes of 0xff at the end", size, len(b), b[len(b)-1], size)
+		}
+		q, err := Decode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(q.Vars) != 3 {
+			t.Fatalf("Decode(Encode(%#x)) = %+v, want 3 variables", size, q.Vars)
+		}
+		for _, v := range p.Vars {
+			if got, ok := q.Get(v.Key); !ok || !bytes.Equal(got, v.Value) {
+				t.Errorf("Get(%q) = %q, %v, want %q, true", v.Key, got, ok, v.Value)
+			}
+		}
+		if q.atStart != (size < vpd20Offset) {
+			t.Errorf("Decode of %#x bytes found the info header at the start = %v", size, q.atStart)
+		}
+	}
+
+	if !p.Delete("empty") || p.Delete("empty") {
+		t.Errorf("Delete(empty) twice did not delete it once")
+	}
+	if _, err := p.Encode(0x100); err == nil || !strings.Contains(err.Error(), "do not fit") {
+		t.Errorf("Encode(0x100) = %v, want it not to fit", err)
+	}
+}
+
+func TestEPS(t *testing.T) {
+	b, err := (&Partition{Vars: []Var{{"a", []byte("b")}}}).Encode(0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:4]) != "_SM_" || string(b[0x10:0x15]) != "_DMI_" {
+		t.Errorf("entry point = %q, want _SM_ and _DMI_ anchors", b[:0x15])
+	}
+	if c := checksum(b[:epsSize]); c != 0 {
+		t.Errorf("entry point checksum is off by %#x", c)
+	}
+	if c := checksum(b[0x10:epsSize]); c != 0 {
+		t.Errorf("intermediate checksum is off by %#x", c)
+	}
+	if b[0x20] != 241 {
+		t.Errorf("table type = %d, want 241", b[0x20])
+	}
+}
+
+func TestDecode(t *testing.T) {
+	erased := bytes.Repeat([]byte{0xff}, 0x1000)
+	if p, err := Decode(erased); err != nil || len(p.Vars) != 0 {
+		t.Errorf("Decode(erased) = %+v, %v, want no variables", p, err)
+	}
+
+	// The vpd tool can also write the info header at the start.
+	b := append([]byte(infoMagic), 5, 0, 0, 0, typeString, 1, 'k', 1, 'v')
+	b = append(b, erased...)
+	p, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Var{{"k", []byte("v")}}; !reflect.DeepEqual(p.Vars, want) || !p.atStart {
+		t.Errorf("Decode = %+v, want %+v at the start", p, want)
+	}
+
+	if _, err := Decode(make([]byte, 0x1000)[:0x601]); err != nil {
+		t.Errorf("Decode(zeros) = %v, want no variables", err)
+	}
+	garbage := bytes.Repeat([]byte{0x55}, 0x1000)
+	if _, err := Decode(garbage); !errors.Is(err, ErrNoVPD) {
+		t.Errorf("Decode(garbage) = %v, want %v", err, ErrNoVPD)
+	}
+	bad := append([]byte(infoMagic), 4, 0, 0, 0, 0x42, 0, 0, 0)
+	if _, err := Decode(bad); err == nil || !strings.Contains(err.Error(), "unknown entry type") {
+		t.Errorf("Decode(bad) = %v, want an unknown entry type", err)
+	}
+	long := append([]byte(infoMagic), 4, 0, 0, 0, typeString, 9, 0, 0)
+	if _, err := Decode(long); err == nil || !strings.Contains(err.Error(), "beyond the end") {
+		t.Errorf("Decode(long) = %v, want an entry beyond the end", err)
+	}
+}
@@ -131,6 +131,7 @@ var templates = map[string][]string{
 		"github.com/u-root/u-root/cmds/core/comm",
 		"github.com/u-root/u-root/cmds/core/cp",
 		"github.com/u-root/u-root/cmds/core/cpio",
+		"github.com/u-root/u-root/cmds/core/curl",
 		"github.com/u-root/u-root/cmds/core/date",
 		"github.com/u-root/u-root/cmds/core/dd",
 		"github.com/u-root/u-root/cmds/core/df",
@@ -130,8 +130,18 @@ func addBootEntry(cfg systembooter.Booter, vpdDir string) error {
 	if err != nil {
 		return err
 	}
+	if imagePath != "" {
+		return withImage(func(r *vpd.Reader) error {
+			return addBootEntryTo(r, data)
+		})
+	}
 	vpdReader := vpd.NewReader()
 	vpdReader.VpdDir = vpdDir
+	return addBootEntryTo(vpdReader, data)
+}
+
+// addBootEntryTo sets the first free BootXXXX variable to data.
+func addBootEntryTo(vpdReader *vpd.Reader, data []byte) error {
 	for i := 1; i < vpd.MaxBootEntry; i++ {
 		key := fmt.Sprintf("Boot%04d", i)
 		if _, err := vpdReader.Get(key, false); err != nil {
@@ -148,9 +158,19 @@ func addBootEntry(cfg systembooter.Booter, vpdDir string) error {
 }
 
 func set(key string, value string) error {
+	if imagePath != "" {
+		return withImage(func(r *vpd.Reader) error {
+			return r.Set(key, []byte(value), false)
+		})
+	}
 	return vpd.FlashromRWVpdSet(key, []byte(value), false)
 }
 
 func delete(key string) error {
+	if imagePath != "" {
+		return withImage(func(r *vpd.Reader) error {
+			return r.Delete(key, false)
+		})
+	}
 	return vpd.FlashromRWVpdSet(key, []byte("dummy"), true)
 }
@@ -5,13 +5,21 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+
+	"github.com/u-root/u-root/pkg/vpd"
 )
 
+// imagePath is a flash image, or a flash device like /dev/mtd0, to read and
+// write the VPD in directly, without flashrom.
+var imagePath string
+
 func getUsage(progname string) string {
 	return fmt.Sprintf(`Usage:
-%s add [netboot [dhcpv6|dhcpv4] [MAC] | localboot [grub|path [Device GUID] [Kernel Path]]]
+%s [-image FILE] add [netboot [dhcpv6|dhcpv4] [MAC] | localboot [grub|path [Device GUID] [Kernel Path]]]
 %s get [variable name]
 %s set [variable name] [variable value]
 %s delete [variable name]
@@ -36,6 +44,8 @@ Flags for localboot:
 
 Global flags:
 
+-image - flash image or device with an FMAP to read and write the VPD in,
+         instead of the kernel and flashrom. It goes before the action.
 -vpd-dir - VPD dir to use
 
 `, progname, progname, progname, progname)
@@ -49,7 +59,33 @@ func main() {
 	}
 }
 
+// withImage calls f with a Reader of the VPD in imagePath.
+func withImage(f func(r *vpd.Reader) error) error {
+	img, err := os.OpenFile(imagePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+	size, err := img.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	i, err := vpd.NewImage(img, size)
+	if err != nil {
+		return err
+	}
+	r := vpd.NewReader()
+	r.Image = i
+	return f(r)
+}
+
 func cli(args []string) error {
+	flg := flag.NewFlagSet("vpdbootmanager", flag.ContinueOnError)
+	flg.StringVar(&imagePath, "image", "", "flash image or device to use instead of flashrom")
+	if err := flg.Parse(args); err != nil {
+		return err
+	}
+	args = flg.Args()
 	if len(args) < 1 {
 		return fmt.Errorf("you need to provide action")
 	}
@@ -62,7 +98,13 @@ func cli(args []string) error {
 			varname = args[1]
 		}
 		getter := NewGetter()
-		return getter.Print(varname)
+		if imagePath == "" {
+			return getter.Print(varname)
+		}
+		return withImage(func(r *vpd.Reader) error {
+			getter.R = r
+			return getter.Print(varname)
+		})
 	case "set":
 		if len(args) == 3 {
 			err := set(args[1], args[2])
@@ -5,12 +5,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
 	"github.com/u-root/u-root/pkg/boot/systembooter"
+	"github.com/u-root/u-root/pkg/flash/fmap"
+	"github.com/u-root/u-root/pkg/vpd"
 )
 
 func TestInvalidCommand(t *testing.T) {
@@ -89,3 +93,59 @@ func TestAddLocalbootEntryFull(t *testing.T) {
 		t.Errorf(`out.Method = %q, want "grub"`, out.Method)
 	}
 }
+
+func TestImage(t *testing.T) {
+	img := bytes.Repeat([]byte{0xff}, 0x10000)
+	m := &fmap.FMap{
+		VerMajor: 1,
+		Size:     uint32(len(img)),
+		Name:     "FLASH",
+		Areas: []fmap.Area{
+			{Offset: 0, Size: 0x1000, Name: "FMAP"},
+			{Offset: 0x1000, Size: 0x2000, Name: "RO_VPD"},
+			{Offset: 0x3000, Size: 0x2000, Name: "RW_VPD"},
+		},
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(img, b)
+	file := filepath.Join(t.TempDir(), "image.rom")
+	if err := os.WriteFile(file, img, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"-image", file, "add", "localboot", "grub"},
+		{"-image", file, "set", "systemboot_log_level", "6"},
+		{"-image", file, "set", "firmware_version", "1.2.3"},
+		{"-image", file, "delete", "firmware_version"},
+		{"-image", file, "get"},
+	} {
+		if err := cli(args); err != nil {
+			t.Fatalf("cli(%v) = %v, want nil", args, err)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	i, err := vpd.NewImage(f, int64(len(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := i.GetAll(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out systembooter.LocalBooter
+	if err := json.Unmarshal(all["Boot0001"], &out); err != nil || out.Method != "grub" {
+		t.Errorf("Boot0001 = %q, %v, want a grub localboot entry", all["Boot0001"], err)
+	}
+	if string(all["systemboot_log_level"]) != "6" || len(all) != 2 {
+		t.Errorf("RW_VPD = %q, want Boot0001 and systemboot_log_level=6", all)
+	}
+}